@@ -16,6 +16,9 @@ interface Message {
   id: number;
   role: string;
   content: string;
+  // The content with its terminal colors, when the server runs with
+  // --message-colors html.
+  html?: string;
 }
 
 // Draft messages are used to optmistically update the UI
@@ -28,6 +31,7 @@ interface MessageUpdateEvent {
   id: number;
   role: string;
   message: string;
+  message_html?: string;
   time: string;
 }
 
@@ -42,6 +46,21 @@ interface ErrorEventData {
   time: string;
 }
 
+export interface PromptOption {
+  key: string;
+  label: string;
+  selected: boolean;
+}
+
+export interface PendingPrompt {
+  question: string;
+  options: PromptOption[];
+}
+
+interface PendingPromptEvent {
+  prompt?: PendingPrompt;
+}
+
 interface APIErrorDetail {
   location: string;
   message: string;
@@ -100,6 +119,8 @@ interface ChatContextValue {
   sendMessage: (message: string, type?: MessageType) => void;
   uploadFiles: (formData: FormData) => Promise<FileUploadResponse>;
   agentType: AgentType;
+  pendingPrompt: PendingPrompt | null;
+  replyToPrompt: (option: string) => void;
 }
 
 const ChatContext = createContext<ChatContextValue | undefined>(undefined);
@@ -144,6 +165,7 @@ export function ChatProvider({ children }: PropsWithChildren) {
   const [loading, setLoading] = useState<boolean>(false);
   const [serverStatus, setServerStatus] = useState<ServerStatus>("unknown");
   const [agentType, setAgentType] = useState<AgentType>("custom");
+  const [pendingPrompt, setPendingPrompt] = useState<PendingPrompt | null>(null);
   const eventSourceRef = useRef<EventSource | null>(null);
   const agentAPIUrl = useAgentAPIUrl();
 
@@ -157,6 +179,7 @@ export function ChatProvider({ children }: PropsWithChildren) {
 
       // Reset messages when establishing a new connection
       setMessages([]);
+      setPendingPrompt(null);
 
       if (!agentAPIUrl) {
         console.warn(
@@ -189,6 +212,7 @@ export function ChatProvider({ children }: PropsWithChildren) {
             updatedMessages[existingIndex] = {
               role: data.role,
               content: data.message,
+              html: data.message_html,
               id: data.id,
             };
             return updatedMessages;
@@ -199,6 +223,7 @@ export function ChatProvider({ children }: PropsWithChildren) {
               {
                 role: data.role,
                 content: data.message,
+                html: data.message_html,
                 id: data.id,
               },
             ];
@@ -221,6 +246,12 @@ export function ChatProvider({ children }: PropsWithChildren) {
         setAgentType(data.agent_type === "" ? "unknown" : data.agent_type as AgentType);
       });
 
+      // Handle prompts the agent is waiting on, such as permission dialogs
+      eventSource.addEventListener("pending_prompt", (event) => {
+        const data: PendingPromptEvent = JSON.parse(event.data);
+        setPendingPrompt(data.prompt ?? null);
+      });
+
       // Handle agent error events
       eventSource.addEventListener("agent_error", (event) => {
         const messageEvent = event as MessageEvent;
@@ -336,6 +367,34 @@ export function ChatProvider({ children }: PropsWithChildren) {
     }
   };
 
+  // Answer the prompt the agent is waiting on
+  const replyToPrompt = async (option: string) => {
+    try {
+      const response = await fetch(`${agentAPIUrl}/pending-prompt/reply`, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify({ option }),
+      });
+
+      if (!response.ok) {
+        const errorData = await response.json() as APIErrorModel;
+        console.error("Failed to reply to prompt:", errorData);
+        toast.error(`Failed to reply to prompt`, {
+          description: errorData.detail,
+        });
+      }
+    } catch (error) {
+      console.error("Error replying to prompt:", error);
+      const message = getErrorMessage(error)
+
+      toast.error(`Error replying to prompt`, {
+        description: message,
+      });
+    }
+  };
+
   // Upload files to workspace
   const uploadFiles = async (formData: FormData): Promise<FileUploadResponse> => {
     let result: FileUploadResponse = {ok: true};
@@ -385,6 +444,8 @@ export function ChatProvider({ children }: PropsWithChildren) {
         serverStatus,
         uploadFiles,
         agentType,
+        pendingPrompt,
+        replyToPrompt,
       }}
     >
       {children}
@@ -3,13 +3,17 @@
 import {useChat} from "./chat-provider";
 import MessageInput from "./message-input";
 import MessageList from "./message-list";
+import PendingPrompt from "./pending-prompt";
 
 export function Chat() {
-  const {messages, loading, sendMessage, serverStatus} = useChat();
+  const {messages, loading, sendMessage, serverStatus, pendingPrompt, replyToPrompt} = useChat();
 
   return (
     <>
       <MessageList messages={messages}/>
+      {pendingPrompt && (
+        <PendingPrompt prompt={pendingPrompt} onReply={replyToPrompt}/>
+      )}
       <MessageInput
         onSendMessage={sendMessage}
         disabled={loading}
@@ -5,6 +5,7 @@ import React, {useLayoutEffect, useRef, useEffect, useCallback, useMemo, useStat
 interface Message {
   role: string;
   content: string;
+  html?: string;
   id: number;
 }
 
@@ -146,6 +147,9 @@ export default function MessageList({messages}: MessageListProps) {
               >
                 {message.role !== "user" && message.content === "" ? (
                   <LoadingDots />
+                ) : message.role !== "user" && message.html ? (
+                  // The server escapes the message and only adds styled spans.
+                  <span dangerouslySetInnerHTML={{__html: message.html}} />
                 ) : (
                   <ProcessedMessage
                     messageContent={message.content}
@@ -0,0 +1,35 @@
+"use client";
+
+import {PendingPrompt as Prompt} from "./chat-provider";
+import {Button} from "./ui/button";
+
+interface PendingPromptProps {
+  prompt: Prompt;
+  onReply: (option: string) => void;
+}
+
+// PendingPrompt shows the options of a prompt the agent is waiting on, such
+// as a permission dialog, as buttons.
+export default function PendingPrompt({prompt, onReply}: PendingPromptProps) {
+  return (
+    <div className="max-w-4xl mx-auto w-full px-4 pb-4">
+      <div className="rounded-lg border p-4 shadow-sm">
+        {prompt.question && (
+          <p className="text-sm font-medium mb-3">{prompt.question}</p>
+        )}
+        <div className="flex flex-wrap gap-2">
+          {prompt.options.map((option) => (
+            <Button
+              key={option.key}
+              variant={option.selected ? "default" : "outline"}
+              size="sm"
+              onClick={() => onReply(option.key)}
+            >
+              {option.label}
+            </Button>
+          ))}
+        </div>
+      </div>
+    </div>
+  );
+}
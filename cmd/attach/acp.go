@@ -0,0 +1,323 @@
+package attach
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/coder/agentapi/lib/httpapi"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	sse "github.com/tmaxmax/go-sse"
+	"golang.org/x/xerrors"
+)
+
+// spinnerFrames are shown in turn while the agent is running.
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+const (
+	spinnerInterval = 100 * time.Millisecond
+	// maxToolInputLength is how much of a tool call's input is shown.
+	maxToolInputLength = 60
+)
+
+// acpModel shows the conversation of an ACP agent, which has no terminal
+// screen to mirror, above an input editor.
+type acpModel struct {
+	messages []httpapi.MessageUpdateBody
+	// toolCalls are shown after the message that was last when they
+	// started.
+	toolCalls []acpToolCall
+	status    httpapi.AgentStatus
+	// spinnerFrame is the current frame of the spinner, and spinning
+	// whether a tick is scheduled to advance it.
+	spinnerFrame int
+	spinning     bool
+	// pending holds the inputs sent but not yet in the conversation. They
+	// are echoed locally until the server reports the user message, so the
+	// input doesn't disappear while the agent starts.
+	pending []string
+	editor  *lineEditor
+	err     string
+	send    func(input string) tea.Cmd
+	focused *atomic.Bool
+}
+
+type acpToolCall struct {
+	httpapi.ToolCallBody
+	afterMessage int
+}
+
+type eventMsg struct {
+	event httpapi.Event
+}
+
+type spinnerTickMsg struct{}
+
+// sentMsg reports the result of sending an input.
+type sentMsg struct {
+	input string
+	err   error
+}
+
+func (m acpModel) Init() tea.Cmd {
+	return nil
+}
+
+//lint:ignore U1000 The Update function is used by the Bubble Tea framework
+func (m acpModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case eventMsg:
+		switch body := msg.event.Payload.(type) {
+		case httpapi.MessageUpdateBody:
+			m.updateMessage(body)
+		case httpapi.ToolCallBody:
+			m.updateToolCall(body)
+		case httpapi.StatusChangeBody:
+			m.status = body.Status
+			if m.status == httpapi.AgentStatusRunning && !m.spinning {
+				m.spinning = true
+				return m, tickSpinner()
+			}
+		}
+	case spinnerTickMsg:
+		if m.status != httpapi.AgentStatusRunning {
+			m.spinning = false
+			return m, nil
+		}
+		m.spinnerFrame = (m.spinnerFrame + 1) % len(spinnerFrames)
+		return m, tickSpinner()
+	case sentMsg:
+		if msg.err != nil {
+			m.err = msg.err.Error()
+			m.dropPending(msg.input)
+		}
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			return m, tea.Quit
+		}
+		if input, ok := m.editor.update(msg); ok {
+			m.err = ""
+			m.pending = append(slices.Clip(m.pending), input)
+			return m, m.send(input)
+		}
+	case tea.FocusMsg:
+		m.focused.Store(true)
+	case tea.BlurMsg:
+		m.focused.Store(false)
+	case finishMsg:
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func (m *acpModel) updateMessage(body httpapi.MessageUpdateBody) {
+	i, found := slices.BinarySearchFunc(m.messages, body.Id, func(msg httpapi.MessageUpdateBody, id int) int {
+		return msg.Id - id
+	})
+	if found {
+		m.messages[i] = body
+		return
+	}
+	m.messages = slices.Insert(slices.Clip(m.messages), i, body)
+	if body.Role == st.ConversationRoleUser {
+		m.dropPending(body.Message)
+	}
+}
+
+func (m *acpModel) updateToolCall(body httpapi.ToolCallBody) {
+	i := slices.IndexFunc(m.toolCalls, func(c acpToolCall) bool { return c.Id == body.Id })
+	if i >= 0 {
+		m.toolCalls = slices.Clone(m.toolCalls)
+		m.toolCalls[i].ToolCallBody = body
+		return
+	}
+	afterMessage := -1
+	if len(m.messages) > 0 {
+		afterMessage = m.messages[len(m.messages)-1].Id
+	}
+	m.toolCalls = append(slices.Clip(m.toolCalls), acpToolCall{ToolCallBody: body, afterMessage: afterMessage})
+}
+
+func tickSpinner() tea.Cmd {
+	return tea.Tick(spinnerInterval, func(time.Time) tea.Msg { return spinnerTickMsg{} })
+}
+
+// dropPending removes the local echo of an input.
+func (m *acpModel) dropPending(input string) {
+	i := slices.IndexFunc(m.pending, func(p string) bool {
+		return strings.TrimSpace(p) == strings.TrimSpace(input)
+	})
+	if i >= 0 {
+		m.pending = slices.Delete(slices.Clone(m.pending), i, i+1)
+	}
+}
+
+func (m acpModel) View() string {
+	var sb strings.Builder
+	m.writeToolCalls(&sb, -1)
+	for _, msg := range m.messages {
+		if msg.Role == st.ConversationRoleUser {
+			sb.WriteString(quoteInput(msg.Message) + "\n\n")
+		} else if text := strings.TrimSpace(msg.Message); text != "" {
+			sb.WriteString(text + "\n\n")
+		}
+		m.writeToolCalls(&sb, msg.Id)
+	}
+	for _, input := range m.pending {
+		// Faint until the server has it.
+		sb.WriteString("\x1b[2m" + quoteInput(input) + "\x1b[0m\n\n")
+	}
+	if m.err != "" {
+		sb.WriteString("\x1b[31m" + m.err + "\x1b[0m\n")
+	}
+	if m.status == httpapi.AgentStatusRunning {
+		sb.WriteString(spinnerFrames[m.spinnerFrame] + " Working…\n")
+	}
+	sb.WriteString(m.editor.view("> "))
+	return sb.String()
+}
+
+// writeToolCalls renders the tool calls started after message id, one per
+// line with their status.
+func (m acpModel) writeToolCalls(sb *strings.Builder, messageId int) {
+	wrote := false
+	for _, call := range m.toolCalls {
+		if call.afterMessage != messageId {
+			continue
+		}
+		mark := "…"
+		switch call.Status {
+		case st.ToolCallStatusCompleted:
+			mark = "\x1b[32m✓\x1b[0m"
+		case st.ToolCallStatusFailed:
+			mark = "\x1b[31m✗\x1b[0m"
+		}
+		input := strings.Join(strings.Fields(call.Input), " ")
+		if runes := []rune(input); len(runes) > maxToolInputLength {
+			input = string(runes[:maxToolInputLength-1]) + "…"
+		}
+		sb.WriteString("\x1b[2m⏺ " + call.Name + "\x1b[0m " + input + " " + mark + "\n")
+		wrote = true
+	}
+	if wrote {
+		sb.WriteString("\n")
+	}
+}
+
+// quoteInput renders a user message the way the input editor shows it.
+func quoteInput(input string) string {
+	return "> " + strings.ReplaceAll(strings.TrimSpace(input), "\n", "\n  ")
+}
+
+// ReadEventsOverHTTP sends the events of the /events stream to ch. Message
+// updates, status changes and tool calls are decoded into their body type;
+// other payloads are left as raw JSON.
+func ReadEventsOverHTTP(ctx context.Context, url string, ch chan<- httpapi.Event) error {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("failed to do request: %w", err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	for ev, err := range sse.Read(res.Body, &sse.ReadConfig{
+		MaxEventSize: 256 * 1024,
+	}) {
+		if err != nil {
+			return xerrors.Errorf("failed to read sse: %w", err)
+		}
+		event := httpapi.Event{Type: httpapi.EventType(ev.Type), Payload: json.RawMessage(ev.Data)}
+		switch event.Type {
+		case httpapi.EventTypeMessageUpdate:
+			event.Payload, err = decodeEvent[httpapi.MessageUpdateBody](ev.Data)
+		case httpapi.EventTypeStatusChange:
+			event.Payload, err = decodeEvent[httpapi.StatusChangeBody](ev.Data)
+		case httpapi.EventTypeToolCall:
+			event.Payload, err = decodeEvent[httpapi.ToolCallBody](ev.Data)
+		}
+		if err != nil {
+			return xerrors.Errorf("failed to unmarshal %s event: %w", event.Type, err)
+		}
+		ch <- event
+	}
+	return nil
+}
+
+func decodeEvent[T any](data string) (any, error) {
+	var body T
+	err := json.Unmarshal([]byte(data), &body)
+	return body, err
+}
+
+func runAttachACP(remoteURL string, notify bool) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	focused := &atomic.Bool{}
+	focused.Store(true)
+	programOptions := []tea.ProgramOption{tea.WithAltScreen()}
+	if notify {
+		programOptions = append(programOptions, tea.WithReportFocus())
+		go notifyWhenStable(ctx, remoteURL, focused)
+	}
+	send := func(input string) tea.Cmd {
+		return func() tea.Msg {
+			return sentMsg{input: input, err: writeMessageOverHTTP(ctx, remoteURL+"/message", httpapi.MessageTypeUser, input)}
+		}
+	}
+	p := tea.NewProgram(acpModel{editor: &lineEditor{}, send: send, focused: focused}, programOptions...)
+
+	eventCh := make(chan httpapi.Event, 64)
+	readEventsErrCh := make(chan error, 1)
+	go func() {
+		defer close(readEventsErrCh)
+		if err := ReadEventsOverHTTP(ctx, remoteURL+"/events", eventCh); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			readEventsErrCh <- xerrors.Errorf("failed to read events: %w", err)
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-eventCh:
+				p.Send(eventMsg{event: event})
+			}
+		}
+	}()
+	pErrCh := make(chan error, 1)
+	go func() {
+		_, err := p.Run()
+		pErrCh <- err
+		close(pErrCh)
+	}()
+
+	var err error
+	select {
+	case err = <-readEventsErrCh:
+	case err = <-pErrCh:
+	}
+
+	p.Send(finishMsg{})
+	graceTimer := quartz.NewReal().NewTimer(1 * time.Second)
+	defer graceTimer.Stop()
+	select {
+	case <-pErrCh:
+	case <-graceTimer.C:
+	}
+
+	return err
+}
@@ -0,0 +1,90 @@
+package attach
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/coder/agentapi/lib/httpapi"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestACPModel(t *testing.T) {
+	var sent []string
+	var m tea.Model = acpModel{
+		editor: &lineEditor{},
+		send: func(input string) tea.Cmd {
+			sent = append(sent, input)
+			return nil
+		},
+		focused: &atomic.Bool{},
+	}
+	update := func(msg tea.Msg) {
+		t.Helper()
+		m, _ = m.Update(msg)
+	}
+	message := func(id int, role st.ConversationRole, text string) tea.Msg {
+		return eventMsg{event: httpapi.Event{Type: httpapi.EventTypeMessageUpdate, Payload: httpapi.MessageUpdateBody{Id: id, Role: role, Message: text}}}
+	}
+
+	update(message(0, st.ConversationRoleAgent, "Welcome"))
+	for _, r := range "Fix the bug" {
+		update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.Equal(t, []string{"Fix the bug"}, sent)
+	// The input is echoed until the server reports it.
+	assert.Equal(t, []string{"Fix the bug"}, m.(acpModel).pending)
+	assert.Contains(t, m.View(), "\x1b[2m> Fix the bug\x1b[0m")
+
+	update(message(1, st.ConversationRoleUser, "Fix the bug"))
+	update(message(2, st.ConversationRoleAgent, "Fixed"))
+	assert.Empty(t, m.(acpModel).pending)
+	assert.Equal(t, "Welcome\n\n> Fix the bug\n\nFixed\n\n> \x1b[7m \x1b[0m", m.View())
+
+	// Inputs the server rejected are no longer echoed.
+	for _, r := range "Again" {
+		update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+	update(tea.KeyMsg{Type: tea.KeyEnter})
+	update(sentMsg{input: "Again", err: errors.New("409 Conflict")})
+	assert.Empty(t, m.(acpModel).pending)
+	assert.Contains(t, m.View(), "409 Conflict")
+}
+
+func TestACPModel_StatusAndToolCalls(t *testing.T) {
+	var m tea.Model = acpModel{editor: &lineEditor{}, focused: &atomic.Bool{}}
+	update := func(payload any) tea.Cmd {
+		t.Helper()
+		var cmd tea.Cmd
+		m, cmd = m.Update(eventMsg{event: httpapi.Event{Payload: payload}})
+		return cmd
+	}
+
+	update(httpapi.MessageUpdateBody{Id: 0, Role: st.ConversationRoleUser, Message: "Fix the bug"})
+	require.NotNil(t, update(httpapi.StatusChangeBody{Status: httpapi.AgentStatusRunning}))
+	// The spinner is already ticking.
+	assert.Nil(t, update(httpapi.StatusChangeBody{Status: httpapi.AgentStatusRunning}))
+	update(httpapi.ToolCallBody{Id: "1", Name: "read", Input: `{"path": "main.go"}`, Status: st.ToolCallStatusStarted})
+	update(httpapi.ToolCallBody{Id: "2", Name: "edit", Input: `{"path": "main.go"}`, Status: st.ToolCallStatusStarted})
+	update(httpapi.ToolCallBody{Id: "1", Name: "read", Input: `{"path": "main.go"}`, Status: st.ToolCallStatusCompleted})
+	update(httpapi.MessageUpdateBody{Id: 1, Role: st.ConversationRoleAgent, Message: "Fixed"})
+	assert.Equal(t, "> Fix the bug\n\n"+
+		"\x1b[2m⏺ read\x1b[0m {\"path\": \"main.go\"} \x1b[32m✓\x1b[0m\n"+
+		"\x1b[2m⏺ edit\x1b[0m {\"path\": \"main.go\"} …\n\n"+
+		"Fixed\n\n"+
+		"⠋ Working…\n"+
+		"> \x1b[7m \x1b[0m", m.View())
+
+	m, _ = m.Update(spinnerTickMsg{})
+	assert.Contains(t, m.View(), "⠙ Working…")
+	update(httpapi.StatusChangeBody{Status: httpapi.AgentStatusStable})
+	assert.NotContains(t, m.View(), "Working…")
+	var cmd tea.Cmd
+	m, cmd = m.Update(spinnerTickMsg{})
+	assert.Nil(t, cmd)
+	assert.False(t, m.(acpModel).spinning)
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -37,6 +38,9 @@ func (c *ChannelWriter) Receive() ([]byte, bool) {
 
 type model struct {
 	screen string
+	// focused tracks whether the terminal has focus. It is only updated
+	// when focus reporting is enabled.
+	focused *atomic.Bool
 }
 
 func (m model) Init() tea.Cmd {
@@ -62,6 +66,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.String() == "ctrl+c" {
 			return m, tea.Quit
 		}
+	case tea.FocusMsg:
+		m.focused.Store(true)
+	case tea.BlurMsg:
+		m.focused.Store(false)
 	case finishMsg:
 		return m, tea.Quit
 	}
@@ -73,6 +81,9 @@ func (m model) View() string {
 	return m.screen
 }
 
+// ReadScreenOverHTTP sends the screen updates of GET /internal/screen to
+// ch. Diff-encoded updates (?encoding=diff) are applied to the previous
+// screen.
 func ReadScreenOverHTTP(ctx context.Context, url string, ch chan<- httpapi.ScreenUpdateBody) error {
 	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	req.Header.Set("Content-Type", "application/json")
@@ -85,6 +96,7 @@ func ReadScreenOverHTTP(ctx context.Context, url string, ch chan<- httpapi.Scree
 		_ = res.Body.Close()
 	}()
 
+	var screen httpapi.ScreenUpdateBody
 	for ev, err := range sse.Read(res.Body, &sse.ReadConfig{
 		// 256KB: screen can be big. The default terminal size is 80x1000,
 		// which can be over 80000 bytes.
@@ -93,9 +105,19 @@ func ReadScreenOverHTTP(ctx context.Context, url string, ch chan<- httpapi.Scree
 		if err != nil {
 			return xerrors.Errorf("failed to read sse: %w", err)
 		}
-		var screen httpapi.ScreenUpdateBody
-		if err := json.Unmarshal([]byte(ev.Data), &screen); err != nil {
-			return xerrors.Errorf("failed to unmarshal screen: %w", err)
+		switch ev.Type {
+		case "screen_diff":
+			var diff httpapi.ScreenDiffBody
+			if err := json.Unmarshal([]byte(ev.Data), &diff); err != nil {
+				return xerrors.Errorf("failed to unmarshal screen diff: %w", err)
+			}
+			if screen, err = diff.Apply(screen); err != nil {
+				return xerrors.Errorf("failed to apply screen diff: %w", err)
+			}
+		default:
+			if err := json.Unmarshal([]byte(ev.Data), &screen); err != nil {
+				return xerrors.Errorf("failed to unmarshal screen: %w", err)
+			}
 		}
 		ch <- screen
 	}
@@ -103,8 +125,15 @@ func ReadScreenOverHTTP(ctx context.Context, url string, ch chan<- httpapi.Scree
 }
 
 func WriteRawInputOverHTTP(ctx context.Context, url string, msg string) error {
+	if err := writeMessageOverHTTP(ctx, url, httpapi.MessageTypeRaw, msg); err != nil {
+		return xerrors.Errorf("failed to write raw input: %w", err)
+	}
+	return nil
+}
+
+func writeMessageOverHTTP(ctx context.Context, url string, messageType httpapi.MessageType, msg string) error {
 	messageRequest := httpapi.MessageRequestBody{
-		Type:    httpapi.MessageTypeRaw,
+		Type:    messageType,
 		Content: msg,
 	}
 	messageRequestBytes, err := json.Marshal(messageRequest)
@@ -122,37 +151,104 @@ func WriteRawInputOverHTTP(ctx context.Context, url string, msg string) error {
 		_ = res.Body.Close()
 	}()
 	if res.StatusCode != http.StatusOK {
-		return xerrors.Errorf("failed to write raw input: %w", errors.New(res.Status))
+		return errors.New(res.Status)
 	}
 
 	return nil
 }
 
-func checkACPMode(remoteURL string) (bool, error) {
+func checkTransport(remoteURL string) (httpapi.Transport, error) {
 	resp, err := http.Get(remoteURL + "/status")
 	if err != nil {
-		return false, xerrors.Errorf("failed to check server status: %w", err)
+		return "", xerrors.Errorf("failed to check server status: %w", err)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return false, xerrors.Errorf("unexpected %d response from server: %s", resp.StatusCode, resp.Status)
+		return "", xerrors.Errorf("unexpected %d response from server: %s", resp.StatusCode, resp.Status)
 	}
 
 	var status httpapi.StatusResponse
 	if err := json.NewDecoder(resp.Body).Decode(&status.Body); err != nil {
-		return false, xerrors.Errorf("failed to decode server status: %w", err)
+		return "", xerrors.Errorf("failed to decode server status: %w", err)
+	}
+
+	return status.Body.Transport, nil
+}
+
+// notifyWhenStable shows a desktop notification each time the agent
+// becomes stable while the terminal is unfocused. Agents waiting for
+// permission to run a tool are stable too.
+func notifyWhenStable(ctx context.Context, remoteURL string, focused *atomic.Bool) {
+	statusCh := make(chan httpapi.AgentStatus, 16)
+	go func() {
+		defer close(statusCh)
+		// Notifications are best effort, so errors don't end the session.
+		_ = ReadStatusOverHTTP(ctx, remoteURL+"/events", statusCh)
+	}()
+
+	var prev httpapi.AgentStatus
+	for status := range statusCh {
+		if prev == httpapi.AgentStatusRunning && status == httpapi.AgentStatusStable && !focused.Load() {
+			if err := sendDesktopNotification("agentapi", "The agent is waiting for input"); err != nil {
+				// Most likely no notification tool is installed.
+				return
+			}
+		}
+		prev = status
+	}
+}
+
+// takeOver takes over the agent's terminal with POST /takeover, so that API
+// clients don't type over the user, and returns a function releasing it.
+func takeOver(remoteURL string) (func(), error) {
+	body, err := json.Marshal(httpapi.TakeoverRequestBody{
+		By:     os.Getenv("USER"),
+		Reason: "agentapi attach --takeover",
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to marshal takeover request: %w", err)
+	}
+	res, err := http.Post(remoteURL+"/takeover", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to take over the terminal: %w", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("failed to take over the terminal: %s", res.Status)
 	}
 
-	return status.Body.Transport == httpapi.TransportACP, nil
+	return func() {
+		res, err := http.Post(remoteURL+"/release", "application/json", nil)
+		if err == nil {
+			_ = res.Body.Close()
+			if res.StatusCode != http.StatusNoContent {
+				err = errors.New(res.Status)
+			}
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "WARN: Failed to release the terminal, release it with POST /release: %s\n", err)
+		}
+	}, nil
 }
 
-func runAttach(remoteURL string) error {
-	// Check if server is running in ACP mode (attach not supported)
-	if isACP, err := checkACPMode(remoteURL); err != nil {
+func runAttach(remoteURL string, notify bool, maxRate int, takeover bool) error {
+	// Mirroring the agent's terminal requires the PTY transport. ACP agents
+	// have no terminal, so their conversation is shown instead.
+	if transport, err := checkTransport(remoteURL); err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "WARN: Unable to check server: %s", err.Error())
-	} else if isACP {
-		return xerrors.New("attach is not yet supported in ACP mode")
+	} else if transport == httpapi.TransportACP {
+		return runAttachACP(remoteURL, notify)
+	} else if transport != httpapi.TransportPTY && transport != httpapi.TransportMock {
+		return xerrors.Errorf("attach is not supported with the %s transport", transport)
+	}
+
+	if takeover {
+		release, err := takeOver(remoteURL)
+		if err != nil {
+			return err
+		}
+		defer release()
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -171,13 +267,21 @@ func runAttach(remoteURL string) error {
 		ch: make(chan []byte, 4096),
 	}
 	tee := io.TeeReader(os.Stdin, stdinWriter)
-	p := tea.NewProgram(model{}, tea.WithInput(tee), tea.WithAltScreen())
+	focused := &atomic.Bool{}
+	focused.Store(true)
+	programOptions := []tea.ProgramOption{tea.WithInput(tee), tea.WithAltScreen()}
+	if notify {
+		programOptions = append(programOptions, tea.WithReportFocus())
+		go notifyWhenStable(ctx, remoteURL, focused)
+	}
+	p := tea.NewProgram(model{focused: focused}, programOptions...)
 	screenCh := make(chan httpapi.ScreenUpdateBody, 64)
 
 	readScreenErrCh := make(chan error, 1)
 	go func() {
 		defer close(readScreenErrCh)
-		if err := ReadScreenOverHTTP(ctx, remoteURL+"/internal/screen", screenCh); err != nil {
+		screenURL := fmt.Sprintf("%s/internal/screen?encoding=diff&max_rate=%d", remoteURL, maxRate)
+		if err := ReadScreenOverHTTP(ctx, screenURL, screenCh); err != nil {
 			if errors.Is(err, context.Canceled) {
 				return
 			}
@@ -200,6 +304,13 @@ func runAttach(remoteURL string) error {
 				if input == "\x03" {
 					continue
 				}
+				// Focus reports are meant for us, not the agent.
+				if notify {
+					input = strings.NewReplacer(focusInSequence, "", focusOutSequence, "").Replace(input)
+					if input == "" {
+						continue
+					}
+				}
 				if err := WriteRawInputOverHTTP(ctx, remoteURL+"/message", input); err != nil {
 					writeRawInputErrCh <- xerrors.Errorf("failed to write raw input: %w", err)
 					return
@@ -248,7 +359,12 @@ func runAttach(remoteURL string) error {
 	return err
 }
 
-var remoteUrlArg string
+var (
+	remoteUrlArg string
+	notifyArg    bool
+	maxRateArg   int
+	takeoverArg  bool
+)
 
 var AttachCmd = &cobra.Command{
 	Use:   "attach",
@@ -264,7 +380,7 @@ var AttachCmd = &cobra.Command{
 			remoteUrl = "http://" + remoteUrl
 		}
 		remoteUrl = strings.TrimRight(remoteUrl, "/")
-		if err := runAttach(remoteUrl); err != nil {
+		if err := runAttach(remoteUrl, notifyArg, maxRateArg, takeoverArg); err != nil {
 			fmt.Fprintf(os.Stderr, "Attach failed: %+v\n", err)
 			os.Exit(1)
 		}
@@ -273,4 +389,7 @@ var AttachCmd = &cobra.Command{
 
 func init() {
 	AttachCmd.Flags().StringVarP(&remoteUrlArg, "url", "u", "localhost:3284", "URL of the agentapi server to attach to. May optionally include a protocol and a path.")
+	AttachCmd.Flags().BoolVar(&notifyArg, "notify", true, "Show a desktop notification when the agent becomes idle while the terminal is unfocused.")
+	AttachCmd.Flags().IntVar(&maxRateArg, "max-rate", 0, "Maximum screen updates per second, to keep the terminal responsive over slow connections. 0 uses the server's limit.")
+	AttachCmd.Flags().BoolVar(&takeoverArg, "takeover", false, "Take over the agent's terminal while attached, so that messages sent through the API are rejected until you detach.")
 }
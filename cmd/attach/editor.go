@@ -0,0 +1,147 @@
+package attach
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxEditorHistory is the number of submitted inputs the editor recalls.
+const maxEditorHistory = 100
+
+// lineEditor is a readline-style input editor: the cursor can be moved
+// with the arrow keys, Home and End, previous inputs are recalled with the
+// up and down arrows, and Alt+Enter inserts a newline to compose messages
+// over several lines.
+type lineEditor struct {
+	text   []rune
+	cursor int
+	// history holds the submitted inputs, oldest first. historyIdx is the
+	// entry being edited, len(history) for a new input, whose text is kept
+	// in draft while browsing the history.
+	history    []string
+	historyIdx int
+	draft      string
+}
+
+// update applies a key press. It returns the input and true when Enter
+// submits it.
+func (e *lineEditor) update(msg tea.KeyMsg) (string, bool) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		if msg.Alt {
+			e.insert('\n')
+			return "", false
+		}
+		return e.submit()
+	case tea.KeyRunes, tea.KeySpace:
+		for _, r := range msg.Runes {
+			e.insert(r)
+		}
+	case tea.KeyBackspace:
+		if e.cursor > 0 {
+			e.text = append(e.text[:e.cursor-1], e.text[e.cursor:]...)
+			e.cursor--
+		}
+	case tea.KeyDelete, tea.KeyCtrlD:
+		if e.cursor < len(e.text) {
+			e.text = append(e.text[:e.cursor], e.text[e.cursor+1:]...)
+		}
+	case tea.KeyLeft, tea.KeyCtrlB:
+		e.cursor = max(e.cursor-1, 0)
+	case tea.KeyRight, tea.KeyCtrlF:
+		e.cursor = min(e.cursor+1, len(e.text))
+	case tea.KeyHome, tea.KeyCtrlA:
+		for e.cursor > 0 && e.text[e.cursor-1] != '\n' {
+			e.cursor--
+		}
+	case tea.KeyEnd, tea.KeyCtrlE:
+		for e.cursor < len(e.text) && e.text[e.cursor] != '\n' {
+			e.cursor++
+		}
+	case tea.KeyCtrlU:
+		e.text = e.text[e.cursor:]
+		e.cursor = 0
+	case tea.KeyCtrlK:
+		e.text = e.text[:e.cursor]
+	case tea.KeyUp:
+		e.recall(e.historyIdx - 1)
+	case tea.KeyDown:
+		e.recall(e.historyIdx + 1)
+	}
+	return "", false
+}
+
+func (e *lineEditor) insert(r rune) {
+	e.text = append(e.text[:e.cursor], append([]rune{r}, e.text[e.cursor:]...)...)
+	e.cursor++
+}
+
+func (e *lineEditor) submit() (string, bool) {
+	input := string(e.text)
+	if strings.TrimSpace(input) == "" {
+		return "", false
+	}
+	if len(e.history) == 0 || e.history[len(e.history)-1] != input {
+		e.history = append(e.history, input)
+		if len(e.history) > maxEditorHistory {
+			e.history = e.history[1:]
+		}
+	}
+	e.historyIdx = len(e.history)
+	e.draft = ""
+	e.text = nil
+	e.cursor = 0
+	return input, true
+}
+
+// recall replaces the text with history entry idx, or with the draft past
+// the last entry.
+func (e *lineEditor) recall(idx int) {
+	if idx < 0 || idx > len(e.history) || idx == e.historyIdx {
+		return
+	}
+	if e.historyIdx == len(e.history) {
+		e.draft = string(e.text)
+	}
+	e.historyIdx = idx
+	if idx == len(e.history) {
+		e.text = []rune(e.draft)
+	} else {
+		e.text = []rune(e.history[idx])
+	}
+	e.cursor = len(e.text)
+}
+
+// view renders the input after prompt, with the cursor shown in reverse
+// video. Continuation lines are indented to line up with the first.
+func (e *lineEditor) view(prompt string) string {
+	var sb strings.Builder
+	sb.WriteString(prompt)
+	indent := strings.Repeat(" ", len(prompt))
+	for i, r := range e.text {
+		if i == e.cursor {
+			sb.WriteString(cursorStyle(r))
+			if r == '\n' {
+				sb.WriteString("\n" + indent)
+			}
+			continue
+		}
+		if r == '\n' {
+			sb.WriteString("\n" + indent)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	if e.cursor == len(e.text) {
+		sb.WriteString(cursorStyle(' '))
+	}
+	return sb.String()
+}
+
+func cursorStyle(r rune) string {
+	if r == '\n' {
+		r = ' '
+	}
+	return "\x1b[7m" + string(r) + "\x1b[0m"
+}
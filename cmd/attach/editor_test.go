@@ -0,0 +1,67 @@
+package attach
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+)
+
+func typeText(e *lineEditor, text string) {
+	for _, r := range text {
+		e.update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	e := &lineEditor{}
+	key := func(k tea.KeyType) (string, bool) { return e.update(tea.KeyMsg{Type: k}) }
+
+	typeText(e, "fix bug")
+	key(tea.KeyLeft)
+	key(tea.KeyLeft)
+	key(tea.KeyLeft)
+	typeText(e, "the ")
+	key(tea.KeyHome)
+	key(tea.KeyDelete)
+	typeText(e, "F")
+	input, ok := key(tea.KeyEnter)
+	assert.True(t, ok)
+	assert.Equal(t, "Fix the bug", input)
+	assert.Empty(t, e.text)
+
+	// Alt+Enter composes several lines. Empty inputs aren't submitted.
+	_, ok = key(tea.KeyEnter)
+	assert.False(t, ok)
+	typeText(e, "Run:")
+	e.update(tea.KeyMsg{Type: tea.KeyEnter, Alt: true})
+	typeText(e, "make test")
+	key(tea.KeyHome)
+	assert.Equal(t, 5, e.cursor)
+	key(tea.KeyBackspace)
+	key(tea.KeyEnd)
+	assert.Equal(t, "Run:make test", string(e.text))
+	e.update(tea.KeyMsg{Type: tea.KeyLeft})
+	input, _ = key(tea.KeyEnter)
+	assert.Equal(t, "Run:make test", input)
+
+	// The history is browsed with the arrows, keeping the draft.
+	typeText(e, "draft")
+	key(tea.KeyUp)
+	assert.Equal(t, "Run:make test", string(e.text))
+	key(tea.KeyUp)
+	assert.Equal(t, "Fix the bug", string(e.text))
+	key(tea.KeyUp)
+	assert.Equal(t, "Fix the bug", string(e.text))
+	key(tea.KeyDown)
+	key(tea.KeyDown)
+	assert.Equal(t, "draft", string(e.text))
+}
+
+func TestLineEditor_View(t *testing.T) {
+	e := &lineEditor{}
+	typeText(e, "a")
+	e.update(tea.KeyMsg{Type: tea.KeyEnter, Alt: true})
+	typeText(e, "b")
+	assert.Equal(t, "> a\n  b\x1b[7m \x1b[0m", e.view("> "))
+}
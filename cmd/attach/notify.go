@@ -0,0 +1,93 @@
+package attach
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/coder/agentapi/lib/httpapi"
+	sse "github.com/tmaxmax/go-sse"
+	"golang.org/x/xerrors"
+)
+
+// Sequences the terminal sends when it gains or loses focus, once focus
+// reporting is enabled.
+const (
+	focusInSequence  = "\x1b[I"
+	focusOutSequence = "\x1b[O"
+)
+
+// notifyTimeout bounds each run of the notification command.
+const notifyTimeout = 10 * time.Second
+
+// notifyCommand returns the command showing a desktop notification on
+// goos: notify-send on Linux and other Unixes, osascript on macOS, and a
+// PowerShell toast on Windows.
+func notifyCommand(goos string, title string, body string) (string, []string) {
+	switch goos {
+	case "darwin":
+		quote := func(s string) string {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+		}
+		return "osascript", []string{"-e", "display notification " + quote(body) + " with title " + quote(title)}
+	case "windows":
+		quote := func(s string) string {
+			return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+		}
+		script := "[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null; " +
+			"$t = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02); " +
+			"$x = $t.GetElementsByTagName('text'); " +
+			"$x.Item(0).AppendChild($t.CreateTextNode(" + quote(title) + ")) > $null; " +
+			"$x.Item(1).AppendChild($t.CreateTextNode(" + quote(body) + ")) > $null; " +
+			"[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('agentapi').Show([Windows.UI.Notifications.ToastNotification]::new($t))"
+		return "powershell", []string{"-NoProfile", "-NonInteractive", "-Command", script}
+	default:
+		return "notify-send", []string{"--app-name=agentapi", title, body}
+	}
+}
+
+func sendDesktopNotification(title string, body string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	name, args := notifyCommand(runtime.GOOS, title, body)
+	if output, err := exec.CommandContext(ctx, name, args...).CombinedOutput(); err != nil {
+		return xerrors.Errorf("%s: %w: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// ReadStatusOverHTTP sends the agent status from each status_change event
+// on the /events stream to ch.
+func ReadStatusOverHTTP(ctx context.Context, url string, ch chan<- httpapi.AgentStatus) error {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("failed to do request: %w", err)
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	for ev, err := range sse.Read(res.Body, &sse.ReadConfig{
+		// Message updates can be as big as the screen.
+		MaxEventSize: 256 * 1024,
+	}) {
+		if err != nil {
+			return xerrors.Errorf("failed to read sse: %w", err)
+		}
+		if ev.Type != string(httpapi.EventTypeStatusChange) {
+			continue
+		}
+		var body httpapi.StatusChangeBody
+		if err := json.Unmarshal([]byte(ev.Data), &body); err != nil {
+			return xerrors.Errorf("failed to unmarshal status change: %w", err)
+		}
+		ch <- body.Status
+	}
+	return nil
+}
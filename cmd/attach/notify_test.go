@@ -0,0 +1,21 @@
+package attach
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyCommand(t *testing.T) {
+	name, args := notifyCommand("linux", "agentapi", "done")
+	assert.Equal(t, "notify-send", name)
+	assert.Equal(t, []string{"--app-name=agentapi", "agentapi", "done"}, args)
+
+	name, args = notifyCommand("darwin", "agentapi", `say "hi"`)
+	assert.Equal(t, "osascript", name)
+	assert.Equal(t, []string{"-e", `display notification "say \"hi\"" with title "agentapi"`}, args)
+
+	name, args = notifyCommand("windows", "agentapi", "it's done")
+	assert.Equal(t, "powershell", name)
+	assert.Contains(t, args[len(args)-1], "CreateTextNode('it''s done')")
+}
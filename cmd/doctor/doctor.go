@@ -0,0 +1,179 @@
+package doctor
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/ActiveState/termtest/xpty"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"golang.org/x/xerrors"
+)
+
+// knownAgents are the executables of the agents agentapi supports.
+var knownAgents = []string{
+	"claude", "goose", "aider", "codex", "gemini", "copilot", "amp", "auggie", "cursor-agent", "q", "opencode",
+}
+
+type checkStatus string
+
+const (
+	checkOK   checkStatus = "ok"
+	checkWarn checkStatus = "warn"
+	checkFail checkStatus = "fail"
+)
+
+// checkResult is the outcome of one diagnostic, with a hint on how to fix
+// it unless it passed.
+type checkResult struct {
+	name   string
+	status checkStatus
+	detail string
+	hint   string
+}
+
+// checkPTY verifies that a pseudo terminal, which agents run in, can be
+// opened.
+func checkPTY() checkResult {
+	xp, err := xpty.New(80, 24, false)
+	if err != nil {
+		return checkResult{
+			name:   "pseudo terminal",
+			status: checkFail,
+			detail: err.Error(),
+			hint:   "agentapi runs agents in a pseudo terminal. In containers, make sure /dev/pts is mounted and /dev/ptmx is accessible.",
+		}
+	}
+	_ = xp.Close()
+	return checkResult{name: "pseudo terminal", status: checkOK, detail: "can open a pseudo terminal"}
+}
+
+// checkAgents looks for the agents on PATH. Without agents, it reports the
+// known agents found and only warns if there are none.
+func checkAgents(agents []string) []checkResult {
+	if len(agents) > 0 {
+		results := make([]checkResult, 0, len(agents))
+		for _, agent := range agents {
+			results = append(results, checkAgent(agent))
+		}
+		return results
+	}
+	var found []string
+	for _, agent := range knownAgents {
+		if _, err := exec.LookPath(agent); err == nil {
+			found = append(found, agent)
+		}
+	}
+	if len(found) == 0 {
+		return []checkResult{{
+			name:   "agents",
+			status: checkWarn,
+			detail: "none of the supported agents is on PATH",
+			hint:   fmt.Sprintf("Install one of %s, or pass the full path of the agent to agentapi server.", strings.Join(knownAgents, ", ")),
+		}}
+	}
+	return []checkResult{{name: "agents", status: checkOK, detail: "found " + strings.Join(found, ", ")}}
+}
+
+func checkAgent(agent string) checkResult {
+	path, err := exec.LookPath(agent)
+	if err != nil {
+		return checkResult{
+			name:   "agent " + agent,
+			status: checkFail,
+			detail: "not found on PATH",
+			hint:   fmt.Sprintf("Install %s, or pass its full path to agentapi server. If you can run it from your shell, `which %s` prints the path.", agent, agent),
+		}
+	}
+	return checkResult{name: "agent " + agent, status: checkOK, detail: path}
+}
+
+// checkPort verifies that the server can listen on the port.
+func checkPort(port int) checkResult {
+	name := fmt.Sprintf("port %d", port)
+	l, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return checkResult{
+			name:   name,
+			status: checkFail,
+			detail: err.Error(),
+			hint:   "Another process, possibly another agentapi server, is using the port. Stop it or pass --port to agentapi server.",
+		}
+	}
+	_ = l.Close()
+	return checkResult{name: name, status: checkOK, detail: "available"}
+}
+
+// checkTerminal verifies that the terminal can display agentapi attach.
+func checkTerminal(isTerminal bool, termEnv string) checkResult {
+	switch {
+	case !isTerminal:
+		return checkResult{
+			name:   "terminal",
+			status: checkWarn,
+			detail: "stdout is not a terminal",
+			hint:   "agentapi attach needs an interactive terminal. The server doesn't.",
+		}
+	case termEnv == "" || termEnv == "dumb":
+		return checkResult{
+			name:   "terminal",
+			status: checkWarn,
+			detail: fmt.Sprintf("TERM is %q", termEnv),
+			hint:   "agentapi attach draws the agent's screen with escape sequences. Set TERM, e.g. to xterm-256color.",
+		}
+	default:
+		return checkResult{name: "terminal", status: checkOK, detail: "TERM=" + termEnv}
+	}
+}
+
+// printResults prints the results and reports whether any check failed.
+func printResults(out io.Writer, results []checkResult) (failed bool) {
+	for _, result := range results {
+		mark := "✓"
+		switch result.status {
+		case checkWarn:
+			mark = "!"
+		case checkFail:
+			mark = "✗"
+			failed = true
+		}
+		_, _ = fmt.Fprintf(out, "%s %s: %s\n", mark, result.name, result.detail)
+		if result.hint != "" && result.status != checkOK {
+			_, _ = fmt.Fprintf(out, "  %s\n", result.hint)
+		}
+	}
+	return failed
+}
+
+func CreateDoctorCmd() *cobra.Command {
+	var port int
+	cmd := &cobra.Command{
+		Use:   "doctor [agent...]",
+		Short: "Diagnose the environment",
+		Long: "Check that agentapi can run agents here: that a pseudo terminal can be opened, the agents are on PATH, " +
+			"the server's port is free, and the terminal can display agentapi attach. Pass agents to check for them " +
+			"specifically, otherwise the supported agents found are listed.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			results := []checkResult{checkPTY()}
+			results = append(results, checkAgents(args)...)
+			results = append(results, checkPort(port))
+			results = append(results, checkTerminal(term.IsTerminal(int(os.Stdout.Fd())), os.Getenv("TERM")))
+			if printResults(cmd.OutOrStdout(), results) {
+				cmd.SilenceUsage = true
+				return xerrors.New("some checks failed")
+			}
+			return nil
+		},
+	}
+	defaultPort := 3284
+	if envPort, err := strconv.Atoi(os.Getenv("AGENTAPI_PORT")); err == nil {
+		defaultPort = envPort
+	}
+	cmd.Flags().IntVarP(&port, "port", "p", defaultPort, "Port the server will run on (defaults to AGENTAPI_PORT or 3284)")
+	return cmd
+}
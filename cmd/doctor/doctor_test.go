@@ -0,0 +1,68 @@
+package doctor
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckAgents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake agents are shell scripts")
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "claude"), []byte("#!/bin/sh\n"), 0o755))
+	t.Setenv("PATH", dir)
+
+	results := checkAgents(nil)
+	require.Len(t, results, 1)
+	assert.Equal(t, checkOK, results[0].status)
+	assert.Equal(t, "found claude", results[0].detail)
+
+	results = checkAgents([]string{"claude", "aider"})
+	require.Len(t, results, 2)
+	assert.Equal(t, checkOK, results[0].status)
+	assert.Equal(t, filepath.Join(dir, "claude"), results[0].detail)
+	assert.Equal(t, checkFail, results[1].status)
+
+	t.Setenv("PATH", t.TempDir())
+	results = checkAgents(nil)
+	assert.Equal(t, checkWarn, results[0].status)
+}
+
+func TestCheckPort(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	assert.Equal(t, checkFail, checkPort(port).status)
+	require.NoError(t, l.Close())
+	assert.Equal(t, checkOK, checkPort(port).status)
+}
+
+func TestCheckTerminal(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, checkWarn, checkTerminal(false, "xterm").status)
+	assert.Equal(t, checkWarn, checkTerminal(true, "dumb").status)
+	assert.Equal(t, checkOK, checkTerminal(true, "xterm-256color").status)
+}
+
+func TestPrintResults(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	failed := printResults(&out, []checkResult{
+		{name: "pseudo terminal", status: checkOK, detail: "can open a pseudo terminal"},
+		{name: "port 3284", status: checkFail, detail: "address already in use", hint: "Stop it."},
+	})
+	assert.True(t, failed)
+	assert.Equal(t, "✓ pseudo terminal: can open a pseudo terminal\n✗ port 3284: address already in use\n  Stop it.\n", out.String())
+}
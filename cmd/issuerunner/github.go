@@ -0,0 +1,126 @@
+package issuerunner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+type issue struct {
+	Number int          `json:"number"`
+	Title  string       `json:"title"`
+	Body   string       `json:"body"`
+	State  string       `json:"state"`
+	Labels []issueLabel `json:"labels"`
+	// PullRequest is set for pull requests, which the issues API also
+	// returns.
+	PullRequest *struct{} `json:"pull_request"`
+}
+
+// githubClient is the subset of the GitHub REST API the runner uses.
+type githubClient struct {
+	apiURL string
+	token  string
+	repo   string // owner/name
+	client *http.Client
+}
+
+func newGitHubClient(apiURL string, token string, repo string) *githubClient {
+	return &githubClient{
+		apiURL: strings.TrimRight(apiURL, "/"),
+		token:  token,
+		repo:   repo,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *githubClient) do(ctx context.Context, method string, path string, body any, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return xerrors.Errorf("failed to marshal request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, reqBody)
+	if err != nil {
+		return xerrors.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("failed to send request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return xerrors.Errorf("%s %s: GitHub returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return xerrors.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// labeledIssues returns the open issues with the given label.
+func (c *githubClient) labeledIssues(ctx context.Context, label string) ([]issue, error) {
+	var issues []issue
+	path := fmt.Sprintf("/repos/%s/issues?state=open&per_page=100&labels=%s", c.repo, url.QueryEscape(label))
+	if err := c.do(ctx, http.MethodGet, path, nil, &issues); err != nil {
+		return nil, err
+	}
+	filtered := issues[:0]
+	for _, issue := range issues {
+		if issue.PullRequest == nil {
+			filtered = append(filtered, issue)
+		}
+	}
+	return filtered, nil
+}
+
+type issueLabel struct {
+	Name string `json:"name"`
+}
+
+// hasLabel reports whether the issue has the given label.
+func (i issue) hasLabel(label string) bool {
+	for _, l := range i.Labels {
+		if l.Name == label {
+			return true
+		}
+	}
+	return false
+}
+
+// issue returns the issue with the given number.
+func (c *githubClient) issue(ctx context.Context, number int) (issue, error) {
+	var iss issue
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/repos/%s/issues/%d", c.repo, number), nil, &iss)
+	return iss, err
+}
+
+func (c *githubClient) comment(ctx context.Context, number int, body string) error {
+	return c.do(ctx, http.MethodPost, fmt.Sprintf("/repos/%s/issues/%d/comments", c.repo, number), map[string]string{"body": body}, nil)
+}
+
+func (c *githubClient) removeLabel(ctx context.Context, number int, label string) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/repos/%s/issues/%d/labels/%s", c.repo, number, url.PathEscape(label)), nil, nil)
+}
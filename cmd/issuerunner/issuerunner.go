@@ -0,0 +1,294 @@
+// Package issuerunner implements `agentapi issue-runner`, which runs an
+// agent for each GitHub issue with a given label and posts the agent's
+// reply as a comment.
+package issuerunner
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+)
+
+type Config struct {
+	// Repo is the repository as owner/name.
+	Repo  string
+	Label string
+	// PollInterval is how often labeled issues are listed. 0 disables
+	// polling, leaving only webhooks.
+	PollInterval   time.Duration
+	SessionTimeout time.Duration
+	MaxConcurrent  int
+	// WebhookAddr is the address the webhook receiver listens on.
+	// Webhooks are disabled when empty.
+	WebhookAddr string
+	// WebhookSecret is the secret deliveries must be signed with. It is
+	// required with WebhookAddr.
+	WebhookSecret string
+	AgentArgs     []string
+}
+
+type runner struct {
+	cfg    Config
+	gh     *githubClient
+	logger *slog.Logger
+	// runSession runs an agent with prompt and returns its reply.
+	runSession func(ctx context.Context, prompt string) (string, error)
+
+	mu     sync.Mutex
+	active map[int]bool
+	wg     sync.WaitGroup
+	slots  chan struct{}
+}
+
+func newRunner(cfg Config, gh *githubClient, logger *slog.Logger) *runner {
+	r := &runner{
+		cfg:    cfg,
+		gh:     gh,
+		logger: logger,
+		active: make(map[int]bool),
+		slots:  make(chan struct{}, max(cfg.MaxConcurrent, 1)),
+	}
+	r.runSession = func(ctx context.Context, prompt string) (string, error) {
+		return runSession(ctx, cfg.AgentArgs, prompt)
+	}
+	return r
+}
+
+func issuePrompt(iss issue) string {
+	return fmt.Sprintf("GitHub issue #%d: %s\n\n%s", iss.Number, iss.Title, iss.Body)
+}
+
+// handle runs a session for iss in the background unless one is already
+// running. Once the agent replies, the reply is posted as a comment and the
+// label is removed so the issue isn't picked up again.
+func (r *runner) handle(ctx context.Context, iss issue) {
+	r.mu.Lock()
+	if r.active[iss.Number] {
+		r.mu.Unlock()
+		return
+	}
+	r.active[iss.Number] = true
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer func() {
+			r.mu.Lock()
+			delete(r.active, iss.Number)
+			r.mu.Unlock()
+		}()
+
+		select {
+		case r.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		defer func() { <-r.slots }()
+
+		logger := r.logger.With("issue", iss.Number)
+		logger.Info("Starting session for issue")
+		sessionCtx, cancel := context.WithTimeout(ctx, r.cfg.SessionTimeout)
+		reply, err := r.runSession(sessionCtx, issuePrompt(iss))
+		cancel()
+		if ctx.Err() != nil {
+			// Shutting down; the issue is picked up again on restart.
+			return
+		}
+
+		var comment string
+		if err != nil {
+			logger.Error("Session failed", "error", err)
+			comment = fmt.Sprintf("AgentAPI could not complete this issue: %s", err)
+		} else {
+			comment = strings.TrimSpace(reply) + "\n\n---\nPosted by [AgentAPI](https://github.com/coder/agentapi)."
+		}
+		if err := r.gh.comment(ctx, iss.Number, comment); err != nil {
+			logger.Error("Failed to comment on issue", "error", err)
+			return
+		}
+		if err := r.gh.removeLabel(ctx, iss.Number, r.cfg.Label); err != nil {
+			logger.Error("Failed to remove label from issue", "error", err)
+		}
+		logger.Info("Posted agent reply to issue")
+	}()
+}
+
+func (r *runner) poll(ctx context.Context) {
+	issues, err := r.gh.labeledIssues(ctx, r.cfg.Label)
+	if err != nil {
+		r.logger.Error("Failed to list issues", "error", err)
+		return
+	}
+	for _, iss := range issues {
+		r.handle(ctx, iss)
+	}
+}
+
+// validSignature checks the X-Hub-Signature-256 header of a webhook
+// delivery.
+func validSignature(secret string, body []byte, header string) bool {
+	signature, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// webhookHandler starts a session when the label is added to an issue. The
+// issue is fetched from the API rather than taken from the payload, so the
+// prompt is what the issue says now and an issue whose label has since been
+// removed is skipped.
+func (r *runner) webhookHandler(ctx context.Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(req.Body, 10<<20))
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		if !validSignature(r.cfg.WebhookSecret, body, req.Header.Get("X-Hub-Signature-256")) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		if req.Header.Get("X-GitHub-Event") != "issues" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		var event struct {
+			Action string `json:"action"`
+			Label  struct {
+				Name string `json:"name"`
+			} `json:"label"`
+			Issue issue `json:"issue"`
+		}
+		if err := json.Unmarshal(body, &event); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		if event.Action != "labeled" || event.Label.Name != r.cfg.Label || event.Issue.PullRequest != nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		iss, err := r.gh.issue(req.Context(), event.Issue.Number)
+		if err != nil {
+			r.logger.Error("Failed to fetch issue", "issue", event.Issue.Number, "error", err)
+			http.Error(w, "failed to fetch issue", http.StatusBadGateway)
+			return
+		}
+		if iss.State == "open" && iss.PullRequest == nil && iss.hasLabel(r.cfg.Label) {
+			r.handle(ctx, iss)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// run polls for issues and serves webhooks until ctx is canceled, then
+// waits for running sessions to stop.
+func (r *runner) run(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	if r.cfg.WebhookAddr != "" {
+		srv := &http.Server{Addr: r.cfg.WebhookAddr, Handler: r.webhookHandler(ctx)}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errCh <- xerrors.Errorf("webhook server failed: %w", err)
+			}
+		}()
+		defer func() {
+			_ = srv.Close()
+		}()
+		r.logger.Info("Receiving webhooks", "addr", r.cfg.WebhookAddr)
+	}
+
+	var tick <-chan time.Time
+	if r.cfg.PollInterval > 0 {
+		ticker := time.NewTicker(r.cfg.PollInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+		r.poll(ctx)
+	}
+
+	var err error
+	for err == nil {
+		select {
+		case <-ctx.Done():
+			r.wg.Wait()
+			return nil
+		case err = <-errCh:
+		case <-tick:
+			r.poll(ctx)
+		}
+	}
+	r.wg.Wait()
+	return err
+}
+
+func githubToken() string {
+	if token := os.Getenv("GH_TOKEN"); token != "" {
+		return token
+	}
+	return os.Getenv("GITHUB_TOKEN")
+}
+
+func CreateIssueRunnerCmd() *cobra.Command {
+	var cfg Config
+	var apiURL string
+	cmd := &cobra.Command{
+		Use:   "issue-runner --repo owner/name [flags] -- [agent]",
+		Short: "Run an agent for each labeled GitHub issue",
+		Long: "Run an agent for each open GitHub issue with the given label, using the issue as the initial prompt. " +
+			"The agent's reply is posted as a comment and the label is removed. Set GH_TOKEN or GITHUB_TOKEN to a token " +
+			"that can read issues and write comments.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !strings.Contains(cfg.Repo, "/") {
+				return xerrors.New("--repo must be set to owner/name")
+			}
+			token := githubToken()
+			if token == "" {
+				return xerrors.New("GH_TOKEN or GITHUB_TOKEN must be set")
+			}
+			if cfg.PollInterval <= 0 && cfg.WebhookAddr == "" {
+				return xerrors.New("either --poll-interval or --webhook-addr must be set")
+			}
+			if cfg.WebhookAddr != "" && cfg.WebhookSecret == "" {
+				return xerrors.New("--webhook-secret must be set with --webhook-addr")
+			}
+			cfg.AgentArgs = args
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+			logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+			return newRunner(cfg, newGitHubClient(apiURL, token, cfg.Repo), logger).run(ctx)
+		},
+	}
+	cmd.Flags().StringVar(&cfg.Repo, "repo", "", "GitHub repository as owner/name")
+	cmd.Flags().StringVar(&cfg.Label, "label", "agentapi", "Label that marks issues for the agent")
+	cmd.Flags().DurationVar(&cfg.PollInterval, "poll-interval", time.Minute, "How often to look for labeled issues. 0 disables polling")
+	cmd.Flags().DurationVar(&cfg.SessionTimeout, "session-timeout", time.Hour, "How long the agent may work on an issue")
+	cmd.Flags().IntVar(&cfg.MaxConcurrent, "max-concurrent", 1, "Maximum number of issues worked on at the same time")
+	cmd.Flags().StringVar(&cfg.WebhookAddr, "webhook-addr", "", "Address to receive GitHub issues webhooks on, e.g. :8080")
+	cmd.Flags().StringVar(&cfg.WebhookSecret, "webhook-secret", os.Getenv("AGENTAPI_WEBHOOK_SECRET"), "Secret the webhook deliveries are signed with (defaults to AGENTAPI_WEBHOOK_SECRET)")
+	cmd.Flags().StringVar(&apiURL, "github-api-url", "https://api.github.com", "GitHub REST API URL, for GitHub Enterprise Server")
+	return cmd
+}
@@ -0,0 +1,173 @@
+package issuerunner
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/httpapi"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGitHub records the comments and label removals made by the runner.
+type fakeGitHub struct {
+	mu       sync.Mutex
+	issues   []issue
+	comments map[string]string
+	removed  []string
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/acme/widgets/issues":
+		_ = json.NewEncoder(w).Encode(f.issues)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/repos/acme/widgets/issues/"):
+		number, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/repos/acme/widgets/issues/"))
+		for _, iss := range f.issues {
+			if iss.Number == number {
+				_ = json.NewEncoder(w).Encode(iss)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodPost:
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.comments[r.URL.Path] = body["body"]
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete:
+		f.removed = append(f.removed, r.URL.Path)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestRunner(t *testing.T, cfg Config) (*runner, *fakeGitHub) {
+	t.Helper()
+	gh := &fakeGitHub{comments: map[string]string{}}
+	srv := httptest.NewServer(gh)
+	t.Cleanup(srv.Close)
+	if cfg.Label == "" {
+		cfg.Label = "agentapi"
+	}
+	cfg.SessionTimeout = time.Minute
+	r := newRunner(cfg, newGitHubClient(srv.URL, "token", "acme/widgets"), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	r.runSession = func(ctx context.Context, prompt string) (string, error) {
+		return "Reply to: " + prompt, nil
+	}
+	return r, gh
+}
+
+func TestRunner_Poll(t *testing.T) {
+	t.Parallel()
+	r, gh := newTestRunner(t, Config{})
+	gh.issues = []issue{
+		{Number: 1, Title: "Fix the widget", Body: "It is broken."},
+		{Number: 2, Title: "Not an issue", PullRequest: &struct{}{}},
+	}
+
+	r.poll(t.Context())
+	r.wg.Wait()
+
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	require.Len(t, gh.comments, 1)
+	assert.Contains(t, gh.comments["/repos/acme/widgets/issues/1/comments"], "Reply to: GitHub issue #1: Fix the widget\n\nIt is broken.")
+	assert.Equal(t, []string{"/repos/acme/widgets/issues/1/labels/agentapi"}, gh.removed)
+}
+
+func TestRunner_Webhook(t *testing.T) {
+	t.Parallel()
+	r, gh := newTestRunner(t, Config{WebhookSecret: "secret"})
+	handler := r.webhookHandler(t.Context())
+	gh.issues = []issue{
+		{Number: 3, Title: "Add docs", Body: "Edited after labeling.", State: "open", Labels: []issueLabel{{Name: "agentapi"}}},
+		// The label was removed again before the delivery arrived.
+		{Number: 5, Title: "Unlabeled", State: "open"},
+	}
+
+	deliver := func(event string, payload string, secret string) int {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(payload))
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(payload))
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	labeled := `{"action": "labeled", "label": {"name": "agentapi"}, "issue": {"number": 3, "title": "Add docs", "body": ""}}`
+	assert.Equal(t, http.StatusUnauthorized, deliver("issues", labeled, "wrong"))
+	assert.Equal(t, http.StatusNoContent, deliver("issues", `{"action": "labeled", "label": {"name": "bug"}, "issue": {"number": 4}}`, "secret"))
+	assert.Equal(t, http.StatusNoContent, deliver("issues", `{"action": "labeled", "label": {"name": "agentapi"}, "issue": {"number": 5}}`, "secret"))
+	assert.Equal(t, http.StatusBadGateway, deliver("issues", `{"action": "labeled", "label": {"name": "agentapi"}, "issue": {"number": 6}}`, "secret"))
+	assert.Equal(t, http.StatusNoContent, deliver("issues", labeled, "secret"))
+	r.wg.Wait()
+
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	require.Len(t, gh.comments, 1)
+	assert.Contains(t, gh.comments["/repos/acme/widgets/issues/3/comments"], "Reply to: GitHub issue #3: Add docs\n\nEdited after labeling.")
+}
+
+func TestWaitForReply(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	status := httpapi.AgentStatusStable
+	messages := []httpapi.Message{{Id: 0, Role: st.ConversationRoleAgent, Content: "Welcome"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/status":
+			_ = json.NewEncoder(w).Encode(map[string]any{"status": status})
+		case "/messages":
+			_ = json.NewEncoder(w).Encode(map[string]any{"messages": messages})
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	replyCh := make(chan string, 1)
+	go func() {
+		reply, err := waitForReply(t.Context(), srv.URL, 10*time.Millisecond)
+		assert.NoError(t, err)
+		replyCh <- reply
+	}()
+
+	// The welcome message is not a reply to the prompt.
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	messages = append(messages, httpapi.Message{Id: 1, Role: st.ConversationRoleUser, Content: "Fix it"})
+	status = httpapi.AgentStatusRunning
+	mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	messages = append(messages, httpapi.Message{Id: 2, Role: st.ConversationRoleAgent, Content: "Fixed."})
+	status = httpapi.AgentStatusStable
+	mu.Unlock()
+
+	select {
+	case reply := <-replyCh:
+		assert.Equal(t, "Fixed.", reply)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the reply")
+	}
+}
@@ -0,0 +1,143 @@
+package issuerunner
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/coder/agentapi/lib/httpapi"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/util"
+	"golang.org/x/xerrors"
+)
+
+// replyPollInterval is how often a session is checked for the agent's
+// reply.
+const replyPollInterval = 2 * time.Second
+
+// stopTimeout is how long a session gets to shut down before it is killed.
+const stopTimeout = 10 * time.Second
+
+// runSession starts `agentapi server` with prompt as the initial prompt,
+// waits for the agent to reply and stops the server.
+func runSession(ctx context.Context, agentArgs []string, prompt string) (string, error) {
+	port, err := freePort()
+	if err != nil {
+		return "", err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return "", xerrors.Errorf("failed to find the agentapi executable: %w", err)
+	}
+	args := append([]string{"server", "--port", strconv.Itoa(port), "--initial-prompt", prompt, "--"}, agentArgs...)
+	cmd := exec.Command(executable, args...)
+	if err := cmd.Start(); err != nil {
+		return "", xerrors.Errorf("failed to start agentapi server: %w", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+	defer func() {
+		// Interrupt saves state and stops the agent cleanly. It is not
+		// supported on Windows, where the process is killed instead.
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			_ = cmd.Process.Kill()
+		}
+		select {
+		case <-exited:
+		case <-time.After(stopTimeout):
+			_ = cmd.Process.Kill()
+		}
+	}()
+
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-exited:
+			cancel()
+		case <-waitCtx.Done():
+		}
+	}()
+	reply, err := waitForReply(waitCtx, "http://127.0.0.1:"+strconv.Itoa(port), replyPollInterval)
+	select {
+	case <-exited:
+		return "", xerrors.Errorf("agentapi server exited: %s", cmd.ProcessState)
+	default:
+	}
+	return reply, err
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, xerrors.Errorf("failed to find a free port: %w", err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// waitForReply polls the agentapi server at baseURL until the agent has
+// replied to the initial prompt, and returns the reply. The agent must be
+// stable on two consecutive polls, so a short pause in its output isn't
+// mistaken for the end of the reply.
+func waitForReply(ctx context.Context, baseURL string, interval time.Duration) (string, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	stablePolls := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+
+		var status httpapi.StatusResponse
+		if err := util.GetJSON(ctx, baseURL+"/status", &status.Body); err != nil {
+			// The server may still be starting.
+			continue
+		}
+		if status.Body.Status != httpapi.AgentStatusStable {
+			stablePolls = 0
+			continue
+		}
+		var messages httpapi.MessagesResponse
+		if err := util.GetJSON(ctx, baseURL+"/messages", &messages.Body); err != nil {
+			continue
+		}
+		reply, ok := replyToPrompt(messages.Body.Messages)
+		if !ok {
+			stablePolls = 0
+			continue
+		}
+		stablePolls++
+		if stablePolls >= 2 {
+			return reply, nil
+		}
+	}
+}
+
+// replyToPrompt returns the last agent message if it follows a user
+// message.
+func replyToPrompt(messages []httpapi.Message) (string, bool) {
+	if len(messages) < 2 {
+		return "", false
+	}
+	last := messages[len(messages)-1]
+	if last.Role != st.ConversationRoleAgent {
+		return "", false
+	}
+	for _, msg := range messages[:len(messages)-1] {
+		if msg.Role == st.ConversationRoleUser {
+			return last.Content, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,439 @@
+package loadtest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/agentapi/lib/httpapi"
+	"github.com/coder/agentapi/lib/logctx"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+)
+
+// The published SLOs of a server, for the p99 latencies measured by the load
+// test. They hold on a single core with 100 subscribers, the README lists
+// the measurements behind them.
+const (
+	deliverySLO = 100 * time.Millisecond
+	statusSLO   = 100 * time.Millisecond
+)
+
+// replyPattern matches the responses of the mock agent, numbered in the
+// order of the messages it received.
+var replyPattern = regexp.MustCompile(`reply-(\d+)`)
+
+const inputBoxBorder = "────────────────────────────────────────"
+
+// mockAgent is an agent with an input box that echoes what is typed and,
+// after the think time, responds to each message it's sent with a numbered
+// reply.
+type mockAgent struct {
+	thinkTime time.Duration
+
+	mu         sync.Mutex
+	transcript []string
+	input      string
+	// replied is when the agent showed the reply to each message.
+	replied []time.Time
+	// entered counts the messages entered.
+	entered int
+}
+
+func (a *mockAgent) ReadScreen() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	lines := append(slices.Clone(a.transcript), inputBoxBorder, "> "+a.input, inputBoxBorder)
+	return strings.Join(lines, "\n")
+}
+
+func (a *mockAgent) Write(data []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	text := strings.NewReplacer("\x1b[200~", "", "\x1b[201~", "").Replace(string(data))
+	if !strings.Contains(text, "\r") {
+		a.input += text
+		return len(data), nil
+	}
+	if a.input == "" {
+		return len(data), nil
+	}
+	a.transcript = append(a.transcript, "you: "+a.input)
+	a.input = ""
+	a.entered++
+	n := a.entered
+	time.AfterFunc(a.thinkTime, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.transcript = append(a.transcript, fmt.Sprintf("reply-%d", n))
+		a.replied = append(a.replied, time.Now())
+	})
+	return len(data), nil
+}
+
+func (a *mockAgent) replyTimes() []time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.replied)
+}
+
+// subscriber is an SSE client of GET /events recording when each reply and
+// each change to the running status reached it.
+type subscriber struct {
+	mu      sync.Mutex
+	replies map[int]time.Time
+	running []time.Time
+}
+
+func (sub *subscriber) run(ctx context.Context, url string, connected func()) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/events", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("failed to subscribe to events: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return xerrors.Errorf("failed to subscribe to events: %s", resp.Status)
+	}
+	connected()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	var event string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		now := time.Now()
+		switch httpapi.EventType(event) {
+		case httpapi.EventTypeMessageUpdate:
+			var body httpapi.MessageUpdateBody
+			if err := json.Unmarshal([]byte(data), &body); err != nil || body.Role != st.ConversationRoleAgent {
+				continue
+			}
+			sub.mu.Lock()
+			for _, match := range replyPattern.FindAllStringSubmatch(body.Message, -1) {
+				n, _ := strconv.Atoi(match[1])
+				if _, ok := sub.replies[n]; !ok {
+					sub.replies[n] = now
+				}
+			}
+			sub.mu.Unlock()
+		case httpapi.EventTypeStatusChange:
+			var body httpapi.StatusChangeBody
+			if err := json.Unmarshal([]byte(data), &body); err != nil || body.Status != httpapi.AgentStatusRunning {
+				continue
+			}
+			sub.mu.Lock()
+			sub.running = append(sub.running, now)
+			sub.mu.Unlock()
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return xerrors.Errorf("event stream ended: %w", scanner.Err())
+}
+
+func (sub *subscriber) received(n int) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	_, ok := sub.replies[n]
+	return ok
+}
+
+// config is the load to put on the server.
+type config struct {
+	subscribers int
+	posters     int
+	messages    int
+	thinkTime   time.Duration
+	timeout     time.Duration
+}
+
+// result holds the latencies measured by a load test.
+type result struct {
+	// delivery is from the agent showing a reply to a subscriber receiving
+	// it, status from a message being posted to a subscriber seeing the
+	// agent running, and post the time POST /message took.
+	delivery []time.Duration
+	status   []time.Duration
+	post     []time.Duration
+	// rejected counts the posts rejected because the agent was busy, and
+	// lost the replies that didn't reach a subscriber.
+	rejected int
+	lost     int
+}
+
+// run puts the load on a server with a mock agent, listening on a random
+// local port.
+func run(ctx context.Context, cfg config) (result, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+
+	agent := &mockAgent{thinkTime: cfg.thinkTime}
+	serverCtx := logctx.WithLogger(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	srv, err := httpapi.NewServer(serverCtx, httpapi.ServerConfig{
+		AgentType:      mf.AgentTypeCustom,
+		AgentIO:        agent,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+	})
+	if err != nil {
+		return result{}, xerrors.Errorf("failed to create server: %w", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return result{}, xerrors.Errorf("failed to listen: %w", err)
+	}
+	httpServer := &http.Server{Handler: srv.Handler()}
+	go func() {
+		_ = httpServer.Serve(ln)
+	}()
+	defer func() {
+		_ = srv.Stop(context.Background())
+		_ = httpServer.Close()
+	}()
+	url := "http://" + ln.Addr().String()
+
+	subCtx, stopSubscribers := context.WithCancel(ctx)
+	defer stopSubscribers()
+	subscribers := make([]*subscriber, cfg.subscribers)
+	subErrs := make(chan error, cfg.subscribers)
+	var connected, done sync.WaitGroup
+	connected.Add(cfg.subscribers)
+	done.Add(cfg.subscribers)
+	for i := range subscribers {
+		sub := &subscriber{replies: map[int]time.Time{}}
+		subscribers[i] = sub
+		go func() {
+			defer done.Done()
+			var once sync.Once
+			markConnected := func() { once.Do(connected.Done) }
+			defer markConnected()
+			if err := sub.run(subCtx, url, markConnected); err != nil {
+				subErrs <- err
+			}
+		}()
+	}
+	connected.Wait()
+
+	var (
+		mu       sync.Mutex
+		res      result
+		posted   []time.Time
+		postErrs = make(chan error, cfg.posters)
+		posters  sync.WaitGroup
+	)
+	for range cfg.posters {
+		posters.Add(1)
+		go func() {
+			defer posters.Done()
+			for {
+				mu.Lock()
+				if len(posted) >= cfg.messages {
+					mu.Unlock()
+					return
+				}
+				n := len(posted) + 1
+				mu.Unlock()
+
+				start := time.Now()
+				accepted, err := postMessage(ctx, url, fmt.Sprintf("message %d", n))
+				if err != nil {
+					postErrs <- err
+					return
+				}
+				mu.Lock()
+				if !accepted {
+					res.rejected++
+					mu.Unlock()
+					time.Sleep(50 * time.Millisecond)
+					continue
+				}
+				posted = append(posted, start)
+				res.post = append(res.post, time.Since(start))
+				mu.Unlock()
+			}
+		}()
+	}
+	posters.Wait()
+	close(postErrs)
+	if err := <-postErrs; err != nil {
+		return result{}, err
+	}
+
+	// Wait for the last reply to reach every subscriber.
+	lastDelivered := func() bool {
+		for _, sub := range subscribers {
+			if !sub.received(len(posted)) {
+				return false
+			}
+		}
+		return true
+	}
+	for !lastDelivered() && ctx.Err() == nil {
+		time.Sleep(50 * time.Millisecond)
+	}
+	stopSubscribers()
+	done.Wait()
+	close(subErrs)
+	if err := <-subErrs; err != nil {
+		return result{}, err
+	}
+
+	replied := agent.replyTimes()
+	slices.SortFunc(posted, func(a, b time.Time) int { return a.Compare(b) })
+	for _, sub := range subscribers {
+		sub.mu.Lock()
+		for i, replyTime := range replied {
+			received, ok := sub.replies[i+1]
+			if !ok {
+				res.lost++
+				continue
+			}
+			res.delivery = append(res.delivery, received.Sub(replyTime))
+		}
+		// The agent runs once per message, so the first time it's seen
+		// running after a message was posted is the change it caused.
+		for _, postTime := range posted {
+			i, _ := slices.BinarySearchFunc(sub.running, postTime, func(a, b time.Time) int { return a.Compare(b) })
+			if i < len(sub.running) {
+				res.status = append(res.status, sub.running[i].Sub(postTime))
+			}
+		}
+		sub.mu.Unlock()
+	}
+	res.lost += (len(posted) - len(replied)) * cfg.subscribers
+	if ctx.Err() != nil {
+		return res, xerrors.Errorf("load test timed out after %s", cfg.timeout)
+	}
+	return res, nil
+}
+
+// postMessage posts a user message and reports whether the server accepted
+// it. It's rejected while the agent is busy with a previous message.
+func postMessage(ctx context.Context, url, content string) (bool, error) {
+	body, err := json.Marshal(httpapi.MessageRequestBody{Content: content, Type: httpapi.MessageTypeUser})
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url+"/message", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, xerrors.Errorf("failed to post message: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return true, nil
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	if strings.Contains(string(respBody), st.ErrMessageValidationChanging.Error()) {
+		return false, nil
+	}
+	return false, xerrors.Errorf("failed to post message: %s: %s", resp.Status, respBody)
+}
+
+// percentile returns the p-th percentile of the durations by the nearest
+// rank method.
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// printResult prints the latencies and reports whether any SLO was missed
+// or a reply was lost.
+func printResult(out io.Writer, cfg config, res result) (failed bool) {
+	_, _ = fmt.Fprintf(out, "%d subscribers, %d posters, %d messages (%d posts rejected while the agent was busy)\n\n",
+		cfg.subscribers, cfg.posters, cfg.messages, res.rejected)
+	_, _ = fmt.Fprintf(out, "%-20s %8s %10s %10s %10s\n", "", "samples", "p50", "p99", "p99 SLO")
+	row := func(name string, durations []time.Duration, slo time.Duration) {
+		p99 := percentile(durations, 99)
+		sloText := "-"
+		if slo > 0 {
+			mark := "✓"
+			if p99 > slo {
+				mark = "✗"
+				failed = true
+			}
+			sloText = fmt.Sprintf("%s %s", slo, mark)
+		}
+		_, _ = fmt.Fprintf(out, "%-20s %8d %10s %10s %10s\n", name, len(durations),
+			percentile(durations, 50).Round(100*time.Microsecond), p99.Round(100*time.Microsecond), sloText)
+	}
+	row("message delivery", res.delivery, deliverySLO)
+	row("status propagation", res.status, statusSLO)
+	row("POST /message", res.post, 0)
+	if res.lost > 0 {
+		_, _ = fmt.Fprintf(out, "\n✗ %d replies didn't reach a subscriber\n", res.lost)
+		failed = true
+	}
+	return failed
+}
+
+func CreateLoadTestCmd() *cobra.Command {
+	var cfg config
+	cmd := &cobra.Command{
+		Use:   "loadtest",
+		Short: "Measure the latency of a server under load",
+		Long: "Run a server with a mock agent, subscribe to its events with many SSE clients while messages are posted to it, " +
+			"and report the p50 and p99 latencies of message delivery and status propagation against the published SLOs. " +
+			"Message delivery is from the agent showing a reply to a subscriber receiving it, status propagation from a " +
+			"message being posted to a subscriber seeing the agent running. Fails if an SLO is missed or a reply is lost.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cfg.subscribers < 1 || cfg.posters < 1 || cfg.messages < 1 {
+				return xerrors.New("--subscribers, --posters and --messages must be at least 1")
+			}
+			cmd.SilenceUsage = true
+			res, err := run(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			if printResult(cmd.OutOrStdout(), cfg, res) {
+				return xerrors.New("the server missed its SLOs")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&cfg.subscribers, "subscribers", 100, "Number of SSE clients subscribed to GET /events")
+	cmd.Flags().IntVar(&cfg.posters, "posters", 4, "Number of clients posting messages concurrently")
+	cmd.Flags().IntVar(&cfg.messages, "messages", 10, "Number of messages the agent is sent")
+	cmd.Flags().DurationVar(&cfg.thinkTime, "think-time", 500*time.Millisecond, "How long the mock agent takes to reply to a message")
+	cmd.Flags().DurationVar(&cfg.timeout, "timeout", 5*time.Minute, "Time after which the load test is aborted")
+	return cmd
+}
@@ -0,0 +1,59 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentile(t *testing.T) {
+	t.Parallel()
+
+	var durations []time.Duration
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 50*time.Millisecond, percentile(durations, 50))
+	assert.Equal(t, 99*time.Millisecond, percentile(durations, 99))
+	assert.Equal(t, 7*time.Millisecond, percentile([]time.Duration{7 * time.Millisecond}, 99))
+	assert.Zero(t, percentile(nil, 50))
+}
+
+func TestRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping load test in short mode")
+	}
+	t.Parallel()
+
+	cfg := config{subscribers: 5, posters: 2, messages: 2, thinkTime: 10 * time.Millisecond, timeout: time.Minute}
+	res, err := run(context.Background(), cfg)
+	require.NoError(t, err)
+	assert.Zero(t, res.lost)
+	assert.Len(t, res.delivery, cfg.subscribers*cfg.messages)
+	assert.Len(t, res.status, cfg.subscribers*cfg.messages)
+	assert.Len(t, res.post, cfg.messages)
+
+	var out bytes.Buffer
+	printResult(&out, cfg, res)
+	assert.Contains(t, out.String(), "message delivery")
+}
+
+func TestPrintResult(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	failed := printResult(&out, config{subscribers: 1, posters: 1, messages: 1}, result{
+		delivery: []time.Duration{time.Second},
+		status:   []time.Duration{time.Millisecond},
+		post:     []time.Duration{time.Second},
+		lost:     1,
+	})
+	assert.True(t, failed)
+	assert.Contains(t, out.String(), "100ms ✗")
+	assert.Contains(t, out.String(), "100ms ✓")
+	assert.Contains(t, out.String(), "1 replies didn't reach a subscriber")
+}
@@ -5,7 +5,13 @@ import (
 	"os"
 
 	"github.com/coder/agentapi/cmd/attach"
+	"github.com/coder/agentapi/cmd/doctor"
+	"github.com/coder/agentapi/cmd/issuerunner"
+	"github.com/coder/agentapi/cmd/loadtest"
+	"github.com/coder/agentapi/cmd/sandbox"
+	"github.com/coder/agentapi/cmd/selfupdate"
 	"github.com/coder/agentapi/cmd/server"
+	"github.com/coder/agentapi/cmd/slack"
 	"github.com/coder/agentapi/internal/version"
 	"github.com/spf13/cobra"
 )
@@ -28,4 +34,11 @@ func Execute() {
 func init() {
 	rootCmd.AddCommand(server.CreateServerCmd())
 	rootCmd.AddCommand(attach.AttachCmd)
+	rootCmd.AddCommand(issuerunner.CreateIssueRunnerCmd())
+	rootCmd.AddCommand(slack.CreateSlackCmd())
+	rootCmd.AddCommand(selfupdate.CreateVersionCmd())
+	rootCmd.AddCommand(selfupdate.CreateSelfUpdateCmd())
+	rootCmd.AddCommand(doctor.CreateDoctorCmd())
+	rootCmd.AddCommand(loadtest.CreateLoadTestCmd())
+	rootCmd.AddCommand(sandbox.CreateSandboxExecCmd())
 }
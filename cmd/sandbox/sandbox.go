@@ -0,0 +1,26 @@
+package sandbox
+
+import (
+	"github.com/coder/agentapi/lib/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// CreateSandboxExecCmd creates the command the server runs the agent
+// through with --sandbox. It isn't meant to be run directly.
+func CreateSandboxExecCmd() *cobra.Command {
+	var cfg sandbox.Config
+	cmd := &cobra.Command{
+		Use:    sandbox.ExecCommand + " [flags] -- program [args...]",
+		Short:  "Run a program with restricted filesystem access",
+		Hidden: true,
+		Args:   cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return sandbox.Exec(cfg, args[0], args[1:])
+		},
+	}
+	cmd.Flags().StringArrayVar(&cfg.ReadWrite, "rw", nil, "Path the program can read and write")
+	cmd.Flags().StringArrayVar(&cfg.ReadOnly, "ro", nil, "Path the program can only read")
+	cmd.Flags().IntVar(&cfg.ProxyPort, "proxy-port", 0, "The only TCP port the program can connect to")
+	return cmd
+}
@@ -0,0 +1,217 @@
+package selfupdate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/semver"
+	"golang.org/x/xerrors"
+)
+
+// checksumsAsset is the release asset listing the SHA-256 checksums of the
+// binaries, in the format of sha256sum.
+const checksumsAsset = "checksums.txt"
+
+// maxBinarySize caps the size of a downloaded binary.
+const maxBinarySize = 512 << 20 // 512MB
+
+type release struct {
+	TagName string         `json:"tag_name"`
+	HTMLURL string         `json:"html_url"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+func (r release) asset(name string) (releaseAsset, bool) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset, true
+		}
+	}
+	return releaseAsset{}, false
+}
+
+// releaseClient reads the releases of a GitHub repository.
+type releaseClient struct {
+	apiURL string
+	token  string
+	repo   string // owner/name
+	client *http.Client
+}
+
+func newReleaseClient(apiURL string, token string, repo string) *releaseClient {
+	return &releaseClient{
+		apiURL: strings.TrimRight(apiURL, "/"),
+		token:  token,
+		repo:   repo,
+		// Downloads can be slow, so only the connection is timed out; the
+		// commands are canceled with Ctrl+C.
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+		}},
+	}
+}
+
+func (c *releaseClient) get(ctx context.Context, url string, accept string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", accept)
+	// The token is optional, it raises GitHub's rate limit.
+	if c.token != "" && strings.HasPrefix(url, c.apiURL) {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		_ = resp.Body.Close()
+		return nil, xerrors.Errorf("GET %s: %s: %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
+
+// latest returns the latest published release.
+func (c *releaseClient) latest(ctx context.Context) (release, error) {
+	body, err := c.get(ctx, fmt.Sprintf("%s/repos/%s/releases/latest", c.apiURL, c.repo), "application/vnd.github+json")
+	if err != nil {
+		return release{}, xerrors.Errorf("failed to get latest release: %w", err)
+	}
+	defer func() {
+		_ = body.Close()
+	}()
+	var rel release
+	if err := json.NewDecoder(body).Decode(&rel); err != nil {
+		return release{}, xerrors.Errorf("failed to decode release: %w", err)
+	}
+	return rel, nil
+}
+
+// isNewer reports whether the latest version is newer than the current
+// one. Versions that aren't semantic versions are never newer.
+func isNewer(current string, latest string) bool {
+	current = "v" + strings.TrimPrefix(current, "v")
+	latest = "v" + strings.TrimPrefix(latest, "v")
+	return semver.IsValid(current) && semver.IsValid(latest) && semver.Compare(latest, current) > 0
+}
+
+// binaryAsset returns the name of the release binary for a platform.
+func binaryAsset(goos string, goarch string) string {
+	name := fmt.Sprintf("agentapi-%s-%s", goos, goarch)
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// parseChecksums parses the output of sha256sum into checksums by file
+// name.
+func parseChecksums(data []byte) map[string]string {
+	checksums := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		// sha256sum marks files read in binary mode with a "*".
+		checksums[strings.TrimPrefix(fields[1], "*")] = strings.ToLower(fields[0])
+	}
+	return checksums
+}
+
+// install replaces the executable at exe with the release binary for the
+// current platform, once its checksum is verified.
+func (c *releaseClient) install(ctx context.Context, rel release, exe string) error {
+	name := binaryAsset(runtime.GOOS, runtime.GOARCH)
+	binary, ok := rel.asset(name)
+	if !ok {
+		return xerrors.Errorf("release %s has no binary for %s/%s", rel.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	checksumsFile, ok := rel.asset(checksumsAsset)
+	if !ok {
+		return xerrors.Errorf("release %s has no %s to verify the binary with", rel.TagName, checksumsAsset)
+	}
+
+	body, err := c.get(ctx, checksumsFile.URL, "application/octet-stream")
+	if err != nil {
+		return xerrors.Errorf("failed to download checksums: %w", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(body, 1<<20))
+	_ = body.Close()
+	if err != nil {
+		return xerrors.Errorf("failed to download checksums: %w", err)
+	}
+	expected, ok := parseChecksums(data)[name]
+	if !ok {
+		return xerrors.Errorf("%s of release %s has no checksum for %s", checksumsAsset, rel.TagName, name)
+	}
+
+	info, err := os.Stat(exe)
+	if err != nil {
+		return xerrors.Errorf("failed to stat executable: %w", err)
+	}
+	// The new binary is written next to the executable so that it can be
+	// renamed over it.
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".agentapi-update-*")
+	if err != nil {
+		return xerrors.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	body, err = c.get(ctx, binary.URL, "application/octet-stream")
+	if err != nil {
+		_ = tmp.Close()
+		return xerrors.Errorf("failed to download binary: %w", err)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(body, maxBinarySize))
+	_ = body.Close()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to download binary: %w", err)
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return xerrors.Errorf("checksum mismatch for %s: expected %s, got %s", name, expected, actual)
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0o111); err != nil {
+		return xerrors.Errorf("failed to make binary executable: %w", err)
+	}
+
+	// Windows can't replace a running executable, but it can rename it.
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		_ = os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return xerrors.Errorf("failed to move the current executable aside: %w", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return xerrors.Errorf("failed to replace executable: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package selfupdate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/coder/agentapi/internal/version"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultAPIURL = "https://api.github.com"
+	defaultRepo   = "coder/agentapi"
+)
+
+// githubToken returns the token for GitHub API requests, if any.
+func githubToken() string {
+	if token := os.Getenv("GH_TOKEN"); token != "" {
+		return token
+	}
+	return os.Getenv("GITHUB_TOKEN")
+}
+
+// checkVersion prints whether a newer release than the running version is
+// available.
+func checkVersion(ctx context.Context, out io.Writer, client *releaseClient) error {
+	rel, err := client.latest(ctx)
+	if err != nil {
+		return err
+	}
+	if !isNewer(version.Version, rel.TagName) {
+		_, _ = fmt.Fprintf(out, "agentapi is up to date (latest release: %s)\n", rel.TagName)
+		return nil
+	}
+	_, _ = fmt.Fprintf(out, "A newer version is available: %s (%s)\nRun `agentapi self-update` to install it.\n", rel.TagName, rel.HTMLURL)
+	return nil
+}
+
+// selfUpdate installs the latest release over exe if it is newer than the
+// running version, or regardless with force.
+func selfUpdate(ctx context.Context, out io.Writer, client *releaseClient, exe string, force bool) error {
+	rel, err := client.latest(ctx)
+	if err != nil {
+		return err
+	}
+	if !force && !isNewer(version.Version, rel.TagName) {
+		_, _ = fmt.Fprintf(out, "agentapi %s is up to date\n", version.Version)
+		return nil
+	}
+	_, _ = fmt.Fprintf(out, "Updating agentapi %s to %s...\n", version.Version, rel.TagName)
+	if err := client.install(ctx, rel, exe); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(out, "Installed agentapi %s at %s\n", rel.TagName, exe)
+	return nil
+}
+
+func CreateVersionCmd() *cobra.Command {
+	var check bool
+	var apiURL string
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Print the version",
+		Long:  "Print the version of agentapi. With --check, also look up the latest release on GitHub.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "agentapi %s\n", version.Version)
+			if !check {
+				return nil
+			}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+			return checkVersion(ctx, cmd.OutOrStdout(), newReleaseClient(apiURL, githubToken(), defaultRepo))
+		},
+	}
+	cmd.Flags().BoolVar(&check, "check", false, "Check whether a newer release is available")
+	cmd.Flags().StringVar(&apiURL, "github-api-url", defaultAPIURL, "GitHub REST API URL")
+	return cmd
+}
+
+func CreateSelfUpdateCmd() *cobra.Command {
+	var force bool
+	var apiURL string
+	cmd := &cobra.Command{
+		Use:   "self-update",
+		Short: "Update agentapi to the latest release",
+		Long: "Download the latest release of agentapi from GitHub and replace the running executable with it. " +
+			"The download is verified against the checksums published with the release. Set GH_TOKEN or " +
+			"GITHUB_TOKEN to avoid GitHub's rate limit.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			exe, err := os.Executable()
+			if err != nil {
+				return xerrors.Errorf("failed to find the executable: %w", err)
+			}
+			if exe, err = filepath.EvalSymlinks(exe); err != nil {
+				return xerrors.Errorf("failed to resolve the executable: %w", err)
+			}
+			if strings.HasPrefix(exe, os.TempDir()) {
+				return xerrors.Errorf("refusing to update %s, which looks like a temporary build", exe)
+			}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+			return selfUpdate(ctx, cmd.OutOrStdout(), newReleaseClient(apiURL, githubToken(), defaultRepo), exe, force)
+		},
+	}
+	cmd.Flags().BoolVar(&force, "force", false, "Install the latest release even if it isn't newer than the running version")
+	cmd.Flags().StringVar(&apiURL, "github-api-url", defaultAPIURL, "GitHub REST API URL")
+	return cmd
+}
@@ -0,0 +1,128 @@
+package selfupdate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/coder/agentapi/internal/version"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsNewer(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, isNewer("0.12.1", "v0.13.0"))
+	assert.True(t, isNewer("v0.12.1", "v1.0.0"))
+	assert.False(t, isNewer("0.12.1", "v0.12.1"))
+	assert.False(t, isNewer("0.12.1", "v0.12.0"))
+	assert.False(t, isNewer("0.12.1", "preview"))
+}
+
+func TestParseChecksums(t *testing.T) {
+	t.Parallel()
+
+	checksums := parseChecksums([]byte("ABC123  agentapi-linux-amd64\ndef456 *agentapi-windows-amd64.exe\n\ngarbage\n"))
+	assert.Equal(t, map[string]string{
+		"agentapi-linux-amd64":       "abc123",
+		"agentapi-windows-amd64.exe": "def456",
+	}, checksums)
+}
+
+// releaseServer serves a release with a binary for the current platform,
+// listed in checksums.txt with the given checksum.
+func releaseServer(t *testing.T, tag string, binary []byte, checksum string) *httptest.Server {
+	t.Helper()
+	name := binaryAsset(runtime.GOOS, runtime.GOARCH)
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	mux.HandleFunc("/repos/coder/agentapi/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(release{
+			TagName: tag,
+			HTMLURL: "https://github.com/coder/agentapi/releases/tag/" + tag,
+			Assets: []releaseAsset{
+				{Name: name, URL: srv.URL + "/download/" + name},
+				{Name: checksumsAsset, URL: srv.URL + "/download/" + checksumsAsset},
+			},
+		})
+	})
+	mux.HandleFunc("/download/"+name, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(binary)
+	})
+	mux.HandleFunc("/download/"+checksumsAsset, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%s  %s\n", checksum, name)
+	})
+	return srv
+}
+
+func TestSelfUpdate(t *testing.T) {
+	t.Parallel()
+
+	binary := []byte("#!/bin/sh\necho new\n")
+	hash := sha256.Sum256(binary)
+	checksum := hex.EncodeToString(hash[:])
+	newExe := func(t *testing.T) string {
+		exe := filepath.Join(t.TempDir(), "agentapi")
+		require.NoError(t, os.WriteFile(exe, []byte("old"), 0o755))
+		return exe
+	}
+
+	t.Run("update", func(t *testing.T) {
+		t.Parallel()
+		srv := releaseServer(t, "v99.0.0", binary, checksum)
+		exe := newExe(t)
+		var out bytes.Buffer
+		require.NoError(t, selfUpdate(context.Background(), &out, newReleaseClient(srv.URL, "", defaultRepo), exe, false))
+		content, err := os.ReadFile(exe)
+		require.NoError(t, err)
+		assert.Equal(t, binary, content)
+		assert.Contains(t, out.String(), "Installed agentapi v99.0.0")
+	})
+
+	t.Run("up-to-date", func(t *testing.T) {
+		t.Parallel()
+		srv := releaseServer(t, "v"+version.Version, binary, checksum)
+		exe := newExe(t)
+		var out bytes.Buffer
+		require.NoError(t, selfUpdate(context.Background(), &out, newReleaseClient(srv.URL, "", defaultRepo), exe, false))
+		content, err := os.ReadFile(exe)
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(content))
+		assert.Contains(t, out.String(), "is up to date")
+	})
+
+	t.Run("checksum-mismatch", func(t *testing.T) {
+		t.Parallel()
+		srv := releaseServer(t, "v99.0.0", binary, hex.EncodeToString(make([]byte, 32)))
+		exe := newExe(t)
+		var out bytes.Buffer
+		err := selfUpdate(context.Background(), &out, newReleaseClient(srv.URL, "", defaultRepo), exe, false)
+		require.ErrorContains(t, err, "checksum mismatch")
+		content, err := os.ReadFile(exe)
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(content))
+		// The download is cleaned up.
+		entries, err := os.ReadDir(filepath.Dir(exe))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("check", func(t *testing.T) {
+		t.Parallel()
+		srv := releaseServer(t, "v99.0.0", binary, checksum)
+		var out bytes.Buffer
+		require.NoError(t, checkVersion(context.Background(), &out, newReleaseClient(srv.URL, "", defaultRepo)))
+		assert.Contains(t, out.String(), "A newer version is available: v99.0.0")
+	})
+}
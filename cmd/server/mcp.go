@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// mcpServer is an MCP server of the --mcp-config file, in the format
+// Claude Code, Gemini CLI and Cursor share. Stdio servers have a command,
+// the others a URL.
+type mcpServer struct {
+	Type    string            `json:"type,omitempty"`
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// mcpConfig is the MCP servers given to the agent with --mcp-config.
+type mcpConfig struct {
+	// Path is the absolute path of the --mcp-config file.
+	Path    string
+	Servers map[string]mcpServer
+	// Dir holds the files the config is converted to for agents that
+	// don't read it as is. It is removed when the server stops.
+	Dir string
+}
+
+// loadMCPConfig reads the --mcp-config file, e.g.
+// {"mcpServers": {"docs": {"command": "npx", "args": ["-y", "docs-mcp"]}}}.
+func loadMCPConfig(path string) (*mcpConfig, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to resolve --%s: %w", FlagMCPConfig, err)
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read --%s: %w", FlagMCPConfig, err)
+	}
+	var file struct {
+		MCPServers map[string]mcpServer `json:"mcpServers"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, xerrors.Errorf("failed to parse --%s: %w", FlagMCPConfig, err)
+	}
+	if len(file.MCPServers) == 0 {
+		return nil, xerrors.Errorf("--%s has no mcpServers", FlagMCPConfig)
+	}
+	for name, server := range file.MCPServers {
+		if (server.Command == "") == (server.URL == "") {
+			return nil, xerrors.Errorf("MCP server %q in --%s must have either a command or a url", name, FlagMCPConfig)
+		}
+	}
+	return &mcpConfig{Path: absPath, Servers: file.MCPServers}, nil
+}
+
+// Names returns the names of the MCP servers, sorted.
+func (c *mcpConfig) Names() []string {
+	return slices.Sorted(maps.Keys(c.Servers))
+}
+
+// apply returns the arguments and environment variables that make the agent
+// load the MCP servers, in the way its CLI supports: Claude Code's
+// --mcp-config flag, Gemini CLI's system settings file, or Codex's config
+// overrides. The user's own MCP servers are kept.
+func (c *mcpConfig) apply(agentType AgentType, args []string) ([]string, map[string]string, error) {
+	switch agentType {
+	case AgentTypeClaude:
+		return append(slices.Clone(args), "--mcp-config", c.Path), nil, nil
+	case AgentTypeGemini:
+		path, err := c.writeGeminiSettings()
+		if err != nil {
+			return nil, nil, err
+		}
+		return args, map[string]string{"GEMINI_CLI_SYSTEM_SETTINGS_PATH": path}, nil
+	case AgentTypeCodex:
+		args = slices.Clone(args)
+		for _, name := range c.Names() {
+			args = append(args, "-c", codexMCPOverride(name, c.Servers[name]))
+		}
+		return args, nil, nil
+	default:
+		return nil, nil, xerrors.Errorf("--%s isn't supported for %s, only for claude, gemini and codex", FlagMCPConfig, agentType)
+	}
+}
+
+// writeGeminiSettings writes the MCP servers as a Gemini CLI settings file,
+// which names the URL of streamable HTTP servers httpUrl.
+func (c *mcpConfig) writeGeminiSettings() (string, error) {
+	servers := map[string]map[string]any{}
+	for name, server := range c.Servers {
+		settings := map[string]any{}
+		if server.Command != "" {
+			settings["command"] = server.Command
+			settings["args"] = server.Args
+		} else if server.Type == "http" {
+			settings["httpUrl"] = server.URL
+		} else {
+			settings["url"] = server.URL
+		}
+		if len(server.Env) > 0 {
+			settings["env"] = server.Env
+		}
+		if len(server.Headers) > 0 {
+			settings["headers"] = server.Headers
+		}
+		servers[name] = settings
+	}
+	data, err := json.MarshalIndent(map[string]any{"mcpServers": servers}, "", "  ")
+	if err != nil {
+		return "", xerrors.Errorf("failed to encode Gemini CLI settings: %w", err)
+	}
+	if c.Dir == "" {
+		if c.Dir, err = os.MkdirTemp("", "agentapi-mcp-"); err != nil {
+			return "", xerrors.Errorf("failed to create MCP config directory: %w", err)
+		}
+	}
+	path := filepath.Join(c.Dir, "gemini-settings.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", xerrors.Errorf("failed to write Gemini CLI settings: %w", err)
+	}
+	return path, nil
+}
+
+// tomlBareKeyPattern matches the keys TOML doesn't require to be quoted.
+var tomlBareKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// codexMCPOverride returns the value of Codex's -c flag that adds an MCP
+// server, e.g. mcp_servers.docs={command="npx",args=["-y","docs-mcp"]}.
+// JSON strings are valid TOML strings.
+func codexMCPOverride(name string, server mcpServer) string {
+	quote := func(s string) string {
+		data, _ := json.Marshal(s)
+		return string(data)
+	}
+	table := func(m map[string]string) string {
+		var fields []string
+		for _, k := range slices.Sorted(maps.Keys(m)) {
+			fields = append(fields, fmt.Sprintf("%s=%s", quote(k), quote(m[k])))
+		}
+		return "{" + strings.Join(fields, ",") + "}"
+	}
+
+	var fields []string
+	if server.Command != "" {
+		args := make([]string, len(server.Args))
+		for i, arg := range server.Args {
+			args[i] = quote(arg)
+		}
+		fields = append(fields, "command="+quote(server.Command), "args=["+strings.Join(args, ",")+"]")
+	} else {
+		fields = append(fields, "url="+quote(server.URL))
+	}
+	if len(server.Env) > 0 {
+		fields = append(fields, "env="+table(server.Env))
+	}
+	if len(server.Headers) > 0 {
+		fields = append(fields, "http_headers="+table(server.Headers))
+	}
+	key := name
+	if !tomlBareKeyPattern.MatchString(key) {
+		key = quote(key)
+	}
+	return fmt.Sprintf("mcp_servers.%s={%s}", key, strings.Join(fields, ","))
+}
@@ -2,13 +2,20 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"math"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -20,11 +27,15 @@ import (
 	"github.com/spf13/viper"
 	"golang.org/x/xerrors"
 
+	"github.com/coder/agentapi/internal/version"
 	"github.com/coder/agentapi/lib/httpapi"
 	"github.com/coder/agentapi/lib/logctx"
 	"github.com/coder/agentapi/lib/msgfmt"
+	"github.com/coder/agentapi/lib/sandbox"
 	st "github.com/coder/agentapi/lib/screentracker"
 	"github.com/coder/agentapi/lib/termexec"
+	"github.com/coder/agentapi/lib/transport"
+	"github.com/coder/agentapi/x/acpio"
 )
 
 type AgentType = msgfmt.AgentType
@@ -44,6 +55,12 @@ const (
 	AgentTypeCustom   AgentType = msgfmt.AgentTypeCustom
 )
 
+// ExitCodeRunLimit is the exit status of the server when it stopped because
+// --max-turns or --max-run-duration was reached.
+const ExitCodeRunLimit = 3
+
+var errRunLimitReached = xerrors.New("run limit reached")
+
 // agentTypeAliases contains the mapping of possible input agent type strings to their canonical AgentType values
 var agentTypeAliases = map[string]AgentType{
 	"claude":       AgentTypeClaude,
@@ -77,6 +94,246 @@ func parseAgentType(firstArg string, agentTypeVar string) (AgentType, error) {
 	return AgentTypeCustom, nil
 }
 
+// parseTransport resolves the --transport flag, treating the deprecated
+// --experimental-acp flag as an alias for --transport=acp.
+func parseTransport(transportVar string, experimentalACP bool) (string, error) {
+	if !experimentalACP {
+		return transportVar, nil
+	}
+	if viper.IsSet(FlagTransport) && transportVar != acpio.TransportName {
+		return "", xerrors.Errorf("flags --%s and --%s=%s are mutually exclusive", FlagExperimentalACP, FlagTransport, transportVar)
+	}
+	return acpio.TransportName, nil
+}
+
+// parseTransportOptions parses --transport-opt key=value pairs.
+func parseTransportOptions(input []string) (map[string]string, error) {
+	return parseKeyValues("transport option", input)
+}
+
+// parseTags parses --tag values into a map.
+func parseTags(input []string) (map[string]string, error) {
+	return parseKeyValues("tag", input)
+}
+
+func parseKeyValues(kind string, input []string) (map[string]string, error) {
+	values := make(map[string]string, len(input))
+	for _, item := range input {
+		key, value, ok := strings.Cut(item, "=")
+		if !ok || key == "" {
+			return nil, xerrors.Errorf("invalid %s %q, expected key=value", kind, item)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// parseResourceLimits parses --cpu-limit, a number of CPUs, and
+// --memory-limit, a size in bytes with an optional k, m or g suffix.
+func parseResourceLimits(cpu string, memory string) (transport.ResourceLimits, error) {
+	var limits transport.ResourceLimits
+	if cpu != "" {
+		n, err := strconv.ParseFloat(cpu, 64)
+		if err != nil || n <= 0 {
+			return limits, xerrors.Errorf("invalid --%s %q, expected a positive number of CPUs (e.g. 1.5)", FlagCPULimit, cpu)
+		}
+		limits.CPU = n
+	}
+	if memory != "" {
+		n, err := parseSize(FlagMemoryLimit, memory)
+		if err != nil {
+			return limits, err
+		}
+		limits.Memory = n
+	}
+	return limits, nil
+}
+
+// parseSize parses the value of the flag, a size in bytes with an optional
+// k, m or g suffix.
+func parseSize(flag string, value string) (int64, error) {
+	number := strings.ToLower(value)
+	var shift uint
+	switch number[len(number)-1] {
+	case 'k':
+		shift = 10
+	case 'm':
+		shift = 20
+	case 'g':
+		shift = 30
+	}
+	if shift > 0 {
+		number = number[:len(number)-1]
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64>>shift {
+		return 0, xerrors.Errorf("invalid --%s %q, expected a positive size in bytes (e.g. 512m or 2g)", flag, value)
+	}
+	return n << shift, nil
+}
+
+// sandboxAgent returns the command that runs the agent in the sandbox set
+// up by --sandbox and --sandbox-allow, and the sandbox's status. The agent
+// can also read the readOnly paths. With --sandbox-proxy, it also returns
+// the proxy the agent's network access must go through, which the caller
+// must close.
+func sandboxAgent(agentType AgentType, program string, args []string, readOnly []string) (string, []string, *httpapi.SandboxStatus, *sandbox.NetworkProxy, error) {
+	abi, err := sandbox.ABI()
+	if err != nil {
+		return "", nil, nil, nil, xerrors.Errorf("--%s is not available: %w", FlagSandbox, err)
+	}
+	workspace, err := os.Getwd()
+	if err != nil {
+		return "", nil, nil, nil, xerrors.Errorf("failed to get the workspace directory: %w", err)
+	}
+	cfg := sandbox.DefaultConfig(agentType, program, workspace)
+	if err := cfg.Allow(viper.GetStringSlice(FlagSandboxAllow)); err != nil {
+		return "", nil, nil, nil, xerrors.Errorf("invalid --%s: %w", FlagSandboxAllow, err)
+	}
+	cfg.ReadOnly = append(cfg.ReadOnly, readOnly...)
+	exe, err := os.Executable()
+	if err != nil {
+		return "", nil, nil, nil, xerrors.Errorf("failed to find the agentapi executable: %w", err)
+	}
+	var proxy *sandbox.NetworkProxy
+	if viper.GetBool(FlagSandboxProxy) {
+		if proxy, err = sandbox.NewNetworkProxy(viper.GetStringSlice(FlagSandboxAllowDomain)); err != nil {
+			return "", nil, nil, nil, xerrors.Errorf("failed to start the network proxy: %w", err)
+		}
+		cfg.ProxyPort = proxy.Port()
+	}
+	program, args = cfg.Command(exe, program, args)
+	return program, args, &httpapi.SandboxStatus{
+		Mechanism:   "landlock",
+		ABI:         abi,
+		ReadWrite:   cfg.ReadWrite,
+		ReadOnly:    cfg.ReadOnly,
+		Limitations: sandbox.Limitations(abi, proxy != nil),
+	}, proxy, nil
+}
+
+func logContentPolicyNames() []string {
+	names := make([]string, len(logctx.ContentPolicyValues))
+	for i, policy := range logctx.ContentPolicyValues {
+		names[i] = string(policy)
+	}
+	return names
+}
+
+func pushEventNames() []string {
+	names := make([]string, 0, len(httpapi.PushEventValues))
+	for _, event := range httpapi.PushEventValues {
+		names = append(names, string(event))
+	}
+	return names
+}
+
+func parsePushEvents(input []string) ([]httpapi.PushEvent, error) {
+	events := make([]httpapi.PushEvent, 0, len(input))
+	for _, item := range input {
+		event := httpapi.PushEvent(strings.TrimSpace(item))
+		if !slices.Contains(httpapi.PushEventValues, event) {
+			return nil, xerrors.Errorf("invalid push event %q, expected one of: %s", item, strings.Join(pushEventNames(), ", "))
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// githubToken returns the token POST /git/pr uses, read from the same
+// environment variables as the gh CLI.
+func githubToken() string {
+	if token := os.Getenv("GH_TOKEN"); token != "" {
+		return token
+	}
+	return os.Getenv("GITHUB_TOKEN")
+}
+
+// coderConfig reads the workspace agent credentials Coder provides to
+// workspaces. The app slug falls back to the variable Coder's MCP server
+// reads it from.
+func coderConfig() httpapi.CoderConfig {
+	appSlug := viper.GetString(FlagCoderAppSlug)
+	if appSlug == "" {
+		appSlug = os.Getenv("CODER_MCP_APP_STATUS_SLUG")
+	}
+	return httpapi.CoderConfig{
+		AgentURL:   os.Getenv("CODER_AGENT_URL"),
+		AgentToken: os.Getenv("CODER_AGENT_TOKEN"),
+		AppSlug:    appSlug,
+	}
+}
+
+// teeMaxFiles is the number of rotated --tee-output files kept.
+const teeMaxFiles = 5
+
+// geminiACPFlag makes Gemini CLI speak ACP on stdin and stdout.
+const geminiACPFlag = "--experimental-acp"
+
+// supportsGeminiACP reports whether program's help output lists the ACP flag.
+// The probe is cancelled with ctx.
+func supportsGeminiACP(ctx context.Context, program string) bool {
+	return transport.HelpMentions(ctx, program, geminiACPFlag)
+}
+
+// geminiACPArgs returns the Gemini command line with the ACP flag added,
+// and whether ACP can be used at all.
+func geminiACPArgs(args []string, probe func(program string) bool) ([]string, bool) {
+	if slices.Contains(args[1:], geminiACPFlag) {
+		return args, true
+	}
+	if !probe(args[0]) {
+		return nil, false
+	}
+	return append(slices.Clone(args), geminiACPFlag), true
+}
+
+// secretFlags are redacted from the environment recorded in the state file.
+var secretFlags = []string{FlagNtfyToken, FlagPushoverToken, FlagPushoverUser, FlagOTLPLogsHeader}
+
+// agentVersion returns the first line program prints for --version, or ""
+// if it fails.
+func agentVersion(program string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, program, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(line)
+}
+
+// stateEnvironment records how the agent is run, to be saved along with
+// its conversation.
+func stateEnvironment(agentType AgentType, agentVersion string, profile termexec.Profile) (*st.Environment, error) {
+	flags := map[string]string{}
+	for _, key := range viper.AllKeys() {
+		if !viper.IsSet(key) {
+			continue
+		}
+		if slices.Contains(secretFlags, key) {
+			flags[key] = "redacted"
+			continue
+		}
+		flags[key] = fmt.Sprint(viper.Get(key))
+	}
+	profileJSON, err := json.Marshal(profile)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to encode the terminal profile: %w", err)
+	}
+	profileHash := sha256.Sum256(profileJSON)
+	return &st.Environment{
+		AgentapiVersion: version.Version,
+		AgentType:       string(agentType),
+		AgentVersion:    agentVersion,
+		TerminalWidth:   profile.Width,
+		TerminalHeight:  profile.Height,
+		Flags:           flags,
+		ProfileHash:     hex.EncodeToString(profileHash[:8]),
+	}, nil
+}
+
 func runServer(ctx context.Context, logger *slog.Logger, argsToPass []string) error {
 	agent := argsToPass[0]
 	agentTypeValue := viper.GetString(FlagType)
@@ -85,8 +342,21 @@ func runServer(ctx context.Context, logger *slog.Logger, argsToPass []string) er
 		return xerrors.Errorf("failed to parse agent type: %w", err)
 	}
 
-	termWidth := viper.GetUint16(FlagTermWidth)
-	termHeight := viper.GetUint16(FlagTermHeight)
+	// Each agent type has a terminal it works well in. The flags override
+	// its size and environment.
+	profile := termexec.AgentProfile(agentType)
+	termWidth, termHeight := profile.Width, profile.Height
+	if viper.IsSet(FlagTermWidth) {
+		termWidth = viper.GetUint16(FlagTermWidth)
+	}
+	if viper.IsSet(FlagTermHeight) {
+		termHeight = viper.GetUint16(FlagTermHeight)
+	}
+	termEnv, err := parseKeyValues("terminal environment variable", viper.GetStringSlice(FlagTermEnv))
+	if err != nil {
+		return err
+	}
+	maps.Copy(profile.Env, termEnv)
 
 	if termWidth < 10 {
 		return xerrors.Errorf("term width must be at least 10")
@@ -95,6 +365,140 @@ func runServer(ctx context.Context, logger *slog.Logger, argsToPass []string) er
 		return xerrors.Errorf("term height must be at least 10")
 	}
 
+	autoCompactThreshold := viper.GetInt(FlagAutoCompactThreshold)
+	if autoCompactThreshold < 0 || autoCompactThreshold > 100 {
+		return xerrors.Errorf("--%s must be between 0 and 100", FlagAutoCompactThreshold)
+	}
+
+	requestLog := httpapi.RequestLogConfig{
+		All:           viper.GetBool(FlagLogRequests),
+		SlowThreshold: viper.GetDuration(FlagSlowRequestThreshold),
+	}
+	if requestLog.SlowThreshold < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagSlowRequestThreshold)
+	}
+
+	nudge := httpapi.NudgeConfig{
+		After:   viper.GetDuration(FlagNudgeAfter),
+		Message: viper.GetString(FlagNudgeMessage),
+		Max:     viper.GetInt(FlagNudgeMax),
+	}
+	if nudge.After < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagNudgeAfter)
+	}
+	if nudge.Max < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagNudgeMax)
+	}
+
+	runLimits := httpapi.RunLimits{
+		MaxTurns:     viper.GetInt(FlagMaxTurns),
+		MaxDuration:  viper.GetDuration(FlagMaxRunDuration),
+		WrapUpPrompt: viper.GetString(FlagWrapUpPrompt),
+	}
+	if runLimits.MaxTurns < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagMaxTurns)
+	}
+	if runLimits.MaxDuration < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagMaxRunDuration)
+	}
+
+	maxMessagesPerMinute := viper.GetInt(FlagMaxMessagesPerMinute)
+	if maxMessagesPerMinute < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagMaxMessagesPerMinute)
+	}
+	// The servers of the sessions share the limiter, so that the limit
+	// applies across sessions.
+	messageLimiter := httpapi.NewMessageRateLimiter(nil, maxMessagesPerMinute)
+	duplicatePrompts := httpapi.DuplicatePromptConfig{
+		Window: viper.GetInt(FlagDuplicatePrompts),
+		Strict: viper.GetBool(FlagRejectDuplicates),
+	}
+	if duplicatePrompts.Window < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagDuplicatePrompts)
+	}
+	if duplicatePrompts.Strict && duplicatePrompts.Window == 0 {
+		return xerrors.Errorf("--%s requires --%s", FlagRejectDuplicates, FlagDuplicatePrompts)
+	}
+
+	retentionMaxSizeMB := viper.GetInt(FlagRetentionMaxSizeMB)
+	if retentionMaxSizeMB < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagRetentionMaxSizeMB)
+	}
+
+	screenMaxRate := viper.GetInt(FlagScreenMaxRate)
+	if screenMaxRate < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagScreenMaxRate)
+	}
+
+	messageWindow := viper.GetInt(FlagMessageWindow)
+	if messageWindow < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagMessageWindow)
+	}
+
+	teeMaxSizeMB := viper.GetInt(FlagTeeMaxSizeMB)
+	if teeMaxSizeMB < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagTeeMaxSizeMB)
+	}
+
+	otlpHeaders, err := parseKeyValues("OTLP header", viper.GetStringSlice(FlagOTLPLogsHeader))
+	if err != nil {
+		return err
+	}
+
+	pushEvents, err := parsePushEvents(viper.GetStringSlice(FlagPushEvents))
+	if err != nil {
+		return err
+	}
+
+	var middleware []st.ConversationMiddleware
+	denyPatterns := viper.GetStringSlice(FlagDenyPattern)
+	rewritePatterns := viper.GetStringSlice(FlagRewritePattern)
+	if len(denyPatterns) > 0 || len(rewritePatterns) > 0 {
+		guardrail, err := httpapi.NewGuardrail(denyPatterns, rewritePatterns)
+		if err != nil {
+			return err
+		}
+		middleware = append(middleware, guardrail)
+	}
+	// Prompts are linted after the guardrail rewrote them.
+	var lintRules []httpapi.PromptLintRule
+	if maxLength := viper.GetInt(FlagPromptMaxLength); maxLength > 0 {
+		lintRules = append(lintRules, httpapi.MaxPromptLength(maxLength))
+	} else if maxLength < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagPromptMaxLength)
+	}
+	if placeholders := viper.GetStringSlice(FlagPromptForbid); len(placeholders) > 0 {
+		lintRules = append(lintRules, httpapi.ForbiddenPlaceholders(placeholders...))
+	}
+	if viper.GetBool(FlagPromptLintTemplates) {
+		lintRules = append(lintRules, httpapi.UnresolvedTemplateVars())
+	}
+	if len(lintRules) > 0 {
+		middleware = append(middleware, httpapi.NewPromptLinter(lintRules...))
+	}
+
+	outputFilterAction := httpapi.OutputFilterAction(viper.GetString(FlagOutputFilterAction))
+	if !slices.Contains(httpapi.OutputFilterActionValues, outputFilterAction) {
+		return xerrors.Errorf("invalid --%s %q, expected %q or %q", FlagOutputFilterAction, outputFilterAction, httpapi.OutputFilterFlag, httpapi.OutputFilterRedact)
+	}
+
+	messageColors := httpapi.MessageColors(viper.GetString(FlagMessageColors))
+	if messageColors != "" && !slices.Contains(httpapi.MessageColorsValues, messageColors) {
+		return xerrors.Errorf("invalid --%s %q, expected %q or %q", FlagMessageColors, messageColors, httpapi.MessageColorsANSI, httpapi.MessageColorsHTML)
+	}
+
+	ttl := viper.GetDuration(FlagTTL)
+	if ttl < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagTTL)
+	}
+	drainTimeout := viper.GetDuration(FlagDrainTimeout)
+	if drainTimeout < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagDrainTimeout)
+	}
+	if viper.GetDuration(FlagCORSMaxAge) < 0 {
+		return xerrors.Errorf("--%s must not be negative", FlagCORSMaxAge)
+	}
+
 	// Read stdin if it's piped, to be used as initial prompt
 	initialPrompt := viper.GetString(FlagInitialPrompt)
 	if initialPrompt == "" {
@@ -134,10 +538,153 @@ func runServer(ctx context.Context, logger *slog.Logger, argsToPass []string) er
 			return xerrors.Errorf("--save-state requires --state-file to be set")
 		}
 	}
+	var environment *st.Environment
+	if stateFile != "" {
+		// Custom agents may not support --version.
+		var binaryVersion string
+		if agentType != AgentTypeCustom {
+			binaryVersion = agentVersion(agent)
+		}
+		environment, err = stateEnvironment(agentType, binaryVersion, termexec.Profile{Width: termWidth, Height: termHeight, Env: maps.Clone(profile.Env)})
+		if err != nil {
+			return err
+		}
+	}
 
-	experimentalACP := viper.GetBool(FlagExperimentalACP)
+	transportName, err := parseTransport(viper.GetString(FlagTransport), viper.GetBool(FlagExperimentalACP))
+	if err != nil {
+		return err
+	}
+	printOpenAPI := viper.GetBool(FlagPrintOpenAPI)
+	// Prefer ACP for Gemini unless the user picked a transport or needs
+	// something ACP doesn't support.
+	if agentType == AgentTypeGemini && !viper.IsSet(FlagTransport) && !viper.GetBool(FlagExperimentalACP) &&
+		!printOpenAPI && !saveState && !loadState && !viper.IsSet(FlagCPULimit) && !viper.IsSet(FlagMemoryLimit) &&
+		!viper.GetBool(FlagSandbox) {
+		if args, ok := geminiACPArgs(argsToPass, func(program string) bool { return supportsGeminiACP(ctx, program) }); ok {
+			logger.Info("Gemini CLI supports ACP, using the ACP transport", "flag", geminiACPFlag)
+			transportName = acpio.TransportName
+			argsToPass = args
+		}
+	}
+	tr, err := transport.Lookup(transportName)
+	if err != nil {
+		return xerrors.Errorf("failed to parse transport: %w", err)
+	}
 
-	if experimentalACP && (saveState || loadState) {
+	limits, err := parseResourceLimits(viper.GetString(FlagCPULimit), viper.GetString(FlagMemoryLimit))
+	if err != nil {
+		return err
+	}
+	if !limits.IsZero() && transportName != termexec.TransportName {
+		return xerrors.Errorf("--%s and --%s are only supported with the %s transport", FlagCPULimit, FlagMemoryLimit, termexec.TransportName)
+	}
+
+	var mcpServers []string
+	var mcpPaths []string
+	if path := viper.GetString(FlagMCPConfig); path != "" && !printOpenAPI {
+		mcp, err := loadMCPConfig(path)
+		if err != nil {
+			return err
+		}
+		args, env, err := mcp.apply(agentType, argsToPass)
+		if mcp.Dir != "" {
+			defer func() { _ = os.RemoveAll(mcp.Dir) }()
+			mcpPaths = append(mcpPaths, mcp.Dir)
+		}
+		if err != nil {
+			return err
+		}
+		argsToPass = args
+		maps.Copy(profile.Env, env)
+		mcpServers = mcp.Names()
+		mcpPaths = append(mcpPaths, mcp.Path)
+		logger.Info("Giving the agent MCP servers", "servers", mcpServers)
+	}
+
+	program, programArgs := agent, argsToPass[1:]
+	var sandboxStatus *httpapi.SandboxStatus
+	// Left nil unless set, since a nil proxy in the interface isn't nil.
+	var networkAllowlist httpapi.NetworkAllowlist
+	if viper.GetBool(FlagSandbox) && !printOpenAPI {
+		if transportName != termexec.TransportName {
+			return xerrors.Errorf("--%s is only supported with the %s transport", FlagSandbox, termexec.TransportName)
+		}
+		var proxy *sandbox.NetworkProxy
+		if program, programArgs, sandboxStatus, proxy, err = sandboxAgent(agentType, program, programArgs, mcpPaths); err != nil {
+			return err
+		}
+		logger.Info("Sandboxing the agent with Landlock", "abi", sandboxStatus.ABI, "readWrite", sandboxStatus.ReadWrite)
+		if proxy != nil {
+			defer func() { _ = proxy.Close() }()
+			maps.Copy(profile.Env, proxy.Env())
+			networkAllowlist = proxy
+			logger.Info("Restricting the agent's network access", "proxyPort", proxy.Port(), "allowedDomains", proxy.Allowed())
+		}
+	} else if viper.GetBool(FlagSandboxProxy) || len(viper.GetStringSlice(FlagSandboxAllowDomain)) > 0 {
+		if !printOpenAPI {
+			return xerrors.Errorf("--%s and --%s require --%s", FlagSandboxProxy, FlagSandboxAllowDomain, FlagSandbox)
+		}
+	}
+
+	transportOptions, err := parseTransportOptions(viper.GetStringSlice(FlagTransportOpt))
+	if err != nil {
+		return err
+	}
+
+	tags, err := parseTags(viper.GetStringSlice(FlagTag))
+	if err != nil {
+		return err
+	}
+
+	sessionSpecs, err := parseSessions(viper.GetStringSlice(FlagSession))
+	if err != nil {
+		return err
+	}
+	var sessions *httpapi.Sessions
+	if len(sessionSpecs) > 0 || viper.GetBool(FlagSessionStart) {
+		if viper.GetBool(FlagSandbox) {
+			return xerrors.Errorf("--%s and --%s aren't supported with --%s", FlagSession, FlagSessionStart, FlagSandbox)
+		}
+		maxSessions := viper.GetInt(FlagMaxSessions)
+		if maxSessions < 0 {
+			return xerrors.Errorf("--%s must not be negative", FlagMaxSessions)
+		}
+		if maxSessions > 0 && len(sessionSpecs)+1 > maxSessions {
+			return xerrors.Errorf("%d sessions are started with --%s, more than --%s %d", len(sessionSpecs)+1, FlagSession, FlagMaxSessions, maxSessions)
+		}
+		var maxMemory int64
+		if value := viper.GetString(FlagMaxTotalMemory); value != "" {
+			if maxMemory, err = parseSize(FlagMaxTotalMemory, value); err != nil {
+				return err
+			}
+			// The agents' memory is read from the cgroups of their limits.
+			if limits.IsZero() {
+				return xerrors.Errorf("--%s requires --%s or --%s", FlagMaxTotalMemory, FlagCPULimit, FlagMemoryLimit)
+			}
+		}
+		sessions = httpapi.NewSessions()
+		sessions.SetLimits(maxSessions, maxMemory)
+	}
+
+	pricing := httpapi.PricingConfig{Model: viper.GetString(FlagPricingModel)}
+	if pricing.Model == "" {
+		pricing.Model = transportOptions["model"]
+	}
+	if pricingFile := viper.GetString(FlagPricingFile); pricingFile != "" {
+		data, err := os.ReadFile(pricingFile)
+		if err != nil {
+			return xerrors.Errorf("failed to read pricing file: %w", err)
+		}
+		if pricing.Models, err = httpapi.ParsePricingTable(data); err != nil {
+			return err
+		}
+		if _, ok := pricing.Models[pricing.Model]; !ok {
+			logger.Warn("No price for the agent's model, costs won't be reported", "model", pricing.Model, "pricingFile", pricingFile)
+		}
+	}
+
+	if transportName == acpio.TransportName && (saveState || loadState) {
 		return xerrors.Errorf("ACP mode doesn't support state persistence")
 	}
 
@@ -151,62 +698,150 @@ func runServer(ctx context.Context, logger *slog.Logger, argsToPass []string) er
 		defer cleanupPIDFile(pidFile, logger)
 	}
 
-	printOpenAPI := viper.GetBool(FlagPrintOpenAPI)
+	if printOpenAPI && transportName == acpio.TransportName {
+		return xerrors.Errorf("flag --%s is not supported with the %s transport", FlagPrintOpenAPI, acpio.TransportName)
+	}
 
-	if printOpenAPI && experimentalACP {
-		return xerrors.Errorf("flags --%s and --%s are mutually exclusive", FlagPrintOpenAPI, FlagExperimentalACP)
+	sessionName := viper.GetString(FlagSessionName)
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("agentapi-%d", viper.GetInt(FlagPort))
 	}
 
 	var agentIO st.AgentIO
-	transport := "pty"
-	var process *termexec.Process
-	var acpResult *httpapi.SetupACPResult
-
-	if printOpenAPI {
-		agentIO = nil
-	} else if experimentalACP {
-		var err error
-		acpResult, err = httpapi.SetupACP(ctx, httpapi.SetupACPConfig{
-			Program:     agent,
-			ProgramArgs: argsToPass[1:],
-		})
+	var agentProc *transport.Agent
+	var resourceStats func() (transport.ResourceStats, error)
+	startConfig := transport.StartConfig{
+		Program:        program,
+		ProgramArgs:    programArgs,
+		AgentType:      agentType,
+		TerminalWidth:  termWidth,
+		TerminalHeight: termHeight,
+		SessionName:    sessionName,
+		Limits:         limits,
+		Options:        transportOptions,
+		Env:            profile.Env,
+	}
+	if !printOpenAPI {
+		agentProc, err = tr.Start(ctx, startConfig)
 		if err != nil {
-			return xerrors.Errorf("failed to setup ACP: %w", err)
+			return xerrors.Errorf("failed to start agent: %w", err)
 		}
-		acpIO := acpResult.AgentIO
-		agentIO = acpIO
-		transport = "acp"
-	} else {
-		proc, err := httpapi.SetupProcess(ctx, httpapi.SetupProcessConfig{
-			Program:        agent,
-			ProgramArgs:    argsToPass[1:],
-			TerminalWidth:  termWidth,
-			TerminalHeight: termHeight,
-			AgentType:      agentType,
-		})
-		if err != nil {
-			return xerrors.Errorf("failed to setup process: %w", err)
+		agentIO = agentProc.IO
+		resourceStats = agentProc.ResourceStats
+		if agentProc.Transport != "" {
+			logger.Info("Transport fell back", "from", transportName, "to", agentProc.Transport)
+			transportName = agentProc.Transport
 		}
-		process = proc
-		agentIO = proc
 	}
 	port := viper.GetInt(FlagPort)
-	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+	var attachments httpapi.AttachmentStore
+	if dir := viper.GetString(FlagAttachmentsDir); dir != "" {
+		if attachments, err = httpapi.NewLocalAttachmentStore(dir, nil); err != nil {
+			return err
+		}
+	}
+	chatBasePath := viper.GetString(FlagChatBasePath)
+	if basePath := strings.TrimSuffix(viper.GetString(FlagBasePath), "/"); basePath != "" && !viper.IsSet(FlagChatBasePath) {
+		chatBasePath = basePath + "/chat"
+	}
+	serverConfig := httpapi.ServerConfig{
 		AgentType:      agentType,
 		AgentIO:        agentIO,
-		Transport:      httpapi.Transport(transport),
+		Transport:      httpapi.Transport(transportName),
 		Port:           port,
-		ChatBasePath:   viper.GetString(FlagChatBasePath),
+		ChatBasePath:   chatBasePath,
+		BasePath:       viper.GetString(FlagBasePath),
 		AllowedHosts:   viper.GetStringSlice(FlagAllowedHosts),
 		AllowedOrigins: viper.GetStringSlice(FlagAllowedOrigins),
-		InitialPrompt:  initialPrompt,
+		CORS: httpapi.CORSConfig{
+			AllowedHeaders:      viper.GetStringSlice(FlagCORSAllowedHeaders),
+			AllowedMethods:      viper.GetStringSlice(FlagCORSAllowedMethods),
+			DisallowCredentials: !viper.GetBool(FlagCORSAllowCredentials),
+			MaxAge:              viper.GetDuration(FlagCORSMaxAge),
+			AllowPrivateNetwork: viper.GetBool(FlagCORSPrivateNetwork),
+		},
+		TrustedProxies:       viper.GetStringSlice(FlagTrustedProxies),
+		TerminalWidth:        termWidth,
+		TerminalHeight:       termHeight,
+		ScreenMaxRate:        screenMaxRate,
+		ResourceStats:        resourceStats,
+		Sandbox:              sandboxStatus,
+		ConfiguredMCPServers: mcpServers,
+		Network:              networkAllowlist,
+		CompressionLevel:     viper.GetInt(FlagCompressionLevel),
+		RequestLog:           requestLog,
+		AttachmentStore:      attachments,
+		AttachmentTTL:        viper.GetDuration(FlagAttachmentsTTL),
+		Retention: httpapi.RetentionConfig{
+			MaxAge:  viper.GetDuration(FlagRetentionMaxAge),
+			MaxSize: int64(retentionMaxSizeMB) << 20,
+		},
+		Files: httpapi.FilesConfig{
+			Root:    viper.GetString(FlagFilesRoot),
+			Allow:   viper.GetStringSlice(FlagFilesAllow),
+			MaxSize: int64(viper.GetInt(FlagFilesMaxSizeMB)) << 20,
+		},
+		Artifacts:           viper.GetBool(FlagArtifacts),
+		RecordTerminalInput: viper.GetBool(FlagRecordTerminalInput),
+		HTTP: httpapi.HTTPConfig{
+			ReadTimeout:          viper.GetDuration(FlagReadTimeout),
+			WriteTimeout:         viper.GetDuration(FlagWriteTimeout),
+			IdleTimeout:          viper.GetDuration(FlagIdleTimeout),
+			MaxConcurrentStreams: viper.GetInt(FlagMaxConcurrentStreams),
+			DisableH2C:           !viper.GetBool(FlagH2C),
+		},
+		InitialPrompt: initialPrompt,
 		StatePersistenceConfig: screentracker.StatePersistenceConfig{
-			StateFile: stateFile,
-			LoadState: loadState,
-			SaveState: saveState,
+			StateFile:   stateFile,
+			LoadState:   loadState,
+			SaveState:   saveState,
+			Environment: environment,
 		},
-	})
-
+		FixturesDir:          viper.GetString(FlagFixturesDir),
+		AutoCompactThreshold: autoCompactThreshold,
+		Nudge:                nudge,
+		DrainTimeout:         drainTimeout,
+		RunLimits:            runLimits,
+		Tags:                 tags,
+		MessageRateLimiter:   messageLimiter,
+		DuplicatePrompts:     duplicatePrompts,
+		Sessions:             sessions,
+		Tee: httpapi.TeeConfig{
+			Path:     viper.GetString(FlagTeeOutput),
+			Screens:  viper.GetBool(FlagTeeScreens),
+			MaxBytes: int64(teeMaxSizeMB) << 20,
+			MaxFiles: teeMaxFiles,
+		},
+		LogExport: httpapi.LogExportConfig{
+			Syslog:       viper.GetString(FlagSyslog),
+			OTLPEndpoint: viper.GetString(FlagOTLPLogsEndpoint),
+			OTLPHeaders:  otlpHeaders,
+		},
+		OnMessageCmd: viper.GetString(FlagOnMessageCmd),
+		Push: httpapi.PushConfig{
+			NtfyTopic:     viper.GetString(FlagNtfyTopic),
+			NtfyToken:     viper.GetString(FlagNtfyToken),
+			PushoverToken: viper.GetString(FlagPushoverToken),
+			PushoverUser:  viper.GetString(FlagPushoverUser),
+			Events:        pushEvents,
+		},
+		GitHub: httpapi.GitHubConfig{
+			Token: githubToken(),
+		},
+		Coder:      coderConfig(),
+		Middleware: middleware,
+		OutputFilter: httpapi.OutputFilterConfig{
+			Patterns: viper.GetStringSlice(FlagOutputFilter),
+			Action:   outputFilterAction,
+		},
+		Markdown:      viper.GetBool(FlagMarkdown),
+		MessageBlocks: viper.GetBool(FlagMessageBlocks),
+		Pricing:       pricing,
+		DebugMessages: viper.GetBool(FlagDebugMessages),
+		MessageWindow: messageWindow,
+		MessageColors: messageColors,
+	}
+	srv, err := httpapi.NewServer(ctx, serverConfig)
 	if err != nil {
 		return xerrors.Errorf("failed to create server: %w", err)
 	}
@@ -215,6 +850,35 @@ func runServer(ctx context.Context, logger *slog.Logger, argsToPass []string) er
 		return nil
 	}
 
+	// The other sessions are stopped once the default session's server,
+	// which serves their API, has shut down.
+	if sessions != nil {
+		if err := sessions.Add(httpapi.DefaultSessionID, srv, nil); err != nil {
+			return err
+		}
+		defer sessions.StopAll()
+		for _, spec := range sessionSpecs {
+			sess, err := startSession(ctx, logger, tr, spec, startConfig, serverConfig, termEnv)
+			if err != nil {
+				return err
+			}
+			if err := sessions.Add(spec.ID, sess.srv, func() { sess.stop(logger) }); err != nil {
+				sess.stop(logger)
+				return err
+			}
+		}
+		if viper.GetBool(FlagSessionStart) {
+			sessions.SetStarter(func(id string, opts httpapi.SessionOptions) (*httpapi.Server, func(), error) {
+				spec := sessionSpec{ID: id, Program: opts.Command[0], Args: opts.Command[1:], Tags: opts.Tags}
+				sess, err := startSession(ctx, logger, tr, spec, startConfig, serverConfig, termEnv)
+				if err != nil {
+					return nil, nil, err
+				}
+				return sess.srv, func() { sess.stop(logger) }, nil
+			})
+		}
+	}
+
 	// Create a context for graceful shutdown
 	gracefulCtx, gracefulCancel := context.WithCancel(ctx)
 	defer gracefulCancel()
@@ -222,35 +886,44 @@ func runServer(ctx context.Context, logger *slog.Logger, argsToPass []string) er
 	// Setup signal handlers (they will call gracefulCancel)
 	handleSignals(gracefulCtx, gracefulCancel, logger, srv)
 
+	// Shut down like on SIGTERM once the TTL expires, so the state is saved
+	// before the agent is stopped.
+	if ttl > 0 {
+		ttlTimer := time.AfterFunc(ttl, func() {
+			logger.Info("Server TTL expired, shutting down", "ttl", ttl)
+			gracefulCancel()
+		})
+		defer ttlTimer.Stop()
+	}
+
+	// Shut down the same way once a run limit is reached, and exit with
+	// ExitCodeRunLimit so batch orchestration can tell the budget ran out.
+	// Draining, with POST /drain or SIGTERM, ends the same way.
+	go func() {
+		select {
+		case <-srv.RunLimitReached():
+			logger.Info("Run limit reached, shutting down")
+			gracefulCancel()
+		case <-srv.Drained():
+			logger.Info("Drained, shutting down")
+			gracefulCancel()
+		case <-gracefulCtx.Done():
+		}
+	}()
+
 	logger.Info("Starting server on port", "port", port)
 
-	// Monitor process exit
+	// Monitor agent exit
 	processExitCh := make(chan error, 1)
-	if process != nil {
-		go func() {
-			defer close(processExitCh)
-			defer gracefulCancel()
-			if err := process.Wait(); err != nil {
-				if errors.Is(err, termexec.ErrNonZeroExitCode) {
-					processExitCh <- xerrors.Errorf("========\n%s\n========\n: %w", strings.TrimSpace(process.ReadScreen()), err)
-				} else {
-					processExitCh <- xerrors.Errorf("failed to wait for process: %w", err)
-				}
-			}
-		}()
-	}
-	if acpResult != nil {
-		go func() {
-			defer close(processExitCh)
-			defer close(acpResult.Done) // Signal cleanup goroutine to exit
-			if err := acpResult.Wait(); err != nil {
-				processExitCh <- xerrors.Errorf("ACP process exited: %w", err)
-			}
-			if err := srv.Stop(ctx); err != nil {
-				logger.Error("Failed to stop server", "error", err)
-			}
-		}()
-	}
+	go func() {
+		defer close(processExitCh)
+		defer gracefulCancel()
+		err := agentProc.Wait()
+		srv.AgentExited()
+		if err != nil {
+			processExitCh <- err
+		}
+	}()
 
 	// Start the server
 	serverErrCh := make(chan error, 1)
@@ -286,11 +959,16 @@ func runServer(ctx context.Context, logger *slog.Logger, argsToPass []string) er
 			return xerrors.Errorf("agent exited with error: %w", err)
 		}
 	default:
-		// Close the process
-		if err := process.Close(logger, 5*time.Second); err != nil {
-			logger.Error("Failed to close process cleanly", "error", err)
+		// Close the agent
+		if err := agentProc.Close(logger, 5*time.Second); err != nil {
+			logger.Error("Failed to close agent cleanly", "error", err)
 		}
 	}
+	select {
+	case <-srv.RunLimitReached():
+		return errRunLimitReached
+	default:
+	}
 	return nil
 }
 
@@ -367,21 +1045,103 @@ type flagSpec struct {
 }
 
 const (
-	FlagType            = "type"
-	FlagPort            = "port"
-	FlagPrintOpenAPI    = "print-openapi"
-	FlagChatBasePath    = "chat-base-path"
-	FlagTermWidth       = "term-width"
-	FlagTermHeight      = "term-height"
-	FlagAllowedHosts    = "allowed-hosts"
-	FlagAllowedOrigins  = "allowed-origins"
-	FlagExit            = "exit"
-	FlagInitialPrompt   = "initial-prompt"
-	FlagStateFile       = "state-file"
-	FlagLoadState       = "load-state"
-	FlagSaveState       = "save-state"
-	FlagPidFile         = "pid-file"
-	FlagExperimentalACP = "experimental-acp"
+	FlagType                 = "type"
+	FlagPort                 = "port"
+	FlagPrintOpenAPI         = "print-openapi"
+	FlagChatBasePath         = "chat-base-path"
+	FlagTermWidth            = "term-width"
+	FlagTermHeight           = "term-height"
+	FlagTermEnv              = "term-env"
+	FlagAllowedHosts         = "allowed-hosts"
+	FlagAllowedOrigins       = "allowed-origins"
+	FlagExit                 = "exit"
+	FlagInitialPrompt        = "initial-prompt"
+	FlagStateFile            = "state-file"
+	FlagLoadState            = "load-state"
+	FlagSaveState            = "save-state"
+	FlagPidFile              = "pid-file"
+	FlagExperimentalACP      = "experimental-acp"
+	FlagFixturesDir          = "fixtures-dir"
+	FlagTransport            = "transport"
+	FlagTransportOpt         = "transport-opt"
+	FlagMCPConfig            = "mcp-config"
+	FlagAutoCompactThreshold = "auto-compact-threshold"
+	FlagNudgeAfter           = "nudge-after"
+	FlagNudgeMessage         = "nudge-message"
+	FlagNudgeMax             = "nudge-max"
+	FlagMaxTurns             = "max-turns"
+	FlagMaxRunDuration       = "max-run-duration"
+	FlagWrapUpPrompt         = "wrap-up-prompt"
+	FlagTag                  = "tag"
+	FlagTTL                  = "ttl"
+	FlagDrainTimeout         = "drain-timeout"
+	FlagMaxMessagesPerMinute = "max-messages-per-minute"
+	FlagDuplicatePrompts     = "duplicate-prompts"
+	FlagRejectDuplicates     = "reject-duplicates"
+	FlagTeeOutput            = "tee-output"
+	FlagTeeScreens           = "tee-screens"
+	FlagTeeMaxSizeMB         = "tee-max-size-mb"
+	FlagSyslog               = "syslog"
+	FlagOTLPLogsEndpoint     = "otlp-logs-endpoint"
+	FlagOTLPLogsHeader       = "otlp-logs-header"
+	FlagOnMessageCmd         = "on-message-cmd"
+	FlagNtfyTopic            = "ntfy-topic"
+	FlagNtfyToken            = "ntfy-token"
+	FlagPushoverToken        = "pushover-token"
+	FlagPushoverUser         = "pushover-user"
+	FlagPushEvents           = "push-events"
+	FlagCoderAppSlug         = "coder-app-slug"
+	FlagDenyPattern          = "deny-pattern"
+	FlagRewritePattern       = "rewrite-pattern"
+	FlagPromptMaxLength      = "prompt-max-length"
+	FlagPromptForbid         = "prompt-forbid"
+	FlagPromptLintTemplates  = "prompt-lint-templates"
+	FlagOutputFilter         = "output-filter"
+	FlagOutputFilterAction   = "output-filter-action"
+	FlagMarkdown             = "markdown"
+	FlagMessageBlocks        = "message-blocks"
+	FlagMessageColors        = "message-colors"
+	FlagPricingFile          = "pricing-file"
+	FlagPricingModel         = "pricing-model"
+	FlagCORSAllowedHeaders   = "cors-allowed-headers"
+	FlagCORSAllowedMethods   = "cors-allowed-methods"
+	FlagCORSAllowCredentials = "cors-allow-credentials"
+	FlagCORSMaxAge           = "cors-max-age"
+	FlagCORSPrivateNetwork   = "cors-allow-private-network"
+	FlagTrustedProxies       = "trusted-proxies"
+	FlagBasePath             = "base-path"
+	FlagReadTimeout          = "read-timeout"
+	FlagWriteTimeout         = "write-timeout"
+	FlagIdleTimeout          = "idle-timeout"
+	FlagMaxConcurrentStreams = "max-concurrent-streams"
+	FlagH2C                  = "h2c"
+	FlagCompressionLevel     = "compression-level"
+	FlagLogRequests          = "log-requests"
+	FlagSlowRequestThreshold = "slow-request-threshold"
+	FlagAttachmentsDir       = "attachments-dir"
+	FlagAttachmentsTTL       = "attachments-ttl"
+	FlagFilesRoot            = "files-root"
+	FlagFilesAllow           = "files-allow"
+	FlagFilesMaxSizeMB       = "files-max-size-mb"
+	FlagArtifacts            = "artifacts"
+	FlagRecordTerminalInput  = "record-terminal-input"
+	FlagScreenMaxRate        = "screen-max-rate"
+	FlagRetentionMaxAge      = "retention-max-age"
+	FlagRetentionMaxSizeMB   = "retention-max-size-mb"
+	FlagSessionName          = "session-name"
+	FlagSession              = "session"
+	FlagSessionStart         = "session-start"
+	FlagMaxSessions          = "max-sessions"
+	FlagMaxTotalMemory       = "max-total-memory"
+	FlagCPULimit             = "cpu-limit"
+	FlagMemoryLimit          = "memory-limit"
+	FlagSandbox              = "sandbox"
+	FlagSandboxAllow         = "sandbox-allow"
+	FlagSandboxProxy         = "sandbox-proxy"
+	FlagSandboxAllowDomain   = "sandbox-allow-domain"
+	FlagLogContentPolicy     = "log-content-policy"
+	FlagDebugMessages        = "debug-messages"
+	FlagMessageWindow        = "message-window"
 )
 
 func CreateServerCmd() *cobra.Command {
@@ -395,13 +1155,20 @@ func CreateServerCmd() *cobra.Command {
 			if viper.GetBool(FlagExit) {
 				return
 			}
-			logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+			contentPolicy, err := logctx.ParseContentPolicy(viper.GetString(FlagLogContentPolicy))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "--%s: %v\n", FlagLogContentPolicy, err)
+				os.Exit(1)
+			}
+			logger := slog.New(logctx.WithContentPolicy(slog.NewTextHandler(os.Stdout, nil), contentPolicy))
 			if viper.GetBool(FlagPrintOpenAPI) {
 				// We don't want log output here.
 				logger = slog.New(logctx.DiscardHandler)
 			}
 			ctx := logctx.WithLogger(context.Background(), logger)
-			if err := runServer(ctx, logger, cmd.Flags().Args()); err != nil {
+			if err := runServer(ctx, logger, cmd.Flags().Args()); errors.Is(err, errRunLimitReached) {
+				os.Exit(ExitCodeRunLimit)
+			} else if err != nil {
 				fmt.Fprintf(os.Stderr, "%+v\n", err)
 				os.Exit(1)
 			}
@@ -412,19 +1179,101 @@ func CreateServerCmd() *cobra.Command {
 		{FlagType, "t", "", fmt.Sprintf("Override the agent type (one of: %s, custom)", strings.Join(agentNames, ", ")), "string"},
 		{FlagPort, "p", 3284, "Port to run the server on", "int"},
 		{FlagPrintOpenAPI, "P", false, "Print the OpenAPI schema to stdout and exit", "bool"},
-		{FlagChatBasePath, "c", "/chat", "Base path for assets and routes used in the static files of the chat interface", "string"},
-		{FlagTermWidth, "W", uint16(80), "Width of the emulated terminal", "uint16"},
-		{FlagTermHeight, "H", uint16(1000), "Height of the emulated terminal", "uint16"},
+		{FlagChatBasePath, "c", "/chat", "Base path for assets and routes used in the static files of the chat interface (defaults to /chat under --base-path)", "string"},
+		{FlagBasePath, "", "", "Serve all routes under this path prefix (e.g. /agentapi), to run several servers behind one reverse proxy hostname", "string"},
+		{FlagTermWidth, "W", uint16(80), "Width of the emulated terminal (defaults to the agent's profile, 80 for most agents)", "uint16"},
+		{FlagTermHeight, "H", uint16(1000), "Height of the emulated terminal (defaults to the agent's profile, 1000 for most agents)", "uint16"},
+		{FlagTermEnv, "", []string{}, "Environment variable of the agent's terminal as key=value, overriding the agent's profile, may be repeated (e.g. --term-env TERM=xterm-256color)", "stringSlice"},
 		// localhost is the default host for the server. Port is ignored during matching.
 		{FlagAllowedHosts, "a", []string{"localhost", "127.0.0.1", "[::1]"}, "HTTP allowed hosts (hostnames only, no ports). Use '*' for all, comma-separated list via flag, space-separated list via AGENTAPI_ALLOWED_HOSTS env var", "stringSlice"},
 		// localhost:3284 is the default origin when you open the chat interface in your browser. localhost:3000 and 3001 are used during development.
 		{FlagAllowedOrigins, "o", []string{"http://localhost:3284", "http://localhost:3000", "http://localhost:3001"}, "HTTP allowed origins. Use '*' for all, comma-separated list via flag, space-separated list via AGENTAPI_ALLOWED_ORIGINS env var", "stringSlice"},
+		{FlagTrustedProxies, "", []string{}, "Addresses of reverse proxies, as IPs or CIDR ranges, whose X-Forwarded-Host header is checked against --allowed-hosts instead of the host header", "stringSlice"},
+		{FlagCORSAllowedHeaders, "", httpapi.DefaultCORSAllowedHeaders, "Request headers browsers may send with cross-origin requests", "stringSlice"},
+		{FlagCORSAllowedMethods, "", httpapi.DefaultCORSAllowedMethods, "HTTP methods browsers may use in cross-origin requests", "stringSlice"},
+		{FlagCORSAllowCredentials, "", true, "Allow browsers to send cookies and HTTP authentication with cross-origin requests", "bool"},
+		{FlagCORSMaxAge, "", httpapi.DefaultCORSMaxAge, "How long browsers may cache CORS preflight responses", "duration"},
+		{FlagCORSPrivateNetwork, "", false, "Allow pages on public origins in --allowed-origins to reach the server on a private network (Private Network Access preflights)", "bool"},
+		{FlagReadTimeout, "", time.Duration(0), "How long reading a request may take. 0 disables", "duration"},
+		{FlagWriteTimeout, "", time.Duration(0), "How long writing a response may take, except for SSE streams. 0 disables", "duration"},
+		{FlagIdleTimeout, "", httpapi.DefaultIdleTimeout, "How long a keep-alive connection may wait for the next request. 0 disables", "duration"},
+		{FlagMaxConcurrentStreams, "", httpapi.DefaultMaxConcurrentStreams, "Maximum concurrent requests, such as /events subscriptions, on one HTTP/2 connection", "int"},
+		{FlagH2C, "", true, "Accept HTTP/2 without TLS (h2c), so reverse proxies can multiplex subscriptions over one connection", "bool"},
+		{FlagCompressionLevel, "", httpapi.DefaultCompressionLevel, "gzip and deflate level of responses such as /messages and the chat interface's assets, from 1 to 9. Event streams are never compressed. 0 disables", "int"},
+		{FlagLogRequests, "", false, "Log the method, path, status and duration of every API request", "bool"},
+		{FlagSlowRequestThreshold, "", httpapi.DefaultSlowRequestThreshold, "Log API requests that take longer than this to start responding as slow, and count them in agentapi_http_slow_requests_total. Event streams only count until they're set up. 0 disables", "duration"},
+		{FlagAttachmentsDir, "", "", "Directory where POST /attachments stores files, kept across restarts. Defaults to a temporary directory removed when the server stops", "string"},
+		{FlagAttachmentsTTL, "", time.Duration(0), "Delete attachments after this long (e.g. 24h). 0 keeps them", "duration"},
+		{FlagFilesRoot, "", "", "Directory browsed by GET /files. Defaults to the working directory", "string"},
+		{FlagFilesAllow, "", []string{"*"}, "Patterns of the files GET /files may return, relative to --files-root (e.g. 'src,*.md'). A file is allowed if it or a parent directory matches. Hidden files never are. Pass an empty value to disable the file browser", "stringSlice"},
+		{FlagFilesMaxSizeMB, "", 1, "Largest file returned by GET /files, in megabytes", "int"},
+		{FlagArtifacts, "", false, "Snapshot the files allowed by --files-allow that the agent announces (e.g. 'created report.md') or modifies in a turn once the turn completes, returned by GET /messages/{id}/artifacts", "bool"},
+		{FlagRecordTerminalInput, "", true, "Record the lines typed into the agent's terminal with agentapi attach as user messages with source 'terminal'", "bool"},
+		{FlagRetentionMaxAge, "", time.Duration(0), "Delete attachments, uploads and rotated transcripts older than this. 0 disables", "duration"},
+		{FlagRetentionMaxSizeMB, "", 0, "Delete the oldest attachments, uploads or rotated transcripts once they use more than this many megabytes, each. 0 disables", "int"},
+		{FlagSessionName, "", "", "Name marking the agent's processes, so that those left behind by a previous server with the same name are killed at startup. Defaults to agentapi-<port>", "string"},
+		{FlagSession, "", []string{}, "Also host the agent run by this command in a session, as id=command (e.g. --session review='aider --model sonnet'), whose API is served under /sessions/<id>. May be repeated", "stringSlice"},
+		{FlagSessionStart, "", false, "Allow starting sessions with POST /sessions and stopping them with DELETE /sessions/{id}. Anyone who can reach the server can then run any program", "bool"},
+		{FlagMaxSessions, "", 0, "Maximum number of sessions, including the default one, that run at the same time. POST /sessions returns 503 beyond it. 0 means no limit", "int"},
+		{FlagMaxTotalMemory, "", "", "Memory the agents of all sessions can use in total (e.g. 8g) before POST /sessions returns 503. Requires --cpu-limit or --memory-limit, whose cgroups report the agents' memory", "string"},
+		{FlagCPULimit, "", "", "Number of CPUs the agent and its subprocesses can use (e.g. 1.5). Requires Linux with cgroup v2", "string"},
+		{FlagMemoryLimit, "", "", "Memory the agent and its subprocesses can use (e.g. 512m or 2g). Requires Linux with cgroup v2", "string"},
+		{FlagLogContentPolicy, "", string(logctx.ContentPolicyHash), fmt.Sprintf("How prompts and agent messages appear in the server's logs (one of: %s)", strings.Join(logContentPolicyNames(), ", ")), "string"},
+		{FlagSandbox, "", false, "Restrict the agent's filesystem access to the workspace, its settings and --sandbox-allow with Landlock. Requires Linux 5.13 or later", "bool"},
+		{FlagSandboxAllow, "", []string{}, "Path the sandboxed agent can write to, or only read with a :ro suffix (e.g. ~/.npm or ~/.gitconfig:ro)", "stringSlice"},
+		{FlagSandboxProxy, "", false, "Route the sandboxed agent's network access through a proxy that only allows --sandbox-allow-domain and domains allowed with POST /sandbox/network/allow. Requires --sandbox", "bool"},
+		{FlagSandboxAllowDomain, "", []string{}, "Domain the sandboxed agent can reach, along with its subdomains, with --sandbox-proxy (e.g. api.anthropic.com)", "stringSlice"},
+		{FlagScreenMaxRate, "", 0, "Maximum screen updates per second sent to each attached terminal, coalescing faster updates. 0 disables", "int"},
 		{FlagInitialPrompt, "I", "", "Initial prompt for the agent. Recommended only if the agent doesn't support initial prompt in interaction mode. Will be read from stdin if piped (e.g., echo 'prompt' | agentapi server -- my-agent)", "string"},
 		{FlagStateFile, "s", "", "Path to file for saving/loading server state", "string"},
 		{FlagLoadState, "", false, "Load state from state-file on startup (defaults to true when state-file is set)", "bool"},
 		{FlagSaveState, "", false, "Save state to state-file on shutdown (defaults to true when state-file is set)", "bool"},
 		{FlagPidFile, "", "", "Path to file where the server process ID will be written for shutdown scripts", "string"},
-		{FlagExperimentalACP, "", false, "Use experimental ACP transport instead of PTY", "bool"},
+		{FlagTransport, "", termexec.TransportName, fmt.Sprintf("Transport used to talk to the agent (one of: %s)", strings.Join(transport.Names(), ", ")), "string"},
+		{FlagTransportOpt, "", []string{}, "Transport-specific option as key=value, may be repeated (e.g. --transport-opt model=gpt-4o for the http transport)", "stringSlice"},
+		{FlagMCPConfig, "", "", "JSON file of MCP servers, as {\"mcpServers\": {...}}, to give the agent along with its own. Supported for claude, gemini and codex", "string"},
+		{FlagExperimentalACP, "", false, "Use experimental ACP transport instead of PTY (alias for --transport=acp)", "bool"},
+		{FlagFixturesDir, "", "", "Directory where POST /internal/screen/save writes screen captures as msgfmt fixtures (e.g. lib/msgfmt/testdata/format)", "string"},
+		{FlagAutoCompactThreshold, "", 0, "Send the agent's compaction command (e.g. /compact for Claude Code) when the context usage it shows reaches this percentage. 0 disables", "int"},
+		{FlagNudgeAfter, "", time.Duration(0), "Send --nudge-message when the agent has been waiting this long for an answer to its reply (e.g. 10m), for unattended runs. 0 disables", "duration"},
+		{FlagNudgeMessage, "", "continue", "Follow-up message sent by --nudge-after. {n} is replaced with the number of the nudge and {max} with --nudge-max", "string"},
+		{FlagNudgeMax, "", 3, "How many nudges are sent in a row before waiting for the user to send a message", "int"},
+		{FlagMaxTurns, "", 0, fmt.Sprintf("Stop the run once the agent has completed this many turns, exiting with status %d. 0 disables", ExitCodeRunLimit), "int"},
+		{FlagMaxRunDuration, "", time.Duration(0), fmt.Sprintf("Stop the run once it has lasted this long (e.g. 2h), exiting with status %d. 0 disables", ExitCodeRunLimit), "duration"},
+		{FlagWrapUpPrompt, "", "", "Message sent to the agent when --max-turns or --max-run-duration is reached, before the server exits (e.g. 'Summarize your progress')", "string"},
+		{FlagTag, "", []string{}, "Label as key=value reported by GET /status, may be repeated (e.g. --tag project=billing --tag priority=high)", "stringSlice"},
+		{FlagTTL, "", time.Duration(0), "Save state and stop the agent after the server has run this long (e.g. 8h). 0 disables", "duration"},
+		{FlagDrainTimeout, "", httpapi.DefaultDrainTimeout, "How long draining, with POST /drain or SIGTERM, waits for the agent to finish its turn before the server saves state and shuts down anyway", "duration"},
+		{FlagMaxMessagesPerMinute, "", 0, "Reject messages and commands with HTTP 429 once this many were sent in the last minute. 0 disables", "int"},
+		{FlagDuplicatePrompts, "", 0, "Warn in the response to POST /message when a user message is identical to one of this many latest ones, as when automation retries blindly. 0 disables", "int"},
+		{FlagRejectDuplicates, "", false, "Reject the user messages flagged by --duplicate-prompts with HTTP 409 instead of warning, unless they set allow_duplicate", "bool"},
+		{FlagTeeOutput, "", "", "Append every finalized message to this file as JSON lines, independently of --state-file", "string"},
+		{FlagTeeScreens, "", false, "Also append every screen update to --tee-output", "bool"},
+		{FlagTeeMaxSizeMB, "", 100, "Rotate --tee-output once it reaches this size in megabytes, keeping 5 old files. 0 disables rotation", "int"},
+		{FlagSyslog, "", "", "Send conversation events to syslog: 'local' or network://host:port (e.g. udp://logs:514)", "string"},
+		{FlagOTLPLogsEndpoint, "", "", "Send conversation events to this OTLP/HTTP logs endpoint (e.g. http://localhost:4318/v1/logs)", "string"},
+		{FlagOTLPLogsHeader, "", []string{}, "Header sent to --otlp-logs-endpoint as key=value, may be repeated", "stringSlice"},
+		{FlagOnMessageCmd, "", "", "Shell command run for each finalized agent message, with the message as JSON on stdin (e.g. 'jq -r .content | notify-send agentapi')", "string"},
+		{FlagNtfyTopic, "", "", "Send push notifications to this ntfy.sh topic, or to the topic at this URL on another ntfy server", "string"},
+		{FlagNtfyToken, "", "", "Access token for --ntfy-topic", "string"},
+		{FlagPushoverToken, "", "", "Pushover application token. Push notifications are sent through Pushover when this and --pushover-user are set", "string"},
+		{FlagPushoverUser, "", "", "Pushover user key", "string"},
+		{FlagPushEvents, "", []string{string(httpapi.PushEventTurnComplete), string(httpapi.PushEventError)}, fmt.Sprintf("Events that trigger push notifications (any of: %s)", strings.Join(pushEventNames(), ", ")), "stringSlice"},
+		{FlagCoderAppSlug, "", "", "Slug of the Coder workspace app to report coder_report_task calls to. Defaults to $CODER_MCP_APP_STATUS_SLUG; requires CODER_AGENT_URL and CODER_AGENT_TOKEN", "string"},
+		{FlagDenyPattern, "", []string{}, "Reject user messages matching this regular expression with HTTP 422, may be repeated (e.g. --deny-pattern 'rm -rf')", "stringSlice"},
+		{FlagRewritePattern, "", []string{}, "Rewrite user messages as pattern=>replacement before they reach the agent, may be repeated (e.g. --rewrite-pattern 'sk-[A-Za-z0-9]+=>[REDACTED]')", "stringSlice"},
+		{FlagPromptMaxLength, "", 0, "Reject user messages longer than this many characters with HTTP 422. 0 disables", "int"},
+		{FlagPromptForbid, "", []string{}, "Reject user messages containing this placeholder with HTTP 422, may be repeated (e.g. --prompt-forbid '{{' --prompt-forbid TODO)", "stringSlice"},
+		{FlagPromptLintTemplates, "", false, "Reject user messages containing unresolved template variables such as {{ .Issue }} or ${ISSUE} with HTTP 422", "bool"},
+		{FlagOutputFilter, "", []string{}, "Flag or redact agent messages matching this regular expression, or a preset (preset:credentials, preset:email). May be repeated", "stringSlice"},
+		{FlagOutputFilterAction, "", string(httpapi.OutputFilterRedact), "What to do with agent messages matching --output-filter: 'flag' marks them as filtered, 'redact' also replaces the matches", "string"},
+		{FlagMarkdown, "", false, "Also return agent messages converted to markdown, with box tables as markdown tables and code blocks fenced, as content_markdown", "bool"},
+		{FlagMessageBlocks, "", false, "Also return agent messages split into prose, code blocks, tool calls and diffs, as content_blocks", "bool"},
+		{FlagMessageColors, "", "", "Also return agent messages with the colors they have in the terminal, as content_ansi with ANSI escape sequences (ansi) or as content_html with styled spans (html)", "string"},
+		{FlagPricingFile, "", "", `JSON file of model prices in US dollars per million tokens, used to report costs in GET /usage (e.g. {"claude-sonnet-4": {"input": 3, "output": 15}})`, "string"},
+		{FlagPricingModel, "", "", "Model from --pricing-file the agent uses. Defaults to the model transport option", "string"},
+		{FlagDebugMessages, "", false, "Record which screen lines each agent message was parsed from and how confident the parse was, returned by GET /messages?include=debug", "bool"},
+		{FlagMessageWindow, "", 0, "Keep only this many of the latest messages in memory and move older ones to a temporary file, read back when requested. 0 keeps all messages in memory", "int"},
 	}
 
 	for _, spec := range flagSpecs {
@@ -439,6 +1288,8 @@ func CreateServerCmd() *cobra.Command {
 			serverCmd.Flags().Uint16P(spec.name, spec.shorthand, spec.defaultValue.(uint16), spec.usage)
 		case "stringSlice":
 			serverCmd.Flags().StringSliceP(spec.name, spec.shorthand, spec.defaultValue.([]string), spec.usage)
+		case "duration":
+			serverCmd.Flags().DurationP(spec.name, spec.shorthand, spec.defaultValue.(time.Duration), spec.usage)
 		default:
 			panic(fmt.Sprintf("unknown flag type: %s", spec.flagType))
 		}
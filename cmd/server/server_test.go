@@ -5,9 +5,14 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/coder/agentapi/lib/httpapi"
+	"github.com/coder/agentapi/lib/termexec"
+	"github.com/coder/agentapi/lib/transport"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
@@ -234,6 +239,78 @@ func TestServerCmd_AllArgs_Defaults(t *testing.T) {
 		{"term-height default", FlagTermHeight, uint16(1000), func() any { return viper.GetUint16(FlagTermHeight) }},
 		{"allowed-hosts default", FlagAllowedHosts, []string{"localhost", "127.0.0.1", "[::1]"}, func() any { return viper.GetStringSlice(FlagAllowedHosts) }},
 		{"allowed-origins default", FlagAllowedOrigins, []string{"http://localhost:3284", "http://localhost:3000", "http://localhost:3001"}, func() any { return viper.GetStringSlice(FlagAllowedOrigins) }},
+		{"transport default", FlagTransport, "pty", func() any { return viper.GetString(FlagTransport) }},
+		{"auto-compact-threshold default", FlagAutoCompactThreshold, 0, func() any { return viper.GetInt(FlagAutoCompactThreshold) }},
+		{"tag default", FlagTag, []string{}, func() any { return viper.GetStringSlice(FlagTag) }},
+		{"ttl default", FlagTTL, time.Duration(0), func() any { return viper.GetDuration(FlagTTL) }},
+		{"max-messages-per-minute default", FlagMaxMessagesPerMinute, 0, func() any { return viper.GetInt(FlagMaxMessagesPerMinute) }},
+		{"tee-output default", FlagTeeOutput, "", func() any { return viper.GetString(FlagTeeOutput) }},
+		{"tee-screens default", FlagTeeScreens, false, func() any { return viper.GetBool(FlagTeeScreens) }},
+		{"tee-max-size-mb default", FlagTeeMaxSizeMB, 100, func() any { return viper.GetInt(FlagTeeMaxSizeMB) }},
+		{"syslog default", FlagSyslog, "", func() any { return viper.GetString(FlagSyslog) }},
+		{"otlp-logs-endpoint default", FlagOTLPLogsEndpoint, "", func() any { return viper.GetString(FlagOTLPLogsEndpoint) }},
+		{"otlp-logs-header default", FlagOTLPLogsHeader, []string{}, func() any { return viper.GetStringSlice(FlagOTLPLogsHeader) }},
+		{"on-message-cmd default", FlagOnMessageCmd, "", func() any { return viper.GetString(FlagOnMessageCmd) }},
+		{"ntfy-topic default", FlagNtfyTopic, "", func() any { return viper.GetString(FlagNtfyTopic) }},
+		{"ntfy-token default", FlagNtfyToken, "", func() any { return viper.GetString(FlagNtfyToken) }},
+		{"pushover-token default", FlagPushoverToken, "", func() any { return viper.GetString(FlagPushoverToken) }},
+		{"pushover-user default", FlagPushoverUser, "", func() any { return viper.GetString(FlagPushoverUser) }},
+		{"push-events default", FlagPushEvents, []string{"turn_complete", "error"}, func() any { return viper.GetStringSlice(FlagPushEvents) }},
+		{"coder-app-slug default", FlagCoderAppSlug, "", func() any { return viper.GetString(FlagCoderAppSlug) }},
+		{"deny-pattern default", FlagDenyPattern, []string{}, func() any { return viper.GetStringSlice(FlagDenyPattern) }},
+		{"rewrite-pattern default", FlagRewritePattern, []string{}, func() any { return viper.GetStringSlice(FlagRewritePattern) }},
+		{"output-filter default", FlagOutputFilter, []string{}, func() any { return viper.GetStringSlice(FlagOutputFilter) }},
+		{"output-filter-action default", FlagOutputFilterAction, "redact", func() any { return viper.GetString(FlagOutputFilterAction) }},
+		{"markdown default", FlagMarkdown, false, func() any { return viper.GetBool(FlagMarkdown) }},
+		{"mcp-config default", FlagMCPConfig, "", func() any { return viper.GetString(FlagMCPConfig) }},
+		{"message-blocks default", FlagMessageBlocks, false, func() any { return viper.GetBool(FlagMessageBlocks) }},
+		{"debug-messages default", FlagDebugMessages, false, func() any { return viper.GetBool(FlagDebugMessages) }},
+		{"message-window default", FlagMessageWindow, 0, func() any { return viper.GetInt(FlagMessageWindow) }},
+		{"pricing-file default", FlagPricingFile, "", func() any { return viper.GetString(FlagPricingFile) }},
+		{"pricing-model default", FlagPricingModel, "", func() any { return viper.GetString(FlagPricingModel) }},
+		{"cors-allowed-headers default", FlagCORSAllowedHeaders, httpapi.DefaultCORSAllowedHeaders, func() any { return viper.GetStringSlice(FlagCORSAllowedHeaders) }},
+		{"cors-allowed-methods default", FlagCORSAllowedMethods, httpapi.DefaultCORSAllowedMethods, func() any { return viper.GetStringSlice(FlagCORSAllowedMethods) }},
+		{"cors-allow-credentials default", FlagCORSAllowCredentials, true, func() any { return viper.GetBool(FlagCORSAllowCredentials) }},
+		{"cors-max-age default", FlagCORSMaxAge, 5 * time.Minute, func() any { return viper.GetDuration(FlagCORSMaxAge) }},
+		{"cors-allow-private-network default", FlagCORSPrivateNetwork, false, func() any { return viper.GetBool(FlagCORSPrivateNetwork) }},
+		{"trusted-proxies default", FlagTrustedProxies, []string{}, func() any { return viper.GetStringSlice(FlagTrustedProxies) }},
+		{"base-path default", FlagBasePath, "", func() any { return viper.GetString(FlagBasePath) }},
+		{"read-timeout default", FlagReadTimeout, time.Duration(0), func() any { return viper.GetDuration(FlagReadTimeout) }},
+		{"write-timeout default", FlagWriteTimeout, time.Duration(0), func() any { return viper.GetDuration(FlagWriteTimeout) }},
+		{"idle-timeout default", FlagIdleTimeout, 2 * time.Minute, func() any { return viper.GetDuration(FlagIdleTimeout) }},
+		{"max-concurrent-streams default", FlagMaxConcurrentStreams, 1000, func() any { return viper.GetInt(FlagMaxConcurrentStreams) }},
+		{"h2c default", FlagH2C, true, func() any { return viper.GetBool(FlagH2C) }},
+		{"compression-level default", FlagCompressionLevel, 5, func() any { return viper.GetInt(FlagCompressionLevel) }},
+		{"attachments-dir default", FlagAttachmentsDir, "", func() any { return viper.GetString(FlagAttachmentsDir) }},
+		{"attachments-ttl default", FlagAttachmentsTTL, time.Duration(0), func() any { return viper.GetDuration(FlagAttachmentsTTL) }},
+		{"files-root default", FlagFilesRoot, "", func() any { return viper.GetString(FlagFilesRoot) }},
+		{"files-allow default", FlagFilesAllow, []string{"*"}, func() any { return viper.GetStringSlice(FlagFilesAllow) }},
+		{"files-max-size-mb default", FlagFilesMaxSizeMB, 1, func() any { return viper.GetInt(FlagFilesMaxSizeMB) }},
+		{"artifacts default", FlagArtifacts, false, func() any { return viper.GetBool(FlagArtifacts) }},
+		{"record-terminal-input default", FlagRecordTerminalInput, true, func() any { return viper.GetBool(FlagRecordTerminalInput) }},
+		{"screen-max-rate default", FlagScreenMaxRate, 0, func() any { return viper.GetInt(FlagScreenMaxRate) }},
+		{"session-name default", FlagSessionName, "", func() any { return viper.GetString(FlagSessionName) }},
+		{"session default", FlagSession, []string{}, func() any { return viper.GetStringSlice(FlagSession) }},
+		{"session-start default", FlagSessionStart, false, func() any { return viper.GetBool(FlagSessionStart) }},
+		{"max-sessions default", FlagMaxSessions, 0, func() any { return viper.GetInt(FlagMaxSessions) }},
+		{"max-total-memory default", FlagMaxTotalMemory, "", func() any { return viper.GetString(FlagMaxTotalMemory) }},
+		{"cpu-limit default", FlagCPULimit, "", func() any { return viper.GetString(FlagCPULimit) }},
+		{"memory-limit default", FlagMemoryLimit, "", func() any { return viper.GetString(FlagMemoryLimit) }},
+		{"log-content-policy default", FlagLogContentPolicy, "hash", func() any { return viper.GetString(FlagLogContentPolicy) }},
+		{"drain-timeout default", FlagDrainTimeout, 5 * time.Minute, func() any { return viper.GetDuration(FlagDrainTimeout) }},
+		{"duplicate-prompts default", FlagDuplicatePrompts, 0, func() any { return viper.GetInt(FlagDuplicatePrompts) }},
+		{"reject-duplicates default", FlagRejectDuplicates, false, func() any { return viper.GetBool(FlagRejectDuplicates) }},
+		{"prompt-max-length default", FlagPromptMaxLength, 0, func() any { return viper.GetInt(FlagPromptMaxLength) }},
+		{"prompt-forbid default", FlagPromptForbid, []string{}, func() any { return viper.GetStringSlice(FlagPromptForbid) }},
+		{"prompt-lint-templates default", FlagPromptLintTemplates, false, func() any { return viper.GetBool(FlagPromptLintTemplates) }},
+		{"log-requests default", FlagLogRequests, false, func() any { return viper.GetBool(FlagLogRequests) }},
+		{"slow-request-threshold default", FlagSlowRequestThreshold, 5 * time.Second, func() any { return viper.GetDuration(FlagSlowRequestThreshold) }},
+		{"sandbox default", FlagSandbox, false, func() any { return viper.GetBool(FlagSandbox) }},
+		{"sandbox-allow default", FlagSandboxAllow, []string{}, func() any { return viper.GetStringSlice(FlagSandboxAllow) }},
+		{"sandbox-proxy default", FlagSandboxProxy, false, func() any { return viper.GetBool(FlagSandboxProxy) }},
+		{"sandbox-allow-domain default", FlagSandboxAllowDomain, []string{}, func() any { return viper.GetStringSlice(FlagSandboxAllowDomain) }},
+		{"retention-max-age default", FlagRetentionMaxAge, time.Duration(0), func() any { return viper.GetDuration(FlagRetentionMaxAge) }},
+		{"retention-max-size-mb default", FlagRetentionMaxSizeMB, 0, func() any { return viper.GetInt(FlagRetentionMaxSizeMB) }},
 	}
 
 	for _, tt := range tests {
@@ -268,6 +345,61 @@ func TestServerCmd_AllEnvVars(t *testing.T) {
 		{"AGENTAPI_TERM_HEIGHT", "AGENTAPI_TERM_HEIGHT", "500", uint16(500), func() any { return viper.GetUint16(FlagTermHeight) }},
 		{"AGENTAPI_ALLOWED_HOSTS", "AGENTAPI_ALLOWED_HOSTS", "localhost example.com", []string{"localhost", "example.com"}, func() any { return viper.GetStringSlice(FlagAllowedHosts) }},
 		{"AGENTAPI_ALLOWED_ORIGINS", "AGENTAPI_ALLOWED_ORIGINS", "https://example.com http://localhost:3000", []string{"https://example.com", "http://localhost:3000"}, func() any { return viper.GetStringSlice(FlagAllowedOrigins) }},
+		{"AGENTAPI_TRANSPORT", "AGENTAPI_TRANSPORT", "mock", "mock", func() any { return viper.GetString(FlagTransport) }},
+		{"AGENTAPI_AUTO_COMPACT_THRESHOLD", "AGENTAPI_AUTO_COMPACT_THRESHOLD", "80", 80, func() any { return viper.GetInt(FlagAutoCompactThreshold) }},
+		{"AGENTAPI_TAG", "AGENTAPI_TAG", "project=billing priority=high", []string{"project=billing", "priority=high"}, func() any { return viper.GetStringSlice(FlagTag) }},
+		{"AGENTAPI_TTL", "AGENTAPI_TTL", "8h", 8 * time.Hour, func() any { return viper.GetDuration(FlagTTL) }},
+		{"AGENTAPI_MAX_MESSAGES_PER_MINUTE", "AGENTAPI_MAX_MESSAGES_PER_MINUTE", "30", 30, func() any { return viper.GetInt(FlagMaxMessagesPerMinute) }},
+		{"AGENTAPI_TEE_OUTPUT", "AGENTAPI_TEE_OUTPUT", "/tmp/transcript.jsonl", "/tmp/transcript.jsonl", func() any { return viper.GetString(FlagTeeOutput) }},
+		{"AGENTAPI_TEE_SCREENS", "AGENTAPI_TEE_SCREENS", "true", true, func() any { return viper.GetBool(FlagTeeScreens) }},
+		{"AGENTAPI_TEE_MAX_SIZE_MB", "AGENTAPI_TEE_MAX_SIZE_MB", "10", 10, func() any { return viper.GetInt(FlagTeeMaxSizeMB) }},
+		{"AGENTAPI_SYSLOG", "AGENTAPI_SYSLOG", "udp://logs:514", "udp://logs:514", func() any { return viper.GetString(FlagSyslog) }},
+		{"AGENTAPI_OTLP_LOGS_ENDPOINT", "AGENTAPI_OTLP_LOGS_ENDPOINT", "http://localhost:4318/v1/logs", "http://localhost:4318/v1/logs", func() any { return viper.GetString(FlagOTLPLogsEndpoint) }},
+		{"AGENTAPI_OTLP_LOGS_HEADER", "AGENTAPI_OTLP_LOGS_HEADER", "authorization=token", []string{"authorization=token"}, func() any { return viper.GetStringSlice(FlagOTLPLogsHeader) }},
+		{"AGENTAPI_ON_MESSAGE_CMD", "AGENTAPI_ON_MESSAGE_CMD", "cat >> messages.jsonl", "cat >> messages.jsonl", func() any { return viper.GetString(FlagOnMessageCmd) }},
+		{"AGENTAPI_NTFY_TOPIC", "AGENTAPI_NTFY_TOPIC", "my-agents", "my-agents", func() any { return viper.GetString(FlagNtfyTopic) }},
+		{"AGENTAPI_NTFY_TOKEN", "AGENTAPI_NTFY_TOKEN", "tk_123", "tk_123", func() any { return viper.GetString(FlagNtfyToken) }},
+		{"AGENTAPI_PUSHOVER_TOKEN", "AGENTAPI_PUSHOVER_TOKEN", "app-token", "app-token", func() any { return viper.GetString(FlagPushoverToken) }},
+		{"AGENTAPI_PUSHOVER_USER", "AGENTAPI_PUSHOVER_USER", "user-key", "user-key", func() any { return viper.GetString(FlagPushoverUser) }},
+		{"AGENTAPI_PUSH_EVENTS", "AGENTAPI_PUSH_EVENTS", "error", []string{"error"}, func() any { return viper.GetStringSlice(FlagPushEvents) }},
+		{"AGENTAPI_CODER_APP_SLUG", "AGENTAPI_CODER_APP_SLUG", "claude", "claude", func() any { return viper.GetString(FlagCoderAppSlug) }},
+		{"AGENTAPI_DENY_PATTERN", "AGENTAPI_DENY_PATTERN", "sudo", []string{"sudo"}, func() any { return viper.GetStringSlice(FlagDenyPattern) }},
+		{"AGENTAPI_REWRITE_PATTERN", "AGENTAPI_REWRITE_PATTERN", "a=>b", []string{"a=>b"}, func() any { return viper.GetStringSlice(FlagRewritePattern) }},
+		{"AGENTAPI_OUTPUT_FILTER", "AGENTAPI_OUTPUT_FILTER", "preset:email", []string{"preset:email"}, func() any { return viper.GetStringSlice(FlagOutputFilter) }},
+		{"AGENTAPI_OUTPUT_FILTER_ACTION", "AGENTAPI_OUTPUT_FILTER_ACTION", "flag", "flag", func() any { return viper.GetString(FlagOutputFilterAction) }},
+		{"AGENTAPI_MARKDOWN", "AGENTAPI_MARKDOWN", "true", true, func() any { return viper.GetBool(FlagMarkdown) }},
+		{"AGENTAPI_DEBUG_MESSAGES", "AGENTAPI_DEBUG_MESSAGES", "true", true, func() any { return viper.GetBool(FlagDebugMessages) }},
+		{"AGENTAPI_MESSAGE_WINDOW", "AGENTAPI_MESSAGE_WINDOW", "200", 200, func() any { return viper.GetInt(FlagMessageWindow) }},
+		{"AGENTAPI_PRICING_FILE", "AGENTAPI_PRICING_FILE", "/tmp/prices.json", "/tmp/prices.json", func() any { return viper.GetString(FlagPricingFile) }},
+		{"AGENTAPI_PRICING_MODEL", "AGENTAPI_PRICING_MODEL", "claude-sonnet-4", "claude-sonnet-4", func() any { return viper.GetString(FlagPricingModel) }},
+		{"AGENTAPI_CORS_ALLOWED_HEADERS", "AGENTAPI_CORS_ALLOWED_HEADERS", "Content-Type X-Request-Id", []string{"Content-Type", "X-Request-Id"}, func() any { return viper.GetStringSlice(FlagCORSAllowedHeaders) }},
+		{"AGENTAPI_CORS_ALLOWED_METHODS", "AGENTAPI_CORS_ALLOWED_METHODS", "GET POST", []string{"GET", "POST"}, func() any { return viper.GetStringSlice(FlagCORSAllowedMethods) }},
+		{"AGENTAPI_CORS_ALLOW_CREDENTIALS", "AGENTAPI_CORS_ALLOW_CREDENTIALS", "false", false, func() any { return viper.GetBool(FlagCORSAllowCredentials) }},
+		{"AGENTAPI_CORS_MAX_AGE", "AGENTAPI_CORS_MAX_AGE", "1h", time.Hour, func() any { return viper.GetDuration(FlagCORSMaxAge) }},
+		{"AGENTAPI_CORS_ALLOW_PRIVATE_NETWORK", "AGENTAPI_CORS_ALLOW_PRIVATE_NETWORK", "true", true, func() any { return viper.GetBool(FlagCORSPrivateNetwork) }},
+		{"AGENTAPI_READ_TIMEOUT", "AGENTAPI_READ_TIMEOUT", "30s", 30 * time.Second, func() any { return viper.GetDuration(FlagReadTimeout) }},
+		{"AGENTAPI_WRITE_TIMEOUT", "AGENTAPI_WRITE_TIMEOUT", "1m", time.Minute, func() any { return viper.GetDuration(FlagWriteTimeout) }},
+		{"AGENTAPI_IDLE_TIMEOUT", "AGENTAPI_IDLE_TIMEOUT", "5m", 5 * time.Minute, func() any { return viper.GetDuration(FlagIdleTimeout) }},
+		{"AGENTAPI_MAX_CONCURRENT_STREAMS", "AGENTAPI_MAX_CONCURRENT_STREAMS", "2000", 2000, func() any { return viper.GetInt(FlagMaxConcurrentStreams) }},
+		{"AGENTAPI_H2C", "AGENTAPI_H2C", "false", false, func() any { return viper.GetBool(FlagH2C) }},
+		{"AGENTAPI_COMPRESSION_LEVEL", "AGENTAPI_COMPRESSION_LEVEL", "0", 0, func() any { return viper.GetInt(FlagCompressionLevel) }},
+		{"AGENTAPI_ATTACHMENTS_DIR", "AGENTAPI_ATTACHMENTS_DIR", "/var/lib/agentapi/attachments", "/var/lib/agentapi/attachments", func() any { return viper.GetString(FlagAttachmentsDir) }},
+		{"AGENTAPI_ATTACHMENTS_TTL", "AGENTAPI_ATTACHMENTS_TTL", "24h", 24 * time.Hour, func() any { return viper.GetDuration(FlagAttachmentsTTL) }},
+		{"AGENTAPI_FILES_ROOT", "AGENTAPI_FILES_ROOT", "/workspace", "/workspace", func() any { return viper.GetString(FlagFilesRoot) }},
+		{"AGENTAPI_FILES_ALLOW", "AGENTAPI_FILES_ALLOW", "src *.md", []string{"src", "*.md"}, func() any { return viper.GetStringSlice(FlagFilesAllow) }},
+		{"AGENTAPI_FILES_MAX_SIZE_MB", "AGENTAPI_FILES_MAX_SIZE_MB", "5", 5, func() any { return viper.GetInt(FlagFilesMaxSizeMB) }},
+		{"AGENTAPI_SCREEN_MAX_RATE", "AGENTAPI_SCREEN_MAX_RATE", "10", 10, func() any { return viper.GetInt(FlagScreenMaxRate) }},
+		{"AGENTAPI_SESSION_NAME", "AGENTAPI_SESSION_NAME", "review-bot", "review-bot", func() any { return viper.GetString(FlagSessionName) }},
+		{"AGENTAPI_CPU_LIMIT", "AGENTAPI_CPU_LIMIT", "1.5", "1.5", func() any { return viper.GetString(FlagCPULimit) }},
+		{"AGENTAPI_MEMORY_LIMIT", "AGENTAPI_MEMORY_LIMIT", "2g", "2g", func() any { return viper.GetString(FlagMemoryLimit) }},
+		{"AGENTAPI_LOG_CONTENT_POLICY", "AGENTAPI_LOG_CONTENT_POLICY", "omit", "omit", func() any { return viper.GetString(FlagLogContentPolicy) }},
+		{"AGENTAPI_SANDBOX", "AGENTAPI_SANDBOX", "true", true, func() any { return viper.GetBool(FlagSandbox) }},
+		{"AGENTAPI_SANDBOX_ALLOW", "AGENTAPI_SANDBOX_ALLOW", "/opt/cache /srv/data:ro", []string{"/opt/cache", "/srv/data:ro"}, func() any { return viper.GetStringSlice(FlagSandboxAllow) }},
+		{"AGENTAPI_SANDBOX_ALLOW_DOMAIN", "AGENTAPI_SANDBOX_ALLOW_DOMAIN", "github.com pypi.org", []string{"github.com", "pypi.org"}, func() any { return viper.GetStringSlice(FlagSandboxAllowDomain) }},
+		{"AGENTAPI_RETENTION_MAX_AGE", "AGENTAPI_RETENTION_MAX_AGE", "24h", 24 * time.Hour, func() any { return viper.GetDuration(FlagRetentionMaxAge) }},
+		{"AGENTAPI_RETENTION_MAX_SIZE_MB", "AGENTAPI_RETENTION_MAX_SIZE_MB", "512", 512, func() any { return viper.GetInt(FlagRetentionMaxSizeMB) }},
+		{"AGENTAPI_BASE_PATH", "AGENTAPI_BASE_PATH", "/agentapi", "/agentapi", func() any { return viper.GetString(FlagBasePath) }},
+		{"AGENTAPI_TRUSTED_PROXIES", "AGENTAPI_TRUSTED_PROXIES", "127.0.0.1 10.0.0.0/8", []string{"127.0.0.1", "10.0.0.0/8"}, func() any { return viper.GetStringSlice(FlagTrustedProxies) }},
 	}
 
 	for _, tt := range tests {
@@ -479,6 +611,195 @@ func TestServerCmd_AllowedHosts(t *testing.T) {
 	}
 }
 
+func TestParseTransport(t *testing.T) {
+	t.Run("transport flag", func(t *testing.T) {
+		isolateViper(t)
+		serverCmd := CreateServerCmd()
+		setupCommandOutput(t, serverCmd)
+		serverCmd.SetArgs([]string{"--transport", "mock", "--exit", "dummy-command"})
+		require.NoError(t, serverCmd.Execute())
+
+		name, err := parseTransport(viper.GetString(FlagTransport), viper.GetBool(FlagExperimentalACP))
+		require.NoError(t, err)
+		assert.Equal(t, "mock", name)
+	})
+
+	t.Run("experimental-acp is an alias", func(t *testing.T) {
+		isolateViper(t)
+		serverCmd := CreateServerCmd()
+		setupCommandOutput(t, serverCmd)
+		serverCmd.SetArgs([]string{"--experimental-acp", "--exit", "dummy-command"})
+		require.NoError(t, serverCmd.Execute())
+
+		name, err := parseTransport(viper.GetString(FlagTransport), viper.GetBool(FlagExperimentalACP))
+		require.NoError(t, err)
+		assert.Equal(t, "acp", name)
+	})
+
+	t.Run("experimental-acp conflicts with another transport", func(t *testing.T) {
+		isolateViper(t)
+		serverCmd := CreateServerCmd()
+		setupCommandOutput(t, serverCmd)
+		serverCmd.SetArgs([]string{"--experimental-acp", "--transport", "pty", "--exit", "dummy-command"})
+		require.NoError(t, serverCmd.Execute())
+
+		_, err := parseTransport(viper.GetString(FlagTransport), viper.GetBool(FlagExperimentalACP))
+		require.Error(t, err)
+	})
+}
+
+func TestParseTransportOptions(t *testing.T) {
+	options, err := parseTransportOptions([]string{"model=gpt-4o", "system-prompt=a=b", "empty="})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"model": "gpt-4o", "system-prompt": "a=b", "empty": ""}, options)
+
+	_, err = parseTransportOptions([]string{"model"})
+	require.Error(t, err)
+	_, err = parseTransportOptions([]string{"=value"})
+	require.Error(t, err)
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := parseTags([]string{"project=billing", "priority=high"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"project": "billing", "priority": "high"}, tags)
+
+	_, err = parseTags([]string{"billing"})
+	require.Error(t, err)
+}
+
+func TestParseSessions(t *testing.T) {
+	specs, err := parseSessions([]string{"review=aider --model sonnet", "docs=claude"})
+	require.NoError(t, err)
+	assert.Equal(t, []sessionSpec{
+		{ID: "review", Program: "aider", Args: []string{"--model", "sonnet"}},
+		{ID: "docs", Program: "claude", Args: []string{}},
+	}, specs)
+
+	for _, invalid := range []string{"review", "review=", "../review=aider", "default=aider"} {
+		_, err = parseSessions([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+	_, err = parseSessions([]string{"review=aider", "review=claude"})
+	assert.Error(t, err)
+}
+
+func TestParsePushEvents(t *testing.T) {
+	events, err := parsePushEvents([]string{"turn_complete", "error"})
+	require.NoError(t, err)
+	assert.Equal(t, []httpapi.PushEvent{httpapi.PushEventTurnComplete, httpapi.PushEventError}, events)
+
+	_, err = parsePushEvents([]string{"stable"})
+	require.Error(t, err)
+}
+
+func TestParseResourceLimits(t *testing.T) {
+	limits, err := parseResourceLimits("1.5", "512m")
+	require.NoError(t, err)
+	assert.Equal(t, transport.ResourceLimits{CPU: 1.5, Memory: 512 << 20}, limits)
+
+	limits, err = parseResourceLimits("", "2G")
+	require.NoError(t, err)
+	assert.Equal(t, transport.ResourceLimits{Memory: 2 << 30}, limits)
+
+	limits, err = parseResourceLimits("", "")
+	require.NoError(t, err)
+	assert.True(t, limits.IsZero())
+
+	for _, invalid := range [][2]string{{"0", ""}, {"two", ""}, {"", "-1g"}, {"", "2gb"}, {"", "99999999999g"}} {
+		_, err = parseResourceLimits(invalid[0], invalid[1])
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestGeminiACPArgs(t *testing.T) {
+	supported := func(string) bool { return true }
+	unsupported := func(string) bool { return false }
+
+	args, ok := geminiACPArgs([]string{"gemini", "--model", "pro"}, supported)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"gemini", "--model", "pro", "--experimental-acp"}, args)
+
+	_, ok = geminiACPArgs([]string{"gemini"}, unsupported)
+	assert.False(t, ok)
+
+	// The flag is not added twice, and no probe is needed.
+	args, ok = geminiACPArgs([]string{"gemini", "--experimental-acp"}, unsupported)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"gemini", "--experimental-acp"}, args)
+}
+
+func TestMCPConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mcp.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"mcpServers": {
+		"docs": {"command": "npx", "args": ["-y", "docs-mcp"], "env": {"TOKEN": "a\"b"}},
+		"my.search": {"type": "http", "url": "https://search.example.com/mcp", "headers": {"Authorization": "Bearer x"}}
+	}}`), 0o600))
+	mcp, err := loadMCPConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docs", "my.search"}, mcp.Names())
+
+	args, env, err := mcp.apply(AgentTypeClaude, []string{"claude"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"claude", "--mcp-config", path}, args)
+	assert.Empty(t, env)
+
+	args, _, err = mcp.apply(AgentTypeCodex, []string{"codex"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"codex",
+		"-c", `mcp_servers.docs={command="npx",args=["-y","docs-mcp"],env={"TOKEN"="a\"b"}}`,
+		"-c", `mcp_servers."my.search"={url="https://search.example.com/mcp",http_headers={"Authorization"="Bearer x"}}`,
+	}, args)
+
+	args, env, err = mcp.apply(AgentTypeGemini, []string{"gemini"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(mcp.Dir) })
+	assert.Equal(t, []string{"gemini"}, args)
+	settings, err := os.ReadFile(env["GEMINI_CLI_SYSTEM_SETTINGS_PATH"])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"mcpServers": {
+		"docs": {"command": "npx", "args": ["-y", "docs-mcp"], "env": {"TOKEN": "a\"b"}},
+		"my.search": {"httpUrl": "https://search.example.com/mcp", "headers": {"Authorization": "Bearer x"}}
+	}}`, string(settings))
+
+	_, _, err = mcp.apply(AgentTypeAider, []string{"aider"})
+	assert.ErrorContains(t, err, "isn't supported for aider")
+
+	for _, invalid := range []string{`{}`, `{"mcpServers": {"x": {}}}`, `{"mcpServers": {"x": {"command": "a", "url": "b"}}}`, `[`} {
+		require.NoError(t, os.WriteFile(path, []byte(invalid), 0o600))
+		_, err := loadMCPConfig(path)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestStateEnvironment(t *testing.T) {
+	isolateViper(t)
+	viper.Set(FlagTermWidth, 120)
+	viper.Set(FlagPushoverToken, "secret")
+
+	profile := termexec.Profile{Width: 120, Height: 1000, Env: map[string]string{"TERM": "xterm"}}
+	environment, err := stateEnvironment(AgentTypeClaude, "1.0.0 (Claude Code)", profile)
+	require.NoError(t, err)
+	assert.Equal(t, "claude", environment.AgentType)
+	assert.Equal(t, "1.0.0 (Claude Code)", environment.AgentVersion)
+	assert.Equal(t, uint16(120), environment.TerminalWidth)
+	assert.Equal(t, map[string]string{FlagTermWidth: "120", FlagPushoverToken: "redacted"}, environment.Flags)
+	assert.NotEmpty(t, environment.ProfileHash)
+
+	// The hash changes with the profile.
+	profile.Env["TERM"] = "xterm-256color"
+	other, err := stateEnvironment(AgentTypeClaude, "1.0.0 (Claude Code)", profile)
+	require.NoError(t, err)
+	assert.NotEqual(t, environment.ProfileHash, other.ProfileHash)
+}
+
+func TestAgentVersion(t *testing.T) {
+	agent := filepath.Join(t.TempDir(), "agent")
+	require.NoError(t, os.WriteFile(agent, []byte("#!/bin/sh\necho '1.2.3 (Agent)'\necho 'more details'\n"), 0o755))
+	assert.Equal(t, "1.2.3 (Agent)", agentVersion(agent))
+	assert.Empty(t, agentVersion(filepath.Join(t.TempDir(), "missing")))
+}
+
 func TestServerCmd_StatePersistenceFlags(t *testing.T) {
 	// NOTE: These tests use --exit flag to test flag parsing and defaults.
 	// Runtime validation that happens in runServer (e.g., "--load-state requires --state-file")
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/xerrors"
+
+	"github.com/coder/agentapi/lib/httpapi"
+	"github.com/coder/agentapi/lib/logctx"
+	"github.com/coder/agentapi/lib/termexec"
+	"github.com/coder/agentapi/lib/transport"
+)
+
+// sessionSpec is an agent to host in a session next to the one the server
+// is started with.
+type sessionSpec struct {
+	ID      string
+	Program string
+	Args    []string
+	// Tags are added to the server's --tag labels.
+	Tags map[string]string
+}
+
+// parseSessions parses the --session flags, "id=command [args...]". The
+// command is split on whitespace.
+func parseSessions(values []string) ([]sessionSpec, error) {
+	specs := make([]sessionSpec, 0, len(values))
+	ids := map[string]bool{httpapi.DefaultSessionID: true}
+	for _, value := range values {
+		id, command, ok := strings.Cut(value, "=")
+		fields := strings.Fields(command)
+		if !ok || len(fields) == 0 {
+			return nil, xerrors.Errorf("invalid --%s %q, expected id=command", FlagSession, value)
+		}
+		if err := httpapi.ValidateSessionID(id); err != nil {
+			return nil, err
+		}
+		if ids[id] {
+			return nil, xerrors.Errorf("session ID %q is already used", id)
+		}
+		ids[id] = true
+		specs = append(specs, sessionSpec{ID: id, Program: fields[0], Args: fields[1:]})
+	}
+	return specs, nil
+}
+
+// session is a running agent started with --session.
+type session struct {
+	id    string
+	agent *transport.Agent
+	srv   *httpapi.Server
+}
+
+// startSession starts the agent of spec like the default one, with start
+// and config, and creates the server for its conversation. The terminal
+// follows the agent's profile unless its size was set with flags.
+func startSession(ctx context.Context, logger *slog.Logger, tr transport.Transport, spec sessionSpec, start transport.StartConfig, config httpapi.ServerConfig, termEnv map[string]string) (*session, error) {
+	logger = logger.With("session", spec.ID)
+	ctx = logctx.WithLogger(ctx, logger)
+
+	agentType, err := parseAgentType(spec.Program, "")
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse the agent type of session %q: %w", spec.ID, err)
+	}
+	profile := termexec.AgentProfile(agentType)
+	maps.Copy(profile.Env, termEnv)
+	start.Program, start.ProgramArgs = spec.Program, spec.Args
+	start.AgentType = agentType
+	start.Env = profile.Env
+	start.SessionName += "-" + spec.ID
+	if !viper.IsSet(FlagTermWidth) {
+		start.TerminalWidth = profile.Width
+	}
+	if !viper.IsSet(FlagTermHeight) {
+		start.TerminalHeight = profile.Height
+	}
+
+	config.AgentType = agentType
+	config.TerminalWidth, config.TerminalHeight = start.TerminalWidth, start.TerminalHeight
+	config.InitialPrompt = ""
+	config.Sessions = nil
+	// --mcp-config is only given to the default session's agent.
+	config.ConfiguredMCPServers = nil
+	// Requests reach the session through the default session's server,
+	// which serves them under its base path, logs and compresses them.
+	config.BasePath = ""
+	config.CompressionLevel = 0
+	config.RequestLog = httpapi.RequestLogConfig{}
+	config.Tags = maps.Clone(config.Tags)
+	if config.Tags == nil {
+		config.Tags = map[string]string{}
+	}
+	maps.Copy(config.Tags, spec.Tags)
+	config.Tags["session"] = spec.ID
+	if config.StatePersistenceConfig.StateFile != "" {
+		config.StatePersistenceConfig.StateFile += "." + spec.ID
+		var binaryVersion string
+		if agentType != AgentTypeCustom {
+			binaryVersion = agentVersion(spec.Program)
+		}
+		profile := termexec.Profile{Width: start.TerminalWidth, Height: start.TerminalHeight, Env: maps.Clone(profile.Env)}
+		if config.StatePersistenceConfig.Environment, err = stateEnvironment(agentType, binaryVersion, profile); err != nil {
+			return nil, err
+		}
+	}
+	if config.Tee.Path != "" {
+		config.Tee.Path += "." + spec.ID
+	}
+
+	agent, err := tr.Start(ctx, start)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to start the agent of session %q: %w", spec.ID, err)
+	}
+	config.AgentIO = agent.IO
+	config.ResourceStats = agent.ResourceStats
+	if agent.Transport != "" {
+		config.Transport = httpapi.Transport(agent.Transport)
+	}
+	srv, err := httpapi.NewServer(ctx, config)
+	if err != nil {
+		if err := agent.Close(logger, 5*time.Second); err != nil {
+			logger.Error("Failed to close agent cleanly", "error", err)
+		}
+		return nil, xerrors.Errorf("failed to create the server of session %q: %w", spec.ID, err)
+	}
+	logger.Info("Started session", "agentType", agentType)
+
+	go func() {
+		err := agent.Wait()
+		srv.AgentExited()
+		logger.Warn("Session agent exited", "error", err)
+	}()
+	return &session{id: spec.ID, agent: agent, srv: srv}, nil
+}
+
+// stop saves the state of the session, stops its server and closes its
+// agent.
+func (s *session) stop(logger *slog.Logger) {
+	logger = logger.With("session", s.id)
+	if err := s.srv.SaveState("shutdown"); err != nil {
+		logger.Error("Failed to save state during shutdown", "error", err)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.srv.Stop(shutdownCtx); err != nil {
+		logger.Error("Failed to stop session server", "error", err)
+	}
+	if err := s.agent.Close(logger, 5*time.Second); err != nil {
+		logger.Error("Failed to close agent cleanly", "error", err)
+	}
+}
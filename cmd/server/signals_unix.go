@@ -13,7 +13,8 @@ import (
 )
 
 // handleSignals sets up signal handlers for:
-// - SIGTERM, SIGINT, SIGHUP: trigger graceful shutdown by canceling the context
+// - SIGINT, SIGHUP: trigger graceful shutdown by canceling the context
+// - SIGTERM: drain the server first; a second signal shuts down right away
 // - SIGUSR1: save conversation state without exiting
 func handleSignals(ctx context.Context, cancel context.CancelFunc, logger *slog.Logger, srv *httpapi.Server) {
 	// Handle shutdown signals (SIGTERM, SIGINT, SIGHUP)
@@ -22,6 +23,15 @@ func handleSignals(ctx context.Context, cancel context.CancelFunc, logger *slog.
 	go func() {
 		defer signal.Stop(shutdownCh)
 		sig := <-shutdownCh
+		if sig == syscall.SIGTERM {
+			logger.Info("Received SIGTERM, draining before shutting down")
+			srv.Drain()
+			select {
+			case sig = <-shutdownCh:
+			case <-ctx.Done():
+				return
+			}
+		}
 		logger.Info("Received shutdown signal", "signal", sig)
 		cancel()
 	}()
@@ -0,0 +1,142 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const defaultSlackAPIURL = "https://slack.com/api"
+
+type slackMessage struct {
+	Ts      string `json:"ts"`
+	User    string `json:"user"`
+	BotId   string `json:"bot_id"`
+	Subtype string `json:"subtype"`
+	Text    string `json:"text"`
+}
+
+// fromUser reports whether the message was written by a person, as
+// opposed to a bot (including the bridge itself) or a channel event.
+func (m slackMessage) fromUser() bool {
+	return m.User != "" && m.BotId == "" && m.Subtype == ""
+}
+
+// slackClient is the subset of the Slack Web API the bridge uses.
+type slackClient struct {
+	apiURL string
+	token  string
+	client *http.Client
+}
+
+func newSlackClient(apiURL string, token string) *slackClient {
+	return &slackClient{
+		apiURL: strings.TrimRight(apiURL, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// call invokes a Web API method. Slack reports errors in the body with a
+// 200 status.
+func (c *slackClient) call(ctx context.Context, method string, params url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.apiURL+"/"+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return xerrors.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("%s: %w", method, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return xerrors.Errorf("%s: Slack returned %s", method, resp.Status)
+	}
+	var result struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	body := json.NewDecoder(resp.Body)
+	var raw json.RawMessage
+	if err := body.Decode(&raw); err != nil {
+		return xerrors.Errorf("%s: failed to decode response: %w", method, err)
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return xerrors.Errorf("%s: failed to decode response: %w", method, err)
+	}
+	if !result.Ok {
+		return xerrors.Errorf("%s: %s", method, result.Error)
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return xerrors.Errorf("%s: failed to decode response: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// messagesSince returns the messages posted to the channel, or to the
+// thread if thread is set, after oldest, oldest first.
+func (c *slackClient) messagesSince(ctx context.Context, channel string, thread string, oldest string) ([]slackMessage, error) {
+	params := url.Values{"channel": {channel}, "oldest": {oldest}, "limit": {"100"}}
+	method := "conversations.history"
+	if thread != "" {
+		method = "conversations.replies"
+		params.Set("ts", thread)
+	}
+	var resp struct {
+		Messages []slackMessage `json:"messages"`
+	}
+	if err := c.call(ctx, method, params, &resp); err != nil {
+		return nil, err
+	}
+	// oldest is exclusive for conversations.history, but replies always
+	// include the thread's parent message.
+	messages := slices.DeleteFunc(resp.Messages, func(m slackMessage) bool {
+		return !tsAfter(m.Ts, oldest)
+	})
+	slices.SortFunc(messages, func(a, b slackMessage) int {
+		if tsAfter(a.Ts, b.Ts) {
+			return 1
+		}
+		if tsAfter(b.Ts, a.Ts) {
+			return -1
+		}
+		return 0
+	})
+	return messages, nil
+}
+
+func (c *slackClient) postMessage(ctx context.Context, channel string, thread string, text string) error {
+	params := url.Values{"channel": {channel}, "text": {text}}
+	if thread != "" {
+		params.Set("thread_ts", thread)
+	}
+	return c.call(ctx, "chat.postMessage", params, nil)
+}
+
+// tsAfter reports whether the Slack timestamp a is later than b.
+func tsAfter(a, b string) bool {
+	af, errA := strconv.ParseFloat(a, 64)
+	bf, errB := strconv.ParseFloat(b, 64)
+	if errA != nil || errB != nil {
+		return a > b
+	}
+	return af > bf
+}
+
+// slackTs formats t as a Slack timestamp.
+func slackTs(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMicro())/1e6, 'f', 6, 64)
+}
@@ -0,0 +1,253 @@
+// Package slack implements `agentapi slack`, which bridges a Slack channel
+// or thread to a running agentapi server.
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/coder/agentapi/lib/httpapi"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/util"
+	"github.com/spf13/cobra"
+	sse "github.com/tmaxmax/go-sse"
+	"golang.org/x/xerrors"
+)
+
+// maxSlackMessageLength keeps posts well within Slack's limit on message
+// text.
+const maxSlackMessageLength = 35000
+
+// promptRetryInterval is how long to wait before resending a prompt the
+// agent wasn't ready for.
+const promptRetryInterval = 2 * time.Second
+
+type Config struct {
+	// AgentURL is the base URL of the agentapi server.
+	AgentURL string
+	Channel  string
+	// Thread is the timestamp of the thread to bridge. The whole channel
+	// is bridged when empty.
+	Thread       string
+	PollInterval time.Duration
+}
+
+type bridge struct {
+	cfg    Config
+	slack  *slackClient
+	logger *slog.Logger
+}
+
+// run forwards prompts from Slack and posts agent replies until ctx is
+// canceled or the event stream ends.
+func (b *bridge) run(ctx context.Context) error {
+	var messages httpapi.MessagesResponse
+	if err := util.GetJSON(ctx, b.cfg.AgentURL+"/messages", &messages.Body); err != nil {
+		return xerrors.Errorf("failed to reach agentapi server: %w", err)
+	}
+	// Only post messages the agent writes from now on.
+	lastPosted := -1
+	for _, msg := range messages.Body.Messages {
+		lastPosted = max(lastPosted, msg.Id)
+	}
+
+	prompts := make(chan string, 64)
+	go b.pollSlack(ctx, slackTs(time.Now()), prompts)
+	go b.sendPrompts(ctx, prompts)
+	return b.postReplies(ctx, lastPosted)
+}
+
+// pollSlack sends the text of each new user message to prompts.
+func (b *bridge) pollSlack(ctx context.Context, oldest string, prompts chan<- string) {
+	ticker := time.NewTicker(b.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		messages, err := b.slack.messagesSince(ctx, b.cfg.Channel, b.cfg.Thread, oldest)
+		if err != nil {
+			b.logger.Warn("Failed to read Slack messages", "error", err)
+			continue
+		}
+		for _, msg := range messages {
+			oldest = msg.Ts
+			if !msg.fromUser() || strings.TrimSpace(msg.Text) == "" {
+				continue
+			}
+			select {
+			case prompts <- msg.Text:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// sendPrompts sends each prompt to the agent, waiting for the agent to
+// become ready if it is busy.
+func (b *bridge) sendPrompts(ctx context.Context, prompts <-chan string) {
+	for {
+		var prompt string
+		select {
+		case <-ctx.Done():
+			return
+		case prompt = <-prompts:
+		}
+		for {
+			err := postMessage(ctx, b.cfg.AgentURL+"/message", prompt)
+			if err == nil {
+				break
+			}
+			b.logger.Info("Agent is not ready for the next prompt, retrying", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(promptRetryInterval):
+			}
+		}
+	}
+}
+
+// postReplies posts agent messages with an id after lastPosted to Slack
+// each time the agent becomes stable.
+func (b *bridge) postReplies(ctx context.Context, lastPosted int) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.cfg.AgentURL+"/events", nil)
+	if err != nil {
+		return xerrors.Errorf("failed to create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("failed to subscribe to events: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	messages := map[int]httpapi.MessageUpdateBody{}
+	for ev, err := range sse.Read(resp.Body, &sse.ReadConfig{MaxEventSize: 256 * 1024}) {
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return xerrors.Errorf("failed to read events: %w", err)
+		}
+		switch httpapi.EventType(ev.Type) {
+		case httpapi.EventTypeMessageUpdate:
+			var msg httpapi.MessageUpdateBody
+			if err := json.Unmarshal([]byte(ev.Data), &msg); err != nil {
+				return xerrors.Errorf("failed to unmarshal message: %w", err)
+			}
+			if msg.Id > lastPosted && msg.Role == st.ConversationRoleAgent {
+				messages[msg.Id] = msg
+			}
+		case httpapi.EventTypeStatusChange:
+			var status httpapi.StatusChangeBody
+			if err := json.Unmarshal([]byte(ev.Data), &status); err != nil {
+				return xerrors.Errorf("failed to unmarshal status: %w", err)
+			}
+			if status.Status != httpapi.AgentStatusStable {
+				continue
+			}
+			for id := lastPosted + 1; len(messages) > 0; id++ {
+				msg, ok := messages[id]
+				if !ok {
+					continue
+				}
+				delete(messages, id)
+				lastPosted = id
+				if strings.TrimSpace(msg.Message) == "" {
+					continue
+				}
+				if err := b.slack.postMessage(ctx, b.cfg.Channel, b.cfg.Thread, formatReply(msg.Message)); err != nil {
+					b.logger.Error("Failed to post agent message to Slack", "error", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// formatReply wraps a terminal-formatted agent message in a code block so
+// Slack keeps its layout.
+func formatReply(message string) string {
+	message = strings.TrimSpace(message)
+	if runes := []rune(message); len(runes) > maxSlackMessageLength {
+		message = string(runes[:maxSlackMessageLength]) + "\n…"
+	}
+	return "```\n" + strings.ReplaceAll(message, "```", "`​``") + "\n```"
+}
+
+func postMessage(ctx context.Context, url string, content string) error {
+	body, err := json.Marshal(httpapi.MessageRequestBody{Content: content, Type: httpapi.MessageTypeUser})
+	if err != nil {
+		return xerrors.Errorf("failed to marshal message: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("failed to send message: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return xerrors.Errorf("failed to send message: %s", resp.Status)
+	}
+	return nil
+}
+
+func CreateSlackCmd() *cobra.Command {
+	cfg := Config{}
+	var apiURL string
+	cmd := &cobra.Command{
+		Use:   "slack --channel <id>",
+		Short: "Bridge a Slack channel to a running agent",
+		Long: "Bridge a Slack channel or thread to a running agentapi server: messages posted in Slack are sent to the agent " +
+			"as prompts, and the agent's replies are posted back. Set SLACK_BOT_TOKEN to a bot token with the " +
+			"channels:history (or groups:history) and chat:write scopes.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token := os.Getenv("SLACK_BOT_TOKEN")
+			if token == "" {
+				return xerrors.New("SLACK_BOT_TOKEN must be set")
+			}
+			if cfg.Channel == "" {
+				return xerrors.New("--channel is required")
+			}
+			if !strings.HasPrefix(cfg.AgentURL, "http") {
+				cfg.AgentURL = "http://" + cfg.AgentURL
+			}
+			cfg.AgentURL = strings.TrimRight(cfg.AgentURL, "/")
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
+			b := &bridge{
+				cfg:    cfg,
+				slack:  newSlackClient(apiURL, token),
+				logger: slog.New(slog.NewTextHandler(os.Stdout, nil)),
+			}
+			return b.run(ctx)
+		},
+	}
+	cmd.Flags().StringVarP(&cfg.AgentURL, "url", "u", "localhost:3284", "URL of the agentapi server to bridge")
+	cmd.Flags().StringVar(&cfg.Channel, "channel", "", "ID of the Slack channel, e.g. C0123456789")
+	cmd.Flags().StringVar(&cfg.Thread, "thread", "", "Timestamp of a thread in the channel to bridge instead of the whole channel")
+	cmd.Flags().DurationVar(&cfg.PollInterval, "poll-interval", 3*time.Second, "How often to check Slack for new messages")
+	cmd.Flags().StringVar(&apiURL, "slack-api-url", defaultSlackAPIURL, "Slack Web API URL")
+	_ = cmd.Flags().MarkHidden("slack-api-url")
+	return cmd
+}
@@ -0,0 +1,148 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/httpapi"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSlack serves canned channel history and records posted messages.
+type fakeSlack struct {
+	mu       sync.Mutex
+	messages []slackMessage
+	posts    []string
+}
+
+func (f *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_ = r.ParseForm()
+	switch r.URL.Path {
+	case "/conversations.history", "/conversations.replies":
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "messages": f.messages})
+	case "/chat.postMessage":
+		f.posts = append(f.posts, r.Form.Get("thread_ts")+"|"+r.Form.Get("text"))
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true})
+	default:
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": "unknown_method"})
+	}
+}
+
+func (f *fakeSlack) getPosts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.posts...)
+}
+
+func TestSlackClient_MessagesSince(t *testing.T) {
+	t.Parallel()
+	fake := &fakeSlack{messages: []slackMessage{
+		{Ts: "1700000003.000000", User: "U1", Text: "third"},
+		{Ts: "1700000001.000000", User: "U1", Text: "parent"},
+		{Ts: "1700000002.000000", User: "U1", Text: "second"},
+	}}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	client := newSlackClient(srv.URL, "token")
+
+	messages, err := client.messagesSince(t.Context(), "C1", "1700000001.000000", "1700000001.000000")
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "second", messages[0].Text)
+	assert.Equal(t, "third", messages[1].Text)
+
+	err = client.call(t.Context(), "users.list", nil, nil)
+	assert.ErrorContains(t, err, "unknown_method")
+}
+
+func TestSlackMessage_FromUser(t *testing.T) {
+	t.Parallel()
+	assert.True(t, slackMessage{User: "U1", Text: "hi"}.fromUser())
+	assert.False(t, slackMessage{User: "U1", BotId: "B1", Text: "reply"}.fromUser())
+	assert.False(t, slackMessage{User: "U1", Subtype: "channel_join"}.fromUser())
+}
+
+func TestFormatReply(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "```\nhello\n```", formatReply("  hello\n"))
+	assert.NotContains(t, strings.Trim(formatReply("a ``` b"), "`\n"), "```")
+}
+
+func TestBridge(t *testing.T) {
+	t.Parallel()
+	fake := &fakeSlack{}
+	slackSrv := httptest.NewServer(fake)
+	t.Cleanup(slackSrv.Close)
+
+	prompts := make(chan string, 1)
+	agentSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/messages":
+			_ = json.NewEncoder(w).Encode(map[string]any{"messages": []httpapi.Message{
+				{Id: 0, Role: st.ConversationRoleAgent, Content: "Welcome"},
+			}})
+		case "/message":
+			var body httpapi.MessageRequestBody
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			prompts <- body.Content
+			_ = json.NewEncoder(w).Encode(map[string]any{"ok": true})
+		case "/events":
+			w.Header().Set("Content-Type", "text/event-stream")
+			flusher := w.(http.Flusher)
+			writeEvent := func(eventType httpapi.EventType, data any) {
+				b, _ := json.Marshal(data)
+				_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, b)
+				flusher.Flush()
+			}
+			writeEvent(httpapi.EventTypeMessageUpdate, httpapi.MessageUpdateBody{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"})
+			writeEvent(httpapi.EventTypeStatusChange, httpapi.StatusChangeBody{Status: httpapi.AgentStatusStable})
+			select {
+			case prompt := <-prompts:
+				writeEvent(httpapi.EventTypeMessageUpdate, httpapi.MessageUpdateBody{Id: 1, Role: st.ConversationRoleUser, Message: prompt})
+				writeEvent(httpapi.EventTypeMessageUpdate, httpapi.MessageUpdateBody{Id: 2, Role: st.ConversationRoleAgent, Message: "Reply to: " + prompt})
+				writeEvent(httpapi.EventTypeStatusChange, httpapi.StatusChangeBody{Status: httpapi.AgentStatusStable})
+			case <-r.Context().Done():
+			}
+			<-r.Context().Done()
+		}
+	}))
+	t.Cleanup(agentSrv.Close)
+
+	fake.mu.Lock()
+	fake.messages = []slackMessage{
+		{Ts: slackTs(time.Now().Add(time.Hour)), User: "U1", Text: "fix the tests"},
+		{Ts: slackTs(time.Now().Add(2 * time.Hour)), User: "U2", BotId: "B1", Text: "ignored"},
+	}
+	fake.mu.Unlock()
+
+	b := &bridge{
+		cfg: Config{
+			AgentURL:     agentSrv.URL,
+			Channel:      "C1",
+			Thread:       "1700000000.000000",
+			PollInterval: 10 * time.Millisecond,
+		},
+		slack:  newSlackClient(slackSrv.URL, "token"),
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	go func() {
+		_ = b.run(t.Context())
+	}()
+
+	require.Eventually(t, func() bool {
+		return len(fake.getPosts()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"1700000000.000000|```\nReply to: fix the tests\n```"}, fake.getPosts())
+}
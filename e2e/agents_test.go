@@ -0,0 +1,281 @@
+package main_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	agentapisdk "github.com/coder/agentapi-sdk-go"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	agentTestTimeout       = 10 * time.Minute
+	agentOperationTimeout  = 3 * time.Minute
+	agentImageBuildTimeout = 10 * time.Minute
+)
+
+// agentSpec describes how to run a real agent in a container. Versions are
+// pinned so that a failing run points at a change in AgentAPI, and bumping a
+// version is a deliberate change that shows whether the agent's UI still
+// parses.
+type agentSpec struct {
+	// name is the value of AGENTAPI_E2E_AGENTS that selects the agent.
+	name    string
+	version string
+	// dockerfile installs the agent. %s is replaced with the version.
+	dockerfile string
+	// command runs the agent inside the container.
+	command []string
+	// agentType is passed to --type.
+	agentType string
+	// interruptKey is the raw input that stops the agent mid-response.
+	interruptKey string
+	// env lists the environment variables passed through to the container,
+	// typically API keys.
+	env []string
+}
+
+var agentSpecs = []agentSpec{
+	{
+		name:    "claude",
+		version: "2.0.0",
+		dockerfile: `FROM node:22-bookworm-slim
+RUN npm install -g @anthropic-ai/claude-code@%s
+RUN useradd -m agent
+USER agent
+WORKDIR /home/agent/project
+`,
+		command:      []string{"claude"},
+		agentType:    "claude",
+		interruptKey: "\x1b",
+		env:          []string{"ANTHROPIC_API_KEY"},
+	},
+	{
+		name:    "aider",
+		version: "0.86.1",
+		dockerfile: `FROM python:3.12-slim-bookworm
+RUN apt-get update && apt-get install -y --no-install-recommends git && rm -rf /var/lib/apt/lists/*
+RUN pip install --no-cache-dir aider-chat==%s
+RUN useradd -m agent
+USER agent
+WORKDIR /home/agent/project
+RUN git init -q .
+`,
+		command:      []string{"aider", "--model", "sonnet", "--yes-always", "--no-auto-commits"},
+		agentType:    "aider",
+		interruptKey: "\x03",
+		env:          []string{"ANTHROPIC_API_KEY"},
+	},
+	{
+		name:    "goose",
+		version: "1.8.0",
+		dockerfile: `FROM debian:bookworm-slim
+RUN apt-get update && apt-get install -y --no-install-recommends bzip2 ca-certificates curl libxcb1 && rm -rf /var/lib/apt/lists/*
+RUN curl -fsSL https://github.com/block/goose/releases/download/v%s/goose-x86_64-unknown-linux-gnu.tar.bz2 | tar -xj -C /usr/local/bin goose
+RUN useradd -m agent
+USER agent
+WORKDIR /home/agent/project
+`,
+		command:      []string{"goose"},
+		agentType:    "goose",
+		interruptKey: "\x03",
+		env:          []string{"GOOSE_PROVIDER", "GOOSE_MODEL", "ANTHROPIC_API_KEY"},
+	},
+}
+
+// TestAgents runs a standard scenario against real agents in containers to
+// catch regressions caused by changes to their terminal UIs. It's opt-in:
+// set AGENTAPI_E2E_AGENTS to a comma-separated list of agents, e.g.
+// "claude,aider", along with their API keys. Docker is required.
+func TestAgents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	selected := os.Getenv("AGENTAPI_E2E_AGENTS")
+	if selected == "" {
+		t.Skip("Set AGENTAPI_E2E_AGENTS to run the agent matrix")
+	}
+	_, err := exec.LookPath("docker")
+	require.NoError(t, err, "The agent matrix requires docker")
+
+	specs := make(map[string]agentSpec, len(agentSpecs))
+	for _, spec := range agentSpecs {
+		specs[spec.name] = spec
+	}
+	var binaryPath string
+	for _, name := range strings.Split(selected, ",") {
+		spec, ok := specs[strings.TrimSpace(name)]
+		require.True(t, ok, "Unknown agent %q in AGENTAPI_E2E_AGENTS", name)
+		if binaryPath == "" {
+			binaryPath = buildLinuxBinary(t)
+		}
+		t.Run(spec.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), agentTestTimeout)
+			defer cancel()
+			runAgentScenario(ctx, t, spec, binaryPath)
+		})
+	}
+}
+
+// runAgentScenario sends a message, interrupts a long response, then
+// restarts the server and checks that the conversation was restored.
+func runAgentScenario(ctx context.Context, t *testing.T, spec agentSpec, binaryPath string) {
+	image := buildAgentImage(ctx, t, spec)
+	stateDir := t.TempDir()
+	// The container user must be able to write the state file.
+	require.NoError(t, os.Chmod(stateDir, 0o777))
+
+	apiClient, stop := startAgentContainer(ctx, t, spec, image, binaryPath, stateDir)
+	require.NoError(t, waitAgentAPIStable(ctx, t, apiClient, agentOperationTimeout, "startup"))
+
+	// Send
+	_, err := apiClient.PostMessage(ctx, agentapisdk.PostMessageParams{
+		Content: "Reply with the single word pong and nothing else.",
+		Type:    agentapisdk.MessageTypeUser,
+	})
+	require.NoError(t, err, "Failed to send message")
+	require.NoError(t, waitAgentAPIStable(ctx, t, apiClient, agentOperationTimeout, "send"))
+	msgResp, err := apiClient.GetMessages(ctx)
+	require.NoError(t, err, "Failed to get messages")
+	last := msgResp.Messages[len(msgResp.Messages)-1]
+	require.Equal(t, agentapisdk.RoleAgent, last.Role)
+	require.Contains(t, strings.ToLower(last.Content), "pong")
+
+	// Interrupt
+	_, err = apiClient.PostMessage(ctx, agentapisdk.PostMessageParams{
+		Content: "Count from 1 to 1000, writing each number on its own line.",
+		Type:    agentapisdk.MessageTypeUser,
+	})
+	require.NoError(t, err, "Failed to send long message")
+	_, err = apiClient.PostMessage(ctx, agentapisdk.PostMessageParams{
+		Content: spec.interruptKey,
+		Type:    agentapisdk.MessageTypeRaw,
+	})
+	require.NoError(t, err, "Failed to interrupt agent")
+	require.NoError(t, waitAgentAPIStable(ctx, t, apiClient, agentOperationTimeout, "interrupt"))
+	msgResp, err = apiClient.GetMessages(ctx)
+	require.NoError(t, err, "Failed to get messages after interrupt")
+	require.NotContains(t, msgResp.Messages[len(msgResp.Messages)-1].Content, "1000", "Agent wasn't interrupted")
+
+	// Restart and restore the state
+	stop()
+	require.FileExists(t, filepath.Join(stateDir, "state.json"), "State file should exist after shutdown")
+	apiClient, stop = startAgentContainer(ctx, t, spec, image, binaryPath, stateDir)
+	defer stop()
+	restored, err := waitForMessagesWithCount(ctx, t, apiClient, len(msgResp.Messages), agentOperationTimeout, "state restore")
+	require.NoError(t, err, "Failed to get messages after state restore")
+	for i, msg := range msgResp.Messages {
+		require.Equal(t, msg.Role, restored.Messages[i].Role)
+		require.Equal(t, strings.TrimSpace(msg.Content), strings.TrimSpace(restored.Messages[i].Content))
+	}
+}
+
+// buildLinuxBinary builds the agentapi binary that's mounted in the agent
+// containers, unless AGENTAPI_BINARY_PATH points to one.
+func buildLinuxBinary(t *testing.T) string {
+	t.Helper()
+	if binaryPath := os.Getenv("AGENTAPI_BINARY_PATH"); binaryPath != "" {
+		return binaryPath
+	}
+	cwd, err := os.Getwd()
+	require.NoError(t, err, "Failed to get current working directory")
+	binaryPath := filepath.Join(cwd, "..", "out", "agentapi-linux-"+runtime.GOARCH)
+	buildCmd := exec.Command("go", "build", "-o", binaryPath, ".")
+	buildCmd.Dir = filepath.Join(cwd, "..")
+	buildCmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS=linux", "GOARCH="+runtime.GOARCH)
+	t.Logf("run: %s", buildCmd.String())
+	out, err := buildCmd.CombinedOutput()
+	require.NoError(t, err, "Failed to build binary: %s", out)
+	return binaryPath
+}
+
+// buildAgentImage builds the image of the agent and returns its tag.
+// Images are tagged with the pinned version, so docker's cache makes
+// later runs fast.
+func buildAgentImage(ctx context.Context, t *testing.T, spec agentSpec) string {
+	t.Helper()
+	image := fmt.Sprintf("agentapi-e2e-%s:%s", spec.name, spec.version)
+	buildCtx, cancel := context.WithTimeout(ctx, agentImageBuildTimeout)
+	defer cancel()
+	buildCmd := exec.CommandContext(buildCtx, "docker", "build", "-t", image, "-")
+	buildCmd.Stdin = strings.NewReader(fmt.Sprintf(spec.dockerfile, spec.version))
+	t.Logf("run: %s", buildCmd.String())
+	out, err := buildCmd.CombinedOutput()
+	require.NoError(t, err, "Failed to build image %s: %s", image, out)
+	return image
+}
+
+// startAgentContainer starts the AgentAPI server with the agent in a
+// container. The returned function stops the container, which saves the
+// state like any other SIGTERM.
+func startAgentContainer(ctx context.Context, t *testing.T, spec agentSpec, image, binaryPath, stateDir string) (*agentapisdk.Client, func()) {
+	t.Helper()
+	serverPort, err := getFreePort()
+	require.NoError(t, err, "Failed to get free port for server")
+	name := fmt.Sprintf("agentapi-e2e-%s-%d", spec.name, serverPort)
+
+	args := []string{
+		"run", "--rm", "--init", "--name", name,
+		"-p", fmt.Sprintf("127.0.0.1:%d:3284", serverPort),
+		"-v", binaryPath + ":/usr/local/bin/agentapi:ro",
+		"-v", stateDir + ":/state",
+	}
+	for _, key := range spec.env {
+		// Without a value, docker passes the variable through from the
+		// environment of the test.
+		args = append(args, "-e", key)
+	}
+	args = append(args, image,
+		"agentapi", "server",
+		"--type="+spec.agentType,
+		"--state-file=/state/state.json",
+		"--",
+	)
+	args = append(args, spec.command...)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	t.Logf("Running command: %s", cmd.String())
+
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err, "Failed to create stdout pipe")
+	stderr, err := cmd.StderrPipe()
+	require.NoError(t, err, "Failed to create stderr pipe")
+	require.NoError(t, cmd.Start(), "Failed to start container")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		logOutput(t, "CONTAINER-STDOUT", stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		logOutput(t, "CONTAINER-STDERR", stderr)
+	}()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			stopCmd := exec.Command("docker", "stop", "--time", "10", name)
+			if out, err := stopCmd.CombinedOutput(); err != nil {
+				t.Logf("Failed to stop container %s: %v: %s", name, err, out)
+			}
+			_ = cmd.Wait()
+			wg.Wait()
+		})
+	}
+	t.Cleanup(stop)
+
+	serverURL := fmt.Sprintf("http://localhost:%d", serverPort)
+	require.NoError(t, waitForServer(ctx, t, serverURL, agentOperationTimeout), "Server not ready")
+	apiClient, err := agentapisdk.NewClient(serverURL)
+	require.NoError(t, err, "Failed to create agentapi SDK client")
+	return apiClient, stop
+}
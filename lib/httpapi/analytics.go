@@ -0,0 +1,119 @@
+package httpapi
+
+import (
+	"context"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+)
+
+// turnStats tracks the timing of the conversation's turns, a turn lasting
+// from a user message until the agent is stable again, and the tool calls
+// made during them. It is updated by the EventEmitter under its lock.
+type turnStats struct {
+	completedTurns int
+	totalDuration  time.Duration
+	longestStall   time.Duration
+	// lastUserMessageId is the id of the user message that started the
+	// latest turn.
+	lastUserMessageId int
+	// turnStart is when the open turn started, zero if there is none, and
+	// lastActivity when the agent last produced output during it.
+	turnStart    time.Time
+	lastActivity time.Time
+	toolCalls    map[string]int
+	toolCallIds  map[string]bool
+}
+
+func newTurnStats() turnStats {
+	return turnStats{
+		lastUserMessageId: -1,
+		toolCalls:         make(map[string]int),
+		toolCallIds:       make(map[string]bool),
+	}
+}
+
+func (t *turnStats) userMessage(id int, now time.Time) {
+	if id <= t.lastUserMessageId {
+		return
+	}
+	t.lastUserMessageId = id
+	t.turnStart = now
+	t.lastActivity = now
+}
+
+// agentActivity records output from the agent. The longest stall is the
+// longest the agent went without output during a turn.
+func (t *turnStats) agentActivity(now time.Time) {
+	if t.turnStart.IsZero() {
+		return
+	}
+	t.longestStall = max(t.longestStall, now.Sub(t.lastActivity))
+	t.lastActivity = now
+}
+
+func (t *turnStats) stable(now time.Time) {
+	if t.turnStart.IsZero() {
+		return
+	}
+	t.completedTurns++
+	t.totalDuration += now.Sub(t.turnStart)
+	t.turnStart = time.Time{}
+}
+
+func (t *turnStats) toolCall(toolCall st.ToolCall) {
+	// Transports report each tool call several times as its status changes.
+	// Calls without an id, such as the PTY transport's report_task calls,
+	// are only reported once.
+	if toolCall.Id != "" {
+		if t.toolCallIds[toolCall.Id] {
+			return
+		}
+		t.toolCallIds[toolCall.Id] = true
+	}
+	t.toolCalls[toolCall.Name]++
+}
+
+// analytics returns the turn statistics of the conversation. An open turn
+// the agent is stalled on counts towards the longest stall.
+func (e *EventEmitter) analytics() (completedTurns int, averageDuration time.Duration, longestStall time.Duration, toolCalls map[string]int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	t := &e.turns
+	if t.completedTurns > 0 {
+		averageDuration = t.totalDuration / time.Duration(t.completedTurns)
+	}
+	longestStall = t.longestStall
+	if !t.turnStart.IsZero() {
+		longestStall = max(longestStall, e.clock.Since(t.lastActivity))
+	}
+	toolCalls = make(map[string]int, len(t.toolCalls))
+	for name, count := range t.toolCalls {
+		toolCalls[name] = count
+	}
+	return t.completedTurns, averageDuration, longestStall, toolCalls
+}
+
+// getAnalytics handles GET /analytics.
+func (s *Server) getAnalytics(ctx context.Context, input *struct{}) (*AnalyticsResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	resp := &AnalyticsResponse{}
+	for _, msg := range s.conversation.Messages() {
+		tokens := s.tokenizer.CountTokens(msg.Message)
+		if msg.Role == st.ConversationRoleUser {
+			resp.Body.Turns++
+			resp.Body.UserTokens += tokens
+		} else {
+			resp.Body.AgentTokens += tokens
+		}
+	}
+	completed, average, stall, toolCalls := s.emitter.analytics()
+	resp.Body.CompletedTurns = completed
+	resp.Body.AverageTurnDurationSeconds = average.Seconds()
+	resp.Body.LongestStallSeconds = stall.Seconds()
+	resp.Body.ToolCalls = toolCalls
+	return resp, nil
+}
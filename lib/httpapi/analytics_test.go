@@ -0,0 +1,66 @@
+package httpapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAnalytics(t *testing.T) {
+	t.Parallel()
+
+	mClock := quartz.NewMock(t)
+	emitter := NewEventEmitter(WithClock(mClock))
+	conversation := &messagesConversation{}
+	s := &Server{conversation: conversation, emitter: emitter, tokenizer: mf.HeuristicTokenizer{}}
+	emit := func(messages ...st.ConversationMessage) {
+		conversation.messages = messages
+		emitter.EmitMessages(messages)
+	}
+	welcome := st.ConversationMessage{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"}
+	prompt := st.ConversationMessage{Id: 1, Role: st.ConversationRoleUser, Message: "Fix the bug"}
+	reply := st.ConversationMessage{Id: 2, Role: st.ConversationRoleAgent, Message: "Looking"}
+
+	emit(welcome)
+	emitter.EmitStatus(st.ConversationStatusStable)
+
+	// A 30 second turn, with a 20 second stall before the agent's last
+	// output.
+	emit(welcome, prompt)
+	emitter.EmitStatus(st.ConversationStatusChanging)
+	mClock.Advance(5 * time.Second)
+	emit(welcome, prompt, reply)
+	emitter.EmitToolCall(st.ToolCall{Id: "1", Name: "read", Status: st.ToolCallStatusStarted})
+	emitter.EmitToolCall(st.ToolCall{Id: "1", Name: "read", Status: st.ToolCallStatusCompleted})
+	emitter.EmitToolCall(st.ToolCall{Id: "2", Name: "edit", Status: st.ToolCallStatusStarted})
+	// Calls without an id are each counted.
+	emitter.EmitToolCall(st.ToolCall{Name: "report_task", Status: st.ToolCallStatusCompleted})
+	emitter.EmitToolCall(st.ToolCall{Name: "report_task", Status: st.ToolCallStatusCompleted})
+	mClock.Advance(20 * time.Second)
+	reply.Message = "Fixed the bug"
+	emit(welcome, prompt, reply)
+	mClock.Advance(5 * time.Second)
+	emitter.EmitStatus(st.ConversationStatusStable)
+
+	// A turn in progress, stalled for longer.
+	prompt2 := st.ConversationMessage{Id: 3, Role: st.ConversationRoleUser, Message: "Run the tests"}
+	emit(welcome, prompt, reply, prompt2)
+	emitter.EmitStatus(st.ConversationStatusChanging)
+	mClock.Advance(time.Minute)
+
+	resp, err := s.getAnalytics(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.Body.Turns)
+	assert.Equal(t, 1, resp.Body.CompletedTurns)
+	assert.Equal(t, 30.0, resp.Body.AverageTurnDurationSeconds)
+	assert.Equal(t, 60.0, resp.Body.LongestStallSeconds)
+	assert.Equal(t, map[string]int{"read": 1, "edit": 1, "report_task": 2}, resp.Body.ToolCalls)
+	assert.Equal(t, 6, resp.Body.UserTokens)
+	assert.Equal(t, 4, resp.Body.AgentTokens)
+}
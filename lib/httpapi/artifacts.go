@@ -0,0 +1,264 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+// artifactInterval is how often the conversation is checked for completed
+// turns whose artifacts weren't captured yet.
+const artifactInterval = time.Second
+
+// maxArtifactsPerMessage bounds the files captured for one message, so a
+// message listing a whole tree doesn't snapshot all of it.
+const maxArtifactsPerMessage = 20
+
+// announcedFilePattern matches the files an agent says it produced, e.g.
+// "Created report.md" or "I've written the file `docs/api.md`". The path
+// must have an extension, so that prose isn't taken for a file name.
+var announcedFilePattern = regexp.MustCompile("(?i)\\b(?:created|wrote|written|saved|generated|updated|modified|edited|exported)" +
+	`(?:\s+(?:a|an|the|new|file|to|in|at|into|as))*\s+` + "[`'\"]?" +
+	`((?:~|\.{1,2})?/?(?:[\w.@+-]+/)*[\w@+-][\w.@+-]*\.[A-Za-z0-9]+)`)
+
+// findAnnouncedFiles returns the paths of the files a message says the
+// agent produced, in order and without duplicates.
+func findAnnouncedFiles(message string) []string {
+	var paths []string
+	for _, match := range announcedFilePattern.FindAllStringSubmatch(message, -1) {
+		if !slices.Contains(paths, match[1]) {
+			paths = append(paths, match[1])
+		}
+	}
+	return paths
+}
+
+// artifactStore keeps the snapshots of the artifacts of agent messages.
+// Contents are stored once in dir, named after their SHA-256 digest, and
+// are deleted with the server's temporary directory. The rest of a file's
+// description is kept in its Artifact, since files with the same content
+// share the snapshot.
+type artifactStore struct {
+	dir string
+
+	mu        sync.Mutex
+	byMessage map[int][]Artifact
+}
+
+// artifactContent is what is stored of a file, which only depends on its
+// content.
+type artifactContent struct {
+	Content  string       `json:"content"`
+	Encoding FileEncoding `json:"encoding"`
+}
+
+func newArtifactStore(dir string) (*artifactStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, xerrors.Errorf("failed to create artifacts directory: %w", err)
+	}
+	return &artifactStore{dir: dir, byMessage: map[int][]Artifact{}}, nil
+}
+
+// capture snapshots the files of an agent message. Files that can't be
+// read through the file browser, e.g. because they aren't allowed by
+// --files-allow, are skipped.
+func (a *artifactStore) capture(files *fileBrowser, messageId int, paths []string, now time.Time) ([]Artifact, error) {
+	var artifacts []Artifact
+	for _, p := range paths {
+		if len(artifacts) == maxArtifactsPerMessage {
+			break
+		}
+		rel, ok := files.relPath(p)
+		if !ok || slices.ContainsFunc(artifacts, func(a Artifact) bool { return a.Path == rel }) {
+			continue
+		}
+		body, err := files.read(rel)
+		if err != nil || body.Type != FileTypeFile {
+			continue
+		}
+		content, err := json.Marshal(artifactContent{Content: body.Content, Encoding: body.Encoding})
+		if err != nil {
+			return nil, xerrors.Errorf("failed to encode artifact: %w", err)
+		}
+		digest := sha256.Sum256([]byte(body.Content))
+		artifact := Artifact{
+			Path:     body.Path,
+			Size:     body.Size,
+			Modified: body.Modified,
+			SHA256:   hex.EncodeToString(digest[:]),
+			Captured: now,
+		}
+		if err := os.WriteFile(filepath.Join(a.dir, artifact.SHA256+".json"), content, 0o600); err != nil {
+			return nil, xerrors.Errorf("failed to write artifact: %w", err)
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	if len(artifacts) > 0 {
+		a.mu.Lock()
+		a.byMessage[messageId] = artifacts
+		a.mu.Unlock()
+	}
+	return artifacts, nil
+}
+
+// list returns the artifacts captured for a message.
+func (a *artifactStore) list(messageId int) []Artifact {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.byMessage[messageId]
+}
+
+// read returns the snapshot of an artifact of a message.
+func (a *artifactStore) read(messageId int, rel string) (FileBody, error) {
+	artifacts := a.list(messageId)
+	i := slices.IndexFunc(artifacts, func(artifact Artifact) bool { return artifact.Path == rel })
+	if i < 0 {
+		return FileBody{}, huma.Error404NotFound(fmt.Sprintf("message %d has no artifact %s", messageId, rel))
+	}
+	data, err := os.ReadFile(filepath.Join(a.dir, artifacts[i].SHA256+".json"))
+	if err != nil {
+		return FileBody{}, xerrors.Errorf("failed to read artifact: %w", err)
+	}
+	var content artifactContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return FileBody{}, xerrors.Errorf("failed to decode artifact: %w", err)
+	}
+	artifact := artifacts[i]
+	return FileBody{
+		FileEntry: FileEntry{
+			Name:     path.Base(artifact.Path),
+			Path:     artifact.Path,
+			Type:     FileTypeFile,
+			Size:     artifact.Size,
+			Modified: artifact.Modified,
+		},
+		Content:  content.Content,
+		Encoding: content.Encoding,
+	}, nil
+}
+
+// startArtifactCapture snapshots the files announced in the agent messages
+// of each turn, and those the agent's tool calls modified, once the turn
+// completes, i.e. once the agent is stable after replying.
+func (s *Server) startArtifactCapture(ctx context.Context) {
+	if s.artifacts == nil {
+		return
+	}
+
+	capturedTurn := -1
+	s.clock.TickerFunc(ctx, artifactInterval, func() error {
+		if s.conversation.Status() != st.ConversationStatusStable {
+			// The turn goes on, e.g. after a permission prompt, and is
+			// captured again once it completes.
+			capturedTurn = -1
+			return nil
+		}
+		s.mu.RLock()
+		latest, ok := s.latestMessage()
+		var turn []st.ConversationMessage
+		if ok && latest.Role == st.ConversationRoleAgent && latest.TurnId != capturedTurn {
+			turn, _, _ = st.ReadMessageRange(s.conversation, latest.TurnId, latest.Id+1)
+		}
+		s.mu.RUnlock()
+		if len(turn) == 0 {
+			return nil
+		}
+		capturedTurn = latest.TurnId
+
+		for _, msg := range turn {
+			if msg.Role != st.ConversationRoleAgent {
+				continue
+			}
+			paths := findAnnouncedFiles(msg.Message)
+			for _, diff := range msg.Diffs {
+				paths = append(paths, diff.Path)
+			}
+			if len(paths) == 0 {
+				continue
+			}
+			artifacts, err := s.artifacts.capture(s.files, msg.Id, paths, s.clock.Now())
+			if err != nil {
+				s.logger.Error("Failed to capture artifacts", "messageId", msg.Id, "error", err)
+				continue
+			}
+			if len(artifacts) > 0 {
+				s.logger.Debug("Captured artifacts", "messageId", msg.Id, "count", len(artifacts))
+				s.metrics.Add("agentapi_artifacts_captured_total", "Files announced or modified by the agent that were snapshotted when its turn completed.",
+					float64(len(artifacts)))
+			}
+		}
+		return nil
+	}, "artifacts")
+}
+
+func (s *Server) checkArtifacts() error {
+	if s.artifacts == nil {
+		return huma.Error404NotFound("artifacts are disabled, enable them with --artifacts and --files-allow")
+	}
+	return nil
+}
+
+// getMessageArtifacts handles GET /messages/{id}/artifacts
+func (s *Server) getMessageArtifacts(ctx context.Context, input *MessageArtifactsRequest) (*MessageArtifactsResponse, error) {
+	if err := s.checkArtifacts(); err != nil {
+		return nil, err
+	}
+	resp := &MessageArtifactsResponse{}
+	resp.Body.Artifacts = s.artifacts.list(input.Id)
+	if resp.Body.Artifacts == nil {
+		resp.Body.Artifacts = []Artifact{}
+	}
+	return resp, nil
+}
+
+// getMessageArtifact handles GET /messages/{id}/artifacts/{path}
+func (s *Server) getMessageArtifact(ctx context.Context, input *MessageArtifactRequest) (*FileResponse, error) {
+	if err := s.checkArtifacts(); err != nil {
+		return nil, err
+	}
+	rel, ok := cleanFilePath(input.Path)
+	if !ok {
+		return nil, huma.Error404NotFound(fmt.Sprintf("message %d has no artifact %s", input.Id, input.Path))
+	}
+	body, err := s.artifacts.read(input.Id, rel)
+	if err != nil {
+		var statusErr huma.StatusError
+		if errors.As(err, &statusErr) {
+			return nil, err
+		}
+		return nil, huma.Error500InternalServerError("failed to read artifact", err)
+	}
+	return &FileResponse{Body: body}, nil
+}
+
+// relPath converts a path mentioned by the agent, relative to the root or
+// absolute, to a path relative to the root, or returns false if it is
+// outside of it.
+func (b *fileBrowser) relPath(p string) (string, bool) {
+	if filepath.IsAbs(p) {
+		rel, err := filepath.Rel(b.root, p)
+		if err != nil {
+			return "", false
+		}
+		p = filepath.ToSlash(rel)
+	}
+	if p = path.Clean(p); p == ".." || strings.HasPrefix(p, "../") || strings.HasPrefix(p, "~") {
+		return "", false
+	}
+	return cleanFilePath(p)
+}
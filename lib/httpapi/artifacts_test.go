@@ -0,0 +1,128 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/metrics"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindAnnouncedFiles(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"report.md", "docs/api.md", "/tmp/out.csv"}, findAnnouncedFiles(
+		"I've created report.md and updated the file `docs/api.md`. Results were saved to /tmp/out.csv. Created report.md."))
+	assert.Empty(t, findAnnouncedFiles("Looking at main.go, the file was created in 2019."))
+}
+
+func TestArtifacts(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "docs"), 0o755))
+	writeFile := func(name, content string) {
+		t.Helper()
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0o644))
+	}
+	writeFile("report.md", "v1")
+	writeFile("docs/api.md", "api")
+	writeFile("secret.txt", "secret")
+
+	files, err := newFileBrowser(FilesConfig{Root: root, Allow: []string{"*.md", "docs"}})
+	require.NoError(t, err)
+	artifacts, err := newArtifactStore(t.TempDir())
+	require.NoError(t, err)
+	mClock := quartz.NewMock(t)
+	conversation := &turnConversation{}
+	s := &Server{
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		conversation: conversation,
+		clock:        mClock,
+		metrics:      metrics.New(),
+		files:        files,
+		artifacts:    artifacts,
+	}
+	s.startArtifactCapture(ctx)
+	tick := func() {
+		t.Helper()
+		_, w := mClock.AdvanceNext()
+		require.NoError(t, w.Wait(ctx))
+	}
+	list := func(id int) []Artifact {
+		t.Helper()
+		resp, err := s.getMessageArtifacts(ctx, &MessageArtifactsRequest{Id: id})
+		require.NoError(t, err)
+		return resp.Body.Artifacts
+	}
+	content := func(id int, path string) string {
+		t.Helper()
+		resp, err := s.getMessageArtifact(ctx, &MessageArtifactRequest{Id: id, Path: path})
+		require.NoError(t, err)
+		return resp.Body.Content
+	}
+
+	conversation.add(st.ConversationRoleUser, "Write the report")
+	conversation.add(st.ConversationRoleAgent, "Created report.md and updated docs/api.md. I also wrote secret.txt.")
+	tick()
+	artifactList := list(1)
+	require.Len(t, artifactList, 2)
+	assert.Equal(t, "report.md", artifactList[0].Path)
+	assert.Equal(t, int64(2), artifactList[0].Size)
+	assert.Equal(t, "docs/api.md", artifactList[1].Path)
+	assert.Equal(t, 2.0, s.metrics.Value("agentapi_artifacts_captured_total"))
+
+	// Each turn keeps the version of the file it produced.
+	writeFile("report.md", "v2")
+	conversation.add(st.ConversationRoleUser, "Update the report")
+	conversation.add(st.ConversationRoleAgent, "Updated `report.md`.")
+	tick()
+	assert.Equal(t, "v1", content(1, "report.md"))
+	assert.Equal(t, "v2", content(3, "docs%2F..%2Freport.md"))
+	assert.Equal(t, "api", content(1, "docs%2Fapi.md"))
+	assert.NotEqual(t, artifactList[0].SHA256, list(3)[0].SHA256)
+
+	assert.Empty(t, list(0))
+	_, err = s.getMessageArtifact(ctx, &MessageArtifactRequest{Id: 1, Path: "secret.txt"})
+	var statusErr huma.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusNotFound, statusErr.GetStatus())
+
+	// Files with the same content share their snapshot, but not their path
+	// and modification time.
+	writeFile("docs/copy.md", "api")
+	copied := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "docs/copy.md"), copied, copied))
+	conversation.add(st.ConversationRoleUser, "Copy the API docs")
+	conversation.add(st.ConversationRoleAgent, "Created docs/copy.md.")
+	tick()
+	assert.Equal(t, artifactList[1].SHA256, list(5)[0].SHA256)
+	original, err := s.getMessageArtifact(ctx, &MessageArtifactRequest{Id: 1, Path: "docs%2Fapi.md"})
+	require.NoError(t, err)
+	assert.Equal(t, "docs/api.md", original.Body.Path)
+	assert.Equal(t, "api.md", original.Body.Name)
+	assert.Equal(t, artifactList[1].Modified, original.Body.Modified)
+	duplicate, err := s.getMessageArtifact(ctx, &MessageArtifactRequest{Id: 5, Path: "docs%2Fcopy.md"})
+	require.NoError(t, err)
+	assert.Equal(t, "docs/copy.md", duplicate.Body.Path)
+	assert.True(t, copied.Equal(duplicate.Body.Modified))
+	assert.Equal(t, "api", duplicate.Body.Content)
+	assert.Equal(t, FileEncodingUTF8, duplicate.Body.Encoding)
+
+	// Artifacts are disabled by default.
+	s.artifacts = nil
+	_, err = s.getMessageArtifacts(ctx, &MessageArtifactsRequest{Id: 1})
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusNotFound, statusErr.GetStatus())
+}
@@ -0,0 +1,268 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+// maxAttachmentSize is the largest file accepted by POST /attachments.
+const maxAttachmentSize = 100 << 20 // 100MB
+
+// attachmentCleanupInterval is how often expired attachments are deleted.
+const attachmentCleanupInterval = time.Minute
+
+// ErrAttachmentNotFound is returned by an AttachmentStore for unknown IDs.
+var ErrAttachmentNotFound = xerrors.New("attachment not found")
+
+// Attachment is a file shared with the agent.
+type Attachment struct {
+	Id   string
+	Name string
+	Size int64
+	// Path is where the agent can read the file.
+	Path    string
+	Created time.Time
+}
+
+// AttachmentStore keeps the files shared with the agent. Agents read files
+// from the local filesystem, so stores backed by remote storage must make
+// attachments available at a local Path.
+type AttachmentStore interface {
+	// Put stores a file read from r under a new ID.
+	Put(ctx context.Context, name string, r io.Reader) (Attachment, error)
+	// Get returns a stored attachment, or ErrAttachmentNotFound.
+	Get(ctx context.Context, id string) (Attachment, error)
+	// Delete removes an attachment, or returns ErrAttachmentNotFound.
+	Delete(ctx context.Context, id string) error
+	// DeleteBefore removes the attachments created before t and returns how
+	// many there were.
+	DeleteBefore(ctx context.Context, t time.Time) (int, error)
+}
+
+// attachmentIdPattern matches the IDs of localAttachmentStore, so that IDs
+// from requests can't escape its directory.
+var attachmentIdPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// localAttachmentStore keeps each attachment in <dir>/<id>/<name>. The
+// modification time of the attachment's directory is its creation time.
+type localAttachmentStore struct {
+	dir   string
+	clock quartz.Clock
+}
+
+// NewLocalAttachmentStore creates a store that keeps attachments in dir.
+func NewLocalAttachmentStore(dir string, clock quartz.Clock) (AttachmentStore, error) {
+	if clock == nil {
+		clock = quartz.NewReal()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, xerrors.Errorf("failed to create attachments directory: %w", err)
+	}
+	return &localAttachmentStore{dir: dir, clock: clock}, nil
+}
+
+func (l *localAttachmentStore) Put(ctx context.Context, name string, r io.Reader) (Attachment, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return Attachment{}, xerrors.Errorf("failed to generate attachment id: %w", err)
+	}
+	id := hex.EncodeToString(idBytes)
+	// Extract just the base filename for security.
+	name = filepath.Base(name)
+	if name == "." || name == string(filepath.Separator) {
+		name = "attachment"
+	}
+
+	dir := filepath.Join(l.dir, id)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return Attachment{}, xerrors.Errorf("failed to create attachment directory: %w", err)
+	}
+	path := filepath.Join(dir, name)
+	size, err := writeFile(path, r)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return Attachment{}, err
+	}
+	created := l.clock.Now()
+	if err := os.Chtimes(dir, created, created); err != nil {
+		_ = os.RemoveAll(dir)
+		return Attachment{}, xerrors.Errorf("failed to set attachment time: %w", err)
+	}
+	return Attachment{Id: id, Name: name, Size: size, Path: path, Created: created}, nil
+}
+
+func writeFile(path string, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to create attachment file: %w", err)
+	}
+	size, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, xerrors.Errorf("failed to write attachment file: %w", err)
+	}
+	return size, nil
+}
+
+func (l *localAttachmentStore) Get(ctx context.Context, id string) (Attachment, error) {
+	if !attachmentIdPattern.MatchString(id) {
+		return Attachment{}, ErrAttachmentNotFound
+	}
+	dir := filepath.Join(l.dir, id)
+	dirInfo, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Attachment{}, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return Attachment{}, xerrors.Errorf("failed to stat attachment: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Attachment{}, xerrors.Errorf("failed to read attachment directory: %w", err)
+	}
+	if len(entries) != 1 {
+		return Attachment{}, ErrAttachmentNotFound
+	}
+	info, err := entries[0].Info()
+	if err != nil {
+		return Attachment{}, xerrors.Errorf("failed to stat attachment: %w", err)
+	}
+	return Attachment{
+		Id:      id,
+		Name:    info.Name(),
+		Size:    info.Size(),
+		Path:    filepath.Join(dir, info.Name()),
+		Created: dirInfo.ModTime(),
+	}, nil
+}
+
+func (l *localAttachmentStore) Delete(ctx context.Context, id string) error {
+	if _, err := l.Get(ctx, id); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(l.dir, id)); err != nil {
+		return xerrors.Errorf("failed to delete attachment: %w", err)
+	}
+	return nil
+}
+
+func (l *localAttachmentStore) DeleteBefore(ctx context.Context, t time.Time) (int, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to read attachments directory: %w", err)
+	}
+	deleted := 0
+	for _, entry := range entries {
+		if !entry.IsDir() || !attachmentIdPattern.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(t) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(l.dir, entry.Name())); err != nil {
+			return deleted, xerrors.Errorf("failed to delete attachment: %w", err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// startAttachmentCleanup deletes attachments once they are older than the
+// configured TTL.
+func (s *Server) startAttachmentCleanup(ctx context.Context) {
+	if s.attachmentTTL <= 0 {
+		return
+	}
+	s.clock.TickerFunc(ctx, attachmentCleanupInterval, func() error {
+		deleted, err := s.attachments.DeleteBefore(ctx, s.clock.Now().Add(-s.attachmentTTL))
+		if err != nil {
+			s.logger.Warn("Failed to delete expired attachments", "error", err)
+		}
+		if deleted > 0 {
+			s.logger.Info(fmt.Sprintf("Deleted %d expired attachments", deleted))
+		}
+		return nil
+	}, "attachmentCleanup")
+}
+
+// attachmentReferences formats the attachments of a message the way the
+// chat interface references uploaded files, so the agent reads them.
+func (s *Server) attachmentReferences(ctx context.Context, ids []string) (string, error) {
+	references := ""
+	for i, id := range ids {
+		attachment, err := s.attachments.Get(ctx, id)
+		if errors.Is(err, ErrAttachmentNotFound) {
+			return "", huma.Error404NotFound("attachment not found", &huma.ErrorDetail{
+				Location: fmt.Sprintf("body.attachments[%d]", i),
+				Value:    id,
+			})
+		}
+		if err != nil {
+			return "", err
+		}
+		references += fmt.Sprintf(` @"%s"`, attachment.Path)
+	}
+	return references, nil
+}
+
+// createAttachment handles POST /attachments
+func (s *Server) createAttachment(ctx context.Context, input *struct {
+	RawBody huma.MultipartFormFiles[UploadRequest]
+},
+) (*AttachmentResponse, error) {
+	formData := input.RawBody.Data()
+	r := io.LimitReader(formData.File.File, maxAttachmentSize+1)
+	attachment, err := s.attachments.Put(ctx, formData.File.Filename, r)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to store attachment: %w", err)
+	}
+	if attachment.Size > maxAttachmentSize {
+		_ = s.attachments.Delete(ctx, attachment.Id)
+		return nil, huma.Error400BadRequest("file size exceeds 100MB limit")
+	}
+	return &AttachmentResponse{Body: convertAttachment(attachment)}, nil
+}
+
+// getAttachment handles GET /attachments/{id}
+func (s *Server) getAttachment(ctx context.Context, input *AttachmentRequest) (*AttachmentResponse, error) {
+	attachment, err := s.attachments.Get(ctx, input.Id)
+	if errors.Is(err, ErrAttachmentNotFound) {
+		return nil, huma.Error404NotFound("attachment not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &AttachmentResponse{Body: convertAttachment(attachment)}, nil
+}
+
+// deleteAttachment handles DELETE /attachments/{id}
+func (s *Server) deleteAttachment(ctx context.Context, input *AttachmentRequest) (*struct{}, error) {
+	err := s.attachments.Delete(ctx, input.Id)
+	if errors.Is(err, ErrAttachmentNotFound) {
+		return nil, huma.Error404NotFound("attachment not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func convertAttachment(a Attachment) AttachmentBody {
+	return AttachmentBody{Id: a.Id, Name: a.Name, Size: a.Size, Path: a.Path, Created: a.Created}
+}
@@ -0,0 +1,118 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalAttachmentStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mClock := quartz.NewMock(t)
+	store, err := NewLocalAttachmentStore(t.TempDir(), mClock)
+	require.NoError(t, err)
+
+	first, err := store.Put(ctx, "../../notes.txt", strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "notes.txt", first.Name)
+	assert.Equal(t, int64(5), first.Size)
+	content, err := os.ReadFile(first.Path)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+
+	got, err := store.Get(ctx, first.Id)
+	require.NoError(t, err)
+	assert.Equal(t, first.Path, got.Path)
+	assert.True(t, first.Created.Equal(got.Created))
+
+	_, err = store.Get(ctx, "../"+filepath.Base(filepath.Dir(first.Path)))
+	assert.ErrorIs(t, err, ErrAttachmentNotFound)
+
+	mClock.Advance(time.Hour)
+	second, err := store.Put(ctx, "diagram.png", strings.NewReader("png"))
+	require.NoError(t, err)
+
+	deleted, err := store.DeleteBefore(ctx, mClock.Now().Add(-30*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, err = store.Get(ctx, first.Id)
+	assert.ErrorIs(t, err, ErrAttachmentNotFound)
+
+	require.NoError(t, store.Delete(ctx, second.Id))
+	assert.ErrorIs(t, store.Delete(ctx, second.Id), ErrAttachmentNotFound)
+}
+
+func TestMessageAttachments(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store, err := NewLocalAttachmentStore(t.TempDir(), nil)
+	require.NoError(t, err)
+	attachment, err := store.Put(ctx, "report.pdf", strings.NewReader("%PDF"))
+	require.NoError(t, err)
+
+	conversation := &sentConversation{}
+	s := &Server{conversation: conversation, attachments: store}
+	_, err = s.createMessage(ctx, &MessageRequest{Body: MessageRequestBody{
+		Content:     "Summarize this",
+		Type:        MessageTypeUser,
+		Attachments: []string{attachment.Id},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{`Summarize this @"` + attachment.Path + `"`}, conversation.Sent())
+
+	_, err = s.createMessage(ctx, &MessageRequest{Body: MessageRequestBody{
+		Content:     "Summarize this",
+		Type:        MessageTypeUser,
+		Attachments: []string{"0123456789abcdef0123456789abcdef"},
+	}})
+	var statusErr huma.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusNotFound, statusErr.GetStatus())
+	assert.Len(t, conversation.Sent(), 1)
+}
+
+func TestAttachmentCleanup(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	mClock := quartz.NewMock(t)
+	store, err := NewLocalAttachmentStore(t.TempDir(), mClock)
+	require.NoError(t, err)
+	s := &Server{
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		clock:         mClock,
+		attachments:   store,
+		attachmentTTL: 90 * time.Second,
+	}
+	s.startAttachmentCleanup(ctx)
+
+	attachment, err := store.Put(ctx, "log.txt", strings.NewReader("log"))
+	require.NoError(t, err)
+	tick := func() {
+		t.Helper()
+		_, w := mClock.AdvanceNext()
+		require.NoError(t, w.Wait(ctx))
+	}
+
+	tick()
+	_, err = store.Get(ctx, attachment.Id)
+	require.NoError(t, err)
+	tick()
+	_, err = store.Get(ctx, attachment.Id)
+	assert.ErrorIs(t, err, ErrAttachmentNotFound)
+}
@@ -0,0 +1,22 @@
+package httpapi
+
+// terminalSignals is implemented by AgentIOs that track the bell and the
+// window title of the agent's terminal, such as termexec.Process.
+type terminalSignals interface {
+	Title() string
+	OnBell(f func())
+}
+
+// watchTerminalSignals publishes an attention event whenever the agent
+// rings the terminal bell, which agents do when they need input.
+func (s *Server) watchTerminalSignals() {
+	signals, ok := s.agentio.(terminalSignals)
+	if !ok {
+		return
+	}
+	signals.OnBell(func() {
+		s.emitter.EmitAttention(signals.Title())
+		s.metrics.Inc("agentapi_attention_events_total", "Times the agent rang the terminal bell.",
+			"agent_type", string(s.agentType))
+	})
+}
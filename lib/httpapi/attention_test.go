@@ -0,0 +1,52 @@
+package httpapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coder/agentapi/lib/metrics"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bellAgentIO is an st.AgentIO with a settable title and bell.
+type bellAgentIO struct {
+	title string
+	bell  func()
+}
+
+func (a *bellAgentIO) Write(data []byte) (int, error) { return len(data), nil }
+func (a *bellAgentIO) ReadScreen() string             { return "" }
+func (a *bellAgentIO) Title() string                  { return a.title }
+func (a *bellAgentIO) OnBell(f func())                { a.bell = f }
+
+func TestTerminalSignals(t *testing.T) {
+	t.Parallel()
+
+	agentIO := &bellAgentIO{title: "✳ Waiting for input"}
+	emitter := NewEventEmitter(WithClock(quartz.NewMock(t)))
+	s := &Server{
+		agentio:      agentIO,
+		agentType:    mf.AgentTypeClaude,
+		conversation: &sentConversation{},
+		emitter:      emitter,
+		metrics:      metrics.New(),
+	}
+	s.watchTerminalSignals()
+	require.NotNil(t, agentIO.bell)
+
+	status, err := s.getStatus(context.Background(), &StatusRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "✳ Waiting for input", status.Body.Title)
+
+	_, ch, _ := emitter.Subscribe()
+	agentIO.bell()
+	event := <-ch
+	assert.Equal(t, EventTypeAttention, event.Type)
+	body, ok := event.Payload.(AttentionBody)
+	require.True(t, ok)
+	assert.Equal(t, "bell", body.Reason)
+	assert.Equal(t, "✳ Waiting for input", body.Title)
+}
@@ -0,0 +1,104 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/coder/agentapi/lib/metrics"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"golang.org/x/xerrors"
+)
+
+// CoderConfig configures reporting task status to the Coder workspace the
+// server runs in.
+type CoderConfig struct {
+	// AgentURL and AgentToken are the Coder deployment URL and workspace
+	// agent token, provided to workspaces as CODER_AGENT_URL and
+	// CODER_AGENT_TOKEN.
+	AgentURL   string
+	AgentToken string
+	// AppSlug is the slug of the workspace app the status is reported for.
+	// Reporting is disabled unless all three fields are set.
+	AppSlug string
+}
+
+// coderStatusTimeout bounds each request to the Coder API.
+const coderStatusTimeout = 10 * time.Second
+
+// coderAppStatusRequest is the body of PATCH
+// /api/v2/workspaceagents/me/app-status.
+type coderAppStatusRequest struct {
+	AppSlug string `json:"app_slug"`
+	State   string `json:"state"`
+	Message string `json:"message"`
+	URI     string `json:"uri"`
+}
+
+// newCoderStatusReporter returns a sink that forwards the agent's
+// coder_report_task tool calls to the Coder workspace app status API, or
+// nil if cfg is incomplete.
+func newCoderStatusReporter(cfg CoderConfig, logger *slog.Logger, metricsRegistry *metrics.Registry) transcriptSink {
+	if cfg.AgentURL == "" || cfg.AgentToken == "" || cfg.AppSlug == "" {
+		return nil
+	}
+	endpoint := strings.TrimRight(cfg.AgentURL, "/") + "/api/v2/workspaceagents/me/app-status"
+	send := func(records []transcriptRecord) error {
+		for _, record := range records {
+			// Structured transports report the arguments when the call
+			// starts; PTY conversations report the rendered call once done.
+			if record.Type != transcriptRecordToolCall || !strings.Contains(record.ToolCall.Name, mf.ReportTaskToolName) {
+				continue
+			}
+			task, ok := mf.ParseReportTask(record.ToolCall.Input)
+			if !ok {
+				continue
+			}
+			if err := patchCoderAppStatus(endpoint, cfg.AgentToken, coderAppStatusRequest{
+				AppSlug: cfg.AppSlug,
+				State:   task.State,
+				Message: task.Summary,
+				URI:     task.Link,
+			}); err != nil {
+				return err
+			}
+			metricsRegistry.Inc("agentapi_coder_status_reports_total", "Task statuses reported to the Coder workspace app status API.",
+				"state", task.State)
+		}
+		return nil
+	}
+	logger.Info("Reporting task status to Coder", "url", cfg.AgentURL, "app", cfg.AppSlug)
+	return newLogExporter("coder", send, nil, logger, metricsRegistry)
+}
+
+func patchCoderAppStatus(endpoint string, token string, status coderAppStatusRequest) error {
+	body, err := json.Marshal(status)
+	if err != nil {
+		return xerrors.Errorf("failed to marshal app status: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), coderStatusTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("failed to create app status request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Coder-Session-Token", token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("failed to report app status: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return xerrors.Errorf("Coder returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoderStatusReporter(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var received []coderAppStatusRequest
+	coder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPatch, r.Method)
+		assert.Equal(t, "/api/v2/workspaceagents/me/app-status", r.URL.Path)
+		assert.Equal(t, "agent-token", r.Header.Get("Coder-Session-Token"))
+		var status coderAppStatusRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&status))
+		mu.Lock()
+		received = append(received, status)
+		mu.Unlock()
+	}))
+	t.Cleanup(coder.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	assert.Nil(t, newCoderStatusReporter(CoderConfig{AgentURL: coder.URL, AgentToken: "agent-token"}, logger, nil))
+
+	sink := newCoderStatusReporter(CoderConfig{AgentURL: coder.URL + "/", AgentToken: "agent-token", AppSlug: "claude"}, logger, nil)
+	require.NotNil(t, sink)
+	emitter := NewEventEmitter(withTranscriptSinks(sink))
+
+	// A structured transport reports the arguments when the call starts.
+	emitter.EmitToolCall(st.ToolCall{Id: "1", Name: "mcp__coder__coder_report_task", Input: `{"state":"working","summary":"Reading the code"}`, Status: st.ToolCallStatusStarted})
+	emitter.EmitToolCall(st.ToolCall{Id: "1", Name: "mcp__coder__coder_report_task", Status: st.ToolCallStatusCompleted})
+	emitter.EmitToolCall(st.ToolCall{Id: "2", Name: "Bash", Input: `{"state":"complete"}`, Status: st.ToolCallStatusStarted})
+	// A PTY conversation reports the call as rendered on the screen.
+	emitter.EmitToolCall(st.ToolCall{
+		Name:   "coder_report_task",
+		Input:  "● coder - coder_report_task (MCP)(summary: \"Fixed the bug\", link: \"\", state: \"complete\")",
+		Status: st.ToolCallStatusCompleted,
+	})
+	require.NoError(t, sink.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []coderAppStatusRequest{
+		{AppSlug: "claude", State: "working", Message: "Reading the code"},
+		{AppSlug: "claude", State: "complete", Message: "Fixed the bug"},
+	}, received)
+}
@@ -0,0 +1,46 @@
+package httpapi
+
+import (
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+)
+
+// MessageColors is the format in which agent messages keep the colors they
+// had on the terminal screen.
+type MessageColors string
+
+const (
+	MessageColorsANSI MessageColors = "ansi"
+	MessageColorsHTML MessageColors = "html"
+)
+
+var MessageColorsValues = []MessageColors{MessageColorsANSI, MessageColorsHTML}
+
+// colorPreserver is a middleware attaching agent messages as they're
+// colored on the screen, e.g. syntax-highlighted diffs, which lose their
+// meaning as plain text.
+type colorPreserver struct {
+	st.NoopMiddleware
+	screen ansiScreenReader
+	format MessageColors
+}
+
+func newColorPreserver(agentIO st.AgentIO, format MessageColors) *colorPreserver {
+	p := &colorPreserver{format: format}
+	p.screen, _ = agentIO.(ansiScreenReader)
+	return p
+}
+
+func (p *colorPreserver) OnAgentMessage(message st.ConversationMessage) st.ConversationMessage {
+	message.ANSI, message.HTML = "", ""
+	if p.screen == nil || message.Message == "" {
+		return message
+	}
+	colored := mf.ColorMessage(message.Message, p.screen.ReadScreenANSI())
+	if p.format == MessageColorsHTML {
+		message.HTML = mf.ANSIToHTML(colored)
+	} else {
+		message.ANSI = colored
+	}
+	return message
+}
@@ -0,0 +1,51 @@
+package httpapi
+
+import (
+	"testing"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+)
+
+// ansiAgentIO is an st.AgentIO that renders its screen with colors.
+type ansiAgentIO struct {
+	screen string
+}
+
+func (a *ansiAgentIO) Write(data []byte) (int, error) { return len(data), nil }
+func (a *ansiAgentIO) ReadScreen() string             { return a.screen }
+func (a *ansiAgentIO) ReadScreenANSI() string         { return a.screen }
+
+func TestColorPreserver(t *testing.T) {
+	t.Parallel()
+
+	agentIO := &ansiAgentIO{screen: "● Updated main.go\n  \x1b[31;49m- return nil\x1b[0m\n"}
+	messages := []st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleUser, Message: "Fix the bug"},
+		{Id: 1, Role: st.ConversationRoleAgent, Message: "Updated main.go\n- return nil"},
+	}
+
+	t.Run("ansi", func(t *testing.T) {
+		t.Parallel()
+		chain := st.NewMiddlewareChain(newColorPreserver(agentIO, MessageColorsANSI))
+		got := chain.Conversation(&messagesConversation{messages: messages}).Messages()
+		assert.Empty(t, got[0].ANSI)
+		assert.Equal(t, "Updated main.go\n\x1b[31;49m- return nil\x1b[0m", got[1].ANSI)
+		assert.Empty(t, got[1].HTML)
+	})
+
+	t.Run("html", func(t *testing.T) {
+		t.Parallel()
+		chain := st.NewMiddlewareChain(newColorPreserver(agentIO, MessageColorsHTML))
+		got := chain.Conversation(&messagesConversation{messages: messages}).Messages()
+		assert.Equal(t, "Updated main.go\n<span style=\"color:#cd0000\">- return nil</span>", got[1].HTML)
+		assert.Empty(t, got[1].ANSI)
+	})
+
+	t.Run("transport without colors", func(t *testing.T) {
+		t.Parallel()
+		chain := st.NewMiddlewareChain(newColorPreserver(&hyperlinkAgentIO{}, MessageColorsANSI))
+		got := chain.Conversation(&messagesConversation{messages: messages}).Messages()
+		assert.Empty(t, got[1].ANSI)
+	})
+}
@@ -0,0 +1,98 @@
+package httpapi
+
+import (
+	"context"
+	"strings"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// commandProvider is implemented by AgentIOs whose agents advertise slash
+// commands, such as acpio.ACPAgentIO.
+type commandProvider interface {
+	AvailableCommands() []st.AgentCommand
+}
+
+// availableCommands returns the commands advertised by the agent, or nil
+// if the transport does not report them.
+func (s *Server) availableCommands() []st.AgentCommand {
+	provider, ok := s.agentio.(commandProvider)
+	if !ok {
+		return nil
+	}
+	return provider.AvailableCommands()
+}
+
+// getCommands handles GET /commands
+func (s *Server) getCommands(ctx context.Context, input *struct{}) (*CommandsResponse, error) {
+	if s.agentio == nil {
+		return nil, huma.Error503ServiceUnavailable("no agent is running")
+	}
+
+	commands := s.availableCommands()
+	resp := &CommandsResponse{}
+	resp.Body.Commands = make([]Command, len(commands))
+	for i, cmd := range commands {
+		resp.Body.Commands[i] = Command{
+			Name:        cmd.Name,
+			Description: cmd.Description,
+			InputHint:   cmd.InputHint,
+		}
+	}
+	return resp, nil
+}
+
+// runCommand handles POST /command. The command is sent to the agent as a
+// user message of the form "/<name> <input>", which is how ACP agents
+// expect commands to be invoked.
+func (s *Server) runCommand(ctx context.Context, input *CommandRequest) (*CommandResponse, error) {
+	if s.agentio == nil {
+		return nil, huma.Error503ServiceUnavailable("no agent is running")
+	}
+
+	name := strings.TrimPrefix(input.Body.Name, "/")
+	known := false
+	for _, cmd := range s.availableCommands() {
+		if cmd.Name == name {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return nil, huma.Error400BadRequest("unknown command: " + name)
+	}
+
+	message := "/" + name
+	if cmdInput := strings.TrimSpace(input.Body.Input); cmdInput != "" {
+		message += " " + cmdInput
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkIfMatch(input.IfMatch); err != nil {
+		return nil, err
+	}
+	if err := s.checkDraining(); err != nil {
+		return nil, err
+	}
+	if err := s.checkTakeover(); err != nil {
+		return nil, err
+	}
+	if err := s.checkRunLimits(); err != nil {
+		return nil, err
+	}
+	if err := s.checkMessageRate("command"); err != nil {
+		return nil, err
+	}
+	if err := s.conversation.Send(FormatMessage(s.agentType, message)...); err != nil {
+		return nil, sendError("command", "body.input", err)
+	}
+	s.messageLimiter.record()
+
+	resp := &CommandResponse{}
+	resp.ETag = s.conversationETag()
+	resp.Body.Ok = true
+	return resp, nil
+}
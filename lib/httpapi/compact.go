@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+)
+
+// autoCompactInterval is how often the context usage is checked against
+// the auto-compaction threshold.
+const autoCompactInterval = time.Second
+
+// startAutoCompact sends the agent's compaction command whenever the context
+// usage it shows reaches the configured threshold. The command is sent once
+// per crossing: usage has to drop below the threshold (or disappear from the
+// screen, as Claude Code's indicator does after compacting) before it is
+// sent again. Agents that accept instructions with the command are asked
+// to keep the pinned messages verbatim.
+func (s *Server) startAutoCompact(ctx context.Context) {
+	if s.autoCompactThreshold <= 0 {
+		return
+	}
+	command := mf.CompactCommand(s.agentType)
+	if command == "" {
+		s.logger.Warn("Auto-compaction is not supported for this agent type", "agentType", s.agentType)
+		return
+	}
+
+	compacted := false
+	s.clock.TickerFunc(ctx, autoCompactInterval, func() error {
+		percent, ok := s.emitter.ContextUsedPercent()
+		if !ok || percent < s.autoCompactThreshold {
+			compacted = false
+			return nil
+		}
+		if _, takenOver := s.takenOver(); compacted || takenOver || s.conversation.Status() != st.ConversationStatusStable {
+			return nil
+		}
+
+		s.logger.Info(fmt.Sprintf("Context usage at %d%%, sending %s", percent, command))
+		s.mu.Lock()
+		message := command
+		if mf.CompactAcceptsInstructions(s.agentType) {
+			if instructions := pinnedCompactInstructions(s.conversation.Messages()); instructions != "" {
+				message += " " + instructions
+			}
+		}
+		err := s.conversation.Send(FormatMessage(s.agentType, message)...)
+		s.mu.Unlock()
+		if err != nil {
+			// The agent may have started working in the meantime; retry on
+			// the next tick.
+			s.logger.Warn("Failed to send compaction command", "error", err)
+			return nil
+		}
+		compacted = true
+		s.metrics.Inc("agentapi_auto_compactions_total", "Compaction commands sent because the context usage crossed --auto-compact-threshold.",
+			"agent_type", string(s.agentType))
+		return nil
+	}, "autoCompact")
+}
+
+// pinnedCompactInstructions asks the agent to keep the pinned messages
+// verbatim when compacting, or returns "" if there are none.
+func pinnedCompactInstructions(messages []st.ConversationMessage) string {
+	var sb strings.Builder
+	for _, msg := range messages {
+		if !msg.Pinned {
+			continue
+		}
+		if sb.Len() == 0 {
+			sb.WriteString("Keep these pinned messages verbatim in the summary:")
+		}
+		role := "User"
+		if msg.Role == st.ConversationRoleAgent {
+			role = "Assistant"
+		}
+		fmt.Fprintf(&sb, "\n\n%s: %s", role, mf.TrimWhitespace(msg.Message))
+	}
+	return sb.String()
+}
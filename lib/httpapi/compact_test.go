@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/coder/agentapi/lib/metrics"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sentConversation is a stable st.Conversation that records sent messages.
+type sentConversation struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (c *sentConversation) Messages() []st.ConversationMessage { return nil }
+func (c *sentConversation) Start(context.Context)              {}
+func (c *sentConversation) Status() st.ConversationStatus      { return st.ConversationStatusStable }
+func (c *sentConversation) Text() string                       { return "" }
+func (c *sentConversation) SaveState() error                   { return nil }
+func (c *sentConversation) Notes() map[string]string           { return nil }
+func (c *sentConversation) SetNotes(map[string]string)         {}
+
+func (c *sentConversation) SetMessagePinned(int, bool) error {
+	return st.ErrMessageNotFound
+}
+
+func (c *sentConversation) Send(parts ...st.MessagePart) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	message := ""
+	for _, part := range parts {
+		message += part.String()
+	}
+	c.sent = append(c.sent, message)
+	return nil
+}
+
+func (c *sentConversation) Sent() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.sent...)
+}
+
+func TestAutoCompact(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	mClock := quartz.NewMock(t)
+	emitter := NewEventEmitter(WithAgentType(mf.AgentTypeGemini), WithContextUsageTracking(true))
+	conversation := &sentConversation{}
+	s := &Server{
+		logger:               slog.New(slog.NewTextHandler(io.Discard, nil)),
+		conversation:         conversation,
+		agentType:            mf.AgentTypeGemini,
+		emitter:              emitter,
+		clock:                mClock,
+		metrics:              metrics.New(),
+		autoCompactThreshold: 80,
+	}
+	s.startAutoCompact(ctx)
+
+	tick := func() {
+		t.Helper()
+		_, w := mClock.AdvanceNext()
+		require.NoError(t, w.Wait(ctx))
+	}
+
+	emitter.EmitScreen("> \n(35% context left)")
+	tick()
+	assert.Empty(t, conversation.Sent())
+
+	emitter.EmitScreen("> \n(15% context left)")
+	tick()
+	assert.Equal(t, []string{"/compress"}, conversation.Sent())
+
+	// The command is only sent once per crossing.
+	tick()
+	assert.Len(t, conversation.Sent(), 1)
+
+	emitter.EmitScreen("> \n(90% context left)")
+	tick()
+	emitter.EmitScreen("> \n(10% context left)")
+	tick()
+	assert.Equal(t, []string{"/compress", "/compress"}, conversation.Sent())
+}
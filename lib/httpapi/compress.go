@@ -0,0 +1,40 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// DefaultCompressionLevel balances speed and size for transcripts.
+const DefaultCompressionLevel = 5
+
+// compressibleContentTypes are compressed when the client accepts gzip or
+// deflate: API responses and the chat interface's assets.
+var compressibleContentTypes = []string{
+	"application/json",
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/javascript",
+	"application/javascript",
+	"image/svg+xml",
+}
+
+// compressMiddleware compresses responses at the given level. Event streams
+// are never compressed: the encoder would buffer events until enough data
+// accumulates, delaying them indefinitely.
+func compressMiddleware(level int) func(http.Handler) http.Handler {
+	compress := middleware.NewCompressor(level, compressibleContentTypes...).Handler
+	return func(next http.Handler) http.Handler {
+		compressed := compress(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			compressed.ServeHTTP(w, r)
+		})
+	}
+}
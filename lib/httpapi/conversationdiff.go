@@ -0,0 +1,98 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+// conversationCheckpoint is the last message a client has seen: its id,
+// its length in bytes and a hash of its content, which tells whether later
+// versions only appended to it.
+type conversationCheckpoint struct {
+	id     int
+	length int
+	hash   string
+}
+
+// checkpointHashLength is the number of hex digits of the SHA-256 hash
+// kept in checkpoints.
+const checkpointHashLength = 16
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])[:checkpointHashLength]
+}
+
+func newCheckpoint(messages []st.ConversationMessage) conversationCheckpoint {
+	if len(messages) == 0 {
+		return conversationCheckpoint{id: -1}
+	}
+	last := messages[len(messages)-1]
+	return conversationCheckpoint{id: last.Id, length: len(last.Message), hash: contentHash(last.Message)}
+}
+
+func (c conversationCheckpoint) String() string {
+	return fmt.Sprintf("v1.%d.%d.%s", c.id, c.length, c.hash)
+}
+
+func parseCheckpoint(s string) (conversationCheckpoint, error) {
+	var c conversationCheckpoint
+	if _, err := fmt.Sscanf(strings.ReplaceAll(s, ".", " "), "v1 %d %d %s", &c.id, &c.length, &c.hash); err != nil ||
+		c.id < -1 || c.length < 0 || len(c.hash) != checkpointHashLength {
+		return c, xerrors.Errorf("invalid checkpoint %q", s)
+	}
+	return c, nil
+}
+
+// getConversationDiff handles GET /conversation/diff
+func (s *Server) getConversationDiff(ctx context.Context, input *ConversationDiffRequest) (*ConversationDiffResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := s.conversation.Messages()
+	if input.ToId >= 0 {
+		end := 0
+		for end < len(messages) && messages[end].Id <= input.ToId {
+			end++
+		}
+		messages = messages[:end]
+	}
+
+	from := conversationCheckpoint{id: input.FromId}
+	if input.Since != "" {
+		var err error
+		if from, err = parseCheckpoint(input.Since); err != nil {
+			return nil, huma.Error400BadRequest(err.Error())
+		}
+	}
+
+	resp := &ConversationDiffResponse{}
+	resp.Body.Added = []Message{}
+	for _, msg := range messages {
+		if msg.Id > from.id {
+			resp.Body.Added = append(resp.Body.Added, s.convertMessage(msg))
+			continue
+		}
+		if msg.Id != from.id || input.Since == "" {
+			continue
+		}
+		if len(msg.Message) == from.length && contentHash(msg.Message) == from.hash {
+			continue
+		}
+		change := &MessageChange{Id: msg.Id, Content: msg.Message, Rewritten: true}
+		if len(msg.Message) >= from.length && contentHash(msg.Message[:from.length]) == from.hash {
+			change.Appended = msg.Message[from.length:]
+			change.Rewritten = false
+		}
+		resp.Body.Changed = change
+	}
+	resp.Body.Checkpoint = newCheckpoint(messages).String()
+	return resp, nil
+}
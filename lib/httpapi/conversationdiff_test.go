@@ -0,0 +1,80 @@
+package httpapi
+
+import (
+	"context"
+	"testing"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// messagesConversation is a stable st.Conversation with fixed messages.
+type messagesConversation struct {
+	sentConversation
+	messages []st.ConversationMessage
+}
+
+func (c *messagesConversation) Messages() []st.ConversationMessage { return c.messages }
+
+func TestGetConversationDiff(t *testing.T) {
+	t.Parallel()
+
+	conversation := &messagesConversation{messages: []st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"},
+		{Id: 1, Role: st.ConversationRoleUser, Message: "Fix the bug"},
+		{Id: 2, Role: st.ConversationRoleAgent, Message: "Looking"},
+	}}
+	s := &Server{conversation: conversation, tokenizer: mf.HeuristicTokenizer{}}
+	diff := func(input ConversationDiffRequest) *ConversationDiffResponse {
+		t.Helper()
+		resp, err := s.getConversationDiff(context.Background(), &input)
+		require.NoError(t, err)
+		return resp
+	}
+	ids := func(messages []Message) []int {
+		var ids []int
+		for _, msg := range messages {
+			ids = append(ids, msg.Id)
+		}
+		return ids
+	}
+
+	first := diff(ConversationDiffRequest{FromId: -1, ToId: -1})
+	assert.Equal(t, []int{0, 1, 2}, ids(first.Body.Added))
+	assert.Equal(t, 3, first.Body.Added[1].EstimatedTokens)
+	assert.Nil(t, first.Body.Changed)
+
+	// Nothing changed.
+	resp := diff(ConversationDiffRequest{Since: first.Body.Checkpoint, FromId: -1, ToId: -1})
+	assert.Empty(t, resp.Body.Added)
+	assert.Nil(t, resp.Body.Changed)
+	assert.Equal(t, first.Body.Checkpoint, resp.Body.Checkpoint)
+
+	// The agent kept writing.
+	conversation.messages[2].Message = "Looking at main.go"
+	resp = diff(ConversationDiffRequest{Since: first.Body.Checkpoint, FromId: -1, ToId: -1})
+	require.NotNil(t, resp.Body.Changed)
+	assert.Equal(t, MessageChange{Id: 2, Content: "Looking at main.go", Appended: " at main.go"}, *resp.Body.Changed)
+
+	// The agent redrew the message and new messages were added.
+	conversation.messages[2].Message = "Fixed it"
+	conversation.messages = append(conversation.messages,
+		st.ConversationMessage{Id: 3, Role: st.ConversationRoleUser, Message: "Thanks"},
+		st.ConversationMessage{Id: 4, Role: st.ConversationRoleAgent, Message: "You're welcome"},
+	)
+	resp = diff(ConversationDiffRequest{Since: first.Body.Checkpoint, FromId: -1, ToId: -1})
+	assert.Equal(t, []int{3, 4}, ids(resp.Body.Added))
+	require.NotNil(t, resp.Body.Changed)
+	assert.True(t, resp.Body.Changed.Rewritten)
+	assert.Empty(t, resp.Body.Changed.Appended)
+
+	// Message ID ranges.
+	resp = diff(ConversationDiffRequest{FromId: 1, ToId: 3})
+	assert.Equal(t, []int{2, 3}, ids(resp.Body.Added))
+	assert.Nil(t, resp.Body.Changed)
+
+	_, err := s.getConversationDiff(context.Background(), &ConversationDiffRequest{Since: "garbage"})
+	assert.ErrorContains(t, err, "invalid checkpoint")
+}
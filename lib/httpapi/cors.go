@@ -0,0 +1,78 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/cors"
+)
+
+// CORSConfig configures the CORS headers beyond the allowed origins. Zero
+// fields keep the defaults, which suit the chat interface.
+type CORSConfig struct {
+	AllowedHeaders []string
+	AllowedMethods []string
+	// DisallowCredentials stops browsers from sending cookies and HTTP
+	// authentication with cross-origin requests.
+	DisallowCredentials bool
+	// MaxAge is how long browsers may cache preflight responses.
+	MaxAge time.Duration
+	// AllowPrivateNetwork answers Private Network Access preflights, which
+	// browsers send before a page on a public origin may call a server on
+	// the local network.
+	AllowPrivateNetwork bool
+}
+
+var (
+	DefaultCORSAllowedHeaders = []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match", "If-None-Match"}
+	DefaultCORSAllowedMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+)
+
+// DefaultCORSMaxAge is the maximum value not ignored by any of the major
+// browsers.
+const DefaultCORSMaxAge = 5 * time.Minute
+
+func corsMiddleware(allowedOrigins []string, cfg CORSConfig) func(http.Handler) http.Handler {
+	if len(cfg.AllowedHeaders) == 0 {
+		cfg.AllowedHeaders = DefaultCORSAllowedHeaders
+	}
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = DefaultCORSAllowedMethods
+	}
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = DefaultCORSMaxAge
+	}
+	handler := cors.New(cors.Options{
+		AllowedOrigins:   allowedOrigins,
+		AllowedMethods:   cfg.AllowedMethods,
+		AllowedHeaders:   cfg.AllowedHeaders,
+		ExposedHeaders:   []string{"Link", "ETag"},
+		AllowCredentials: !cfg.DisallowCredentials,
+		MaxAge:           int(cfg.MaxAge.Seconds()),
+	}).Handler
+	if !cfg.AllowPrivateNetwork {
+		return handler
+	}
+	return func(next http.Handler) http.Handler {
+		h := handler(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Private-Network") == "true" {
+				w = &privateNetworkWriter{ResponseWriter: w}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// privateNetworkWriter allows private network access in preflight
+// responses that allow the request's origin.
+type privateNetworkWriter struct {
+	http.ResponseWriter
+}
+
+func (w *privateNetworkWriter) WriteHeader(statusCode int) {
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		w.Header().Set("Access-Control-Allow-Private-Network", "true")
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
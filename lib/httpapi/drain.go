@@ -0,0 +1,104 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// DefaultDrainTimeout is how long draining waits for the agent to finish
+// its turn.
+const DefaultDrainTimeout = 5 * time.Minute
+
+// drainInterval is how often draining checks whether the agent is done.
+const drainInterval = time.Second
+
+// drainRetryAfter is when clients are told to retry the user messages
+// rejected while draining, by which time a replacement server is expected
+// to be up.
+const drainRetryAfter = 10 * time.Second
+
+// Drain stops accepting user messages and waits for the agent to finish
+// its turn and the queued messages to be sent, or for the drain timeout to
+// pass. The channel returned by Drained is then closed. Calling Drain again
+// has no effect.
+func (s *Server) Drain() {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	if !s.drainStart.IsZero() {
+		return
+	}
+	s.drainStart = s.clock.Now()
+	s.logger.Info("Draining, new user messages are rejected", "timeout", s.drainTimeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.clock.TickerFunc(ctx, drainInterval, func() error {
+		if !s.drainComplete() {
+			return nil
+		}
+		cancel()
+		close(s.drained)
+		return nil
+	}, "drain")
+}
+
+// Drained returns a channel closed once draining is complete.
+func (s *Server) Drained() <-chan struct{} {
+	return s.drained
+}
+
+// draining returns when draining started, or false if it didn't.
+func (s *Server) draining() (time.Time, bool) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	return s.drainStart, !s.drainStart.IsZero()
+}
+
+// drainComplete returns whether the agent is done with its turn and the
+// queued messages, or the drain timeout has passed.
+func (s *Server) drainComplete() bool {
+	start, _ := s.draining()
+	if s.clock.Since(start) >= s.drainTimeout {
+		s.logger.Warn("Agent still busy after the drain timeout, shutting down", "timeout", s.drainTimeout)
+		return true
+	}
+	if s.conversation.Status() != st.ConversationStatusStable {
+		return false
+	}
+	if reporter, ok := s.conversation.(st.QueueReporter); ok && reporter.QueueDepth() > 0 {
+		return false
+	}
+	s.logger.Info("Drained, the agent is done with its turn", "duration", s.clock.Since(start))
+	return true
+}
+
+// checkDraining rejects user messages while draining, with a Retry-After
+// header for clients to try again on the server replacing this one.
+func (s *Server) checkDraining() error {
+	if _, ok := s.draining(); !ok {
+		return nil
+	}
+	return huma.ErrorWithHeaders(
+		huma.Error503ServiceUnavailable("the server is draining before shutting down, retry on its replacement"),
+		http.Header{"Retry-After": {strconv.Itoa(int(drainRetryAfter.Seconds()))}},
+	)
+}
+
+// drain handles POST /drain.
+func (s *Server) drain(ctx context.Context, input *struct{}) (*DrainResponse, error) {
+	s.Drain()
+	start, _ := s.draining()
+	resp := &DrainResponse{}
+	resp.Body.Started = start
+	resp.Body.Deadline = start.Add(s.drainTimeout)
+	select {
+	case <-s.drained:
+		resp.Body.Drained = true
+	default:
+	}
+	return resp, nil
+}
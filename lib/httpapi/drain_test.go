@@ -0,0 +1,104 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/metrics"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDrainServer(t *testing.T, conversation st.Conversation) (*Server, *quartz.Mock) {
+	t.Helper()
+	mClock := quartz.NewMock(t)
+	s := &Server{
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		conversation: conversation,
+		emitter:      NewEventEmitter(WithClock(mClock)),
+		clock:        mClock,
+		metrics:      metrics.New(),
+		drainTimeout: time.Minute,
+		drained:      make(chan struct{}),
+	}
+	return s, mClock
+}
+
+func requireDrained(t *testing.T, s *Server, drained bool) {
+	t.Helper()
+	select {
+	case <-s.Drained():
+		require.True(t, drained, "drained")
+	default:
+		require.False(t, drained, "not drained")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	conversation := &statusConversation{status: st.ConversationStatusChanging, queued: 1}
+	s, mClock := newDrainServer(t, conversation)
+	require.NoError(t, s.checkDraining())
+
+	resp, err := s.drain(ctx, &struct{}{})
+	require.NoError(t, err)
+	assert.Equal(t, mClock.Now().Add(time.Minute), resp.Body.Deadline)
+	assert.False(t, resp.Body.Drained)
+
+	// User messages are rejected while draining.
+	err = s.checkDraining()
+	var statusErr huma.StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusServiceUnavailable, statusErr.GetStatus())
+	var headersErr huma.HeadersError
+	require.True(t, errors.As(err, &headersErr))
+	assert.Equal(t, "10", headersErr.GetHeaders().Get("Retry-After"))
+
+	// Draining waits for the agent to finish its turn and the queued
+	// messages to be sent.
+	tick := func() {
+		t.Helper()
+		_, w := mClock.AdvanceNext()
+		require.NoError(t, w.Wait(ctx))
+	}
+	tick()
+	requireDrained(t, s, false)
+	conversation.status = st.ConversationStatusStable
+	tick()
+	requireDrained(t, s, false)
+	conversation.queued = 0
+	tick()
+	requireDrained(t, s, true)
+
+	// Draining again has no effect.
+	resp, err = s.drain(ctx, &struct{}{})
+	require.NoError(t, err)
+	assert.True(t, resp.Body.Drained)
+}
+
+func TestDrainTimeout(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	conversation := &statusConversation{status: st.ConversationStatusChanging}
+	s, mClock := newDrainServer(t, conversation)
+	s.Drain()
+	for range time.Minute/drainInterval - 1 {
+		_, w := mClock.AdvanceNext()
+		require.NoError(t, w.Wait(ctx))
+	}
+	requireDrained(t, s, false)
+	_, w := mClock.AdvanceNext()
+	require.NoError(t, w.Wait(ctx))
+	requireDrained(t, s, true)
+}
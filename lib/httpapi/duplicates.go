@@ -0,0 +1,95 @@
+package httpapi
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// DuplicatePromptConfig configures the detection of user messages that
+// repeat a recent one, which usually means automation retried blindly.
+type DuplicatePromptConfig struct {
+	// Window is how many of the latest user messages are compared with a
+	// new one. 0 disables the detection.
+	Window int
+	// Strict rejects duplicates instead of only warning about them.
+	Strict bool
+}
+
+// duplicatePromptDetector remembers the latest user messages. A nil
+// detector finds no duplicates.
+type duplicatePromptDetector struct {
+	cfg DuplicatePromptConfig
+
+	mu     sync.Mutex
+	recent []string // oldest first
+}
+
+func newDuplicatePromptDetector(cfg DuplicatePromptConfig) *duplicatePromptDetector {
+	if cfg.Window <= 0 {
+		return nil
+	}
+	return &duplicatePromptDetector{cfg: cfg}
+}
+
+// normalizePrompt ignores the whitespace around a prompt, which doesn't
+// change what the agent is asked.
+func normalizePrompt(content string) string {
+	return strings.TrimSpace(content)
+}
+
+// age returns how many user messages ago content was sent, from 1 for the
+// latest, or 0 if it wasn't among the latest ones.
+func (d *duplicatePromptDetector) age(content string) int {
+	if d == nil {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if i := slices.Index(d.recent, normalizePrompt(content)); i >= 0 {
+		return len(d.recent) - i
+	}
+	return 0
+}
+
+// record remembers a user message that was sent.
+func (d *duplicatePromptDetector) record(content string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	content = normalizePrompt(content)
+	// A repeated message moves to the end instead of being kept twice.
+	d.recent = slices.DeleteFunc(d.recent, func(recent string) bool { return recent == content })
+	d.recent = append(d.recent, content)
+	if len(d.recent) > d.cfg.Window {
+		d.recent = slices.Delete(d.recent, 0, len(d.recent)-d.cfg.Window)
+	}
+}
+
+// checkDuplicatePrompt returns a warning if the user message repeats one of
+// the latest ones, or an error if duplicates are rejected.
+func (s *Server) checkDuplicatePrompt(input MessageRequestBody) (string, error) {
+	age := s.duplicatePrompts.age(input.Content)
+	if age == 0 || input.AllowDuplicate {
+		return "", nil
+	}
+	warning := "this message is identical to the latest user message"
+	if age > 1 {
+		warning = fmt.Sprintf("this message is identical to the user message sent %d messages ago", age)
+	}
+	s.metrics.Inc("agentapi_duplicate_prompts_total", "User messages identical to one of the latest ones, by whether they were rejected.",
+		"rejected", fmt.Sprint(s.duplicatePrompts.cfg.Strict))
+	if s.duplicatePrompts.cfg.Strict {
+		return "", huma.Error409Conflict("duplicate prompt: "+warning+", set allow_duplicate to send it anyway", &huma.ErrorDetail{
+			Location: "body.content",
+			Message:  "duplicate of a recent user message",
+		})
+	}
+	s.logger.Warn("Duplicate user message", "age", age)
+	return warning, nil
+}
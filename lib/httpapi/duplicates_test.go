@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/coder/agentapi/lib/metrics"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuplicatePrompts(t *testing.T) {
+	t.Parallel()
+
+	newServer := func(cfg DuplicatePromptConfig) (*Server, *messagesConversation) {
+		conversation := &messagesConversation{}
+		return &Server{
+			conversation:     conversation,
+			agentType:        mf.AgentTypeCustom,
+			tokenizer:        mf.HeuristicTokenizer{},
+			logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+			metrics:          metrics.New(),
+			duplicatePrompts: newDuplicatePromptDetector(cfg),
+		}, conversation
+	}
+	send := func(s *Server, content string, allowDuplicate bool) (*MessageResponse, error) {
+		t.Helper()
+		return s.createMessage(context.Background(), &MessageRequest{
+			Body: MessageRequestBody{Content: content, Type: MessageTypeUser, AllowDuplicate: allowDuplicate},
+		})
+	}
+
+	s, conversation := newServer(DuplicatePromptConfig{Window: 2})
+	resp, err := send(s, "Fix the bug", false)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Body.Warning)
+	resp, err = send(s, "Fix the bug\n", false)
+	require.NoError(t, err)
+	assert.Equal(t, "this message is identical to the latest user message", resp.Body.Warning)
+	_, err = send(s, "Run the tests", false)
+	require.NoError(t, err)
+	resp, err = send(s, "Fix the bug", false)
+	require.NoError(t, err)
+	assert.Equal(t, "this message is identical to the user message sent 2 messages ago", resp.Body.Warning)
+	assert.Len(t, conversation.Sent(), 4)
+	assert.Equal(t, 2.0, s.metrics.Value("agentapi_duplicate_prompts_total", "rejected", "false"))
+
+	// Only the latest messages are compared.
+	_, err = send(s, "Commit", false)
+	require.NoError(t, err)
+	resp, err = send(s, "Run the tests", false)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Body.Warning)
+
+	// Strict mode rejects duplicates unless they're meant.
+	s, conversation = newServer(DuplicatePromptConfig{Window: 2, Strict: true})
+	_, err = send(s, "continue", false)
+	require.NoError(t, err)
+	_, err = send(s, "continue", false)
+	var statusErr huma.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusConflict, statusErr.GetStatus())
+	assert.Contains(t, err.Error(), "duplicate prompt")
+	resp, err = send(s, "continue", true)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Body.Warning)
+	assert.Len(t, conversation.Sent(), 2)
+
+	// Detection is off by default.
+	s, _ = newServer(DuplicatePromptConfig{})
+	_, err = send(s, "continue", false)
+	require.NoError(t, err)
+	resp, err = send(s, "continue", false)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Body.Warning)
+}
@@ -0,0 +1,91 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// conversationETag identifies how far the conversation has advanced: it is
+// the ID of the latest message, quoted as HTTP requires, and changes
+// whenever a message is added. Assumes the caller holds s.mu.
+func (s *Server) conversationETag() string {
+	return strconv.Quote(strconv.Itoa(s.latestMessageId()))
+}
+
+// Assumes the caller holds s.mu.
+func (s *Server) latestMessageId() int {
+	if msg, ok := s.latestMessage(); ok {
+		return msg.Id
+	}
+	return -1
+}
+
+// latestMessage returns the latest message of the conversation. Assumes
+// the caller holds s.mu.
+func (s *Server) latestMessage() (st.ConversationMessage, bool) {
+	// Only the latest message is read, rather than all of them.
+	_, n, _ := st.ReadMessageRange(s.conversation, math.MaxInt, math.MaxInt)
+	if messages, _, _ := st.ReadMessageRange(s.conversation, n-1, n); len(messages) > 0 {
+		return messages[len(messages)-1], true
+	}
+	return st.ConversationMessage{}, false
+}
+
+// messagesETag is the ETag of GET /messages. Unlike conversationETag, it
+// also changes while the agent updates its latest message, so it can be
+// used to poll with If-None-Match. It starts with the latest message ID, so
+// If-Match accepts it too. Assumes the caller holds s.mu.
+func (s *Server) messagesETag(body any) string {
+	return contentETag(strconv.Itoa(s.latestMessageId())+"-", body)
+}
+
+// contentETag is a weak ETag derived from a response body.
+func contentETag(prefix string, body any) string {
+	h := fnv.New64a()
+	_ = json.NewEncoder(h).Encode(body)
+	return fmt.Sprintf(`W/"%s%016x"`, prefix, h.Sum64())
+}
+
+// checkIfMatch rejects a mutation with 412 if the client sent an If-Match
+// header and the conversation has advanced past all the ETags it lists.
+// Assumes the caller holds s.mu.
+func (s *Server) checkIfMatch(ifMatch string) error {
+	if ifMatch == "" {
+		return nil
+	}
+	id := strconv.Itoa(s.latestMessageId())
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+		// Both conversationETag and messagesETag start with the ID.
+		if tagId, _, _ := strings.Cut(tag, "-"); tag == "*" || tagId == id {
+			return nil
+		}
+	}
+	return huma.Error412PreconditionFailed("the conversation has advanced since it was read, its ETag is now " + s.conversationETag())
+}
+
+// checkIfNoneMatch answers a GET with 304 if the client sent an
+// If-None-Match header listing etag, so it can keep the response it has.
+// ETags are compared weakly, as HTTP requires for If-None-Match.
+func checkIfNoneMatch(ifNoneMatch string, etag string) error {
+	if ifNoneMatch == "" {
+		return nil
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == strings.TrimPrefix(etag, "W/") {
+			headers := http.Header{}
+			headers.Set("ETag", etag)
+			return huma.ErrorWithHeaders(huma.Status304NotModified(), headers)
+		}
+	}
+	return nil
+}
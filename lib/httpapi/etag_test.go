@@ -0,0 +1,99 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIfMatch(t *testing.T) {
+	t.Parallel()
+
+	conversation := &messagesConversation{messages: []st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"},
+	}}
+	s := &Server{conversation: conversation, agentType: mf.AgentTypeCustom, tokenizer: mf.HeuristicTokenizer{}}
+	send := func(ifMatch string) error {
+		t.Helper()
+		_, err := s.createMessage(context.Background(), &MessageRequest{
+			IfMatch: ifMatch,
+			Body:    MessageRequestBody{Content: "Fix the bug", Type: MessageTypeUser},
+		})
+		return err
+	}
+
+	messages, err := s.getMessages(context.Background(), &MessagesRequest{})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(messages.ETag, `W/"0-`), messages.ETag)
+
+	require.NoError(t, send(""))
+	require.NoError(t, send(messages.ETag))
+	require.NoError(t, send(`W/"0", "1"`))
+	require.NoError(t, send("*"))
+	assert.Len(t, conversation.Sent(), 4)
+
+	// Another client's message was added since.
+	conversation.messages = append(conversation.messages, st.ConversationMessage{Id: 1, Role: st.ConversationRoleUser, Message: "Run the tests"})
+	err = send(messages.ETag)
+	var statusErr huma.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusPreconditionFailed, statusErr.GetStatus())
+	assert.Contains(t, err.Error(), `its ETag is now "1"`)
+	assert.Len(t, conversation.Sent(), 4)
+
+	empty := &Server{conversation: &messagesConversation{}}
+	assert.Equal(t, `"-1"`, empty.conversationETag())
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	t.Parallel()
+
+	conversation := &messagesConversation{messages: []st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"},
+	}}
+	s := &Server{conversation: conversation, agentType: mf.AgentTypeCustom, tokenizer: mf.HeuristicTokenizer{}, emitter: NewEventEmitter()}
+	getMessages := func(ifNoneMatch string) (*MessagesResponse, error) {
+		t.Helper()
+		return s.getMessages(context.Background(), &MessagesRequest{IfNoneMatch: ifNoneMatch})
+	}
+	requireNotModified := func(t *testing.T, err error, etag string) {
+		t.Helper()
+		var statusErr huma.StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusNotModified, statusErr.GetStatus())
+		var headersErr huma.HeadersError
+		require.ErrorAs(t, err, &headersErr)
+		assert.Equal(t, etag, headersErr.GetHeaders().Get("ETag"))
+	}
+
+	first, err := getMessages("")
+	require.NoError(t, err)
+	_, err = getMessages(first.ETag)
+	requireNotModified(t, err, first.ETag)
+	_, err = getMessages(`"other", ` + strings.TrimPrefix(first.ETag, "W/"))
+	requireNotModified(t, err, first.ETag)
+
+	// The agent updates its message without adding one.
+	conversation.messages = []st.ConversationMessage{{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome back"}}
+	second, err := getMessages(first.ETag)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ETag, second.ETag)
+	assert.Equal(t, "Welcome back", second.Body.Messages[0].Content)
+	// It still identifies the latest message for If-Match.
+	assert.NoError(t, s.checkIfMatch(first.ETag))
+
+	status, err := s.getStatus(context.Background(), &StatusRequest{})
+	require.NoError(t, err)
+	_, err = s.getStatus(context.Background(), &StatusRequest{IfNoneMatch: status.ETag})
+	requireNotModified(t, err, status.ETag)
+	s.tags = map[string]string{"project": "billing"}
+	_, err = s.getStatus(context.Background(), &StatusRequest{IfNoneMatch: status.ETag})
+	assert.NoError(t, err)
+}
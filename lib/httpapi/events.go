@@ -2,25 +2,36 @@ package httpapi
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/coder/quartz"
 
+	"github.com/coder/agentapi/lib/metrics"
 	mf "github.com/coder/agentapi/lib/msgfmt"
 	st "github.com/coder/agentapi/lib/screentracker"
 	"github.com/coder/agentapi/lib/util"
 	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
 )
 
 type EventType string
 
 const (
-	EventTypeMessageUpdate EventType = "message_update"
-	EventTypeStatusChange  EventType = "status_change"
-	EventTypeScreenUpdate  EventType = "screen_update"
-	EventTypeError         EventType = "agent_error"
+	EventTypeMessageUpdate  EventType = "message_update"
+	EventTypeStatusChange   EventType = "status_change"
+	EventTypeScreenUpdate   EventType = "screen_update"
+	EventTypeError          EventType = "agent_error"
+	EventTypeParseWarning   EventType = "parse_warning"
+	EventTypeToolCall       EventType = "tool_call"
+	EventTypeThoughtUpdate  EventType = "thought_update"
+	EventTypePlanUpdate     EventType = "plan_update"
+	EventTypeDiff           EventType = "diff"
+	EventTypeAttention      EventType = "attention"
+	EventTypePendingPrompt  EventType = "pending_prompt"
+	EventTypeNetworkBlocked EventType = "network_blocked"
 )
 
 type AgentStatus string
@@ -40,10 +51,23 @@ func (a AgentStatus) Schema(r huma.Registry) *huma.Schema {
 }
 
 type MessageUpdateBody struct {
-	Id      int                 `json:"id" doc:"Unique identifier for the message. This identifier also represents the order of the message in the conversation history."`
-	Role    st.ConversationRole `json:"role" doc:"Role of the message author"`
-	Message string              `json:"message" doc:"Message content. The message is formatted as it appears in the agent's terminal session, meaning that, by default, it consists of lines of text with 80 characters per line."`
-	Time    time.Time           `json:"time" doc:"Timestamp of the message"`
+	Id         int                 `json:"id" doc:"Unique identifier for the message. This identifier also represents the order of the message in the conversation history."`
+	Role       st.ConversationRole `json:"role" doc:"Role of the message author"`
+	Message    string              `json:"message" doc:"Message content. The message is formatted as it appears in the agent's terminal session, meaning that, by default, it consists of lines of text with 80 characters per line."`
+	Time       time.Time           `json:"time" doc:"Timestamp of the message"`
+	TurnId     int                 `json:"turn_id" doc:"Id of the message that started the turn this message belongs to, as in GET /messages."`
+	Thought    string              `json:"thought,omitempty" doc:"The agent's reasoning for this message, if the transport reports it separately. Changes are published as thought_update events."`
+	Plan       []PlanEntry         `json:"plan,omitempty" doc:"The agent's latest execution plan for this message, if the transport reports it. Changes are published as plan_update events."`
+	Diffs      []FileDiff          `json:"diffs,omitempty" doc:"Files modified while producing this message, if the transport reports them. New diffs are published as diff events."`
+	StopReason st.StopReason       `json:"stop_reason,omitempty" doc:"Why the agent stopped producing this message, if known."`
+	Filtered   bool                `json:"filtered,omitempty" doc:"Whether the message matched an output filter pattern."`
+	Pinned     bool                `json:"pinned,omitempty" doc:"Whether the message is pinned."`
+	Source     st.MessageSource    `json:"source,omitempty" doc:"'terminal' for a user message typed into the agent's terminal rather than sent with POST /message."`
+	Markdown   string              `json:"message_markdown,omitempty" doc:"The message converted to markdown. Only set on agent messages when the server runs with --markdown."`
+	Blocks     []Block             `json:"message_blocks,omitempty" doc:"The message split into prose, code blocks, tool calls and diffs. Only set on agent messages when the server runs with --message-blocks."`
+	ANSI       string              `json:"message_ansi,omitempty" doc:"The message with its terminal colors as ANSI escape sequences. Only set on agent messages when the server runs with --message-colors ansi."`
+	HTML       string              `json:"message_html,omitempty" doc:"The message with its terminal colors as HTML. Only set on agent messages when the server runs with --message-colors html."`
+	Links      []Link              `json:"links,omitempty" doc:"URLs and file references found in an agent message."`
 }
 
 type StatusChangeBody struct {
@@ -51,8 +75,83 @@ type StatusChangeBody struct {
 	AgentType mf.AgentType `json:"agent_type" doc:"Type of the agent being used by the server."`
 }
 
+// ScreenUpdateVersion is the version of ScreenUpdateBody. Version 2 added
+// the terminal size and sequence numbers.
+const ScreenUpdateVersion = 2
+
 type ScreenUpdateBody struct {
-	Screen string `json:"screen"`
+	Version  int    `json:"version" doc:"Version of the screen event schema, incremented when fields are added or changed."`
+	Screen   string `json:"screen"`
+	Width    uint16 `json:"width" doc:"Width of the agent's terminal in columns. Lines of the screen are never wider."`
+	Height   uint16 `json:"height" doc:"Height of the agent's terminal in rows. Trailing blank lines are trimmed from the screen, so it may have fewer."`
+	Sequence uint64 `json:"sequence" doc:"Number of the screen update, incremented with each change of the screen. A gap means updates were dropped."`
+}
+
+// ScreenDiffBody is a screen update encoded as the lines that changed since
+// the previous update sent to the subscriber.
+type ScreenDiffBody struct {
+	Version      int          `json:"version" doc:"Version of the screen event schema, incremented when fields are added or changed."`
+	Width        uint16       `json:"width" doc:"Width of the agent's terminal in columns."`
+	Height       uint16       `json:"height" doc:"Height of the agent's terminal in rows."`
+	Sequence     uint64       `json:"sequence" doc:"Number of the screen update, incremented with each change of the screen."`
+	BaseSequence uint64       `json:"base_sequence" doc:"Sequence number of the screen the diff applies to. With rate limiting, it is usually lower than sequence - 1."`
+	LineCount    int          `json:"line_count" doc:"Number of lines of the new screen. Lines past it are removed."`
+	Lines        []ScreenLine `json:"lines" nullable:"false" doc:"The lines that changed."`
+}
+
+// ScreenLine is a line of the screen.
+type ScreenLine struct {
+	Index   int    `json:"index" doc:"Index of the line, starting at 0."`
+	Content string `json:"content" doc:"Content of the line."`
+}
+
+// diffScreens returns the lines of next that differ from prev.
+func diffScreens(prev, next ScreenUpdateBody) ScreenDiffBody {
+	prevLines := strings.Split(prev.Screen, "\n")
+	nextLines := strings.Split(next.Screen, "\n")
+	diff := ScreenDiffBody{
+		Version:      next.Version,
+		Width:        next.Width,
+		Height:       next.Height,
+		Sequence:     next.Sequence,
+		BaseSequence: prev.Sequence,
+		LineCount:    len(nextLines),
+		Lines:        []ScreenLine{},
+	}
+	for i, line := range nextLines {
+		if i >= len(prevLines) || prevLines[i] != line {
+			diff.Lines = append(diff.Lines, ScreenLine{Index: i, Content: line})
+		}
+	}
+	return diff
+}
+
+// Apply returns the screen update the diff encodes, given the screen with
+// the diff's base sequence number.
+func (d ScreenDiffBody) Apply(base ScreenUpdateBody) (ScreenUpdateBody, error) {
+	if base.Sequence != d.BaseSequence {
+		return ScreenUpdateBody{}, xerrors.Errorf("screen diff applies to screen %d, not %d", d.BaseSequence, base.Sequence)
+	}
+	lines := strings.Split(base.Screen, "\n")
+	if d.LineCount < len(lines) {
+		lines = lines[:d.LineCount]
+	}
+	for len(lines) < d.LineCount {
+		lines = append(lines, "")
+	}
+	for _, line := range d.Lines {
+		if line.Index < 0 || line.Index >= len(lines) {
+			return ScreenUpdateBody{}, xerrors.Errorf("screen diff line %d out of range", line.Index)
+		}
+		lines[line.Index] = line.Content
+	}
+	return ScreenUpdateBody{
+		Version:  d.Version,
+		Screen:   strings.Join(lines, "\n"),
+		Width:    d.Width,
+		Height:   d.Height,
+		Sequence: d.Sequence,
+	}, nil
 }
 
 type ErrorBody struct {
@@ -61,6 +160,69 @@ type ErrorBody struct {
 	Time    time.Time     `json:"time" doc:"Timestamp when the error occurred"`
 }
 
+type ParseIssue struct {
+	Kind   mf.ParseIssueKind `json:"kind" doc:"Kind of the suspected parsing problem, e.g. 'box_drawing' or 'echoed_input'."`
+	Detail string            `json:"detail" doc:"Human-readable explanation of why the message was flagged."`
+}
+
+type ParseWarningBody struct {
+	MessageId int          `json:"message_id" doc:"Id of the agent message that was flagged."`
+	AgentType mf.AgentType `json:"agent_type" doc:"Type of the agent being used by the server."`
+	Issues    []ParseIssue `json:"issues" doc:"Heuristics that flagged the message as likely containing unremoved terminal UI."`
+	Time      time.Time    `json:"time" doc:"Timestamp when the warning was raised"`
+}
+
+type ToolCallBody struct {
+	Id     string            `json:"id" doc:"Identifier of the tool call, shared by all events about the same call."`
+	Name   string            `json:"name" doc:"Name of the tool."`
+	Input  string            `json:"input" doc:"Tool input as reported by the agent, usually JSON."`
+	Status st.ToolCallStatus `json:"status" doc:"Status of the tool call."`
+	TurnId int               `json:"turn_id" doc:"Id of the message that started the turn the tool call was made in, as in GET /messages."`
+	Time   time.Time         `json:"time" doc:"Timestamp of the event"`
+}
+
+type ThoughtUpdateBody struct {
+	MessageId int    `json:"message_id" doc:"Id of the agent message the thought belongs to."`
+	Thought   string `json:"thought" doc:"The agent's reasoning accumulated so far for the message."`
+}
+
+type PlanUpdateBody struct {
+	MessageId int         `json:"message_id" doc:"Id of the agent message the plan belongs to."`
+	Entries   []PlanEntry `json:"entries" nullable:"false" doc:"The complete plan. Each update replaces the previous one."`
+}
+
+type DiffBody struct {
+	MessageId int `json:"message_id" doc:"Id of the agent message the diff belongs to."`
+	FileDiff
+}
+
+type AttentionBody struct {
+	Reason string    `json:"reason" enum:"bell" doc:"What the agent did to ask for attention. 'bell' means it rang the terminal bell."`
+	Title  string    `json:"title,omitempty" doc:"Terminal window title set by the agent at the time, if any."`
+	Time   time.Time `json:"time" doc:"Timestamp of the event"`
+}
+
+type NetworkBlockedBody struct {
+	Host     string    `json:"host" doc:"Host the sandboxed agent tried to reach. Allow it with POST /sandbox/network/allow."`
+	Attempts int       `json:"attempts" doc:"Number of times the agent tried to reach the host so far."`
+	Time     time.Time `json:"time" doc:"Timestamp of the event"`
+}
+
+type PromptOption struct {
+	Key      string `json:"key" doc:"Identifies the option in POST /pending-prompt/reply. It's the number or letter the agent shows for the option."`
+	Label    string `json:"label" doc:"Text of the option."`
+	Selected bool   `json:"selected" doc:"Whether the option is the one chosen by pressing Enter."`
+}
+
+type PendingPrompt struct {
+	Question string         `json:"question" doc:"The question asked by the agent, if it could be identified."`
+	Options  []PromptOption `json:"options" nullable:"false" doc:"The options offered by the agent."`
+}
+
+type PendingPromptBody struct {
+	Prompt *PendingPrompt `json:"prompt,omitempty" doc:"The prompt the agent is waiting on. Absent once the prompt has been answered."`
+}
+
 type Event struct {
 	Type    EventType
 	Payload any
@@ -77,8 +239,55 @@ type EventEmitter struct {
 	screen              string
 	errors              []ErrorBody
 	clock               quartz.Clock
+	// checkParseQuality enables parse warnings for agent messages that
+	// likely contain unremoved terminal UI. Only meaningful for PTY agents.
+	checkParseQuality bool
+	// lastCheckedMessage is the last agent message inspected for parse
+	// issues, so each finalized message is only checked once.
+	lastCheckedMessage st.ConversationMessage
+	metrics            *metrics.Registry
+	// trackContextUsage enables parsing the context window usage indicator
+	// some agents show on screen. Only meaningful for PTY agents.
+	trackContextUsage  bool
+	contextUsedPercent int
+	contextUsedKnown   bool
+	// parseStartupBanner enables parsing the agent's configuration from the
+	// banner it shows when it starts, until the first user message. Only
+	// meaningful for PTY agents.
+	parseStartupBanner bool
+	startupBanner      mf.StartupBanner
+	startupBannerDone  bool
+	// sinks receive finalized messages, screens, status changes and tool
+	// calls for --tee-output and log export.
+	sinks []transcriptSink
+	// nextTranscriptMessage is the index of the first message not yet
+	// written to the sinks.
+	nextTranscriptMessage int
+	// turns is reported by GET /analytics.
+	turns turnStats
+	// tokenizer and pricing estimate the usage of each turn written to the
+	// sinks. Usage isn't written if tokenizer is nil. lastUsageTurn is the
+	// id of the user message of the last turn written.
+	tokenizer     mf.Tokenizer
+	pricing       PricingConfig
+	lastUsageTurn int
+	// terminalWidth and terminalHeight are reported in screen updates,
+	// and screenSequence numbers them.
+	terminalWidth  uint16
+	terminalHeight uint16
+	screenSequence uint64
+	// detectPrompts enables pending_prompt events for the interactive
+	// prompts, such as permission dialogs, the agent shows on screen. Only
+	// meaningful for PTY agents.
+	detectPrompts bool
+	pendingPrompt *mf.Prompt
+	// ownsMessages is set when messages was built by EmitMessageWindow
+	// rather than given to EmitMessages, so it can be updated in place.
+	ownsMessages bool
 }
 
+var _ st.MessageWindowEmitter = &EventEmitter{}
+
 func convertStatus(status st.ConversationStatus) AgentStatus {
 	switch status {
 	case st.ConversationStatusInitializing:
@@ -115,18 +324,75 @@ func WithAgentType(agentType mf.AgentType) EventEmitterOption {
 	}
 }
 
+// WithTerminalSize sets the size of the agent's terminal reported in
+// screen updates.
+func WithTerminalSize(width, height uint16) EventEmitterOption {
+	return func(e *EventEmitter) {
+		e.terminalWidth = width
+		e.terminalHeight = height
+	}
+}
+
 func WithClock(clock quartz.Clock) EventEmitterOption {
 	return func(e *EventEmitter) {
 		e.clock = clock
 	}
 }
 
+// WithParseQualityCheck enables parse_warning events. Each time the agent
+// becomes stable, its last message is checked for leftover terminal UI.
+func WithParseQualityCheck(enabled bool) EventEmitterOption {
+	return func(e *EventEmitter) {
+		e.checkParseQuality = enabled
+	}
+}
+
+// WithContextUsageTracking enables reading the context window usage from
+// the indicators agents such as Claude Code and Gemini CLI show on screen.
+func WithContextUsageTracking(enabled bool) EventEmitterOption {
+	return func(e *EventEmitter) {
+		e.trackContextUsage = enabled
+	}
+}
+
+// WithStartupBannerParsing enables reading the agent's version, model and
+// workspace from the banner it shows on screen when it starts.
+func WithStartupBannerParsing(enabled bool) EventEmitterOption {
+	return func(e *EventEmitter) {
+		e.parseStartupBanner = enabled
+	}
+}
+
+// WithPromptDetection enables pending_prompt events. While the agent is
+// stable, its screen is searched for prompts such as permission dialogs.
+func WithPromptDetection(enabled bool) EventEmitterOption {
+	return func(e *EventEmitter) {
+		e.detectPrompts = enabled
+	}
+}
+
+// withTranscriptSinks sends conversation events to the given sinks.
+// Messages are sent once the agent becomes stable after producing them.
+func withTranscriptSinks(sinks ...transcriptSink) EventEmitterOption {
+	return func(e *EventEmitter) {
+		e.sinks = sinks
+	}
+}
+
+func WithMetrics(m *metrics.Registry) EventEmitterOption {
+	return func(e *EventEmitter) {
+		e.metrics = m
+	}
+}
+
 func NewEventEmitter(opts ...EventEmitterOption) *EventEmitter {
 	e := &EventEmitter{
 		messages:            make([]st.ConversationMessage, 0),
 		status:              AgentStatusRunning,
 		chans:               make(map[int]chan Event),
 		subscriptionBufSize: defaultSubscriptionBufSize,
+		turns:               newTurnStats(),
+		lastUsageTurn:       -1,
 	}
 	for _, opt := range opts {
 		opt(e)
@@ -166,30 +432,48 @@ func (e *EventEmitter) EmitMessages(newMessages []st.ConversationMessage) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	maxLength := max(len(e.messages), len(newMessages))
-	for i := range maxLength {
+	e.notifyMessageChangesLocked(0, newMessages)
+	e.messages = newMessages
+	e.ownsMessages = false
+}
+
+// EmitMessageWindow updates the messages from start on, leaving earlier
+// ones as they are.
+func (e *EventEmitter) EmitMessageWindow(start int, messages []st.ConversationMessage) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	start = min(start, len(e.messages))
+	e.notifyMessageChangesLocked(start, messages)
+	if !e.ownsMessages {
+		// The slice given to EmitMessages may be shared with the
+		// conversation.
+		e.messages = slices.Clone(e.messages[:start])
+		e.ownsMessages = true
+	}
+	e.messages = append(e.messages[:start], messages...)
+}
+
+// notifyMessageChangesLocked publishes updates of the messages that differ
+// from those held, starting at index start. Assumes the caller holds the
+// lock.
+func (e *EventEmitter) notifyMessageChangesLocked(start int, messages []st.ConversationMessage) {
+	for i, newMsg := range messages {
 		var oldMsg st.ConversationMessage
-		var newMsg st.ConversationMessage
-		if i < len(e.messages) {
-			oldMsg = e.messages[i]
+		if start+i < len(e.messages) {
+			oldMsg = e.messages[start+i]
 		}
-		if i < len(newMessages) {
-			newMsg = newMessages[i]
+		// Thought and plan changes have their own events.
+		if sameMessageContent(oldMsg, newMsg) {
+			continue
 		}
-		if oldMsg != newMsg {
-			if i >= len(newMessages) {
-				continue
-			}
-			e.notifyChannels(EventTypeMessageUpdate, MessageUpdateBody{
-				Id:      newMessages[i].Id,
-				Role:    newMessages[i].Role,
-				Message: newMessages[i].Message,
-				Time:    newMessages[i].Time,
-			})
+		e.notifyChannels(EventTypeMessageUpdate, messageUpdateBody(newMsg))
+		if newMsg.Role == st.ConversationRoleUser {
+			e.turns.userMessage(newMsg.Id, e.clock.Now())
+		} else {
+			e.turns.agentActivity(e.clock.Now())
 		}
 	}
-
-	e.messages = newMessages
 }
 
 func (e *EventEmitter) EmitStatus(newStatus st.ConversationStatus) {
@@ -203,6 +487,81 @@ func (e *EventEmitter) EmitStatus(newStatus st.ConversationStatus) {
 
 	e.notifyChannels(EventTypeStatusChange, StatusChangeBody{Status: newAgentStatus, AgentType: e.agentType})
 	e.status = newAgentStatus
+	if newAgentStatus == AgentStatusStable {
+		e.writeMessagesLocked()
+		e.writeUsageLocked()
+		e.turns.stable(e.clock.Now())
+	}
+	e.writeRecordLocked(transcriptRecord{Type: transcriptRecordStatus, Time: e.clock.Now(), Status: newAgentStatus})
+
+	if newAgentStatus == AgentStatusStable && e.checkParseQuality {
+		e.checkParseQualityLocked()
+	}
+	e.updatePendingPromptLocked()
+}
+
+// writeMessagesLocked sends the messages added since the agent was last
+// stable to the transcript sinks. Assumes the caller holds the lock.
+func (e *EventEmitter) writeMessagesLocked() {
+	for _, msg := range e.messages[min(e.nextTranscriptMessage, len(e.messages)):] {
+		e.writeRecordLocked(messageRecord(msg))
+	}
+	e.nextTranscriptMessage = len(e.messages)
+}
+
+// flushTranscript sends the messages of the turn in progress to the
+// transcript sinks, which otherwise only get them once the agent is stable.
+// It's called when the server stops.
+func (e *EventEmitter) flushTranscript() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.writeMessagesLocked()
+}
+
+// Assumes the caller holds the lock.
+func (e *EventEmitter) writeRecordLocked(record transcriptRecord) {
+	for _, sink := range e.sinks {
+		sink.writeRecord(record)
+	}
+}
+
+// checkParseQualityLocked runs the msgfmt parse heuristics on the last
+// agent message and emits a parse_warning event if any of them match.
+// Assumes the caller holds the lock.
+func (e *EventEmitter) checkParseQualityLocked() {
+	if len(e.messages) == 0 {
+		return
+	}
+	lastMessage := e.messages[len(e.messages)-1]
+	if lastMessage.Role != st.ConversationRoleAgent || sameMessageContent(lastMessage, e.lastCheckedMessage) {
+		return
+	}
+	e.lastCheckedMessage = lastMessage
+
+	var userInput string
+	for i := len(e.messages) - 1; i >= 0; i-- {
+		if e.messages[i].Role == st.ConversationRoleUser {
+			userInput = e.messages[i].Message
+			break
+		}
+	}
+
+	issues := mf.DetectParseIssues(lastMessage.Message, userInput)
+	if len(issues) == 0 {
+		return
+	}
+	body := ParseWarningBody{
+		MessageId: lastMessage.Id,
+		AgentType: e.agentType,
+		Issues:    make([]ParseIssue, 0, len(issues)),
+		Time:      e.clock.Now(),
+	}
+	for _, issue := range issues {
+		body.Issues = append(body.Issues, ParseIssue{Kind: issue.Kind, Detail: issue.Detail})
+		e.metrics.Inc("agentapi_parse_warnings_total", "Agent messages suspected to contain unremoved terminal UI.",
+			"agent_type", string(e.agentType), "kind", string(issue.Kind))
+	}
+	e.notifyChannels(EventTypeParseWarning, body)
 }
 
 func (e *EventEmitter) EmitScreen(newScreen string) {
@@ -213,8 +572,108 @@ func (e *EventEmitter) EmitScreen(newScreen string) {
 		return
 	}
 
-	e.notifyChannels(EventTypeScreenUpdate, ScreenUpdateBody{Screen: strings.TrimRight(newScreen, mf.WhiteSpaceChars)})
 	e.screen = newScreen
+	e.screenSequence++
+	e.notifyChannels(EventTypeScreenUpdate, e.screenUpdateBody())
+
+	if len(e.sinks) > 0 {
+		e.writeRecordLocked(transcriptRecord{Type: transcriptRecordScreen, Time: e.clock.Now(), Content: newScreen})
+	}
+
+	if e.trackContextUsage {
+		e.contextUsedPercent, e.contextUsedKnown = mf.ContextUsedPercent(e.agentType, newScreen)
+	}
+	e.updateStartupBannerLocked()
+	e.updatePendingPromptLocked()
+}
+
+// updateStartupBannerLocked merges the startup banner fields shown on the
+// screen. Once the user sent a message, the screen shows the conversation
+// rather than the banner, and parsing stops. Assumes the caller holds the
+// lock.
+func (e *EventEmitter) updateStartupBannerLocked() {
+	if !e.parseStartupBanner || e.startupBannerDone {
+		return
+	}
+	if slices.ContainsFunc(e.messages, func(msg st.ConversationMessage) bool { return msg.Role == st.ConversationRoleUser }) {
+		e.startupBannerDone = true
+		return
+	}
+	e.startupBanner = e.startupBanner.Merge(mf.ParseStartupBanner(e.agentType, e.screen))
+}
+
+// StartupBanner returns the configuration the agent showed when it
+// started. ok is false if none of it was recognized.
+func (e *EventEmitter) StartupBanner() (banner mf.StartupBanner, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.startupBanner, !e.startupBanner.IsZero()
+}
+
+// updatePendingPromptLocked looks for a prompt on the screen and emits a
+// pending_prompt event when it changes. A prompt is only pending while the
+// agent is stable, since the screen may show a half drawn one otherwise.
+// Assumes the caller holds the lock.
+func (e *EventEmitter) updatePendingPromptLocked() {
+	if !e.detectPrompts {
+		return
+	}
+	var pending *mf.Prompt
+	if e.status == AgentStatusStable {
+		if prompt, ok := mf.DetectPrompt(e.screen); ok {
+			pending = &prompt
+		}
+	}
+	if pending == nil && e.pendingPrompt == nil ||
+		pending != nil && e.pendingPrompt != nil && pending.Equal(*e.pendingPrompt) {
+		return
+	}
+	e.pendingPrompt = pending
+	e.notifyChannels(EventTypePendingPrompt, pendingPromptBody(pending))
+}
+
+// PendingPrompt returns the prompt the agent is waiting on, if any.
+func (e *EventEmitter) PendingPrompt() (mf.Prompt, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.pendingPrompt == nil {
+		return mf.Prompt{}, false
+	}
+	return *e.pendingPrompt, true
+}
+
+func convertStartupBanner(banner mf.StartupBanner) *StartupBanner {
+	return &StartupBanner{
+		Version:          banner.Version,
+		Model:            banner.Model,
+		Workspace:        banner.Workspace,
+		WorkspaceTrusted: banner.WorkspaceTrusted,
+		MCPServers:       banner.MCPServers,
+		MCPServersFailed: banner.MCPServersFailed,
+	}
+}
+
+func convertPrompt(prompt mf.Prompt) *PendingPrompt {
+	pending := &PendingPrompt{Question: prompt.Question, Options: make([]PromptOption, 0, len(prompt.Options))}
+	for _, option := range prompt.Options {
+		pending.Options = append(pending.Options, PromptOption{Key: option.Key, Label: option.Label, Selected: option.Selected})
+	}
+	return pending
+}
+
+func pendingPromptBody(prompt *mf.Prompt) PendingPromptBody {
+	if prompt == nil {
+		return PendingPromptBody{}
+	}
+	return PendingPromptBody{Prompt: convertPrompt(*prompt)}
+}
+
+// ContextUsedPercent returns the share of the context window the agent
+// currently shows as used. ok is false if the agent doesn't show it.
+func (e *EventEmitter) ContextUsedPercent() (percent int, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.contextUsedPercent, e.contextUsedKnown
 }
 
 func (e *EventEmitter) EmitError(message string, level st.ErrorLevel) {
@@ -234,6 +693,113 @@ func (e *EventEmitter) EmitError(message string, level st.ErrorLevel) {
 	}
 
 	e.notifyChannels(EventTypeError, errorBody)
+	e.writeRecordLocked(transcriptRecord{Type: transcriptRecordError, Time: errorBody.Time, Content: message, Level: level})
+}
+
+// EmitToolCall publishes a tool call. Tool calls are not replayed to new
+// subscribers; the messages already describe them.
+func (e *EventEmitter) EmitToolCall(toolCall st.ToolCall) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	body := ToolCallBody{
+		Id:     toolCall.Id,
+		Name:   toolCall.Name,
+		Input:  toolCall.Input,
+		Status: toolCall.Status,
+		TurnId: toolCall.TurnId,
+		Time:   e.clock.Now(),
+	}
+	e.notifyChannels(EventTypeToolCall, body)
+	e.turns.toolCall(toolCall)
+	e.turns.agentActivity(body.Time)
+	e.writeRecordLocked(transcriptRecord{Type: transcriptRecordToolCall, Time: body.Time, ToolCall: &body})
+}
+
+// EmitThought publishes the agent's reasoning for a message. Like tool
+// calls, thoughts are not replayed; new subscribers get them from the
+// message_update events.
+func (e *EventEmitter) EmitThought(messageId int, thought string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.notifyChannels(EventTypeThoughtUpdate, ThoughtUpdateBody{MessageId: messageId, Thought: thought})
+}
+
+// EmitPlan publishes the agent's plan for a message.
+func (e *EventEmitter) EmitPlan(messageId int, plan []st.PlanEntry) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.notifyChannels(EventTypePlanUpdate, PlanUpdateBody{MessageId: messageId, Entries: convertPlan(plan)})
+}
+
+// EmitDiff publishes a file diff made by one of the agent's tool calls.
+func (e *EventEmitter) EmitDiff(messageId int, diff st.FileDiff) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.notifyChannels(EventTypeDiff, DiffBody{MessageId: messageId, FileDiff: convertDiff(diff)})
+}
+
+// EmitAttention publishes that the agent rang the terminal bell. Like
+// tool calls, attention events are not replayed to new subscribers.
+func (e *EventEmitter) EmitAttention(title string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.notifyChannels(EventTypeAttention, AttentionBody{Reason: "bell", Title: title, Time: e.clock.Now()})
+}
+
+// EmitNetworkBlocked publishes an attempt of the sandboxed agent to reach a
+// host that isn't allowed. Like tool calls, blocked attempts are not
+// replayed; GET /sandbox/network lists them.
+func (e *EventEmitter) EmitNetworkBlocked(host string, attempts int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.notifyChannels(EventTypeNetworkBlocked, NetworkBlockedBody{Host: host, Attempts: attempts, Time: e.clock.Now()})
+}
+
+// sameMessageContent reports whether two messages have the same id, role,
+// content, time, stop reason and filtered flag, ignoring the agent's
+// thought, plan and diffs.
+func sameMessageContent(a, b st.ConversationMessage) bool {
+	return a.Id == b.Id && a.Role == b.Role && a.Message == b.Message && a.Time == b.Time && a.StopReason == b.StopReason &&
+		a.Filtered == b.Filtered && a.Pinned == b.Pinned
+}
+
+func messageUpdateBody(msg st.ConversationMessage) MessageUpdateBody {
+	return MessageUpdateBody{
+		Id:         msg.Id,
+		Role:       msg.Role,
+		Message:    msg.Message,
+		Time:       msg.Time,
+		TurnId:     msg.TurnId,
+		Thought:    msg.Thought,
+		Plan:       convertPlan(msg.Plan),
+		Diffs:      convertDiffs(msg.Diffs),
+		StopReason: msg.StopReason,
+		Filtered:   msg.Filtered,
+		Pinned:     msg.Pinned,
+		Source:     msg.Source,
+		Markdown:   msg.Markdown,
+		Blocks:     convertBlocks(msg.Blocks),
+		ANSI:       msg.ANSI,
+		HTML:       msg.HTML,
+		Links:      convertLinks(msg.Links),
+	}
+}
+
+// Assumes the caller holds the lock.
+func (e *EventEmitter) screenUpdateBody() ScreenUpdateBody {
+	return ScreenUpdateBody{
+		Version:  ScreenUpdateVersion,
+		Screen:   strings.TrimRight(e.screen, mf.WhiteSpaceChars),
+		Width:    e.terminalWidth,
+		Height:   e.terminalHeight,
+		Sequence: e.screenSequence,
+	}
 }
 
 // Assumes the caller holds the lock.
@@ -242,7 +808,7 @@ func (e *EventEmitter) currentStateAsEvents() []Event {
 	for _, msg := range e.messages {
 		events = append(events, Event{
 			Type:    EventTypeMessageUpdate,
-			Payload: MessageUpdateBody{Id: msg.Id, Role: msg.Role, Message: msg.Message, Time: msg.Time},
+			Payload: messageUpdateBody(msg),
 		})
 	}
 	events = append(events, Event{
@@ -251,9 +817,16 @@ func (e *EventEmitter) currentStateAsEvents() []Event {
 	})
 	events = append(events, Event{
 		Type:    EventTypeScreenUpdate,
-		Payload: ScreenUpdateBody{Screen: strings.TrimRight(e.screen, mf.WhiteSpaceChars)},
+		Payload: e.screenUpdateBody(),
 	})
 
+	if e.pendingPrompt != nil {
+		events = append(events, Event{
+			Type:    EventTypePendingPrompt,
+			Payload: pendingPromptBody(e.pendingPrompt),
+		})
+	}
+
 	// Include all error events
 	for _, err := range e.errors {
 		events = append(events, Event{
@@ -287,8 +860,12 @@ func (e *EventEmitter) unsubscribeInner(chanId int) {
 	delete(e.chans, chanId)
 }
 
+// Unsubscribe closes the subscription's channel. It is a no-op if the
+// emitter already closed it because the subscriber fell behind.
 func (e *EventEmitter) Unsubscribe(chanId int) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	e.unsubscribeInner(chanId)
+	if _, ok := e.chans[chanId]; ok {
+		e.unsubscribeInner(chanId)
+	}
 }
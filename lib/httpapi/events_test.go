@@ -5,6 +5,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/coder/agentapi/lib/metrics"
+	mf "github.com/coder/agentapi/lib/msgfmt"
 	st "github.com/coder/agentapi/lib/screentracker"
 	"github.com/coder/quartz"
 	"github.com/stretchr/testify/assert"
@@ -22,7 +24,7 @@ func TestEventEmitter(t *testing.T) {
 			},
 			{
 				Type:    EventTypeScreenUpdate,
-				Payload: ScreenUpdateBody{Screen: ""},
+				Payload: ScreenUpdateBody{Version: ScreenUpdateVersion},
 			},
 		}, stateEvents)
 
@@ -60,6 +62,43 @@ func TestEventEmitter(t *testing.T) {
 		}, newEvent)
 	})
 
+	t.Run("message-window", func(t *testing.T) {
+		emitter := NewEventEmitter(WithSubscriptionBufSize(10))
+		now := time.Now()
+		messages := []st.ConversationMessage{
+			{Id: 0, Message: "Welcome", Role: st.ConversationRoleAgent, Time: now},
+			{Id: 1, Message: "Hi", Role: st.ConversationRoleUser, Time: now},
+			{Id: 2, Message: "Hel", Role: st.ConversationRoleAgent, Time: now},
+		}
+		emitter.EmitMessages(messages)
+		_, ch, _ := emitter.Subscribe()
+
+		// Only the messages in the window are compared and replaced.
+		emitter.EmitMessageWindow(2, []st.ConversationMessage{
+			{Id: 2, Message: "Hello", Role: st.ConversationRoleAgent, Time: now},
+			{Id: 3, Message: "Bye", Role: st.ConversationRoleUser, Time: now},
+		})
+		assert.Equal(t, MessageUpdateBody{Id: 2, Message: "Hello", Role: st.ConversationRoleAgent, Time: now}, (<-ch).Payload)
+		assert.Equal(t, MessageUpdateBody{Id: 3, Message: "Bye", Role: st.ConversationRoleUser, Time: now}, (<-ch).Payload)
+		assert.Empty(t, ch)
+		// The slice given to EmitMessages isn't modified.
+		assert.Equal(t, "Hel", messages[2].Message)
+
+		emitter.EmitMessageWindow(3, []st.ConversationMessage{
+			{Id: 3, Message: "Bye", Role: st.ConversationRoleUser, Time: now},
+		})
+		assert.Empty(t, ch)
+
+		_, _, stateEvents := emitter.Subscribe()
+		var contents []string
+		for _, event := range stateEvents {
+			if body, ok := event.Payload.(MessageUpdateBody); ok {
+				contents = append(contents, body.Message)
+			}
+		}
+		assert.Equal(t, []string{"Welcome", "Hi", "Hello", "Bye"}, contents)
+	})
+
 	t.Run("multiple-subscriptions", func(t *testing.T) {
 		emitter := NewEventEmitter(WithSubscriptionBufSize(10))
 		channels := make([]<-chan Event, 0, 10)
@@ -198,4 +237,198 @@ func TestEventEmitter(t *testing.T) {
 		assert.Equal(t, st.ErrorLevelWarning, errorBody.Level)
 		assert.Equal(t, newTime, errorBody.Time)
 	})
+
+	t.Run("parse-warning", func(t *testing.T) {
+		registry := metrics.New()
+		emitter := NewEventEmitter(
+			WithSubscriptionBufSize(10),
+			WithAgentType(mf.AgentTypeClaude),
+			WithParseQualityCheck(true),
+			WithMetrics(registry),
+		)
+		_, ch, _ := emitter.Subscribe()
+
+		now := time.Now()
+		emitter.EmitMessages([]st.ConversationMessage{
+			{Id: 0, Message: "Welcome", Role: st.ConversationRoleAgent, Time: now},
+			{Id: 1, Message: "Please refactor the parser", Role: st.ConversationRoleUser, Time: now},
+			{Id: 2, Message: "> Please refactor the parser\n\nDone.", Role: st.ConversationRoleAgent, Time: now},
+		})
+		for range 3 {
+			<-ch
+		}
+
+		emitter.EmitStatus(st.ConversationStatusStable)
+		assert.Equal(t, EventTypeStatusChange, (<-ch).Type)
+		event := <-ch
+		assert.Equal(t, EventTypeParseWarning, event.Type)
+		body, ok := event.Payload.(ParseWarningBody)
+		assert.True(t, ok)
+		assert.Equal(t, 2, body.MessageId)
+		assert.Equal(t, mf.AgentTypeClaude, body.AgentType)
+		assert.Len(t, body.Issues, 1)
+		assert.Equal(t, mf.ParseIssueEchoedInput, body.Issues[0].Kind)
+		assert.Equal(t, float64(1), registry.Value("agentapi_parse_warnings_total", "agent_type", "claude", "kind", "echoed_input"))
+
+		// The same message is not checked twice.
+		emitter.EmitStatus(st.ConversationStatusChanging)
+		emitter.EmitStatus(st.ConversationStatusStable)
+		assert.Equal(t, EventTypeStatusChange, (<-ch).Type)
+		assert.Equal(t, EventTypeStatusChange, (<-ch).Type)
+		assert.Empty(t, ch)
+	})
+
+	t.Run("parse-warning-disabled", func(t *testing.T) {
+		emitter := NewEventEmitter(WithSubscriptionBufSize(10))
+		_, ch, _ := emitter.Subscribe()
+		emitter.EmitMessages([]st.ConversationMessage{
+			{Id: 0, Message: "Please refactor the parser", Role: st.ConversationRoleUser, Time: time.Now()},
+			{Id: 1, Message: "> Please refactor the parser", Role: st.ConversationRoleAgent, Time: time.Now()},
+		})
+		emitter.EmitStatus(st.ConversationStatusStable)
+		for range 3 {
+			assert.NotEqual(t, EventTypeParseWarning, (<-ch).Type)
+		}
+		assert.Empty(t, ch)
+	})
+
+	t.Run("tool-call", func(t *testing.T) {
+		mockClock := quartz.NewMock(t)
+		emitter := NewEventEmitter(WithSubscriptionBufSize(10), WithClock(mockClock))
+		_, ch, _ := emitter.Subscribe()
+		emitter.EmitToolCall(st.ToolCall{Id: "t1", Name: "Bash", Input: `{"command":"ls"}`, Status: st.ToolCallStatusStarted})
+		assert.Equal(t, Event{
+			Type:    EventTypeToolCall,
+			Payload: ToolCallBody{Id: "t1", Name: "Bash", Input: `{"command":"ls"}`, Status: st.ToolCallStatusStarted, Time: mockClock.Now()},
+		}, <-ch)
+
+		// Tool calls are not part of the replayed state.
+		_, _, stateEvents := emitter.Subscribe()
+		for _, event := range stateEvents {
+			assert.NotEqual(t, EventTypeToolCall, event.Type)
+		}
+	})
+
+	t.Run("screen-update", func(t *testing.T) {
+		emitter := NewEventEmitter(WithSubscriptionBufSize(10), WithTerminalSize(80, 24))
+		_, ch, _ := emitter.Subscribe()
+		emitter.EmitScreen("> hello  \n\n")
+		emitter.EmitScreen("> hello  \n\n")
+		emitter.EmitScreen("> world")
+		assert.Equal(t, Event{
+			Type:    EventTypeScreenUpdate,
+			Payload: ScreenUpdateBody{Version: ScreenUpdateVersion, Screen: "> hello", Width: 80, Height: 24, Sequence: 1},
+		}, <-ch)
+		assert.Equal(t, uint64(2), (<-ch).Payload.(ScreenUpdateBody).Sequence)
+
+		// New subscribers get the sequence number of the current screen.
+		_, _, stateEvents := emitter.Subscribe()
+		assert.Contains(t, stateEvents, Event{
+			Type:    EventTypeScreenUpdate,
+			Payload: ScreenUpdateBody{Version: ScreenUpdateVersion, Screen: "> world", Width: 80, Height: 24, Sequence: 2},
+		})
+	})
+
+	t.Run("context-usage", func(t *testing.T) {
+		emitter := NewEventEmitter(WithAgentType(mf.AgentTypeClaude), WithContextUsageTracking(true))
+		_, ok := emitter.ContextUsedPercent()
+		assert.False(t, ok)
+
+		emitter.EmitScreen("> \n  Context left until auto-compact: 25%")
+		percent, ok := emitter.ContextUsedPercent()
+		assert.True(t, ok)
+		assert.Equal(t, 75, percent)
+
+		// The indicator disappears after compacting.
+		emitter.EmitScreen("> ")
+		_, ok = emitter.ContextUsedPercent()
+		assert.False(t, ok)
+
+		untracked := NewEventEmitter(WithAgentType(mf.AgentTypeClaude))
+		untracked.EmitScreen("Context left until auto-compact: 25%")
+		_, ok = untracked.ContextUsedPercent()
+		assert.False(t, ok)
+	})
+
+	t.Run("startup-banner", func(t *testing.T) {
+		emitter := NewEventEmitter(WithAgentType(mf.AgentTypeClaude), WithStartupBannerParsing(true))
+		_, ok := emitter.StartupBanner()
+		assert.False(t, ok)
+
+		// The banner is drawn over several screens.
+		emitter.EmitScreen("╭─── Claude Code v2.0.14 ───╮")
+		emitter.EmitScreen("╭─── Claude Code v2.0.14 ───╮\n│   Sonnet 4.5 · Claude Max   │")
+		emitter.EmitScreen("> ")
+		banner, ok := emitter.StartupBanner()
+		assert.True(t, ok)
+		assert.Equal(t, "2.0.14", banner.Version)
+		assert.Equal(t, "Sonnet 4.5", banner.Model)
+
+		// The conversation isn't taken for the banner.
+		emitter.EmitMessages([]st.ConversationMessage{{Id: 0, Role: st.ConversationRoleUser, Message: "switch models"}})
+		emitter.EmitScreen("● Model: claude-opus-4-1")
+		banner, _ = emitter.StartupBanner()
+		assert.Equal(t, "Sonnet 4.5", banner.Model)
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		emitter := NewEventEmitter(WithSubscriptionBufSize(10))
+		_, ch, _ := emitter.Subscribe()
+		emitter.EmitDiff(1, st.FileDiff{
+			ToolCallId: "call_1",
+			Path:       "main.go",
+			NewFile:    true,
+			Hunks:      []st.DiffHunk{{NewStart: 1, NewLines: 1, Lines: []string{"+package main"}}},
+		})
+		assert.Equal(t, Event{
+			Type: EventTypeDiff,
+			Payload: DiffBody{MessageId: 1, FileDiff: FileDiff{
+				ToolCallId: "call_1",
+				Path:       "main.go",
+				NewFile:    true,
+				Hunks:      []DiffHunk{{NewStart: 1, NewLines: 1, Lines: []string{"+package main"}}},
+			}},
+		}, <-ch)
+	})
+
+	t.Run("thought-and-plan", func(t *testing.T) {
+		emitter := NewEventEmitter(WithSubscriptionBufSize(10))
+		_, ch, _ := emitter.Subscribe()
+		now := time.Now()
+		emitter.EmitMessages([]st.ConversationMessage{
+			{Id: 1, Message: "", Role: st.ConversationRoleAgent, Time: now},
+		})
+		assert.Equal(t, EventTypeMessageUpdate, (<-ch).Type)
+
+		// Reasoning changes don't produce message updates of their own.
+		plan := []st.PlanEntry{{Content: "Read the code", Priority: "high", Status: "in_progress"}}
+		emitter.EmitMessages([]st.ConversationMessage{
+			{Id: 1, Message: "", Role: st.ConversationRoleAgent, Time: now, Thought: "Let me think", Plan: plan},
+		})
+		assert.Empty(t, ch)
+
+		emitter.EmitThought(1, "Let me think")
+		assert.Equal(t, Event{
+			Type:    EventTypeThoughtUpdate,
+			Payload: ThoughtUpdateBody{MessageId: 1, Thought: "Let me think"},
+		}, <-ch)
+		emitter.EmitPlan(1, plan)
+		assert.Equal(t, Event{
+			Type:    EventTypePlanUpdate,
+			Payload: PlanUpdateBody{MessageId: 1, Entries: []PlanEntry{{Content: "Read the code", Priority: "high", Status: "in_progress"}}},
+		}, <-ch)
+
+		// New subscribers get the reasoning through the replayed messages.
+		_, _, stateEvents := emitter.Subscribe()
+		assert.Equal(t, Event{
+			Type: EventTypeMessageUpdate,
+			Payload: MessageUpdateBody{
+				Id:      1,
+				Role:    st.ConversationRoleAgent,
+				Time:    now,
+				Thought: "Let me think",
+				Plan:    []PlanEntry{{Content: "Read the code", Priority: "high", Status: "in_progress"}},
+			},
+		}, stateEvents[0])
+	})
 }
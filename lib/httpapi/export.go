@@ -0,0 +1,165 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+// exportPageSize is how many messages GET /messages/export reads and
+// converts at a time.
+const exportPageSize = 1000
+
+var exportContentTypes = map[MessagesExportFormat]string{
+	MessagesExportNDJSON:    "application/x-ndjson",
+	MessagesExportJSON:      "application/json",
+	MessagesExportMarkdown:  "text/markdown; charset=utf-8",
+	MessagesExportOpenAI:    "application/x-ndjson",
+	MessagesExportAnthropic: "application/x-ndjson",
+}
+
+// exportMessages handles GET /messages/export. Unlike GET /messages, the
+// response is written while the messages are read, a page at a time.
+func (s *Server) exportMessages(ctx context.Context, input *MessagesExportRequest) (*huma.StreamResponse, error) {
+	s.mu.RLock()
+	_, n, err := st.ReadMessageRange(s.conversation, math.MaxInt, math.MaxInt)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read messages: %w", err)
+	}
+
+	return &huma.StreamResponse{Body: func(hctx huma.Context) {
+		hctx.SetHeader("Content-Type", exportContentTypes[input.Format])
+		if err := s.writeMessagesExport(hctx.BodyWriter(), input, n); err != nil {
+			// The status was sent already, so the truncated response is
+			// the only sign of the error for the client.
+			s.logger.Error("Failed to export messages", "error", err)
+		}
+	}}, nil
+}
+
+// writeMessagesExport writes the first n messages in the requested format,
+// flushing out after each page.
+func (s *Server) writeMessagesExport(out io.Writer, input *MessagesExportRequest, n int) error {
+	format := input.Format
+	w := bufio.NewWriter(out)
+	encoder := json.NewEncoder(w)
+	var example *fineTuneWriter
+	switch format {
+	case MessagesExportJSON:
+		if _, err := w.WriteString(`{"messages":[`); err != nil {
+			return err
+		}
+	case MessagesExportOpenAI, MessagesExportAnthropic:
+		example = newFineTuneWriter(w, input)
+		if err := example.begin(); err != nil {
+			return err
+		}
+	}
+	for start := 0; start < n; start += exportPageSize {
+		page, err := s.exportPage(start, min(start+exportPageSize, n))
+		if err != nil {
+			return err
+		}
+		for i, msg := range page {
+			switch format {
+			case MessagesExportJSON:
+				if start+i > 0 {
+					if err := w.WriteByte(','); err != nil {
+						return err
+					}
+				}
+				err = encoder.Encode(msg)
+			case MessagesExportNDJSON:
+				err = encoder.Encode(msg)
+			case MessagesExportMarkdown:
+				err = writeMarkdownMessage(w, msg)
+			case MessagesExportOpenAI, MessagesExportAnthropic:
+				err = example.add(msg)
+			}
+			if err != nil {
+				return xerrors.Errorf("failed to write message %d: %w", msg.Id, err)
+			}
+		}
+		if err := flushExport(w, out); err != nil {
+			return err
+		}
+	}
+	switch format {
+	case MessagesExportJSON:
+		if _, err := w.WriteString("]}\n"); err != nil {
+			return err
+		}
+	case MessagesExportOpenAI, MessagesExportAnthropic:
+		if err := example.end(); err != nil {
+			return err
+		}
+	}
+	return flushExport(w, out)
+}
+
+// exportPage reads and converts the messages from start up to end. The
+// lock is only held for the page, so that exports don't hold back
+// messages sent meanwhile.
+func (s *Server) exportPage(start, end int) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	messages, _, err := st.ReadMessageRange(s.conversation, start, end)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read messages: %w", err)
+	}
+	page := make([]Message, len(messages))
+	for i, msg := range messages {
+		page[i] = s.convertMessage(msg)
+	}
+	return page, nil
+}
+
+// flushExport sends what was written to w so far to the client through
+// out, the response body.
+func flushExport(w *bufio.Writer, out io.Writer) error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if flusher, ok := out.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// writeMarkdownMessage writes a message as a heading with its role and time,
+// followed by the content. Agent messages are fenced, since they're drawn
+// for a terminal, unless they were converted to markdown. With their colors
+// as HTML, they're preformatted HTML instead, which markdown renders.
+func writeMarkdownMessage(w io.Writer, msg Message) error {
+	role := "User"
+	if msg.Role == st.ConversationRoleAgent {
+		role = "Agent"
+	}
+	content := msg.Content
+	if msg.Role == st.ConversationRoleAgent {
+		if msg.Markdown != "" {
+			content = msg.Markdown
+		} else if msg.HTML != "" {
+			content = "<pre>" + msg.HTML + "</pre>"
+		} else if content != "" {
+			fence := codeFence(content)
+			content = fence + "\n" + content + "\n" + fence
+		}
+	}
+	pinned := ""
+	if msg.Pinned {
+		pinned = ", pinned"
+	}
+	_, err := fmt.Fprintf(w, "### %s (%s%s)\n\n%s\n\n", role, msg.Time.UTC().Format(time.RFC3339), pinned, content)
+	return err
+}
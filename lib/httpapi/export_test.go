@@ -0,0 +1,100 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2/humatest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flushCountingRecorder counts how many times the response is flushed.
+type flushCountingRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (r *flushCountingRecorder) Flush() {
+	r.flushes++
+	r.ResponseRecorder.Flush()
+}
+
+func TestExportMessages(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	const count = 100_000
+	conversation := &messagesConversation{messages: make([]st.ConversationMessage, count)}
+	for i := range conversation.messages {
+		role := st.ConversationRoleAgent
+		if i%2 == 1 {
+			role = st.ConversationRoleUser
+		}
+		conversation.messages[i] = st.ConversationMessage{Id: i, Role: role, Message: "message", Time: now}
+	}
+	s := &Server{conversation: conversation, agentType: mf.AgentTypeCustom, tokenizer: mf.HeuristicTokenizer{}}
+	export := func(t *testing.T, format MessagesExportFormat) *flushCountingRecorder {
+		t.Helper()
+		resp, err := s.exportMessages(context.Background(), &MessagesExportRequest{Format: format})
+		require.NoError(t, err)
+		recorder := &flushCountingRecorder{ResponseRecorder: httptest.NewRecorder()}
+		req := httptest.NewRequest(http.MethodGet, "/messages/export", nil)
+		resp.Body(humatest.NewContext(nil, req, recorder))
+		return recorder
+	}
+
+	t.Run("ndjson", func(t *testing.T) {
+		t.Parallel()
+		recorder := export(t, MessagesExportNDJSON)
+		assert.Equal(t, "application/x-ndjson", recorder.Header().Get("Content-Type"))
+		// The messages are written a page at a time.
+		assert.Equal(t, count/exportPageSize+1, recorder.flushes)
+
+		scanner := bufio.NewScanner(recorder.Body)
+		id := 0
+		for scanner.Scan() {
+			var msg Message
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &msg))
+			require.Equal(t, id, msg.Id)
+			id++
+		}
+		require.NoError(t, scanner.Err())
+		assert.Equal(t, count, id)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+		recorder := export(t, MessagesExportJSON)
+		var body struct {
+			Messages []Message `json:"messages"`
+		}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+		require.Len(t, body.Messages, count)
+		assert.Equal(t, count-1, body.Messages[count-1].Id)
+		assert.Equal(t, st.ConversationRoleUser, body.Messages[count-1].Role)
+	})
+}
+
+func TestWriteMarkdownMessage(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var sb strings.Builder
+	require.NoError(t, writeMarkdownMessage(&sb, Message{Role: st.ConversationRoleUser, Content: "Fix the bug", Time: now}))
+	require.NoError(t, writeMarkdownMessage(&sb, Message{Role: st.ConversationRoleAgent, Content: "● Done\n  ```go", Time: now}))
+	require.NoError(t, writeMarkdownMessage(&sb, Message{Role: st.ConversationRoleAgent, Content: "Done", Markdown: "**Done**", Time: now, Pinned: true}))
+	require.NoError(t, writeMarkdownMessage(&sb, Message{Role: st.ConversationRoleAgent, Content: "- a", HTML: `<span style="color:#cd0000">- a</span>`, Time: now}))
+	assert.Equal(t, "### User (2025-01-01T00:00:00Z)\n\nFix the bug\n\n"+
+		"### Agent (2025-01-01T00:00:00Z)\n\n````\n● Done\n  ```go\n````\n\n"+
+		"### Agent (2025-01-01T00:00:00Z, pinned)\n\n**Done**\n\n"+
+		"### Agent (2025-01-01T00:00:00Z)\n\n<pre><span style=\"color:#cd0000\">- a</span></pre>\n\n", sb.String())
+}
@@ -0,0 +1,246 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+// DefaultFilesMaxSize is the largest file returned by GET /files/{path}
+// unless configured otherwise.
+const DefaultFilesMaxSize = 1 << 20 // 1MB
+
+// FilesConfig configures the read-only file browser of GET /files.
+type FilesConfig struct {
+	// Root is the directory browsed, the agent's working directory.
+	// Defaults to the server's working directory.
+	Root string
+	// Allow lists path.Match patterns, relative to Root, of the files that
+	// may be read. A file is allowed if it or one of its parent directories
+	// matches. Hidden files, such as .env or .git, are never served. Empty
+	// disables the file browser.
+	Allow []string
+	// MaxSize is the largest file returned, in bytes. Defaults to
+	// DefaultFilesMaxSize.
+	MaxSize int64
+}
+
+type fileBrowser struct {
+	root    string
+	allow   [][]string
+	maxSize int64
+}
+
+func newFileBrowser(cfg FilesConfig) (*fileBrowser, error) {
+	if len(cfg.Allow) == 0 {
+		return nil, nil
+	}
+	if cfg.Root == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, xerrors.Errorf("failed to get working directory: %w", err)
+		}
+		cfg.Root = wd
+	}
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = DefaultFilesMaxSize
+	}
+	b := &fileBrowser{root: cfg.Root, maxSize: cfg.MaxSize}
+	for _, pattern := range cfg.Allow {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, xerrors.Errorf("invalid file pattern %q: %w", pattern, err)
+		}
+		b.allow = append(b.allow, strings.Split(path.Clean(pattern), "/"))
+	}
+	return b, nil
+}
+
+// allowed reports whether a path relative to the root may be read. A
+// directory that doesn't match may still be listed if the files it
+// contains could, so that they can be browsed to.
+func (b *fileBrowser) allowed(rel string, dir bool) bool {
+	if rel == "." {
+		return true
+	}
+	parts := strings.Split(rel, "/")
+	for _, part := range parts {
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	for _, pattern := range b.allow {
+		n := min(len(pattern), len(parts))
+		if n < len(pattern) && !dir {
+			continue
+		}
+		if ok, _ := path.Match(strings.Join(pattern[:n], "/"), strings.Join(parts[:n], "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanFilePath converts a path from a request to a path relative to the
+// root, or returns false if it is outside of it.
+func cleanFilePath(p string) (string, bool) {
+	// Clients encode the slashes of the path parameter.
+	if unescaped, err := url.PathUnescape(p); err == nil {
+		p = unescaped
+	}
+	p = path.Clean("/" + p)[1:]
+	if p == "" {
+		p = "."
+	}
+	return p, fs.ValidPath(p)
+}
+
+// stat returns the entry of an allowed file or directory, or a 404 error.
+func (b *fileBrowser) stat(rel string) (FileEntry, error) {
+	root, clean, info, err := b.open(rel)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	_ = root.Close()
+	return convertFileInfo(clean, info), nil
+}
+
+// open opens the root and stats an allowed file or directory in it. The
+// caller must close the root.
+func (b *fileBrowser) open(rel string) (*os.Root, string, fs.FileInfo, error) {
+	notFound := huma.Error404NotFound("file not found: " + rel)
+	clean, ok := cleanFilePath(rel)
+	if !ok {
+		return nil, "", nil, notFound
+	}
+	// os.Root also stops symbolic links from leading out of the root.
+	root, err := os.OpenRoot(b.root)
+	if err != nil {
+		return nil, "", nil, xerrors.Errorf("failed to open workspace: %w", err)
+	}
+	info, err := root.Stat(clean)
+	if err != nil || !b.allowed(clean, info.IsDir()) {
+		_ = root.Close()
+		return nil, "", nil, notFound
+	}
+	return root, clean, info, nil
+}
+
+func (b *fileBrowser) read(rel string) (FileBody, error) {
+	root, clean, info, err := b.open(rel)
+	if err != nil {
+		return FileBody{}, err
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+
+	body := FileBody{FileEntry: convertFileInfo(clean, info)}
+	if info.IsDir() {
+		body.Entries, err = b.list(root, clean)
+		return body, err
+	}
+	if !info.Mode().IsRegular() {
+		return FileBody{}, huma.Error404NotFound("file not found: " + rel)
+	}
+	if info.Size() > b.maxSize {
+		return FileBody{}, huma.NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than %d bytes", b.maxSize))
+	}
+	f, err := root.Open(clean)
+	if err != nil {
+		return FileBody{}, xerrors.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	content, err := io.ReadAll(io.LimitReader(f, b.maxSize))
+	if err != nil {
+		return FileBody{}, xerrors.Errorf("failed to read file: %w", err)
+	}
+	if utf8.Valid(content) {
+		body.Content = string(content)
+		body.Encoding = FileEncodingUTF8
+	} else {
+		body.Content = base64.StdEncoding.EncodeToString(content)
+		body.Encoding = FileEncodingBase64
+	}
+	return body, nil
+}
+
+func (b *fileBrowser) list(root *os.Root, dir string) ([]FileEntry, error) {
+	f, err := root.Open(dir)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open directory: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	dirEntries, err := f.ReadDir(-1)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read directory: %w", err)
+	}
+	entries := []FileEntry{}
+	for _, dirEntry := range dirEntries {
+		rel := path.Join(dir, dirEntry.Name())
+		info, err := dirEntry.Info()
+		if err != nil || !b.allowed(rel, info.IsDir()) {
+			continue
+		}
+		entries = append(entries, convertFileInfo(rel, info))
+	}
+	// Directories first, then by name.
+	slices.SortFunc(entries, func(a, b FileEntry) int {
+		if a.Type != b.Type {
+			return strings.Compare(string(a.Type), string(b.Type))
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return entries, nil
+}
+
+func convertFileInfo(rel string, info fs.FileInfo) FileEntry {
+	entry := FileEntry{
+		Name:     path.Base(rel),
+		Path:     rel,
+		Type:     FileTypeFile,
+		Size:     info.Size(),
+		Modified: info.ModTime(),
+	}
+	if info.IsDir() {
+		entry.Type = FileTypeDirectory
+		entry.Size = 0
+	}
+	return entry
+}
+
+func (s *Server) readFile(rel string) (*FileResponse, error) {
+	if s.files == nil {
+		return nil, huma.Error404NotFound("the file browser is disabled, enable it with --files-allow")
+	}
+	body, err := s.files.read(rel)
+	if err != nil {
+		return nil, err
+	}
+	return &FileResponse{Body: body}, nil
+}
+
+// getFiles handles GET /files
+func (s *Server) getFiles(ctx context.Context, input *struct{}) (*FileResponse, error) {
+	return s.readFile(".")
+}
+
+// getFile handles GET /files/{path}
+func (s *Server) getFile(ctx context.Context, input *FileRequest) (*FileResponse, error) {
+	return s.readFile(input.Path)
+}
@@ -0,0 +1,83 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileBrowser(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeFile := func(name string, content string) {
+		t.Helper()
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	writeFile("README.md", "# Project")
+	writeFile(".env", "SECRET=1")
+	writeFile("src/main.go", "package main")
+	writeFile("src/.git/config", "[core]")
+	writeFile("src/logo.png", "\x89PNG\x00")
+	writeFile("src/large.txt", "0123456789abcdef")
+	writeFile("vendor/lib.go", "package lib")
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	require.NoError(t, os.WriteFile(outside, []byte("secret"), 0o644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "src", "link.txt")))
+
+	files, err := newFileBrowser(FilesConfig{Root: root, Allow: []string{"*.md", "src/*"}, MaxSize: 12})
+	require.NoError(t, err)
+	s := &Server{files: files}
+	requireStatus := func(t *testing.T, err error, status int) {
+		t.Helper()
+		var statusErr huma.StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, status, statusErr.GetStatus())
+	}
+	names := func(entries []FileEntry) []string {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Path)
+		}
+		return names
+	}
+
+	resp, err := s.getFiles(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, FileTypeDirectory, resp.Body.Type)
+	assert.Equal(t, []string{"src", "README.md"}, names(resp.Body.Entries))
+
+	resp, err = s.getFile(context.Background(), &FileRequest{Path: "src"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"src/large.txt", "src/link.txt", "src/logo.png", "src/main.go"}, names(resp.Body.Entries))
+
+	resp, err = s.getFile(context.Background(), &FileRequest{Path: "src%2Fmain.go"})
+	require.NoError(t, err)
+	assert.Equal(t, "package main", resp.Body.Content)
+	assert.Equal(t, FileEncodingUTF8, resp.Body.Encoding)
+
+	resp, err = s.getFile(context.Background(), &FileRequest{Path: "src/logo.png"})
+	require.NoError(t, err)
+	assert.Equal(t, FileEncodingBase64, resp.Body.Encoding)
+	assert.Equal(t, "iVBORwA=", resp.Body.Content)
+
+	_, err = s.getFile(context.Background(), &FileRequest{Path: "src/large.txt"})
+	requireStatus(t, err, http.StatusRequestEntityTooLarge)
+
+	for _, path := range []string{".env", "src/.git/config", "vendor/lib.go", "vendor", "../secret.txt", "src/link.txt", "missing.md"} {
+		_, err = s.getFile(context.Background(), &FileRequest{Path: path})
+		requireStatus(t, err, http.StatusNotFound)
+	}
+
+	disabled := &Server{}
+	_, err = disabled.getFiles(context.Background(), nil)
+	requireStatus(t, err, http.StatusNotFound)
+}
@@ -0,0 +1,167 @@
+package httpapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+)
+
+// fineTuneMessage is a message of a fine-tuning example, in the chat format
+// shared by the OpenAI and Anthropic fine-tuning APIs.
+type fineTuneMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// fineTuneWriter writes a conversation as one fine-tuning example, a
+// single JSONL line, while its messages are read a page at a time.
+//
+// Fine-tuning APIs expect the conversation to open with a user message and
+// roles to alternate, so agent messages before the first user message, such
+// as the agent's welcome screen, are left out, and consecutive messages of
+// the same role are merged.
+type fineTuneWriter struct {
+	w         *bufio.Writer
+	format    MessagesExportFormat
+	system    string
+	userRole  string
+	agentRole string
+	// pending is the latest message, written once a message of the other
+	// role follows.
+	pending *fineTuneMessage
+	// written is whether a message was written to the messages array.
+	written bool
+}
+
+func newFineTuneWriter(w *bufio.Writer, input *MessagesExportRequest) *fineTuneWriter {
+	ft := &fineTuneWriter{
+		w:         w,
+		format:    input.Format,
+		system:    input.System,
+		userRole:  input.UserRole,
+		agentRole: input.AgentRole,
+	}
+	if ft.userRole == "" {
+		ft.userRole = "user"
+	}
+	if ft.agentRole == "" {
+		ft.agentRole = "assistant"
+	}
+	return ft
+}
+
+// begin opens the example. OpenAI takes the system prompt as the first
+// message, Anthropic as a separate field.
+func (ft *fineTuneWriter) begin() error {
+	if ft.format == MessagesExportAnthropic {
+		if _, err := ft.w.WriteString("{"); err != nil {
+			return err
+		}
+		if ft.system != "" {
+			system, err := json.Marshal(ft.system)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(ft.w, `"system":%s,`, system); err != nil {
+				return err
+			}
+		}
+		_, err := ft.w.WriteString(`"messages":[`)
+		return err
+	}
+	if _, err := ft.w.WriteString(`{"messages":[`); err != nil {
+		return err
+	}
+	if ft.system != "" {
+		return ft.write(fineTuneMessage{Role: "system", Content: ft.system})
+	}
+	return nil
+}
+
+// add adds a message of the conversation to the example.
+func (ft *fineTuneWriter) add(msg Message) error {
+	role := ft.userRole
+	if msg.Role == st.ConversationRoleAgent {
+		if ft.pending == nil {
+			// No user message yet.
+			return nil
+		}
+		role = ft.agentRole
+	}
+	content := fineTuneContent(msg)
+	if content == "" {
+		return nil
+	}
+	if ft.pending != nil && ft.pending.Role == role {
+		ft.pending.Content += "\n\n" + content
+		return nil
+	}
+	if ft.pending != nil {
+		if err := ft.write(*ft.pending); err != nil {
+			return err
+		}
+	}
+	ft.pending = &fineTuneMessage{Role: role, Content: content}
+	return nil
+}
+
+// end writes the latest message and closes the example.
+func (ft *fineTuneWriter) end() error {
+	if ft.pending != nil {
+		if err := ft.write(*ft.pending); err != nil {
+			return err
+		}
+		ft.pending = nil
+	}
+	_, err := ft.w.WriteString("]}\n")
+	return err
+}
+
+func (ft *fineTuneWriter) write(msg fineTuneMessage) error {
+	if ft.written {
+		if err := ft.w.WriteByte(','); err != nil {
+			return err
+		}
+	}
+	// Unlike json.Encoder, Marshal doesn't end the value with a newline,
+	// which would split the example over several lines.
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ft.written = true
+	_, err = ft.w.Write(data)
+	return err
+}
+
+// fineTuneContent is the text of a message in a fine-tuning example. Agent
+// messages are taken as markdown when it's available, followed by a marker
+// for each file their tool calls modified.
+func fineTuneContent(msg Message) string {
+	content := msg.Content
+	if msg.Role == st.ConversationRoleAgent && msg.Markdown != "" {
+		content = msg.Markdown
+	}
+	content = strings.TrimSpace(content)
+	if msg.Role != st.ConversationRoleAgent {
+		return content
+	}
+	var markers []string
+	for _, diff := range msg.Diffs {
+		action := "edit"
+		if diff.NewFile {
+			action = "create"
+		}
+		markers = append(markers, fmt.Sprintf("[tool call: %s %s]", action, diff.Path))
+	}
+	if len(markers) == 0 {
+		return content
+	}
+	if content != "" {
+		content += "\n\n"
+	}
+	return content + strings.Join(markers, "\n")
+}
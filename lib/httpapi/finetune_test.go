@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"bytes"
+	"testing"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFineTuneExport(t *testing.T) {
+	t.Parallel()
+
+	conversation := &messagesConversation{messages: []st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome to the agent"},
+		{Id: 1, Role: st.ConversationRoleUser, Message: "Add a README"},
+		{Id: 2, Role: st.ConversationRoleAgent, Message: "  Added README.md  "},
+		{Id: 3, Role: st.ConversationRoleUser, Message: "Mention the license"},
+		{Id: 4, Role: st.ConversationRoleUser, Message: "and the authors"},
+		{Id: 5, Role: st.ConversationRoleAgent, Message: "Done"},
+	}}
+	s := &Server{conversation: conversation, agentType: mf.AgentTypeCustom, tokenizer: mf.HeuristicTokenizer{}}
+	export := func(t *testing.T, input *MessagesExportRequest) string {
+		t.Helper()
+		var buf bytes.Buffer
+		require.NoError(t, s.writeMessagesExport(&buf, input, len(conversation.messages)))
+		return buf.String()
+	}
+
+	t.Run("openai", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t,
+			`{"messages":[{"role":"system","content":"You are a coding agent."},{"role":"user","content":"Add a README"},{"role":"assistant","content":"Added README.md"},{"role":"user","content":"Mention the license\n\nand the authors"},{"role":"assistant","content":"Done"}]}`+"\n",
+			export(t, &MessagesExportRequest{Format: MessagesExportOpenAI, System: "You are a coding agent."}))
+	})
+
+	t.Run("anthropic", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t,
+			`{"system":"You are a coding agent.","messages":[{"role":"human","content":"Add a README"},{"role":"assistant","content":"Added README.md"},{"role":"human","content":"Mention the license\n\nand the authors"},{"role":"assistant","content":"Done"}]}`+"\n",
+			export(t, &MessagesExportRequest{Format: MessagesExportAnthropic, System: "You are a coding agent.", UserRole: "human"}))
+	})
+}
+
+func TestFineTuneContent(t *testing.T) {
+	t.Parallel()
+
+	// Markdown is preferred, and modified files are marked.
+	assert.Equal(t, "Refactored the **parser**\n\n[tool call: edit parser.go]\n[tool call: create parser_test.go]", fineTuneContent(Message{
+		Role:     st.ConversationRoleAgent,
+		Content:  "Refactored the parser",
+		Markdown: "Refactored the **parser**\n",
+		Diffs: []FileDiff{
+			{Path: "parser.go"},
+			{Path: "parser_test.go", NewFile: true},
+		},
+	}))
+	assert.Equal(t, "Fix the parser", fineTuneContent(Message{Role: st.ConversationRoleUser, Content: " Fix the parser\n"}))
+}
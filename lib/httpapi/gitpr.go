@@ -0,0 +1,338 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+// GitHubConfig configures POST /git/pr.
+type GitHubConfig struct {
+	// Token is used to push the branch and open the pull request. The
+	// endpoint is disabled when empty.
+	Token string
+	// APIURL is the GitHub REST API base URL. Defaults to
+	// https://api.github.com.
+	APIURL string
+	// GitURL is the base URL the branch is pushed to, as
+	// <GitURL>/<owner>/<repo>.git. Defaults to https://github.com.
+	GitURL string
+	// Dir is the git repository the agent works in. Defaults to the
+	// server's working directory.
+	Dir string
+}
+
+const (
+	defaultGitHubAPIURL = "https://api.github.com"
+	defaultGitHubGitURL = "https://github.com"
+	// githubHost is the host the origin remote must point to.
+	githubHost = "github.com"
+	// prSummaryMaxLength caps each message quoted in the pull request
+	// description.
+	prSummaryMaxLength = 4000
+)
+
+// parseGitHubRemote returns the owner and repository of an HTTPS or SSH
+// remote URL pointing to github.com, e.g. https://github.com/acme/widgets.git
+// or git@github.com:acme/widgets.git. ok is false for other hosts.
+func parseGitHubRemote(remote string) (owner, repo string, ok bool) {
+	var host, path string
+	if strings.Contains(remote, "://") {
+		u, err := url.Parse(remote)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "ssh") || u.Port() != "" {
+			return "", "", false
+		}
+		host, path = u.Hostname(), u.Path
+	} else {
+		// The scp-like syntax, [user@]host:path.
+		var found bool
+		if host, path, found = strings.Cut(remote, ":"); !found {
+			return "", "", false
+		}
+		if _, h, found := strings.Cut(host, "@"); found {
+			host = h
+		}
+	}
+	if !strings.EqualFold(host, githubHost) {
+		return "", "", false
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	owner, repo, found := strings.Cut(path, "/")
+	if !found || owner == "" || repo == "" || strings.Contains(repo, "/") || owner == ".." || repo == ".." {
+		return "", "", false
+	}
+	return owner, repo, true
+}
+
+type pullRequestCreator struct {
+	cfg    GitHubConfig
+	client *http.Client
+	// clock names the branches created when the request doesn't.
+	clock quartz.Clock
+}
+
+func newPullRequestCreator(cfg GitHubConfig, clock quartz.Clock) *pullRequestCreator {
+	if cfg.Token == "" {
+		return nil
+	}
+	if cfg.APIURL == "" {
+		cfg.APIURL = defaultGitHubAPIURL
+	}
+	if cfg.GitURL == "" {
+		cfg.GitURL = defaultGitHubGitURL
+	}
+	return &pullRequestCreator{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}, clock: clock}
+}
+
+func (p *pullRequestCreator) git(ctx context.Context, args ...string) (string, error) {
+	return p.gitWithEnv(ctx, nil, args...)
+}
+
+// gitWithEnv runs git with env added to its environment.
+func (p *pullRequestCreator) gitWithEnv(ctx context.Context, env []string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = p.cfg.Dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", xerrors.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// checkBranchName returns a 400 error if name isn't a valid branch name.
+func (p *pullRequestCreator) checkBranchName(ctx context.Context, field, name string) error {
+	if strings.HasPrefix(name, "-") {
+		return huma.Error400BadRequest(fmt.Sprintf("invalid %s: %s", field, name))
+	}
+	// Shorthands such as @{-1} are expanded, so they don't round-trip.
+	if normalized, err := p.git(ctx, "check-ref-format", "--branch", name); err != nil || normalized != name {
+		return huma.Error400BadRequest(fmt.Sprintf("invalid %s: %s", field, name))
+	}
+	return nil
+}
+
+// create commits all changes in the workspace to a new branch, pushes it
+// and opens a pull request against base. If committing or pushing fails,
+// the workspace is switched back to its branch with the changes
+// uncommitted.
+func (p *pullRequestCreator) create(ctx context.Context, req GitPRRequestBody, description string) (*GitPRResponse, error) {
+	if status, err := p.git(ctx, "status", "--porcelain"); err != nil {
+		return nil, err
+	} else if status == "" {
+		return nil, huma.Error400BadRequest("there are no changes to commit")
+	}
+
+	remoteURL, err := p.git(ctx, "remote", "get-url", "origin")
+	if err != nil {
+		return nil, err
+	}
+	owner, repo, ok := parseGitHubRemote(remoteURL)
+	if !ok {
+		return nil, huma.Error400BadRequest("the origin remote is not a GitHub repository: " + remoteURL)
+	}
+
+	current, err := p.git(ctx, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	currentCommit, err := p.git(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	base := req.Base
+	if base == "" {
+		if current == "HEAD" {
+			return nil, huma.Error400BadRequest("HEAD is detached, set base to the branch to open the pull request against")
+		}
+		base = current
+	}
+	branch := req.Branch
+	if branch == "" {
+		branch = "agentapi/" + p.clock.Now().UTC().Format("20060102-150405")
+	}
+	if err := p.checkBranchName(ctx, "base", base); err != nil {
+		return nil, err
+	}
+	if err := p.checkBranchName(ctx, "branch", branch); err != nil {
+		return nil, err
+	}
+	commitMessage := req.CommitMessage
+	if commitMessage == "" {
+		commitMessage = req.Title
+	}
+
+	if _, err := p.git(ctx, "checkout", "-b", branch, "--"); err != nil {
+		return nil, err
+	}
+	pushed := false
+	defer func() {
+		if !pushed {
+			p.restoreBranch(current, currentCommit, branch)
+		}
+	}()
+	if _, err := p.git(ctx, "add", "--all"); err != nil {
+		return nil, err
+	}
+	if _, err := p.git(ctx, "commit", "--message", commitMessage); err != nil {
+		return nil, err
+	}
+	// Push to GitHub rather than to origin, whose URL the agent can change,
+	// and authenticate like actions/checkout does. The header is passed in
+	// the environment so it doesn't show up in the process list, and only
+	// sent to GitHub.
+	gitURL := strings.TrimRight(p.cfg.GitURL, "/")
+	auth := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + p.cfg.Token))
+	env := []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http." + gitURL + "/.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + auth,
+	}
+	pushURL := fmt.Sprintf("%s/%s/%s.git", gitURL, owner, repo)
+	if _, err := p.gitWithEnv(ctx, env, "push", pushURL, "--", "HEAD:refs/heads/"+branch); err != nil {
+		return nil, err
+	}
+	pushed = true
+
+	payload, err := json.Marshal(map[string]any{
+		"title": req.Title,
+		"head":  branch,
+		"base":  base,
+		"body":  description,
+		"draft": req.Draft,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to marshal pull request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/repos/%s/%s/pulls", strings.TrimRight(p.cfg.APIURL, "/"), owner, repo), bytes.NewReader(payload))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Accept", "application/vnd.github+json")
+	httpReq.Header.Set("Authorization", "Bearer "+p.cfg.Token)
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create pull request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, xerrors.Errorf("GitHub returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var pr struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		return nil, xerrors.Errorf("failed to decode pull request: %w", err)
+	}
+
+	out := &GitPRResponse{}
+	out.Body.Url = pr.HTMLURL
+	out.Body.Number = pr.Number
+	out.Body.Branch = branch
+	return out, nil
+}
+
+// restoreBranch switches the workspace back to ref, at commit, after a
+// failure while committing to branch or pushing it. The changes are kept
+// in the working tree, and branch is deleted.
+func (p *pullRequestCreator) restoreBranch(ref, commit, branch string) {
+	// The request's context may be what failed.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if ref == "HEAD" {
+		ref = commit
+	}
+	for _, args := range [][]string{
+		{"reset", "--mixed", commit},
+		{"checkout", ref, "--"},
+		{"branch", "-D", branch},
+	} {
+		if _, err := p.git(ctx, args...); err != nil {
+			return
+		}
+	}
+}
+
+// quoteMarkdown renders a message as a Markdown block quote, truncated to
+// prSummaryMaxLength unless it's pinned.
+func quoteMarkdown(msg st.ConversationMessage) string {
+	text := strings.TrimSpace(msg.Message)
+	if !msg.Pinned {
+		text = truncateRunes(text, prSummaryMaxLength)
+	}
+	return "> " + strings.ReplaceAll(text, "\n", "\n> ")
+}
+
+// pullRequestDescription summarizes the conversation: the user's prompts,
+// the pinned agent messages and the agent's last message.
+func pullRequestDescription(messages []st.ConversationMessage) string {
+	var sb strings.Builder
+	sb.WriteString("## Prompts\n")
+	var pinned []st.ConversationMessage
+	var lastAgentMessage st.ConversationMessage
+	for _, msg := range messages {
+		switch msg.Role {
+		case st.ConversationRoleUser:
+			sb.WriteString("\n" + quoteMarkdown(msg) + "\n")
+		case st.ConversationRoleAgent:
+			if msg.Pinned {
+				pinned = append(pinned, msg)
+			}
+			lastAgentMessage = msg
+		}
+	}
+	if len(pinned) > 0 && pinned[len(pinned)-1].Id == lastAgentMessage.Id {
+		pinned = pinned[:len(pinned)-1]
+	}
+	if len(pinned) > 0 {
+		sb.WriteString("\n## Pinned agent messages\n")
+		for _, msg := range pinned {
+			sb.WriteString("\n" + quoteMarkdown(msg) + "\n")
+		}
+	}
+	if strings.TrimSpace(lastAgentMessage.Message) != "" {
+		sb.WriteString("\n## Agent summary\n\n" + quoteMarkdown(lastAgentMessage) + "\n")
+	}
+	sb.WriteString("\n---\nOpened by [AgentAPI](https://github.com/coder/agentapi).\n")
+	return sb.String()
+}
+
+// createPullRequest handles POST /git/pr
+func (s *Server) createPullRequest(ctx context.Context, input *GitPRRequest) (*GitPRResponse, error) {
+	if s.pullRequests == nil {
+		return nil, huma.Error503ServiceUnavailable("set GITHUB_TOKEN to open pull requests")
+	}
+
+	// Hold the lock so no message is sent to the agent while committing.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conversation.Status() != st.ConversationStatusStable {
+		return nil, huma.Error409Conflict("the agent is still working, wait until it is stable")
+	}
+	return s.pullRequests.create(ctx, input.Body, pullRequestDescription(s.conversation.Messages()))
+}
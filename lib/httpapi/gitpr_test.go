@@ -0,0 +1,163 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+func TestPullRequestCreator(t *testing.T) {
+	t.Parallel()
+
+	// Branches are pushed to <GitURL>/acme/widgets.git rather than to
+	// origin, which points to GitHub.
+	root := t.TempDir()
+	remote := filepath.Join(root, "acme", "widgets.git")
+	require.NoError(t, os.MkdirAll(remote, 0o755))
+	runGit(t, remote, "init", "--bare", "--initial-branch=main")
+
+	workspace := filepath.Join(root, "workspace")
+	require.NoError(t, os.MkdirAll(workspace, 0o755))
+	runGit(t, workspace, "init", "--initial-branch=main")
+	runGit(t, workspace, "config", "user.name", "Agent")
+	runGit(t, workspace, "config", "user.email", "agent@example.com")
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, "README.md"), []byte("widgets\n"), 0o644))
+	runGit(t, workspace, "add", "README.md")
+	runGit(t, workspace, "commit", "-m", "Initial commit")
+	runGit(t, workspace, "push", remote, "main")
+	runGit(t, workspace, "remote", "add", "origin", "https://github.com/acme/widgets.git")
+
+	var pullRequest map[string]any
+	github := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/acme/widgets/pulls", r.URL.Path)
+		assert.Equal(t, "Bearer ghp_test", r.Header.Get("Authorization"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&pullRequest))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"number": 7, "html_url": "https://github.com/acme/widgets/pull/7"}`))
+	}))
+	t.Cleanup(github.Close)
+
+	mClock := quartz.NewMock(t)
+	mClock.Set(time.Date(2025, 6, 1, 12, 30, 0, 0, time.UTC))
+	creator := newPullRequestCreator(GitHubConfig{Token: "ghp_test", APIURL: github.URL, GitURL: "file://" + root, Dir: workspace}, mClock)
+
+	_, err := creator.create(t.Context(), GitPRRequestBody{Title: "Nothing"}, "")
+	require.ErrorContains(t, err, "no changes")
+
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, "widget.go"), []byte("package widgets\n"), 0o644))
+	resp, err := creator.create(t.Context(), GitPRRequestBody{Title: "Add widgets", Branch: "agent/widgets"}, "description")
+	require.NoError(t, err)
+	assert.Equal(t, 7, resp.Body.Number)
+	assert.Equal(t, "https://github.com/acme/widgets/pull/7", resp.Body.Url)
+	assert.Equal(t, "agent/widgets", resp.Body.Branch)
+
+	assert.Equal(t, map[string]any{
+		"title": "Add widgets",
+		"head":  "agent/widgets",
+		"base":  "main",
+		"body":  "description",
+		"draft": false,
+	}, pullRequest)
+	assert.Equal(t, "Add widgets", runGit(t, remote, "log", "-1", "--format=%s", "agent/widgets"))
+
+	// Without a branch, it's named after the time.
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, "gadget.go"), []byte("package widgets\n"), 0o644))
+	resp, err = creator.create(t.Context(), GitPRRequestBody{Title: "Add gadgets", Base: "main"}, "description")
+	require.NoError(t, err)
+	assert.Equal(t, "agentapi/20250601-123000", resp.Body.Branch)
+	assert.Equal(t, "agentapi/20250601-123000", pullRequest["head"])
+
+	// Invalid branch names are rejected before anything is committed.
+	runGit(t, workspace, "checkout", "main")
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, "gizmo.go"), []byte("package widgets\n"), 0o644))
+	for _, branch := range []string{"--force", "a..b", "@{-1}"} {
+		_, err = creator.create(t.Context(), GitPRRequestBody{Title: "Add gizmos", Branch: branch}, "description")
+		require.ErrorContains(t, err, "invalid branch", branch)
+	}
+	_, err = creator.create(t.Context(), GitPRRequestBody{Title: "Add gizmos", Base: "-x"}, "description")
+	require.ErrorContains(t, err, "invalid base")
+
+	// If the push fails, the workspace is back on its branch with the
+	// changes uncommitted.
+	failing := newPullRequestCreator(GitHubConfig{Token: "ghp_test", APIURL: github.URL, GitURL: "file://" + filepath.Join(root, "missing"), Dir: workspace}, mClock)
+	_, err = failing.create(t.Context(), GitPRRequestBody{Title: "Add gizmos", Branch: "agent/gizmos"}, "description")
+	require.Error(t, err)
+	assert.Equal(t, "main", runGit(t, workspace, "rev-parse", "--abbrev-ref", "HEAD"))
+	assert.Equal(t, "?? gizmo.go", runGit(t, workspace, "status", "--porcelain"))
+	assert.Empty(t, runGit(t, workspace, "branch", "--list", "agent/gizmos"))
+}
+
+func TestParseGitHubRemote(t *testing.T) {
+	t.Parallel()
+	for _, remote := range []string{
+		"https://github.com/acme/widgets.git",
+		"https://github.com/acme/widgets",
+		"https://user@github.com/acme/widgets/",
+		"git@github.com:acme/widgets.git",
+		"ssh://git@github.com/acme/widgets",
+	} {
+		owner, repo, ok := parseGitHubRemote(remote)
+		require.True(t, ok, remote)
+		assert.Equal(t, []string{"acme", "widgets"}, []string{owner, repo}, remote)
+	}
+	for _, remote := range []string{
+		"https://gitlab.com/acme/widgets.git",
+		"https://attacker.example/github.com/acme/widgets",
+		"https://github.com.attacker.example/acme/widgets",
+		"https://github.com:8443/acme/widgets",
+		"http://github.com/acme/widgets",
+		"git@attacker.example:github.com/acme/widgets",
+		"/srv/github.com/acme/widgets.git",
+		"https://github.com/acme/widgets/extra",
+		"https://github.com/acme",
+	} {
+		_, _, ok := parseGitHubRemote(remote)
+		assert.False(t, ok, remote)
+	}
+}
+
+func TestPullRequestDescription(t *testing.T) {
+	t.Parallel()
+	description := pullRequestDescription([]st.ConversationMessage{
+		{Role: st.ConversationRoleAgent, Message: "Welcome"},
+		{Role: st.ConversationRoleUser, Message: "Add a widget\nwith tests"},
+		{Role: st.ConversationRoleAgent, Message: "Added widget.go."},
+	})
+	assert.Equal(t, "## Prompts\n\n> Add a widget\n> with tests\n\n## Agent summary\n\n> Added widget.go.\n\n---\nOpened by [AgentAPI](https://github.com/coder/agentapi).\n", description)
+}
+
+func TestPullRequestDescriptionPinned(t *testing.T) {
+	t.Parallel()
+	long := strings.Repeat("a", prSummaryMaxLength+1)
+	description := pullRequestDescription([]st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleUser, Message: long, Pinned: true},
+		{Id: 1, Role: st.ConversationRoleAgent, Message: "Use the v2 API", Pinned: true},
+		{Id: 2, Role: st.ConversationRoleUser, Message: long},
+		{Id: 3, Role: st.ConversationRoleAgent, Message: "Done", Pinned: true},
+	})
+	// Pinned messages are quoted in full, and pinned agent messages are
+	// listed before the summary.
+	assert.Equal(t, "## Prompts\n\n> "+long+"\n\n> "+truncateRunes(long, prSummaryMaxLength)+"\n"+
+		"\n## Pinned agent messages\n\n> Use the v2 API\n"+
+		"\n## Agent summary\n\n> Done\n\n---\nOpened by [AgentAPI](https://github.com/coder/agentapi).\n", description)
+}
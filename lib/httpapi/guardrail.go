@@ -0,0 +1,109 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+// GuardrailViolation is returned by the guardrail middleware when a user
+// message matches a deny pattern.
+type GuardrailViolation struct {
+	// Rule is the deny pattern the message matched.
+	Rule string
+}
+
+func (v *GuardrailViolation) Error() string {
+	return fmt.Sprintf("message matches deny pattern %q", v.Rule)
+}
+
+type guardrailRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// guardrail blocks user messages matching a deny pattern and rewrites
+// matches of the rewrite patterns before messages reach the agent.
+type guardrail struct {
+	st.NoopMiddleware
+	deny    []*regexp.Regexp
+	rewrite []guardrailRewrite
+}
+
+// NewGuardrail returns a middleware checking user messages against deny, a
+// list of regular expressions, and rewriting them with rewrite, a list of
+// "pattern=>replacement" rules. Replacements may refer to capture groups as
+// in regexp.Regexp.ReplaceAllString.
+func NewGuardrail(deny []string, rewrite []string) (st.ConversationMiddleware, error) {
+	g := &guardrail{}
+	for _, pattern := range deny {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid deny pattern %q: %w", pattern, err)
+		}
+		g.deny = append(g.deny, re)
+	}
+	for _, rule := range rewrite {
+		pattern, replacement, ok := strings.Cut(rule, "=>")
+		if !ok {
+			return nil, xerrors.Errorf("invalid rewrite rule %q, expected pattern=>replacement", rule)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid rewrite pattern %q: %w", pattern, err)
+		}
+		g.rewrite = append(g.rewrite, guardrailRewrite{pattern: re, replacement: replacement})
+	}
+	return g, nil
+}
+
+func isGuardrail(m st.ConversationMiddleware) bool {
+	_, ok := m.(*guardrail)
+	return ok
+}
+
+// OnUserMessage rewrites the message first, so rewrite rules can also
+// defuse text a deny pattern would reject.
+func (g *guardrail) OnUserMessage(message string) (string, error) {
+	for _, rule := range g.rewrite {
+		message = rule.pattern.ReplaceAllString(message, rule.replacement)
+	}
+	for _, re := range g.deny {
+		if re.MatchString(message) {
+			return "", &GuardrailViolation{Rule: re.String()}
+		}
+	}
+	return message, nil
+}
+
+// sendError converts an error from Conversation.Send into the HTTP error
+// returned to the client. location is the request field holding the
+// message.
+func sendError(what string, location string, err error) error {
+	var violation *GuardrailViolation
+	if errors.As(err, &violation) {
+		return huma.Error422UnprocessableEntity(what+" rejected by guardrail", &huma.ErrorDetail{
+			Message:  violation.Error(),
+			Location: location,
+			Value:    violation.Rule,
+		})
+	}
+	var lintErr *PromptLintError
+	if errors.As(err, &lintErr) {
+		details := make([]error, len(lintErr.Violations))
+		for i, violation := range lintErr.Violations {
+			detail := &huma.ErrorDetail{Message: violation.Rule + ": " + violation.Message, Location: location}
+			if violation.Value != "" {
+				detail.Value = violation.Value
+			}
+			details[i] = detail
+		}
+		return huma.Error422UnprocessableEntity(what+" rejected by prompt lint", details...)
+	}
+	return xerrors.Errorf("failed to send %s: %w", what, err)
+}
@@ -0,0 +1,29 @@
+package httpapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuardrail(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewGuardrail([]string{"("}, nil)
+	assert.ErrorContains(t, err, "invalid deny pattern")
+	_, err = NewGuardrail(nil, []string{"no-arrow"})
+	assert.ErrorContains(t, err, "expected pattern=>replacement")
+
+	g, err := NewGuardrail([]string{`(?i)drop\s+table`}, []string{`(password=)\S+=>${1}***`, `rm -rf=>rm -ri`})
+	require.NoError(t, err)
+
+	message, err := g.OnUserMessage("set password=hunter2 and run rm -rf build")
+	require.NoError(t, err)
+	assert.Equal(t, "set password=*** and run rm -ri build", message)
+
+	_, err = g.OnUserMessage("DROP  TABLE users")
+	var violation *GuardrailViolation
+	require.ErrorAs(t, err, &violation)
+	assert.Equal(t, `(?i)drop\s+table`, violation.Rule)
+}
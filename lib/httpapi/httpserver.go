@@ -0,0 +1,53 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+)
+
+// HTTPConfig tunes the HTTP server. Zero timeouts disable them.
+type HTTPConfig struct {
+	// ReadTimeout is how long reading a request, including its body, may
+	// take.
+	ReadTimeout time.Duration
+	// WriteTimeout is how long writing a response may take. The SSE
+	// endpoints instead limit the time to write each event, since their
+	// responses last as long as the subscription.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection may wait for the
+	// next request.
+	IdleTimeout time.Duration
+	// MaxConcurrentStreams caps the requests, such as SSE subscriptions,
+	// that an HTTP/2 client may have open on one connection.
+	MaxConcurrentStreams int
+	// DisableH2C turns off HTTP/2 without TLS (h2c). With h2c, reverse
+	// proxies can multiplex many subscriptions over one connection.
+	DisableH2C bool
+}
+
+const (
+	DefaultIdleTimeout          = 2 * time.Minute
+	DefaultMaxConcurrentStreams = 1000
+)
+
+// newHTTPServer creates the HTTP server for the routes.
+func (s *Server) newHTTPServer(addr string) *http.Server {
+	cfg := s.httpConfig
+	if cfg.MaxConcurrentStreams == 0 {
+		cfg.MaxConcurrentStreams = DefaultMaxConcurrentStreams
+	}
+	protocols := &http.Protocols{}
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(!cfg.DisableH2C)
+	return &http.Server{
+		Addr:         addr,
+		Handler:      s.handler,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+		Protocols:    protocols,
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: cfg.MaxConcurrentStreams,
+		},
+	}
+}
@@ -0,0 +1,115 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/logctx"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+func TestHTTPServer_ManySubscribers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping load test in short mode")
+	}
+	t.Parallel()
+
+	const subscribers = 500
+	writeTimeout := 200 * time.Millisecond
+
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s, err := NewServer(ctx, ServerConfig{
+		AgentType:      mf.AgentTypeClaude,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		HTTP:           HTTPConfig{WriteTimeout: writeTimeout},
+	})
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := s.newHTTPServer("")
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	t.Cleanup(func() {
+		_ = s.Stop(context.Background())
+		_ = srv.Close()
+	})
+
+	// All subscriptions share one h2c connection, as they would behind a
+	// reverse proxy.
+	protocols := &http.Protocols{}
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	t.Cleanup(client.CloseIdleConnections)
+
+	url := "http://" + ln.Addr().String() + "/events"
+	reqCtx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	var (
+		connected sync.WaitGroup
+		received  sync.WaitGroup
+		errs      = make(chan error, subscribers)
+	)
+	connected.Add(subscribers)
+	received.Add(subscribers)
+	for range subscribers {
+		go func() {
+			defer received.Done()
+			isConnected := false
+			defer func() {
+				if !isConnected {
+					connected.Done()
+				}
+			}()
+			req, _ := http.NewRequestWithContext(reqCtx, http.MethodGet, url, nil)
+			resp, err := client.Do(req)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer func() {
+				_ = resp.Body.Close()
+			}()
+			if resp.ProtoMajor != 2 {
+				errs <- xerrors.Errorf("unexpected protocol %s", resp.Proto)
+			}
+			scanner := bufio.NewScanner(resp.Body)
+			waitingFor := "event: status_change"
+			for scanner.Scan() {
+				if scanner.Text() != waitingFor {
+					continue
+				}
+				if waitingFor == "event: message_update" {
+					return
+				}
+				isConnected = true
+				connected.Done()
+				waitingFor = "event: message_update"
+			}
+			errs <- xerrors.Errorf("stream ended before the message update: %v", scanner.Err())
+		}()
+	}
+	connected.Wait()
+
+	// Streams outlive the write timeout.
+	time.Sleep(2 * writeTimeout)
+	s.emitter.EmitMessages([]st.ConversationMessage{{Id: 0, Role: st.ConversationRoleAgent, Message: "Hello"}})
+
+	received.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
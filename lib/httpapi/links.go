@@ -0,0 +1,37 @@
+package httpapi
+
+import (
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+)
+
+// hyperlinkSource is implemented by AgentIOs that keep the OSC 8
+// hyperlinks written by the agent, such as termexec.Process.
+type hyperlinkSource interface {
+	Hyperlinks() []mf.Hyperlink
+}
+
+// linkDetector is a middleware attaching the URLs and file references
+// found in agent messages.
+type linkDetector struct {
+	st.NoopMiddleware
+	hyperlinks hyperlinkSource
+}
+
+func newLinkDetector(agentIO st.AgentIO) *linkDetector {
+	d := &linkDetector{}
+	d.hyperlinks, _ = agentIO.(hyperlinkSource)
+	return d
+}
+
+func (d *linkDetector) OnAgentMessage(message st.ConversationMessage) st.ConversationMessage {
+	var hyperlinks []mf.Hyperlink
+	if d.hyperlinks != nil {
+		hyperlinks = d.hyperlinks.Hyperlinks()
+	}
+	message.Links = nil
+	if links := mf.FindLinks(message.Message, hyperlinks); len(links) > 0 {
+		message.Links = links
+	}
+	return message
+}
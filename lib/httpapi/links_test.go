@@ -0,0 +1,38 @@
+package httpapi
+
+import (
+	"testing"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+)
+
+// hyperlinkAgentIO is an st.AgentIO that reports OSC 8 hyperlinks.
+type hyperlinkAgentIO struct {
+	hyperlinks []mf.Hyperlink
+}
+
+func (a *hyperlinkAgentIO) Write(data []byte) (int, error) { return len(data), nil }
+func (a *hyperlinkAgentIO) ReadScreen() string             { return "" }
+func (a *hyperlinkAgentIO) Hyperlinks() []mf.Hyperlink     { return a.hyperlinks }
+
+func TestLinkDetector(t *testing.T) {
+	t.Parallel()
+
+	agentIO := &hyperlinkAgentIO{hyperlinks: []mf.Hyperlink{{Text: "PR #42", URL: "https://github.com/coder/agentapi/pull/42"}}}
+	chain := st.NewMiddlewareChain(newLinkDetector(agentIO))
+	conversation := chain.Conversation(&messagesConversation{messages: []st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleUser, Message: "Fix server.go:12"},
+		{Id: 1, Role: st.ConversationRoleAgent, Message: "Fixed server.go:12 and opened PR #42."},
+		{Id: 2, Role: st.ConversationRoleAgent, Message: "No links here."},
+	}})
+
+	messages := conversation.Messages()
+	assert.Nil(t, messages[0].Links)
+	assert.Equal(t, []mf.Link{
+		{Kind: mf.LinkKindFile, Text: "server.go:12", Path: "server.go", Line: 12},
+		{Kind: mf.LinkKindURL, Text: "PR #42", URL: "https://github.com/coder/agentapi/pull/42"},
+	}, messages[1].Links)
+	assert.Nil(t, messages[2].Links)
+}
@@ -0,0 +1,254 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/agentapi/lib/metrics"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"golang.org/x/xerrors"
+)
+
+// LogExportConfig configures exporting conversation events (messages,
+// status changes, tool calls and errors) to centralized logging.
+type LogExportConfig struct {
+	// Syslog is the syslog server as network://host:port, e.g.
+	// udp://logs.example.com:514, or "local" for the local syslog daemon.
+	// Syslog export is disabled when empty.
+	Syslog string
+	// OTLPEndpoint is an OTLP/HTTP logs endpoint, e.g.
+	// http://localhost:4318/v1/logs. OTLP export is disabled when empty.
+	OTLPEndpoint string
+	// OTLPHeaders are sent with every OTLP request, e.g. for authentication.
+	OTLPHeaders map[string]string
+}
+
+const (
+	// logExportBufSize is the number of records buffered per exporter.
+	// Records are dropped when the buffer is full.
+	logExportBufSize = 1024
+	// logExportMaxBatch caps the records sent in one request.
+	logExportMaxBatch = 100
+	// otlpTimeout bounds each OTLP request.
+	otlpTimeout = 10 * time.Second
+)
+
+// newLogExporters creates an exporter for each destination set in cfg.
+func newLogExporters(cfg LogExportConfig, agentType mf.AgentType, logger *slog.Logger, metricsRegistry *metrics.Registry) ([]transcriptSink, error) {
+	var sinks []transcriptSink
+	if cfg.Syslog != "" {
+		send, closeFn, err := newSyslogSender(cfg.Syslog)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, newLogExporter("syslog", send, closeFn, logger, metricsRegistry))
+		logger.Info("Exporting conversation events to syslog", "address", cfg.Syslog)
+	}
+	if cfg.OTLPEndpoint != "" {
+		send := newOTLPSender(cfg.OTLPEndpoint, cfg.OTLPHeaders, agentType)
+		sinks = append(sinks, newLogExporter("otlp", send, nil, logger, metricsRegistry))
+		logger.Info("Exporting conversation events to OTLP", "endpoint", cfg.OTLPEndpoint)
+	}
+	return sinks, nil
+}
+
+// logExporter sends records to a log destination in the background, so a
+// slow or unreachable collector never blocks the conversation.
+type logExporter struct {
+	name    string
+	send    func(records []transcriptRecord) error
+	closeFn func() error
+	logger  *slog.Logger
+	metrics *metrics.Registry
+
+	mu      sync.Mutex
+	closed  bool
+	records chan transcriptRecord
+	done    chan struct{}
+}
+
+func newLogExporter(name string, send func([]transcriptRecord) error, closeFn func() error, logger *slog.Logger, metricsRegistry *metrics.Registry) *logExporter {
+	e := &logExporter{
+		name:    name,
+		send:    send,
+		closeFn: closeFn,
+		logger:  logger,
+		metrics: metricsRegistry,
+		records: make(chan transcriptRecord, logExportBufSize),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// writeRecord queues everything except screens, which are too large and
+// frequent for log pipelines.
+func (e *logExporter) writeRecord(record transcriptRecord) {
+	if record.Type == transcriptRecordScreen {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.records <- record:
+	default:
+		e.metrics.Inc("agentapi_log_export_dropped_total", "Conversation events dropped because the log exporter fell behind.",
+			"exporter", e.name)
+	}
+}
+
+func (e *logExporter) run() {
+	defer close(e.done)
+	for record := range e.records {
+		batch := []transcriptRecord{record}
+	drain:
+		for len(batch) < logExportMaxBatch {
+			select {
+			case next, ok := <-e.records:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		if err := e.send(batch); err != nil {
+			e.logger.Warn("Failed to export conversation events", "exporter", e.name, "count", len(batch), "error", err)
+			e.metrics.Add("agentapi_log_export_failed_total", "Conversation events that could not be exported.",
+				float64(len(batch)), "exporter", e.name)
+		}
+	}
+}
+
+// Close flushes the queued records and releases the destination.
+func (e *logExporter) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.records)
+	e.mu.Unlock()
+
+	<-e.done
+	if e.closeFn != nil {
+		return e.closeFn()
+	}
+	return nil
+}
+
+// OTLP/JSON types, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano   string         `json:"timeUnixNano"`
+	SeverityNumber int            `json:"severityNumber"`
+	SeverityText   string         `json:"severityText"`
+	Body           otlpAnyValue   `json:"body"`
+	Attributes     []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeLogs struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpResourceLogs struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+// otlpSeverityInfo is the OTLP severity number for INFO.
+const otlpSeverityInfo = 9
+
+func otlpAttr(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: value}}
+}
+
+// newOTLPSender returns a function posting records to an OTLP/HTTP logs
+// endpoint using the JSON encoding. The record is the log body, so
+// consumers get the same JSON as --tee-output.
+func newOTLPSender(endpoint string, headers map[string]string, agentType mf.AgentType) func([]transcriptRecord) error {
+	client := &http.Client{Timeout: otlpTimeout}
+	return func(records []transcriptRecord) error {
+		var resourceLogs otlpResourceLogs
+		resourceLogs.Resource.Attributes = []otlpKeyValue{
+			otlpAttr("service.name", "agentapi"),
+			otlpAttr("agentapi.agent_type", string(agentType)),
+		}
+		var scopeLogs otlpScopeLogs
+		scopeLogs.Scope.Name = "agentapi"
+		for _, record := range records {
+			body, err := json.Marshal(record)
+			if err != nil {
+				return xerrors.Errorf("failed to marshal record: %w", err)
+			}
+			attributes := []otlpKeyValue{otlpAttr("agentapi.event_type", string(record.Type))}
+			if record.Role != "" {
+				attributes = append(attributes, otlpAttr("agentapi.role", string(record.Role)))
+			}
+			scopeLogs.LogRecords = append(scopeLogs.LogRecords, otlpLogRecord{
+				TimeUnixNano:   strconv.FormatInt(record.Time.UnixNano(), 10),
+				SeverityNumber: otlpSeverityInfo,
+				SeverityText:   "INFO",
+				Body:           otlpAnyValue{StringValue: string(body)},
+				Attributes:     attributes,
+			})
+		}
+
+		resourceLogs.ScopeLogs = []otlpScopeLogs{scopeLogs}
+		payload, err := json.Marshal(otlpLogsRequest{ResourceLogs: []otlpResourceLogs{resourceLogs}})
+		if err != nil {
+			return xerrors.Errorf("failed to marshal OTLP request: %w", err)
+		}
+		httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, endpoint, bytes.NewReader(payload))
+		if err != nil {
+			return xerrors.Errorf("failed to create OTLP request: %w", err)
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		for key, value := range headers {
+			httpReq.Header.Set(key, value)
+		}
+		resp, err := client.Do(httpReq)
+		if err != nil {
+			return xerrors.Errorf("failed to send OTLP request: %w", err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		if resp.StatusCode/100 != 2 {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return xerrors.Errorf("OTLP endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogExport_OTLP(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var requests []otlpLogsRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/logs", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		var req otlpLogsRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+	}))
+	t.Cleanup(collector.Close)
+
+	sinks, err := newLogExporters(LogExportConfig{
+		OTLPEndpoint: collector.URL + "/v1/logs",
+		OTLPHeaders:  map[string]string{"Authorization": "Bearer token"},
+	}, mf.AgentTypeClaude, slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	require.NoError(t, err)
+	require.Len(t, sinks, 1)
+	emitter := NewEventEmitter(withTranscriptSinks(sinks...))
+
+	emitter.EmitMessages([]st.ConversationMessage{{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"}})
+	emitter.EmitScreen("Welcome")
+	emitter.EmitStatus(st.ConversationStatusStable)
+	emitter.EmitToolCall(st.ToolCall{Id: "call-1", Name: "Read", Status: st.ToolCallStatusStarted})
+	// Close flushes the queue.
+	require.NoError(t, sinks[0].Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	var records []transcriptRecord
+	for _, req := range requests {
+		require.Len(t, req.ResourceLogs, 1)
+		assert.Contains(t, req.ResourceLogs[0].Resource.Attributes, otlpAttr("agentapi.agent_type", "claude"))
+		for _, logRecord := range req.ResourceLogs[0].ScopeLogs[0].LogRecords {
+			var record transcriptRecord
+			require.NoError(t, json.Unmarshal([]byte(logRecord.Body.StringValue), &record))
+			assert.Contains(t, logRecord.Attributes, otlpAttr("agentapi.event_type", string(record.Type)))
+			records = append(records, record)
+		}
+	}
+
+	// Screens are not exported.
+	require.Len(t, records, 3)
+	assert.Equal(t, transcriptRecordMessage, records[0].Type)
+	assert.Equal(t, "Welcome", records[0].Content)
+	assert.Equal(t, transcriptRecordStatus, records[1].Type)
+	assert.Equal(t, AgentStatusStable, records[1].Status)
+	assert.Equal(t, transcriptRecordToolCall, records[2].Type)
+	assert.Equal(t, "call-1", records[2].ToolCall.Id)
+}
+
+func TestLogExport_InvalidSyslogAddress(t *testing.T) {
+	t.Parallel()
+	_, err := newLogExporters(LogExportConfig{Syslog: "logs.example.com:514"}, mf.AgentTypeClaude, slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	require.Error(t, err)
+}
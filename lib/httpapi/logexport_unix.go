@@ -0,0 +1,47 @@
+//go:build unix
+
+package httpapi
+
+import (
+	"encoding/json"
+	"log/syslog"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// newSyslogSender connects to the syslog server at addr and returns a
+// function writing each record as a JSON message.
+func newSyslogSender(addr string) (func([]transcriptRecord) error, func() error, error) {
+	const priority = syslog.LOG_INFO | syslog.LOG_USER
+	const tag = "agentapi"
+
+	var writer *syslog.Writer
+	var err error
+	if addr == "local" {
+		writer, err = syslog.New(priority, tag)
+	} else {
+		network, raddr, ok := strings.Cut(addr, "://")
+		if !ok {
+			return nil, nil, xerrors.Errorf("invalid syslog address %q, expected local or network://host:port", addr)
+		}
+		writer, err = syslog.Dial(network, raddr, priority, tag)
+	}
+	if err != nil {
+		return nil, nil, xerrors.Errorf("failed to connect to syslog: %w", err)
+	}
+
+	send := func(records []transcriptRecord) error {
+		for _, record := range records {
+			line, err := json.Marshal(record)
+			if err != nil {
+				return xerrors.Errorf("failed to marshal record: %w", err)
+			}
+			if err := writer.Info(string(line)); err != nil {
+				return xerrors.Errorf("failed to write to syslog: %w", err)
+			}
+		}
+		return nil
+	}
+	return send, writer.Close, nil
+}
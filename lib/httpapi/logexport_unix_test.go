@@ -0,0 +1,49 @@
+//go:build unix
+
+package httpapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/logctx"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewServer_ClosesLogExportersOnError(t *testing.T) {
+	t.Parallel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	_, err = NewServer(ctx, ServerConfig{
+		AgentType:      mf.AgentTypeClaude,
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		LogExport:      LogExportConfig{Syslog: "tcp://" + listener.Addr().String()},
+		// The tee file can't be created, so the server isn't either.
+		Tee: TeeConfig{Path: filepath.Join(t.TempDir(), "missing", "transcript.jsonl")},
+	})
+	require.Error(t, err)
+
+	// The syslog connection was closed.
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Second)))
+	_, err = io.ReadAll(conn)
+	assert.NoError(t, err)
+}
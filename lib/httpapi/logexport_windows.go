@@ -0,0 +1,9 @@
+//go:build windows
+
+package httpapi
+
+import "golang.org/x/xerrors"
+
+func newSyslogSender(addr string) (func([]transcriptRecord) error, func() error, error) {
+	return nil, nil, xerrors.New("syslog export is not supported on Windows")
+}
@@ -0,0 +1,28 @@
+package httpapi
+
+import (
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+)
+
+// markdownConverter is a middleware adding the markdown conversion of
+// agent messages.
+type markdownConverter struct {
+	st.NoopMiddleware
+}
+
+func (markdownConverter) OnAgentMessage(message st.ConversationMessage) st.ConversationMessage {
+	message.Markdown = mf.ToMarkdown(message.Message)
+	return message
+}
+
+// blockParser is a middleware splitting agent messages into blocks.
+type blockParser struct {
+	st.NoopMiddleware
+	agentType mf.AgentType
+}
+
+func (p blockParser) OnAgentMessage(message st.ConversationMessage) st.ConversationMessage {
+	message.Blocks = mf.ParseBlocks(p.agentType, message.Message)
+	return message
+}
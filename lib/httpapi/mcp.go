@@ -0,0 +1,27 @@
+package httpapi
+
+import "github.com/coder/agentapi/lib/transport"
+
+// mcpStatusReporter is implemented by AgentIOs whose agents report the MCP
+// servers they loaded, such as claudeio.ClaudeAgentIO.
+type mcpStatusReporter interface {
+	MCPServers() ([]transport.MCPServerStatus, bool)
+}
+
+// mcpServers returns the MCP servers the agent reported loading, or nil if
+// it hasn't reported them or the transport doesn't.
+func (s *Server) mcpServers() []MCPServerStatus {
+	reporter, ok := s.agentio.(mcpStatusReporter)
+	if !ok {
+		return nil
+	}
+	servers, ok := reporter.MCPServers()
+	if !ok {
+		return nil
+	}
+	resp := make([]MCPServerStatus, len(servers))
+	for i, server := range servers {
+		resp[i] = MCPServerStatus{Name: server.Name, Status: server.Status}
+	}
+	return resp
+}
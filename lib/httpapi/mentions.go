@@ -0,0 +1,112 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// fileMentionPattern matches the @file(path) mentions of a user message.
+var fileMentionPattern = regexp.MustCompile(`@file\(([^()\n]+)\)`)
+
+// expandFileMentions replaces the @file(path) mentions of a message with
+// the agent's own file reference syntax. Agents without one get the path,
+// and the contents of the files are appended to the message. Files are
+// read through the file browser, so they must be allowed by --files-allow.
+func (s *Server) expandFileMentions(message string) (string, []FileMention, error) {
+	matches := fileMentionPattern.FindAllStringSubmatchIndex(message, -1)
+	if len(matches) == 0 {
+		return message, nil, nil
+	}
+	if s.files == nil {
+		return "", nil, huma.Error400BadRequest("file mentions need the file browser, enable it with --files-allow")
+	}
+
+	var expanded, contents strings.Builder
+	var mentions []FileMention
+	last := 0
+	for _, match := range matches {
+		expanded.WriteString(message[last:match[0]])
+		last = match[1]
+		mention, replacement, content, err := s.expandFileMention(message[match[2]:match[3]])
+		if err != nil {
+			return "", nil, err
+		}
+		expanded.WriteString(replacement)
+		if mention.Expansion == FileMentionExpansionContent {
+			fence := codeFence(content)
+			fmt.Fprintf(&contents, "\n\n%s:\n%s\n%s\n%s", mention.Path, fence, strings.TrimSuffix(content, "\n"), fence)
+		}
+		mentions = append(mentions, mention)
+	}
+	expanded.WriteString(message[last:])
+	expanded.WriteString(contents.String())
+	return expanded.String(), mentions, nil
+}
+
+func (s *Server) expandFileMention(rel string) (FileMention, string, string, error) {
+	detail := &huma.ErrorDetail{Location: "body.content", Value: rel}
+	entry, err := s.files.stat(strings.TrimSpace(rel))
+	if err != nil {
+		return FileMention{}, "", "", huma.Error400BadRequest("mentioned file not found: "+rel, detail)
+	}
+	if entry.Type != FileTypeFile {
+		return FileMention{}, "", "", huma.Error400BadRequest("mentioned path is not a file: "+rel, detail)
+	}
+	mention := FileMention{Path: entry.Path, Size: entry.Size}
+	if reference := mf.FileMention(s.agentType, entry.Path); reference != "" {
+		mention.Expansion = FileMentionExpansionReference
+		return mention, reference, "", nil
+	}
+
+	body, err := s.files.read(entry.Path)
+	if err != nil {
+		var statusErr huma.StatusError
+		if !errors.As(err, &statusErr) {
+			return FileMention{}, "", "", err
+		}
+		return FileMention{}, "", "", huma.Error400BadRequest(fmt.Sprintf("mentioned file can't be expanded: %s", statusErr.Error()), detail)
+	}
+	if body.Encoding != FileEncodingUTF8 {
+		return FileMention{}, "", "", huma.Error400BadRequest("mentioned file is binary: "+rel, detail)
+	}
+	mention.Expansion = FileMentionExpansionContent
+	return mention, "`" + entry.Path + "`", body.Content, nil
+}
+
+// codeFence returns a fence longer than any run of backticks in content.
+func codeFence(content string) string {
+	longest, run := 0, 0
+	for _, r := range content {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// recordFileMentions attaches the expanded mentions to the latest user
+// message, which was just sent.
+func (s *Server) recordFileMentions(mentions []FileMention) {
+	if len(mentions) == 0 {
+		return
+	}
+	messages := s.conversation.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == st.ConversationRoleUser {
+			if s.fileMentions == nil {
+				s.fileMentions = make(map[int][]FileMention)
+			}
+			s.fileMentions[messages[i].Id] = mentions
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// userMessagesConversation records sent messages as user messages.
+type userMessagesConversation struct {
+	sentConversation
+}
+
+func (c *userMessagesConversation) Messages() []st.ConversationMessage {
+	var messages []st.ConversationMessage
+	for i, message := range c.Sent() {
+		messages = append(messages, st.ConversationMessage{Id: i, Role: st.ConversationRoleUser, Message: message})
+	}
+	return messages
+}
+
+func TestFileMentions(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "src"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "src", "main.go"), []byte("package main\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "README.md"), []byte("```sh\nmake\n```\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, ".env"), []byte("SECRET=1"), 0o644))
+	files, err := newFileBrowser(FilesConfig{Root: root, Allow: []string{"*"}})
+	require.NoError(t, err)
+	newServer := func(agentType mf.AgentType) (*Server, *userMessagesConversation) {
+		conversation := &userMessagesConversation{}
+		return &Server{conversation: conversation, agentType: agentType, files: files, tokenizer: mf.HeuristicTokenizer{}}, conversation
+	}
+	send := func(s *Server, content string) error {
+		_, err := s.createMessage(context.Background(), &MessageRequest{Body: MessageRequestBody{Content: content, Type: MessageTypeUser}})
+		return err
+	}
+
+	t.Run("reference", func(t *testing.T) {
+		t.Parallel()
+		s, conversation := newServer(mf.AgentTypeCodex)
+		require.NoError(t, send(s, "Explain @file(src/main.go)"))
+		assert.Equal(t, []string{"Explain @src/main.go"}, conversation.Sent())
+		resp, err := s.getMessages(context.Background(), &MessagesRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, []FileMention{{Path: "src/main.go", Expansion: FileMentionExpansionReference, Size: 13}}, resp.Body.Messages[0].Mentions)
+	})
+
+	t.Run("content", func(t *testing.T) {
+		t.Parallel()
+		s, conversation := newServer(mf.AgentTypeAider)
+		require.NoError(t, send(s, "Compare @file(src/main.go) with @file(./README.md)"))
+		assert.Equal(t, []string{
+			"Compare `src/main.go` with `README.md`\n\n" +
+				"src/main.go:\n```\npackage main\n```\n\n" +
+				"README.md:\n````\n```sh\nmake\n```\n````",
+		}, conversation.Sent())
+		assert.Equal(t, []FileMention{
+			{Path: "src/main.go", Expansion: FileMentionExpansionContent, Size: 13},
+			{Path: "README.md", Expansion: FileMentionExpansionContent, Size: 15},
+		}, s.fileMentions[0])
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		t.Parallel()
+		s, conversation := newServer(mf.AgentTypeClaude)
+		for _, content := range []string{"@file(.env)", "@file(missing.go)", "@file(src)", "@file(../secret)"} {
+			err := send(s, content)
+			var statusErr huma.StatusError
+			require.ErrorAs(t, err, &statusErr, content)
+			assert.Equal(t, http.StatusBadRequest, statusErr.GetStatus(), content)
+		}
+		assert.Empty(t, conversation.Sent())
+
+		disabled := &Server{conversation: conversation}
+		require.Error(t, send(disabled, "@file(src/main.go)"))
+		require.NoError(t, send(disabled, "email me@example.com"))
+	})
+}
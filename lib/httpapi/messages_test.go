@@ -0,0 +1,84 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessagesIncludeDebug(t *testing.T) {
+	t.Parallel()
+
+	conversation := &messagesConversation{messages: []st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome", Debug: &st.MessageDebug{
+			ScreenStartLine: 3,
+			ScreenEndLine:   7,
+			EchoRemoved:     true,
+			Confidence:      0.5,
+		}},
+	}}
+	s := &Server{conversation: conversation, agentType: mf.AgentTypeCustom, tokenizer: mf.HeuristicTokenizer{}}
+
+	_, err := s.getMessages(context.Background(), &MessagesRequest{Include: []string{"debug"}})
+	var statusErr huma.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusBadRequest, statusErr.GetStatus())
+
+	s.debugMessages = true
+	resp, err := s.getMessages(context.Background(), &MessagesRequest{})
+	require.NoError(t, err)
+	assert.Nil(t, resp.Body.Messages[0].Debug)
+
+	resp, err = s.getMessages(context.Background(), &MessagesRequest{Include: []string{"debug"}})
+	require.NoError(t, err)
+	assert.Equal(t, &MessageDebug{ScreenStartLine: 3, ScreenEndLine: 7, EchoRemoved: true, Confidence: 0.5}, resp.Body.Messages[0].Debug)
+}
+
+func TestMessagesPagination(t *testing.T) {
+	t.Parallel()
+
+	conversation := &messagesConversation{}
+	for i, text := range []string{"Welcome", "Fix the bug", "Looking", "Thanks", "Done"} {
+		role := st.ConversationRoleAgent
+		if i%2 == 1 {
+			role = st.ConversationRoleUser
+		}
+		conversation.messages = append(conversation.messages, st.ConversationMessage{Id: i, Role: role, Message: text})
+	}
+	s := &Server{conversation: conversation, agentType: mf.AgentTypeCustom, tokenizer: mf.HeuristicTokenizer{}}
+	page := func(input MessagesRequest) ([]int, bool) {
+		t.Helper()
+		resp, err := s.getMessages(context.Background(), &input)
+		require.NoError(t, err)
+		ids := []int{}
+		for _, msg := range resp.Body.Messages {
+			ids = append(ids, msg.Id)
+		}
+		return ids, resp.Body.HasMore
+	}
+
+	ids, hasMore := page(MessagesRequest{})
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, ids)
+	assert.False(t, hasMore)
+
+	ids, hasMore = page(MessagesRequest{Limit: 2})
+	assert.Equal(t, []int{3, 4}, ids)
+	assert.True(t, hasMore)
+
+	ids, hasMore = page(MessagesRequest{Limit: 2, BeforeId: 3})
+	assert.Equal(t, []int{1, 2}, ids)
+	assert.True(t, hasMore)
+
+	ids, hasMore = page(MessagesRequest{Limit: 2, BeforeId: 1})
+	assert.Equal(t, []int{0}, ids)
+	assert.False(t, hasMore)
+
+	ids, _ = page(MessagesRequest{BeforeId: 2})
+	assert.Equal(t, []int{0, 1}, ids)
+}
@@ -28,57 +28,536 @@ func (m MessageType) Schema(r huma.Registry) *huma.Schema {
 type Transport string
 
 const (
-	TransportPTY Transport = "pty"
-	TransportACP Transport = "acp"
+	TransportPTY  Transport = "pty"
+	TransportACP  Transport = "acp"
+	TransportHTTP Transport = "http"
+	// TransportClaudeHeadless drives Claude Code with -p and stream-json.
+	TransportClaudeHeadless Transport = "claude-headless"
+	// TransportAiderHeadless runs `aider --message` once per message.
+	TransportAiderHeadless Transport = "aider-headless"
+	// TransportMock runs an in-process fake agent, for testing.
+	TransportMock Transport = "mock"
 )
 
 var TransportValues = []Transport{
 	TransportPTY,
 	TransportACP,
+	TransportHTTP,
+	TransportClaudeHeadless,
+	TransportAiderHeadless,
+	TransportMock,
 }
 
 func (tr Transport) Schema(r huma.Registry) *huma.Schema {
 	return util.OpenAPISchema(r, "Transport", TransportValues)
 }
 
+// ProcessState is the state of the agent's process, independently of what
+// it shows.
+type ProcessState string
+
+const (
+	ProcessStateStarting ProcessState = "starting"
+	ProcessStateRunning  ProcessState = "running"
+	ProcessStateExited   ProcessState = "exited"
+)
+
+var ProcessStateValues = []ProcessState{
+	ProcessStateStarting,
+	ProcessStateRunning,
+	ProcessStateExited,
+}
+
+func (p ProcessState) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "ProcessState", ProcessStateValues)
+}
+
+// ConversationState is what the conversation with the agent is waiting on.
+type ConversationState string
+
+const (
+	ConversationStateInitializing ConversationState = "initializing"
+	ConversationStateIdle         ConversationState = "idle"
+	ConversationStateGenerating   ConversationState = "generating"
+	ConversationStateWaitingInput ConversationState = "waiting_input"
+)
+
+var ConversationStateValues = []ConversationState{
+	ConversationStateInitializing,
+	ConversationStateIdle,
+	ConversationStateGenerating,
+	ConversationStateWaitingInput,
+}
+
+func (c ConversationState) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "ConversationState", ConversationStateValues)
+}
+
+// WaitForSource is what POST /wait_for matches the pattern against.
+type WaitForSource string
+
+const (
+	WaitForSourceAny     WaitForSource = "any"
+	WaitForSourceScreen  WaitForSource = "screen"
+	WaitForSourceMessage WaitForSource = "message"
+)
+
+var WaitForSourceValues = []WaitForSource{
+	WaitForSourceAny,
+	WaitForSourceScreen,
+	WaitForSourceMessage,
+}
+
+func (w WaitForSource) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "WaitForSource", WaitForSourceValues)
+}
+
+// FileType tells files from directories in GET /files.
+type FileType string
+
+const (
+	FileTypeFile      FileType = "file"
+	FileTypeDirectory FileType = "directory"
+)
+
+var FileTypeValues = []FileType{
+	FileTypeFile,
+	FileTypeDirectory,
+}
+
+func (f FileType) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "FileType", FileTypeValues)
+}
+
+// FileEncoding is how GET /files/{path} encodes the content of a file.
+type FileEncoding string
+
+const (
+	FileEncodingUTF8   FileEncoding = "utf-8"
+	FileEncodingBase64 FileEncoding = "base64"
+)
+
+var FileEncodingValues = []FileEncoding{
+	FileEncodingUTF8,
+	FileEncodingBase64,
+}
+
+func (f FileEncoding) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "FileEncoding", FileEncodingValues)
+}
+
+// FileMentionExpansion is how an @file(path) mention of a message was
+// expanded.
+type FileMentionExpansion string
+
+const (
+	// FileMentionExpansionReference replaced the mention with the agent's
+	// own file reference syntax, e.g. @path for Claude Code.
+	FileMentionExpansionReference FileMentionExpansion = "reference"
+	// FileMentionExpansionContent appended the contents of the file.
+	FileMentionExpansionContent FileMentionExpansion = "content"
+)
+
+var FileMentionExpansionValues = []FileMentionExpansion{
+	FileMentionExpansionReference,
+	FileMentionExpansionContent,
+}
+
+func (f FileMentionExpansion) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "FileMentionExpansion", FileMentionExpansionValues)
+}
+
+// FileMention is an @file(path) mention expanded in a user message.
+type FileMention struct {
+	Path      string               `json:"path" example:"src/main.go" doc:"Path of the file, relative to the agent's working directory."`
+	Expansion FileMentionExpansion `json:"expansion" doc:"How the mention was expanded: replaced with the agent's own file reference syntax, or with the contents of the file."`
+	Size      int64                `json:"size" doc:"Size of the file in bytes."`
+}
+
 // Message represents a message
 type Message struct {
-	Id      int                 `json:"id" doc:"Unique identifier for the message. This identifier also represents the order of the message in the conversation history."`
-	Content string              `json:"content" example:"Hello world" doc:"Message content. The message is formatted as it appears in the agent's terminal session, meaning that, by default, it consists of lines of text with 80 characters per line."`
-	Role    st.ConversationRole `json:"role" doc:"Role of the message author"`
-	Time    time.Time           `json:"time" doc:"Timestamp of the message"`
+	Id         int                 `json:"id" doc:"Unique identifier for the message. This identifier also represents the order of the message in the conversation history."`
+	Content    string              `json:"content" example:"Hello world" doc:"Message content. The message is formatted as it appears in the agent's terminal session, meaning that, by default, it consists of lines of text with 80 characters per line."`
+	Role       st.ConversationRole `json:"role" doc:"Role of the message author"`
+	Time       time.Time           `json:"time" doc:"Timestamp of the message"`
+	TurnId     int                 `json:"turn_id" doc:"Id of the message that started the turn this message belongs to: the user message the agent responds to, or the first message of the conversation before the user sent any. Messages and tool_call events with the same turn_id can be grouped into one turn."`
+	Thought    string              `json:"thought,omitempty" doc:"The agent's reasoning for this message, kept separate from the content. Only reported by some transports, such as ACP."`
+	Plan       []PlanEntry         `json:"plan,omitempty" doc:"The agent's latest execution plan for this message. Only reported by some transports, such as ACP."`
+	Diffs      []FileDiff          `json:"diffs,omitempty" doc:"Files modified by the agent's tool calls while producing this message. Only reported by some transports, such as ACP."`
+	StopReason st.StopReason       `json:"stop_reason,omitempty" doc:"Why the agent stopped producing this message, if known. ACP agents report it for every reply; for terminal agents it is only set when a banner such as a context limit error is detected."`
+	Filtered   bool                `json:"filtered,omitempty" doc:"Whether the message matched an output filter pattern. With the redact action, the matches were removed from the content."`
+	Source     st.MessageSource    `json:"source,omitempty" doc:"How a user message reached the agent if not through POST /message: 'terminal' for a message typed into the agent's terminal, e.g. with agentapi attach."`
+	Pinned     bool                `json:"pinned,omitempty" doc:"Whether the message was pinned with PATCH /messages/{id}/pin. Pinned messages are kept verbatim where the server shortens the conversation."`
+	Markdown   string              `json:"content_markdown,omitempty" doc:"The content of an agent message converted to markdown, with tables drawn with box-drawing characters as markdown tables and code blocks fenced. Only set when the server runs with --markdown."`
+	Blocks     []Block             `json:"content_blocks,omitempty" doc:"The content of an agent message split into prose, code blocks, tool calls and diffs, so clients can render each without parsing terminal output. Only set when the server runs with --message-blocks."`
+	ANSI       string              `json:"content_ansi,omitempty" doc:"The content of an agent message with the colors it has in the terminal, as ANSI escape sequences. Only set when the server runs with --message-colors ansi."`
+	HTML       string              `json:"content_html,omitempty" doc:"The content of an agent message with the colors it has in the terminal, as HTML with inline-styled spans. Only set when the server runs with --message-colors html."`
+	Links      []Link              `json:"links,omitempty" doc:"URLs and file references such as 'main.go:12' found in an agent message, including OSC 8 terminal hyperlinks, so clients can make them clickable."`
+	Mentions   []FileMention       `json:"file_mentions,omitempty" doc:"The @file(path) mentions expanded in a user message sent through this server."`
+	// EstimatedTokens is computed from Content, so it doesn't include the
+	// tokens of the agent's tool calls or system prompt.
+	EstimatedTokens int `json:"estimated_tokens" doc:"Estimated number of tokens of the message content, for budgeting and context usage displays. The estimate doesn't depend on the agent's model unless the server is configured with its tokenizer."`
+	// Debug is only set on agent messages of PTY conversations.
+	Debug *MessageDebug `json:"debug,omitempty" doc:"How an agent message was parsed from the terminal screen. Only returned with ?include=debug by servers started with --debug-messages."`
+}
+
+// MessageDebug describes how an agent message was parsed from the screen.
+type MessageDebug struct {
+	ScreenStartLine int     `json:"screen_start_line" doc:"First line of the terminal screen, counted from 0, the message was derived from before formatting. -1 if the screen didn't change."`
+	ScreenEndLine   int     `json:"screen_end_line" doc:"Last line of the terminal screen, counted from 0, the message was derived from before formatting. -1 if the screen didn't change."`
+	EchoRemoved     bool    `json:"echo_removed" doc:"Whether the user message the agent responds to was found echoed on the screen and removed."`
+	Confidence      float64 `json:"confidence" minimum:"0" maximum:"1" doc:"How likely the message is free of leftover terminal UI, from 0 to 1. It halves for each heuristic reported in parse_warning events that matches."`
+}
+
+// PlanEntry is one task of an agent's execution plan.
+type PlanEntry struct {
+	Content  string `json:"content" doc:"Description of the task."`
+	Priority string `json:"priority" example:"high" doc:"Priority of the task as reported by the agent, e.g. 'high', 'medium' or 'low'."`
+	Status   string `json:"status" example:"in_progress" doc:"Status of the task as reported by the agent, e.g. 'pending', 'in_progress' or 'completed'."`
+}
+
+// FileDiff is a file modification made by one of the agent's tool calls.
+type FileDiff struct {
+	ToolCallId string     `json:"tool_call_id" doc:"Identifier of the tool call that made the change."`
+	Path       string     `json:"path" example:"/home/coder/project/main.go" doc:"Path of the modified file."`
+	NewFile    bool       `json:"new_file,omitempty" doc:"Whether the file was created by the change."`
+	Hunks      []DiffHunk `json:"hunks" nullable:"false" doc:"Changed regions of the file, with three lines of context like 'diff -u'."`
+}
+
+// Link is a URL or file location referenced in a message.
+type Link struct {
+	Kind   mf.LinkKind `json:"kind" enum:"url,file" doc:"Whether the link points to a URL or to a file."`
+	Text   string      `json:"text" doc:"Text of the message the link covers."`
+	URL    string      `json:"url,omitempty" doc:"Target of URL links, and of file links from file:// hyperlinks."`
+	Path   string      `json:"path,omitempty" example:"lib/httpapi/server.go" doc:"Path of the file, as written by the agent. Relative paths are relative to the agent's working directory."`
+	Line   int         `json:"line,omitempty" doc:"Line in the file, starting at 1, if referenced."`
+	Column int         `json:"column,omitempty" doc:"Column in the line, starting at 1, if referenced."`
+}
+
+// Block is a part of an agent message.
+type Block struct {
+	Kind     mf.BlockKind `json:"kind" enum:"text,code,tool_call,diff" doc:"Whether the block is prose, a code block, a tool call, or the diff of a file the agent edited."`
+	Text     string       `json:"text" doc:"Prose of text blocks, code of code blocks, and output the agent shows for tool calls and diffs, without the markers and indentation drawn around them."`
+	Language string       `json:"language,omitempty" example:"go" doc:"Language of a code block, if its fence names it."`
+	Tool     string       `json:"tool,omitempty" example:"Bash" doc:"Name of the tool of a tool call or diff, as the agent shows it."`
+	Input    string       `json:"input,omitempty" example:"go test ./..." doc:"Arguments of the tool call, as the agent shows them."`
+	Path     string       `json:"path,omitempty" example:"lib/httpapi/server.go" doc:"File of a diff, as written by the agent."`
+}
+
+// DiffHunk is a hunk of a unified diff.
+type DiffHunk struct {
+	OldStart int      `json:"old_start" doc:"First line of the hunk in the old file, starting at 1."`
+	OldLines int      `json:"old_lines" doc:"Number of lines of the old file in the hunk."`
+	NewStart int      `json:"new_start" doc:"First line of the hunk in the new file, starting at 1."`
+	NewLines int      `json:"new_lines" doc:"Number of lines of the new file in the hunk."`
+	Lines    []string `json:"lines" nullable:"false" doc:"Lines of the hunk, each prefixed with ' ' (unchanged), '-' (removed) or '+' (added)."`
+}
+
+func convertDiff(diff st.FileDiff) FileDiff {
+	hunks := make([]DiffHunk, len(diff.Hunks))
+	for i, hunk := range diff.Hunks {
+		hunks[i] = DiffHunk(hunk)
+	}
+	return FileDiff{
+		ToolCallId: diff.ToolCallId,
+		Path:       diff.Path,
+		NewFile:    diff.NewFile,
+		Hunks:      hunks,
+	}
+}
+
+func convertDiffs(diffs []st.FileDiff) []FileDiff {
+	if diffs == nil {
+		return nil
+	}
+	converted := make([]FileDiff, len(diffs))
+	for i, diff := range diffs {
+		converted[i] = convertDiff(diff)
+	}
+	return converted
+}
+
+func convertLinks(links []mf.Link) []Link {
+	if links == nil {
+		return nil
+	}
+	converted := make([]Link, len(links))
+	for i, link := range links {
+		converted[i] = Link(link)
+	}
+	return converted
+}
+
+func convertBlocks(blocks []mf.Block) []Block {
+	if blocks == nil {
+		return nil
+	}
+	converted := make([]Block, len(blocks))
+	for i, block := range blocks {
+		converted[i] = Block(block)
+	}
+	return converted
+}
+
+func convertPlan(plan []st.PlanEntry) []PlanEntry {
+	if plan == nil {
+		return nil
+	}
+	entries := make([]PlanEntry, len(plan))
+	for i, entry := range plan {
+		entries[i] = PlanEntry(entry)
+	}
+	return entries
 }
 
 // StatusResponse represents the server status
+type StatusRequest struct {
+	IfNoneMatch string `header:"If-None-Match" doc:"Returns 304 without a body if the status's ETag, as returned by a previous request, is one of these."`
+}
+
 type StatusResponse struct {
+	ETag string `header:"ETag" doc:"Changes whenever the status does. Pass it in If-None-Match to poll without downloading an unchanged status."`
 	Body struct {
-		Status    AgentStatus  `json:"status" doc:"Current agent status. 'running' means that the agent is processing a message, 'stable' means that the agent is idle and waiting for input."`
-		AgentType mf.AgentType `json:"agent_type" doc:"Type of the agent being used by the server."`
-		Transport Transport    `json:"transport" doc:"Backend transport being used ('acp' or 'pty')."`
+		Status               AgentStatus       `json:"status" doc:"Current agent status. 'running' means that the agent is processing a message, 'stable' means that the agent is idle and waiting for input. Kept for compatibility: process_state and conversation_state tell apart what it conflates."`
+		ProcessState         ProcessState      `json:"process_state" doc:"State of the agent's process: 'starting' until the agent has started up, 'running', and 'exited' once the process exited, while the server shuts down."`
+		ConversationState    ConversationState `json:"conversation_state" doc:"State of the conversation: 'initializing' until the agent has started up, 'generating' while it works on a message, 'waiting_input' while it waits on an interactive prompt such as a permission dialog (see GET /pending-prompt), and 'idle' while it waits for the next message."`
+		QueueDepth           int               `json:"queue_depth" doc:"Number of messages accepted but not yet sent to the agent, including the initial prompt."`
+		AgentType            mf.AgentType      `json:"agent_type" doc:"Type of the agent being used by the server."`
+		Transport            Transport         `json:"transport" doc:"Backend transport being used, e.g. 'pty' or 'acp'."`
+		ContextUsedPercent   *int              `json:"context_used_percent,omitempty" minimum:"0" maximum:"100" doc:"Share of the model's context window in use, as shown by the agent. Omitted if the agent doesn't currently show it."`
+		Banner               *StartupBanner    `json:"banner,omitempty" doc:"Configuration the agent showed in its startup banner. Omitted until it's recognized. Only reported by the PTY transport."`
+		Tags                 map[string]string `json:"tags,omitempty" doc:"Labels assigned to the server with --tag, e.g. the project or repository the agent works on."`
+		Title                string            `json:"title,omitempty" doc:"Terminal window title last set by the agent. Only reported by the PTY transport."`
+		Sandbox              *SandboxStatus    `json:"sandbox,omitempty" doc:"Restrictions the agent runs under. Omitted unless the server runs with --sandbox."`
+		ConfiguredMCPServers []string          `json:"configured_mcp_servers,omitempty" doc:"Names of the MCP servers given to the agent with --mcp-config. The agent may not have loaded all of them: see mcp_servers, or the counts in banner when the agent shows them."`
+		MCPServers           []MCPServerStatus `json:"mcp_servers,omitempty" doc:"MCP servers the agent reported loading, with or without --mcp-config, and whether each connected. Omitted until the agent reports them. Only reported by the claude-headless transport."`
+		Draining             bool              `json:"draining" doc:"Whether the server is draining before shutting down, after POST /drain or SIGTERM. User messages are rejected while draining."`
+		TakenOver            *Takeover         `json:"taken_over,omitempty" doc:"Who took over the agent's terminal with POST /takeover. User messages are rejected until POST /release. Omitted while the API controls it."`
+	}
+}
+
+// MCPServerStatus is an MCP server the agent loaded.
+type MCPServerStatus struct {
+	Name   string `json:"name" example:"docs" doc:"Name of the server."`
+	Status string `json:"status" example:"connected" doc:"Status of the server as the agent reports it, e.g. 'connected', 'pending' or 'failed'."`
+}
+
+// StartupBanner is the configuration an agent shows when it starts. Fields
+// the agent doesn't show are omitted.
+type StartupBanner struct {
+	Version          string `json:"version,omitempty" example:"2.0.14" doc:"Version of the agent."`
+	Model            string `json:"model,omitempty" example:"Sonnet 4.5" doc:"Model the agent uses, as it names it."`
+	Workspace        string `json:"workspace,omitempty" example:"~/dev/agentapi" doc:"Directory the agent works in."`
+	WorkspaceTrusted *bool  `json:"workspace_trusted,omitempty" doc:"Whether the agent trusts the files in the workspace. False while it asks whether to."`
+	MCPServers       int    `json:"mcp_servers,omitempty" doc:"Number of MCP servers the agent loaded."`
+	MCPServersFailed int    `json:"mcp_servers_failed,omitempty" doc:"Number of MCP servers that failed to connect."`
+}
+
+// SessionInfo describes a session hosted by the server.
+type SessionInfo struct {
+	ID                string            `json:"id" doc:"ID of the session. Its API is served under /sessions/{id}."`
+	AgentType         mf.AgentType      `json:"agent_type" doc:"Type of the session's agent."`
+	Status            AgentStatus       `json:"status" doc:"Status of the session's agent, as reported by GET /status."`
+	ProcessState      ProcessState      `json:"process_state" doc:"State of the session's agent process."`
+	ConversationState ConversationState `json:"conversation_state" doc:"State of the session's conversation."`
+	Tags              map[string]string `json:"tags,omitempty" doc:"Labels of the session, as reported by its GET /status. Each session but the default one has a 'session' tag with its ID."`
+	LastActivity      time.Time         `json:"last_activity" doc:"When the session's latest message was sent, or when the session started if it has none."`
+	ExpiresAt         *time.Time        `json:"expires_at,omitempty" doc:"When the session's ttl expires, or expired for an archived session. Omitted if it has none."`
+	Archived          bool              `json:"archived,omitempty" doc:"Set once the session's ttl expired: its agent was stopped, and GET /sessions/{id}/status and GET /sessions/{id}/messages serve them as they were then. DELETE /sessions/{id} removes it."`
+}
+
+// SessionsRequest filters and sorts the sessions listed by GET /sessions.
+type SessionsRequest struct {
+	Tags []string `query:"tag,explode" doc:"Only list the sessions with this tag, as key=value. May be repeated, in which case sessions must have all the tags."`
+	Sort string   `query:"sort" example:"last_activity" doc:"Sort the sessions by 'status', 'last_activity' (most recent first) or the value of a tag, as 'tag:<key>' (sessions without it last), rather than by ID. Sessions that compare equal stay sorted by ID."`
+}
+
+// SessionsResponse lists the sessions hosted by the server.
+type SessionsResponse struct {
+	Body struct {
+		Sessions []SessionInfo `json:"sessions" doc:"Sessions, sorted by ID unless sort is given. The agent the server was started with is the 'default' session."`
+	}
+}
+
+// StartSessionRequest starts a session running an agent.
+type StartSessionRequest struct {
+	Body struct {
+		ID      string            `json:"id" example:"review" doc:"ID of the session, whose API is served under /sessions/{id}. Must start with a letter or digit and contain only letters, digits, '.', '_' and '-'."`
+		Command []string          `json:"command" minItems:"1" doc:"Program running the agent, followed by its arguments, e.g. [\"aider\", \"--model\", \"sonnet\"]."`
+		Tags    map[string]string `json:"tags,omitempty" doc:"Labels added to those of the server, e.g. the project or repository the agent works on. GET /sessions can filter and sort on them."`
+		TTL     string            `json:"ttl,omitempty" example:"8h" doc:"How long the session runs before its state is saved, its agent is stopped and it is archived read-only. Runs until stopped if omitted."`
+	}
+}
+
+// SessionRequest identifies a session.
+type SessionRequest struct {
+	Id string `path:"id" doc:"ID of the session"`
+}
+
+// UpdateSessionRequest changes the tags of a session.
+type UpdateSessionRequest struct {
+	Id   string `path:"id" doc:"ID of the session"`
+	Body struct {
+		Tags       map[string]string `json:"tags,omitempty" doc:"Tags to set, replacing the value of those the session has."`
+		RemoveTags []string          `json:"remove_tags,omitempty" doc:"Keys of the tags to remove. Tags are removed before those in tags are set."`
+	}
+}
+
+// SessionResponse describes a session.
+type SessionResponse struct {
+	Body SessionInfo
+}
+
+// Takeover describes a human controlling the agent's terminal.
+type Takeover struct {
+	By     string    `json:"by,omitempty" doc:"Who took over the terminal, as given to POST /takeover."`
+	Reason string    `json:"reason,omitempty" doc:"Why the terminal was taken over, as given to POST /takeover."`
+	Since  time.Time `json:"since" doc:"When the terminal was taken over."`
+}
+
+// TakeoverRequestBody describes who takes over the agent's terminal.
+type TakeoverRequestBody struct {
+	By     string `json:"by,omitempty" example:"alice" doc:"Who takes over the terminal, shown to the clients whose messages are rejected."`
+	Reason string `json:"reason,omitempty" example:"Debugging the failing build" doc:"Why the terminal is taken over."`
+}
+
+// TakeoverRequest takes over the agent's terminal.
+type TakeoverRequest struct {
+	Body *TakeoverRequestBody
+}
+
+// TakeoverResponse describes the takeover.
+type TakeoverResponse struct {
+	Body Takeover
+}
+
+// DrainResponse reports the progress of draining.
+type DrainResponse struct {
+	Body struct {
+		Started  time.Time `json:"started" doc:"When draining started."`
+		Deadline time.Time `json:"deadline" doc:"When the server shuts down even if the agent is still working."`
+		Drained  bool      `json:"drained" doc:"Whether the agent is done and the server is shutting down."`
+	}
+}
+
+// SandboxStatus reports the filesystem sandbox of an agent started with
+// --sandbox.
+type SandboxStatus struct {
+	Mechanism   string   `json:"mechanism" enum:"landlock" doc:"How the agent is sandboxed."`
+	ABI         int      `json:"abi" doc:"Version of the Landlock ABI supported by the kernel, which determines what can be restricted."`
+	ReadWrite   []string `json:"read_write" nullable:"false" doc:"Paths the agent can read, write and execute. Paths that didn't exist when the agent started are not accessible."`
+	ReadOnly    []string `json:"read_only" nullable:"false" doc:"Paths the agent can only read and execute."`
+	Limitations []string `json:"limitations" nullable:"false" doc:"What the sandbox doesn't restrict."`
+}
+
+// BlockedHost is a host the sandboxed agent was blocked from reaching.
+type BlockedHost struct {
+	Host        string    `json:"host" doc:"Host the agent tried to reach."`
+	Attempts    int       `json:"attempts" doc:"Number of times the agent tried to reach the host."`
+	LastAttempt time.Time `json:"last_attempt" doc:"When the agent last tried to reach the host."`
+}
+
+// NetworkPolicyResponse lists what the sandboxed agent can reach.
+type NetworkPolicyResponse struct {
+	Body struct {
+		AllowedDomains []string      `json:"allowed_domains" nullable:"false" doc:"Domains the agent can reach, along with their subdomains."`
+		Blocked        []BlockedHost `json:"blocked" nullable:"false" doc:"Hosts the agent was blocked from reaching and that haven't been allowed since, most recent first."`
+	}
+}
+
+// NetworkAllowRequest lets the sandboxed agent reach more domains.
+type NetworkAllowRequest struct {
+	Body struct {
+		Domains []string `json:"domains" minItems:"1" doc:"Domains to allow, along with their subdomains, e.g. 'github.com'."`
+	}
+}
+
+// AnalyticsResponse summarizes the conversation so far.
+type AnalyticsResponse struct {
+	Body struct {
+		Turns                      int            `json:"turns" doc:"Number of user messages sent to the agent."`
+		CompletedTurns             int            `json:"completed_turns" doc:"Number of user messages the agent has finished responding to."`
+		AverageTurnDurationSeconds float64        `json:"average_turn_duration_seconds" doc:"Average time from a user message to the agent becoming stable again, over the completed turns."`
+		LongestStallSeconds        float64        `json:"longest_stall_seconds" doc:"Longest time the agent went without producing output while responding, including the current turn."`
+		ToolCalls                  map[string]int `json:"tool_calls" nullable:"false" doc:"Number of tool calls by tool name. Only reported by some transports, such as ACP."`
+		UserTokens                 int            `json:"user_tokens" doc:"Estimated number of tokens of the user messages, as in the estimated_tokens field of messages."`
+		AgentTokens                int            `json:"agent_tokens" doc:"Estimated number of tokens of the agent messages, as in the estimated_tokens field of messages."`
+	}
+}
+
+// TurnUsage is the estimated usage of a turn of the conversation: a user
+// message and the agent's response to it.
+type TurnUsage struct {
+	UserMessageId     int      `json:"user_message_id" doc:"ID of the user message that started the turn."`
+	InputTokens       int      `json:"input_tokens" doc:"Estimated number of tokens the model read: the conversation up to and including the user message."`
+	OutputTokens      int      `json:"output_tokens" doc:"Estimated number of tokens of the agent's response."`
+	CostUSD           *float64 `json:"cost_usd,omitempty" doc:"Estimated cost of the turn in US dollars. Omitted if the server has no price for the agent's model."`
+	CumulativeCostUSD *float64 `json:"cumulative_cost_usd,omitempty" doc:"Estimated cost of the conversation up to and including this turn, in US dollars."`
+}
+
+// UsageResponse reports the estimated usage and cost of the conversation.
+type UsageResponse struct {
+	Body struct {
+		Model        string      `json:"model,omitempty" doc:"Model the costs are computed for, set with --pricing-model."`
+		Turns        []TurnUsage `json:"turns" nullable:"false" doc:"Usage of each turn, oldest first."`
+		InputTokens  int         `json:"input_tokens" doc:"Estimated number of input tokens of all turns."`
+		OutputTokens int         `json:"output_tokens" doc:"Estimated number of output tokens of all turns."`
+		CostUSD      *float64    `json:"cost_usd,omitempty" doc:"Estimated cost of the conversation in US dollars. Omitted if the server has no price for the agent's model."`
 	}
 }
 
 // MessagesResponse represents the list of messages
+type MessagesRequest struct {
+	IfNoneMatch string   `header:"If-None-Match" doc:"Returns 304 without a body if the messages' ETag, as returned by a previous request, is one of these."`
+	Include     []string `query:"include" enum:"debug" doc:"Optional fields to add to the messages. 'debug' adds how agent messages were parsed from the screen, and requires the server to run with --debug-messages."`
+	BeforeId    int      `query:"before_id" minimum:"0" doc:"Only return messages with a lower ID, to page back through the conversation. 0, the default, starts after the latest message."`
+	Limit       int      `query:"limit" minimum:"0" doc:"Return at most this many messages, the latest ones before before_id. 0, the default, returns all of them."`
+}
+
 type MessagesResponse struct {
+	ETag string `header:"ETag" doc:"Changes whenever the messages do. It starts with the ID of the latest message, which identifies how far the conversation has advanced: pass it in If-Match to make a message conditional on the conversation not having advanced, or in If-None-Match to poll without downloading unchanged messages."`
 	Body struct {
 		Messages []Message `json:"messages" nullable:"false" doc:"List of messages"`
+		HasMore  bool      `json:"has_more,omitempty" doc:"Set when limit left out older messages. Request them with before_id set to the ID of the first message returned."`
 	}
 }
 
+type MessagesExportFormat string
+
+const (
+	MessagesExportNDJSON    MessagesExportFormat = "ndjson"
+	MessagesExportJSON      MessagesExportFormat = "json"
+	MessagesExportMarkdown  MessagesExportFormat = "markdown"
+	MessagesExportOpenAI    MessagesExportFormat = "openai"
+	MessagesExportAnthropic MessagesExportFormat = "anthropic"
+)
+
+type MessagesExportRequest struct {
+	Format    MessagesExportFormat `query:"format" enum:"ndjson,json,markdown,openai,anthropic" default:"ndjson" doc:"'ndjson' writes one message per line, 'json' the same document as GET /messages, and 'markdown' a transcript with a heading per message. 'openai' and 'anthropic' write the conversation as a single line of fine-tuning JSONL, in the chat format of the OpenAI and Anthropic (Amazon Bedrock) fine-tuning APIs."`
+	System    string               `query:"system" doc:"System prompt of the fine-tuning example. Only used by the 'openai' and 'anthropic' formats."`
+	UserRole  string               `query:"user_role" default:"user" doc:"Role given to user messages in fine-tuning examples."`
+	AgentRole string               `query:"agent_role" default:"assistant" doc:"Role given to agent messages in fine-tuning examples."`
+}
+
 type MessageRequestBody struct {
-	Content string      `json:"content" example:"Hello, agent!" doc:"Message content"`
-	Type    MessageType `json:"type" doc:"A 'user' type message will be logged as a user message in the conversation history and submitted to the agent. AgentAPI will wait until the agent starts carrying out the task described in the message before responding. A 'raw' type message will be written directly to the agent's terminal session as keystrokes and will not be saved in the conversation history. 'raw' messages are useful for sending escape sequences to the terminal."`
+	Content        string      `json:"content" example:"Hello, agent!" doc:"Message content"`
+	Attachments    []string    `json:"attachments,omitempty" doc:"IDs of attachments stored with POST /attachments. References to their files are appended to the content of 'user' messages, so the agent reads them."`
+	AllowDuplicate bool        `json:"allow_duplicate,omitempty" doc:"Send a 'user' message even if it's identical to one of the latest ones, which is rejected with --reject-duplicates."`
+	Type           MessageType `json:"type" doc:"A 'user' type message will be logged as a user message in the conversation history and submitted to the agent. AgentAPI will wait until the agent starts carrying out the task described in the message before responding. A 'raw' type message will be written directly to the agent's terminal session as keystrokes and will not be saved in the conversation history. 'raw' messages are useful for sending escape sequences to the terminal."`
 }
 
 // MessageRequest represents a request to create a new message
 type MessageRequest struct {
-	Body MessageRequestBody `json:"body" doc:"Message content and type"`
+	IfMatch string             `header:"If-Match" doc:"Only send the message if the conversation's ETag, as returned by GET /messages, is one of these. Returns 412 if the conversation has advanced since."`
+	Body    MessageRequestBody `json:"body" doc:"Message content and type"`
 }
 
 // MessageResponse represents a newly created message
 type MessageResponse struct {
+	ETag string `header:"ETag" doc:"Identifies how far the conversation has advanced: it changes whenever a message is added. Pass it in If-Match to make a message conditional on the conversation not having advanced."`
 	Body struct {
-		Ok bool `json:"ok" doc:"Indicates whether the message was sent successfully. For messages of type 'user', success means detecting that the agent began executing the task described. For messages of type 'raw', success means the keystrokes were sent to the terminal."`
+		Ok      bool   `json:"ok" doc:"Indicates whether the message was sent successfully. For messages of type 'user', success means detecting that the agent began executing the task described. For messages of type 'raw', success means the keystrokes were sent to the terminal."`
+		Warning string `json:"warning,omitempty" doc:"Set when a 'user' message is identical to one of the latest ones, as when automation retries blindly. Only checked with --duplicate-prompts."`
 	}
 }
 
@@ -89,6 +568,311 @@ type UploadResponse struct {
 	}
 }
 
+type AttachmentRequest struct {
+	Id string `path:"id" doc:"ID of the attachment"`
+}
+
+type AttachmentBody struct {
+	Id      string    `json:"id" doc:"ID to reference the attachment from messages."`
+	Name    string    `json:"name" doc:"File name of the attachment."`
+	Size    int64     `json:"size" doc:"Size of the attachment in bytes."`
+	Path    string    `json:"path" doc:"Path where the agent reads the attachment."`
+	Created time.Time `json:"created" doc:"When the attachment was stored."`
+}
+
+type AttachmentResponse struct {
+	Body AttachmentBody
+}
+
+// StorageCategory groups the files kept by the server on disk.
+type StorageCategory string
+
+const (
+	StorageCategoryAttachments StorageCategory = "attachments"
+	StorageCategoryUploads     StorageCategory = "uploads"
+	StorageCategoryTranscripts StorageCategory = "transcripts"
+	StorageCategoryState       StorageCategory = "state"
+	StorageCategoryFixtures    StorageCategory = "fixtures"
+)
+
+var StorageCategoryValues = []StorageCategory{
+	StorageCategoryAttachments,
+	StorageCategoryUploads,
+	StorageCategoryTranscripts,
+	StorageCategoryState,
+	StorageCategoryFixtures,
+}
+
+func (c StorageCategory) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "StorageCategory", StorageCategoryValues)
+}
+
+type StorageUsage struct {
+	Category      StorageCategory `json:"category" doc:"Kind of files: attachments from POST /attachments, files from POST /upload, --tee-output transcripts, the --state-file and its snapshots, or --fixtures-dir screen captures."`
+	Bytes         int64           `json:"bytes" doc:"Disk space used by the files of the category, in bytes."`
+	Files         int             `json:"files" doc:"Number of files of the category."`
+	PrunableBytes int64           `json:"prunable_bytes" doc:"Part of bytes the retention policy may delete. The state file and its snapshots, the current transcript and fixtures are never deleted."`
+}
+
+type StorageResponse struct {
+	Body struct {
+		Categories []StorageUsage `json:"categories" nullable:"false" doc:"Disk usage of each category."`
+		TotalBytes int64          `json:"total_bytes" doc:"Disk space used by all categories, in bytes."`
+	}
+}
+
+type FileRequest struct {
+	Path string `path:"path" doc:"Path of the file or directory relative to the agent's working directory, with slashes encoded as %2F."`
+}
+
+type FileEntry struct {
+	Name     string    `json:"name" doc:"Name of the file or directory."`
+	Path     string    `json:"path" doc:"Path relative to the agent's working directory."`
+	Type     FileType  `json:"type" doc:"Whether this is a file or a directory."`
+	Size     int64     `json:"size" doc:"Size of the file in bytes. 0 for directories."`
+	Modified time.Time `json:"modified" doc:"When the file was last modified."`
+}
+
+type FileBody struct {
+	FileEntry
+	Entries  []FileEntry  `json:"entries,omitempty" doc:"Files and directories in a directory that may be browsed, directories first."`
+	Content  string       `json:"content,omitempty" doc:"Content of a file."`
+	Encoding FileEncoding `json:"encoding,omitempty" doc:"Encoding of the content: 'utf-8' for text, 'base64' for binary files."`
+}
+
+type FileResponse struct {
+	Body FileBody
+}
+
+// Artifact is a file the agent produced in a turn, as it was when the turn
+// completed.
+type Artifact struct {
+	Path     string    `json:"path" doc:"Path of the file relative to the agent's working directory."`
+	Size     int64     `json:"size" doc:"Size of the file in bytes when it was captured."`
+	Modified time.Time `json:"modified" doc:"When the file was last modified before it was captured."`
+	SHA256   string    `json:"sha256" doc:"SHA-256 digest of the captured content, as returned by GET /messages/{id}/artifacts/{path}. Artifacts of different turns with the same digest are the same version of the file."`
+	Captured time.Time `json:"captured" doc:"When the file was captured, once the agent completed its turn."`
+}
+
+type MessageArtifactsRequest struct {
+	Id int `path:"id" minimum:"0" doc:"ID of the agent message."`
+}
+
+type MessageArtifactsResponse struct {
+	Body struct {
+		Artifacts []Artifact `json:"artifacts" doc:"Files the message announced, e.g. 'created report.md', or its tool calls modified, in the order they were mentioned."`
+	}
+}
+
+type MessageArtifactRequest struct {
+	Id   int    `path:"id" minimum:"0" doc:"ID of the agent message."`
+	Path string `path:"path" doc:"Path of the artifact relative to the agent's working directory, with slashes encoded as %2F."`
+}
+
 type UploadRequest struct {
 	File huma.FormFile `form:"file" required:"true" doc:"file that needs to be uploaded"`
 }
+
+// ScreenRawResponse is the exact current capture of the agent's terminal.
+type ScreenRawResponse struct {
+	ContentType string `header:"Content-Type"`
+	Body        []byte
+}
+
+type ScreenSaveRequest struct {
+	Body struct {
+		Name      string `json:"name" example:"first_message" doc:"Name of the fixture. Used as the directory name under <fixtures-dir>/<agent-type>/."`
+		Format    string `json:"format,omitempty" enum:"ansi,txt" default:"txt" doc:"Capture format. msg.txt is always written; 'ansi' also writes msg.ansi with color escape codes preserved."`
+		Overwrite bool   `json:"overwrite,omitempty" doc:"Replace the files of an existing fixture. Without it, saving over an existing fixture returns 409."`
+	}
+}
+
+type ScreenSaveResponse struct {
+	Body struct {
+		Ok  bool   `json:"ok" doc:"Indicates whether the capture was saved successfully."`
+		Dir string `json:"dir" doc:"Directory the fixture files were written to"`
+	}
+}
+
+// Command is a slash command advertised by the agent.
+type Command struct {
+	Name        string `json:"name" example:"web" doc:"Name of the command, without the leading slash."`
+	Description string `json:"description" doc:"Human-readable description of what the command does."`
+	InputHint   string `json:"input_hint,omitempty" doc:"Hint for the input expected after the command name. Omitted if the command takes no input."`
+}
+
+// CommandsResponse represents the list of available commands
+type CommandsResponse struct {
+	Body struct {
+		Commands []Command `json:"commands" nullable:"false" doc:"Commands the agent currently advertises. Empty if the transport does not report commands."`
+	}
+}
+
+// CommandRequest represents a request to invoke a command
+type CommandRequest struct {
+	IfMatch string `header:"If-Match" doc:"Only send the command if the conversation's ETag, as returned by GET /messages, is one of these. Returns 412 if the conversation has advanced since."`
+	Body    struct {
+		Name  string `json:"name" example:"web" doc:"Name of the command to invoke, without the leading slash."`
+		Input string `json:"input,omitempty" doc:"Input passed to the command."`
+	}
+}
+
+// CommandResponse represents the result of invoking a command
+type CommandResponse struct {
+	ETag string `header:"ETag" doc:"Identifies how far the conversation has advanced: it changes whenever a message is added. Pass it in If-Match to make a message conditional on the conversation not having advanced."`
+	Body struct {
+		Ok bool `json:"ok" doc:"Indicates whether the command was sent to the agent successfully."`
+	}
+}
+
+// ConversationDiffRequest selects the point to diff the conversation from
+type ConversationDiffRequest struct {
+	Since  string `query:"since" doc:"Checkpoint returned by a previous call. Takes precedence over from_id."`
+	FromId int    `query:"from_id" default:"-1" minimum:"-1" doc:"Return the messages after this message ID. -1 returns all messages."`
+	ToId   int    `query:"to_id" default:"-1" minimum:"-1" doc:"Only return messages up to and including this message ID. -1 returns messages up to the latest one."`
+}
+
+// MessageChange describes how a message changed since a checkpoint
+type MessageChange struct {
+	Id        int    `json:"id" doc:"ID of the message."`
+	Content   string `json:"content" doc:"Current content of the message."`
+	Appended  string `json:"appended" doc:"Text added to the end of the message since the checkpoint. Empty if the message was rewritten."`
+	Rewritten bool   `json:"rewritten" doc:"Whether the message content seen at the checkpoint is no longer a prefix of the current content. Terminal agents may redraw earlier parts of a message."`
+}
+
+// ConversationDiffResponse lists the conversation changes since a checkpoint
+type ConversationDiffResponse struct {
+	Body struct {
+		Checkpoint string         `json:"checkpoint" doc:"Opaque checkpoint to pass as 'since' on the next call."`
+		Added      []Message      `json:"added" nullable:"false" doc:"Messages added since the checkpoint."`
+		Changed    *MessageChange `json:"changed,omitempty" doc:"Change to the last message seen at the checkpoint, if it was updated since. Agent messages are updated while the agent responds."`
+	}
+}
+
+// WaitForRequest describes the pattern to wait for
+type WaitForRequest struct {
+	Body struct {
+		Pattern string        `json:"pattern" minLength:"1" example:"All tests passed" doc:"Regular expression (RE2 syntax) to wait for."`
+		Timeout string        `json:"timeout,omitempty" default:"10m" example:"10m" doc:"How long to wait before giving up, as a Go duration."`
+		Source  WaitForSource `json:"source,omitempty" default:"message" doc:"Where to look for the pattern: the latest agent message, the terminal screen, or either. The screen also shows the user's prompt, so a pattern the prompt mentions matches it right away."`
+	}
+}
+
+// WaitForResponse describes where the pattern was found
+type WaitForResponse struct {
+	Body struct {
+		Source    WaitForSource `json:"source" doc:"Where the pattern was found, 'screen' or 'message'."`
+		Match     string        `json:"match" doc:"Text that matched the pattern."`
+		MessageId *int          `json:"message_id,omitempty" doc:"ID of the agent message that matched, if the pattern was found in a message."`
+	}
+}
+
+// PendingPromptResponse represents the prompt the agent is waiting on
+type PendingPromptResponse struct {
+	Body struct {
+		Prompt *PendingPrompt `json:"prompt,omitempty" doc:"The prompt the agent is waiting on. Absent if there's none."`
+	}
+}
+
+// PendingPromptReplyRequest chooses an option of the pending prompt
+type PendingPromptReplyRequest struct {
+	Body struct {
+		Option string `json:"option" minLength:"1" doc:"Key of the option to choose, as returned by GET /pending-prompt."`
+	}
+}
+
+// PendingPromptReplyResponse represents the result of answering the prompt
+type PendingPromptReplyResponse struct {
+	Body struct {
+		Ok bool `json:"ok" doc:"Indicates whether the reply was sent to the agent."`
+	}
+}
+
+// NotesResponse represents the notes attached to the conversation
+type NotesResponse struct {
+	Body struct {
+		Notes map[string]string `json:"notes" nullable:"false" doc:"Client-managed key-value notes, such as a ticket ID or CI run URL."`
+	}
+}
+
+// NotesRequest replaces the notes attached to the conversation
+type NotesRequest struct {
+	Body struct {
+		Notes map[string]string `json:"notes" doc:"Notes to store. Replaces all existing notes; send an empty object to clear them."`
+	}
+}
+
+// MessagePinRequest pins or unpins a message
+type MessagePinRequest struct {
+	Id   int `path:"id" minimum:"0" doc:"ID of the message."`
+	Body struct {
+		Pinned bool `json:"pinned" doc:"Whether to pin the message. Send false to unpin it."`
+	}
+}
+
+// MessagePinResponse is the message after pinning or unpinning it
+type MessagePinResponse struct {
+	Body Message
+}
+
+// StateSnapshot is a named copy of the state file
+type StateSnapshot struct {
+	Name     string    `json:"name" doc:"Name of the snapshot."`
+	Time     time.Time `json:"time" doc:"When the snapshot was taken."`
+	Messages int       `json:"messages" doc:"Number of messages in the snapshot."`
+	Bytes    int64     `json:"bytes" doc:"Size of the snapshot, in bytes."`
+	Warnings []string  `json:"warnings,omitempty" doc:"When restoring, how the environment the snapshot was taken in differs from the current one, e.g. another agent version."`
+}
+
+// StateSnapshotCreateRequest takes a snapshot of the state file
+type StateSnapshotCreateRequest struct {
+	Body struct {
+		Name string `json:"name" pattern:"^[A-Za-z0-9][A-Za-z0-9._-]*$" maxLength:"100" doc:"Name of the snapshot, e.g. before-refactor. Letters, digits, dots, dashes and underscores. Taking a snapshot with an existing name replaces it."`
+	}
+}
+
+// StateSnapshotRestoreRequest restores a snapshot
+type StateSnapshotRestoreRequest struct {
+	Name string `path:"name" doc:"Name of the snapshot."`
+}
+
+// StateSnapshotResponse is a snapshot that was taken or restored
+type StateSnapshotResponse struct {
+	Body StateSnapshot
+}
+
+// StateSnapshotsResponse lists the snapshots of the state file
+type StateSnapshotsResponse struct {
+	Body struct {
+		Snapshots []StateSnapshot `json:"snapshots" nullable:"false" doc:"Snapshots, oldest first."`
+	}
+}
+
+// GitPRRequestBody describes the pull request to open
+type GitPRRequestBody struct {
+	Title         string `json:"title" minLength:"1" doc:"Title of the pull request."`
+	Branch        string `json:"branch,omitempty" doc:"Branch to create for the changes. Defaults to agentapi/<timestamp>."`
+	Base          string `json:"base,omitempty" doc:"Branch to open the pull request against. Defaults to the currently checked out branch."`
+	CommitMessage string `json:"commit_message,omitempty" doc:"Commit message. Defaults to the title."`
+	Draft         bool   `json:"draft,omitempty" doc:"Open the pull request as a draft."`
+}
+
+// GitPRRequest represents a request to open a pull request
+type GitPRRequest struct {
+	Body GitPRRequestBody
+}
+
+// GitPRResponse represents the opened pull request
+type GitPRResponse struct {
+	Body struct {
+		Url    string `json:"url" doc:"URL of the pull request."`
+		Number int    `json:"number" doc:"Number of the pull request."`
+		Branch string `json:"branch" doc:"Branch the changes were committed to."`
+	}
+}
+
+// ScreenStreamRequest is the input of GET /internal/screen.
+type ScreenStreamRequest struct {
+	MaxRate  int    `query:"max_rate" minimum:"0" doc:"Maximum number of screen events per second. Faster updates are coalesced, sending only the latest screen. The server's --screen-max-rate still applies. 0 means no limit."`
+	Encoding string `query:"encoding" enum:"full,diff" default:"full" doc:"How screen updates are encoded. 'full' sends the whole screen in each 'screen' event. 'diff' sends the first screen in full, then only the changed lines in 'screen_diff' events."`
+}
@@ -0,0 +1,113 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/agentapi/lib/metrics"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// onMessageCmdTimeout bounds each run of --on-message-cmd.
+	onMessageCmdTimeout = time.Minute
+	// onMessageCmdBufSize is the number of messages waiting for their run.
+	onMessageCmdBufSize = 100
+)
+
+// onMessageCmd runs --on-message-cmd through the shell for each finalized
+// agent message, with the message as JSON on stdin. Runs happen one at a
+// time in the background, and a failed run doesn't affect the others.
+type onMessageCmd struct {
+	command string
+	logger  *slog.Logger
+	metrics *metrics.Registry
+
+	mu      sync.Mutex
+	closed  bool
+	records chan transcriptRecord
+	done    chan struct{}
+}
+
+func newOnMessageCmd(command string, logger *slog.Logger, metricsRegistry *metrics.Registry) transcriptSink {
+	c := &onMessageCmd{
+		command: command,
+		logger:  logger,
+		metrics: metricsRegistry,
+		records: make(chan transcriptRecord, onMessageCmdBufSize),
+		done:    make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// writeRecord queues the agent's messages.
+func (c *onMessageCmd) writeRecord(record transcriptRecord) {
+	if record.Type != transcriptRecordMessage || record.Role != st.ConversationRoleAgent {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.records <- record:
+	default:
+		c.metrics.Inc("agentapi_on_message_cmd_dropped_total", "Agent messages --on-message-cmd wasn't run for because earlier runs fell behind.")
+	}
+}
+
+func (c *onMessageCmd) run() {
+	defer close(c.done)
+	for record := range c.records {
+		if err := runOnMessageCmd(c.command, record); err != nil {
+			c.logger.Warn("Failed to run --on-message-cmd", "messageId", record.Id, "error", err)
+			c.metrics.Inc("agentapi_on_message_cmd_failed_total", "Runs of --on-message-cmd that failed.")
+		}
+	}
+}
+
+// Close waits for the queued messages to be processed.
+func (c *onMessageCmd) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.records)
+	c.mu.Unlock()
+
+	<-c.done
+	return nil
+}
+
+func runOnMessageCmd(command string, record transcriptRecord) error {
+	input, err := json.Marshal(record)
+	if err != nil {
+		return xerrors.Errorf("failed to marshal message: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), onMessageCmdTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stdin = bytes.NewReader(input)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return xerrors.Errorf("%s: %w: %s", command, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
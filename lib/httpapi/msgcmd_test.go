@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coder/agentapi/lib/metrics"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnMessageCmd(t *testing.T) {
+	t.Parallel()
+
+	out := filepath.Join(t.TempDir(), "messages.jsonl")
+	sink := newOnMessageCmd("cat >> "+out+" && echo >> "+out, slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	emitter := NewEventEmitter(withTranscriptSinks(sink))
+
+	emitter.EmitMessages([]st.ConversationMessage{{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"}})
+	emitter.EmitStatus(st.ConversationStatusStable)
+	emitter.EmitStatus(st.ConversationStatusChanging)
+	emitter.EmitMessages([]st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"},
+		{Id: 1, Role: st.ConversationRoleUser, Message: "Hi"},
+		{Id: 2, Role: st.ConversationRoleAgent, Message: "Hello"},
+	})
+	emitter.EmitStatus(st.ConversationStatusStable)
+	require.NoError(t, sink.Close())
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	// User messages don't trigger the command.
+	require.Len(t, lines, 2)
+	for i, content := range []string{"Welcome", "Hello"} {
+		var record transcriptRecord
+		require.NoError(t, json.Unmarshal([]byte(lines[i]), &record))
+		assert.Equal(t, st.ConversationRoleAgent, record.Role)
+		assert.Equal(t, content, record.Content)
+	}
+}
+
+func TestOnMessageCmd_Failures(t *testing.T) {
+	t.Parallel()
+
+	out := filepath.Join(t.TempDir(), "messages.txt")
+	registry := metrics.New()
+	// The command fails for the first message only.
+	sink := newOnMessageCmd(`grep -q '"content":"fail"' && exit 1; echo ok >> `+out, slog.New(slog.NewTextHandler(io.Discard, nil)), registry)
+	emitter := NewEventEmitter(withTranscriptSinks(sink))
+
+	emitter.EmitMessages([]st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleAgent, Message: "fail"},
+		{Id: 1, Role: st.ConversationRoleAgent, Message: "succeed"},
+	})
+	emitter.EmitStatus(st.ConversationStatusStable)
+	require.NoError(t, sink.Close())
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "ok\n", string(data))
+	assert.Equal(t, 1.0, registry.Value("agentapi_on_message_cmd_failed_total"))
+	assert.Zero(t, registry.Value("agentapi_log_export_failed_total", "exporter", "on-message-cmd"))
+}
@@ -0,0 +1,106 @@
+package httpapi
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// NetworkAllowlist is the proxy the sandboxed agent's network access goes
+// through, which only lets it reach the allowed domains and their
+// subdomains.
+type NetworkAllowlist interface {
+	Allow(domains ...string) error
+	Allowed() []string
+	// SetOnBlocked sets a callback called with the host of every request
+	// the proxy blocks.
+	SetOnBlocked(fn func(host string))
+}
+
+// blockedHostsLimit is how many blocked hosts are remembered. The least
+// recently blocked one is forgotten first.
+const blockedHostsLimit = 100
+
+// networkBlocked records an attempt of the agent to reach a host that isn't
+// allowed and publishes it.
+func (s *Server) networkBlocked(host string) {
+	s.blockedMu.Lock()
+	blocked, ok := s.blockedHosts[host]
+	if !ok {
+		if len(s.blockedHosts) >= blockedHostsLimit {
+			var oldest *BlockedHost
+			for _, b := range s.blockedHosts {
+				if oldest == nil || b.LastAttempt.Before(oldest.LastAttempt) {
+					oldest = b
+				}
+			}
+			delete(s.blockedHosts, oldest.Host)
+		}
+		blocked = &BlockedHost{Host: host}
+		s.blockedHosts[host] = blocked
+	}
+	blocked.Attempts++
+	blocked.LastAttempt = s.clock.Now()
+	attempts := blocked.Attempts
+	s.blockedMu.Unlock()
+
+	s.logger.Warn("Blocked the agent's network access", "host", host)
+	s.metrics.Inc("agentapi_network_blocked_total", "Requests of the sandboxed agent to hosts that aren't allowed.")
+	s.emitter.EmitNetworkBlocked(host, attempts)
+}
+
+func (s *Server) networkPolicy() *NetworkPolicyResponse {
+	resp := &NetworkPolicyResponse{}
+	resp.Body.AllowedDomains = s.network.Allowed()
+	s.blockedMu.Lock()
+	resp.Body.Blocked = make([]BlockedHost, 0, len(s.blockedHosts))
+	for _, blocked := range s.blockedHosts {
+		resp.Body.Blocked = append(resp.Body.Blocked, *blocked)
+	}
+	s.blockedMu.Unlock()
+	slices.SortFunc(resp.Body.Blocked, func(a, b BlockedHost) int {
+		return b.LastAttempt.Compare(a.LastAttempt)
+	})
+	return resp
+}
+
+func (s *Server) checkNetworkAllowlist() error {
+	if s.network == nil {
+		return huma.Error404NotFound("the agent's network access isn't restricted, start the server with --sandbox --sandbox-proxy")
+	}
+	return nil
+}
+
+// getNetworkPolicy handles GET /sandbox/network.
+func (s *Server) getNetworkPolicy(ctx context.Context, input *struct{}) (*NetworkPolicyResponse, error) {
+	if err := s.checkNetworkAllowlist(); err != nil {
+		return nil, err
+	}
+	return s.networkPolicy(), nil
+}
+
+// allowNetworkDomains handles POST /sandbox/network/allow.
+func (s *Server) allowNetworkDomains(ctx context.Context, input *NetworkAllowRequest) (*NetworkPolicyResponse, error) {
+	if err := s.checkNetworkAllowlist(); err != nil {
+		return nil, err
+	}
+	if err := s.network.Allow(input.Body.Domains...); err != nil {
+		return nil, huma.Error400BadRequest(err.Error())
+	}
+	s.logger.Info("Allowed the agent's network access", "domains", input.Body.Domains)
+
+	// Hosts that were allowed are no longer blocked.
+	s.blockedMu.Lock()
+	for host := range s.blockedHosts {
+		for _, domain := range input.Body.Domains {
+			domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				delete(s.blockedHosts, host)
+			}
+		}
+	}
+	s.blockedMu.Unlock()
+	return s.networkPolicy(), nil
+}
@@ -0,0 +1,121 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/metrics"
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+// fakeAllowlist is a NetworkAllowlist that only records the allowed
+// domains.
+type fakeAllowlist struct {
+	allowed   []string
+	onBlocked func(host string)
+}
+
+func (a *fakeAllowlist) Allow(domains ...string) error {
+	for _, domain := range domains {
+		if strings.Contains(domain, "/") {
+			return xerrors.Errorf("invalid domain %q", domain)
+		}
+	}
+	a.allowed = append(a.allowed, domains...)
+	return nil
+}
+
+func (a *fakeAllowlist) Allowed() []string                 { return slices.Clone(a.allowed) }
+func (a *fakeAllowlist) SetOnBlocked(fn func(host string)) { a.onBlocked = fn }
+
+func newNetworkServer(t *testing.T, network NetworkAllowlist) (*Server, *quartz.Mock) {
+	t.Helper()
+	mClock := quartz.NewMock(t)
+	s := &Server{
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		emitter:      NewEventEmitter(WithClock(mClock)),
+		clock:        mClock,
+		metrics:      metrics.New(),
+		network:      network,
+		blockedHosts: map[string]*BlockedHost{},
+	}
+	return s, mClock
+}
+
+func TestNetworkBlocked(t *testing.T) {
+	t.Parallel()
+
+	network := &fakeAllowlist{allowed: []string{"github.com"}}
+	s, mClock := newNetworkServer(t, network)
+	network.SetOnBlocked(s.networkBlocked)
+	_, ch, _ := s.emitter.Subscribe()
+
+	network.onBlocked("pypi.org")
+	mClock.Advance(time.Second)
+	network.onBlocked("files.pythonhosted.org")
+	mClock.Advance(time.Second)
+	network.onBlocked("pypi.org")
+	for _, want := range []NetworkBlockedBody{
+		{Host: "pypi.org", Attempts: 1},
+		{Host: "files.pythonhosted.org", Attempts: 1},
+		{Host: "pypi.org", Attempts: 2},
+	} {
+		event := <-ch
+		require.Equal(t, EventTypeNetworkBlocked, event.Type)
+		body := event.Payload.(NetworkBlockedBody)
+		assert.Equal(t, want.Host, body.Host)
+		assert.Equal(t, want.Attempts, body.Attempts)
+	}
+
+	policy, err := s.getNetworkPolicy(context.Background(), &struct{}{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"github.com"}, policy.Body.AllowedDomains)
+	require.Len(t, policy.Body.Blocked, 2)
+	assert.Equal(t, "pypi.org", policy.Body.Blocked[0].Host)
+	assert.Equal(t, 2, policy.Body.Blocked[0].Attempts)
+	assert.Equal(t, "files.pythonhosted.org", policy.Body.Blocked[1].Host)
+}
+
+func TestAllowNetworkDomains(t *testing.T) {
+	t.Parallel()
+
+	network := &fakeAllowlist{}
+	s, _ := newNetworkServer(t, network)
+	s.networkBlocked("upload.pypi.org")
+	s.networkBlocked("example.com")
+
+	req := &NetworkAllowRequest{}
+	req.Body.Domains = []string{"PyPI.org"}
+	policy, err := s.allowNetworkDomains(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PyPI.org"}, policy.Body.AllowedDomains)
+	require.Len(t, policy.Body.Blocked, 1)
+	assert.Equal(t, "example.com", policy.Body.Blocked[0].Host)
+
+	req.Body.Domains = []string{"https://example.com"}
+	_, err = s.allowNetworkDomains(context.Background(), req)
+	var statusErr huma.StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusBadRequest, statusErr.GetStatus())
+}
+
+func TestNetworkAllowlistDisabled(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newNetworkServer(t, nil)
+	_, err := s.getNetworkPolicy(context.Background(), &struct{}{})
+	var statusErr huma.StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusNotFound, statusErr.GetStatus())
+}
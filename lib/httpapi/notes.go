@@ -0,0 +1,34 @@
+package httpapi
+
+import (
+	"context"
+)
+
+// getNotes handles GET /notes
+func (s *Server) getNotes(ctx context.Context, input *struct{}) (*NotesResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	resp := &NotesResponse{}
+	resp.Body.Notes = s.conversation.Notes()
+	if resp.Body.Notes == nil {
+		resp.Body.Notes = map[string]string{}
+	}
+	return resp, nil
+}
+
+// putNotes handles PUT /notes. The notes replace any existing ones and are
+// written to the state file the next time the state is saved.
+func (s *Server) putNotes(ctx context.Context, input *NotesRequest) (*NotesResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conversation.SetNotes(input.Body.Notes)
+
+	resp := &NotesResponse{}
+	resp.Body.Notes = s.conversation.Notes()
+	if resp.Body.Notes == nil {
+		resp.Body.Notes = map[string]string{}
+	}
+	return resp, nil
+}
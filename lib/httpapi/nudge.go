@@ -0,0 +1,93 @@
+package httpapi
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+)
+
+// nudgeInterval is how often the agent is checked for inactivity.
+const nudgeInterval = time.Second
+
+// NudgeConfig configures the follow-up messages sent to an agent that
+// stopped to wait on the user, for unattended runs.
+type NudgeConfig struct {
+	// After is how long the agent has to be stable with its reply
+	// unanswered before it is nudged. 0 disables nudging.
+	After time.Duration
+	// Message is the follow-up, e.g. "continue". {n} is replaced with the
+	// number of the nudge and {max} with Max.
+	Message string
+	// Max is how many nudges are sent in a row. The count starts over when
+	// the user sends a message.
+	Max int
+}
+
+func (c NudgeConfig) message(n int) string {
+	return strings.NewReplacer("{n}", strconv.Itoa(n), "{max}", strconv.Itoa(c.Max)).Replace(c.Message)
+}
+
+// startNudging sends the nudge message whenever the agent has been stable
+// for NudgeConfig.After with the reply to the latest user message
+// unanswered. The agent isn't nudged before the user's first message,
+// while it waits on a permission prompt, which the follow-up would answer,
+// while a human took over its terminal, or once a run limit was reached.
+func (s *Server) startNudging(ctx context.Context) {
+	if s.nudge.After <= 0 || s.nudge.Max <= 0 || s.nudge.Message == "" {
+		return
+	}
+
+	var idleSince time.Time
+	// idleTurn is the turn the agent went idle in, nudgeTurn the turn the
+	// latest nudge started, and nudges the number sent in a row.
+	idleTurn, nudgeTurn, nudges := -1, -1, 0
+	s.clock.TickerFunc(ctx, nudgeInterval, func() error {
+		s.mu.RLock()
+		latest, ok := s.latestMessage()
+		s.mu.RUnlock()
+		_, prompted := s.emitter.PendingPrompt()
+		_, draining := s.draining()
+		_, takenOver := s.takenOver()
+		// The first message of the conversation starts a turn of its own
+		// before the user sends any.
+		if !ok || latest.Role != st.ConversationRoleAgent || latest.TurnId == latest.Id || prompted || draining || takenOver ||
+			s.conversation.Status() != st.ConversationStatusStable || s.runLimitExceeded() != "" {
+			idleSince = time.Time{}
+			return nil
+		}
+		if latest.TurnId != nudgeTurn {
+			// The user answered since the latest nudge.
+			nudges = 0
+		}
+		if idleSince.IsZero() || latest.TurnId != idleTurn {
+			idleSince = s.clock.Now()
+			idleTurn = latest.TurnId
+		}
+		if nudges >= s.nudge.Max || s.clock.Since(idleSince) < s.nudge.After {
+			return nil
+		}
+
+		s.logger.Info("Agent idle, sending nudge", "idleFor", s.clock.Since(idleSince), "nudge", nudges+1)
+		s.mu.Lock()
+		err := s.conversation.Send(FormatMessage(s.agentType, s.nudge.message(nudges+1))...)
+		if err != nil {
+			s.mu.Unlock()
+			// The agent may have started working in the meantime; retry on
+			// the next tick.
+			s.logger.Warn("Failed to send nudge", "error", err)
+			return nil
+		}
+		nudges++
+		idleSince = time.Time{}
+		if sent, ok := s.latestMessage(); ok {
+			nudgeTurn = sent.TurnId
+		}
+		s.mu.Unlock()
+		s.metrics.Inc("agentapi_nudges_total", "Follow-up messages sent because the agent was idle for --nudge-after.",
+			"agent_type", string(s.agentType))
+		return nil
+	}, "nudge")
+}
@@ -0,0 +1,105 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/metrics"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// turnConversation is a conversation that records sent messages as user
+// messages, for the test to add the agent's replies.
+type turnConversation struct {
+	sentConversation
+	messages []st.ConversationMessage
+}
+
+func (c *turnConversation) Messages() []st.ConversationMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]st.ConversationMessage(nil), c.messages...)
+}
+
+func (c *turnConversation) add(role st.ConversationRole, message string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := len(c.messages)
+	turnId := id
+	if role == st.ConversationRoleAgent && id > 0 {
+		turnId = c.messages[id-1].TurnId
+	}
+	c.messages = append(c.messages, st.ConversationMessage{Id: id, Role: role, Message: message, TurnId: turnId})
+}
+
+func (c *turnConversation) Send(parts ...st.MessagePart) error {
+	if err := c.sentConversation.Send(parts...); err != nil {
+		return err
+	}
+	c.add(st.ConversationRoleUser, parts[0].String())
+	return nil
+}
+
+func TestNudge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	mClock := quartz.NewMock(t)
+	conversation := &turnConversation{}
+	s := &Server{
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		conversation: conversation,
+		agentType:    mf.AgentTypeCustom,
+		emitter:      NewEventEmitter(WithClock(mClock)),
+		clock:        mClock,
+		metrics:      metrics.New(),
+		nudge:        NudgeConfig{After: 5 * time.Second, Message: "continue ({n}/{max})", Max: 2},
+	}
+	s.startNudging(ctx)
+
+	tickFor := func(d time.Duration) {
+		t.Helper()
+		for range d / nudgeInterval {
+			_, w := mClock.AdvanceNext()
+			require.NoError(t, w.Wait(ctx))
+		}
+	}
+
+	// The agent isn't nudged before the user's first message.
+	conversation.add(st.ConversationRoleAgent, "Welcome")
+	tickFor(10 * time.Second)
+	assert.Empty(t, conversation.Sent())
+
+	conversation.add(st.ConversationRoleUser, "Fix the bug")
+	conversation.add(st.ConversationRoleAgent, "Should I run the tests?")
+	// The agent is idle from the first tick that sees its reply.
+	tickFor(5 * time.Second)
+	assert.Empty(t, conversation.Sent())
+	tickFor(time.Second)
+	assert.Equal(t, []string{"continue (1/2)"}, conversation.Sent())
+
+	// The agent isn't nudged while it works on the nudge.
+	tickFor(10 * time.Second)
+	assert.Len(t, conversation.Sent(), 1)
+
+	conversation.add(st.ConversationRoleAgent, "Tests pass. Anything else?")
+	tickFor(6 * time.Second)
+	assert.Equal(t, []string{"continue (1/2)", "continue (2/2)"}, conversation.Sent())
+
+	// The nudges stop at the limit until the user sends a message.
+	conversation.add(st.ConversationRoleAgent, "Done.")
+	tickFor(time.Minute)
+	assert.Len(t, conversation.Sent(), 2)
+
+	conversation.add(st.ConversationRoleUser, "Now update the docs")
+	conversation.add(st.ConversationRoleAgent, "Which docs?")
+	tickFor(6 * time.Second)
+	assert.Equal(t, "continue (1/2)", conversation.Sent()[2])
+}
@@ -0,0 +1,184 @@
+package httpapi
+
+import (
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/coder/agentapi/lib/metrics"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"golang.org/x/xerrors"
+)
+
+// OutputFilterAction is what happens to agent messages matching an output
+// filter pattern.
+type OutputFilterAction string
+
+const (
+	// OutputFilterFlag marks matching messages as filtered and leaves their
+	// content as is.
+	OutputFilterFlag OutputFilterAction = "flag"
+	// OutputFilterRedact also replaces the matches with outputFilterMask.
+	OutputFilterRedact OutputFilterAction = "redact"
+)
+
+var OutputFilterActionValues = []OutputFilterAction{
+	OutputFilterFlag,
+	OutputFilterRedact,
+}
+
+// OutputFilterConfig configures the filter applied to agent messages
+// before they are emitted to clients or saved to the state file. With the
+// redact action, the screen and the thoughts streamed to clients are
+// redacted too. The conversation itself, the raw screen and the terminal
+// keep the agent's original output.
+type OutputFilterConfig struct {
+	// Patterns are regular expressions, or the name of a preset prefixed
+	// with "preset:" (see outputFilterPresets). The filter is disabled when
+	// empty.
+	Patterns []string
+	Action   OutputFilterAction
+}
+
+const outputFilterMask = "[REDACTED]"
+
+// outputFilterPresets are patterns for common kinds of sensitive data.
+var outputFilterPresets = map[string][]string{
+	"credentials": {
+		`AKIA[0-9A-Z]{16}`,                            // AWS access key IDs
+		`gh[pousr]_[A-Za-z0-9]{36,}`,                  // GitHub tokens
+		`sk-(?:ant-)?[A-Za-z0-9_-]{20,}`,              // OpenAI and Anthropic API keys
+		`xox[abposr]-[A-Za-z0-9-]{10,}`,               // Slack tokens
+		`-----BEGIN [A-Z ]*PRIVATE KEY-----`,          // PEM private keys
+		`(?i)(?:password|passwd|secret)\s*[:=]\s*\S+`, // inline passwords
+	},
+	"email": {
+		`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	},
+}
+
+// outputFilter is a middleware flagging or redacting agent output that
+// matches any of its patterns.
+type outputFilter struct {
+	st.NoopMiddleware
+	patterns []*regexp.Regexp
+	action   OutputFilterAction
+	logger   *slog.Logger
+	metrics  *metrics.Registry
+
+	mu sync.Mutex
+	// audited holds the ids of messages already logged, so streamed
+	// updates of a message are only logged once.
+	audited map[int]bool
+}
+
+// newOutputFilter returns the output filter middleware, or nil if no
+// patterns are configured.
+func newOutputFilter(cfg OutputFilterConfig, logger *slog.Logger, metricsRegistry *metrics.Registry) (*outputFilter, error) {
+	if len(cfg.Patterns) == 0 {
+		return nil, nil
+	}
+	f := &outputFilter{
+		action:  cfg.Action,
+		logger:  logger,
+		metrics: metricsRegistry,
+		audited: make(map[int]bool),
+	}
+	if f.action == "" {
+		f.action = OutputFilterRedact
+	}
+	for _, pattern := range cfg.Patterns {
+		expanded := []string{pattern}
+		if name, ok := strings.CutPrefix(pattern, "preset:"); ok {
+			if expanded, ok = outputFilterPresets[name]; !ok {
+				return nil, xerrors.Errorf("unknown output filter preset %q", name)
+			}
+		}
+		for _, p := range expanded {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid output filter pattern %q: %w", p, err)
+			}
+			f.patterns = append(f.patterns, re)
+		}
+	}
+	return f, nil
+}
+
+// apply returns text, with the matches redacted if that's the action, and
+// the patterns that matched.
+func (f *outputFilter) apply(text string) (string, []string) {
+	var matched []string
+	for _, re := range f.patterns {
+		if !re.MatchString(text) {
+			continue
+		}
+		matched = append(matched, re.String())
+		if f.action == OutputFilterRedact {
+			text = re.ReplaceAllLiteralString(text, outputFilterMask)
+		}
+	}
+	return text, matched
+}
+
+// filterMessage applies the filter to the content and thought of an agent
+// message, and returns the patterns that matched.
+func (f *outputFilter) filterMessage(message st.ConversationMessage) (st.ConversationMessage, []string) {
+	if message.Role != st.ConversationRoleAgent {
+		return message, nil
+	}
+	var matched, thoughtMatched []string
+	message.Message, matched = f.apply(message.Message)
+	message.Thought, thoughtMatched = f.apply(message.Thought)
+	for _, pattern := range thoughtMatched {
+		if !slices.Contains(matched, pattern) {
+			matched = append(matched, pattern)
+		}
+	}
+	if len(matched) > 0 {
+		message.Filtered = true
+	}
+	return message, matched
+}
+
+func (f *outputFilter) OnAgentMessage(message st.ConversationMessage) st.ConversationMessage {
+	message, matched := f.filterMessage(message)
+	if len(matched) == 0 {
+		return message
+	}
+
+	f.mu.Lock()
+	audited := f.audited[message.Id]
+	f.audited[message.Id] = true
+	f.mu.Unlock()
+	if !audited {
+		// Never log the matched text itself.
+		f.logger.Warn("Agent message matched output filter", "audit", true, "messageId", message.Id,
+			"action", string(f.action), "patterns", matched)
+		f.metrics.Inc("agentapi_output_filtered_total", "Agent messages that matched an output filter pattern.",
+			"action", string(f.action))
+	}
+	return message
+}
+
+// OnAgentOutput redacts the screen and streamed thoughts. They are only
+// flagged through the messages they end up in.
+func (f *outputFilter) OnAgentOutput(text string) string {
+	if f.action != OutputFilterRedact {
+		return text
+	}
+	text, _ = f.apply(text)
+	return text
+}
+
+// filterState applies the filter to the messages saved to the state file.
+// They were audited when they were emitted.
+func (f *outputFilter) filterState(messages []st.ConversationMessage) []st.ConversationMessage {
+	result := make([]st.ConversationMessage, len(messages))
+	for i, message := range messages {
+		result[i], _ = f.filterMessage(message)
+	}
+	return result
+}
@@ -0,0 +1,33 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// pinMessage handles PATCH /messages/{id}/pin
+func (s *Server) pinMessage(ctx context.Context, input *MessagePinRequest) (*MessagePinResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.conversation.SetMessagePinned(input.Id, input.Body.Pinned); err != nil {
+		if errors.Is(err, st.ErrMessageNotFound) {
+			return nil, huma.Error404NotFound(fmt.Sprintf("message %d not found", input.Id))
+		}
+		return nil, huma.Error500InternalServerError("failed to pin message", err)
+	}
+	messages, _, err := st.ReadMessageRange(s.conversation, input.Id, input.Id+1)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("failed to read message", err)
+	}
+	if len(messages) == 0 {
+		return nil, huma.Error404NotFound(fmt.Sprintf("message %d not found", input.Id))
+	}
+	resp := &MessagePinResponse{}
+	resp.Body = s.convertMessage(messages[0])
+	return resp, nil
+}
@@ -0,0 +1,70 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pinnableConversation is a messagesConversation that supports pinning.
+type pinnableConversation struct {
+	messagesConversation
+}
+
+func (c *pinnableConversation) SetMessagePinned(id int, pinned bool) error {
+	if id < 0 || id >= len(c.messages) {
+		return st.ErrMessageNotFound
+	}
+	c.messages[id].Pinned = pinned
+	return nil
+}
+
+func TestPinMessage(t *testing.T) {
+	t.Parallel()
+
+	conversation := &pinnableConversation{messagesConversation{messages: []st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"},
+		{Id: 1, Role: st.ConversationRoleUser, Message: "Always run the linter"},
+	}}}
+	s := &Server{conversation: conversation, agentType: mf.AgentTypeCustom, tokenizer: mf.HeuristicTokenizer{}}
+	pin := func(id int, pinned bool) (*MessagePinResponse, error) {
+		input := &MessagePinRequest{Id: id}
+		input.Body.Pinned = pinned
+		return s.pinMessage(context.Background(), input)
+	}
+
+	resp, err := pin(1, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, resp.Body.Id)
+	assert.Equal(t, "Always run the linter", resp.Body.Content)
+	assert.True(t, resp.Body.Pinned)
+
+	resp, err = pin(1, false)
+	require.NoError(t, err)
+	assert.False(t, resp.Body.Pinned)
+
+	_, err = pin(2, true)
+	var statusErr huma.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusNotFound, statusErr.GetStatus())
+}
+
+func TestPinnedCompactInstructions(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, pinnedCompactInstructions([]st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"},
+	}))
+	assert.Equal(t, "Keep these pinned messages verbatim in the summary:\n\nUser: Always run the linter\n\nAssistant: The API is frozen",
+		pinnedCompactInstructions([]st.ConversationMessage{
+			{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"},
+			{Id: 1, Role: st.ConversationRoleUser, Message: "Always run the linter", Pinned: true},
+			{Id: 2, Role: st.ConversationRoleAgent, Message: "  The API is frozen\n", Pinned: true},
+		}))
+}
@@ -0,0 +1,47 @@
+package httpapi
+
+import (
+	"context"
+
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+// getPendingPrompt handles GET /pending-prompt
+func (s *Server) getPendingPrompt(ctx context.Context, input *struct{}) (*PendingPromptResponse, error) {
+	resp := &PendingPromptResponse{}
+	if prompt, ok := s.emitter.PendingPrompt(); ok {
+		resp.Body.Prompt = convertPrompt(prompt)
+	}
+	return resp, nil
+}
+
+// replyToPendingPrompt handles POST /pending-prompt/reply. The keystrokes
+// that choose the option are written to the terminal, like a raw message.
+func (s *Server) replyToPendingPrompt(ctx context.Context, input *PendingPromptReplyRequest) (*PendingPromptReplyResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkTakeover(); err != nil {
+		return nil, err
+	}
+	prompt, ok := s.emitter.PendingPrompt()
+	if !ok {
+		return nil, huma.Error409Conflict("the agent isn't waiting on a prompt")
+	}
+	reply, ok := prompt.Reply(input.Body.Option)
+	if !ok {
+		return nil, huma.Error400BadRequest("unknown option", &huma.ErrorDetail{
+			Location: "body.option",
+			Message:  "option is not one of the options of the pending prompt",
+			Value:    input.Body.Option,
+		})
+	}
+	if _, err := s.agentio.Write([]byte(reply)); err != nil {
+		return nil, xerrors.Errorf("failed to send reply: %w", err)
+	}
+
+	resp := &PendingPromptReplyResponse{}
+	resp.Body.Ok = true
+	return resp, nil
+}
@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/coder/agentapi/lib/metrics"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingAgentIO is an st.AgentIO that records what is written to it.
+type recordingAgentIO struct {
+	written []string
+}
+
+func (a *recordingAgentIO) Write(data []byte) (int, error) {
+	a.written = append(a.written, string(data))
+	return len(data), nil
+}
+func (a *recordingAgentIO) ReadScreen() string { return "" }
+
+const permissionScreen = `Do you want to run this command?
+
+❯ 1. Yes
+  2. Yes, and don't ask again
+  3. No`
+
+func TestPendingPrompt(t *testing.T) {
+	t.Parallel()
+
+	agentIO := &recordingAgentIO{}
+	emitter := NewEventEmitter(WithClock(quartz.NewMock(t)), WithPromptDetection(true))
+	s := &Server{
+		agentio:      agentIO,
+		agentType:    mf.AgentTypeClaude,
+		conversation: &sentConversation{},
+		emitter:      emitter,
+		metrics:      metrics.New(),
+	}
+	_, ch, _ := emitter.Subscribe()
+
+	_, err := s.replyToPendingPrompt(context.Background(), &PendingPromptReplyRequest{})
+	var statusErr huma.StatusError
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusConflict, statusErr.GetStatus())
+
+	// The prompt is only pending once the agent is stable.
+	emitter.EmitScreen(permissionScreen)
+	<-ch
+	_, ok := emitter.PendingPrompt()
+	assert.False(t, ok)
+	emitter.EmitStatus(st.ConversationStatusStable)
+	<-ch
+	event := <-ch
+	require.Equal(t, EventTypePendingPrompt, event.Type)
+	body, ok := event.Payload.(PendingPromptBody)
+	require.True(t, ok)
+	require.NotNil(t, body.Prompt)
+	assert.Equal(t, "Do you want to run this command?", body.Prompt.Question)
+
+	resp, err := s.getPendingPrompt(context.Background(), &struct{}{})
+	require.NoError(t, err)
+	assert.Equal(t, body.Prompt, resp.Body.Prompt)
+
+	_, _, state := emitter.Subscribe()
+	assert.Contains(t, state, event)
+
+	req := &PendingPromptReplyRequest{}
+	req.Body.Option = "4"
+	_, err = s.replyToPendingPrompt(context.Background(), req)
+	require.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusBadRequest, statusErr.GetStatus())
+
+	req.Body.Option = "2"
+	_, err = s.replyToPendingPrompt(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"\x1b[B\r"}, agentIO.written)
+
+	// The prompt is cleared once it's gone from the screen.
+	emitter.EmitScreen("Running the command…")
+	<-ch
+	event = <-ch
+	assert.Equal(t, Event{Type: EventTypePendingPrompt, Payload: PendingPromptBody{}}, event)
+	resp, err = s.getPendingPrompt(context.Background(), &struct{}{})
+	require.NoError(t, err)
+	assert.Nil(t, resp.Body.Prompt)
+}
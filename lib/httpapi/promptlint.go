@@ -0,0 +1,111 @@
+package httpapi
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+)
+
+// PromptViolation is a problem a PromptLintRule found in a user message.
+type PromptViolation struct {
+	// Rule names the rule, e.g. "max-length".
+	Rule string
+	// Message describes the problem.
+	Message string
+	// Value is the offending part of the message, if any.
+	Value string
+}
+
+// PromptLintRule checks a user message before it reaches the agent.
+type PromptLintRule func(prompt string) []PromptViolation
+
+// PromptLintError is returned by the prompt linter middleware when a user
+// message violates its rules.
+type PromptLintError struct {
+	Violations []PromptViolation
+}
+
+func (e *PromptLintError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+	return "prompt lint failed: " + strings.Join(messages, "; ")
+}
+
+// MaxPromptLength flags user messages longer than max characters.
+func MaxPromptLength(max int) PromptLintRule {
+	return func(prompt string) []PromptViolation {
+		if n := utf8.RuneCountInString(prompt); n > max {
+			return []PromptViolation{{
+				Rule:    "max-length",
+				Message: fmt.Sprintf("message is %d characters long, more than the maximum of %d", n, max),
+			}}
+		}
+		return nil
+	}
+}
+
+// ForbiddenPlaceholders flags user messages containing any of the
+// placeholders, such as "TODO" or "{{", left in by a broken template.
+func ForbiddenPlaceholders(placeholders ...string) PromptLintRule {
+	return func(prompt string) []PromptViolation {
+		var violations []PromptViolation
+		for _, placeholder := range placeholders {
+			if strings.Contains(prompt, placeholder) {
+				violations = append(violations, PromptViolation{
+					Rule:    "forbidden-placeholder",
+					Message: fmt.Sprintf("message contains the forbidden placeholder %q", placeholder),
+					Value:   placeholder,
+				})
+			}
+		}
+		return violations
+	}
+}
+
+// templateVarPattern matches the variables of Go and Jinja templates, e.g.
+// {{ .Issue }}, and of shell-style templates, e.g. ${ISSUE}.
+var templateVarPattern = regexp.MustCompile(`\{\{-?\s*[.$]?[A-Za-z_][\w.]*\s*-?\}\}|\$\{[A-Za-z_]\w*\}`)
+
+// UnresolvedTemplateVars flags user messages containing template variables
+// that weren't substituted.
+func UnresolvedTemplateVars() PromptLintRule {
+	return func(prompt string) []PromptViolation {
+		var violations []PromptViolation
+		for _, match := range templateVarPattern.FindAllString(prompt, -1) {
+			violations = append(violations, PromptViolation{
+				Rule:    "unresolved-template-var",
+				Message: fmt.Sprintf("message contains the unresolved template variable %s", match),
+				Value:   match,
+			})
+		}
+		return violations
+	}
+}
+
+// promptLinter rejects user messages violating any of its rules.
+type promptLinter struct {
+	st.NoopMiddleware
+	rules []PromptLintRule
+}
+
+// NewPromptLinter returns a middleware rejecting user messages that violate
+// any of the rules with a *PromptLintError listing every violation.
+func NewPromptLinter(rules ...PromptLintRule) st.ConversationMiddleware {
+	return &promptLinter{rules: rules}
+}
+
+func (l *promptLinter) OnUserMessage(message string) (string, error) {
+	var violations []PromptViolation
+	for _, rule := range l.rules {
+		violations = append(violations, rule(message)...)
+	}
+	if len(violations) > 0 {
+		return "", &PromptLintError{Violations: violations}
+	}
+	return message, nil
+}
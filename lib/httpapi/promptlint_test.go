@@ -0,0 +1,49 @@
+package httpapi
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromptLinter(t *testing.T) {
+	t.Parallel()
+
+	l := NewPromptLinter(MaxPromptLength(40), ForbiddenPlaceholders("{{", "TODO"), UnresolvedTemplateVars())
+
+	message, err := l.OnUserMessage("Fix the bug in ${file:-main.go}")
+	require.NoError(t, err)
+	assert.Equal(t, "Fix the bug in ${file:-main.go}", message)
+
+	_, err = l.OnUserMessage("Fix {{ .Issue }} in ${FILE}, see TODO")
+	var lintErr *PromptLintError
+	require.ErrorAs(t, err, &lintErr)
+	assert.Equal(t, []PromptViolation{
+		{Rule: "forbidden-placeholder", Message: `message contains the forbidden placeholder "{{"`, Value: "{{"},
+		{Rule: "forbidden-placeholder", Message: `message contains the forbidden placeholder "TODO"`, Value: "TODO"},
+		{Rule: "unresolved-template-var", Message: "message contains the unresolved template variable {{ .Issue }}", Value: "{{ .Issue }}"},
+		{Rule: "unresolved-template-var", Message: "message contains the unresolved template variable ${FILE}", Value: "${FILE}"},
+	}, lintErr.Violations)
+
+	_, err = l.OnUserMessage("Refactor the parser and the lexer, then run the tests")
+	require.ErrorAs(t, err, &lintErr)
+	require.Len(t, lintErr.Violations, 1)
+	assert.Equal(t, "max-length", lintErr.Violations[0].Rule)
+
+	// Every violation is reported in the 422 response.
+	err = sendError("message", "body.content", &PromptLintError{Violations: []PromptViolation{
+		{Rule: "max-length", Message: "too long"},
+		{Rule: "unresolved-template-var", Message: "unresolved", Value: "${FILE}"},
+	}})
+	var model *huma.ErrorModel
+	require.ErrorAs(t, err, &model)
+	assert.Equal(t, http.StatusUnprocessableEntity, model.Status)
+	require.Len(t, model.Errors, 2)
+	assert.Equal(t, "max-length: too long", model.Errors[0].Message)
+	assert.Nil(t, model.Errors[0].Value)
+	assert.Equal(t, "body.content", model.Errors[1].Location)
+	assert.Equal(t, "${FILE}", model.Errors[1].Value)
+}
@@ -0,0 +1,197 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coder/agentapi/lib/metrics"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"golang.org/x/xerrors"
+)
+
+// PushEvent is an event that triggers a push notification.
+type PushEvent string
+
+const (
+	// PushEventTurnComplete fires when the agent becomes stable after
+	// responding to a message. Agents waiting for input, including
+	// permission prompts, are stable.
+	PushEventTurnComplete PushEvent = "turn_complete"
+	PushEventError        PushEvent = "error"
+)
+
+var PushEventValues = []PushEvent{
+	PushEventTurnComplete,
+	PushEventError,
+}
+
+// PushConfig configures phone notifications through ntfy and Pushover.
+type PushConfig struct {
+	// NtfyTopic is a topic on ntfy.sh, or the full URL of a topic on
+	// another ntfy server. ntfy is disabled when empty.
+	NtfyTopic string
+	// NtfyToken is sent as a bearer token if set.
+	NtfyToken string
+	// PushoverToken and PushoverUser are the Pushover application token and
+	// user key. Pushover is disabled unless both are set.
+	PushoverToken string
+	PushoverUser  string
+	// Events selects what triggers a notification.
+	Events []PushEvent
+}
+
+const (
+	ntfyBaseURL    = "https://ntfy.sh/"
+	pushoverAPIURL = "https://api.pushover.net/1/messages.json"
+	// pushMaxMessageLength keeps messages within Pushover's 1024 character
+	// limit.
+	pushMaxMessageLength = 1000
+	// pushTimeout bounds each request to a push service.
+	pushTimeout = 10 * time.Second
+)
+
+// pushNotification is a notification sent to every configured service.
+type pushNotification struct {
+	Title   string
+	Message string
+	// Urgent notifications get a higher priority.
+	Urgent bool
+}
+
+type pushPublisher func(ctx context.Context, n pushNotification) error
+
+// newPushNotifier returns a sink publishing cfg.Events to the configured
+// services, or nil if none is configured.
+func newPushNotifier(cfg PushConfig, agentType string, logger *slog.Logger, metricsRegistry *metrics.Registry) transcriptSink {
+	var publishers []pushPublisher
+	if cfg.NtfyTopic != "" {
+		topicURL := cfg.NtfyTopic
+		if !strings.Contains(topicURL, "://") {
+			topicURL = ntfyBaseURL + topicURL
+		}
+		publishers = append(publishers, ntfyPublisher(topicURL, cfg.NtfyToken))
+	}
+	if cfg.PushoverToken != "" && cfg.PushoverUser != "" {
+		publishers = append(publishers, pushoverPublisher(pushoverAPIURL, cfg.PushoverToken, cfg.PushoverUser))
+	}
+	if len(publishers) == 0 || len(cfg.Events) == 0 {
+		return nil
+	}
+
+	title := "agentapi (" + agentType + ")"
+	// Only touched by the exporter goroutine.
+	turnPending := false
+	lastAgentMessage := ""
+	send := func(records []transcriptRecord) error {
+		var errs []error
+		for _, record := range records {
+			var n pushNotification
+			switch {
+			case record.Type == transcriptRecordMessage && record.Role == st.ConversationRoleUser:
+				turnPending = true
+				continue
+			case record.Type == transcriptRecordMessage:
+				lastAgentMessage = record.Content
+				continue
+			case record.Type == transcriptRecordStatus && record.Status == AgentStatusStable && turnPending:
+				// Ignore the agent becoming ready on startup.
+				turnPending = false
+				if !slices.Contains(cfg.Events, PushEventTurnComplete) {
+					continue
+				}
+				n = pushNotification{Title: title + ": turn complete", Message: lastAgentMessage}
+			case record.Type == transcriptRecordError && record.Level == st.ErrorLevelError:
+				if !slices.Contains(cfg.Events, PushEventError) {
+					continue
+				}
+				n = pushNotification{Title: title + ": error", Message: record.Content, Urgent: true}
+			default:
+				continue
+			}
+			n.Message = truncateRunes(strings.TrimSpace(n.Message), pushMaxMessageLength)
+			if n.Message == "" {
+				n.Message = "(empty message)"
+			}
+			for _, publish := range publishers {
+				if err := publish(context.Background(), n); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+		if len(errs) > 0 {
+			return xerrors.Errorf("failed to send push notifications: %w", errs[0])
+		}
+		return nil
+	}
+	return newLogExporter("push", send, nil, logger, metricsRegistry)
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// ntfyPublisher publishes to an ntfy topic, see https://docs.ntfy.sh/publish/.
+func ntfyPublisher(topicURL string, token string) pushPublisher {
+	return func(ctx context.Context, n pushNotification) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, topicURL, strings.NewReader(n.Message))
+		if err != nil {
+			return xerrors.Errorf("failed to create ntfy request: %w", err)
+		}
+		req.Header.Set("Title", n.Title)
+		if n.Urgent {
+			req.Header.Set("Priority", "high")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return doPushRequest("ntfy", req)
+	}
+}
+
+// pushoverPublisher publishes through the Pushover API, see
+// https://pushover.net/api.
+func pushoverPublisher(apiURL string, token string, user string) pushPublisher {
+	return func(ctx context.Context, n pushNotification) error {
+		form := url.Values{
+			"token":   {token},
+			"user":    {user},
+			"title":   {n.Title},
+			"message": {n.Message},
+		}
+		if n.Urgent {
+			form.Set("priority", "1")
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return xerrors.Errorf("failed to create Pushover request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return doPushRequest("Pushover", req)
+	}
+}
+
+func doPushRequest(service string, req *http.Request) error {
+	client := &http.Client{Timeout: pushTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("failed to send %s notification: %w", service, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return xerrors.Errorf("%s returned %s: %s", service, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
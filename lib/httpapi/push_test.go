@@ -0,0 +1,89 @@
+package httpapi
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushNotifier(t *testing.T) {
+	t.Parallel()
+
+	type notification struct {
+		title    string
+		message  string
+		priority string
+	}
+	var mu sync.Mutex
+	var received []notification
+	ntfy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/my-agents", r.URL.Path)
+		assert.Equal(t, "Bearer tk_123", r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, notification{r.Header.Get("Title"), string(body), r.Header.Get("Priority")})
+		mu.Unlock()
+	}))
+	t.Cleanup(ntfy.Close)
+
+	sink := newPushNotifier(PushConfig{
+		NtfyTopic: ntfy.URL + "/my-agents",
+		NtfyToken: "tk_123",
+		Events:    []PushEvent{PushEventTurnComplete, PushEventError},
+	}, "claude", slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+	require.NotNil(t, sink)
+	emitter := NewEventEmitter(withTranscriptSinks(sink))
+
+	// Becoming ready on startup is not a completed turn.
+	emitter.EmitMessages([]st.ConversationMessage{{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"}})
+	emitter.EmitStatus(st.ConversationStatusStable)
+
+	emitter.EmitStatus(st.ConversationStatusChanging)
+	emitter.EmitMessages([]st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"},
+		{Id: 1, Role: st.ConversationRoleUser, Message: "Fix the bug"},
+		{Id: 2, Role: st.ConversationRoleAgent, Message: "Fixed it."},
+	})
+	emitter.EmitStatus(st.ConversationStatusStable)
+	emitter.EmitError("agent crashed", st.ErrorLevelError)
+	emitter.EmitError("slow screen", st.ErrorLevelWarning)
+	require.NoError(t, sink.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []notification{
+		{"agentapi (claude): turn complete", "Fixed it.", ""},
+		{"agentapi (claude): error", "agent crashed", "high"},
+	}, received)
+}
+
+func TestPushNotifier_Disabled(t *testing.T) {
+	t.Parallel()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	assert.Nil(t, newPushNotifier(PushConfig{Events: PushEventValues}, "claude", logger, nil))
+	assert.Nil(t, newPushNotifier(PushConfig{NtfyTopic: "topic"}, "claude", logger, nil))
+	assert.Nil(t, newPushNotifier(PushConfig{PushoverToken: "token", Events: PushEventValues}, "claude", logger, nil))
+}
+
+func TestPushoverPublisher(t *testing.T) {
+	t.Parallel()
+	pushover := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "app-token", r.PostForm.Get("token"))
+		assert.Equal(t, "user-key", r.PostForm.Get("user"))
+		assert.Equal(t, "title", r.PostForm.Get("title"))
+		assert.Equal(t, "message", r.PostForm.Get("message"))
+		assert.Equal(t, "1", r.PostForm.Get("priority"))
+	}))
+	t.Cleanup(pushover.Close)
+
+	publish := pushoverPublisher(pushover.URL, "app-token", "user-key")
+	require.NoError(t, publish(t.Context(), pushNotification{Title: "title", Message: "message", Urgent: true}))
+}
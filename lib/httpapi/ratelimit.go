@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// messageRateWindow is the window --max-messages-per-minute applies to.
+const messageRateWindow = time.Minute
+
+// MessageRateLimiter limits how many messages are sent to the agent in a
+// sliding window. The servers of sessions share the limiter of the server
+// they are started from, so that the limit applies across sessions. A nil
+// limiter allows everything.
+type MessageRateLimiter struct {
+	clock quartz.Clock
+	limit int
+
+	mu   sync.Mutex
+	sent []time.Time // send times within the window, oldest first
+}
+
+// NewMessageRateLimiter returns a limiter allowing limit messages per
+// minute, or nil if limit is 0. A nil clock is the real one.
+func NewMessageRateLimiter(clock quartz.Clock, limit int) *MessageRateLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	if clock == nil {
+		clock = quartz.NewReal()
+	}
+	return &MessageRateLimiter{clock: clock, limit: limit}
+}
+
+// allow reports whether another message may be sent now. Messages only
+// count against the limit once they're recorded with record.
+func (l *MessageRateLimiter) allow() bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	expired := 0
+	for expired < len(l.sent) && now.Sub(l.sent[expired]) >= messageRateWindow {
+		expired++
+	}
+	l.sent = l.sent[expired:]
+	return len(l.sent) < l.limit
+}
+
+// record counts a message sent now against the limit.
+func (l *MessageRateLimiter) record() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sent = append(l.sent, l.clock.Now())
+}
+
+// checkMessageRate returns a 429 error if sending another message would
+// exceed --max-messages-per-minute. The message must be recorded with
+// s.messageLimiter.record once it's sent.
+func (s *Server) checkMessageRate(kind string) error {
+	if s.messageLimiter.allow() {
+		return nil
+	}
+	s.metrics.Inc("agentapi_rate_limited_total", "Requests rejected because they exceeded --max-messages-per-minute.",
+		"kind", kind)
+	return huma.Error429TooManyRequests(fmt.Sprintf("more than %d messages per minute", s.messageLimiter.limit))
+}
@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+		limiter := NewMessageRateLimiter(quartz.NewMock(t), 0)
+		for range 100 {
+			assert.True(t, limiter.allow())
+			limiter.record()
+		}
+	})
+
+	t.Run("sliding window", func(t *testing.T) {
+		t.Parallel()
+		clock := quartz.NewMock(t)
+		limiter := NewMessageRateLimiter(clock, 2)
+		send := func() bool {
+			if !limiter.allow() {
+				return false
+			}
+			limiter.record()
+			return true
+		}
+
+		assert.True(t, send())
+		clock.Advance(30 * time.Second)
+		assert.True(t, send())
+		assert.False(t, send())
+
+		// The first message leaves the window.
+		clock.Advance(30 * time.Second)
+		assert.True(t, send())
+		assert.False(t, send())
+
+		clock.Advance(time.Minute)
+		assert.True(t, send())
+		assert.True(t, send())
+	})
+
+	t.Run("only recorded messages count", func(t *testing.T) {
+		t.Parallel()
+		limiter := NewMessageRateLimiter(quartz.NewMock(t), 1)
+		// Messages rejected after the check, e.g. by a guardrail, aren't
+		// recorded.
+		for range 3 {
+			assert.True(t, limiter.allow())
+		}
+		limiter.record()
+		assert.False(t, limiter.allow())
+	})
+}
@@ -0,0 +1,110 @@
+package httpapi
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/coder/agentapi/lib/metrics"
+	"github.com/coder/quartz"
+	"github.com/go-chi/chi/v5"
+)
+
+// DefaultSlowRequestThreshold is how long a request may take to respond
+// before it's logged as slow.
+const DefaultSlowRequestThreshold = 5 * time.Second
+
+// RequestLogConfig configures the logging of API requests.
+type RequestLogConfig struct {
+	// All logs every request, not only slow ones.
+	All bool
+	// SlowThreshold is how long a request may take until the response
+	// starts before it's logged as slow. Event streams only count until
+	// their headers are sent. 0 disables slow request detection.
+	SlowThreshold time.Duration
+}
+
+// requestLogWriter records the status of a response and when it started.
+type requestLogWriter struct {
+	http.ResponseWriter
+	clock     quartz.Clock
+	status    int
+	firstByte time.Time
+}
+
+func (w *requestLogWriter) WriteHeader(status int) {
+	if w.firstByte.IsZero() {
+		w.status = status
+		w.firstByte = w.clock.Now()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *requestLogWriter) Write(p []byte) (int, error) {
+	if w.firstByte.IsZero() {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *requestLogWriter) Flush() {
+	if w.firstByte.IsZero() {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController and event streams reach the
+// underlying writer.
+func (w *requestLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// requestLogMiddleware logs the method, path, status and duration of
+// requests, and counts them by route and status. Requests whose response
+// takes longer than the slow threshold to start are logged as warnings,
+// to find clients that hang or hammer the server.
+func requestLogMiddleware(cfg RequestLogConfig, logger *slog.Logger, clock quartz.Clock, metricsRegistry *metrics.Registry) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := clock.Now()
+			lw := &requestLogWriter{ResponseWriter: w, clock: clock}
+			next.ServeHTTP(lw, r)
+			end := clock.Now()
+			if lw.firstByte.IsZero() {
+				lw.status = http.StatusOK
+				lw.firstByte = end
+			}
+
+			// Routes rather than paths keep the number of label values
+			// bounded.
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			metricsRegistry.Inc("agentapi_http_requests_total", "API requests, by method, route and status.",
+				"method", r.Method, "route", route, "status", strconv.Itoa(lw.status))
+
+			attrs := []any{
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", lw.status,
+				"duration", end.Sub(start),
+				"remoteAddr", r.RemoteAddr,
+				"userAgent", r.UserAgent(),
+			}
+			if cfg.SlowThreshold > 0 && lw.firstByte.Sub(start) >= cfg.SlowThreshold {
+				metricsRegistry.Inc("agentapi_http_slow_requests_total", "API requests that took longer than the slow request threshold to respond, by method and route.",
+					"method", r.Method, "route", route)
+				logger.Warn("Slow request", append(attrs, "timeToFirstByte", lw.firstByte.Sub(start))...)
+				return
+			}
+			if cfg.All {
+				logger.Info("Request", attrs...)
+			}
+		})
+	}
+}
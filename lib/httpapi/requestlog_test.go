@@ -0,0 +1,74 @@
+package httpapi
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/metrics"
+	"github.com/coder/quartz"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLogMiddleware(t *testing.T) {
+	t.Parallel()
+
+	mClock := quartz.NewMock(t)
+	var logs strings.Builder
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	registry := metrics.New()
+
+	newRouter := func(cfg RequestLogConfig) http.Handler {
+		router := chi.NewMux()
+		router.Use(requestLogMiddleware(cfg, logger, mClock, registry))
+		router.Get("/messages/{id}", func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("slow") != "" {
+				mClock.Advance(2 * time.Second).MustWait(r.Context())
+			}
+			_, _ = w.Write([]byte("ok"))
+		})
+		router.Get("/events", func(w http.ResponseWriter, r *http.Request) {
+			// Streams only count until their headers are sent.
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.(http.Flusher).Flush()
+			mClock.Advance(time.Minute).MustWait(r.Context())
+		})
+		return router
+	}
+	get := func(router http.Handler, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	// Only slow requests are logged by default.
+	router := newRouter(RequestLogConfig{SlowThreshold: time.Second})
+	assert.Equal(t, "ok", get(router, "/messages/1").Body.String())
+	assert.Empty(t, logs.String())
+	get(router, "/messages/2?slow=1")
+	assert.Contains(t, logs.String(), `msg="Slow request"`)
+	assert.Contains(t, logs.String(), "path=/messages/2")
+	assert.Contains(t, logs.String(), "timeToFirstByte=2s")
+	rec := get(router, "/events")
+	assert.True(t, rec.Flushed)
+	assert.Equal(t, 1, strings.Count(logs.String(), "Slow request"))
+	get(router, "/missing")
+
+	assert.Equal(t, 2.0, registry.Value("agentapi_http_requests_total", "method", "GET", "route", "/messages/{id}", "status", "200"))
+	assert.Equal(t, 1.0, registry.Value("agentapi_http_requests_total", "method", "GET", "route", "unmatched", "status", "404"))
+	assert.Equal(t, 1.0, registry.Value("agentapi_http_slow_requests_total", "method", "GET", "route", "/messages/{id}"))
+
+	logs.Reset()
+	router = newRouter(RequestLogConfig{All: true})
+	get(router, "/messages/3?slow=1")
+	require.Contains(t, logs.String(), `msg=Request`)
+	assert.Contains(t, logs.String(), "status=200")
+	assert.Contains(t, logs.String(), "duration=2s")
+	assert.Equal(t, 1.0, registry.Value("agentapi_http_slow_requests_total", "method", "GET", "route", "/messages/{id}"))
+}
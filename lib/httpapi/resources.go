@@ -0,0 +1,49 @@
+package httpapi
+
+import (
+	"net/http"
+)
+
+// updateResourceMetrics copies the usage of the agent's cgroup into the
+// metrics. The cgroup's counters are cumulative, so the metrics are
+// advanced by the difference.
+func (s *Server) updateResourceMetrics() {
+	if s.resourceStats == nil {
+		return
+	}
+	s.resourceMu.Lock()
+	defer s.resourceMu.Unlock()
+	stats, err := s.resourceStats()
+	if err != nil {
+		s.logger.Warn("Failed to read the agent's resource usage", "error", err)
+		return
+	}
+	s.metrics.Set("agentapi_agent_memory_bytes", "Memory used by the agent and its subprocesses.",
+		float64(stats.MemoryBytes))
+	counters := []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{"agentapi_agent_cpu_throttled_periods_total", "Scheduling periods in which the agent used up --cpu-limit.", float64(stats.CPUThrottledPeriods)},
+		{"agentapi_agent_cpu_throttled_seconds_total", "Time the agent was throttled because of --cpu-limit.", stats.CPUThrottled.Seconds()},
+		{"agentapi_agent_memory_limit_hits_total", "Times the agent's memory use reached --memory-limit.", float64(stats.MemoryMaxEvents)},
+		{"agentapi_agent_oom_kills_total", "Agent processes killed because they exceeded --memory-limit.", float64(stats.OOMKills)},
+	}
+	for _, c := range counters {
+		// Adding 0 reports the counter before it first increases.
+		if delta := c.value - s.metrics.Value(c.name); delta >= 0 {
+			s.metrics.Add(c.name, c.help, delta)
+		}
+	}
+}
+
+// metricsHandler serves the metrics, with the agent's resource usage read
+// at scrape time.
+func (s *Server) metricsHandler() http.Handler {
+	handler := s.metrics.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.updateResourceMetrics()
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/metrics"
+	"github.com/coder/agentapi/lib/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceMetrics(t *testing.T) {
+	t.Parallel()
+
+	stats := transport.ResourceStats{MemoryBytes: 100 << 20}
+	s := &Server{
+		metrics: metrics.New(),
+		resourceStats: func() (transport.ResourceStats, error) {
+			return stats, nil
+		},
+	}
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		s.metricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec.Body.String()
+	}
+
+	body := scrape()
+	assert.Contains(t, body, "agentapi_agent_memory_bytes 1.048576e+08\n")
+	assert.Contains(t, body, "agentapi_agent_cpu_throttled_periods_total 0\n")
+
+	stats = transport.ResourceStats{
+		MemoryBytes:         200 << 20,
+		CPUThrottledPeriods: 12,
+		CPUThrottled:        2500 * time.Millisecond,
+		OOMKills:            1,
+	}
+	scrape()
+	body = scrape()
+	assert.Contains(t, body, "agentapi_agent_memory_bytes 2.097152e+08\n")
+	assert.Contains(t, body, "agentapi_agent_cpu_throttled_periods_total 12\n")
+	assert.Contains(t, body, "agentapi_agent_cpu_throttled_seconds_total 2.5\n")
+	assert.Contains(t, body, "agentapi_agent_oom_kills_total 1\n")
+}
@@ -0,0 +1,121 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// runLimitInterval is how often the run limits are checked.
+const runLimitInterval = time.Second
+
+// runLimitGrace is how long the agent may keep working once a run limit is
+// reached, on its current turn or the wrap-up prompt, before the server
+// stops waiting for it.
+const runLimitGrace = 10 * time.Minute
+
+// RunLimits bound an unattended run. Once a limit is reached, user messages
+// are rejected, the agent is sent the wrap-up prompt, and the channel
+// returned by Server.RunLimitReached is closed when the agent is done.
+type RunLimits struct {
+	// MaxTurns is how many turns the agent may complete. 0 disables the
+	// limit.
+	MaxTurns int
+	// MaxDuration is how long the run may last. 0 disables the limit.
+	MaxDuration time.Duration
+	// WrapUpPrompt is sent to the agent once a limit is reached, e.g. to
+	// have it summarize its progress. Empty sends nothing.
+	WrapUpPrompt string
+}
+
+// runLimitExceeded returns which run limit was reached, or "" if none was.
+func (s *Server) runLimitExceeded() string {
+	if s.runLimits.MaxDuration > 0 && s.clock.Since(s.runStart) >= s.runLimits.MaxDuration {
+		return fmt.Sprintf("the run has lasted %s", s.runLimits.MaxDuration)
+	}
+	if s.runLimits.MaxTurns > 0 {
+		if completed, _, _, _ := s.emitter.analytics(); completed >= s.runLimits.MaxTurns {
+			return fmt.Sprintf("the agent has completed %d turns", completed)
+		}
+	}
+	return ""
+}
+
+// checkRunLimits rejects user messages once a run limit was reached.
+func (s *Server) checkRunLimits() error {
+	if reason := s.runLimitExceeded(); reason != "" {
+		return huma.Error409Conflict("run limit reached: " + reason)
+	}
+	return nil
+}
+
+// RunLimitReached returns a channel closed once a run limit was reached and
+// the agent has answered the wrap-up prompt, if any.
+func (s *Server) RunLimitReached() <-chan struct{} {
+	return s.runLimitReached
+}
+
+// startRunLimits waits for a run limit to be reached, then for the agent to
+// finish its turn and answer the wrap-up prompt, and closes the channel
+// returned by RunLimitReached.
+func (s *Server) startRunLimits(ctx context.Context) {
+	if s.runLimits.MaxTurns <= 0 && s.runLimits.MaxDuration <= 0 {
+		return
+	}
+
+	var reachedAt time.Time
+	wrapUpSent, wrapUpTurn, done := false, 0, false
+	s.clock.TickerFunc(ctx, runLimitInterval, func() error {
+		if done {
+			return nil
+		}
+		if reachedAt.IsZero() {
+			reason := s.runLimitExceeded()
+			if reason == "" {
+				return nil
+			}
+			s.logger.Info("Run limit reached", "reason", reason)
+			reachedAt = s.clock.Now()
+		}
+
+		_, takenOver := s.takenOver()
+		switch {
+		case s.clock.Since(reachedAt) >= runLimitGrace:
+			s.logger.Warn("Agent still busy after the run limit was reached, stopping", "grace", runLimitGrace)
+		case s.conversation.Status() != st.ConversationStatusStable:
+			return nil
+		case takenOver && s.runLimits.WrapUpPrompt != "" && !wrapUpSent:
+			// The wrap-up prompt waits for the human to release the
+			// terminal, up to the grace period.
+			return nil
+		case s.runLimits.WrapUpPrompt != "" && !wrapUpSent:
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if err := s.conversation.Send(FormatMessage(s.agentType, s.runLimits.WrapUpPrompt)...); err != nil {
+				// The agent may have started working in the meantime; retry
+				// on the next tick.
+				s.logger.Warn("Failed to send wrap-up prompt", "error", err)
+				return nil
+			}
+			wrapUpSent = true
+			if msg, ok := s.latestMessage(); ok {
+				wrapUpTurn = msg.TurnId
+			}
+			return nil
+		case wrapUpSent:
+			s.mu.RLock()
+			latest, ok := s.latestMessage()
+			s.mu.RUnlock()
+			if !ok || latest.Role != st.ConversationRoleAgent || latest.TurnId != wrapUpTurn {
+				return nil
+			}
+		}
+
+		done = true
+		close(s.runLimitReached)
+		return nil
+	}, "runLimits")
+}
@@ -0,0 +1,111 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/metrics"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRunLimitsServer(t *testing.T, limits RunLimits) (*Server, *turnConversation, *quartz.Mock) {
+	t.Helper()
+	mClock := quartz.NewMock(t)
+	conversation := &turnConversation{}
+	conversation.add(st.ConversationRoleAgent, "Welcome")
+	s := &Server{
+		logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+		conversation:    conversation,
+		agentType:       mf.AgentTypeCustom,
+		emitter:         NewEventEmitter(WithClock(mClock)),
+		clock:           mClock,
+		metrics:         metrics.New(),
+		runLimits:       limits,
+		runStart:        mClock.Now(),
+		runLimitReached: make(chan struct{}),
+	}
+	return s, conversation, mClock
+}
+
+func requireRunLimitReached(t *testing.T, s *Server, reached bool) {
+	t.Helper()
+	select {
+	case <-s.RunLimitReached():
+		require.True(t, reached, "run limit reached")
+	default:
+		require.False(t, reached, "run limit not reached")
+	}
+}
+
+func TestRunLimitsMaxTurns(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	s, conversation, mClock := newRunLimitsServer(t, RunLimits{MaxTurns: 1, WrapUpPrompt: "Summarize your progress"})
+	s.startRunLimits(ctx)
+	tick := func() {
+		t.Helper()
+		_, w := mClock.AdvanceNext()
+		require.NoError(t, w.Wait(ctx))
+	}
+
+	conversation.add(st.ConversationRoleUser, "Fix the bug")
+	s.emitter.EmitMessages(conversation.Messages())
+	s.emitter.EmitStatus(st.ConversationStatusChanging)
+	tick()
+	require.NoError(t, s.checkRunLimits())
+
+	// The limit is reached once the agent completes the turn.
+	conversation.add(st.ConversationRoleAgent, "Fixed")
+	s.emitter.EmitStatus(st.ConversationStatusStable)
+	var statusErr huma.StatusError
+	require.True(t, errors.As(s.checkRunLimits(), &statusErr))
+	assert.Equal(t, http.StatusConflict, statusErr.GetStatus())
+
+	tick()
+	assert.Equal(t, []string{"Summarize your progress"}, conversation.Sent())
+	requireRunLimitReached(t, s, false)
+
+	// The server waits for the agent to answer the wrap-up prompt.
+	tick()
+	requireRunLimitReached(t, s, false)
+	conversation.add(st.ConversationRoleAgent, "I fixed the bug")
+	tick()
+	requireRunLimitReached(t, s, true)
+	assert.Len(t, conversation.Sent(), 1)
+}
+
+func TestRunLimitsMaxDuration(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	s, conversation, mClock := newRunLimitsServer(t, RunLimits{MaxDuration: time.Minute})
+	s.startRunLimits(ctx)
+
+	tickFor := func(d time.Duration) {
+		t.Helper()
+		for range d / runLimitInterval {
+			_, w := mClock.AdvanceNext()
+			require.NoError(t, w.Wait(ctx))
+		}
+	}
+
+	tickFor(59 * time.Second)
+	require.NoError(t, s.checkRunLimits())
+	requireRunLimitReached(t, s, false)
+
+	tickFor(time.Second)
+	require.Error(t, s.checkRunLimits())
+	requireRunLimitReached(t, s, true)
+	assert.Empty(t, conversation.Sent())
+}
@@ -0,0 +1,203 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/sse"
+	"golang.org/x/xerrors"
+)
+
+const (
+	screenFormatANSI = "ansi"
+	screenFormatText = "txt"
+)
+
+// ansiScreenReader is implemented by AgentIOs that can render the screen
+// with color escape codes, such as termexec.Process.
+type ansiScreenReader interface {
+	ReadScreenANSI() string
+}
+
+// readScreen returns the current screen capture in the requested format.
+func (s *Server) readScreen(format string) (string, error) {
+	if s.agentio == nil {
+		return "", huma.Error503ServiceUnavailable("no agent is running")
+	}
+	switch format {
+	case screenFormatANSI:
+		reader, ok := s.agentio.(ansiScreenReader)
+		if !ok {
+			return "", huma.Error400BadRequest("the current transport does not support ANSI screen capture")
+		}
+		return reader.ReadScreenANSI(), nil
+	case screenFormatText, "":
+		return s.agentio.ReadScreen(), nil
+	default:
+		return "", huma.Error400BadRequest("unknown screen format: " + format)
+	}
+}
+
+// getScreenRaw handles GET /internal/screen/raw
+func (s *Server) getScreenRaw(ctx context.Context, input *struct {
+	Format string `query:"format" enum:"ansi,txt" default:"txt"`
+},
+) (*ScreenRawResponse, error) {
+	screen, err := s.readScreen(input.Format)
+	if err != nil {
+		return nil, err
+	}
+	resp := &ScreenRawResponse{}
+	resp.ContentType = "text/plain; charset=utf-8"
+	resp.Body = []byte(screen)
+	return resp, nil
+}
+
+// saveScreen handles POST /internal/screen/save. It writes the current
+// capture into <fixturesDir>/<agentType>/<name>/ using the same layout as
+// lib/msgfmt/testdata/format: msg.txt, the last user message in user.txt,
+// and expected.txt seeded with how the capture is formatted now, to be
+// corrected by hand. With the ansi format, msg.ansi is written as well.
+func (s *Server) saveScreen(ctx context.Context, input *ScreenSaveRequest) (*ScreenSaveResponse, error) {
+	if s.fixturesDir == "" {
+		return nil, huma.Error400BadRequest("fixtures directory is not configured; start the server with --fixtures-dir")
+	}
+	name := input.Body.Name
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return nil, huma.Error400BadRequest("invalid fixture name: " + name)
+	}
+
+	screen, err := s.readScreen(screenFormatText)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{"msg.txt": screen}
+	if input.Body.Format == screenFormatANSI {
+		if files["msg.ansi"], err = s.readScreen(screenFormatANSI); err != nil {
+			return nil, err
+		}
+	}
+
+	var userInput string
+	s.mu.RLock()
+	messages := s.conversation.Messages()
+	s.mu.RUnlock()
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == st.ConversationRoleUser {
+			userInput = messages[i].Message
+			break
+		}
+	}
+	files["user.txt"] = userInput
+	expected, toolCalls := mf.FormatToolCall(s.agentType, mf.FormatAgentMessage(s.agentType, screen, userInput))
+	files["expected.txt"] = expected
+	if len(toolCalls) > 0 {
+		files["expected_tool_calls.txt"] = strings.Join(toolCalls, "\n---\n")
+	}
+
+	dir := filepath.Join(s.fixturesDir, string(s.agentType), name)
+	if _, err := os.Stat(dir); err == nil && !input.Body.Overwrite {
+		return nil, huma.Error409Conflict(fmt.Sprintf("fixture %s already exists, set overwrite to replace it", name))
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, xerrors.Errorf("failed to create fixture directory: %w", err)
+	}
+	for file, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0o644); err != nil {
+			return nil, xerrors.Errorf("failed to write %s: %w", file, err)
+		}
+	}
+	s.logger.Info("Saved screen fixture", "dir", dir, "format", input.Body.Format)
+
+	resp := &ScreenSaveResponse{}
+	resp.Body.Ok = true
+	resp.Body.Dir = dir
+	return resp, nil
+}
+
+const (
+	screenEncodingFull = "full"
+	screenEncodingDiff = "diff"
+)
+
+// screenStream sends the screen updates of a subscription, at most once
+// per interval. Updates arriving faster are coalesced, so only the latest
+// one is sent when the interval elapses.
+type screenStream struct {
+	send     sse.Sender
+	clock    quartz.Clock
+	encoding string
+	interval time.Duration
+	// last is the last update sent, which diffs are relative to.
+	last     *ScreenUpdateBody
+	pending  *ScreenUpdateBody
+	nextSend time.Time
+	timer    *quartz.Timer
+}
+
+// screenInterval returns the minimum time between two screen events given
+// the server's and the subscriber's maximum rates. Zero means unlimited.
+func screenInterval(serverRate, clientRate int) time.Duration {
+	rate := serverRate
+	if clientRate > 0 && (rate == 0 || clientRate < rate) {
+		rate = clientRate
+	}
+	if rate <= 0 {
+		return 0
+	}
+	return time.Second / time.Duration(rate)
+}
+
+// update sends a screen update, or keeps it until the next send is due.
+func (w *screenStream) update(screen ScreenUpdateBody) error {
+	now := w.clock.Now()
+	if w.interval == 0 || !now.Before(w.nextSend) {
+		return w.write(screen)
+	}
+	if w.pending == nil {
+		w.timer = w.clock.NewTimer(w.nextSend.Sub(now), "screenStream")
+	}
+	w.pending = &screen
+	return nil
+}
+
+// due returns a channel that fires when a pending update should be sent.
+func (w *screenStream) due() <-chan time.Time {
+	if w.pending == nil {
+		return nil
+	}
+	return w.timer.C
+}
+
+// flush sends the pending update.
+func (w *screenStream) flush() error {
+	screen := *w.pending
+	w.pending = nil
+	return w.write(screen)
+}
+
+func (w *screenStream) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+func (w *screenStream) write(screen ScreenUpdateBody) error {
+	w.nextSend = w.clock.Now().Add(w.interval)
+	var err error
+	if w.encoding == screenEncodingDiff && w.last != nil {
+		err = w.send.Data(diffScreens(*w.last, screen))
+	} else {
+		err = w.send.Data(screen)
+	}
+	w.last = &screen
+	return err
+}
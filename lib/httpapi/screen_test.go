@@ -0,0 +1,148 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/sse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScreenInterval(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, time.Duration(0), screenInterval(0, 0))
+	assert.Equal(t, 100*time.Millisecond, screenInterval(10, 0))
+	assert.Equal(t, 100*time.Millisecond, screenInterval(0, 10))
+	assert.Equal(t, 500*time.Millisecond, screenInterval(10, 2))
+	assert.Equal(t, 100*time.Millisecond, screenInterval(10, 20))
+}
+
+func TestScreenStream(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mClock := quartz.NewMock(t)
+	var sent []any
+	stream := &screenStream{
+		send: func(msg sse.Message) error {
+			sent = append(sent, msg.Data)
+			return nil
+		},
+		clock:    mClock,
+		encoding: screenEncodingDiff,
+		interval: 100 * time.Millisecond,
+	}
+	defer stream.stop()
+	screen := func(sequence uint64, content string) ScreenUpdateBody {
+		return ScreenUpdateBody{Version: ScreenUpdateVersion, Screen: content, Width: 80, Height: 24, Sequence: sequence}
+	}
+
+	require.NoError(t, stream.update(screen(1, "> \nidle")))
+	require.Len(t, sent, 1)
+	assert.Equal(t, screen(1, "> \nidle"), sent[0])
+
+	// Updates within the interval are coalesced, latest wins.
+	require.NoError(t, stream.update(screen(2, "> h\nidle")))
+	require.NoError(t, stream.update(screen(3, "> hi")))
+	assert.Len(t, sent, 1)
+	require.NotNil(t, stream.due())
+
+	mClock.Advance(100 * time.Millisecond).MustWait(ctx)
+	<-stream.due()
+	require.NoError(t, stream.flush())
+	require.Len(t, sent, 2)
+	diff := sent[1].(ScreenDiffBody)
+	assert.Equal(t, ScreenDiffBody{
+		Version:      ScreenUpdateVersion,
+		Width:        80,
+		Height:       24,
+		Sequence:     3,
+		BaseSequence: 1,
+		LineCount:    1,
+		Lines:        []ScreenLine{{Index: 0, Content: "> hi"}},
+	}, diff)
+	assert.Nil(t, stream.due())
+
+	applied, err := diff.Apply(screen(1, "> \nidle"))
+	require.NoError(t, err)
+	assert.Equal(t, screen(3, "> hi"), applied)
+	_, err = diff.Apply(screen(2, "> h\nidle"))
+	assert.Error(t, err)
+
+	grown, err := diffScreens(screen(3, "> hi"), screen(4, "> hi\nthinking\n")).Apply(screen(3, "> hi"))
+	require.NoError(t, err)
+	assert.Equal(t, screen(4, "> hi\nthinking\n"), grown)
+}
+
+func TestSaveScreen(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	s := &Server{
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		agentio:   &ansiAgentIO{screen: "● Hello\n> "},
+		agentType: mf.AgentTypeClaude,
+		conversation: &messagesConversation{messages: []st.ConversationMessage{
+			{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"},
+			{Id: 1, Role: st.ConversationRoleUser, Message: "Say hello"},
+			{Id: 2, Role: st.ConversationRoleAgent, Message: "Hello"},
+		}},
+		fixturesDir: dir,
+	}
+
+	save := func(name, format string, overwrite bool) (string, error) {
+		t.Helper()
+		input := &ScreenSaveRequest{}
+		input.Body.Name = name
+		input.Body.Format = format
+		input.Body.Overwrite = overwrite
+		resp, err := s.saveScreen(context.Background(), input)
+		if err != nil {
+			return "", err
+		}
+		return resp.Body.Dir, nil
+	}
+	read := func(dir, name string) string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	fixture, err := save("first_message", screenFormatText, false)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "claude", "first_message"), fixture)
+	assert.Equal(t, "● Hello\n> ", read(fixture, "msg.txt"))
+	assert.Equal(t, "Say hello", read(fixture, "user.txt"))
+	// expected.txt is what TestFormatMessage compares the formatted msg.txt
+	// to.
+	expected, _ := mf.FormatToolCall(mf.AgentTypeClaude, mf.FormatAgentMessage(mf.AgentTypeClaude, "● Hello\n> ", "Say hello"))
+	assert.Equal(t, expected, read(fixture, "expected.txt"))
+	assert.NoFileExists(t, filepath.Join(fixture, "msg.ansi"))
+
+	// Existing fixtures are only replaced with overwrite.
+	_, err = save("first_message", screenFormatANSI, false)
+	var statusErr huma.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusConflict, statusErr.GetStatus())
+	_, err = save("first_message", screenFormatANSI, true)
+	require.NoError(t, err)
+	assert.Equal(t, "● Hello\n> ", read(fixture, "msg.txt"))
+	assert.Equal(t, "● Hello\n> ", read(fixture, "msg.ansi"))
+	assert.Equal(t, expected, read(fixture, "expected.txt"))
+
+	_, err = save("../escape", screenFormatText, false)
+	assert.Error(t, err)
+}
@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -22,21 +24,23 @@ import (
 
 	"github.com/coder/agentapi/internal/version"
 	"github.com/coder/agentapi/lib/logctx"
+	"github.com/coder/agentapi/lib/metrics"
 	mf "github.com/coder/agentapi/lib/msgfmt"
 	st "github.com/coder/agentapi/lib/screentracker"
-	"github.com/coder/agentapi/lib/termexec"
-	"github.com/coder/agentapi/x/acpio"
+	"github.com/coder/agentapi/lib/transport"
 	"github.com/coder/quartz"
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humachi"
 	"github.com/danielgtaylor/huma/v2/sse"
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/cors"
 	"golang.org/x/xerrors"
 )
 
 // Server represents the HTTP server
 type Server struct {
+	// handler serves the whole server, and router the routes under the
+	// base path.
+	handler      http.Handler
 	router       chi.Router
 	api          huma.API
 	port         int
@@ -54,6 +58,67 @@ type Server struct {
 	shutdownCtx  context.Context
 	shutdown     context.CancelFunc
 	transport    Transport
+	fixturesDir  string
+	metrics      *metrics.Registry
+	// autoCompactThreshold is the context usage percentage at which the
+	// agent's compaction command is sent. 0 disables auto-compaction.
+	autoCompactThreshold int
+	nudge                NudgeConfig
+	runLimits            RunLimits
+	runStart             time.Time
+	runLimitReached      chan struct{}
+	drainTimeout         time.Duration
+	drainMu              sync.Mutex
+	drainStart           time.Time
+	drained              chan struct{}
+	takeoverMu           sync.Mutex
+	takeover             *Takeover
+	tags                 map[string]string
+	messageLimiter       *MessageRateLimiter
+	duplicatePrompts     *duplicatePromptDetector
+	sessions             *Sessions
+	transcriptSinks      []transcriptSink
+	pullRequests         *pullRequestCreator
+	tokenizer            mf.Tokenizer
+	pricing              PricingConfig
+	httpConfig           HTTPConfig
+	attachments          AttachmentStore
+	attachmentTTL        time.Duration
+	files                *fileBrowser
+	artifacts            *artifactStore
+	// terminalInput is nil unless raw keystrokes are recorded.
+	terminalInput *terminalLineBuffer
+	// fileMentions are the expanded @file(path) mentions of user messages,
+	// by message ID.
+	fileMentions  map[int][]FileMention
+	screenMaxRate int
+	stateFile     string
+	saveState     bool
+	// environment is how the conversation is produced, compared with that
+	// of restored snapshots.
+	environment *st.Environment
+	teeConfig   TeeConfig
+	retention   RetentionConfig
+	// resourceStats reads the usage of the agent's cgroup, if it runs with
+	// resource limits. resourceMu serializes the metric updates.
+	resourceStats        func() (transport.ResourceStats, error)
+	resourceMu           sync.Mutex
+	sandbox              *SandboxStatus
+	configuredMCPServers []string
+	// network is nil unless the sandboxed agent's network access is
+	// restricted. blockedHosts are the hosts it tried to reach since.
+	network       NetworkAllowlist
+	blockedMu     sync.Mutex
+	blockedHosts  map[string]*BlockedHost
+	debugMessages bool
+	// messageStore is closed on Stop. nil if the transport's default store
+	// is used.
+	messageStore st.MessageStore
+	// agentExited is set once the agent's process exited.
+	agentExited bool
+	// rawMessagesDisabled is set when a guardrail is configured, since
+	// keystrokes sent one at a time can't be checked against it.
+	rawMessagesDisabled bool
 }
 
 func (s *Server) NormalizeSchema(schema any) any {
@@ -98,10 +163,6 @@ func (s *Server) GetOpenAPI() string {
 	return string(prettyJSON)
 }
 
-// That's about 40 frames per second. It's slightly less
-// because the action of taking a snapshot takes time too.
-const snapshotInterval = 25 * time.Millisecond
-
 type ServerConfig struct {
 	AgentType              mf.AgentType
 	AgentIO                st.AgentIO
@@ -110,9 +171,133 @@ type ServerConfig struct {
 	ChatBasePath           string
 	AllowedHosts           []string
 	AllowedOrigins         []string
+	CORS                   CORSConfig
 	InitialPrompt          string
 	Clock                  quartz.Clock
 	StatePersistenceConfig st.StatePersistenceConfig
+	// FixturesDir is where POST /internal/screen/save writes screen captures.
+	// Saving is disabled when empty.
+	FixturesDir string
+	// AutoCompactThreshold is the context usage percentage at which the
+	// agent's compaction command (e.g. /compact) is sent automatically.
+	// 0 disables auto-compaction.
+	AutoCompactThreshold int
+	// Nudge sends a follow-up message to an agent that has been idle with
+	// its reply unanswered.
+	Nudge NudgeConfig
+	// RunLimits stop the run once the agent has completed enough turns or
+	// run for long enough.
+	RunLimits RunLimits
+	// DrainTimeout is how long POST /drain waits for the agent to finish
+	// its turn before the server shuts down anyway.
+	DrainTimeout time.Duration
+	// Tags are labels assigned by whoever started the server, such as the
+	// project or repository the agent works on. They are reported by
+	// GET /status.
+	Tags map[string]string
+	// MessageRateLimiter caps the messages and commands sent to the agent
+	// per minute. Requests over the limit get a 429. nil disables the limit.
+	MessageRateLimiter *MessageRateLimiter
+	// DuplicatePrompts flags user messages identical to a recent one.
+	DuplicatePrompts DuplicatePromptConfig
+	// Sessions are served under /sessions/{id} when the server hosts more
+	// than one conversation. nil disables the sessions routes.
+	Sessions *Sessions
+	// Tee appends finalized messages to a JSONL file, independently of
+	// state persistence.
+	Tee TeeConfig
+	// LogExport sends conversation events to syslog or an OTLP endpoint.
+	LogExport LogExportConfig
+	// OnMessageCmd is a shell command run for each finalized agent
+	// message, with the message as JSON on stdin.
+	OnMessageCmd string
+	// Push sends phone notifications through ntfy or Pushover.
+	Push PushConfig
+	// GitHub enables POST /git/pr.
+	GitHub GitHubConfig
+	// Coder reports the agent's coder_report_task calls to the Coder
+	// workspace the server runs in.
+	Coder CoderConfig
+	// Middleware runs on every message and status change of the
+	// conversation, in order.
+	Middleware []st.ConversationMiddleware
+	// OutputFilter flags or redacts agent output matching sensitive
+	// patterns. It runs after Middleware.
+	OutputFilter OutputFilterConfig
+	// Markdown adds a markdown conversion of agent messages, for clients
+	// that render them rather than showing them as terminal output.
+	Markdown bool
+	// MessageBlocks splits agent messages into prose, code blocks, tool
+	// calls and diffs, for clients that render each differently.
+	MessageBlocks bool
+	// MessageColors keeps the colors of agent messages, as ANSI escape
+	// sequences or HTML, for clients that show them as the terminal does.
+	// Empty disables it.
+	MessageColors MessageColors
+	// Tokenizer estimates the tokens of each message reported by
+	// GET /messages. Defaults to mf.HeuristicTokenizer.
+	Tokenizer mf.Tokenizer
+	// Pricing is used to compute the cost of each turn reported by
+	// GET /usage and exported to the transcript sinks.
+	Pricing PricingConfig
+	// CompressionLevel is the gzip and deflate level of responses, from 1
+	// to 9. 0 disables compression.
+	CompressionLevel int
+	// RequestLog configures the logging of API requests.
+	RequestLog RequestLogConfig
+	// AttachmentStore keeps the files stored with POST /attachments.
+	// Defaults to a directory removed when the server stops.
+	AttachmentStore AttachmentStore
+	// AttachmentTTL is how long attachments are kept. 0 keeps them until
+	// the server stops.
+	AttachmentTTL time.Duration
+	// Files enables the read-only file browser of GET /files.
+	Files FilesConfig
+	// RecordTerminalInput records the lines typed in raw keystrokes, e.g.
+	// with agentapi attach, as user messages.
+	RecordTerminalInput bool
+	// Artifacts snapshots the files the agent announces or modifies in each
+	// turn when the turn completes. It needs the file browser.
+	Artifacts bool
+	// HTTP tunes the timeouts and HTTP/2 support of the HTTP server.
+	HTTP HTTPConfig
+	// BasePath is a prefix, such as /agentapi, under which all routes are
+	// served. Empty serves them at the root.
+	BasePath string
+	// TrustedProxies are the addresses, as IPs or CIDR ranges, of the
+	// reverse proxies whose X-Forwarded-Host header is checked against
+	// AllowedHosts instead of the Host header.
+	TrustedProxies []string
+	// TerminalWidth and TerminalHeight are the size of the agent's
+	// terminal, reported in screen events.
+	TerminalWidth  uint16
+	TerminalHeight uint16
+	// Retention limits the disk space used by attachments, uploads and
+	// rotated transcripts.
+	Retention RetentionConfig
+	// ScreenMaxRate caps the screen events sent to each subscriber of
+	// /internal/screen per second. 0 means unlimited.
+	ScreenMaxRate int
+	// ResourceStats reads the usage of the agent's process tree when it
+	// runs with resource limits, and is reported in /metrics.
+	ResourceStats func() (transport.ResourceStats, error)
+	// Sandbox describes the sandbox of an agent started with --sandbox, as
+	// reported by GET /status.
+	Sandbox *SandboxStatus
+	// ConfiguredMCPServers are the names of the MCP servers given to the
+	// agent with --mcp-config, as reported by GET /status. Whether the agent
+	// loaded them isn't checked.
+	ConfiguredMCPServers []string
+	// Network is the proxy the sandboxed agent's network access goes
+	// through, if restricted with --sandbox-proxy.
+	Network NetworkAllowlist
+	// DebugMessages records how agent messages are parsed from the screen,
+	// returned by GET /messages?include=debug.
+	DebugMessages bool
+	// MessageWindow is how many of the latest messages are kept in memory
+	// by transports that parse messages from the screen. Older messages are
+	// moved to a temporary file. 0 keeps all messages in memory.
+	MessageWindow int
 }
 
 // Validate allowed hosts don't contain whitespace, commas, schemes, or ports.
@@ -167,6 +352,55 @@ func parseAllowedHosts(input []string) ([]string, error) {
 	return hostStrings, nil
 }
 
+// parseBasePath normalizes a base path to start with a slash and have no
+// trailing slash. The root is returned as an empty string.
+func parseBasePath(input string) (string, error) {
+	if input == "" || input == "/" {
+		return "", nil
+	}
+	if !strings.HasPrefix(input, "/") {
+		return "", fmt.Errorf("'%s' must start with '/'", input)
+	}
+	u, err := url.Parse(input)
+	if err != nil || u.Path != input {
+		return "", fmt.Errorf("'%s' is not a valid path", input)
+	}
+	return strings.TrimSuffix(input, "/"), nil
+}
+
+// parseTrustedProxies parses IP addresses and CIDR ranges.
+func parseTrustedProxies(input []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(input))
+	for _, item := range input {
+		if prefix, err := netip.ParsePrefix(item); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid IP address or CIDR range", item)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// isTrustedProxy reports whether a request comes from one of the trusted
+// proxies.
+func isTrustedProxy(r *http.Request, trustedProxies []netip.Prefix) bool {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
 // Validate allowed origins
 func parseAllowedOrigins(input []string) ([]string, error) {
 	if len(input) == 0 {
@@ -208,6 +442,15 @@ func NewServer(ctx context.Context, config ServerConfig) (*Server, error) {
 	if config.Clock == nil {
 		config.Clock = quartz.NewReal()
 	}
+	if config.Sessions != nil {
+		config.Sessions.setClock(config.Clock)
+	}
+	if config.Tokenizer == nil {
+		config.Tokenizer = mf.HeuristicTokenizer{}
+	}
+	if config.Transport == "" {
+		config.Transport = TransportPTY
+	}
 
 	allowedHosts, err := parseAllowedHosts(config.AllowedHosts)
 	if err != nil {
@@ -218,41 +461,97 @@ func NewServer(ctx context.Context, config ServerConfig) (*Server, error) {
 		return nil, xerrors.Errorf("failed to parse allowed origins: %w", err)
 	}
 
+	trustedProxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse trusted proxies: %w", err)
+	}
+	basePath, err := parseBasePath(config.BasePath)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to parse base path: %w", err)
+	}
+
 	logger.Info(fmt.Sprintf("Allowed hosts: %s", strings.Join(allowedHosts, ", ")))
 	logger.Info(fmt.Sprintf("Allowed origins: %s", strings.Join(allowedOrigins, ", ")))
+	if len(trustedProxies) > 0 {
+		logger.Info(fmt.Sprintf("Trusted proxies: %s", strings.Join(config.TrustedProxies, ", ")))
+	}
+
+	metricsRegistry := metrics.New()
+	// Requests are logged first so that those rejected by the other
+	// middlewares are too.
+	router.Use(requestLogMiddleware(config.RequestLog, logger, config.Clock, metricsRegistry))
 
 	// Enforce allowed hosts in a custom middleware that ignores the port during matching.
 	badHostHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid host header. Allowed hosts: "+strings.Join(allowedHosts, ", "), http.StatusBadRequest)
 	})
-	router.Use(hostAuthorizationMiddleware(allowedHosts, badHostHandler))
+	router.Use(hostAuthorizationMiddleware(allowedHosts, trustedProxies, badHostHandler))
 
-	corsMiddleware := cors.New(cors.Options{
-		AllowedOrigins:   allowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300, // Maximum value not ignored by any of major browsers
-	})
-	router.Use(corsMiddleware.Handler)
+	router.Use(corsMiddleware(allowedOrigins, config.CORS))
+	if config.CompressionLevel != 0 {
+		if config.CompressionLevel < 1 || config.CompressionLevel > 9 {
+			return nil, xerrors.Errorf("compression level must be between 1 and 9, got %d", config.CompressionLevel)
+		}
+		router.Use(compressMiddleware(config.CompressionLevel))
+	}
+
+	// Routes are registered on apiRouter, which is mounted under the base
+	// path when there is one.
+	apiRouter := chi.Router(router)
+	if basePath != "" {
+		apiRouter = chi.NewRouter()
+		router.Mount(basePath, apiRouter)
+		logger.Info(fmt.Sprintf("Base path: %s", basePath))
+	}
 
 	humaConfig := huma.DefaultConfig("AgentAPI", version.Version)
 	humaConfig.Info.Description = "HTTP API for Claude Code, Goose, and Aider.\n\nhttps://github.com/coder/agentapi"
-	api := humachi.New(router, humaConfig)
-	formatMessage := func(message string, userInput string) string {
-		return mf.FormatAgentMessage(config.AgentType, message, userInput)
+	if basePath != "" {
+		// Huma also prefixes the OpenAPI link of the docs page with it.
+		humaConfig.Servers = []*huma.Server{{URL: basePath}}
 	}
-
-	isAgentReadyForInitialPrompt := func(message string) bool {
-		return mf.IsAgentReadyForInitialPrompt(config.AgentType, message)
+	api := humachi.New(apiRouter, humaConfig)
+	transcriptSinks, err := newLogExporters(config.LogExport, config.AgentType, logger, metricsRegistry)
+	if err != nil {
+		return nil, err
 	}
-
-	formatToolCall := func(message string) (string, []string) {
-		return mf.FormatToolCall(config.AgentType, message)
+	// The sinks are closed by Stop, or here if a later step fails.
+	created := false
+	defer func() {
+		if !created {
+			closeTranscriptSinks(transcriptSinks, logger)
+		}
+	}()
+	if push := newPushNotifier(config.Push, string(config.AgentType), logger, metricsRegistry); push != nil {
+		transcriptSinks = append(transcriptSinks, push)
 	}
-
-	emitter := NewEventEmitter(WithAgentType(config.AgentType))
+	if coder := newCoderStatusReporter(config.Coder, logger, metricsRegistry); coder != nil {
+		transcriptSinks = append(transcriptSinks, coder)
+	}
+	if config.OnMessageCmd != "" {
+		transcriptSinks = append(transcriptSinks, newOnMessageCmd(config.OnMessageCmd, logger, metricsRegistry))
+	}
+	if config.Tee.Path != "" {
+		tee, err := newTranscriptTee(config.Tee, logger)
+		if err != nil {
+			return nil, err
+		}
+		transcriptSinks = append(transcriptSinks, tee)
+		logger.Info("Teeing agent messages", "path", config.Tee.Path, "screens", config.Tee.Screens)
+	}
+	emitter := NewEventEmitter(
+		WithAgentType(config.AgentType),
+		WithTerminalSize(config.TerminalWidth, config.TerminalHeight),
+		WithMetrics(metricsRegistry),
+		withTranscriptSinks(transcriptSinks...),
+		withUsage(config.Tokenizer, config.Pricing),
+		// Parse warnings and on-screen context indicators only make sense
+		// for agents running in a terminal.
+		WithParseQualityCheck(config.Transport == TransportPTY),
+		WithContextUsageTracking(config.Transport == TransportPTY),
+		WithPromptDetection(config.Transport == TransportPTY),
+		WithStartupBannerParsing(config.Transport == TransportPTY),
+	)
 
 	// Format initial prompt into message parts if provided
 	var initialPrompt []st.MessagePart
@@ -260,32 +559,68 @@ func NewServer(ctx context.Context, config ServerConfig) (*Server, error) {
 		initialPrompt = FormatMessage(config.AgentType, config.InitialPrompt)
 	}
 
-	var conversation st.Conversation
-	if config.Transport == TransportACP {
-		// For ACP, cast AgentIO to *acpio.ACPAgentIO
-		acpIO, ok := config.AgentIO.(*acpio.ACPAgentIO)
-		if !ok {
-			return nil, fmt.Errorf("ACP transport requires ACPAgentIO")
+	middlewares := config.Middleware
+	outputFilter, err := newOutputFilter(config.OutputFilter, logger, metricsRegistry)
+	if err != nil {
+		return nil, err
+	}
+	statePersistence := config.StatePersistenceConfig
+	if outputFilter != nil {
+		middlewares = append(slices.Clip(middlewares), outputFilter)
+		statePersistence.FilterMessages = outputFilter.filterState
+	}
+	if config.Markdown {
+		middlewares = append(slices.Clip(middlewares), markdownConverter{})
+	}
+	if config.MessageBlocks {
+		middlewares = append(slices.Clip(middlewares), blockParser{agentType: config.AgentType})
+	}
+	if config.MessageColors != "" {
+		middlewares = append(slices.Clip(middlewares), newColorPreserver(config.AgentIO, config.MessageColors))
+	}
+	// Links are found last, so redacted URLs aren't linked.
+	middlewares = append(slices.Clip(middlewares), newLinkDetector(config.AgentIO))
+	var conversationEmitter st.Emitter = emitter
+	var middleware *st.MiddlewareChain
+	if len(middlewares) > 0 {
+		middleware = st.NewMiddlewareChain(middlewares...)
+		conversationEmitter = middleware.Emitter(emitter)
+		if initialPrompt, err = middleware.UserMessage(initialPrompt); err != nil {
+			return nil, xerrors.Errorf("initial prompt rejected: %w", err)
+		}
+	}
+
+	tr, err := transport.Lookup(string(config.Transport))
+	if err != nil {
+		return nil, xerrors.Errorf("failed to look up transport: %w", err)
+	}
+	var messageStore st.MessageStore
+	if config.MessageWindow > 0 {
+		if messageStore, err = st.NewDiskMessageStore("", config.MessageWindow); err != nil {
+			return nil, err
 		}
-		conversation = acpio.NewACPConversation(ctx, acpIO, logger, initialPrompt, emitter, config.Clock)
-	} else {
-		proc, ok := config.AgentIO.(*termexec.Process)
-		if !ok && config.AgentIO != nil {
-			return nil, fmt.Errorf("PTY transport requires termexec.Process")
-		}
-		conversation = st.NewPTY(ctx, st.PTYConversationConfig{
-			AgentType:              config.AgentType,
-			AgentIO:                proc,
-			Clock:                  config.Clock,
-			SnapshotInterval:       snapshotInterval,
-			ScreenStabilityLength:  2 * time.Second,
-			FormatMessage:          formatMessage,
-			ReadyForInitialPrompt:  isAgentReadyForInitialPrompt,
-			FormatToolCall:         formatToolCall,
-			InitialPrompt:          initialPrompt,
-			Logger:                 logger,
-			StatePersistenceConfig: config.StatePersistenceConfig,
-		}, emitter)
+	}
+	conversation, err := tr.NewConversation(ctx, transport.ConversationConfig{
+		AgentType:              config.AgentType,
+		AgentIO:                config.AgentIO,
+		InitialPrompt:          initialPrompt,
+		Emitter:                conversationEmitter,
+		Clock:                  config.Clock,
+		Logger:                 logger,
+		StatePersistenceConfig: statePersistence,
+		DebugMessages:          config.DebugMessages,
+		MessageStore:           messageStore,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create conversation: %w", err)
+	}
+	if middleware != nil {
+		conversation = middleware.Conversation(conversation)
+	}
+
+	files, err := newFileBrowser(config.Files)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create temporary directory for uploads
@@ -294,51 +629,120 @@ func NewServer(ctx context.Context, config ServerConfig) (*Server, error) {
 		return nil, xerrors.Errorf("failed to create temporary directory: %w", err)
 	}
 	logger.Info("Created temporary directory for uploads", "tempDir", tempDir)
+	attachments := config.AttachmentStore
+	if attachments == nil {
+		if attachments, err = NewLocalAttachmentStore(filepath.Join(tempDir, "attachments"), config.Clock); err != nil {
+			return nil, err
+		}
+	}
+
+	var artifacts *artifactStore
+	if config.Artifacts {
+		if files == nil {
+			return nil, xerrors.Errorf("artifacts need the file browser, enable it with --files-allow")
+		}
+		if artifacts, err = newArtifactStore(filepath.Join(tempDir, "artifacts")); err != nil {
+			return nil, err
+		}
+	}
 
 	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
 
 	s := &Server{
-		router:       router,
-		api:          api,
-		port:         config.Port,
-		conversation: conversation,
-		logger:       logger,
-		agentio:      config.AgentIO,
-		agentType:    config.AgentType,
-		emitter:      emitter,
-		chatBasePath: strings.TrimSuffix(config.ChatBasePath, "/"),
-		tempDir:      tempDir,
-		clock:        config.Clock,
-		shutdownCtx:  shutdownCtx,
-		shutdown:     shutdownCancel,
-		transport:    config.Transport,
+		handler:              router,
+		router:               apiRouter,
+		api:                  api,
+		port:                 config.Port,
+		conversation:         conversation,
+		logger:               logger,
+		agentio:              config.AgentIO,
+		agentType:            config.AgentType,
+		emitter:              emitter,
+		chatBasePath:         strings.TrimSuffix(config.ChatBasePath, "/"),
+		tempDir:              tempDir,
+		clock:                config.Clock,
+		shutdownCtx:          shutdownCtx,
+		shutdown:             shutdownCancel,
+		transport:            config.Transport,
+		fixturesDir:          config.FixturesDir,
+		metrics:              metricsRegistry,
+		autoCompactThreshold: config.AutoCompactThreshold,
+		nudge:                config.Nudge,
+		runLimits:            config.RunLimits,
+		runStart:             config.Clock.Now(),
+		runLimitReached:      make(chan struct{}),
+		drainTimeout:         config.DrainTimeout,
+		drained:              make(chan struct{}),
+		tags:                 config.Tags,
+		messageLimiter:       config.MessageRateLimiter,
+		duplicatePrompts:     newDuplicatePromptDetector(config.DuplicatePrompts),
+		sessions:             config.Sessions,
+		transcriptSinks:      transcriptSinks,
+		pullRequests:         newPullRequestCreator(config.GitHub, config.Clock),
+		tokenizer:            config.Tokenizer,
+		pricing:              config.Pricing,
+		httpConfig:           config.HTTP,
+		attachments:          attachments,
+		attachmentTTL:        config.AttachmentTTL,
+		files:                files,
+		artifacts:            artifacts,
+		screenMaxRate:        config.ScreenMaxRate,
+		stateFile:            config.StatePersistenceConfig.StateFile,
+		saveState:            config.StatePersistenceConfig.SaveState,
+		environment:          config.StatePersistenceConfig.Environment,
+		teeConfig:            config.Tee,
+		retention:            config.Retention,
+		resourceStats:        config.ResourceStats,
+		sandbox:              config.Sandbox,
+		configuredMCPServers: config.ConfiguredMCPServers,
+		network:              config.Network,
+		blockedHosts:         map[string]*BlockedHost{},
+		debugMessages:        config.DebugMessages,
+		messageStore:         messageStore,
+		rawMessagesDisabled:  slices.ContainsFunc(config.Middleware, isGuardrail),
+	}
+
+	if config.RecordTerminalInput {
+		s.terminalInput = &terminalLineBuffer{}
 	}
 
 	// Register API routes
 	s.registerRoutes()
+	s.startAttachmentCleanup(shutdownCtx)
+	s.startStoragePruning(shutdownCtx)
 
 	// Start the conversation polling loop if we have an agent IO.
 	// AgentIO is nil only when --print-openapi is used (no agent runs).
-	// For PTY transport, the process is already running at this point -
-	// termexec.StartProcess() blocks until the PTY is created and the process
-	// is active. Agent readiness (waiting for the prompt) is handled
-	// asynchronously inside conversation.Start() via ReadyForInitialPrompt.
+	// The agent is already running at this point - transport.Transport.Start
+	// blocks until the agent can receive input. Agent readiness (waiting for
+	// the prompt) is handled asynchronously inside conversation.Start().
 	if config.AgentIO != nil {
 		s.conversation.Start(ctx)
+		s.startAutoCompact(ctx)
+		s.startNudging(ctx)
+		s.startArtifactCapture(ctx)
+		s.startRunLimits(ctx)
+		s.watchTerminalSignals()
+		if s.network != nil {
+			s.network.SetOnBlocked(s.networkBlocked)
+		}
 	}
 
+	created = true
 	return s, nil
 }
 
 // Handler returns the underlying chi.Router for testing purposes.
 func (s *Server) Handler() http.Handler {
-	return s.router
+	return s.handler
 }
 
 // hostAuthorizationMiddleware enforces that the request Host header matches one of the allowed
 // hosts, ignoring any port in the comparison. If allowedHosts is empty, all hosts are allowed.
 // Always uses url.Parse("http://" + r.Host) to robustly extract the hostname (handles IPv6).
-func hostAuthorizationMiddleware(allowedHosts []string, badHostHandler http.Handler) func(next http.Handler) http.Handler {
+// Behind a trusted proxy, the Host header is the proxy's, so the host the client asked for
+// is taken from X-Forwarded-Host instead.
+func hostAuthorizationMiddleware(allowedHosts []string, trustedProxies []netip.Prefix, badHostHandler http.Handler) func(next http.Handler) http.Handler {
 	// Copy for safety; also build a map for O(1) lookups with case-insensitive keys.
 	allowed := make(map[string]struct{}, len(allowedHosts))
 	for _, h := range allowedHosts {
@@ -353,6 +757,17 @@ func hostAuthorizationMiddleware(allowedHosts []string, badHostHandler http.Hand
 			}
 			// Extract hostname from the Host header using url.Parse; ignore any port.
 			hostHeader := r.Host
+			if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" && isTrustedProxy(r, trustedProxies) {
+				// Proxies append to the list, after any value the client
+				// sent itself, so only the last host, added by the trusted
+				// proxy that connected to us, can be relied on.
+				hosts := r.Header.Values("X-Forwarded-Host")
+				forwarded = hosts[len(hosts)-1]
+				if i := strings.LastIndex(forwarded, ","); i >= 0 {
+					forwarded = forwarded[i+1:]
+				}
+				hostHeader = strings.TrimSpace(forwarded)
+			}
 			if hostHeader == "" {
 				badHostHandler.ServeHTTP(w, r)
 				return
@@ -394,15 +809,133 @@ func (s *Server) registerRoutes() {
 		o.Description = "Returns a list of messages representing the conversation history with the agent."
 	})
 
+	huma.Get(s.api, "/messages/export", s.exportMessages, func(o *huma.Operation) {
+		o.Description = "Streams all the messages of the conversation, reading and writing a page of messages at a time, so that long conversations don't have to fit in memory. The messages are those of GET /messages when the request is received."
+	})
+
+	huma.Patch(s.api, "/messages/{id}/pin", s.pinMessage, func(o *huma.Operation) {
+		o.Description = "Pin or unpin a message, e.g. `{\"pinned\": true}`. Pinned messages are flagged in exports and quoted in full in pull request descriptions, and auto-compaction asks agents that support it to keep them verbatim. Pins are persisted with the state file."
+	})
+
 	// POST /message endpoint
 	huma.Post(s.api, "/message", s.createMessage, func(o *huma.Operation) {
-		o.Description = "Send a message to the agent. For messages of type 'user', the agent's status must be 'stable' for the operation to complete successfully. Otherwise, this endpoint will return an error."
+		o.Description = "Send a message to the agent. For messages of type 'user', the agent's status must be 'stable' for the operation to complete successfully. Otherwise, this endpoint will return an error. Messages of type 'raw' are rejected with 403 when guardrails are configured."
+	})
+
+	huma.Post(s.api, "/takeover", s.takeOver, func(o *huma.Operation) {
+		o.Description = "Mark the agent's terminal as controlled by a human, e.g. one debugging it with agentapi attach. Until POST /release, user messages, commands and prompt replies are rejected with 423, and nudges, auto-compaction and wrap-up prompts aren't sent. Raw messages, which agentapi attach sends keystrokes with, and the screen keep working. Returns 409 if it's already taken over."
+	})
+	huma.Post(s.api, "/release", s.release, func(o *huma.Operation) {
+		o.Description = "Return control of the agent's terminal to the API after POST /takeover. Returns 409 unless it was taken over."
+		o.DefaultStatus = http.StatusNoContent
+	})
+
+	huma.Post(s.api, "/drain", s.drain, func(o *huma.Operation) {
+		o.Description = "Stop accepting user messages, which are rejected with 503 and a Retry-After header, and shut down once the agent has finished its turn and the state is saved, or after --drain-timeout. For rolling updates; SIGTERM does the same. Returns immediately."
+		o.DefaultStatus = http.StatusAccepted
+	})
+
+	huma.Get(s.api, "/sandbox/network", s.getNetworkPolicy, func(o *huma.Operation) {
+		o.Description = "Returns the domains the sandboxed agent can reach and the hosts it was blocked from reaching. Returns 404 unless the server runs with --sandbox-proxy."
+	})
+
+	huma.Post(s.api, "/sandbox/network/allow", s.allowNetworkDomains, func(o *huma.Operation) {
+		o.Description = "Let the sandboxed agent reach more domains, along with their subdomains, e.g. `{\"domains\": [\"pypi.org\"]}`. Takes effect for the agent's next connection."
+	})
+
+	huma.Get(s.api, "/pending-prompt", s.getPendingPrompt, func(o *huma.Operation) {
+		o.Description = "Returns the interactive prompt, such as a permission dialog, the agent is waiting on, with its options. The prompt is absent if there's none. Only agents running in a terminal are checked for prompts."
+	})
+
+	huma.Post(s.api, "/pending-prompt/reply", s.replyToPendingPrompt, func(o *huma.Operation) {
+		o.Description = "Answer the pending prompt by choosing one of its options. The server sends the keystrokes that choose the option. Returns 409 if no prompt is pending."
+	})
+
+	huma.Get(s.api, "/analytics", s.getAnalytics, func(o *huma.Operation) {
+		o.Description = "Returns statistics about the conversation: the number of turns and their average duration, the longest time the agent stalled, tool call counts by tool, and the estimated tokens consumed."
+	})
+
+	huma.Get(s.api, "/usage", s.getUsage, func(o *huma.Operation) {
+		o.Description = "Returns the estimated tokens used by each turn of the conversation and, if the server has a price for the agent's model (--pricing-file and --pricing-model), their cost in US dollars."
+	})
+
+	huma.Get(s.api, "/conversation/diff", s.getConversationDiff, func(o *huma.Operation) {
+		o.Description = "Returns what changed in the conversation since a checkpoint returned by a previous call, or since a message ID: the messages added, and how the last message seen has changed since. Omit both parameters to get the whole conversation and a first checkpoint."
+	})
+
+	huma.Post(s.api, "/wait_for", s.waitFor, func(o *huma.Operation) {
+		o.Description = "Block until the latest agent message, or with `source` the terminal screen, matches a regular expression, e.g. `{\"pattern\": \"All tests passed\", \"timeout\": \"10m\"}`. Resolves immediately if one already matches. Returns 408 if the pattern isn't found before the timeout."
+	})
+
+	huma.Get(s.api, "/commands", s.getCommands, func(o *huma.Operation) {
+		o.Description = "Returns the slash commands the agent currently advertises. Only ACP agents report commands; other transports return an empty list."
+	})
+
+	huma.Post(s.api, "/command", s.runCommand, func(o *huma.Operation) {
+		o.Description = "Invoke one of the commands returned by GET /commands. The command is sent to the agent as a user message, so the agent's status must be 'stable'."
+	})
+
+	huma.Get(s.api, "/notes", s.getNotes, func(o *huma.Operation) {
+		o.Description = "Returns the notes attached to the conversation."
+	})
+
+	huma.Put(s.api, "/notes", s.putNotes, func(o *huma.Operation) {
+		o.Description = "Replace the notes attached to the conversation. Notes are arbitrary key-value metadata managed by the client and are persisted with the state file when --state-file is set."
+	})
+
+	huma.Post(s.api, "/state/snapshots", s.createStateSnapshot, func(o *huma.Operation) {
+		o.Description = "Save the state and copy the state file to a named snapshot, e.g. before a risky change. Requires --state-file with --save-state. Snapshots are kept next to the state file and are independent of the agent's workspace."
+	})
+
+	huma.Get(s.api, "/state/snapshots", s.getStateSnapshots, func(o *huma.Operation) {
+		o.Description = "Lists the snapshots taken with POST /state/snapshots."
+	})
+
+	huma.Post(s.api, "/state/snapshots/{name}/restore", s.restoreStateSnapshot, func(o *huma.Operation) {
+		o.Description = "Replace the conversation's messages, pins and notes with those of a snapshot and save the state file. The agent's status must be 'stable'. The agent itself keeps its context, and the workspace is left as is. Clients subscribed to /events should fetch GET /messages again, since messages after the snapshot are removed."
+	})
+
+	huma.Post(s.api, "/git/pr", s.createPullRequest, func(o *huma.Operation) {
+		o.Description = "Commit all changes in the agent's git workspace to a new branch, push it and open a GitHub pull request summarizing the conversation. Requires GITHUB_TOKEN to be set, and the agent's status must be 'stable'."
 	})
 
 	huma.Post(s.api, "/upload", s.uploadFiles, func(o *huma.Operation) {
 		o.Description = "Upload files to the specified upload path."
 	})
 
+	huma.Get(s.api, "/files", s.getFiles, func(o *huma.Operation) {
+		o.Description = "Lists the agent's working directory. Only files allowed by --files-allow are listed, and hidden files never are."
+	})
+
+	huma.Get(s.api, "/files/{path}", s.getFile, func(o *huma.Operation) {
+		o.Description = "Returns a file in the agent's working directory with its content, or lists a directory. Files larger than --files-max-size-mb return 413."
+	})
+
+	huma.Get(s.api, "/messages/{id}/artifacts", s.getMessageArtifacts, func(o *huma.Operation) {
+		o.Description = "Lists the files an agent message announced, e.g. 'created report.md', or its tool calls modified, as they were when the agent completed its turn. Only files allowed by --files-allow are captured. Returns 404 unless the server runs with --artifacts."
+	})
+
+	huma.Get(s.api, "/messages/{id}/artifacts/{path}", s.getMessageArtifact, func(o *huma.Operation) {
+		o.Description = "Returns an artifact of an agent message with the content it had when the agent completed its turn, even if the file changed since. Artifacts are kept until the server stops."
+	})
+
+	huma.Post(s.api, "/attachments", s.createAttachment, func(o *huma.Operation) {
+		o.Description = "Store a file of up to 100MB to share with the agent. Pass the returned ID in the attachments of a message to reference the file. Attachments are deleted when the server stops, or after --attachments-ttl."
+	})
+
+	huma.Get(s.api, "/attachments/{id}", s.getAttachment, func(o *huma.Operation) {
+		o.Description = "Returns an attachment stored with POST /attachments."
+	})
+
+	huma.Delete(s.api, "/attachments/{id}", s.deleteAttachment, func(o *huma.Operation) {
+		o.Description = "Deletes an attachment stored with POST /attachments."
+		o.DefaultStatus = http.StatusNoContent
+	})
+
+	huma.Get(s.api, "/storage", s.getStorage, func(o *huma.Operation) {
+		o.Description = "Returns the disk space used by the server's files, by category, and how much of it the retention policy (--retention-max-age and --retention-max-size-mb) may prune."
+	})
+
 	// GET /events endpoint
 	sse.Register(s.api, huma.Operation{
 		OperationID: "subscribeEvents",
@@ -413,9 +946,17 @@ func (s *Server) registerRoutes() {
 		Middlewares: []func(huma.Context, func(huma.Context)){sseMiddleware},
 	}, map[string]any{
 		// Mapping of event type name to Go struct for that event.
-		"message_update": MessageUpdateBody{},
-		"status_change":  StatusChangeBody{},
-		"agent_error":    ErrorBody{},
+		"message_update":  MessageUpdateBody{},
+		"status_change":   StatusChangeBody{},
+		"agent_error":     ErrorBody{},
+		"parse_warning":   ParseWarningBody{},
+		"tool_call":       ToolCallBody{},
+		"thought_update":  ThoughtUpdateBody{},
+		"plan_update":     PlanUpdateBody{},
+		"diff":            DiffBody{},
+		"attention":       AttentionBody{},
+		"pending_prompt":  PendingPromptBody{},
+		"network_blocked": NetworkBlockedBody{},
 	}, s.subscribeEvents)
 
 	sse.Register(s.api, huma.Operation{
@@ -426,9 +967,47 @@ func (s *Server) registerRoutes() {
 		Hidden:      true,
 		Middlewares: []func(huma.Context, func(huma.Context)){sseMiddleware},
 	}, map[string]any{
-		"screen": ScreenUpdateBody{},
+		"screen":      ScreenUpdateBody{},
+		"screen_diff": ScreenDiffBody{},
 	}, s.subscribeScreen)
 
+	huma.Register(s.api, huma.Operation{
+		OperationID: "getScreenRaw",
+		Method:      http.MethodGet,
+		Path:        "/internal/screen/raw",
+		Summary:     "Get raw screen capture",
+		Description: "Returns the exact current capture of the agent's terminal. Use format=ansi to preserve color escape codes.",
+		Hidden:      true,
+	}, s.getScreenRaw)
+
+	huma.Register(s.api, huma.Operation{
+		OperationID: "saveScreen",
+		Method:      http.MethodPost,
+		Path:        "/internal/screen/save",
+		Summary:     "Save screen capture as a fixture",
+		Description: "Writes the current screen capture, the last user message and the current formatting of the capture into the fixtures directory, as a lib/msgfmt/testdata/format case. Returns 409 if the fixture exists, unless overwrite is set.",
+		Hidden:      true,
+	}, s.saveScreen)
+
+	huma.Get(s.api, "/sessions", s.listSessions, func(o *huma.Operation) {
+		o.Description = "Lists the sessions the server hosts, each with its own agent, when it was started with --session or --session-start. The API of a session is served under /sessions/{id}, e.g. GET /sessions/{id}/status, GET /sessions/{id}/messages and GET /sessions/{id}/events. Filter them by tag with e.g. ?tag=project=billing, and sort them with ?sort=status, last_activity or tag:<key>. Returns 404 unless the server hosts several sessions."
+	})
+	huma.Post(s.api, "/sessions", s.startSession, func(o *huma.Operation) {
+		o.Description = "Starts a session running an agent, when the server was started with --session-start. The agent runs in a terminal like the one the server was started with. With a ttl, the session is stopped once it expires and archived read-only. Returns 409 if the ID is used, and 503 if --max-sessions sessions are running or their agents use --max-total-memory."
+		o.DefaultStatus = http.StatusCreated
+	})
+	huma.Patch(s.api, "/sessions/{id}", s.updateSession, func(o *huma.Operation) {
+		o.Description = "Sets or removes tags of a session, which are reported by its GET /status. The 'session' tag, the session's ID, can't be changed."
+	})
+	huma.Delete(s.api, "/sessions/{id}", s.stopSession, func(o *huma.Operation) {
+		o.Description = "Stops a session and its agent, saving its state first if the server persists it, or removes an archived session. The default session is stopped with the server, and returns 409."
+		o.DefaultStatus = http.StatusNoContent
+	})
+	s.router.Handle("/sessions/{id}/*", http.HandlerFunc(s.serveSession))
+
+	// GET /metrics endpoint (Prometheus text format, not part of the OpenAPI schema)
+	s.router.Handle("/metrics", s.metricsHandler())
+
 	s.router.Handle("/", http.HandlerFunc(s.redirectToChat))
 
 	// Serve static files for the chat interface under /chat
@@ -436,7 +1015,7 @@ func (s *Server) registerRoutes() {
 }
 
 // getStatus handles GET /status
-func (s *Server) getStatus(ctx context.Context, input *struct{}) (*StatusResponse, error) {
+func (s *Server) getStatus(ctx context.Context, input *StatusRequest) (*StatusResponse, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -445,48 +1024,212 @@ func (s *Server) getStatus(ctx context.Context, input *struct{}) (*StatusRespons
 
 	resp := &StatusResponse{}
 	resp.Body.Status = agentStatus
+	resp.Body.ProcessState = s.processState(status)
+	resp.Body.ConversationState = s.conversationState(status)
+	if reporter, ok := s.conversation.(st.QueueReporter); ok {
+		resp.Body.QueueDepth = reporter.QueueDepth()
+	}
 	resp.Body.AgentType = s.agentType
 	resp.Body.Transport = s.transport
+	if percent, ok := s.emitter.ContextUsedPercent(); ok {
+		resp.Body.ContextUsedPercent = &percent
+	}
+	if banner, ok := s.emitter.StartupBanner(); ok {
+		resp.Body.Banner = convertStartupBanner(banner)
+	}
+	resp.Body.Tags = s.tags
+	if signals, ok := s.agentio.(terminalSignals); ok {
+		resp.Body.Title = signals.Title()
+	}
+	resp.Body.Sandbox = s.sandbox
+	resp.Body.ConfiguredMCPServers = s.configuredMCPServers
+	resp.Body.MCPServers = s.mcpServers()
+	_, resp.Body.Draining = s.draining()
+	if takeover, ok := s.takenOver(); ok {
+		resp.Body.TakenOver = &takeover
+	}
+	resp.ETag = contentETag("", resp.Body)
+	if err := checkIfNoneMatch(input.IfNoneMatch, resp.ETag); err != nil {
+		return nil, err
+	}
 
 	return resp, nil
 }
 
+// AgentExited records that the agent's process exited, which GET /status
+// reports until the server stops.
+func (s *Server) AgentExited() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agentExited = true
+}
+
+// processState is the state of the agent's process. The agent is starting
+// until the conversation is initialized. The caller must hold s.mu.
+func (s *Server) processState(status st.ConversationStatus) ProcessState {
+	switch {
+	case s.agentExited:
+		return ProcessStateExited
+	case status == st.ConversationStatusInitializing:
+		return ProcessStateStarting
+	default:
+		return ProcessStateRunning
+	}
+}
+
+// conversationState tells apart the agent waiting for the next message
+// from the agent waiting on an interactive prompt, which the legacy status
+// both reports as stable.
+func (s *Server) conversationState(status st.ConversationStatus) ConversationState {
+	if status == st.ConversationStatusInitializing {
+		return ConversationStateInitializing
+	}
+	if _, ok := s.emitter.PendingPrompt(); ok {
+		return ConversationStateWaitingInput
+	}
+	if status == st.ConversationStatusStable {
+		return ConversationStateIdle
+	}
+	return ConversationStateGenerating
+}
+
 // getMessages handles GET /messages
-func (s *Server) getMessages(ctx context.Context, input *struct{}) (*MessagesResponse, error) {
+func (s *Server) getMessages(ctx context.Context, input *MessagesRequest) (*MessagesResponse, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	includeDebug := slices.Contains(input.Include, "debug")
+	if includeDebug && !s.debugMessages {
+		return nil, huma.Error400BadRequest("debug information isn't recorded, start the server with --debug-messages")
+	}
+
+	end := math.MaxInt
+	if input.BeforeId > 0 {
+		end = input.BeforeId
+	}
+	start := 0
+	if input.Limit > 0 {
+		if end == math.MaxInt {
+			_, end, _ = st.ReadMessageRange(s.conversation, math.MaxInt, math.MaxInt)
+		}
+		start = max(0, end-input.Limit)
+	}
+	messages, _, err := st.ReadMessageRange(s.conversation, start, end)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read messages: %w", err)
+	}
+
 	resp := &MessagesResponse{}
-	resp.Body.Messages = make([]Message, len(s.conversation.Messages()))
-	for i, msg := range s.conversation.Messages() {
-		resp.Body.Messages[i] = Message{
-			Id:      msg.Id,
-			Role:    msg.Role,
-			Content: msg.Message,
-			Time:    msg.Time,
+	resp.Body.HasMore = start > 0
+	resp.Body.Messages = make([]Message, len(messages))
+	for i, msg := range messages {
+		resp.Body.Messages[i] = s.convertMessage(msg)
+		if includeDebug {
+			resp.Body.Messages[i].Debug = convertMessageDebug(msg.Debug)
 		}
 	}
+	resp.ETag = s.messagesETag(resp.Body)
+	if err := checkIfNoneMatch(input.IfNoneMatch, resp.ETag); err != nil {
+		return nil, err
+	}
 
 	return resp, nil
 }
 
+func (s *Server) convertMessage(msg st.ConversationMessage) Message {
+	return Message{
+		EstimatedTokens: s.tokenizer.CountTokens(msg.Message),
+		Id:              msg.Id,
+		Role:            msg.Role,
+		Content:         msg.Message,
+		Time:            msg.Time,
+		TurnId:          msg.TurnId,
+		Thought:         msg.Thought,
+		Plan:            convertPlan(msg.Plan),
+		Diffs:           convertDiffs(msg.Diffs),
+		StopReason:      msg.StopReason,
+		Filtered:        msg.Filtered,
+		Pinned:          msg.Pinned,
+		Source:          msg.Source,
+		Links:           convertLinks(msg.Links),
+		Markdown:        msg.Markdown,
+		Blocks:          convertBlocks(msg.Blocks),
+		ANSI:            msg.ANSI,
+		HTML:            msg.HTML,
+		Mentions:        s.fileMentions[msg.Id],
+	}
+}
+
+func convertMessageDebug(debug *st.MessageDebug) *MessageDebug {
+	if debug == nil {
+		return nil
+	}
+	return &MessageDebug{
+		ScreenStartLine: debug.ScreenStartLine,
+		ScreenEndLine:   debug.ScreenEndLine,
+		EchoRemoved:     debug.EchoRemoved,
+		Confidence:      debug.Confidence,
+	}
+}
+
 // createMessage handles POST /message
 func (s *Server) createMessage(ctx context.Context, input *MessageRequest) (*MessageResponse, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.checkIfMatch(input.IfMatch); err != nil {
+		return nil, err
+	}
+	resp := &MessageResponse{}
 	switch input.Body.Type {
 	case MessageTypeUser:
-		if err := s.conversation.Send(FormatMessage(s.agentType, input.Body.Content)...); err != nil {
-			return nil, xerrors.Errorf("failed to send message: %w", err)
+		if err := s.checkDraining(); err != nil {
+			return nil, err
+		}
+		if err := s.checkTakeover(); err != nil {
+			return nil, err
 		}
+		if err := s.checkRunLimits(); err != nil {
+			return nil, err
+		}
+		warning, err := s.checkDuplicatePrompt(input.Body)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.checkMessageRate("message"); err != nil {
+			return nil, err
+		}
+		content, mentions, err := s.expandFileMentions(input.Body.Content)
+		if err != nil {
+			return nil, err
+		}
+		references, err := s.attachmentReferences(ctx, input.Body.Attachments)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.conversation.Send(FormatMessage(s.agentType, content+references)...); err != nil {
+			return nil, sendError("message", "body.content", err)
+		}
+		s.messageLimiter.record()
+		s.recordFileMentions(mentions)
+		s.duplicatePrompts.record(input.Body.Content)
+		resp.Body.Warning = warning
 	case MessageTypeRaw:
+		if s.rawMessagesDisabled {
+			return nil, huma.Error403Forbidden("raw messages are disabled when guardrails are configured")
+		}
+		if len(input.Body.Attachments) > 0 {
+			return nil, huma.Error400BadRequest("attachments are only supported on 'user' messages")
+		}
+		// The line is recorded before Enter reaches the agent, so that the
+		// reply is told apart from it.
+		s.recordTerminalInput(input.Body.Content)
 		if _, err := s.agentio.Write([]byte(input.Body.Content)); err != nil {
 			return nil, xerrors.Errorf("failed to send message: %w", err)
 		}
 	}
 
-	resp := &MessageResponse{}
+	resp.ETag = s.conversationETag()
 	resp.Body.Ok = true
 
 	return resp, nil
@@ -577,15 +1320,22 @@ func (s *Server) subscribeEvents(ctx context.Context, input *struct{}, send sse.
 	}
 }
 
-func (s *Server) subscribeScreen(ctx context.Context, input *struct{}, send sse.Sender) {
+func (s *Server) subscribeScreen(ctx context.Context, input *ScreenStreamRequest, send sse.Sender) {
 	subscriberId, ch, stateEvents := s.emitter.Subscribe()
 	defer s.emitter.Unsubscribe(subscriberId)
 	s.logger.Info("New screen subscriber", "subscriberId", subscriberId)
+	stream := &screenStream{
+		send:     send,
+		clock:    s.clock,
+		encoding: input.Encoding,
+		interval: screenInterval(s.screenMaxRate, input.MaxRate),
+	}
+	defer stream.stop()
 	for _, event := range stateEvents {
 		if event.Type != EventTypeScreenUpdate {
 			continue
 		}
-		if err := send.Data(event.Payload); err != nil {
+		if err := stream.write(event.Payload.(ScreenUpdateBody)); err != nil {
 			s.logger.Error("Failed to send screen event", "subscriberId", subscriberId, "error", err)
 			return
 		}
@@ -600,7 +1350,12 @@ func (s *Server) subscribeScreen(ctx context.Context, input *struct{}, send sse.
 			if event.Type != EventTypeScreenUpdate {
 				continue
 			}
-			if err := send.Data(event.Payload); err != nil {
+			if err := stream.update(event.Payload.(ScreenUpdateBody)); err != nil {
+				s.logger.Error("Failed to send screen event", "subscriberId", subscriberId, "error", err)
+				return
+			}
+		case <-stream.due():
+			if err := stream.flush(); err != nil {
 				s.logger.Error("Failed to send screen event", "subscriberId", subscriberId, "error", err)
 				return
 			}
@@ -617,10 +1372,7 @@ func (s *Server) subscribeScreen(ctx context.Context, input *struct{}, send sse.
 // Start starts the HTTP server
 func (s *Server) Start() error {
 	addr := fmt.Sprintf(":%d", s.port)
-	s.srv = &http.Server{
-		Addr:    addr,
-		Handler: s.router,
-	}
+	s.srv = s.newHTTPServer(addr)
 
 	return s.srv.ListenAndServe()
 }
@@ -634,6 +1386,15 @@ func (s *Server) Stop(ctx context.Context) error {
 		// Clean up temporary directory
 		s.cleanupTempDir()
 
+		if s.messageStore != nil {
+			if err := s.messageStore.Close(); err != nil {
+				s.logger.Error("Failed to close message store", "error", err)
+			}
+		}
+
+		s.emitter.flushTranscript()
+		closeTranscriptSinks(s.transcriptSinks, s.logger)
+
 		if s.srv != nil {
 			if err = s.srv.Shutdown(ctx); errors.Is(err, http.ErrServerClosed) {
 				err = nil
@@ -1,7 +1,9 @@
 package httpapi_test
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"io"
@@ -18,6 +20,9 @@ import (
 	"github.com/coder/agentapi/lib/httpapi"
 	"github.com/coder/agentapi/lib/logctx"
 	"github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/transport"
+	"github.com/danielgtaylor/huma/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -106,6 +111,63 @@ func TestServer_redirectToChat(t *testing.T) {
 	}
 }
 
+func TestServer_BasePath(t *testing.T) {
+	t.Parallel()
+
+	tCtx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	s, err := httpapi.NewServer(tCtx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		ChatBasePath:   "/agentapi/chat",
+		BasePath:       "/agentapi/",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(s.Handler())
+	t.Cleanup(tsServer.Close)
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	get := func(t *testing.T, path string) *http.Response {
+		t.Helper()
+		resp, err := client.Get(tsServer.URL + path)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		return resp
+	}
+
+	require.Equal(t, http.StatusOK, get(t, "/agentapi/status").StatusCode)
+	require.Equal(t, http.StatusNotFound, get(t, "/status").StatusCode)
+
+	resp := get(t, "/agentapi")
+	require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	require.Equal(t, "/agentapi/chat/embed", resp.Header.Get("Location"))
+
+	resp = get(t, "/agentapi/openapi.json")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var schema struct {
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&schema))
+	require.Len(t, schema.Servers, 1)
+	require.Equal(t, "/agentapi", schema.Servers[0].URL)
+
+	_, err = httpapi.NewServer(tCtx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		BasePath:       "agentapi",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+	})
+	require.ErrorContains(t, err, "must start with '/'")
+}
+
 func TestServer_AllowedHosts(t *testing.T) {
 	cases := []struct {
 		name               string
@@ -611,6 +673,221 @@ func TestServer_CORSPreflightOrigins(t *testing.T) {
 	}
 }
 
+func TestServer_CORSConfig(t *testing.T) {
+	t.Parallel()
+
+	preflight := func(t *testing.T, cfg httpapi.CORSConfig, privateNetwork bool) *http.Response {
+		t.Helper()
+		ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+		s, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+			AgentType:      msgfmt.AgentTypeClaude,
+			ChatBasePath:   "/chat",
+			AllowedHosts:   []string{"*"},
+			AllowedOrigins: []string{"https://chat.example.com"},
+			CORS:           cfg,
+		})
+		require.NoError(t, err)
+		tsServer := httptest.NewServer(s.Handler())
+		t.Cleanup(tsServer.Close)
+
+		req, err := http.NewRequest("OPTIONS", tsServer.URL+"/message", nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", "https://chat.example.com")
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "X-Request-Id")
+		if privateNetwork {
+			req.Header.Set("Access-Control-Request-Private-Network", "true")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		return resp
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		t.Parallel()
+		resp := preflight(t, httpapi.CORSConfig{}, true)
+		// X-Request-Id isn't allowed by default.
+		require.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+		require.Empty(t, resp.Header.Get("Access-Control-Allow-Private-Network"))
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		t.Parallel()
+		resp := preflight(t, httpapi.CORSConfig{
+			AllowedHeaders:      []string{"Content-Type", "X-Request-Id"},
+			AllowedMethods:      []string{"GET", "POST"},
+			DisallowCredentials: true,
+			MaxAge:              time.Hour,
+			AllowPrivateNetwork: true,
+		}, true)
+		require.Equal(t, "https://chat.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+		require.Equal(t, "X-Request-Id", resp.Header.Get("Access-Control-Allow-Headers"))
+		require.Equal(t, "POST", resp.Header.Get("Access-Control-Allow-Methods"))
+		require.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"))
+		require.Equal(t, "3600", resp.Header.Get("Access-Control-Max-Age"))
+		require.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Private-Network"))
+	})
+
+	t.Run("private network access not requested", func(t *testing.T) {
+		t.Parallel()
+		resp := preflight(t, httpapi.CORSConfig{AllowedHeaders: []string{"X-Request-Id"}, AllowPrivateNetwork: true}, false)
+		require.Equal(t, "https://chat.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+		require.Empty(t, resp.Header.Get("Access-Control-Allow-Private-Network"))
+	})
+}
+
+func TestServer_TrustedProxies(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		forwardedHost  string
+		expectedStatus int
+	}{
+		{name: "trusted proxy, allowed forwarded host", trustedProxies: []string{"127.0.0.1"}, forwardedHost: "agent.example.com", expectedStatus: http.StatusOK},
+		{name: "trusted range, allowed forwarded host", trustedProxies: []string{"127.0.0.0/8"}, forwardedHost: "agent.example.com:443", expectedStatus: http.StatusOK},
+		{name: "appended value is used over the client's", trustedProxies: []string{"127.0.0.1"}, forwardedHost: "agent.example.com, evil.example.com", expectedStatus: http.StatusBadRequest},
+		{name: "client's value followed by the proxy's", trustedProxies: []string{"127.0.0.1"}, forwardedHost: "evil.example.com, agent.example.com", expectedStatus: http.StatusOK},
+		{name: "trusted proxy, disallowed forwarded host", trustedProxies: []string{"127.0.0.1"}, forwardedHost: "evil.example.com", expectedStatus: http.StatusBadRequest},
+		{name: "untrusted proxy", trustedProxies: []string{"10.0.0.0/8"}, forwardedHost: "agent.example.com", expectedStatus: http.StatusBadRequest},
+		{name: "no trusted proxies", forwardedHost: "agent.example.com", expectedStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+			s, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+				AgentType:      msgfmt.AgentTypeClaude,
+				ChatBasePath:   "/chat",
+				AllowedHosts:   []string{"agent.example.com"},
+				AllowedOrigins: []string{"https://agent.example.com"},
+				TrustedProxies: tt.trustedProxies,
+			})
+			require.NoError(t, err)
+			tsServer := httptest.NewServer(s.Handler())
+			t.Cleanup(tsServer.Close)
+
+			req, err := http.NewRequest("GET", tsServer.URL+"/status", nil)
+			require.NoError(t, err)
+			req.Header.Set("X-Forwarded-Host", tt.forwardedHost)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				_ = resp.Body.Close()
+			})
+			require.Equal(t, tt.expectedStatus, resp.StatusCode)
+		})
+	}
+
+	t.Run("header lines appended by the proxy", func(t *testing.T) {
+		t.Parallel()
+		ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+		s, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+			AgentType:      msgfmt.AgentTypeClaude,
+			ChatBasePath:   "/chat",
+			AllowedHosts:   []string{"agent.example.com"},
+			AllowedOrigins: []string{"https://agent.example.com"},
+			TrustedProxies: []string{"127.0.0.1"},
+		})
+		require.NoError(t, err)
+		tsServer := httptest.NewServer(s.Handler())
+		t.Cleanup(tsServer.Close)
+
+		for _, tc := range []struct {
+			hosts  []string
+			status int
+		}{
+			{[]string{"localhost", "evil.example.com"}, http.StatusBadRequest},
+			{[]string{"evil.example.com", "agent.example.com"}, http.StatusOK},
+		} {
+			req, err := http.NewRequest("GET", tsServer.URL+"/status", nil)
+			require.NoError(t, err)
+			for _, host := range tc.hosts {
+				req.Header.Add("X-Forwarded-Host", host)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+			require.Equal(t, tc.status, resp.StatusCode, tc.hosts)
+		}
+	})
+
+	t.Run("invalid trusted proxy", func(t *testing.T) {
+		t.Parallel()
+		ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+		_, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+			AgentType:      msgfmt.AgentTypeClaude,
+			ChatBasePath:   "/chat",
+			AllowedHosts:   []string{"*"},
+			AllowedOrigins: []string{"*"},
+			TrustedProxies: []string{"proxy.example.com"},
+		})
+		require.ErrorContains(t, err, "not a valid IP address or CIDR range")
+	})
+}
+
+func TestServer_Compression(t *testing.T) {
+	t.Parallel()
+
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	s, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:        msgfmt.AgentTypeClaude,
+		ChatBasePath:     "/chat",
+		AllowedHosts:     []string{"*"},
+		AllowedOrigins:   []string{"*"},
+		CompressionLevel: httpapi.DefaultCompressionLevel,
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(s.Handler())
+	t.Cleanup(tsServer.Close)
+
+	get := func(t *testing.T, ctx context.Context, path string, accept string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, "GET", tsServer.URL+path, nil)
+		require.NoError(t, err)
+		// Setting Accept-Encoding stops the client from decompressing.
+		req.Header.Set("Accept-Encoding", "gzip")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		return resp
+	}
+
+	t.Run("messages", func(t *testing.T) {
+		t.Parallel()
+		resp := get(t, context.Background(), "/messages", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		gz, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		var body struct {
+			Messages []any `json:"messages"`
+		}
+		require.NoError(t, json.NewDecoder(gz).Decode(&body))
+	})
+
+	t.Run("events", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		resp := get(t, ctx, "/events", "text/event-stream")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Empty(t, resp.Header.Get("Content-Encoding"))
+		line, err := bufio.NewReader(resp.Body).ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "event: status_change\n", line)
+	})
+}
+
 func TestServer_SSEMiddleware_Events(t *testing.T) {
 	t.Parallel()
 	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
@@ -813,6 +1090,63 @@ func TestServer_UploadFiles(t *testing.T) {
 	}
 }
 
+func TestServer_Attachments(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+
+	do := func(t *testing.T, method, path string, body io.Reader, contentType string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, tsServer.URL+path, body)
+		require.NoError(t, err)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		return resp
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("file", "screenshot.png")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("png data"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	resp := do(t, "POST", "/attachments", &buf, writer.FormDataContentType())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var attachment struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+		Path string `json:"path"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&attachment))
+	require.Equal(t, "screenshot.png", attachment.Name)
+	require.Equal(t, int64(8), attachment.Size)
+	content, err := os.ReadFile(attachment.Path)
+	require.NoError(t, err)
+	require.Equal(t, "png data", string(content))
+
+	require.Equal(t, http.StatusOK, do(t, "GET", "/attachments/"+attachment.Id, nil, "").StatusCode)
+	require.Equal(t, http.StatusNoContent, do(t, "DELETE", "/attachments/"+attachment.Id, nil, "").StatusCode)
+	require.Equal(t, http.StatusNotFound, do(t, "GET", "/attachments/"+attachment.Id, nil, "").StatusCode)
+	require.NoFileExists(t, attachment.Path)
+}
+
 func TestServer_UploadFiles_Errors(t *testing.T) {
 	t.Parallel()
 	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
@@ -990,3 +1324,210 @@ func TestServer_Stop_Idempotency(t *testing.T) {
 	err = srv.Stop(stopCtx3)
 	require.NoError(t, err)
 }
+
+func TestServer_ScreenCapture_Errors(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeClaude,
+		AgentIO:        nil,
+		Port:           0,
+		ChatBasePath:   "/chat",
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+
+	t.Run("raw without agent", func(t *testing.T) {
+		t.Parallel()
+		resp, err := http.Get(tsServer.URL + "/internal/screen/raw?format=ansi")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+
+	t.Run("raw with unknown format", func(t *testing.T) {
+		t.Parallel()
+		resp, err := http.Get(tsServer.URL + "/internal/screen/raw?format=html")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	})
+
+	t.Run("save without fixtures dir", func(t *testing.T) {
+		t.Parallel()
+		resp, err := http.Post(tsServer.URL+"/internal/screen/save", "application/json", strings.NewReader(`{"name":"first_message"}`))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(body), "fixtures directory is not configured")
+	})
+}
+
+func TestServer_MockTransport(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	ctx = logctx.WithLogger(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tr, err := transport.Lookup(string(httpapi.TransportMock))
+	require.NoError(t, err)
+	agent, err := tr.Start(ctx, transport.StartConfig{AgentType: msgfmt.AgentTypeCustom})
+	require.NoError(t, err)
+
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:            msgfmt.AgentTypeCustom,
+		AgentIO:              agent.IO,
+		Transport:            httpapi.TransportMock,
+		Port:                 0,
+		ChatBasePath:         "/chat",
+		AllowedHosts:         []string{"*"},
+		AllowedOrigins:       []string{"*"},
+		Tags:                 map[string]string{"project": "billing"},
+		ConfiguredMCPServers: []string{"docs"},
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+
+	getJSON := func(path string, v any) {
+		resp, err := http.Get(tsServer.URL + path)
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+
+	var status httpapi.StatusResponse
+	require.Eventually(t, func() bool {
+		getJSON("/status", &status.Body)
+		return status.Body.Status == httpapi.AgentStatusStable
+	}, 10*time.Second, 50*time.Millisecond)
+	require.Equal(t, httpapi.TransportMock, status.Body.Transport)
+	require.Equal(t, map[string]string{"project": "billing"}, status.Body.Tags)
+	require.Equal(t, []string{"docs"}, status.Body.ConfiguredMCPServers)
+
+	resp, err := http.Post(tsServer.URL+"/message", "application/json", strings.NewReader(`{"content":"hello there","type":"user"}`))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var messages httpapi.MessagesResponse
+	require.Eventually(t, func() bool {
+		getJSON("/messages", &messages.Body)
+		last := messages.Body.Messages[len(messages.Body.Messages)-1]
+		return last.Role == st.ConversationRoleAgent && strings.Contains(last.Content, "mock: hello there")
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// The mock agent doesn't advertise commands.
+	var commands httpapi.CommandsResponse
+	getJSON("/commands", &commands.Body)
+	require.Empty(t, commands.Body.Commands)
+
+	resp, err = http.Post(tsServer.URL+"/command", "application/json", strings.NewReader(`{"name":"init"}`))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var notes httpapi.NotesResponse
+	getJSON("/notes", &notes.Body)
+	require.Empty(t, notes.Body.Notes)
+
+	req, err := http.NewRequest(http.MethodPut, tsServer.URL+"/notes", strings.NewReader(`{"notes":{"ticket":"ENG-123"}}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	getJSON("/notes", &notes.Body)
+	require.Equal(t, map[string]string{"ticket": "ENG-123"}, notes.Body.Notes)
+}
+
+func TestServer_Guardrail(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	ctx = logctx.WithLogger(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tr, err := transport.Lookup(string(httpapi.TransportMock))
+	require.NoError(t, err)
+	agent, err := tr.Start(ctx, transport.StartConfig{AgentType: msgfmt.AgentTypeCustom})
+	require.NoError(t, err)
+
+	guardrail, err := httpapi.NewGuardrail([]string{`rm\s+-rf`}, []string{`sk-[a-z0-9]+=>[REDACTED]`})
+	require.NoError(t, err)
+	srv, err := httpapi.NewServer(ctx, httpapi.ServerConfig{
+		AgentType:      msgfmt.AgentTypeCustom,
+		AgentIO:        agent.IO,
+		Transport:      httpapi.TransportMock,
+		AllowedHosts:   []string{"*"},
+		AllowedOrigins: []string{"*"},
+		Middleware:     []st.ConversationMiddleware{guardrail},
+	})
+	require.NoError(t, err)
+	tsServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(tsServer.Close)
+
+	post := func(body string) *http.Response {
+		resp, err := http.Post(tsServer.URL+"/message", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			_ = resp.Body.Close()
+		})
+		return resp
+	}
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(tsServer.URL + "/status")
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		var status httpapi.StatusResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&status.Body))
+		return status.Body.Status == httpapi.AgentStatusStable
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// Raw keystrokes would bypass the guardrail.
+	resp := post(`{"content":"rm -rf /\r","type":"raw"}`)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = post(`{"content":"please rm  -rf /","type":"user"}`)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	var problem huma.ErrorModel
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	require.Len(t, problem.Errors, 1)
+	require.Equal(t, "body.content", problem.Errors[0].Location)
+	require.Equal(t, `rm\s+-rf`, problem.Errors[0].Value)
+
+	resp = post(`{"content":"use key sk-abc123","type":"user"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(tsServer.URL + "/messages")
+		require.NoError(t, err)
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		var messages httpapi.MessagesResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&messages.Body))
+		for _, msg := range messages.Body.Messages {
+			require.NotContains(t, msg.Content, "sk-abc123")
+		}
+		last := messages.Body.Messages[len(messages.Body.Messages)-1]
+		return strings.Contains(last.Content, "mock: use key [REDACTED]")
+	}, 10*time.Second, 50*time.Millisecond)
+}
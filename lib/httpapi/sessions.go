@@ -0,0 +1,681 @@
+package httpapi
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/xerrors"
+)
+
+// DefaultSessionID is the ID of the session of the agent the server was
+// started with, whose API is also served at the root.
+const DefaultSessionID = "default"
+
+// sessionIDPattern matches session IDs. They are path segments of the
+// session's routes.
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateSessionID returns an error if id can't be used as a session ID.
+func ValidateSessionID(id string) error {
+	if !sessionIDPattern.MatchString(id) {
+		return xerrors.Errorf("invalid session ID %q: must start with a letter or digit and contain only letters, digits, '.', '_' and '-'", id)
+	}
+	return nil
+}
+
+// sessionsDisabledMessage is returned by the sessions routes when the
+// server hosts a single conversation.
+const sessionsDisabledMessage = "multiple sessions are not enabled, start the server with --session or --session-start"
+
+var (
+	// ErrSessionNotFound is returned by Sessions for unknown IDs.
+	ErrSessionNotFound = xerrors.New("session not found")
+	// ErrSessionExists is returned when starting a session whose ID is used.
+	ErrSessionExists = xerrors.New("session already exists")
+	// ErrSessionNotStoppable is returned when stopping a session that can
+	// only be stopped with the server, such as the default one.
+	ErrSessionNotStoppable = xerrors.New("session can't be stopped")
+	// ErrSessionLimit is returned when starting a session while the
+	// maximum number of sessions run.
+	ErrSessionLimit = xerrors.New("too many sessions")
+	// ErrSessionMemoryLimit is returned when starting a session while the
+	// agents of the sessions use the maximum memory.
+	ErrSessionMemoryLimit = xerrors.New("the sessions use too much memory")
+)
+
+// SessionOptions describes a session to start.
+type SessionOptions struct {
+	// Command is the program running the agent, followed by its arguments.
+	Command []string
+	// Tags are added to those of the server the session is started from.
+	Tags map[string]string
+	// TTL is how long the session runs before it is stopped and archived.
+	// 0 disables it.
+	TTL time.Duration
+}
+
+// SessionStarter starts an agent as described by opts, and returns the
+// server of its conversation and a function stopping both.
+type SessionStarter func(id string, opts SessionOptions) (*Server, func(), error)
+
+// Sessions are the conversations a server hosts, each with its own agent,
+// by session ID. The API of each is served under /sessions/{id}, e.g.
+// /sessions/{id}/messages.
+type Sessions struct {
+	mu      sync.RWMutex
+	servers map[string]*Server
+	stops   map[string]func()
+	// starting reserves the IDs of the sessions being started, or being
+	// archived.
+	starting map[string]bool
+	start    SessionStarter
+	clock    quartz.Clock
+	// expiries are the TTL timers of the sessions started with one.
+	expiries map[string]*sessionExpiry
+	// archived are the sessions stopped because their TTL expired.
+	archived map[string]*archivedSession
+	// maxSessions is the maximum number of sessions, including those being
+	// started, and maxMemory that of the memory their agents use in total.
+	// 0 means no limit.
+	maxSessions int
+	maxMemory   int64
+}
+
+type sessionExpiry struct {
+	timer *quartz.Timer
+	at    time.Time
+}
+
+// archivedSession is what is left of a session once its TTL expired: its
+// status and messages when it was stopped, served read-only.
+type archivedSession struct {
+	info     SessionInfo
+	status   *StatusResponse
+	messages *MessagesResponse
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{
+		servers:  map[string]*Server{},
+		stops:    map[string]func(){},
+		starting: map[string]bool{},
+		clock:    quartz.NewReal(),
+		expiries: map[string]*sessionExpiry{},
+		archived: map[string]*archivedSession{},
+	}
+}
+
+// setClock sets the clock of the sessions' TTLs. NewServer sets it to the
+// clock of the server hosting the sessions.
+func (s *Sessions) setClock(clock quartz.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+// SetStarter allows starting sessions with POST /sessions, with start.
+func (s *Sessions) SetStarter(start SessionStarter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start = start
+}
+
+// SetLimits limits the number of sessions, including the default one,
+// and the memory their agents use in total, which Start allows. 0 means
+// no limit.
+func (s *Sessions) SetLimits(maxSessions int, maxMemory int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxSessions = maxSessions
+	s.maxMemory = maxMemory
+}
+
+// memoryBytes returns the memory the agents of the sessions use in total.
+// Agents running without resource limits, which have no cgroup reporting
+// it, don't count.
+func (s *Sessions) memoryBytes() int64 {
+	s.mu.RLock()
+	servers := slices.Collect(maps.Values(s.servers))
+	s.mu.RUnlock()
+	var total int64
+	for _, srv := range servers {
+		if srv.resourceStats == nil {
+			continue
+		}
+		stats, err := srv.resourceStats()
+		if err != nil {
+			srv.logger.Warn("Failed to read the agent's resource usage", "error", err)
+			continue
+		}
+		total += stats.MemoryBytes
+	}
+	return total
+}
+
+// Add hosts the conversation of srv as the session id. stop is called when
+// the session is stopped with DELETE /sessions/{id} or StopAll. A nil stop
+// makes the session last as long as the server.
+func (s *Sessions) Add(id string, srv *Server, stop func()) error {
+	if err := ValidateSessionID(id); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.usedLocked(id) {
+		return xerrors.Errorf("session %q: %w", id, ErrSessionExists)
+	}
+	s.servers[id] = srv
+	if stop != nil {
+		s.stops[id] = stop
+	}
+	return nil
+}
+
+// usedLocked reports whether the ID of a session, running, being started
+// or archived, is id. Assumes the caller holds the lock.
+func (s *Sessions) usedLocked(id string) bool {
+	_, running := s.servers[id]
+	_, archived := s.archived[id]
+	return running || archived || s.starting[id]
+}
+
+// Start starts the session id with the SessionStarter. Once its TTL
+// expires, the session is stopped like with Stop and archived.
+func (s *Sessions) Start(id string, opts SessionOptions) (*Server, error) {
+	if err := ValidateSessionID(id); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	maxMemory := s.maxMemory
+	s.mu.RUnlock()
+	if maxMemory > 0 && s.memoryBytes() >= maxMemory {
+		return nil, xerrors.Errorf("session %q: %w", id, ErrSessionMemoryLimit)
+	}
+	s.mu.Lock()
+	start := s.start
+	if start == nil {
+		s.mu.Unlock()
+		return nil, xerrors.New("starting sessions is disabled")
+	}
+	if s.usedLocked(id) {
+		s.mu.Unlock()
+		return nil, xerrors.Errorf("session %q: %w", id, ErrSessionExists)
+	}
+	if s.maxSessions > 0 && len(s.servers)+len(s.starting) >= s.maxSessions {
+		s.mu.Unlock()
+		return nil, xerrors.Errorf("session %q: %w", id, ErrSessionLimit)
+	}
+	s.starting[id] = true
+	s.mu.Unlock()
+
+	srv, stop, err := start(id, opts)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.starting, id)
+	if err != nil {
+		return nil, err
+	}
+	if s.start == nil {
+		// StopAll was called while the agent started.
+		stop()
+		return nil, xerrors.New("the server is shutting down")
+	}
+	s.servers[id] = srv
+	s.stops[id] = stop
+	if opts.TTL > 0 {
+		expiry := &sessionExpiry{at: s.clock.Now().Add(opts.TTL)}
+		expiry.timer = s.clock.AfterFunc(opts.TTL, func() { s.expire(id, expiry) }, "sessions", "ttl")
+		s.expiries[id] = expiry
+	}
+	return srv, nil
+}
+
+// expire stops and archives the session id if expiry is still its TTL
+// timer, rather than that of a session with the same ID started after it
+// was stopped.
+func (s *Sessions) expire(id string, expiry *sessionExpiry) {
+	s.mu.Lock()
+	if s.expiries[id] != expiry {
+		s.mu.Unlock()
+		return
+	}
+	srv, stop := s.servers[id], s.stops[id]
+	delete(s.servers, id)
+	delete(s.stops, id)
+	delete(s.expiries, id)
+	// Reserve the ID until the session is archived.
+	s.starting[id] = true
+	s.mu.Unlock()
+
+	// The status and messages are read right before the session's state
+	// is saved and its server stopped.
+	archive := srv.archive(id, expiry.at)
+	stop()
+	srv.logger.Info("Session TTL expired, archived it", "session", id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.starting, id)
+	s.archived[id] = archive
+}
+
+// archive returns the read-only record of srv as the session id, expired
+// at expiredAt.
+func (s *Server) archive(id string, expiredAt time.Time) *archivedSession {
+	ctx := context.Background()
+	info := sessionInfo(id, s)
+	info.ProcessState = ProcessStateExited
+	info.Archived = true
+	info.ExpiresAt = &expiredAt
+	archive := &archivedSession{info: info}
+	var err error
+	if archive.status, err = s.getStatus(ctx, &StatusRequest{}); err != nil {
+		s.logger.Error("Failed to read the status of the expired session", "session", id, "error", err)
+		archive.status = &StatusResponse{}
+	}
+	archive.status.Body.ProcessState = ProcessStateExited
+	if archive.messages, err = s.getMessages(ctx, &MessagesRequest{}); err != nil {
+		s.logger.Error("Failed to read the messages of the expired session", "session", id, "error", err)
+		archive.messages = &MessagesResponse{}
+		archive.messages.Body.Messages = []Message{}
+	}
+	return archive
+}
+
+// Stop stops the session id and stops hosting it. An archived session is
+// removed.
+func (s *Sessions) Stop(id string) error {
+	s.mu.Lock()
+	if _, ok := s.archived[id]; ok {
+		delete(s.archived, id)
+		s.mu.Unlock()
+		return nil
+	}
+	if _, ok := s.servers[id]; !ok {
+		s.mu.Unlock()
+		return xerrors.Errorf("session %q: %w", id, ErrSessionNotFound)
+	}
+	stop, ok := s.stops[id]
+	if !ok {
+		s.mu.Unlock()
+		return xerrors.Errorf("session %q: %w", id, ErrSessionNotStoppable)
+	}
+	delete(s.servers, id)
+	delete(s.stops, id)
+	s.stopExpiryLocked(id)
+	s.mu.Unlock()
+
+	stop()
+	return nil
+}
+
+// stopExpiryLocked stops the TTL timer of the session id. Assumes the
+// caller holds the lock.
+func (s *Sessions) stopExpiryLocked(id string) {
+	if expiry, ok := s.expiries[id]; ok {
+		expiry.timer.Stop()
+		delete(s.expiries, id)
+	}
+}
+
+// StopAll stops the sessions that can be stopped. Sessions can't be
+// started afterwards.
+func (s *Sessions) StopAll() {
+	s.mu.Lock()
+	s.start = nil
+	stops := s.stops
+	for id := range stops {
+		delete(s.servers, id)
+		s.stopExpiryLocked(id)
+	}
+	s.stops = map[string]func(){}
+	s.mu.Unlock()
+
+	for _, stop := range stops {
+		stop()
+	}
+}
+
+// Get returns the server of the session id, or nil if there is none.
+func (s *Sessions) Get(id string) *Server {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.servers[id]
+}
+
+// IDs returns the IDs of the sessions, sorted.
+func (s *Sessions) IDs() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]string, 0, len(s.servers))
+	for id := range s.servers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// getArchived returns the session id if its TTL expired, or nil.
+func (s *Sessions) getArchived(id string) *archivedSession {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.archived[id]
+}
+
+// archivedInfos describes the archived sessions, sorted by ID.
+func (s *Sessions) archivedInfos() []SessionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	infos := make([]SessionInfo, 0, len(s.archived))
+	for _, archive := range s.archived {
+		infos = append(infos, archive.info)
+	}
+	slices.SortFunc(infos, func(a, b SessionInfo) int { return cmp.Compare(a.ID, b.ID) })
+	return infos
+}
+
+// info describes srv as the session id, with when its TTL expires.
+func (s *Sessions) info(id string, srv *Server) SessionInfo {
+	info := sessionInfo(id, srv)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if expiry, ok := s.expiries[id]; ok {
+		at := expiry.at
+		info.ExpiresAt = &at
+	}
+	return info
+}
+
+// sessionInfo describes srv as the session id.
+func sessionInfo(id string, srv *Server) SessionInfo {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	status := srv.conversation.Status()
+	lastActivity := srv.runStart
+	if latest, ok := srv.latestMessage(); ok {
+		lastActivity = latest.Time
+	}
+	return SessionInfo{
+		ID:                id,
+		AgentType:         srv.agentType,
+		Status:            convertStatus(status),
+		ProcessState:      srv.processState(status),
+		ConversationState: srv.conversationState(status),
+		Tags:              srv.tags,
+		LastActivity:      lastActivity,
+	}
+}
+
+// parseTagFilters parses the tag filters of GET /sessions, "key=value".
+func parseTagFilters(filters []string) (map[string]string, error) {
+	tags := make(map[string]string, len(filters))
+	for i, filter := range filters {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok || key == "" {
+			return nil, huma.Error422UnprocessableEntity(fmt.Sprintf("invalid tag filter %q, expected key=value", filter), &huma.ErrorDetail{
+				Location: fmt.Sprintf("query.tag[%d]", i),
+				Value:    filter,
+			})
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// sessionTagSortPrefix prefixes the tag key of sort=tag:<key>.
+const sessionTagSortPrefix = "tag:"
+
+// sortSessions sorts sessions, already sorted by ID, as GET /sessions'
+// sort parameter asks. Sessions that compare equal stay sorted by ID.
+func sortSessions(sessions []SessionInfo, by string) error {
+	var compare func(a, b SessionInfo) int
+	switch {
+	case by == "":
+		return nil
+	case by == "status":
+		compare = func(a, b SessionInfo) int { return cmp.Compare(a.Status, b.Status) }
+	case by == "last_activity":
+		// The most recently active sessions come first.
+		compare = func(a, b SessionInfo) int { return b.LastActivity.Compare(a.LastActivity) }
+	case strings.HasPrefix(by, sessionTagSortPrefix) && len(by) > len(sessionTagSortPrefix):
+		key := strings.TrimPrefix(by, sessionTagSortPrefix)
+		// Sessions without the tag come last.
+		compare = func(a, b SessionInfo) int {
+			av, aok := a.Tags[key]
+			bv, bok := b.Tags[key]
+			if aok != bok {
+				if aok {
+					return -1
+				}
+				return 1
+			}
+			return cmp.Compare(av, bv)
+		}
+	default:
+		return huma.Error422UnprocessableEntity(fmt.Sprintf("invalid sort %q, expected status, last_activity or tag:<key>", by), &huma.ErrorDetail{
+			Location: "query.sort",
+			Value:    by,
+		})
+	}
+	slices.SortStableFunc(sessions, compare)
+	return nil
+}
+
+func (s *Server) checkSessions() error {
+	if s.sessions == nil {
+		return huma.Error404NotFound(sessionsDisabledMessage)
+	}
+	return nil
+}
+
+// listSessions handles GET /sessions.
+func (s *Server) listSessions(ctx context.Context, input *SessionsRequest) (*SessionsResponse, error) {
+	if err := s.checkSessions(); err != nil {
+		return nil, err
+	}
+	filters, err := parseTagFilters(input.Tags)
+	if err != nil {
+		return nil, err
+	}
+	resp := &SessionsResponse{}
+	resp.Body.Sessions = []SessionInfo{}
+	var infos []SessionInfo
+	for _, id := range s.sessions.IDs() {
+		srv := s.sessions.Get(id)
+		if srv == nil {
+			continue
+		}
+		infos = append(infos, s.sessions.info(id, srv))
+	}
+	// Archived sessions are listed among the others, by ID.
+	infos = append(infos, s.sessions.archivedInfos()...)
+	slices.SortStableFunc(infos, func(a, b SessionInfo) int { return cmp.Compare(a.ID, b.ID) })
+sessions:
+	for _, info := range infos {
+		for key, value := range filters {
+			if tag, ok := info.Tags[key]; !ok || tag != value {
+				continue sessions
+			}
+		}
+		resp.Body.Sessions = append(resp.Body.Sessions, info)
+	}
+	if err := sortSessions(resp.Body.Sessions, input.Sort); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// startSession handles POST /sessions.
+func (s *Server) startSession(ctx context.Context, input *StartSessionRequest) (*SessionResponse, error) {
+	if err := s.checkSessions(); err != nil {
+		return nil, err
+	}
+	s.sessions.mu.RLock()
+	enabled := s.sessions.start != nil
+	s.sessions.mu.RUnlock()
+	if !enabled {
+		return nil, huma.Error404NotFound("starting sessions is disabled, enable it with --session-start")
+	}
+	if err := ValidateSessionID(input.Body.ID); err != nil {
+		return nil, huma.Error422UnprocessableEntity(err.Error(), &huma.ErrorDetail{
+			Location: "body.id",
+			Value:    input.Body.ID,
+		})
+	}
+	if strings.TrimSpace(input.Body.Command[0]) == "" {
+		return nil, huma.Error422UnprocessableEntity("the command's program is empty", &huma.ErrorDetail{
+			Location: "body.command[0]",
+			Value:    input.Body.Command[0],
+		})
+	}
+	var ttl time.Duration
+	if input.Body.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(input.Body.TTL); err != nil || ttl <= 0 {
+			return nil, huma.Error422UnprocessableEntity(fmt.Sprintf("invalid ttl %q, expected a positive duration such as 8h", input.Body.TTL), &huma.ErrorDetail{
+				Location: "body.ttl",
+				Value:    input.Body.TTL,
+			})
+		}
+	}
+	srv, err := s.sessions.Start(input.Body.ID, SessionOptions{Command: input.Body.Command, Tags: input.Body.Tags, TTL: ttl})
+	if errors.Is(err, ErrSessionExists) {
+		return nil, huma.Error409Conflict(fmt.Sprintf("session %q already exists", input.Body.ID))
+	}
+	if errors.Is(err, ErrSessionLimit) {
+		s.metrics.Inc("agentapi_session_limit_rejections_total", "Sessions not started because of --max-sessions or --max-total-memory, by limit.",
+			"limit", "sessions")
+		return nil, huma.Error503ServiceUnavailable("the maximum number of sessions are running, stop one first")
+	}
+	if errors.Is(err, ErrSessionMemoryLimit) {
+		s.metrics.Inc("agentapi_session_limit_rejections_total", "Sessions not started because of --max-sessions or --max-total-memory, by limit.",
+			"limit", "memory")
+		return nil, huma.Error503ServiceUnavailable("the agents of the sessions use --max-total-memory, stop one first")
+	}
+	if err != nil {
+		return nil, huma.Error500InternalServerError("failed to start session", err)
+	}
+	s.logger.Info("Started session over the API", "session", input.Body.ID)
+	return &SessionResponse{Body: s.sessions.info(input.Body.ID, srv)}, nil
+}
+
+// stopSession handles DELETE /sessions/{id}.
+func (s *Server) stopSession(ctx context.Context, input *SessionRequest) (*struct{}, error) {
+	if err := s.checkSessions(); err != nil {
+		return nil, err
+	}
+	err := s.sessions.Stop(input.Id)
+	switch {
+	case errors.Is(err, ErrSessionNotFound):
+		return nil, huma.Error404NotFound(fmt.Sprintf("session %q not found", input.Id))
+	case errors.Is(err, ErrSessionNotStoppable):
+		return nil, huma.Error409Conflict(fmt.Sprintf("session %q can only be stopped with the server", input.Id))
+	case err != nil:
+		return nil, huma.Error500InternalServerError("failed to stop session", err)
+	}
+	s.logger.Info("Stopped session over the API", "session", input.Id)
+	return nil, nil
+}
+
+// updateSession handles PATCH /sessions/{id}.
+func (s *Server) updateSession(ctx context.Context, input *UpdateSessionRequest) (*SessionResponse, error) {
+	if err := s.checkSessions(); err != nil {
+		return nil, err
+	}
+	if s.sessions.getArchived(input.Id) != nil {
+		return nil, huma.Error409Conflict(fmt.Sprintf("session %q expired and is archived read-only", input.Id))
+	}
+	srv := s.sessions.Get(input.Id)
+	if srv == nil {
+		return nil, huma.Error404NotFound(fmt.Sprintf("session %q not found", input.Id))
+	}
+	if _, ok := input.Body.Tags["session"]; ok || slices.Contains(input.Body.RemoveTags, "session") {
+		return nil, huma.Error422UnprocessableEntity("the session tag is the session's ID and can't be changed")
+	}
+	srv.updateTags(input.Body.Tags, input.Body.RemoveTags)
+	return &SessionResponse{Body: s.sessions.info(input.Id, srv)}, nil
+}
+
+// updateTags sets tags and removes the tags with the remove keys. The tags
+// are replaced rather than modified, since responses hold on to them.
+func (s *Server) updateTags(tags map[string]string, remove []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	updated := maps.Clone(s.tags)
+	if updated == nil {
+		updated = map[string]string{}
+	}
+	for _, key := range remove {
+		delete(updated, key)
+	}
+	maps.Copy(updated, tags)
+	s.tags = updated
+}
+
+// serveSession handles the requests under /sessions/{id} with the API of
+// the session's server, which routes them by the rest of the path.
+func (s *Server) serveSession(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if archive := s.sessions.getArchived(id); archive != nil {
+		archive.serveHTTP(w, r, "/"+chi.URLParam(r, "*"))
+		return
+	}
+	srv := s.sessions.Get(id)
+	if srv == nil {
+		message := fmt.Sprintf("session %q not found", id)
+		if s.sessions == nil {
+			message = sessionsDisabledMessage
+		}
+		http.Error(w, message, http.StatusNotFound)
+		return
+	}
+	chi.RouteContext(r.Context()).RoutePath = "/" + chi.URLParam(r, "*")
+	srv.handler.ServeHTTP(w, r)
+}
+
+// serveHTTP serves GET /status and GET /messages of the archived session,
+// at path, as they were when it expired. The session can't be changed.
+func (a *archivedSession) serveHTTP(w http.ResponseWriter, r *http.Request, path string) {
+	var body any
+	switch path {
+	case "/status":
+		body = a.status.Body
+	case "/messages":
+		body = a.messages.Body
+	default:
+		http.Error(w, fmt.Sprintf("session %q expired and is archived, only GET /status and GET /messages are served", a.info.ID), http.StatusGone)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, fmt.Sprintf("session %q expired and is archived read-only", a.info.ID), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		http.Error(w, "failed to encode the archived session", http.StatusInternalServerError)
+	}
+}
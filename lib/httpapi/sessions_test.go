@@ -0,0 +1,385 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/logctx"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/coder/agentapi/lib/transport"
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	newServer := func(agentType mf.AgentType, sessions *Sessions) *Server {
+		t.Helper()
+		s, err := NewServer(ctx, ServerConfig{
+			AgentType:      agentType,
+			ChatBasePath:   "/chat",
+			AllowedHosts:   []string{"*"},
+			AllowedOrigins: []string{"*"},
+			Sessions:       sessions,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = s.Stop(context.Background()) })
+		return s
+	}
+	do := func(s *Server, method, path, reqBody string, body any) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		s.Handler().ServeHTTP(rec, req)
+		if body != nil && rec.Code < 300 {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), body))
+		}
+		return rec.Code
+	}
+	get := func(s *Server, path string, body any) int {
+		t.Helper()
+		return do(s, http.MethodGet, path, "", body)
+	}
+
+	sessions := NewSessions()
+	s := newServer(mf.AgentTypeClaude, sessions)
+	require.NoError(t, sessions.Add(DefaultSessionID, s, nil))
+	require.NoError(t, sessions.Add("review", newServer(mf.AgentTypeAider, nil), nil))
+	require.ErrorIs(t, sessions.Add("review", s, nil), ErrSessionExists)
+	require.Error(t, sessions.Add("../review", s, nil))
+
+	var list struct {
+		Sessions []SessionInfo `json:"sessions"`
+	}
+	require.Equal(t, http.StatusOK, get(s, "/sessions", &list))
+	require.Len(t, list.Sessions, 2)
+	assert.Equal(t, DefaultSessionID, list.Sessions[0].ID)
+	assert.Equal(t, mf.AgentTypeClaude, list.Sessions[0].AgentType)
+	assert.Equal(t, "review", list.Sessions[1].ID)
+	assert.Equal(t, mf.AgentTypeAider, list.Sessions[1].AgentType)
+
+	// Each session's API is served under its ID.
+	var status struct {
+		AgentType mf.AgentType `json:"agent_type"`
+	}
+	require.Equal(t, http.StatusOK, get(s, "/sessions/review/status", &status))
+	assert.Equal(t, mf.AgentTypeAider, status.AgentType)
+	require.Equal(t, http.StatusOK, get(s, "/sessions/default/status", &status))
+	assert.Equal(t, mf.AgentTypeClaude, status.AgentType)
+	require.Equal(t, http.StatusOK, get(s, "/sessions/review/messages", nil))
+	assert.Equal(t, http.StatusNotFound, get(s, "/sessions/missing/status", nil))
+
+	// Sessions are only started at runtime with --session-start.
+	const startBody = `{"id": "fix", "command": ["aider", "--model", "sonnet"]}`
+	assert.Equal(t, http.StatusNotFound, do(s, http.MethodPost, "/sessions", startBody, nil))
+
+	var started [][]string
+	stopped := 0
+	sessions.SetStarter(func(id string, opts SessionOptions) (*Server, func(), error) {
+		started = append(started, opts.Command)
+		return newServer(mf.AgentTypeAider, nil), func() { stopped++ }, nil
+	})
+	var info SessionInfo
+	require.Equal(t, http.StatusCreated, do(s, http.MethodPost, "/sessions", startBody, &info))
+	assert.Equal(t, "fix", info.ID)
+	assert.Equal(t, mf.AgentTypeAider, info.AgentType)
+	assert.Equal(t, [][]string{{"aider", "--model", "sonnet"}}, started)
+	assert.Equal(t, http.StatusConflict, do(s, http.MethodPost, "/sessions", startBody, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, do(s, http.MethodPost, "/sessions", `{"id": "../fix", "command": ["aider"]}`, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, do(s, http.MethodPost, "/sessions", `{"id": "other", "command": []}`, nil))
+	require.Equal(t, http.StatusOK, get(s, "/sessions/fix/status", nil))
+
+	// The default session counts towards the limit.
+	sessions.SetLimits(3, 0)
+	assert.Equal(t, http.StatusServiceUnavailable, do(s, http.MethodPost, "/sessions", `{"id": "other", "command": ["aider"]}`, nil))
+	assert.Len(t, started, 1)
+	sessions.SetLimits(0, 0)
+
+	// The default session lasts as long as the server.
+	assert.Equal(t, http.StatusConflict, do(s, http.MethodDelete, "/sessions/default", "", nil))
+	assert.Equal(t, http.StatusNoContent, do(s, http.MethodDelete, "/sessions/fix", "", nil))
+	assert.Equal(t, 1, stopped)
+	assert.Equal(t, http.StatusNotFound, get(s, "/sessions/fix/status", nil))
+	assert.Equal(t, http.StatusNotFound, do(s, http.MethodDelete, "/sessions/fix", "", nil))
+
+	require.Equal(t, http.StatusCreated, do(s, http.MethodPost, "/sessions", startBody, nil))
+	sessions.StopAll()
+	assert.Equal(t, 2, stopped)
+	assert.Equal(t, []string{DefaultSessionID, "review"}, sessions.IDs())
+	assert.Equal(t, http.StatusNotFound, do(s, http.MethodPost, "/sessions", startBody, nil))
+
+	// The routes are disabled unless the server hosts sessions.
+	single := newServer(mf.AgentTypeClaude, nil)
+	assert.Equal(t, http.StatusNotFound, get(single, "/sessions", nil))
+	assert.Equal(t, http.StatusNotFound, get(single, "/sessions/default/status", nil))
+	assert.Equal(t, http.StatusNotFound, do(single, http.MethodPost, "/sessions", startBody, nil))
+}
+
+func TestSessions_Tags(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	clock := quartz.NewMock(t)
+
+	newServer := func(tags map[string]string, sessions *Sessions) *Server {
+		t.Helper()
+		s, err := NewServer(ctx, ServerConfig{
+			AgentType:      mf.AgentTypeClaude,
+			ChatBasePath:   "/chat",
+			AllowedHosts:   []string{"*"},
+			AllowedOrigins: []string{"*"},
+			Sessions:       sessions,
+			Tags:           tags,
+			Clock:          clock,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = s.Stop(context.Background()) })
+		return s
+	}
+	do := func(s *Server, method, path, reqBody string, body any) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		s.Handler().ServeHTTP(rec, req)
+		if body != nil && rec.Code < 300 {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), body))
+		}
+		return rec.Code
+	}
+	list := func(s *Server, query string) []string {
+		t.Helper()
+		var resp struct {
+			Sessions []SessionInfo `json:"sessions"`
+		}
+		require.Equal(t, http.StatusOK, do(s, http.MethodGet, "/sessions"+query, "", &resp))
+		ids := []string{}
+		for _, info := range resp.Sessions {
+			ids = append(ids, info.ID)
+		}
+		return ids
+	}
+
+	sessions := NewSessions()
+	s := newServer(nil, sessions)
+	require.NoError(t, sessions.Add(DefaultSessionID, s, nil))
+	sessions.SetStarter(func(id string, opts SessionOptions) (*Server, func(), error) {
+		tags := maps.Clone(opts.Tags)
+		tags["session"] = id
+		return newServer(tags, nil), func() {}, nil
+	})
+
+	// Sessions are started a minute apart, which is their last activity
+	// until they have messages.
+	clock.Advance(time.Minute)
+	var info SessionInfo
+	require.Equal(t, http.StatusCreated, do(s, http.MethodPost, "/sessions",
+		`{"id": "web", "command": ["aider"], "tags": {"project": "web"}}`, &info))
+	assert.Equal(t, map[string]string{"project": "web", "session": "web"}, info.Tags)
+	clock.Advance(time.Minute)
+	require.Equal(t, http.StatusCreated, do(s, http.MethodPost, "/sessions",
+		`{"id": "billing", "command": ["aider"], "tags": {"project": "billing"}}`, nil))
+
+	assert.Equal(t, []string{"billing"}, list(s, "?tag=project=billing"))
+	assert.Equal(t, []string{}, list(s, "?tag=project=billing&tag=session=web"))
+	assert.Equal(t, http.StatusUnprocessableEntity, do(s, http.MethodGet, "/sessions?tag=project", "", nil))
+
+	assert.Equal(t, []string{"billing", DefaultSessionID, "web"}, list(s, ""))
+	assert.Equal(t, []string{"billing", "web", DefaultSessionID}, list(s, "?sort=last_activity"))
+	assert.Equal(t, []string{"billing", "web", DefaultSessionID}, list(s, "?sort=tag:project"))
+	assert.Equal(t, []string{"billing", DefaultSessionID, "web"}, list(s, "?sort=status"))
+	assert.Equal(t, http.StatusUnprocessableEntity, do(s, http.MethodGet, "/sessions?sort=age", "", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, do(s, http.MethodGet, "/sessions?sort=tag:", "", nil))
+
+	// Tags can be changed after the session started.
+	patch := func(id, body string) map[string]string {
+		t.Helper()
+		var info SessionInfo
+		require.Equal(t, http.StatusOK, do(s, http.MethodPatch, "/sessions/"+id, body, &info))
+		return info.Tags
+	}
+	assert.Equal(t, map[string]string{"project": "api", "owner": "alice", "session": "web"},
+		patch("web", `{"tags": {"project": "api", "owner": "alice"}}`))
+	assert.Equal(t, map[string]string{"project": "api", "session": "web"}, patch("web", `{"remove_tags": ["owner"]}`))
+	assert.Equal(t, []string{"web", "billing", DefaultSessionID}, list(s, "?sort=tag:project"))
+	var status struct {
+		Tags map[string]string `json:"tags"`
+	}
+	require.Equal(t, http.StatusOK, do(s, http.MethodGet, "/sessions/web/status", "", &status))
+	assert.Equal(t, map[string]string{"project": "api", "session": "web"}, status.Tags)
+	assert.Equal(t, map[string]string{"project": "core"}, patch(DefaultSessionID, `{"tags": {"project": "core"}}`))
+	assert.Equal(t, http.StatusUnprocessableEntity, do(s, http.MethodPatch, "/sessions/web", `{"tags": {"session": "other"}}`, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, do(s, http.MethodPatch, "/sessions/web", `{"remove_tags": ["session"]}`, nil))
+	assert.Equal(t, http.StatusNotFound, do(s, http.MethodPatch, "/sessions/missing", `{"tags": {"a": "b"}}`, nil))
+}
+
+func TestSessions_TTL(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	clock := quartz.NewMock(t)
+
+	newServer := func(sessions *Sessions) *Server {
+		t.Helper()
+		s, err := NewServer(ctx, ServerConfig{
+			AgentType:      mf.AgentTypeClaude,
+			ChatBasePath:   "/chat",
+			AllowedHosts:   []string{"*"},
+			AllowedOrigins: []string{"*"},
+			Sessions:       sessions,
+			Clock:          clock,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = s.Stop(context.Background()) })
+		return s
+	}
+	do := func(s *Server, method, path, reqBody string, body any) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		s.Handler().ServeHTTP(rec, req)
+		if body != nil && rec.Code < 300 {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), body))
+		}
+		return rec.Code
+	}
+	list := func(s *Server) []SessionInfo {
+		t.Helper()
+		var resp struct {
+			Sessions []SessionInfo `json:"sessions"`
+		}
+		require.Equal(t, http.StatusOK, do(s, http.MethodGet, "/sessions", "", &resp))
+		return resp.Sessions
+	}
+
+	sessions := NewSessions()
+	s := newServer(sessions)
+	require.NoError(t, sessions.Add(DefaultSessionID, s, nil))
+	var stopped []string
+	sessions.SetStarter(func(id string, opts SessionOptions) (*Server, func(), error) {
+		return newServer(nil), func() { stopped = append(stopped, id) }, nil
+	})
+
+	var info SessionInfo
+	require.Equal(t, http.StatusCreated, do(s, http.MethodPost, "/sessions",
+		`{"id": "billing", "command": ["aider"], "ttl": "1h"}`, &info))
+	require.NotNil(t, info.ExpiresAt)
+	assert.Equal(t, clock.Now().Add(time.Hour), *info.ExpiresAt)
+	assert.False(t, info.Archived)
+	require.Equal(t, http.StatusCreated, do(s, http.MethodPost, "/sessions",
+		`{"id": "web", "command": ["aider"]}`, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, do(s, http.MethodPost, "/sessions",
+		`{"id": "other", "command": ["aider"], "ttl": "soon"}`, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, do(s, http.MethodPost, "/sessions",
+		`{"id": "other", "command": ["aider"], "ttl": "-1h"}`, nil))
+	assert.Nil(t, list(s)[2].ExpiresAt)
+
+	// Once its TTL expires, the session is stopped and archived read-only.
+	clock.Advance(time.Hour).MustWait(ctx)
+	assert.Equal(t, []string{"billing"}, stopped)
+	infos := list(s)
+	require.Len(t, infos, 3)
+	assert.Equal(t, "billing", infos[0].ID)
+	assert.True(t, infos[0].Archived)
+	assert.Equal(t, ProcessStateExited, infos[0].ProcessState)
+	var status struct {
+		ProcessState ProcessState `json:"process_state"`
+	}
+	require.Equal(t, http.StatusOK, do(s, http.MethodGet, "/sessions/billing/status", "", &status))
+	assert.Equal(t, ProcessStateExited, status.ProcessState)
+	var messages struct {
+		Messages []Message `json:"messages"`
+	}
+	require.Equal(t, http.StatusOK, do(s, http.MethodGet, "/sessions/billing/messages", "", &messages))
+	assert.NotNil(t, messages.Messages)
+	assert.Equal(t, http.StatusMethodNotAllowed, do(s, http.MethodPost, "/sessions/billing/messages", "", nil))
+	assert.Equal(t, http.StatusGone, do(s, http.MethodPost, "/sessions/billing/message", `{"content": "hi", "type": "user"}`, nil))
+	assert.Equal(t, http.StatusConflict, do(s, http.MethodPatch, "/sessions/billing", `{"tags": {"a": "b"}}`, nil))
+	assert.Equal(t, http.StatusConflict, do(s, http.MethodPost, "/sessions", `{"id": "billing", "command": ["aider"]}`, nil))
+
+	// Deleting the archive frees its ID.
+	assert.Equal(t, http.StatusNoContent, do(s, http.MethodDelete, "/sessions/billing", "", nil))
+	assert.Equal(t, http.StatusNotFound, do(s, http.MethodGet, "/sessions/billing/status", "", nil))
+	assert.Len(t, list(s), 2)
+	require.Equal(t, http.StatusCreated, do(s, http.MethodPost, "/sessions", `{"id": "billing", "command": ["aider"], "ttl": "1h"}`, nil))
+
+	// Stopped sessions don't expire.
+	assert.Equal(t, http.StatusNoContent, do(s, http.MethodDelete, "/sessions/billing", "", nil))
+	clock.Advance(time.Hour).MustWait(ctx)
+	assert.Equal(t, []string{"billing", "billing"}, stopped)
+	assert.Len(t, list(s), 2)
+	sessions.StopAll()
+	assert.Equal(t, []string{"billing", "billing", "web"}, stopped)
+}
+
+func TestSessions_Limits(t *testing.T) {
+	t.Parallel()
+	ctx := logctx.WithLogger(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	clock := quartz.NewMock(t)
+	limiter := NewMessageRateLimiter(clock, 2)
+
+	newServer := func(memory int64, sessions *Sessions) *Server {
+		t.Helper()
+		s, err := NewServer(ctx, ServerConfig{
+			AgentType:          mf.AgentTypeClaude,
+			ChatBasePath:       "/chat",
+			AllowedHosts:       []string{"*"},
+			AllowedOrigins:     []string{"*"},
+			Sessions:           sessions,
+			Clock:              clock,
+			MessageRateLimiter: limiter,
+			ResourceStats: func() (transport.ResourceStats, error) {
+				return transport.ResourceStats{MemoryBytes: memory}, nil
+			},
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = s.Stop(context.Background()) })
+		return s
+	}
+	start := func(s *Server, id string) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/sessions", strings.NewReader(`{"id": "`+id+`", "command": ["aider"]}`))
+		req.Header.Set("Content-Type", "application/json")
+		s.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	sessions := NewSessions()
+	s := newServer(1<<30, sessions)
+	require.NoError(t, sessions.Add(DefaultSessionID, s, nil))
+	sessions.SetStarter(func(id string, opts SessionOptions) (*Server, func(), error) {
+		return newServer(1<<30, nil), func() {}, nil
+	})
+
+	// Agents can be started until those running use the maximum memory.
+	sessions.SetLimits(0, 3<<30)
+	assert.Equal(t, http.StatusCreated, start(s, "one"))
+	assert.Equal(t, http.StatusCreated, start(s, "two"))
+	assert.Equal(t, http.StatusServiceUnavailable, start(s, "three"))
+	require.NoError(t, sessions.Stop("two"))
+	assert.Equal(t, http.StatusCreated, start(s, "three"))
+
+	sessions.SetLimits(3, 0)
+	assert.Equal(t, http.StatusServiceUnavailable, start(s, "four"))
+	assert.Equal(t, float64(1), s.metrics.Value("agentapi_session_limit_rejections_total", "limit", "memory"))
+	assert.Equal(t, float64(1), s.metrics.Value("agentapi_session_limit_rejections_total", "limit", "sessions"))
+
+	// The sessions share the message rate limit.
+	one := sessions.Get("one")
+	require.NoError(t, one.checkMessageRate("user"))
+	one.messageLimiter.record()
+	s.messageLimiter.record()
+	assert.Error(t, sessions.Get("three").checkMessageRate("user"))
+}
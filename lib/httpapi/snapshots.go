@@ -0,0 +1,179 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/xerrors"
+)
+
+// snapshotNamePattern matches the names of state snapshots. It keeps them
+// valid file names in the snapshot directory.
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// snapshotDir is the directory that holds the named snapshots of the state
+// file, next to it.
+func (s *Server) snapshotDir() string {
+	return s.stateFile + ".snapshots"
+}
+
+func (s *Server) snapshotPath(name string) string {
+	return filepath.Join(s.snapshotDir(), name+".json")
+}
+
+// readSnapshot returns the description of a snapshot along with its state.
+func (s *Server) readSnapshot(name string) (StateSnapshot, st.AgentState, error) {
+	path := s.snapshotPath(name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return StateSnapshot{}, st.AgentState{}, xerrors.Errorf("failed to stat snapshot %q: %w", name, err)
+	}
+	state, err := st.ReadAgentState(path)
+	if err != nil {
+		return StateSnapshot{}, st.AgentState{}, xerrors.Errorf("failed to read snapshot %q: %w", name, err)
+	}
+	return StateSnapshot{
+		Name:     name,
+		Time:     info.ModTime(),
+		Messages: len(state.Messages),
+		Bytes:    info.Size(),
+	}, state, nil
+}
+
+// checkSnapshotsEnabled returns an error unless the state is saved to a
+// file, which snapshots are copies of.
+func (s *Server) checkSnapshotsEnabled() error {
+	if s.stateFile == "" || !s.saveState {
+		return huma.Error400BadRequest("state snapshots require --state-file with --save-state")
+	}
+	return nil
+}
+
+// createStateSnapshot handles POST /state/snapshots. The state is saved
+// first, so the snapshot holds the conversation as it is now.
+func (s *Server) createStateSnapshot(ctx context.Context, input *StateSnapshotCreateRequest) (*StateSnapshotResponse, error) {
+	if err := s.checkSnapshotsEnabled(); err != nil {
+		return nil, err
+	}
+	if !snapshotNamePattern.MatchString(input.Body.Name) {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("invalid snapshot name %q", input.Body.Name))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.SaveState("snapshot"); err != nil {
+		return nil, huma.Error500InternalServerError("failed to save state", err)
+	}
+	state, err := st.ReadAgentState(s.stateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, huma.Error409Conflict("there's no state to snapshot yet")
+	}
+	if err != nil {
+		return nil, huma.Error500InternalServerError("failed to read state file", err)
+	}
+	if err := st.WriteAgentState(s.snapshotPath(input.Body.Name), state); err != nil {
+		return nil, huma.Error500InternalServerError("failed to write snapshot", err)
+	}
+	snapshot, _, err := s.readSnapshot(input.Body.Name)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("failed to read snapshot", err)
+	}
+	s.logger.Info("Took state snapshot", "name", snapshot.Name, "messages", snapshot.Messages)
+
+	resp := &StateSnapshotResponse{}
+	resp.Body = snapshot
+	return resp, nil
+}
+
+// getStateSnapshots handles GET /state/snapshots
+func (s *Server) getStateSnapshots(ctx context.Context, input *struct{}) (*StateSnapshotsResponse, error) {
+	if err := s.checkSnapshotsEnabled(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	resp := &StateSnapshotsResponse{}
+	resp.Body.Snapshots = []StateSnapshot{}
+	entries, err := os.ReadDir(s.snapshotDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return resp, nil
+	}
+	if err != nil {
+		return nil, huma.Error500InternalServerError("failed to list snapshots", err)
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() || !snapshotNamePattern.MatchString(name) {
+			continue
+		}
+		snapshot, _, err := s.readSnapshot(name)
+		if err != nil {
+			s.logger.Warn("Skipping unreadable state snapshot", "name", name, "error", err)
+			continue
+		}
+		resp.Body.Snapshots = append(resp.Body.Snapshots, snapshot)
+	}
+	slices.SortStableFunc(resp.Body.Snapshots, func(a, b StateSnapshot) int {
+		return a.Time.Compare(b.Time)
+	})
+	return resp, nil
+}
+
+// restoreStateSnapshot handles POST /state/snapshots/{name}/restore. The
+// conversation is replaced with the snapshot and the state file is saved,
+// so the restored conversation also survives a restart.
+func (s *Server) restoreStateSnapshot(ctx context.Context, input *StateSnapshotRestoreRequest) (*StateSnapshotResponse, error) {
+	if err := s.checkSnapshotsEnabled(); err != nil {
+		return nil, err
+	}
+	if !snapshotNamePattern.MatchString(input.Name) {
+		return nil, huma.Error404NotFound(fmt.Sprintf("snapshot %q not found", input.Name))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	restorer, ok := s.conversation.(st.StateRestorer)
+	if !ok {
+		return nil, huma.Error501NotImplemented("restoring snapshots is not supported by this transport")
+	}
+	if s.conversation.Status() != st.ConversationStatusStable {
+		return nil, huma.Error409Conflict("the agent is still working, wait until it is stable")
+	}
+	snapshot, state, err := s.readSnapshot(input.Name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, huma.Error404NotFound(fmt.Sprintf("snapshot %q not found", input.Name))
+	}
+	if err != nil {
+		return nil, huma.Error500InternalServerError("failed to read snapshot", err)
+	}
+	if err := restorer.RestoreState(state); err != nil {
+		if errors.Is(err, st.ErrStateRestoreUnsupported) {
+			return nil, huma.Error501NotImplemented("restoring snapshots is not supported by this transport")
+		}
+		return nil, huma.Error500InternalServerError("failed to restore snapshot", err)
+	}
+	if err := s.SaveState("snapshot restore"); err != nil {
+		return nil, huma.Error500InternalServerError("failed to save restored state", err)
+	}
+	s.logger.Info("Restored state snapshot", "name", snapshot.Name, "messages", snapshot.Messages)
+	if snapshot.Warnings = s.environment.Differences(state.Environment); len(snapshot.Warnings) > 0 {
+		s.logger.Warn("The snapshot was taken in a different environment, the agent may behave differently", "name", snapshot.Name, "differences", snapshot.Warnings)
+	}
+
+	resp := &StateSnapshotResponse{}
+	resp.Body = snapshot
+	return resp, nil
+}
@@ -0,0 +1,110 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// restorableConversation is a messagesConversation that saves its messages
+// to a state file and can restore them.
+type restorableConversation struct {
+	messagesConversation
+	stateFile string
+}
+
+func (c *restorableConversation) SaveState() error {
+	return st.WriteAgentState(c.stateFile, st.AgentState{Version: 1, Messages: c.messages})
+}
+
+func (c *restorableConversation) RestoreState(state st.AgentState) error {
+	c.messages = state.Messages
+	return nil
+}
+
+func TestStateSnapshots(t *testing.T) {
+	t.Parallel()
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	conversation := &restorableConversation{stateFile: stateFile}
+	s := &Server{
+		conversation: conversation,
+		agentType:    mf.AgentTypeCustom,
+		tokenizer:    mf.HeuristicTokenizer{},
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		stateFile:    stateFile,
+		saveState:    true,
+	}
+	ctx := context.Background()
+	create := func(name string) (*StateSnapshotResponse, error) {
+		input := &StateSnapshotCreateRequest{}
+		input.Body.Name = name
+		return s.createStateSnapshot(ctx, input)
+	}
+	statusOf := func(err error) int {
+		var statusErr huma.StatusError
+		require.ErrorAs(t, err, &statusErr)
+		return statusErr.GetStatus()
+	}
+
+	conversation.messages = []st.ConversationMessage{
+		{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"},
+		{Id: 1, Role: st.ConversationRoleUser, Message: "Refactor the parser"},
+	}
+	resp, err := create("before-refactor")
+	require.NoError(t, err)
+	assert.Equal(t, "before-refactor", resp.Body.Name)
+	assert.Equal(t, 2, resp.Body.Messages)
+	assert.FileExists(t, filepath.Join(stateFile+".snapshots", "before-refactor.json"))
+
+	_, err = create("../escape")
+	assert.Equal(t, http.StatusBadRequest, statusOf(err))
+
+	list, err := s.getStateSnapshots(ctx, &struct{}{})
+	require.NoError(t, err)
+	require.Len(t, list.Body.Snapshots, 1)
+	assert.Equal(t, "before-refactor", list.Body.Snapshots[0].Name)
+
+	// Restoring drops the messages sent after the snapshot and saves the
+	// state file.
+	conversation.messages = append(conversation.messages,
+		st.ConversationMessage{Id: 2, Role: st.ConversationRoleAgent, Message: "Done, but the tests fail"})
+	resp, err = s.restoreStateSnapshot(ctx, &StateSnapshotRestoreRequest{Name: "before-refactor"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.Body.Messages)
+	require.Len(t, conversation.messages, 2)
+	assert.Equal(t, "Refactor the parser", conversation.messages[1].Message)
+	saved, err := st.ReadAgentState(stateFile)
+	require.NoError(t, err)
+	assert.Len(t, saved.Messages, 2)
+	assert.Empty(t, resp.Body.Warnings)
+
+	// Restoring a snapshot taken with another agent version warns about
+	// it.
+	s.environment = &st.Environment{AgentType: "claude", AgentVersion: "2.0.0"}
+	require.NoError(t, st.WriteAgentState(filepath.Join(stateFile+".snapshots", "old-agent.json"), st.AgentState{
+		Version:     1,
+		Messages:    conversation.messages,
+		Environment: &st.Environment{AgentType: "claude", AgentVersion: "1.0.0"},
+	}))
+	resp, err = s.restoreStateSnapshot(ctx, &StateSnapshotRestoreRequest{Name: "old-agent"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"agent version was 1.0.0, is 2.0.0"}, resp.Body.Warnings)
+
+	_, err = s.restoreStateSnapshot(ctx, &StateSnapshotRestoreRequest{Name: "missing"})
+	assert.Equal(t, http.StatusNotFound, statusOf(err))
+
+	// Snapshots require a state file that's saved.
+	s.saveState = false
+	_, err = create("other")
+	assert.Equal(t, http.StatusBadRequest, statusOf(err))
+}
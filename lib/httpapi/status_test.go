@@ -0,0 +1,115 @@
+package httpapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coder/agentapi/lib/metrics"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/transport"
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// statusConversation is a conversation whose status and queue are set by
+// the test.
+type statusConversation struct {
+	sentConversation
+	status st.ConversationStatus
+	queued int
+}
+
+func (c *statusConversation) Status() st.ConversationStatus { return c.status }
+func (c *statusConversation) QueueDepth() int               { return c.queued }
+
+func TestStatusStates(t *testing.T) {
+	t.Parallel()
+
+	conversation := &statusConversation{status: st.ConversationStatusInitializing}
+	emitter := NewEventEmitter(WithClock(quartz.NewMock(t)), WithPromptDetection(true))
+	s := &Server{
+		agentio:      &recordingAgentIO{},
+		agentType:    mf.AgentTypeClaude,
+		conversation: conversation,
+		emitter:      emitter,
+		metrics:      metrics.New(),
+	}
+	getStatus := func() StatusResponse {
+		t.Helper()
+		resp, err := s.getStatus(context.Background(), &StatusRequest{})
+		require.NoError(t, err)
+		return *resp
+	}
+
+	status := getStatus()
+	assert.Equal(t, ProcessStateStarting, status.Body.ProcessState)
+	assert.Equal(t, ConversationStateInitializing, status.Body.ConversationState)
+
+	conversation.status = st.ConversationStatusChanging
+	conversation.queued = 1
+	status = getStatus()
+	assert.Equal(t, AgentStatusRunning, status.Body.Status)
+	assert.Equal(t, ProcessStateRunning, status.Body.ProcessState)
+	assert.Equal(t, ConversationStateGenerating, status.Body.ConversationState)
+	assert.Equal(t, 1, status.Body.QueueDepth)
+
+	conversation.status = st.ConversationStatusStable
+	conversation.queued = 0
+	status = getStatus()
+	assert.Equal(t, AgentStatusStable, status.Body.Status)
+	assert.Equal(t, ConversationStateIdle, status.Body.ConversationState)
+	assert.Zero(t, status.Body.QueueDepth)
+
+	// The legacy status is stable while a permission prompt waits on the
+	// user too.
+	_, ch, _ := emitter.Subscribe()
+	emitter.EmitScreen(permissionScreen)
+	<-ch
+	emitter.EmitStatus(st.ConversationStatusStable)
+	<-ch
+	<-ch
+	status = getStatus()
+	assert.Equal(t, AgentStatusStable, status.Body.Status)
+	assert.Equal(t, ConversationStateWaitingInput, status.Body.ConversationState)
+
+	s.AgentExited()
+	status = getStatus()
+	assert.Equal(t, ProcessStateExited, status.Body.ProcessState)
+}
+
+// mcpAgentIO is an AgentIO whose agent reports its MCP servers once
+// reported is set.
+type mcpAgentIO struct {
+	recordingAgentIO
+	servers  []transport.MCPServerStatus
+	reported bool
+}
+
+func (a *mcpAgentIO) MCPServers() ([]transport.MCPServerStatus, bool) {
+	return a.servers, a.reported
+}
+
+func TestStatusMCPServers(t *testing.T) {
+	t.Parallel()
+
+	agentio := &mcpAgentIO{}
+	s := &Server{
+		agentio:      agentio,
+		agentType:    mf.AgentTypeClaude,
+		conversation: &statusConversation{status: st.ConversationStatusStable},
+		emitter:      NewEventEmitter(WithClock(quartz.NewMock(t))),
+		metrics:      metrics.New(),
+	}
+
+	resp, err := s.getStatus(context.Background(), &StatusRequest{})
+	require.NoError(t, err)
+	assert.Nil(t, resp.Body.MCPServers)
+
+	agentio.servers = []transport.MCPServerStatus{{Name: "docs", Status: "connected"}, {Name: "search", Status: "failed"}}
+	agentio.reported = true
+	resp, err = s.getStatus(context.Background(), &StatusRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []MCPServerStatus{{Name: "docs", Status: "connected"}, {Name: "search", Status: "failed"}}, resp.Body.MCPServers)
+}
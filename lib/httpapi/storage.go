@@ -0,0 +1,245 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// storagePruneInterval is how often the retention policy is applied.
+const storagePruneInterval = time.Minute
+
+// RetentionConfig limits the disk space used by each storage category
+// reported by GET /storage. Only files the server no longer needs are
+// pruned, oldest first: attachments, uploads and rotated transcripts. The
+// state file and its snapshots, the current transcript and fixtures are
+// only reported.
+type RetentionConfig struct {
+	// MaxAge prunes files older than this. 0 disables it.
+	MaxAge time.Duration
+	// MaxSize prunes the oldest files of a category once it uses more
+	// bytes than this. 0 disables it.
+	MaxSize int64
+}
+
+func (r RetentionConfig) enabled() bool {
+	return r.MaxAge > 0 || r.MaxSize > 0
+}
+
+// storageEntry is a file, or a directory counted as a whole, of a storage
+// category.
+type storageEntry struct {
+	path     string
+	size     int64
+	files    int
+	modified time.Time
+	// prunable entries may be deleted by the retention policy.
+	prunable bool
+}
+
+// storageEntries returns the entries of a storage category.
+func (s *Server) storageEntries(category StorageCategory) ([]storageEntry, error) {
+	switch category {
+	case StorageCategoryAttachments:
+		store, ok := s.attachments.(*localAttachmentStore)
+		if !ok {
+			// Other stores manage their own storage.
+			return nil, nil
+		}
+		return dirStorageEntries(store.dir)
+	case StorageCategoryUploads:
+		entries, err := dirStorageEntries(s.tempDir)
+		if store, ok := s.attachments.(*localAttachmentStore); ok {
+			// The default attachment store lives in the uploads directory.
+			entries = slices.DeleteFunc(entries, func(e storageEntry) bool { return e.path == store.dir })
+		}
+		return entries, err
+	case StorageCategoryTranscripts:
+		if s.teeConfig.Path == "" {
+			return nil, nil
+		}
+		entries, err := fileStorageEntries(false, s.teeConfig.Path)
+		if err != nil {
+			return nil, err
+		}
+		rotated, err := filepath.Glob(globEscape(s.teeConfig.Path) + ".[0-9]*")
+		if err != nil {
+			return nil, xerrors.Errorf("failed to list rotated transcripts: %w", err)
+		}
+		rotatedEntries, err := fileStorageEntries(true, rotated...)
+		return append(entries, rotatedEntries...), err
+	case StorageCategoryState:
+		if s.stateFile == "" {
+			return nil, nil
+		}
+		entries, err := fileStorageEntries(false, s.stateFile)
+		if err != nil {
+			return nil, err
+		}
+		snapshots, err := dirStorageEntries(s.snapshotDir())
+		for i := range snapshots {
+			snapshots[i].prunable = false
+		}
+		return append(entries, snapshots...), err
+	case StorageCategoryFixtures:
+		if s.fixturesDir == "" {
+			return nil, nil
+		}
+		entries, err := dirStorageEntries(s.fixturesDir)
+		for i := range entries {
+			entries[i].prunable = false
+		}
+		return entries, err
+	default:
+		return nil, xerrors.Errorf("unknown storage category %q", category)
+	}
+}
+
+// dirStorageEntries returns the files and directories in dir as prunable
+// entries. A missing dir has none.
+func dirStorageEntries(dir string) ([]storageEntry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %s: %w", dir, err)
+	}
+	entries := make([]storageEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		path := filepath.Join(dir, dirEntry.Name())
+		info, err := dirEntry.Info()
+		if err != nil {
+			// Deleted in the meantime.
+			continue
+		}
+		entry := storageEntry{path: path, size: info.Size(), files: 1, modified: info.ModTime(), prunable: true}
+		if info.IsDir() {
+			entry.size, entry.files = diskUsage(path)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// fileStorageEntries returns the files that exist among paths.
+func fileStorageEntries(prunable bool, paths ...string) ([]storageEntry, error) {
+	var entries []storageEntry
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("failed to stat %s: %w", path, err)
+		}
+		entries = append(entries, storageEntry{path: path, size: info.Size(), files: 1, modified: info.ModTime(), prunable: prunable})
+	}
+	return entries, nil
+}
+
+// diskUsage returns the total size and number of the files in dir.
+func diskUsage(dir string) (size int64, files int) {
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+			files++
+		}
+		return nil
+	})
+	return size, files
+}
+
+// globEscape escapes the glob metacharacters of a path.
+func globEscape(path string) string {
+	escaped := make([]rune, 0, len(path))
+	for _, r := range path {
+		switch r {
+		case '*', '?', '[', '\\':
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, r)
+	}
+	return string(escaped)
+}
+
+// pruneStorage applies the retention policy to every storage category and
+// returns how many entries were deleted.
+func (s *Server) pruneStorage() (int, error) {
+	now := s.clock.Now()
+	deleted := 0
+	for _, category := range StorageCategoryValues {
+		entries, err := s.storageEntries(category)
+		if err != nil {
+			return deleted, err
+		}
+		var total int64
+		for _, entry := range entries {
+			total += entry.size
+		}
+		slices.SortFunc(entries, func(a, b storageEntry) int { return a.modified.Compare(b.modified) })
+		for _, entry := range entries {
+			expired := s.retention.MaxAge > 0 && now.Sub(entry.modified) > s.retention.MaxAge
+			oversized := s.retention.MaxSize > 0 && total > s.retention.MaxSize
+			if !entry.prunable || (!expired && !oversized) {
+				continue
+			}
+			if err := os.RemoveAll(entry.path); err != nil {
+				return deleted, xerrors.Errorf("failed to prune %s: %w", entry.path, err)
+			}
+			total -= entry.size
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// startStoragePruning applies the retention policy periodically.
+func (s *Server) startStoragePruning(ctx context.Context) {
+	if !s.retention.enabled() {
+		return
+	}
+	s.clock.TickerFunc(ctx, storagePruneInterval, func() error {
+		deleted, err := s.pruneStorage()
+		if err != nil {
+			s.logger.Warn("Failed to prune storage", "error", err)
+		}
+		if deleted > 0 {
+			s.logger.Info(fmt.Sprintf("Pruned %d files and directories from storage", deleted))
+		}
+		return nil
+	}, "storagePruning")
+}
+
+// getStorage handles GET /storage
+func (s *Server) getStorage(ctx context.Context, input *struct{}) (*StorageResponse, error) {
+	resp := &StorageResponse{}
+	resp.Body.Categories = make([]StorageUsage, 0, len(StorageCategoryValues))
+	for _, category := range StorageCategoryValues {
+		entries, err := s.storageEntries(category)
+		if err != nil {
+			return nil, err
+		}
+		usage := StorageUsage{Category: category}
+		for _, entry := range entries {
+			usage.Bytes += entry.size
+			usage.Files += entry.files
+			if entry.prunable {
+				usage.PrunableBytes += entry.size
+			}
+		}
+		resp.Body.Categories = append(resp.Body.Categories, usage)
+		resp.Body.TotalBytes += usage.Bytes
+	}
+	return resp, nil
+}
@@ -0,0 +1,87 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mClock := quartz.NewMock(t)
+	dir := t.TempDir()
+	tempDir := filepath.Join(dir, "uploads")
+	store, err := NewLocalAttachmentStore(filepath.Join(tempDir, "attachments"), mClock)
+	require.NoError(t, err)
+	writeFile := func(path string, size int, age time.Duration) {
+		t.Helper()
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, make([]byte, size), 0o644))
+		modified := mClock.Now().Add(-age)
+		require.NoError(t, os.Chtimes(path, modified, modified))
+	}
+	writeFile(filepath.Join(tempDir, "0123456789abcdef", "old.txt"), 100, 3*time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(tempDir, "0123456789abcdef"), mClock.Now().Add(-3*time.Hour), mClock.Now().Add(-3*time.Hour)))
+	_, err = store.Put(ctx, "new.txt", strings.NewReader("attachment"))
+	require.NoError(t, err)
+	tee := filepath.Join(dir, "transcript.jsonl")
+	writeFile(tee, 40, 0)
+	writeFile(tee+".1", 30, time.Hour)
+	writeFile(tee+".2", 20, 2*time.Hour)
+	stateFile := filepath.Join(dir, "state.json")
+	writeFile(stateFile, 50, 5*time.Hour)
+
+	s := &Server{
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		clock:       mClock,
+		tempDir:     tempDir,
+		attachments: store,
+		teeConfig:   TeeConfig{Path: tee},
+		stateFile:   stateFile,
+		retention:   RetentionConfig{MaxAge: 90 * time.Minute, MaxSize: 40},
+	}
+	usage := func() map[StorageCategory]StorageUsage {
+		t.Helper()
+		resp, err := s.getStorage(ctx, nil)
+		require.NoError(t, err)
+		usage := map[StorageCategory]StorageUsage{}
+		var total int64
+		for _, category := range resp.Body.Categories {
+			usage[category.Category] = category
+			total += category.Bytes
+		}
+		assert.Equal(t, total, resp.Body.TotalBytes)
+		return usage
+	}
+
+	before := usage()
+	assert.Equal(t, StorageUsage{Category: StorageCategoryAttachments, Bytes: 10, Files: 1, PrunableBytes: 10}, before[StorageCategoryAttachments])
+	assert.Equal(t, StorageUsage{Category: StorageCategoryUploads, Bytes: 100, Files: 1, PrunableBytes: 100}, before[StorageCategoryUploads])
+	assert.Equal(t, StorageUsage{Category: StorageCategoryTranscripts, Bytes: 90, Files: 3, PrunableBytes: 50}, before[StorageCategoryTranscripts])
+	assert.Equal(t, StorageUsage{Category: StorageCategoryState, Bytes: 50, Files: 1}, before[StorageCategoryState])
+	assert.Equal(t, StorageUsage{Category: StorageCategoryFixtures}, before[StorageCategoryFixtures])
+
+	// The old upload and the oldest rotated transcript have expired, and
+	// the other rotated transcript is over the size limit. The current
+	// transcript and the state file are kept.
+	deleted, err := s.pruneStorage()
+	require.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+	after := usage()
+	assert.Equal(t, int64(10), after[StorageCategoryAttachments].Bytes)
+	assert.Equal(t, int64(0), after[StorageCategoryUploads].Bytes)
+	assert.Equal(t, StorageUsage{Category: StorageCategoryTranscripts, Bytes: 40, Files: 1}, after[StorageCategoryTranscripts])
+	assert.Equal(t, int64(50), after[StorageCategoryState].Bytes)
+	assert.FileExists(t, tee)
+}
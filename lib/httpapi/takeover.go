@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// takenOver returns who took over the agent's terminal, or false if the
+// API controls it.
+func (s *Server) takenOver() (Takeover, bool) {
+	s.takeoverMu.Lock()
+	defer s.takeoverMu.Unlock()
+	if s.takeover == nil {
+		return Takeover{}, false
+	}
+	return *s.takeover, true
+}
+
+// checkTakeover rejects the messages that would type over a human who took
+// over the agent's terminal with POST /takeover.
+func (s *Server) checkTakeover() error {
+	takeover, ok := s.takenOver()
+	if !ok {
+		return nil
+	}
+	by := ""
+	if takeover.By != "" {
+		by = " by " + takeover.By
+	}
+	return huma.NewError(http.StatusLocked, fmt.Sprintf("the agent's terminal was taken over%s at %s, retry once it's released with POST /release",
+		by, takeover.Since.Format(time.RFC3339)))
+}
+
+// takeOver handles POST /takeover.
+func (s *Server) takeOver(ctx context.Context, input *TakeoverRequest) (*TakeoverResponse, error) {
+	s.takeoverMu.Lock()
+	defer s.takeoverMu.Unlock()
+	if s.takeover != nil {
+		return nil, huma.Error409Conflict("the agent's terminal is already taken over, release it first with POST /release")
+	}
+	s.takeover = &Takeover{Since: s.clock.Now()}
+	if input.Body != nil {
+		s.takeover.By, s.takeover.Reason = input.Body.By, input.Body.Reason
+	}
+	s.logger.Info("Agent's terminal taken over, API messages are rejected", "by", s.takeover.By, "reason", s.takeover.Reason)
+	return &TakeoverResponse{Body: *s.takeover}, nil
+}
+
+// release handles POST /release.
+func (s *Server) release(ctx context.Context, input *struct{}) (*struct{}, error) {
+	s.takeoverMu.Lock()
+	defer s.takeoverMu.Unlock()
+	if s.takeover == nil {
+		return nil, huma.Error409Conflict("the agent's terminal isn't taken over")
+	}
+	s.logger.Info("Agent's terminal released", "by", s.takeover.By, "duration", s.clock.Since(s.takeover.Since))
+	s.takeover = nil
+	return nil, nil
+}
@@ -0,0 +1,75 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/coder/agentapi/lib/metrics"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTakeover(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	mClock := quartz.NewMock(t)
+	agentIO := &recordingAgentIO{}
+	conversation := &sentConversation{}
+	s := &Server{
+		logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+		agentio:      agentIO,
+		agentType:    mf.AgentTypeClaude,
+		conversation: conversation,
+		emitter:      NewEventEmitter(WithClock(mClock)),
+		clock:        mClock,
+		metrics:      metrics.New(),
+	}
+	send := func(messageType MessageType, content string) error {
+		_, err := s.createMessage(ctx, &MessageRequest{Body: MessageRequestBody{Type: messageType, Content: content}})
+		return err
+	}
+	requireStatus := func(err error, status int) {
+		t.Helper()
+		var statusErr huma.StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, status, statusErr.GetStatus())
+	}
+
+	input := &TakeoverRequest{Body: &TakeoverRequestBody{By: "alice", Reason: "debugging"}}
+	resp, err := s.takeOver(ctx, input)
+	require.NoError(t, err)
+	assert.Equal(t, Takeover{By: "alice", Reason: "debugging", Since: mClock.Now()}, resp.Body)
+	_, err = s.takeOver(ctx, &TakeoverRequest{})
+	requireStatus(err, http.StatusConflict)
+
+	status, err := s.getStatus(ctx, &StatusRequest{})
+	require.NoError(t, err)
+	require.NotNil(t, status.Body.TakenOver)
+	assert.Equal(t, "alice", status.Body.TakenOver.By)
+
+	// API messages are rejected, while the human's keystrokes go through.
+	err = send(MessageTypeUser, "hello")
+	requireStatus(err, http.StatusLocked)
+	assert.Contains(t, err.Error(), "taken over by alice")
+	require.NoError(t, send(MessageTypeRaw, "ls\r"))
+	assert.Empty(t, conversation.sent)
+	assert.Equal(t, []string{"ls\r"}, agentIO.written)
+
+	_, err = s.release(ctx, &struct{}{})
+	require.NoError(t, err)
+	_, err = s.release(ctx, &struct{}{})
+	requireStatus(err, http.StatusConflict)
+	require.NoError(t, send(MessageTypeUser, "hello"))
+	assert.Len(t, conversation.sent, 1)
+
+	status, err = s.getStatus(ctx, &StatusRequest{})
+	require.NoError(t, err)
+	assert.Nil(t, status.Body.TakenOver)
+}
@@ -0,0 +1,143 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// TeeConfig configures --tee-output.
+type TeeConfig struct {
+	// Path is the JSONL file finalized messages are appended to. Teeing is
+	// disabled when empty.
+	Path string
+	// Screens also appends every screen update.
+	Screens bool
+	// MaxBytes is the size at which the file is rotated to Path.1. Older
+	// files are shifted up to Path.<MaxFiles>. 0 disables rotation.
+	MaxBytes int64
+	MaxFiles int
+}
+
+// transcriptTee appends finalized messages to a rotating JSONL file. Write
+// errors are logged rather than returned so a full disk doesn't take the
+// conversation down with it.
+type transcriptTee struct {
+	cfg    TeeConfig
+	logger *slog.Logger
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	// closed is set by Close. Until then, the file is reopened if a
+	// rotation fails after closing it.
+	closed bool
+}
+
+func newTranscriptTee(cfg TeeConfig, logger *slog.Logger) (*transcriptTee, error) {
+	t := &transcriptTee{cfg: cfg, logger: logger}
+	if err := t.openLocked(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Assumes the caller holds the lock.
+func (t *transcriptTee) openLocked() error {
+	file, err := os.OpenFile(t.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return xerrors.Errorf("failed to open tee output: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return xerrors.Errorf("failed to stat tee output: %w", err)
+	}
+	t.file = file
+	t.size = info.Size()
+	return nil
+}
+
+// Assumes the caller holds the lock.
+func (t *transcriptTee) rotateLocked() error {
+	err := t.file.Close()
+	t.file = nil
+	if err != nil {
+		return xerrors.Errorf("failed to close tee output: %w", err)
+	}
+	for i := t.cfg.MaxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", t.cfg.Path, i), fmt.Sprintf("%s.%d", t.cfg.Path, i+1))
+	}
+	if t.cfg.MaxFiles > 0 {
+		if err := os.Rename(t.cfg.Path, t.cfg.Path+".1"); err != nil {
+			return xerrors.Errorf("failed to rotate tee output: %w", err)
+		}
+	} else if err := os.Remove(t.cfg.Path); err != nil {
+		return xerrors.Errorf("failed to truncate tee output: %w", err)
+	}
+	return t.openLocked()
+}
+
+func (t *transcriptTee) write(record transcriptRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		t.logger.Error("Failed to marshal tee record", "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	if t.file != nil && t.cfg.MaxBytes > 0 && t.size > 0 && t.size+int64(len(line)) > t.cfg.MaxBytes {
+		if err := t.rotateLocked(); err != nil {
+			t.logger.Error("Failed to rotate tee output", "path", t.cfg.Path, "error", err)
+		}
+	}
+	if t.file == nil {
+		// A failed rotation left the file closed. Keep appending to it
+		// rather than dropping every record that follows.
+		if err := t.openLocked(); err != nil {
+			t.logger.Error("Failed to reopen tee output", "path", t.cfg.Path, "error", err)
+			return
+		}
+	}
+	n, err := t.file.Write(line)
+	t.size += int64(n)
+	if err != nil {
+		t.logger.Error("Failed to write tee output", "path", t.cfg.Path, "error", err)
+	}
+}
+
+// writeRecord appends messages and the usage of each turn, and screens if
+// --tee-screens is set.
+func (t *transcriptTee) writeRecord(record transcriptRecord) {
+	switch record.Type {
+	case transcriptRecordMessage, transcriptRecordUsage:
+	case transcriptRecordScreen:
+		if !t.cfg.Screens {
+			return
+		}
+	default:
+		return
+	}
+	t.write(record)
+}
+
+func (t *transcriptTee) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
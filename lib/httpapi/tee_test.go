@@ -0,0 +1,148 @@
+package httpapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readTeeRecords(t *testing.T, path string) []transcriptRecord {
+	t.Helper()
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() {
+		_ = file.Close()
+	}()
+	var records []transcriptRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record transcriptRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func TestTee(t *testing.T) {
+	t.Parallel()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("messages are written once the agent is stable", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "transcript.jsonl")
+		tee, err := newTranscriptTee(TeeConfig{Path: path}, logger)
+		require.NoError(t, err)
+		emitter := NewEventEmitter(withTranscriptSinks(tee))
+
+		emitter.EmitMessages([]st.ConversationMessage{{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"}})
+		emitter.EmitStatus(st.ConversationStatusStable)
+		emitter.EmitStatus(st.ConversationStatusChanging)
+		emitter.EmitMessages([]st.ConversationMessage{
+			{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"},
+			{Id: 1, Role: st.ConversationRoleUser, Message: "Hi"},
+			{Id: 2, Role: st.ConversationRoleAgent, Message: "Hel"},
+		})
+		// The agent is still writing its reply.
+		emitter.EmitScreen("Hello")
+		require.Len(t, readTeeRecords(t, path), 1)
+
+		emitter.EmitMessages([]st.ConversationMessage{
+			{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"},
+			{Id: 1, Role: st.ConversationRoleUser, Message: "Hi"},
+			{Id: 2, Role: st.ConversationRoleAgent, Message: "Hello"},
+		})
+		emitter.EmitStatus(st.ConversationStatusStable)
+		require.NoError(t, tee.Close())
+
+		records := readTeeRecords(t, path)
+		require.Len(t, records, 3)
+		for i, content := range []string{"Welcome", "Hi", "Hello"} {
+			assert.Equal(t, transcriptRecordMessage, records[i].Type)
+			assert.Equal(t, i, *records[i].Id)
+			assert.Equal(t, content, records[i].Content)
+		}
+	})
+
+	t.Run("screens", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "transcript.jsonl")
+		tee, err := newTranscriptTee(TeeConfig{Path: path, Screens: true}, logger)
+		require.NoError(t, err)
+		emitter := NewEventEmitter(withTranscriptSinks(tee))
+
+		emitter.EmitScreen("one")
+		emitter.EmitScreen("one")
+		emitter.EmitScreen("two")
+		require.NoError(t, tee.Close())
+
+		records := readTeeRecords(t, path)
+		require.Len(t, records, 2)
+		assert.Equal(t, transcriptRecordScreen, records[0].Type)
+		assert.Equal(t, "two", records[1].Content)
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "transcript.jsonl")
+		tee, err := newTranscriptTee(TeeConfig{Path: path, Screens: true, MaxBytes: 100, MaxFiles: 2}, logger)
+		require.NoError(t, err)
+		emitter := NewEventEmitter(withTranscriptSinks(tee))
+
+		// Each record is longer than half the limit, so every write rotates.
+		for _, screen := range []string{"a", "b", "c", "d"} {
+			emitter.EmitScreen(screen)
+		}
+		require.NoError(t, tee.Close())
+
+		assert.Equal(t, "d", readTeeRecords(t, path)[0].Content)
+		assert.Equal(t, "c", readTeeRecords(t, path+".1")[0].Content)
+		assert.Equal(t, "b", readTeeRecords(t, path+".2")[0].Content)
+		assert.NoFileExists(t, path+".3")
+	})
+	t.Run("failed rotation", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "transcript.jsonl")
+		// The file can't be renamed over a non-empty directory.
+		require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "blocker"), 0o755))
+		tee, err := newTranscriptTee(TeeConfig{Path: path, Screens: true, MaxBytes: 100, MaxFiles: 1}, logger)
+		require.NoError(t, err)
+		emitter := NewEventEmitter(withTranscriptSinks(tee))
+
+		for _, screen := range []string{"a", "b", "c"} {
+			emitter.EmitScreen(screen)
+		}
+		require.NoError(t, tee.Close())
+
+		records := readTeeRecords(t, path)
+		require.Len(t, records, 3)
+		assert.Equal(t, "c", records[2].Content)
+	})
+
+	t.Run("turn in progress is flushed", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "transcript.jsonl")
+		tee, err := newTranscriptTee(TeeConfig{Path: path}, logger)
+		require.NoError(t, err)
+		emitter := NewEventEmitter(withTranscriptSinks(tee))
+
+		emitter.EmitMessages([]st.ConversationMessage{
+			{Id: 0, Role: st.ConversationRoleUser, Message: "Hi"},
+			{Id: 1, Role: st.ConversationRoleAgent, Message: "Hel"},
+		})
+		emitter.flushTranscript()
+		require.NoError(t, tee.Close())
+
+		records := readTeeRecords(t, path)
+		require.Len(t, records, 2)
+		assert.Equal(t, "Hel", records[1].Content)
+	})
+}
@@ -0,0 +1,127 @@
+package httpapi
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+)
+
+// terminalLineBuffer assembles the lines typed in raw keystrokes, as sent by
+// agentapi attach. It handles backspace, Ctrl+U and bracketed paste, and
+// drops other control characters and escape sequences, such as arrow keys,
+// so lines edited with the cursor may not match what the agent received.
+type terminalLineBuffer struct {
+	mu    sync.Mutex
+	line  strings.Builder
+	paste bool
+	// record is whether the current line is recorded once complete.
+	record bool
+}
+
+const (
+	pasteStartSequence = "\x1b[200~"
+	pasteEndSequence   = "\x1b[201~"
+)
+
+// write consumes keystrokes and returns the lines completed with Enter.
+// start is called when a line starts and returns whether it is recorded.
+func (b *terminalLineBuffer) write(input string, start func() bool) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var lines []string
+	for len(input) > 0 {
+		if input[0] == '\x1b' {
+			n := escapeSequenceLength(input)
+			switch input[:n] {
+			case pasteStartSequence:
+				b.paste = true
+			case pasteEndSequence:
+				b.paste = false
+			}
+			input = input[n:]
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(input)
+		input = input[size:]
+		switch {
+		case (r == '\r' || r == '\n') && b.paste:
+			b.line.WriteByte('\n')
+		case r == '\r' || r == '\n':
+			if line := strings.TrimSpace(b.line.String()); line != "" && b.record {
+				lines = append(lines, line)
+			}
+			b.line.Reset()
+		case r == '\x7f' || r == '\b':
+			line := b.line.String()
+			if _, size := utf8.DecodeLastRuneInString(line); size > 0 {
+				b.line.Reset()
+				b.line.WriteString(line[:len(line)-size])
+			}
+		case r == '\x15': // Ctrl+U
+			b.line.Reset()
+		case r < ' ' && r != '\t':
+		default:
+			if b.line.Len() == 0 {
+				b.record = start()
+			}
+			b.line.WriteRune(r)
+		}
+	}
+	return lines
+}
+
+// escapeSequenceLength returns the length of the escape sequence input
+// starts with. Keystrokes are written whole, so an incomplete sequence,
+// such as the Escape key on its own, spans the rest of input.
+func escapeSequenceLength(input string) int {
+	if len(input) < 2 {
+		return len(input)
+	}
+	switch input[1] {
+	case '[':
+		// CSI: parameters and intermediates, then a final byte.
+		for i := 2; i < len(input); i++ {
+			if input[i] >= 0x40 && input[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return len(input)
+	case 'O':
+		// SS3, sent by some terminals for arrow and function keys.
+		return min(3, len(input))
+	default:
+		// Alt+key.
+		_, size := utf8.DecodeRuneInString(input[1:])
+		return 1 + size
+	}
+}
+
+// recordTerminalInput records the lines typed in raw keystrokes as user
+// messages, so the conversation shows what a human attached to the agent's
+// terminal asked. Lines started while the agent works or waits on a prompt
+// answer it rather than start a turn, and aren't recorded. The caller must
+// hold s.mu.
+func (s *Server) recordTerminalInput(input string) {
+	if s.terminalInput == nil {
+		return
+	}
+	recorder, ok := s.conversation.(st.TerminalInputRecorder)
+	if !ok {
+		return
+	}
+	lines := s.terminalInput.write(input, func() bool {
+		if _, prompted := s.emitter.PendingPrompt(); prompted || s.conversation.Status() != st.ConversationStatusStable {
+			return false
+		}
+		recorder.StartTerminalInput()
+		return true
+	})
+	for _, line := range lines {
+		recorder.RecordTerminalInput(line)
+		s.metrics.Inc("agentapi_terminal_messages_total", "User messages typed into the agent's terminal rather than sent with POST /message.",
+			"agent_type", string(s.agentType))
+	}
+}
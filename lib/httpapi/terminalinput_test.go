@@ -0,0 +1,104 @@
+package httpapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coder/agentapi/lib/metrics"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// terminalConversation is a conversation that records the lines typed into
+// the agent's terminal.
+type terminalConversation struct {
+	statusConversation
+	started  int
+	recorded []string
+}
+
+func (c *terminalConversation) StartTerminalInput() { c.started++ }
+func (c *terminalConversation) RecordTerminalInput(message string) {
+	c.recorded = append(c.recorded, message)
+}
+
+func TestTerminalLineBuffer(t *testing.T) {
+	t.Parallel()
+
+	record := func() bool { return true }
+	for _, tc := range []struct {
+		name  string
+		input []string
+		want  []string
+	}{
+		{"lines", []string{"hello", "\r", "a\rb\n"}, []string{"hello", "a", "b"}},
+		{"backspace", []string{"helx", "\x7flo\r"}, []string{"hello"}},
+		{"ctrl+u", []string{"draft\x15final\r"}, []string{"final"}},
+		{"arrow keys", []string{"ab\x1b[D\x1bOAc\r"}, []string{"abc"}},
+		{"escape key", []string{"a", "\x1b", "b\r"}, []string{"ab"}},
+		{"bracketed paste", []string{"\x1b[200~one\rtwo\x1b[201~", "\r"}, []string{"one\ntwo"}},
+		{"blank lines", []string{"\r", "  \r"}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var b terminalLineBuffer
+			var lines []string
+			for _, input := range tc.input {
+				lines = append(lines, b.write(input, record)...)
+			}
+			assert.Equal(t, tc.want, lines)
+		})
+	}
+
+	t.Run("not recorded", func(t *testing.T) {
+		t.Parallel()
+		var b terminalLineBuffer
+		recording := false
+		assert.Empty(t, b.write("1\r", func() bool { return recording }))
+		recording = true
+		assert.Equal(t, []string{"next"}, b.write("next\r", func() bool { return recording }))
+	})
+}
+
+func TestRecordTerminalInput(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	agentIO := &recordingAgentIO{}
+	conversation := &terminalConversation{statusConversation: statusConversation{status: st.ConversationStatusStable}}
+	s := &Server{
+		agentio:       agentIO,
+		agentType:     mf.AgentTypeClaude,
+		conversation:  conversation,
+		emitter:       NewEventEmitter(WithClock(quartz.NewMock(t))),
+		metrics:       metrics.New(),
+		terminalInput: &terminalLineBuffer{},
+	}
+	send := func(content string) {
+		t.Helper()
+		_, err := s.createMessage(ctx, &MessageRequest{Body: MessageRequestBody{Type: MessageTypeRaw, Content: content}})
+		require.NoError(t, err)
+	}
+
+	send("fix")
+	send(" the tests")
+	send("\r")
+	assert.Equal(t, 1, conversation.started)
+	assert.Equal(t, []string{"fix the tests"}, conversation.recorded)
+	assert.Equal(t, []string{"fix", " the tests", "\r"}, agentIO.written)
+	assert.Equal(t, 1.0, s.metrics.Value("agentapi_terminal_messages_total", "agent_type", "claude"))
+
+	// Keys typed while the agent works answer it and aren't recorded.
+	conversation.status = st.ConversationStatusChanging
+	send("y\r")
+	assert.Len(t, conversation.recorded, 1)
+
+	// Recording is disabled with --record-terminal-input=false.
+	conversation.status = st.ConversationStatusStable
+	s.terminalInput = nil
+	send("again\r")
+	assert.Len(t, conversation.recorded, 1)
+}
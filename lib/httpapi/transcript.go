@@ -0,0 +1,76 @@
+package httpapi
+
+import (
+	"log/slog"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+)
+
+type transcriptRecordType string
+
+const (
+	transcriptRecordMessage  transcriptRecordType = "message"
+	transcriptRecordScreen   transcriptRecordType = "screen"
+	transcriptRecordStatus   transcriptRecordType = "status"
+	transcriptRecordToolCall transcriptRecordType = "tool_call"
+	transcriptRecordError    transcriptRecordType = "error"
+	transcriptRecordUsage    transcriptRecordType = "usage"
+)
+
+// transcriptRecord is a conversation event written to --tee-output or
+// exported to syslog and OTLP.
+type transcriptRecord struct {
+	Type       transcriptRecordType `json:"type"`
+	Time       time.Time            `json:"time"`
+	Id         *int                 `json:"id,omitempty"`
+	Role       st.ConversationRole  `json:"role,omitempty"`
+	Content    string               `json:"content,omitempty"`
+	StopReason st.StopReason        `json:"stop_reason,omitempty"`
+	Filtered   bool                 `json:"filtered,omitempty"`
+	Pinned     bool                 `json:"pinned,omitempty"`
+	Source     st.MessageSource     `json:"source,omitempty"`
+	Thought    string               `json:"thought,omitempty"`
+	Diffs      []st.FileDiff        `json:"diffs,omitempty"`
+	Plan       []st.PlanEntry       `json:"plan,omitempty"`
+	Status     AgentStatus          `json:"status,omitempty"`
+	ToolCall   *ToolCallBody        `json:"tool_call,omitempty"`
+	Level      st.ErrorLevel        `json:"level,omitempty"`
+	Usage      *TurnUsage           `json:"usage,omitempty"`
+}
+
+// transcriptSink receives the conversation events the EventEmitter
+// records: finalized messages, screens, status changes, tool calls,
+// errors and the usage of each turn.
+// Sinks pick the record types they care about and must not block.
+type transcriptSink interface {
+	writeRecord(record transcriptRecord)
+	Close() error
+}
+
+// closeTranscriptSinks closes the sinks, logging the errors.
+func closeTranscriptSinks(sinks []transcriptSink, logger *slog.Logger) {
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			logger.Error("Failed to close transcript output", "error", err)
+		}
+	}
+}
+
+func messageRecord(msg st.ConversationMessage) transcriptRecord {
+	id := msg.Id
+	return transcriptRecord{
+		Type:       transcriptRecordMessage,
+		Time:       msg.Time,
+		Id:         &id,
+		Role:       msg.Role,
+		Content:    msg.Message,
+		StopReason: msg.StopReason,
+		Thought:    msg.Thought,
+		Diffs:      msg.Diffs,
+		Plan:       msg.Plan,
+		Filtered:   msg.Filtered,
+		Pinned:     msg.Pinned,
+		Source:     msg.Source,
+	}
+}
@@ -0,0 +1,11 @@
+package httpapi
+
+// Register the built-in transports, so NewServer can look up every value
+// in TransportValues.
+import (
+	_ "github.com/coder/agentapi/lib/termexec"
+	_ "github.com/coder/agentapi/x/acpio"
+	_ "github.com/coder/agentapi/x/aiderio"
+	_ "github.com/coder/agentapi/x/claudeio"
+	_ "github.com/coder/agentapi/x/httpio"
+)
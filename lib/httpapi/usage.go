@@ -0,0 +1,132 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"golang.org/x/xerrors"
+)
+
+// ModelPrice is the price of a model's tokens, in US dollars per million
+// tokens.
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// PricingConfig configures the cost reported by GET /usage.
+type PricingConfig struct {
+	// Models maps model names to their price.
+	Models map[string]ModelPrice
+	// Model is the model the agent uses. Costs are only reported if it is
+	// one of Models.
+	Model string
+}
+
+func (c PricingConfig) price() (ModelPrice, bool) {
+	price, ok := c.Models[c.Model]
+	return price, ok
+}
+
+// ParsePricingTable parses a JSON object mapping model names to prices,
+// e.g. {"claude-sonnet-4": {"input": 3, "output": 15}}.
+func ParsePricingTable(data []byte) (map[string]ModelPrice, error) {
+	var models map[string]ModelPrice
+	if err := json.Unmarshal(data, &models); err != nil {
+		return nil, xerrors.Errorf("invalid pricing table: %w", err)
+	}
+	for name, price := range models {
+		if price.Input < 0 || price.Output < 0 {
+			return nil, xerrors.Errorf("invalid pricing table: negative price for model %q", name)
+		}
+	}
+	return models, nil
+}
+
+// conversationUsage estimates the tokens used by each turn of a
+// conversation. A turn starts with a user message. Its input is the whole
+// conversation up to that message, which the model reads again on every
+// turn, and its output the agent messages that follow. Agent messages
+// before the first user message, such as welcome screens, aren't counted.
+func conversationUsage(messages []st.ConversationMessage, tokenizer mf.Tokenizer, pricing PricingConfig) []TurnUsage {
+	price, priced := pricing.price()
+	var turns []TurnUsage
+	contextTokens := 0
+	for _, msg := range messages {
+		if msg.Role == st.ConversationRoleUser {
+			turns = append(turns, TurnUsage{UserMessageId: msg.Id})
+		}
+		if len(turns) == 0 {
+			continue
+		}
+		tokens := tokenizer.CountTokens(msg.Message)
+		turn := &turns[len(turns)-1]
+		if msg.Role == st.ConversationRoleUser {
+			turn.InputTokens = contextTokens + tokens
+		} else {
+			turn.OutputTokens += tokens
+		}
+		contextTokens += tokens
+	}
+	if priced {
+		cumulativeCost := 0.0
+		for i := range turns {
+			cost := (float64(turns[i].InputTokens)*price.Input + float64(turns[i].OutputTokens)*price.Output) / 1e6
+			cumulativeCost += cost
+			cumulative := cumulativeCost
+			turns[i].CostUSD = &cost
+			turns[i].CumulativeCostUSD = &cumulative
+		}
+	}
+	return turns
+}
+
+// writeUsageLocked exports the usage of the turn the agent just completed,
+// once per turn. Assumes the caller holds the lock.
+func (e *EventEmitter) writeUsageLocked() {
+	if e.tokenizer == nil {
+		return
+	}
+	turns := conversationUsage(e.messages, e.tokenizer, e.pricing)
+	if len(turns) == 0 || turns[len(turns)-1].UserMessageId <= e.lastUsageTurn {
+		return
+	}
+	usage := turns[len(turns)-1]
+	e.lastUsageTurn = usage.UserMessageId
+	e.writeRecordLocked(transcriptRecord{Type: transcriptRecordUsage, Time: e.clock.Now(), Usage: &usage})
+}
+
+// withUsage exports the usage of each turn to the transcript sinks.
+func withUsage(tokenizer mf.Tokenizer, pricing PricingConfig) EventEmitterOption {
+	return func(e *EventEmitter) {
+		e.tokenizer = tokenizer
+		e.pricing = pricing
+	}
+}
+
+// getUsage handles GET /usage.
+func (s *Server) getUsage(ctx context.Context, input *struct{}) (*UsageResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	resp := &UsageResponse{}
+	resp.Body.Model = s.pricing.Model
+	resp.Body.Turns = conversationUsage(s.conversation.Messages(), s.tokenizer, s.pricing)
+	if resp.Body.Turns == nil {
+		resp.Body.Turns = []TurnUsage{}
+	}
+	for _, turn := range resp.Body.Turns {
+		resp.Body.InputTokens += turn.InputTokens
+		resp.Body.OutputTokens += turn.OutputTokens
+	}
+	if _, ok := s.pricing.price(); ok {
+		cost := 0.0
+		if n := len(resp.Body.Turns); n > 0 {
+			cost = *resp.Body.Turns[n-1].CumulativeCostUSD
+		}
+		resp.Body.CostUSD = &cost
+	}
+	return resp, nil
+}
@@ -0,0 +1,109 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// byteTokenizer counts one token per byte, to keep expected values simple.
+type byteTokenizer struct{}
+
+func (byteTokenizer) CountTokens(text string) int { return len(text) }
+
+var _ mf.Tokenizer = byteTokenizer{}
+
+var usageMessages = []st.ConversationMessage{
+	{Id: 0, Role: st.ConversationRoleAgent, Message: "Welcome"},
+	{Id: 1, Role: st.ConversationRoleUser, Message: "Fix it"},
+	{Id: 2, Role: st.ConversationRoleAgent, Message: "Fixed"},
+	{Id: 3, Role: st.ConversationRoleUser, Message: "Test it"},
+	{Id: 4, Role: st.ConversationRoleAgent, Message: "Passed"},
+}
+
+func TestGetUsage(t *testing.T) {
+	t.Parallel()
+
+	pricing := PricingConfig{
+		Models: map[string]ModelPrice{"claude-sonnet-4": {Input: 3, Output: 15}},
+		Model:  "claude-sonnet-4",
+	}
+	s := &Server{conversation: &messagesConversation{messages: usageMessages}, tokenizer: byteTokenizer{}, pricing: pricing}
+	resp, err := s.getUsage(context.Background(), nil)
+	require.NoError(t, err)
+
+	// The second turn reads the whole conversation since the first user
+	// message: "Fix it", "Fixed" and "Test it".
+	require.Len(t, resp.Body.Turns, 2)
+	assert.Equal(t, 1, resp.Body.Turns[0].UserMessageId)
+	assert.Equal(t, 6, resp.Body.Turns[0].InputTokens)
+	assert.Equal(t, 5, resp.Body.Turns[0].OutputTokens)
+	assert.Equal(t, 3, resp.Body.Turns[1].UserMessageId)
+	assert.Equal(t, 18, resp.Body.Turns[1].InputTokens)
+	assert.Equal(t, 6, resp.Body.Turns[1].OutputTokens)
+	assert.Equal(t, 24, resp.Body.InputTokens)
+	assert.Equal(t, 11, resp.Body.OutputTokens)
+
+	first := (6*3 + 5*15) / 1e6
+	second := (18*3 + 6*15) / 1e6
+	assert.InDelta(t, first, *resp.Body.Turns[0].CostUSD, 1e-12)
+	assert.InDelta(t, first, *resp.Body.Turns[0].CumulativeCostUSD, 1e-12)
+	assert.InDelta(t, second, *resp.Body.Turns[1].CostUSD, 1e-12)
+	assert.InDelta(t, first+second, *resp.Body.Turns[1].CumulativeCostUSD, 1e-12)
+	assert.InDelta(t, first+second, *resp.Body.CostUSD, 1e-12)
+
+	// Without a price for the model, only tokens are reported.
+	s.pricing.Model = "gpt-4o"
+	resp, err = s.getUsage(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Nil(t, resp.Body.CostUSD)
+	assert.Nil(t, resp.Body.Turns[0].CostUSD)
+	assert.Equal(t, 24, resp.Body.InputTokens)
+}
+
+func TestUsageExport(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "transcript.jsonl")
+	tee, err := newTranscriptTee(TeeConfig{Path: path}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	require.NoError(t, err)
+	emitter := NewEventEmitter(withTranscriptSinks(tee), withUsage(byteTokenizer{}, PricingConfig{}))
+
+	emitter.EmitMessages(usageMessages[:1])
+	emitter.EmitStatus(st.ConversationStatusStable)
+	emitter.EmitStatus(st.ConversationStatusChanging)
+	emitter.EmitMessages(usageMessages[:3])
+	emitter.EmitStatus(st.ConversationStatusStable)
+	// The status changes without a new turn.
+	emitter.EmitStatus(st.ConversationStatusChanging)
+	emitter.EmitStatus(st.ConversationStatusStable)
+	require.NoError(t, tee.Close())
+
+	var usage []TurnUsage
+	for _, record := range readTeeRecords(t, path) {
+		if record.Type == transcriptRecordUsage {
+			usage = append(usage, *record.Usage)
+		}
+	}
+	assert.Equal(t, []TurnUsage{{UserMessageId: 1, InputTokens: 6, OutputTokens: 5}}, usage)
+}
+
+func TestParsePricingTable(t *testing.T) {
+	t.Parallel()
+
+	models, err := ParsePricingTable([]byte(`{"claude-sonnet-4": {"input": 3, "output": 15}}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ModelPrice{"claude-sonnet-4": {Input: 3, Output: 15}}, models)
+
+	_, err = ParsePricingTable([]byte(`{"claude-sonnet-4": {"input": -3}}`))
+	assert.ErrorContains(t, err, "negative price")
+	_, err = ParsePricingTable([]byte(`[]`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package httpapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// waitForMatcher tracks the latest screen and agent message and reports
+// the first one matching the pattern.
+type waitForMatcher struct {
+	pattern *regexp.Regexp
+	source  WaitForSource
+	// lastAgentId is the id of the latest agent message seen.
+	lastAgentId int
+}
+
+func (m *waitForMatcher) match(event Event) (*WaitForResponse, bool) {
+	resp := &WaitForResponse{}
+	switch payload := event.Payload.(type) {
+	case ScreenUpdateBody:
+		if m.source == WaitForSourceMessage {
+			return nil, false
+		}
+		match := m.pattern.FindString(payload.Screen)
+		if match == "" && !m.pattern.MatchString(payload.Screen) {
+			return nil, false
+		}
+		resp.Body.Source = WaitForSourceScreen
+		resp.Body.Match = match
+	case MessageUpdateBody:
+		// Only the latest agent message counts.
+		if m.source == WaitForSourceScreen || payload.Role != st.ConversationRoleAgent || payload.Id < m.lastAgentId {
+			return nil, false
+		}
+		m.lastAgentId = payload.Id
+		match := m.pattern.FindString(payload.Message)
+		if match == "" && !m.pattern.MatchString(payload.Message) {
+			return nil, false
+		}
+		id := payload.Id
+		resp.Body.Source = WaitForSourceMessage
+		resp.Body.Match = match
+		resp.Body.MessageId = &id
+	default:
+		return nil, false
+	}
+	return resp, true
+}
+
+// waitFor handles POST /wait_for. It resolves as soon as the source
+// matches, including when it already does.
+func (s *Server) waitFor(ctx context.Context, input *WaitForRequest) (*WaitForResponse, error) {
+	pattern, err := regexp.Compile(input.Body.Pattern)
+	if err != nil {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("invalid pattern: %s", err))
+	}
+	timeout, err := time.ParseDuration(input.Body.Timeout)
+	if err != nil || timeout <= 0 {
+		return nil, huma.Error400BadRequest(fmt.Sprintf("invalid timeout %q, expected a positive duration such as 10m", input.Body.Timeout))
+	}
+	source := input.Body.Source
+	if source == "" {
+		source = WaitForSourceMessage
+	}
+	matcher := &waitForMatcher{pattern: pattern, source: source}
+
+	timer := s.clock.NewTimer(timeout, "waitFor")
+	defer timer.Stop()
+	for {
+		resp, err := s.waitForSubscription(ctx, timer.C, matcher, timeout)
+		if resp != nil || err != nil {
+			return resp, err
+		}
+		// The emitter closed the channel because we fell behind. Subscribe
+		// again to catch up.
+	}
+}
+
+// waitForSubscription returns nil, nil if the emitter closes the
+// subscription.
+func (s *Server) waitForSubscription(ctx context.Context, timeoutCh <-chan time.Time, matcher *waitForMatcher, timeout time.Duration) (*WaitForResponse, error) {
+	subscriberId, ch, stateEvents := s.emitter.Subscribe()
+	defer s.emitter.Unsubscribe(subscriberId)
+	// The state events replay every message; skip to the latest agent one.
+	for _, event := range stateEvents {
+		if msg, ok := event.Payload.(MessageUpdateBody); ok && msg.Role == st.ConversationRoleAgent {
+			matcher.lastAgentId = max(matcher.lastAgentId, msg.Id)
+		}
+	}
+	for _, event := range stateEvents {
+		if resp, ok := matcher.match(event); ok {
+			return resp, nil
+		}
+	}
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return nil, nil
+			}
+			if resp, ok := matcher.match(event); ok {
+				return resp, nil
+			}
+		case <-timeoutCh:
+			return nil, huma.NewError(http.StatusRequestTimeout, fmt.Sprintf("pattern %q not found within %s", matcher.pattern, timeout))
+		case <-s.shutdownCtx.Done():
+			return nil, huma.Error503ServiceUnavailable("server is shutting down")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
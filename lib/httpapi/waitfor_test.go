@@ -0,0 +1,110 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitFor(t *testing.T) {
+	t.Parallel()
+
+	newServer := func(t *testing.T) (*Server, *EventEmitter, *quartz.Mock) {
+		mClock := quartz.NewMock(t)
+		emitter := NewEventEmitter()
+		return &Server{emitter: emitter, clock: mClock, shutdownCtx: context.Background()}, emitter, mClock
+	}
+	request := func(pattern string, timeout string, source WaitForSource) *WaitForRequest {
+		input := &WaitForRequest{}
+		input.Body.Pattern = pattern
+		input.Body.Timeout = timeout
+		input.Body.Source = source
+		return input
+	}
+
+	t.Run("already matching", func(t *testing.T) {
+		t.Parallel()
+		s, emitter, _ := newServer(t)
+		emitter.EmitScreen("$ go test ./...\nok  \tpkg\t0.1s\nAll tests passed")
+		resp, err := s.waitFor(context.Background(), request(`All tests \w+`, "1m", WaitForSourceScreen))
+		require.NoError(t, err)
+		assert.Equal(t, WaitForSourceScreen, resp.Body.Source)
+		assert.Equal(t, "All tests passed", resp.Body.Match)
+	})
+
+	t.Run("latest agent message", func(t *testing.T) {
+		t.Parallel()
+		s, emitter, _ := newServer(t)
+		// Earlier messages and user messages don't count, nor does the
+		// screen echoing the prompt by default.
+		emitter.EmitMessages([]st.ConversationMessage{
+			{Id: 0, Role: st.ConversationRoleAgent, Message: "DONE"},
+			{Id: 1, Role: st.ConversationRoleUser, Message: "Say DONE when finished"},
+			{Id: 2, Role: st.ConversationRoleAgent, Message: "Working"},
+		})
+		emitter.EmitScreen("DONE")
+
+		done := make(chan *WaitForResponse)
+		go func() {
+			resp, err := s.waitFor(context.Background(), request("DONE", "1m", ""))
+			assert.NoError(t, err)
+			done <- resp
+		}()
+		require.Eventually(t, func() bool {
+			emitter.mu.Lock()
+			defer emitter.mu.Unlock()
+			return len(emitter.chans) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		select {
+		case <-done:
+			t.Fatal("resolved before the agent message matched")
+		default:
+		}
+
+		emitter.EmitMessages([]st.ConversationMessage{
+			{Id: 0, Role: st.ConversationRoleAgent, Message: "DONE"},
+			{Id: 1, Role: st.ConversationRoleUser, Message: "Say DONE when finished"},
+			{Id: 2, Role: st.ConversationRoleAgent, Message: "Working\nDONE"},
+		})
+		resp := <-done
+		assert.Equal(t, WaitForSourceMessage, resp.Body.Source)
+		require.NotNil(t, resp.Body.MessageId)
+		assert.Equal(t, 2, *resp.Body.MessageId)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+		s, _, mClock := newServer(t)
+		trap := mClock.Trap().NewTimer("waitFor")
+		defer trap.Close()
+
+		errCh := make(chan error)
+		go func() {
+			_, err := s.waitFor(context.Background(), request("never", "5m", ""))
+			errCh <- err
+		}()
+		call := trap.MustWait(context.Background())
+		call.Release()
+		mClock.Advance(5 * time.Minute).MustWait(context.Background())
+
+		var statusErr huma.StatusError
+		require.ErrorAs(t, <-errCh, &statusErr)
+		assert.Equal(t, http.StatusRequestTimeout, statusErr.GetStatus())
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		t.Parallel()
+		s, _, _ := newServer(t)
+		_, err := s.waitFor(context.Background(), request("(", "1m", ""))
+		assert.ErrorContains(t, err, "invalid pattern")
+		_, err = s.waitFor(context.Background(), request("x", "soon", ""))
+		assert.ErrorContains(t, err, "invalid timeout")
+	})
+}
@@ -0,0 +1,132 @@
+package logctx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"slices"
+
+	"golang.org/x/xerrors"
+)
+
+// ContentPolicy decides how conversation content, such as prompts and
+// agent messages, appears in logs.
+type ContentPolicy string
+
+const (
+	// ContentPolicyFull logs content as is.
+	ContentPolicyFull ContentPolicy = "full"
+	// ContentPolicyTruncate logs the beginning of content.
+	ContentPolicyTruncate ContentPolicy = "truncate"
+	// ContentPolicyHash logs a hash of content, so that the same content
+	// can be recognized without revealing it.
+	ContentPolicyHash ContentPolicy = "hash"
+	// ContentPolicyOmit leaves content out.
+	ContentPolicyOmit ContentPolicy = "omit"
+)
+
+var ContentPolicyValues = []ContentPolicy{
+	ContentPolicyFull,
+	ContentPolicyTruncate,
+	ContentPolicyHash,
+	ContentPolicyOmit,
+}
+
+// truncatedContentLength is how many characters of content
+// ContentPolicyTruncate keeps.
+const truncatedContentLength = 32
+
+func ParseContentPolicy(value string) (ContentPolicy, error) {
+	policy := ContentPolicy(value)
+	if !slices.Contains(ContentPolicyValues, policy) {
+		return "", xerrors.Errorf("invalid content policy %q, expected one of %v", value, ContentPolicyValues)
+	}
+	return policy, nil
+}
+
+// content is conversation content in a log attribute. Handlers that don't
+// apply a policy log it in full.
+type content string
+
+func (c content) LogValue() slog.Value {
+	return slog.StringValue(string(c))
+}
+
+// Content returns a log attribute holding conversation content, which is
+// masked according to the policy of the handler.
+func Content(key string, value string) slog.Attr {
+	return slog.Any(key, content(value))
+}
+
+func (p ContentPolicy) mask(c content) slog.Value {
+	switch p {
+	case ContentPolicyTruncate:
+		runes := []rune(string(c))
+		if len(runes) <= truncatedContentLength {
+			return slog.StringValue(string(c))
+		}
+		return slog.StringValue(string(runes[:truncatedContentLength]) + "…")
+	case ContentPolicyHash:
+		sum := sha256.Sum256([]byte(c))
+		return slog.StringValue("sha256:" + hex.EncodeToString(sum[:8]))
+	case ContentPolicyOmit:
+		return slog.StringValue("[omitted]")
+	default:
+		return slog.StringValue(string(c))
+	}
+}
+
+func (p ContentPolicy) maskAttr(attr slog.Attr) slog.Attr {
+	switch attr.Value.Kind() {
+	case slog.KindLogValuer:
+		if c, ok := attr.Value.LogValuer().(content); ok {
+			attr.Value = p.mask(c)
+		}
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		masked := make([]any, len(group))
+		for i, a := range group {
+			masked[i] = p.maskAttr(a)
+		}
+		attr = slog.Group(attr.Key, masked...)
+	}
+	return attr
+}
+
+// contentPolicyHandler masks the content attributes of records before
+// passing them on.
+type contentPolicyHandler struct {
+	slog.Handler
+	policy ContentPolicy
+}
+
+// WithContentPolicy wraps a handler so that attributes created with
+// Content are logged according to the policy.
+func WithContentPolicy(handler slog.Handler, policy ContentPolicy) slog.Handler {
+	if policy == ContentPolicyFull {
+		return handler
+	}
+	return &contentPolicyHandler{Handler: handler, policy: policy}
+}
+
+func (h *contentPolicyHandler) Handle(ctx context.Context, r slog.Record) error {
+	masked := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(attr slog.Attr) bool {
+		masked.AddAttrs(h.policy.maskAttr(attr))
+		return true
+	})
+	return h.Handler.Handle(ctx, masked)
+}
+
+func (h *contentPolicyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	masked := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		masked[i] = h.policy.maskAttr(attr)
+	}
+	return &contentPolicyHandler{Handler: h.Handler.WithAttrs(masked), policy: h.policy}
+}
+
+func (h *contentPolicyHandler) WithGroup(name string) slog.Handler {
+	return &contentPolicyHandler{Handler: h.Handler.WithGroup(name), policy: h.policy}
+}
@@ -0,0 +1,49 @@
+package logctx
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentPolicy(t *testing.T) {
+	t.Parallel()
+
+	prompt := "Deploy with the token sk-0123456789abcdef0123456789abcdef"
+	for _, tc := range []struct {
+		policy   ContentPolicy
+		expected string
+	}{
+		{ContentPolicyFull, `prompt="Deploy with the token sk-0123456789abcdef0123456789abcdef"`},
+		{ContentPolicyTruncate, `prompt="Deploy with the token sk-0123456…"`},
+		{ContentPolicyHash, `prompt=sha256:`},
+		{ContentPolicyOmit, `prompt=[omitted]`},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			t.Parallel()
+			var out bytes.Buffer
+			logger := slog.New(WithContentPolicy(slog.NewTextHandler(&out, nil), tc.policy))
+			logger.With(Content("prompt", prompt)).WithGroup("g").Info("Sending", Content("prompt", prompt), "length", len(prompt))
+			assert.Contains(t, out.String(), " "+tc.expected)
+			assert.Contains(t, out.String(), " g."+tc.expected)
+			assert.Contains(t, out.String(), "g.length=57")
+			if tc.policy != ContentPolicyFull {
+				assert.NotContains(t, out.String(), "sk-0123456789abcdef")
+			}
+		})
+	}
+}
+
+func TestParseContentPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := ParseContentPolicy("hash")
+	require.NoError(t, err)
+	assert.Equal(t, ContentPolicyHash, policy)
+
+	_, err = ParseContentPolicy("redact")
+	require.Error(t, err)
+}
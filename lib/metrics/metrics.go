@@ -0,0 +1,142 @@
+// Package metrics implements a minimal registry of counters and gauges
+// rendered in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type metricType string
+
+const (
+	typeCounter metricType = "counter"
+	typeGauge   metricType = "gauge"
+)
+
+type metric struct {
+	help   string
+	typ    metricType
+	values map[string]float64 // keyed by rendered label set
+}
+
+// Registry holds named metrics. The zero value is not usable; use New.
+// A nil *Registry is valid and discards all updates.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+func New() *Registry {
+	return &Registry{metrics: make(map[string]*metric)}
+}
+
+// renderLabels formats key/value pairs as {k1="v1",k2="v2"}.
+func renderLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	if len(labels)%2 != 0 {
+		panic(fmt.Sprintf("metrics: odd number of label arguments: %v", labels))
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Assumes the caller holds the lock.
+func (r *Registry) getLocked(name, help string, typ metricType) *metric {
+	m, ok := r.metrics[name]
+	if !ok {
+		m = &metric{help: help, typ: typ, values: make(map[string]float64)}
+		r.metrics[name] = m
+	}
+	if m.typ != typ {
+		panic(fmt.Sprintf("metrics: %s registered as %s, used as %s", name, m.typ, typ))
+	}
+	return m
+}
+
+// Inc increments the counter identified by name and labels, given as
+// alternating key/value pairs.
+func (r *Registry) Inc(name, help string, labels ...string) {
+	r.Add(name, help, 1, labels...)
+}
+
+// Add adds delta to the counter identified by name and labels.
+func (r *Registry) Add(name, help string, delta float64, labels ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.getLocked(name, help, typeCounter).values[renderLabels(labels)] += delta
+}
+
+// Set sets the gauge identified by name and labels.
+func (r *Registry) Set(name, help string, value float64, labels ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.getLocked(name, help, typeGauge).values[renderLabels(labels)] = value
+}
+
+// Value returns the current value of a metric, or 0 if it doesn't exist.
+func (r *Registry) Value(name string, labels ...string) float64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.metrics[name]
+	if !ok {
+		return 0
+	}
+	return m.values[renderLabels(labels)]
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		m := r.metrics[name]
+		fmt.Fprintf(&sb, "# HELP %s %s\n", name, m.help)
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", name, m.typ)
+		labelSets := make([]string, 0, len(m.values))
+		for labels := range m.values {
+			labelSets = append(labelSets, labels)
+		}
+		sort.Strings(labelSets)
+		for _, labels := range labelSets {
+			fmt.Fprintf(&sb, "%s%s %g\n", name, labels, m.values[labels])
+		}
+	}
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// Handler serves the registry in the Prometheus text exposition format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
@@ -0,0 +1,58 @@
+package metrics_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/coder/agentapi/lib/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	r := metrics.New()
+	r.Inc("requests_total", "Total requests.", "path", "/status")
+	r.Inc("requests_total", "Total requests.", "path", "/status")
+	r.Add("requests_total", "Total requests.", 3, "path", `/a"b`)
+	r.Set("queue_depth", "Messages waiting to be sent.", 2)
+
+	assert.Equal(t, float64(2), r.Value("requests_total", "path", "/status"))
+	assert.Equal(t, float64(0), r.Value("requests_total", "path", "/missing"))
+
+	var sb strings.Builder
+	_, err := r.WriteTo(&sb)
+	require.NoError(t, err)
+	assert.Equal(t, `# HELP queue_depth Messages waiting to be sent.
+# TYPE queue_depth gauge
+queue_depth 2
+# HELP requests_total Total requests.
+# TYPE requests_total counter
+requests_total{path="/a\"b"} 3
+requests_total{path="/status"} 2
+`, sb.String())
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, sb.String(), rec.Body.String())
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain")
+}
+
+func TestRegistry_Nil(t *testing.T) {
+	t.Parallel()
+
+	var r *metrics.Registry
+	r.Inc("requests_total", "Total requests.")
+	r.Set("queue_depth", "Messages waiting to be sent.", 1)
+	assert.Equal(t, float64(0), r.Value("requests_total"))
+}
+
+func TestRegistry_TypeMismatch(t *testing.T) {
+	t.Parallel()
+
+	r := metrics.New()
+	r.Inc("things", "Things.")
+	assert.Panics(t, func() { r.Set("things", "Things.", 1) })
+}
@@ -0,0 +1,215 @@
+package msgfmt
+
+import (
+	"regexp"
+	"strings"
+)
+
+type BlockKind string
+
+const (
+	BlockKindText     BlockKind = "text"
+	BlockKindCode     BlockKind = "code"
+	BlockKindToolCall BlockKind = "tool_call"
+	BlockKindDiff     BlockKind = "diff"
+)
+
+// Block is a part of an agent message: prose, a code block, a tool call or
+// the diff of a file the agent edited.
+type Block struct {
+	Kind BlockKind `json:"kind"`
+	// Text is the prose of text blocks, the code of code blocks, and the
+	// output the agent shows for tool calls and diffs, without the markers
+	// and indentation the agent draws around them.
+	Text string `json:"text"`
+	// Language is set on code blocks whose fence names it.
+	Language string `json:"language,omitempty"`
+	// Tool and Input are the name and arguments of a tool call, or of the
+	// tool that edited the file of a diff.
+	Tool  string `json:"tool,omitempty"`
+	Input string `json:"input,omitempty"`
+	// Path is the file of a diff.
+	Path string `json:"path,omitempty"`
+}
+
+// blockPatterns match the lines agents start their tool calls with. The
+// groups of header are the tool's name and its input.
+type blockPatterns struct {
+	header *regexp.Regexp
+	// editTools are the tools whose output is the diff of the file they
+	// edit, and whose input starts with the file's path.
+	editTools []string
+}
+
+var toolCallPatterns = map[AgentType]blockPatterns{
+	// "● Update(main.go)", "⏺ Search(pattern: "TODO")…"
+	AgentTypeClaude: {
+		header:    regexp.MustCompile(`^[●⏺] ([A-Z][\w-]*(?: - [\w-]+(?: \(MCP\))?)?)\((.*)\)…?$`),
+		editTools: []string{"Update", "Edit", "MultiEdit"},
+	},
+	// "• Ran git status", "⚡ Ran command git status", "• Edited main.go (+2 -1)"
+	AgentTypeCodex: {
+		header:    regexp.MustCompile(`^[•⚡] (Ran|Explored|Edited|Added|Deleted|Called|Read|Searched|Listed)(?: command)? (.+)$`),
+		editTools: []string{"Edited", "Added", "Deleted"},
+	},
+}
+
+// geminiToolCallPattern matches the first line of the boxes Gemini CLI
+// draws around tool calls, e.g. "│ ✔  ReadFile main.go │".
+var geminiToolCallPattern = regexp.MustCompile(`^[│|]\s*[✔✓✗✖x⊷o?-]\s+([A-Z]\w*)\s*(.*?)\s*[│|]?$`)
+
+// editStatsPattern matches the line counts Codex shows after the path of
+// the files it edits, e.g. "main.go (+2 -1)".
+var editStatsPattern = regexp.MustCompile(`\s+\(\+\d+ -\d+\)$`)
+
+// textMarkers are the bullets agents start their prose with.
+var textMarkers = []string{"● ", "⏺ ", "✦ ", "• "}
+
+// ParseBlocks splits an agent message, as formatted by FormatAgentMessage,
+// into prose, code blocks, tool calls and diffs. Tool calls are only
+// recognized for Claude Code, Codex and Gemini CLI; the messages of other
+// agents are split into prose and fenced code.
+func ParseBlocks(agentType AgentType, message string) []Block {
+	lines := strings.Split(message, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, WhiteSpaceChars)
+	}
+	patterns := toolCallPatterns[agentType]
+
+	var blocks []Block
+	var text []string
+	flushText := func() {
+		if content := trimEmptyLines(strings.Join(removeCommonIndent(text), "\n")); content != "" {
+			blocks = append(blocks, Block{Kind: BlockKindText, Text: content})
+		}
+		text = nil
+	}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "```") {
+			flushText()
+			end := i + 1
+			for end < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[end]), "```") {
+				end++
+			}
+			blocks = append(blocks, Block{
+				Kind:     BlockKindCode,
+				Text:     strings.Join(removeCommonIndent(lines[i+1:min(end, len(lines))]), "\n"),
+				Language: strings.TrimSpace(strings.TrimPrefix(trimmed, "```")),
+			})
+			i = end
+			continue
+		}
+
+		if patterns.header != nil {
+			if match := patterns.header.FindStringSubmatch(trimmed); match != nil {
+				flushText()
+				end := toolOutputEnd(lines, i)
+				blocks = append(blocks, toolCallBlock(patterns, match[1], match[2], toolOutput(lines[i+1:end])))
+				i = end - 1
+				continue
+			}
+		}
+
+		if agentType == AgentTypeGemini && startsWithAny(trimmed, boxTopCorners) && i+1 < len(lines) {
+			if match := geminiToolCallPattern.FindStringSubmatch(strings.TrimSpace(lines[i+1])); match != nil {
+				flushText()
+				end := i + 2
+				var output []string
+				for ; end < len(lines) && !startsWithAny(strings.TrimSpace(lines[end]), boxBottomCorners); end++ {
+					row := strings.TrimSpace(lines[end])
+					row = strings.TrimSuffix(strings.TrimPrefix(row, "│"), "│")
+					output = append(output, strings.TrimRight(row, WhiteSpaceChars))
+				}
+				blocks = append(blocks, Block{
+					Kind:  BlockKindToolCall,
+					Text:  trimEmptyLines(strings.Join(removeCommonIndent(output), "\n")),
+					Tool:  match[1],
+					Input: match[2],
+				})
+				i = end
+				continue
+			}
+		}
+
+		if marker := textMarker(trimmed); marker != "" {
+			// Each bullet starts a paragraph of its own.
+			flushText()
+			line = strings.Repeat(" ", indentOf(line)+len([]rune(marker))) + strings.TrimPrefix(trimmed, marker)
+		}
+		text = append(text, line)
+	}
+	flushText()
+	return blocks
+}
+
+// toolCallBlock returns the block of a tool call, which is a diff if the
+// tool edits a file.
+func toolCallBlock(patterns blockPatterns, tool, input, output string) Block {
+	for _, editTool := range patterns.editTools {
+		if tool == editTool {
+			path := editStatsPattern.ReplaceAllString(input, "")
+			return Block{Kind: BlockKindDiff, Text: output, Tool: tool, Input: input, Path: path}
+		}
+	}
+	return Block{Kind: BlockKindToolCall, Text: output, Tool: tool, Input: input}
+}
+
+// toolOutputEnd returns the index of the first line after the output of
+// the tool call whose header is at start: the output is indented, and
+// blank lines only belong to it if more output follows.
+func toolOutputEnd(lines []string, start int) int {
+	headerIndent := indentOf(lines[start])
+	end := start + 1
+	for j := start + 1; j < len(lines); j++ {
+		if lines[j] == "" {
+			continue
+		}
+		if indentOf(lines[j]) <= headerIndent {
+			break
+		}
+		end = j + 1
+	}
+	return end
+}
+
+// toolOutput returns the output of a tool call without the "⎿" marker
+// agents start it with and its indentation.
+func toolOutput(lines []string) string {
+	output := make([]string, len(lines))
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if rest, ok := strings.CutPrefix(trimmed, "⎿"); ok {
+			line = strings.Repeat(" ", indentOf(line)+1) + rest
+		}
+		output[i] = line
+	}
+	return trimEmptyLines(strings.Join(removeCommonIndent(output), "\n"))
+}
+
+// textMarker returns the bullet line starts with, or "" if none.
+func textMarker(line string) string {
+	for _, marker := range textMarkers {
+		if strings.HasPrefix(line, marker) {
+			return marker
+		}
+	}
+	return ""
+}
+
+// removeCommonIndent removes the indentation all non-blank lines share.
+func removeCommonIndent(lines []string) []string {
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" && (indent < 0 || indentOf(line) < indent) {
+			indent = indentOf(line)
+		}
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = removeIndent(line, max(indent, 0))
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+package msgfmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBlocks(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		agentType AgentType
+		message   string
+		expected  []Block
+	}{
+		{
+			name:      "claude",
+			agentType: AgentTypeClaude,
+			message: `● Let me search for this code in the project files.
+
+● Search(pattern: "polling")…
+  ⎿  Found 1 file (ctrl+r to expand)
+
+● Update(main.go)
+  ⎿  Updated main.go with 1 addition and 1 removal
+     1  func main() {
+     2 -  fmt.Println("hi")
+     2 +  fmt.Println("hello")
+
+● Done. Run it with:
+
+` + "  ```sh\n  go run .\n  ```",
+			expected: []Block{
+				{Kind: BlockKindText, Text: "Let me search for this code in the project files."},
+				{Kind: BlockKindToolCall, Text: "Found 1 file (ctrl+r to expand)", Tool: "Search", Input: `pattern: "polling"`},
+				{Kind: BlockKindDiff, Text: "Updated main.go with 1 addition and 1 removal\n1  func main() {\n2 -  fmt.Println(\"hi\")\n2 +  fmt.Println(\"hello\")",
+					Tool: "Update", Input: "main.go", Path: "main.go"},
+				{Kind: BlockKindText, Text: "Done. Run it with:"},
+				{Kind: BlockKindCode, Text: "go run .", Language: "sh"},
+			},
+		},
+		{
+			name:      "codex",
+			agentType: AgentTypeCodex,
+			message: `⚡ Ran command git status --porcelain
+  ⎿  M cmd/server/server.go
+     M lib/msgfmt/msgfmt.go
+
+• Edited main.go (+1 -1)
+    2 -  fmt.Println("hi")
+    2 +  fmt.Println("hello")
+
+codex
+There are 2 untracked files.`,
+			expected: []Block{
+				{Kind: BlockKindToolCall, Text: "M cmd/server/server.go\nM lib/msgfmt/msgfmt.go", Tool: "Ran", Input: "git status --porcelain"},
+				{Kind: BlockKindDiff, Text: "2 -  fmt.Println(\"hi\")\n2 +  fmt.Println(\"hello\")", Tool: "Edited", Input: "main.go (+1 -1)", Path: "main.go"},
+				{Kind: BlockKindText, Text: "codex\nThere are 2 untracked files."},
+			},
+		},
+		{
+			name:      "gemini",
+			agentType: AgentTypeGemini,
+			message: ` ╭──────────────────────────────────────────╮
+ │ ✔  SearchText 'fetchMessages' in **/*.tsx │
+ │                                           │
+ │    No matches found                       │
+ ╰──────────────────────────────────────────╯
+✦ I couldn't find that code.
+
+  Could you tell me more?`,
+			expected: []Block{
+				{Kind: BlockKindToolCall, Text: "No matches found", Tool: "SearchText", Input: "'fetchMessages' in **/*.tsx"},
+				{Kind: BlockKindText, Text: "I couldn't find that code.\n\nCould you tell me more?"},
+			},
+		},
+		{
+			name:      "other agents only get prose and code",
+			agentType: AgentTypeAider,
+			message:   "● Update(main.go)\n```go\npackage main\n```",
+			expected: []Block{
+				{Kind: BlockKindText, Text: "Update(main.go)"},
+				{Kind: BlockKindCode, Text: "package main", Language: "go"},
+			},
+		},
+		{
+			name:      "unterminated fence",
+			agentType: AgentTypeClaude,
+			message:   "```\nline",
+			expected:  []Block{{Kind: BlockKindCode, Text: "line"}},
+		},
+		{
+			name:      "empty",
+			agentType: AgentTypeClaude,
+			message:   "\n  \n",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, ParseBlocks(c.agentType, c.message))
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package msgfmt
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// coloredRune is a character of a screen line along with the SGR escape
+// sequence in effect for it, empty for the default colors.
+type coloredRune struct {
+	r   rune
+	sgr string
+}
+
+// parseColoredLine splits a line with SGR escape sequences into its
+// characters and their colors. Each sequence sets all attributes, the way
+// termexec's ReadScreenANSI writes them. Other escape sequences are
+// dropped.
+func parseColoredLine(line string) []coloredRune {
+	var runes []coloredRune
+	sgr := ""
+	for i := 0; i < len(line); {
+		if strings.HasPrefix(line[i:], "\x1b[") {
+			end := strings.IndexFunc(line[i+2:], func(r rune) bool { return r >= 0x40 && r <= 0x7e })
+			if end == -1 {
+				break
+			}
+			seq := line[i : i+2+end+1]
+			if strings.HasSuffix(seq, "m") {
+				if seq == "\x1b[0m" || seq == "\x1b[m" {
+					sgr = ""
+				} else {
+					sgr = seq
+				}
+			}
+			i += len(seq)
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(line[i:])
+		runes = append(runes, coloredRune{r: r, sgr: sgr})
+		i += size
+	}
+	return runes
+}
+
+func writeColoredRunes(sb *strings.Builder, runes []coloredRune) {
+	sgr := ""
+	for _, cr := range runes {
+		if cr.sgr != sgr {
+			if cr.sgr == "" {
+				sb.WriteString("\x1b[0m")
+			} else {
+				sb.WriteString(cr.sgr)
+			}
+			sgr = cr.sgr
+		}
+		sb.WriteRune(cr.r)
+	}
+	if sgr != "" {
+		sb.WriteString("\x1b[0m")
+	}
+}
+
+// ColorMessage returns the message with the colors its lines have on the
+// screen, given as lines with SGR escape sequences. Messages are the latest
+// output on the screen, so their lines are looked for from the bottom up.
+// Lines that aren't found, such as lines the formatter changed, are left
+// uncolored.
+func ColorMessage(message string, ansiScreen string) string {
+	screen := strings.Split(ansiScreen, "\n")
+	coloredScreen := make([][]coloredRune, len(screen))
+	plainScreen := make([]string, len(screen))
+	for i, line := range screen {
+		coloredScreen[i] = parseColoredLine(line)
+		var sb strings.Builder
+		for _, cr := range coloredScreen[i] {
+			sb.WriteRune(cr.r)
+		}
+		plainScreen[i] = sb.String()
+	}
+
+	lines := strings.Split(message, "\n")
+	colored := make([]string, len(lines))
+	cursor := len(screen) - 1
+	for i := len(lines) - 1; i >= 0; i-- {
+		colored[i] = lines[i]
+		text := strings.TrimSpace(lines[i])
+		if text == "" {
+			continue
+		}
+		for j := cursor; j >= 0; j-- {
+			idx := strings.Index(plainScreen[j], text)
+			if idx == -1 {
+				continue
+			}
+			start := utf8.RuneCountInString(plainScreen[j][:idx])
+			var sb strings.Builder
+			sb.WriteString(lines[i][:strings.Index(lines[i], text)])
+			writeColoredRunes(&sb, coloredScreen[j][start:start+utf8.RuneCountInString(text)])
+			colored[i] = sb.String()
+			cursor = j - 1
+			break
+		}
+	}
+	return strings.Join(colored, "\n")
+}
+
+// ansiPalette are the 16 basic colors of xterm.
+var ansiPalette = [16]string{
+	"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+	"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+}
+
+// ansiColor returns the CSS color of a color of the 256 color palette.
+func ansiColor(n int) string {
+	switch {
+	case n < 16:
+		return ansiPalette[n]
+	case n < 232:
+		levels := [6]int{0, 95, 135, 175, 215, 255}
+		n -= 16
+		return fmt.Sprintf("#%02x%02x%02x", levels[n/36], levels[n/6%6], levels[n%6])
+	default:
+		gray := 8 + 10*(n-232)
+		return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+	}
+}
+
+// sgrStyle returns the CSS style of an SGR escape sequence.
+func sgrStyle(sgr string) string {
+	params := strings.Split(strings.TrimSuffix(strings.TrimPrefix(sgr, "\x1b["), "m"), ";")
+	var fg, bg string
+	var bold, italic, underline bool
+	for i := 0; i < len(params); i++ {
+		code, err := strconv.Atoi(params[i])
+		if err != nil {
+			continue
+		}
+		switch {
+		case code == 0:
+			fg, bg, bold, italic, underline = "", "", false, false, false
+		case code == 1:
+			bold = true
+		case code == 3:
+			italic = true
+		case code == 4:
+			underline = true
+		case code >= 30 && code <= 37:
+			fg = ansiColor(code - 30)
+		case code >= 90 && code <= 97:
+			fg = ansiColor(code - 90 + 8)
+		case code >= 40 && code <= 47:
+			bg = ansiColor(code - 40)
+		case code >= 100 && code <= 107:
+			bg = ansiColor(code - 100 + 8)
+		case code == 39:
+			fg = ""
+		case code == 49:
+			bg = ""
+		case (code == 38 || code == 48) && i+2 < len(params) && params[i+1] == "5":
+			n, _ := strconv.Atoi(params[i+2])
+			color := ansiColor(min(max(n, 0), 255))
+			i += 2
+			if code == 38 {
+				fg = color
+			} else {
+				bg = color
+			}
+		case (code == 38 || code == 48) && i+4 < len(params) && params[i+1] == "2":
+			var rgb [3]int
+			for k := range rgb {
+				rgb[k], _ = strconv.Atoi(params[i+2+k])
+			}
+			color := fmt.Sprintf("#%02x%02x%02x", rgb[0]&0xff, rgb[1]&0xff, rgb[2]&0xff)
+			i += 4
+			if code == 38 {
+				fg = color
+			} else {
+				bg = color
+			}
+		}
+	}
+	var style []string
+	if fg != "" {
+		style = append(style, "color:"+fg)
+	}
+	if bg != "" {
+		style = append(style, "background-color:"+bg)
+	}
+	if bold {
+		style = append(style, "font-weight:bold")
+	}
+	if italic {
+		style = append(style, "font-style:italic")
+	}
+	if underline {
+		style = append(style, "text-decoration:underline")
+	}
+	return strings.Join(style, ";")
+}
+
+// ANSIToHTML converts text with SGR escape sequences to HTML, with the
+// colored parts in spans styled inline, so the HTML can be embedded as is.
+// The text is escaped, and other escape sequences are dropped.
+func ANSIToHTML(text string) string {
+	var sb strings.Builder
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		var runs strings.Builder
+		style := ""
+		flush := func() {
+			if runs.Len() == 0 {
+				return
+			}
+			if style == "" {
+				sb.WriteString(html.EscapeString(runs.String()))
+			} else {
+				fmt.Fprintf(&sb, `<span style="%s">%s</span>`, style, html.EscapeString(runs.String()))
+			}
+			runs.Reset()
+		}
+		for _, cr := range parseColoredLine(line) {
+			if runeStyle := sgrStyle(cr.sgr); runeStyle != style {
+				flush()
+				style = runeStyle
+			}
+			runs.WriteRune(cr.r)
+		}
+		flush()
+	}
+	return sb.String()
+}
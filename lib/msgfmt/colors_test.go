@@ -0,0 +1,39 @@
+package msgfmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColorMessage(t *testing.T) {
+	t.Parallel()
+
+	const (
+		red   = "\x1b[31;49m"
+		green = "\x1b[32;49m"
+		reset = "\x1b[0m"
+	)
+	// The screen as termexec renders it: full-width lines with the colors
+	// of each cell, and the diff shown twice, so the latest one counts.
+	screen := "> Fix the bug       \n" +
+		red + "- return nil" + reset + "        \n" +
+		"> Fix it again      \n" +
+		"● Updated main.go   \n" +
+		"  " + red + "- return nil" + reset + "      \n" +
+		"  " + green + "+ return err" + reset + "      \n" +
+		"                    \n" +
+		"────────────────────\n"
+	message := "Updated main.go\n- return nil\n+ return err\n\nDone."
+	assert.Equal(t, "Updated main.go\n"+red+"- return nil"+reset+"\n"+green+"+ return err"+reset+"\n\nDone.",
+		ColorMessage(message, screen))
+}
+
+func TestANSIToHTML(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, `<span style="color:#cd0000">- if a &lt; b</span>`+"\n"+
+		`<span style="color:#00ff00;background-color:#87005f;font-weight:bold">+</span> ok`,
+		ANSIToHTML("\x1b[31;49m- if a < b\x1b[0m\n\x1b[1;92;48;5;89m+\x1b[0m ok"))
+	assert.Equal(t, "plain &amp; simple", ANSIToHTML("plain & simple"))
+}
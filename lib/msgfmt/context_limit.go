@@ -0,0 +1,109 @@
+package msgfmt
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// contextLimitPatterns match the banners agents print when the
+// conversation no longer fits in the model's context window.
+var contextLimitPatterns = map[AgentType][]*regexp.Regexp{
+	AgentTypeClaude: {
+		regexp.MustCompile(`Prompt is too long`),
+	},
+	AgentTypeAider: {
+		regexp.MustCompile(`exceeds the [\d,]+ token limit`),
+		regexp.MustCompile(`larger than the context window`),
+	},
+	AgentTypeCodex: {
+		regexp.MustCompile(`ran out of room in the model's context window`),
+	},
+	AgentTypeGoose: {
+		regexp.MustCompile(`(?i)context length exceeded`),
+	},
+}
+
+// genericContextLimitPattern matches the errors most model APIs return
+// when the context window is exceeded, which agents often print verbatim as
+// the last line of their message. Only that line is matched, so that replies
+// discussing context limits aren't mistaken for the error.
+var genericContextLimitPattern = regexp.MustCompile(`(?i)maximum context length|context[ _]length[ _]exceeded|context window exceeded`)
+
+// IsContextLimitReached reports whether an agent message says that the
+// agent stopped because the model's context window is full.
+func IsContextLimitReached(agentType AgentType, message string) bool {
+	for _, pattern := range contextLimitPatterns[agentType] {
+		if pattern.MatchString(message) {
+			return true
+		}
+	}
+	return genericContextLimitPattern.MatchString(lastNonEmptyLine(message))
+}
+
+// lastNonEmptyLine returns the last line of s that isn't blank.
+func lastNonEmptyLine(s string) string {
+	lines := strings.Split(s, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) != "" {
+			return lines[i]
+		}
+	}
+	return ""
+}
+
+// contextLeftPatterns match the indicators agents show in their terminal UI
+// as the context window fills up. The first group is the percentage of the
+// context window that is still available.
+var contextLeftPatterns = map[AgentType][]*regexp.Regexp{
+	AgentTypeClaude: {
+		regexp.MustCompile(`Context left until auto-compact: (\d{1,3})%`),
+		regexp.MustCompile(`Context low \((\d{1,3})% remaining\)`),
+	},
+}
+
+// genericContextLeftPattern matches the footer shown by Gemini CLI and
+// Codex, e.g. "(87% context left)".
+var genericContextLeftPattern = regexp.MustCompile(`(\d{1,3})% context left`)
+
+// ContextUsedPercent returns how much of the context window the agent
+// reports as used on its screen. ok is false if the screen doesn't show it.
+// The last indicator on the screen wins.
+func ContextUsedPercent(agentType AgentType, screen string) (percent int, ok bool) {
+	lastIdx := -1
+	for _, pattern := range append(slices.Clone(contextLeftPatterns[agentType]), genericContextLeftPattern) {
+		for _, match := range pattern.FindAllStringSubmatchIndex(screen, -1) {
+			if match[0] < lastIdx {
+				continue
+			}
+			left, err := strconv.Atoi(screen[match[2]:match[3]])
+			if err != nil || left > 100 {
+				continue
+			}
+			lastIdx = match[0]
+			percent, ok = 100-left, true
+		}
+	}
+	return percent, ok
+}
+
+// CompactAcceptsInstructions reports whether the agent's compaction command
+// can be followed by instructions on what to keep, as in Claude Code's
+// "/compact Keep the API design".
+func CompactAcceptsInstructions(agentType AgentType) bool {
+	return agentType == AgentTypeClaude
+}
+
+// CompactCommand returns the slash command that makes the agent summarize
+// its conversation to free up context, or "" if the agent has none.
+func CompactCommand(agentType AgentType) string {
+	switch agentType {
+	case AgentTypeClaude, AgentTypeCodex, AgentTypeOpencode:
+		return "/compact"
+	case AgentTypeGemini:
+		return "/compress"
+	default:
+		return ""
+	}
+}
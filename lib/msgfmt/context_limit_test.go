@@ -0,0 +1,60 @@
+package msgfmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsContextLimitReached(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		agentType AgentType
+		message   string
+		expected  bool
+	}{
+		{"claude", AgentTypeClaude, "● I'll read the logs.\n\n  ⎿  API Error: 400 Prompt is too long", true},
+		{"aider", AgentTypeAider, "Your estimated chat context of 130,511 tokens exceeds the 128,000 token limit for gpt-4o!", true},
+		{"codex", AgentTypeCodex, "Codex ran out of room in the model's context window. Start a new conversation or clear earlier history before retrying.", true},
+		{"goose", AgentTypeGoose, "Context length exceeded. Summarize the conversation to continue.", true},
+		{"generic", AgentTypeCustom, "Error: This model's maximum context length is 8192 tokens.", true},
+		{"generic trailing banner", AgentTypeCustom, "Reading the logs.\n\nError: context_length_exceeded\n\n", true},
+		{"reply mentioning the limit", AgentTypeCustom, "If the maximum context length is exceeded, the API returns a 400.\nI added a check for it.", false},
+		{"normal reply", AgentTypeClaude, "● The context window of this function is the request scope.", false},
+		{"other agent's banner", AgentTypeGemini, "Prompt is too long", false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, IsContextLimitReached(c.agentType, c.message))
+		})
+	}
+}
+
+func TestContextUsedPercent(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		agentType AgentType
+		screen    string
+		percent   int
+		ok        bool
+	}{
+		{"claude auto-compact", AgentTypeClaude, "> \n  ? for shortcuts      Context left until auto-compact: 12%", 88, true},
+		{"claude context low", AgentTypeClaude, "Context low (3% remaining) · Run /compact to compact & continue", 97, true},
+		{"gemini footer", AgentTypeGemini, "~/project   no sandbox   gemini-2.5-pro (92% context left)", 8, true},
+		{"codex footer", AgentTypeCodex, "⏎ send   ⌃J newline   ⌃C quit   61% context left", 39, true},
+		{"last indicator wins", AgentTypeGemini, "(92% context left)\n...\n(40% context left)", 60, true},
+		{"not shown", AgentTypeClaude, "> \n  ? for shortcuts", 0, false},
+		{"out of range", AgentTypeGemini, "(150% context left)", 0, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			percent, ok := ContextUsedPercent(c.agentType, c.screen)
+			assert.Equal(t, c.ok, ok)
+			assert.Equal(t, c.percent, percent)
+		})
+	}
+}
+
+func TestCompactCommand(t *testing.T) {
+	assert.Equal(t, "/compact", CompactCommand(AgentTypeClaude))
+	assert.Equal(t, "/compress", CompactCommand(AgentTypeGemini))
+	assert.Equal(t, "", CompactCommand(AgentTypeAider))
+}
@@ -0,0 +1,19 @@
+package msgfmt
+
+import "strings"
+
+// FileMention returns how a prompt references a file with the agent's own
+// syntax, so that the agent reads the file itself, or "" if the agent has
+// none. Aider's /add command must be sent on its own rather than in a
+// prompt, so it doesn't count.
+func FileMention(agentType AgentType, path string) string {
+	if strings.ContainsAny(path, " \t") {
+		return ""
+	}
+	switch agentType {
+	case AgentTypeClaude, AgentTypeCodex, AgentTypeGemini, AgentTypeOpencode:
+		return "@" + path
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,86 @@
+package msgfmt
+
+import (
+	"path"
+	"slices"
+	"testing"
+)
+
+var fuzzAgentTypes = []AgentType{AgentTypeClaude, AgentTypeGoose, AgentTypeAider, AgentTypeCodex, AgentTypeGemini, AgentTypeCopilot, AgentTypeAmp, AgentTypeCursor, AgentTypeAuggie, AgentTypeAmazonQ, AgentTypeOpencode, AgentTypeCustom}
+
+// addFormatSeeds seeds the corpus with the messages and user inputs of the
+// format and remove-user-input fixtures.
+func addFormatSeeds(f *testing.F) {
+	f.Helper()
+	for _, agentType := range fuzzAgentTypes {
+		dir := path.Join("testdata/format", string(agentType))
+		cases, err := testdataDir.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, c := range cases {
+			msg, err := testdataDir.ReadFile(path.Join(dir, c.Name(), "msg.txt"))
+			if err != nil {
+				f.Fatal(err)
+			}
+			userInput, err := testdataDir.ReadFile(path.Join(dir, c.Name(), "user.txt"))
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(string(msg), string(userInput), string(agentType))
+		}
+	}
+	dir := "testdata/remove-user-input"
+	cases, err := testdataDir.ReadDir(dir)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, c := range cases {
+		msg, err := testdataDir.ReadFile(path.Join(dir, c.Name(), "msg.txt"))
+		if err != nil {
+			f.Fatal(err)
+		}
+		userInput, err := testdataDir.ReadFile(path.Join(dir, c.Name(), "user.txt"))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(msg), string(userInput), string(AgentTypeCustom))
+	}
+}
+
+func FuzzRemoveUserInput(f *testing.F) {
+	addFormatSeeds(f)
+	f.Fuzz(func(t *testing.T, msg string, userInput string, agentType string) {
+		if !slices.Contains(fuzzAgentTypes, AgentType(agentType)) {
+			agentType = string(AgentTypeCustom)
+		}
+		result := RemoveUserInput(msg, userInput, AgentType(agentType))
+		if UserInputEchoed(msg, userInput) != (result != msg) && AgentType(agentType) == AgentTypeCustom {
+			t.Errorf("UserInputEchoed disagrees with RemoveUserInput for %q and %q", msg, userInput)
+		}
+	})
+}
+
+func FuzzFormatAgentMessage(f *testing.F) {
+	addFormatSeeds(f)
+	f.Fuzz(func(t *testing.T, msg string, userInput string, agentType string) {
+		FormatAgentMessage(AgentType(agentType), msg, userInput)
+	})
+}
+
+func FuzzParseBlocks(f *testing.F) {
+	addFormatSeeds(f)
+	f.Fuzz(func(t *testing.T, msg string, _ string, agentType string) {
+		ParseBlocks(AgentType(agentType), msg)
+	})
+}
+
+func FuzzRemoveMessageBox(f *testing.F) {
+	addFormatSeeds(f)
+	f.Fuzz(func(t *testing.T, msg string, _ string, _ string) {
+		removeMessageBox(msg)
+		removeCodexMessageBox(msg)
+		removeOpencodeMessageBox(msg)
+		removeAmpMessageBox(msg)
+	})
+}
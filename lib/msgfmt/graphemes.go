@@ -0,0 +1,46 @@
+package msgfmt
+
+import (
+	"strings"
+
+	"github.com/rivo/uniseg"
+)
+
+// splitGraphemes splits s into grapheme clusters: user-perceived
+// characters such as "👨‍👩‍👧", or "é" written as an "e" followed by a
+// combining accent, which span several runes.
+func splitGraphemes(s string) []string {
+	clusters := make([]string, 0, len(s))
+	state := -1
+	for s != "" {
+		var cluster string
+		cluster, s, _, state = uniseg.FirstGraphemeClusterInString(s, state)
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
+
+// normalizeGrapheme removes the invisible runes of a grapheme cluster, so
+// that clusters compare equal whether or not the terminal or the agent
+// kept, for instance, an emoji's variation selector.
+func normalizeGrapheme(cluster string) string {
+	return strings.Map(func(r rune) rune {
+		if isInvisible(r) {
+			return -1
+		}
+		return r
+	}, cluster)
+}
+
+// ContinuesGrapheme reports whether next starts in the middle of the last
+// grapheme cluster of prev, as when the terminal wraps a line between the
+// runes of an emoji sequence or before a combining mark.
+func ContinuesGrapheme(prev string, next string) bool {
+	if prev == "" || next == "" {
+		return false
+	}
+	clusters := splitGraphemes(prev)
+	last := clusters[len(clusters)-1]
+	first, _, _, _ := uniseg.FirstGraphemeClusterInString(last+next, -1)
+	return len(first) > len(last)
+}
@@ -0,0 +1,128 @@
+package msgfmt
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type LinkKind string
+
+const (
+	LinkKindURL  LinkKind = "url"
+	LinkKindFile LinkKind = "file"
+)
+
+// Hyperlink is an OSC 8 hyperlink written by the agent: Text is what the
+// terminal displayed and URL is where it points.
+type Hyperlink struct {
+	Text string
+	URL  string
+}
+
+// Link is a URL or a file location referenced in a message.
+type Link struct {
+	Kind LinkKind `json:"kind"`
+	// Text is the part of the message the link covers.
+	Text string `json:"text"`
+	// URL is set on URL links, and on file links from file:// hyperlinks.
+	URL string `json:"url,omitempty"`
+	// Path, Line and Column are set on file links. Line and Column are 0
+	// when unknown.
+	Path   string `json:"path,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+var (
+	urlPattern = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+	// filePathPattern matches path:line and path:line:column references.
+	// The path must have an extension, so times such as 10:30 don't match.
+	filePathPattern = regexp.MustCompile(`(?:^|[\s("'` + "`" + `\[])((?:~|\.{1,2})?/?(?:[\w.@+-]+/)*[\w@+-][\w.@+-]*\.[A-Za-z0-9]+):(\d+)(?::(\d+))?\b`)
+)
+
+// FindLinks returns the links in a message, in the order they appear:
+// OSC 8 hyperlinks whose text is in the message, URLs, and file references
+// such as lib/msgfmt/links.go:42.
+func FindLinks(message string, hyperlinks []Hyperlink) []Link {
+	type found struct {
+		start, end int
+		link       Link
+	}
+	var links []found
+	overlaps := func(start, end int) bool {
+		for _, l := range links {
+			if start < l.end && end > l.start {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, h := range hyperlinks {
+		text := strings.TrimSpace(h.Text)
+		start := strings.Index(message, text)
+		if text == "" || start < 0 || overlaps(start, start+len(text)) {
+			continue
+		}
+		links = append(links, found{start, start + len(text), hyperlinkToLink(text, h.URL)})
+	}
+	for _, loc := range urlPattern.FindAllStringIndex(message, -1) {
+		text := trimURL(message[loc[0]:loc[1]])
+		if overlaps(loc[0], loc[0]+len(text)) {
+			continue
+		}
+		links = append(links, found{loc[0], loc[0] + len(text), Link{Kind: LinkKindURL, Text: text, URL: text}})
+	}
+	for _, m := range filePathPattern.FindAllStringSubmatchIndex(message, -1) {
+		start, end := m[2], m[1]
+		if overlaps(start, end) {
+			continue
+		}
+		link := Link{Kind: LinkKindFile, Text: message[start:end], Path: message[m[2]:m[3]]}
+		link.Line, _ = strconv.Atoi(message[m[4]:m[5]])
+		if m[6] >= 0 {
+			link.Column, _ = strconv.Atoi(message[m[6]:m[7]])
+		}
+		links = append(links, found{start, end, link})
+	}
+
+	slices.SortFunc(links, func(a, b found) int { return a.start - b.start })
+	result := make([]Link, 0, len(links))
+	for _, l := range links {
+		result = append(result, l.link)
+	}
+	return result
+}
+
+// trimURL removes trailing punctuation that is more likely part of the
+// surrounding sentence than of the URL.
+func trimURL(u string) string {
+	for len(u) > 0 {
+		last := u[len(u)-1]
+		switch {
+		case strings.IndexByte(".,;:!?'\"", last) >= 0:
+		case last == ')' && strings.Count(u, "(") < strings.Count(u, ")"):
+		case last == ']' && strings.Count(u, "[") < strings.Count(u, "]"):
+		default:
+			return u
+		}
+		u = u[:len(u)-1]
+	}
+	return u
+}
+
+func hyperlinkToLink(text string, target string) Link {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "file" {
+		return Link{Kind: LinkKindURL, Text: text, URL: target}
+	}
+	link := Link{Kind: LinkKindFile, Text: text, URL: target, Path: u.Path}
+	// Editors commonly link to lines with a #L<line> fragment.
+	if line, ok := strings.CutPrefix(u.Fragment, "L"); ok {
+		link.Line, _ = strconv.Atoi(line)
+	}
+	return link
+}
@@ -0,0 +1,59 @@
+package msgfmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindLinks(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		message    string
+		hyperlinks []Hyperlink
+		expected   []Link
+	}{
+		{
+			"none",
+			"The meeting is at 10:30, see version 1.2.",
+			nil,
+			[]Link{},
+		},
+		{
+			"urls",
+			"See https://github.com/coder/agentapi/pull/42. (Docs: https://en.wikipedia.org/wiki/Go_(programming_language))",
+			nil,
+			[]Link{
+				{Kind: LinkKindURL, Text: "https://github.com/coder/agentapi/pull/42", URL: "https://github.com/coder/agentapi/pull/42"},
+				{Kind: LinkKindURL, Text: "https://en.wikipedia.org/wiki/Go_(programming_language)", URL: "https://en.wikipedia.org/wiki/Go_(programming_language)"},
+			},
+		},
+		{
+			"file references",
+			"I fixed the bug in lib/httpapi/server.go:123 and `./main.go:7:2`, but not in https://example.com/a.go:1.",
+			nil,
+			[]Link{
+				{Kind: LinkKindFile, Text: "lib/httpapi/server.go:123", Path: "lib/httpapi/server.go", Line: 123},
+				{Kind: LinkKindFile, Text: "./main.go:7:2", Path: "./main.go", Line: 7, Column: 2},
+				{Kind: LinkKindURL, Text: "https://example.com/a.go:1", URL: "https://example.com/a.go:1"},
+			},
+		},
+		{
+			"hyperlinks",
+			"Opened PR #42 after editing server.go.",
+			[]Hyperlink{
+				{Text: "PR #42", URL: "https://github.com/coder/agentapi/pull/42"},
+				{Text: "server.go", URL: "file:///home/coder/agentapi/server.go#L12"},
+				{Text: "not in the message", URL: "https://example.com"},
+			},
+			[]Link{
+				{Kind: LinkKindURL, Text: "PR #42", URL: "https://github.com/coder/agentapi/pull/42"},
+				{Kind: LinkKindFile, Text: "server.go", URL: "file:///home/coder/agentapi/server.go#L12", Path: "/home/coder/agentapi/server.go", Line: 12},
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, FindLinks(c.message, c.hyperlinks))
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package msgfmt
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	boxTopCorners    = "┌╭┏╔"
+	boxBottomCorners = "└╰┗╚"
+	boxSeparators    = "├┣╠╞┝"
+	boxVerticals     = "│┃║"
+	// codeBlockIndent is how much deeper than the surrounding text lines
+	// must be indented to be taken for code.
+	codeBlockIndent = 4
+)
+
+// ToMarkdown converts an agent message as rendered in a terminal into
+// markdown. Boxes drawn with box-drawing characters become markdown tables
+// if they have several columns, and code blocks otherwise, as agents draw
+// boxes around commands and their output. Indented blocks following a blank
+// line or a colon are fenced as code, since agents render code blocks by
+// indenting and coloring them, and the colors are lost. Existing code
+// fences are preserved.
+func ToMarkdown(message string) string {
+	lines := strings.Split(message, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, WhiteSpaceChars)
+	}
+	baseIndent := -1
+	for _, line := range lines {
+		if line != "" && (baseIndent < 0 || indentOf(line) < baseIndent) {
+			baseIndent = indentOf(line)
+		}
+	}
+
+	var out []string
+	inFence := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") {
+			inFence = !inFence
+			out = append(out, line)
+			continue
+		}
+		if inFence {
+			out = append(out, line)
+			continue
+		}
+
+		if startsWithAny(trimmed, boxTopCorners) {
+			if converted, end, ok := convertBox(lines, i); ok {
+				out = append(out, converted...)
+				i = end
+				continue
+			}
+		}
+
+		prev := ""
+		if len(out) > 0 {
+			prev = out[len(out)-1]
+		}
+		if line != "" && indentOf(line) >= baseIndent+codeBlockIndent &&
+			(strings.TrimSpace(prev) == "" || strings.HasSuffix(prev, ":")) {
+			end := i
+			for j := i + 1; j < len(lines); j++ {
+				if lines[j] == "" {
+					continue
+				}
+				if indentOf(lines[j]) < baseIndent+codeBlockIndent {
+					break
+				}
+				end = j
+			}
+			out = append(out, "```")
+			for _, code := range lines[i : end+1] {
+				out = append(out, removeIndent(code, baseIndent+codeBlockIndent))
+			}
+			out = append(out, "```")
+			i = end
+			continue
+		}
+
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// convertBox converts the box starting at lines[start]. It returns the
+// index of the box's last line, or ok false if the box isn't closed.
+func convertBox(lines []string, start int) (converted []string, end int, ok bool) {
+	// Each row is a list of cells, each cell the list of its lines.
+	var rows [][][]string
+	var row [][]string
+	columns := 0
+	for end = start + 1; end < len(lines); end++ {
+		trimmed := strings.TrimSpace(lines[end])
+		switch {
+		case startsWithAny(trimmed, boxVerticals):
+			cells := splitBoxRow(trimmed)
+			columns = max(columns, len(cells))
+			for len(row) < len(cells) {
+				row = append(row, nil)
+			}
+			for i, cell := range cells {
+				row[i] = append(row[i], cell)
+			}
+		case startsWithAny(trimmed, boxSeparators), startsWithAny(trimmed, boxBottomCorners):
+			if row != nil {
+				rows = append(rows, row)
+				row = nil
+			}
+			if startsWithAny(trimmed, boxBottomCorners) {
+				if columns == 0 {
+					return nil, 0, false
+				}
+				if columns == 1 {
+					return boxToCodeBlock(rows), end, true
+				}
+				return boxToTable(rows, columns), end, true
+			}
+		default:
+			return nil, 0, false
+		}
+	}
+	return nil, 0, false
+}
+
+// splitBoxRow splits a row such as "│ a │ b │" into its cells, without the
+// space padding them from the borders.
+func splitBoxRow(row string) []string {
+	_, size := utf8.DecodeRuneInString(row)
+	var cells []string
+	var cell strings.Builder
+	for _, r := range row[size:] {
+		if strings.ContainsRune(boxVerticals, r) {
+			cells = append(cells, strings.TrimPrefix(strings.TrimRight(cell.String(), " "), " "))
+			cell.Reset()
+			continue
+		}
+		cell.WriteRune(r)
+	}
+	// Text after the last border, if the row isn't closed.
+	if rest := strings.TrimSpace(cell.String()); rest != "" {
+		cells = append(cells, rest)
+	}
+	return cells
+}
+
+func boxToCodeBlock(rows [][][]string) []string {
+	code := []string{"```"}
+	for _, row := range rows {
+		code = append(code, row[0]...)
+	}
+	return append(code, "```")
+}
+
+func boxToTable(rows [][][]string, columns int) []string {
+	table := make([]string, 0, len(rows)+1)
+	for i, row := range rows {
+		cells := make([]string, columns)
+		for j := range row {
+			var parts []string
+			for _, part := range row[j] {
+				if part = strings.TrimSpace(part); part != "" {
+					parts = append(parts, part)
+				}
+			}
+			cells[j] = strings.ReplaceAll(strings.Join(parts, " "), "|", `\|`)
+		}
+		table = append(table, "| "+strings.Join(cells, " | ")+" |")
+		if i == 0 {
+			table = append(table, "|"+strings.Repeat(" --- |", columns))
+		}
+	}
+	return table
+}
+
+func startsWithAny(s string, chars string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return s != "" && strings.ContainsRune(chars, r)
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func removeIndent(line string, indent int) string {
+	return line[min(indent, indentOf(line)):]
+}
@@ -0,0 +1,50 @@
+package msgfmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToMarkdown(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{
+			"plain text",
+			"  Hello!\n\n  1. First\n  2. Second",
+			"  Hello!\n\n  1. First\n  2. Second",
+		},
+		{
+			"table",
+			"Results:\n┌──────────┬────────┐\n│ Package  │ Status │\n├──────────┼────────┤\n│ msgfmt   │ ok     │\n├──────────┼────────┤\n│ httpapi  │ FAIL   │\n│ (server) │        │\n└──────────┴────────┘\nDone.",
+			"Results:\n| Package | Status |\n| --- | --- |\n| msgfmt | ok |\n| httpapi (server) | FAIL |\nDone.",
+		},
+		{
+			"command box",
+			"  Counting files.\n\n ┌──────────────────────────────┐   \n │ $ ls | wc -l                 │\n │                              │\n │       17                     │\n └──────────────────────────────┘\n\n  17",
+			"  Counting files.\n\n```\n$ ls | wc -l\n\n      17\n```\n\n  17",
+		},
+		{
+			"indented code",
+			"  Add this to main.go:\n      func main() {\n          fmt.Println(\"hi\")\n\n      }\n  Then run it.",
+			"  Add this to main.go:\n```\nfunc main() {\n    fmt.Println(\"hi\")\n\n}\n```\n  Then run it.",
+		},
+		{
+			"existing fence",
+			"```\n┌─┬─┐\n│a│b│\n└─┴─┘\n```",
+			"```\n┌─┬─┐\n│a│b│\n└─┴─┘\n```",
+		},
+		{
+			"unclosed box",
+			"╭───╮\n│ a │",
+			"╭───╮\n│ a │",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, ToMarkdown(c.message))
+		})
+	}
+}
@@ -6,8 +6,10 @@ import (
 
 const WhiteSpaceChars = " \t\n\r\f\v"
 
+// TrimWhitespace removes leading and trailing whitespace, including
+// non-ASCII whitespace such as the ideographic space.
 func TrimWhitespace(msg string) string {
-	return strings.Trim(msg, WhiteSpaceChars)
+	return strings.TrimFunc(msg, isWhitespace)
 }
 
 // IndexSubslice returns the index of the first instance of sub in s,
@@ -38,26 +40,30 @@ func IndexSubslice[T comparable](s, sub []T) int {
 	return -1
 }
 
-// Normalize the string to remove any whitespace.
-// Remember in which line each rune is located.
-// Return the runes, the lines, and the rune to line location mapping.
-func normalizeAndGetRuneLineMapping(msgRaw string) ([]rune, []string, []int) {
+// Normalize the string to remove any whitespace and zero-width characters.
+// The string is split into grapheme clusters rather than runes, so that
+// matching never stops in the middle of an emoji sequence or before a
+// combining mark. Remember in which line each grapheme is located.
+// Return the graphemes, the lines, and the grapheme to line location mapping.
+func normalizeAndGetGraphemeLineMapping(msgRaw string) ([]string, []string, []int) {
 	msgLines := strings.Split(msgRaw, "\n")
-	var msgRuneLineLocations []int
-	var runes []rune
+	var msgGraphemeLineLocations []int
+	var graphemes []string
 	for lineIdx, line := range msgLines {
-		for _, r := range line {
-			if !strings.ContainsRune(WhiteSpaceChars, r) {
-				runes = append(runes, r)
-				msgRuneLineLocations = append(msgRuneLineLocations, lineIdx)
+		for _, cluster := range splitGraphemes(line) {
+			cluster = normalizeGrapheme(cluster)
+			if cluster == "" || strings.TrimFunc(cluster, isWhitespace) == "" {
+				continue
 			}
+			graphemes = append(graphemes, cluster)
+			msgGraphemeLineLocations = append(msgGraphemeLineLocations, lineIdx)
 		}
 	}
-	return runes, msgLines, msgRuneLineLocations
+	return graphemes, msgLines, msgGraphemeLineLocations
 }
 
 // Find where the user input starts in the message
-func findUserInputStartIdx(msg []rune, msgRuneLineLocations []int, userInput []rune, userInputLineLocations []int) int {
+func findUserInputStartIdx[T comparable](msg []T, msgRuneLineLocations []int, userInput []T, userInputLineLocations []int) int {
 	// We take up to 6 runes from the first line of the user input
 	// and search for it in the message. 6 is arbitrary.
 	// We only look at the first line to avoid running into user input
@@ -107,7 +113,7 @@ func findUserInputStartIdx(msg []rune, msgRuneLineLocations []int, userInput []r
 // "```" and instead formats enclosed text as a code block).
 // We're going to see if any of the next 5 runes in the message
 // match any of the next 5 runes in the user input.
-func findNextMatch(knownMsgMatchIdx int, knownUserInputMatchIdx int, msg []rune, userInput []rune) (int, int) {
+func findNextMatch[T comparable](knownMsgMatchIdx int, knownUserInputMatchIdx int, msg []T, userInput []T) (int, int) {
 	for i := range 5 {
 		for j := range 5 {
 			userInputIdx := knownUserInputMatchIdx + i + 1
@@ -126,7 +132,7 @@ func findNextMatch(knownMsgMatchIdx int, knownUserInputMatchIdx int, msg []rune,
 
 // Find where the user input ends in the message. Returns the index of the last rune
 // of the user input in the message.
-func findUserInputEndIdx(userInputStartIdx int, msg []rune, userInput []rune) int {
+func findUserInputEndIdx[T comparable](userInputStartIdx int, msg []T, userInput []T) int {
 	userInputIdx := 0
 	msgIdx := userInputStartIdx
 	for {
@@ -171,8 +177,8 @@ func RemoveUserInput(msgRaw string, userInputRaw string, agentType AgentType) st
 	if userInputRaw == "" {
 		return msgRaw
 	}
-	msg, msgLines, msgRuneLineLocations := normalizeAndGetRuneLineMapping(msgRaw)
-	userInput, _, userInputLineLocations := normalizeAndGetRuneLineMapping(userInputRaw)
+	msg, msgLines, msgRuneLineLocations := normalizeAndGetGraphemeLineMapping(msgRaw)
+	userInput, _, userInputLineLocations := normalizeAndGetGraphemeLineMapping(userInputRaw)
 	userInputStartIdx := findUserInputStartIdx(msg, msgRuneLineLocations, userInput, userInputLineLocations)
 
 	if userInputStartIdx == -1 {
@@ -208,6 +214,17 @@ func RemoveUserInput(msgRaw string, userInputRaw string, agentType AgentType) st
 	return strings.Join(msgLines[lastUserInputLineIdx+1:], "\n")
 }
 
+// UserInputEchoed reports whether RemoveUserInput finds the user input
+// echoed in the message, and so removes it.
+func UserInputEchoed(msgRaw string, userInputRaw string) bool {
+	if userInputRaw == "" {
+		return false
+	}
+	msg, _, msgRuneLineLocations := normalizeAndGetGraphemeLineMapping(msgRaw)
+	userInput, _, userInputLineLocations := normalizeAndGetGraphemeLineMapping(userInputRaw)
+	return findUserInputStartIdx(msg, msgRuneLineLocations, userInput, userInputLineLocations) != -1
+}
+
 func trimEmptyLines(message string) string {
 	lines := strings.Split(message, "\n")
 	firstIdx := 0
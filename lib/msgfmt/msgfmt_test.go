@@ -9,26 +9,60 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func TestNormalizeAndGetRuneLineMapping(t *testing.T) {
+func TestNormalizeAndGetGraphemeLineMapping(t *testing.T) {
 	msg := "Hello, World!\n \nTest.\n"
-	normalizedMsg, lines, runeLineLocations := normalizeAndGetRuneLineMapping(msg)
-	assert.Equal(t, normalizedMsg, []rune("Hello,World!Test."))
+	normalizedMsg, lines, runeLineLocations := normalizeAndGetGraphemeLineMapping(msg)
+	assert.Equal(t, normalizedMsg, strings.Split("Hello,World!Test.", ""))
 	assert.Equal(t, []string{"Hello, World!", " ", "Test.", ""}, lines)
 	assert.Equal(t, []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 2, 2, 2, 2}, runeLineLocations)
 
 	nonAscii := "😄😄😄😄😄🎉🎉🎉🎉🎉🌮"
-	normalizedNonAscii, lines, runeLineLocations := normalizeAndGetRuneLineMapping(nonAscii)
+	normalizedNonAscii, lines, runeLineLocations := normalizeAndGetGraphemeLineMapping(nonAscii)
 	assert.Equal(t, len([]rune(nonAscii)), len(runeLineLocations))
-	assert.Equal(t, normalizedNonAscii, []rune("😄😄😄😄😄🎉🎉🎉🎉🎉🌮"))
+	assert.Equal(t, normalizedNonAscii, strings.Split("😄😄😄😄😄🎉🎉🎉🎉🎉🌮", ""))
 	assert.Equal(t, []string{"😄😄😄😄😄🎉🎉🎉🎉🎉🌮"}, lines)
 	assert.Equal(t, []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, runeLineLocations)
 
 	nonAscii2 := "╭───"
-	normalizedNonAscii2, lines, runeLineLocations := normalizeAndGetRuneLineMapping(nonAscii2)
+	normalizedNonAscii2, lines, runeLineLocations := normalizeAndGetGraphemeLineMapping(nonAscii2)
 	assert.Equal(t, len([]rune(nonAscii2)), len(runeLineLocations))
-	assert.Equal(t, normalizedNonAscii2, []rune("╭───"))
+	assert.Equal(t, normalizedNonAscii2, strings.Split("╭───", ""))
 	assert.Equal(t, []string{"╭───"}, lines)
 	assert.Equal(t, []int{0, 0, 0, 0}, runeLineLocations)
+
+	// Non-ASCII whitespace and zero-width characters are removed too.
+	cjk := "你好\u3000世界\n\u00a0❤\ufe0f"
+	normalizedCJK, lines, runeLineLocations := normalizeAndGetGraphemeLineMapping(cjk)
+	assert.Equal(t, strings.Split("你好世界❤", ""), normalizedCJK)
+	assert.Equal(t, []string{"你好\u3000世界", "\u00a0❤\ufe0f"}, lines)
+	assert.Equal(t, []int{0, 0, 0, 0, 1}, runeLineLocations)
+
+	// Multi-rune emoji form a single grapheme. Invisible runes such as
+	// joiners and combining marks are ignored.
+	graphemes := "👨\u200d👩\u200d👧 🇫🇷 👍🏽 e\u0301"
+	normalizedGraphemes, _, runeLineLocations := normalizeAndGetGraphemeLineMapping(graphemes)
+	assert.Equal(t, []string{"👨👩👧", "🇫🇷", "👍🏽", "e"}, normalizedGraphemes)
+	assert.Equal(t, []int{0, 0, 0, 0}, runeLineLocations)
+}
+
+func TestTrimWhitespace(t *testing.T) {
+	assert.Equal(t, "こんにちは 世界", TrimWhitespace("\u3000 こんにちは 世界\u00a0\n"))
+}
+
+func TestStringWidth(t *testing.T) {
+	assert.Equal(t, 5, StringWidth("hello"))
+	assert.Equal(t, 4, StringWidth("你好"))
+	// Box-drawing characters are ambiguous-width, but agents draw them in
+	// a single column.
+	assert.Equal(t, 3, StringWidth("╭─╮"))
+	assert.Equal(t, 1, StringWidth("e\u0301"))
+}
+
+func TestContinuesGrapheme(t *testing.T) {
+	assert.True(t, ContinuesGrapheme("family: 👨", "\u200d👩\u200d👧"))
+	assert.True(t, ContinuesGrapheme("cafe", "\u0301 ouvert"))
+	assert.False(t, ContinuesGrapheme("family: 👨", " 👩"))
+	assert.False(t, ContinuesGrapheme("", "\u200d👩"))
 }
 
 func TestFindUserInputStartIdx(t *testing.T) {
@@ -189,6 +223,7 @@ func TestRemoveUserInput(t *testing.T) {
 			expected, err := testdataDir.ReadFile(path.Join(dir, c.Name(), "expected.txt"))
 			assert.NoError(t, err)
 			assert.Equal(t, string(expected), RemoveUserInput(string(msg), string(userInput), AgentTypeCustom))
+			assert.Equal(t, string(expected) != string(msg), UserInputEchoed(string(msg), string(userInput)))
 		})
 	}
 }
@@ -0,0 +1,98 @@
+package msgfmt
+
+import (
+	"fmt"
+	"strings"
+)
+
+type ParseIssueKind string
+
+const (
+	// ParseIssueBoxDrawing means the message is dominated by box-drawing
+	// characters, which usually indicates an input box or other UI chrome
+	// that the formatter failed to remove.
+	ParseIssueBoxDrawing ParseIssueKind = "box_drawing"
+	// ParseIssueEchoedInput means the user's message is still present near
+	// the top of the agent message, i.e. echo removal didn't match.
+	ParseIssueEchoedInput ParseIssueKind = "echoed_input"
+)
+
+type ParseIssue struct {
+	Kind   ParseIssueKind
+	Detail string
+}
+
+const (
+	// Messages with fewer box-drawing runes than this are never flagged,
+	// so a short separator line doesn't trigger a warning.
+	minBoxDrawingRunes = 10
+	// Percentage of non-whitespace runes that must be box-drawing runes.
+	boxDrawingDensityThreshold = 20
+	// User input narrower than this many columns is too likely to appear
+	// in the reply by coincidence. Measuring columns rather than runes
+	// counts CJK characters, which carry more meaning, twice.
+	minEchoedInputWidth = 8
+	// Echoed input is only searched for in the first few lines.
+	echoedInputSearchLines = 5
+)
+
+func isBoxDrawingRune(r rune) bool {
+	// Box Drawing and Block Elements blocks.
+	return r >= 0x2500 && r <= 0x259F
+}
+
+// DetectParseIssues inspects a formatted agent message and reports
+// heuristics suggesting that the message still contains terminal UI
+// chrome. userInput is the user message the agent is responding to.
+func DetectParseIssues(message string, userInput string) []ParseIssue {
+	var issues []ParseIssue
+
+	boxRunes, nonSpaceRunes := 0, 0
+	for _, r := range message {
+		if isWhitespace(r) {
+			continue
+		}
+		nonSpaceRunes++
+		if isBoxDrawingRune(r) {
+			boxRunes++
+		}
+	}
+	if boxRunes >= minBoxDrawingRunes && boxRunes*100 >= nonSpaceRunes*boxDrawingDensityThreshold {
+		issues = append(issues, ParseIssue{
+			Kind:   ParseIssueBoxDrawing,
+			Detail: fmt.Sprintf("%d of %d non-whitespace characters are box-drawing characters", boxRunes, nonSpaceRunes),
+		})
+	}
+
+	userInputFirstLine, _, _ := strings.Cut(TrimWhitespace(userInput), "\n")
+	inputGraphemes, _, _ := normalizeAndGetGraphemeLineMapping(userInputFirstLine)
+	if StringWidth(strings.Join(inputGraphemes, "")) >= minEchoedInputWidth {
+		msgGraphemes, _, msgGraphemeLines := normalizeAndGetGraphemeLineMapping(trimEmptyLines(message))
+		prefixLen := 0
+		for i, lineIdx := range msgGraphemeLines {
+			if lineIdx >= echoedInputSearchLines {
+				break
+			}
+			prefixLen = i + 1
+		}
+		if IndexSubslice(msgGraphemes[:prefixLen], inputGraphemes) != -1 {
+			issues = append(issues, ParseIssue{
+				Kind:   ParseIssueEchoedInput,
+				Detail: "the first line of the user message appears at the start of the agent message",
+			})
+		}
+	}
+
+	return issues
+}
+
+// ParseConfidence estimates, from 0 to 1, how likely a formatted agent
+// message is free of terminal UI. Each issue found by DetectParseIssues
+// halves it.
+func ParseConfidence(message string, userInput string) float64 {
+	confidence := 1.0
+	for range DetectParseIssues(message, userInput) {
+		confidence /= 2
+	}
+	return confidence
+}
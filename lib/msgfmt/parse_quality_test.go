@@ -0,0 +1,93 @@
+package msgfmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectParseIssues(t *testing.T) {
+	cases := []struct {
+		name      string
+		message   string
+		userInput string
+		expected  []ParseIssueKind
+	}{
+		{
+			name:      "clean message",
+			message:   "Sure, here is the function you asked for.\nIt returns the sum.",
+			userInput: "Write a function that adds two numbers",
+			expected:  nil,
+		},
+		{
+			name:      "leftover input box",
+			message:   "Done.\n╭──────────────────────────╮\n│ >                        │\n╰──────────────────────────╯",
+			userInput: "Do the thing",
+			expected:  []ParseIssueKind{ParseIssueBoxDrawing},
+		},
+		{
+			name:      "short separator is fine",
+			message:   "Section one\n─────\nSection two with a lot more text in it than the separator",
+			userInput: "Hello there",
+			expected:  nil,
+		},
+		{
+			name:      "echoed user input",
+			message:   "> Write a function that adds\n  two numbers\n\nSure, here it is.",
+			userInput: "Write a function that adds\ntwo numbers",
+			expected:  []ParseIssueKind{ParseIssueEchoedInput},
+		},
+		{
+			name:      "user input quoted later in the reply",
+			message:   "Sure.\n\nHere is the plan.\n\nStep one.\n\nStep two.\n\nYou asked: Write a function that adds",
+			userInput: "Write a function that adds",
+			expected:  nil,
+		},
+		{
+			name:      "echoed CJK user input",
+			message:   "> 测试失败了\n\n好的，我来看看。",
+			userInput: "测试失败了",
+			expected:  []ParseIssueKind{ParseIssueEchoedInput},
+		},
+		{
+			name:      "short user input is ignored",
+			message:   "hi\nhello to you too",
+			userInput: "hi",
+			expected:  nil,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var kinds []ParseIssueKind
+			for _, issue := range DetectParseIssues(c.message, c.userInput) {
+				kinds = append(kinds, issue.Kind)
+			}
+			assert.Equal(t, c.expected, kinds)
+		})
+	}
+
+	t.Run("formatted fixtures are clean", func(t *testing.T) {
+		// Sanity check against false positives: the expected output of the
+		// formatting fixtures should never trigger the echoed input heuristic.
+		for _, agentType := range []AgentType{AgentTypeClaude, AgentTypeCodex, AgentTypeGemini} {
+			cases, err := testdataDir.ReadDir("testdata/format/" + string(agentType))
+			assert.NoError(t, err)
+			for _, c := range cases {
+				dir := "testdata/format/" + string(agentType) + "/" + c.Name()
+				expected, err := testdataDir.ReadFile(dir + "/expected.txt")
+				assert.NoError(t, err)
+				userInput, err := testdataDir.ReadFile(dir + "/user.txt")
+				assert.NoError(t, err)
+				for _, issue := range DetectParseIssues(string(expected), string(userInput)) {
+					assert.NotEqual(t, ParseIssueEchoedInput, issue.Kind, "%s: %s", dir, issue.Detail)
+				}
+			}
+		}
+	})
+}
+
+func TestParseConfidence(t *testing.T) {
+	assert.Equal(t, 1.0, ParseConfidence("All tests passed.", "run the tests"))
+	assert.Equal(t, 0.5, ParseConfidence("╭──────────────╮\n│ >            │\n╰──────────────╯", ""))
+	assert.Equal(t, 0.25, ParseConfidence("run the tests please\n╭──────────────╮\n╰──────────────╯", "run the tests please"))
+}
@@ -0,0 +1,213 @@
+package msgfmt
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// PromptOption is a choice offered by an interactive prompt.
+type PromptOption struct {
+	// Key identifies the option: the number or letter shown on screen.
+	Key   string
+	Label string
+	// Selected is set on the option chosen by pressing Enter.
+	Selected bool
+}
+
+// Prompt is an interactive prompt the agent waits on, such as a permission
+// dialog.
+type Prompt struct {
+	// Question is the line asking the question, if it could be identified.
+	Question string
+	Options  []PromptOption
+	// menu is set for menus navigated with the arrow keys, rather than
+	// answered by typing a letter.
+	menu bool
+}
+
+func (p Prompt) Equal(other Prompt) bool {
+	return p.Question == other.Question && p.menu == other.menu && slices.Equal(p.Options, other.Options)
+}
+
+// Reply returns the input that chooses the option with the given key, and
+// false if the prompt has no such option.
+func (p Prompt) Reply(key string) (string, bool) {
+	target, selected := -1, -1
+	for i, option := range p.Options {
+		if strings.EqualFold(option.Key, key) {
+			target = i
+		}
+		if option.Selected {
+			selected = i
+		}
+	}
+	if target < 0 {
+		return "", false
+	}
+	if !p.menu {
+		return p.Options[target].Key + "\r", true
+	}
+	if selected < 0 {
+		// Menus without a visible selection are answered with number keys.
+		return p.Options[target].Key, true
+	}
+	if target < selected {
+		return strings.Repeat("\x1b[A", selected-target) + "\r", true
+	}
+	return strings.Repeat("\x1b[B", target-selected) + "\r", true
+}
+
+// promptSearchLines is how many non-blank lines at the bottom of the screen
+// are searched for a prompt.
+const promptSearchLines = 12
+
+var (
+	// menuOptionPattern matches options of menus such as Claude Code's
+	// permission dialogs, "❯ 1. Yes", with the selection marker.
+	menuOptionPattern = regexp.MustCompile(`^([❯›>]\s*)?(\d+)[.)]\s+(.+)$`)
+	// letterChoicePattern matches choices at the end of a question, such
+	// as "[y/N]" or "(y/n/t):".
+	letterChoicePattern = regexp.MustCompile(`^(.*?)\s*[\[(]([A-Za-z](?:/[A-Za-z])+)[\])]:?$`)
+	// wordChoicePattern matches Aider's choices, such as
+	// "(Y)es/(N)o/(D)on't ask again [Yes]:".
+	wordChoicePattern = regexp.MustCompile(`^(.*?)\s*(\([A-Za-z]\)[^/\[]*(?:/\([A-Za-z]\)[^/\[]*)+?)\s*\[([^\]]*)\]:?$`)
+	wordChoicePart    = regexp.MustCompile(`^\(([A-Za-z])\)(.*)$`)
+)
+
+// letterLabels are the labels of the usual single-letter choices.
+var letterLabels = map[string]string{
+	"y": "Yes",
+	"n": "No",
+	"a": "All",
+	"t": "Trust",
+	"s": "Skip",
+	"d": "Don't ask again",
+}
+
+// stripBoxBorders removes the borders of a box drawn around a line.
+func stripBoxBorders(line string) string {
+	return strings.TrimSpace(strings.Trim(strings.TrimSpace(line), "│┃║|"))
+}
+
+// DetectPrompt finds an interactive prompt at the bottom of the screen:
+// numbered menus such as Claude Code's permission dialogs, and questions
+// answered with a letter, such as "[y/n]".
+func DetectPrompt(screen string) (Prompt, bool) {
+	var lines []string
+	all := strings.Split(screen, "\n")
+	for i := len(all) - 1; i >= 0 && len(lines) < promptSearchLines; i-- {
+		line := stripBoxBorders(all[i])
+		if strings.Trim(line, "─━═╭╮╰╯┌┐└┘ ") == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	slices.Reverse(lines)
+	if len(lines) == 0 {
+		return Prompt{}, false
+	}
+
+	// Letter choices are asked on the last lines, below which there's at
+	// most the agent's input line.
+	for i := len(lines) - 1; i >= max(0, len(lines)-2); i-- {
+		if prompt, ok := detectChoicePrompt(lines, i); ok {
+			return prompt, true
+		}
+	}
+	return detectMenuPrompt(lines)
+}
+
+func detectChoicePrompt(lines []string, i int) (Prompt, bool) {
+	var prompt Prompt
+	if m := wordChoicePattern.FindStringSubmatch(lines[i]); m != nil {
+		prompt.Question = m[1]
+		for _, part := range strings.Split(m[2], "/") {
+			pm := wordChoicePart.FindStringSubmatch(strings.TrimSpace(part))
+			if pm == nil {
+				return Prompt{}, false
+			}
+			label := pm[1] + pm[2]
+			prompt.Options = append(prompt.Options, PromptOption{
+				Key:      strings.ToLower(pm[1]),
+				Label:    label,
+				Selected: strings.EqualFold(label, m[3]),
+			})
+		}
+	} else if m := letterChoicePattern.FindStringSubmatch(lines[i]); m != nil {
+		prompt.Question = m[1]
+		for _, letter := range strings.Split(m[2], "/") {
+			key := strings.ToLower(letter)
+			label, ok := letterLabels[key]
+			if !ok {
+				label = key
+			}
+			prompt.Options = append(prompt.Options, PromptOption{
+				Key:   key,
+				Label: label,
+				// An uppercase letter marks the default, as in [y/N].
+				Selected: letter != key,
+			})
+		}
+	} else {
+		return Prompt{}, false
+	}
+	if prompt.Question == "" && i > 0 {
+		prompt.Question = lines[i-1]
+	}
+	return prompt, true
+}
+
+// detectMenuPrompt finds the last run of consecutively numbered options
+// with one of them marked as selected.
+func detectMenuPrompt(lines []string) (Prompt, bool) {
+	for end := len(lines) - 1; end >= 0; end-- {
+		m := menuOptionPattern.FindStringSubmatch(lines[end])
+		if m == nil {
+			continue
+		}
+		// Walk up to the first option.
+		start := end
+		for start > 0 {
+			prev := menuOptionPattern.FindStringSubmatch(lines[start-1])
+			cur := menuOptionPattern.FindStringSubmatch(lines[start])
+			if prev == nil || !consecutive(prev[2], cur[2]) {
+				break
+			}
+			start--
+		}
+		prompt := Prompt{menu: true}
+		selected := 0
+		for _, line := range lines[start : end+1] {
+			m := menuOptionPattern.FindStringSubmatch(line)
+			option := PromptOption{Key: m[2], Label: strings.TrimSpace(m[3]), Selected: m[1] != ""}
+			if option.Selected {
+				selected++
+			}
+			prompt.Options = append(prompt.Options, option)
+		}
+		if len(prompt.Options) < 2 || prompt.Options[0].Key != "1" || selected != 1 {
+			return Prompt{}, false
+		}
+		// The question is usually right above the options, possibly with
+		// a line of explanation in between.
+		for i := start - 1; i >= max(0, start-3); i-- {
+			if strings.HasSuffix(lines[i], "?") {
+				prompt.Question = lines[i]
+				break
+			}
+		}
+		if prompt.Question == "" && start > 0 {
+			prompt.Question = lines[start-1]
+		}
+		return prompt, true
+	}
+	return Prompt{}, false
+}
+
+func consecutive(prev, cur string) bool {
+	p, err1 := strconv.Atoi(prev)
+	c, err2 := strconv.Atoi(cur)
+	return err1 == nil && err2 == nil && c == p+1
+}
@@ -0,0 +1,105 @@
+package msgfmt
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectPrompt(t *testing.T) {
+	readScreen := func(t *testing.T, name string) string {
+		t.Helper()
+		msg, err := testdataDir.ReadFile(path.Join("testdata/format", name, "msg.txt"))
+		require.NoError(t, err)
+		return string(msg)
+	}
+
+	t.Run("numbered menu in a box", func(t *testing.T) {
+		prompt, ok := DetectPrompt(readScreen(t, "copilot/confirmation_box"))
+		require.True(t, ok)
+		assert.Equal(t, "Do you want to run this command?", prompt.Question)
+		assert.Equal(t, []PromptOption{
+			{Key: "1", Label: "Yes", Selected: true},
+			{Key: "2", Label: "Yes, and approve `xargs` for the rest of the running session"},
+			{Key: "3", Label: "No, and tell Copilot what to do differently (Esc)"},
+		}, prompt.Options)
+	})
+
+	t.Run("numbered menu without a question mark", func(t *testing.T) {
+		prompt, ok := DetectPrompt(readScreen(t, "codex/confirmation_box"))
+		require.True(t, ok)
+		assert.Equal(t, "approval of all edits and commands.", prompt.Question)
+		assert.Equal(t, []PromptOption{
+			{Key: "1", Label: "Allow Codex to work in this folder without asking for approval"},
+			{Key: "2", Label: "Require approval of edits and commands", Selected: true},
+		}, prompt.Options)
+	})
+
+	t.Run("letter choices", func(t *testing.T) {
+		prompt, ok := DetectPrompt(readScreen(t, "amazonq/confirmation_box"))
+		require.True(t, ok)
+		assert.Equal(t, "Allow this action? Use 't' to trust (always allow) this tool for the session.", prompt.Question)
+		assert.Equal(t, []PromptOption{
+			{Key: "y", Label: "Yes"},
+			{Key: "n", Label: "No"},
+			{Key: "t", Label: "Trust"},
+		}, prompt.Options)
+	})
+
+	t.Run("default letter", func(t *testing.T) {
+		prompt, ok := DetectPrompt("Overwrite file.txt? [y/N]")
+		require.True(t, ok)
+		assert.Equal(t, "Overwrite file.txt?", prompt.Question)
+		assert.Equal(t, []PromptOption{
+			{Key: "y", Label: "Yes"},
+			{Key: "n", Label: "No", Selected: true},
+		}, prompt.Options)
+	})
+
+	t.Run("word choices", func(t *testing.T) {
+		prompt, ok := DetectPrompt("Add file to the chat? (Y)es/(N)o/(D)on't ask again [Yes]:   \n")
+		require.True(t, ok)
+		assert.Equal(t, "Add file to the chat?", prompt.Question)
+		assert.Equal(t, []PromptOption{
+			{Key: "y", Label: "Yes", Selected: true},
+			{Key: "n", Label: "No"},
+			{Key: "d", Label: "Don't ask again"},
+		}, prompt.Options)
+	})
+
+	t.Run("no prompt", func(t *testing.T) {
+		for _, name := range []string{"claude/first_message", "aider/multi-line-input"} {
+			_, ok := DetectPrompt(readScreen(t, name))
+			assert.False(t, ok, name)
+		}
+		// A numbered list in a message has no selection marker.
+		_, ok := DetectPrompt("Steps:\n1. Install\n2. Run\n\n> ")
+		assert.False(t, ok)
+	})
+}
+
+func TestPromptReply(t *testing.T) {
+	menu := Prompt{menu: true, Options: []PromptOption{
+		{Key: "1", Label: "Yes"},
+		{Key: "2", Label: "Always", Selected: true},
+		{Key: "3", Label: "No"},
+	}}
+	reply, ok := menu.Reply("3")
+	require.True(t, ok)
+	assert.Equal(t, "\x1b[B\r", reply)
+	reply, ok = menu.Reply("1")
+	require.True(t, ok)
+	assert.Equal(t, "\x1b[A\r", reply)
+	reply, ok = menu.Reply("2")
+	require.True(t, ok)
+	assert.Equal(t, "\r", reply)
+	_, ok = menu.Reply("4")
+	assert.False(t, ok)
+
+	choice := Prompt{Options: []PromptOption{{Key: "y", Label: "Yes"}, {Key: "n", Label: "No"}}}
+	reply, ok = choice.Reply("Y")
+	require.True(t, ok)
+	assert.Equal(t, "y\r", reply)
+}
@@ -0,0 +1,56 @@
+package msgfmt
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ReportTaskToolName is the name of the tool Coder's MCP server exposes for
+// agents to report the status of their task.
+const ReportTaskToolName = "coder_report_task"
+
+// ReportTask holds the arguments of a coder_report_task tool call.
+type ReportTask struct {
+	State   string `json:"state"`
+	Summary string `json:"summary"`
+	Link    string `json:"link"`
+}
+
+// reportTaskArgPattern matches an argument as rendered by Claude Code
+// (`summary: "..."`) or Codex (`"summary":"..."`).
+var reportTaskArgPattern = regexp.MustCompile(`"?\b(state|summary|link)"?\s*:\s*("(?:[^"\\]|\\.)*")`)
+
+// ParseReportTask extracts the arguments of a coder_report_task tool call.
+// input is either the JSON arguments reported by structured transports or
+// a tool call removed from the screen by FormatToolCall. Lines wrapped by
+// the terminal are joined with a space, so long values may contain extra
+// spaces. ok is false if no state can be found.
+func ParseReportTask(input string) (task ReportTask, ok bool) {
+	input = strings.TrimSpace(input)
+	if err := json.Unmarshal([]byte(input), &task); err == nil {
+		return task, task.State != ""
+	}
+
+	lines := strings.Split(input, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	joined := strings.Join(lines, " ")
+	for _, match := range reportTaskArgPattern.FindAllStringSubmatch(joined, -1) {
+		value, err := strconv.Unquote(match[2])
+		if err != nil {
+			value = strings.Trim(match[2], `"`)
+		}
+		switch match[1] {
+		case "state":
+			task.State = value
+		case "summary":
+			task.Summary = value
+		case "link":
+			task.Link = value
+		}
+	}
+	return task, task.State != ""
+}
@@ -0,0 +1,43 @@
+package msgfmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReportTask(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		input    string
+		expected ReportTask
+		ok       bool
+	}{
+		{
+			"json",
+			`{"state":"complete","summary":"Done","link":"https://example.com"}`,
+			ReportTask{State: "complete", Summary: "Done", Link: "https://example.com"},
+			true,
+		},
+		{
+			"claude",
+			"● coder - coder_report_task (MCP)(summary: \"Building a snake game\n                                 with HTML/CSS/JavaScript\", link:\n                                 \"\", state: \"working\")\n  ⎿  {\n       \"message\": \"Thanks for reporting!\"\n     }",
+			ReportTask{State: "working", Summary: "Building a snake game with HTML/CSS/JavaScript"},
+			true,
+		},
+		{
+			"codex",
+			"• Called\n  └ Coder.coder_report_task({\"link\":\"snake-\n        game\",\"state\":\"failure\",\"summary\":\"Tests \\\"failed\\\"\"})\n    {\"message\": \"Thanks for reporting!\"}",
+			ReportTask{State: "failure", Summary: `Tests "failed"`, Link: "snake- game"},
+			true,
+		},
+		{"no state", `{"summary":"Done"}`, ReportTask{Summary: "Done"}, false},
+		{"not a tool call", "● I'll report my progress.", ReportTask{}, false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			task, ok := ParseReportTask(c.input)
+			assert.Equal(t, c.ok, ok)
+			assert.Equal(t, c.expected, task)
+		})
+	}
+}
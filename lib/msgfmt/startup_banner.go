@@ -0,0 +1,137 @@
+package msgfmt
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// StartupBanner is the configuration an agent shows when it starts. Fields
+// the banner doesn't show are left empty.
+type StartupBanner struct {
+	Version   string
+	Model     string
+	Workspace string
+	// WorkspaceTrusted is whether the agent trusts the files in the
+	// workspace, or nil if it doesn't say.
+	WorkspaceTrusted *bool
+	// MCPServers is the number of MCP servers the agent loaded, and
+	// MCPServersFailed the number of those that failed to connect.
+	MCPServers       int
+	MCPServersFailed int
+}
+
+// Merge returns b updated with the fields other knows, as the banner is
+// drawn over several screens.
+func (b StartupBanner) Merge(other StartupBanner) StartupBanner {
+	if other.Version != "" {
+		b.Version = other.Version
+	}
+	if other.Model != "" {
+		b.Model = other.Model
+	}
+	if other.Workspace != "" {
+		b.Workspace = other.Workspace
+	}
+	if other.WorkspaceTrusted != nil {
+		b.WorkspaceTrusted = other.WorkspaceTrusted
+	}
+	if other.MCPServers != 0 {
+		b.MCPServers = other.MCPServers
+	}
+	if other.MCPServersFailed != 0 {
+		b.MCPServersFailed = other.MCPServersFailed
+	}
+	return b
+}
+
+// IsZero reports whether no field of the banner is known.
+func (b StartupBanner) IsZero() bool {
+	return b == StartupBanner{}
+}
+
+// bannerPatterns match the fields of an agent's startup banner. The first
+// group of each is the field's value, and the first pattern that matches
+// wins.
+type bannerPatterns struct {
+	version    []*regexp.Regexp
+	model      []*regexp.Regexp
+	workspace  []*regexp.Regexp
+	mcpServers []*regexp.Regexp
+	mcpFailed  []*regexp.Regexp
+	// trusted matches banners only shown once the workspace is trusted.
+	trusted *regexp.Regexp
+}
+
+var startupBannerPatterns = map[AgentType]bannerPatterns{
+	AgentTypeClaude: {
+		version: []*regexp.Regexp{regexp.MustCompile(`Claude Code v(\d[\w.-]*)`)},
+		model: []*regexp.Regexp{
+			regexp.MustCompile(`(?m)^[│•\s]*[Mm]odel: ([\w.:/\[\]-]+)`),
+			// Claude Code 2 shows the model and plan, e.g. "Sonnet 4.5 · Claude Max".
+			regexp.MustCompile(`((?:Opus|Sonnet|Haiku) \d+(?:\.\d+)?) · `),
+		},
+		workspace: []*regexp.Regexp{regexp.MustCompile(`cwd: (\S+)`)},
+		mcpFailed: []*regexp.Regexp{regexp.MustCompile(`(\d+) MCP servers? failed`)},
+		trusted:   regexp.MustCompile(`Welcome to Claude Code|Claude Code v\d`),
+	},
+	AgentTypeAider: {
+		version: []*regexp.Regexp{regexp.MustCompile(`(?m)^Aider v(\d[\w.-]*)`)},
+		model:   []*regexp.Regexp{regexp.MustCompile(`(?m)^Main model: (\S+)`)},
+	},
+	AgentTypeCodex: {
+		version: []*regexp.Regexp{regexp.MustCompile(`OpenAI Codex \(v(\d[\w.-]*)\)`)},
+		model: []*regexp.Regexp{
+			regexp.MustCompile(`(?m)^[│\s]*model:\s+(\S+)`),
+			// The footer, e.g. "gpt-5-codex high · 100% left · ~/dev".
+			regexp.MustCompile(`(?m)^\s*(\S+) (?:default|minimal|low|medium|high) · \d{1,3}% left`),
+		},
+		workspace: []*regexp.Regexp{
+			regexp.MustCompile(`(?m)^[│\s]*directory:\s+(\S+)`),
+			regexp.MustCompile(`You are using OpenAI Codex in (\S+)`),
+		},
+	},
+	AgentTypeGemini: {
+		model: []*regexp.Regexp{regexp.MustCompile(`(gemini-[\w.-]+) \(\d{1,3}% context left\)`)},
+		// The footer starts with the directory and its git branch, e.g.
+		// "~/dev/agentapi (main*)".
+		workspace:  []*regexp.Regexp{regexp.MustCompile(`(?m)^\s*(~?/\S*)(?: \([^)]*\))? {2,}\S`)},
+		mcpServers: []*regexp.Regexp{regexp.MustCompile(`(\d+) MCP servers?\b`)},
+	},
+}
+
+// untrustedWorkspacePattern matches the dialogs agents show before they
+// start in a workspace they don't trust yet.
+var untrustedWorkspacePattern = regexp.MustCompile(`(?i)do you trust (?:the files in |the contents of )?this (?:folder|directory)`)
+
+// ParseStartupBanner returns the configuration shown in the startup banner
+// on the agent's screen.
+func ParseStartupBanner(agentType AgentType, screen string) StartupBanner {
+	patterns := startupBannerPatterns[agentType]
+	banner := StartupBanner{
+		Version:   firstSubmatch(patterns.version, screen),
+		Model:     firstSubmatch(patterns.model, screen),
+		Workspace: firstSubmatch(patterns.workspace, screen),
+	}
+	banner.MCPServers, _ = strconv.Atoi(firstSubmatch(patterns.mcpServers, screen))
+	banner.MCPServersFailed, _ = strconv.Atoi(firstSubmatch(patterns.mcpFailed, screen))
+	switch {
+	case untrustedWorkspacePattern.MatchString(screen):
+		trusted := false
+		banner.WorkspaceTrusted = &trusted
+	case patterns.trusted != nil && patterns.trusted.MatchString(screen):
+		trusted := true
+		banner.WorkspaceTrusted = &trusted
+	}
+	return banner
+}
+
+// firstSubmatch returns the first group of the first pattern matching s, or
+// "" if none does.
+func firstSubmatch(patterns []*regexp.Regexp, s string) string {
+	for _, pattern := range patterns {
+		if match := pattern.FindStringSubmatch(s); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package msgfmt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStartupBanner(t *testing.T) {
+	trusted, untrusted := true, false
+	fixture := func(agent string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join("testdata", "initialization", agent, "ready", "msg.txt"))
+		require.NoError(t, err)
+		return string(data)
+	}
+	for _, c := range []struct {
+		name      string
+		agentType AgentType
+		screen    string
+		expected  StartupBanner
+	}{
+		{"claude", AgentTypeClaude, fixture("claude"), StartupBanner{Workspace: "/Users/hugodutka/dev/agentapi", WorkspaceTrusted: &trusted}},
+		{"claude 2", AgentTypeClaude, "╭─── Claude Code v2.0.14 ───╮\n│   Sonnet 4.5 · Claude Max   │\n\n  2 MCP servers failed · /mcp for info",
+			StartupBanner{Version: "2.0.14", Model: "Sonnet 4.5", WorkspaceTrusted: &trusted, MCPServersFailed: 2}},
+		{"claude model override", AgentTypeClaude, "│ ✻ Welcome to Claude Code! │\n│   Overrides (via env):    │\n│   • Model: claude-opus-4-1 │",
+			StartupBanner{Model: "claude-opus-4-1", WorkspaceTrusted: &trusted}},
+		{"claude trust dialog", AgentTypeClaude, " Do you trust the files in this folder?\n\n /home/coder/project\n\n ❯ 1. Yes, proceed\n   2. No, exit",
+			StartupBanner{WorkspaceTrusted: &untrusted}},
+		{"aider", AgentTypeAider, fixture("aider"), StartupBanner{Version: "0.81.1", Model: "anthropic/claude-3-7-sonnet-20250219"}},
+		{"codex", AgentTypeCodex, fixture("codex"), StartupBanner{Model: "gpt-5.1-codex-max", Workspace: "~"}},
+		{"codex banner", AgentTypeCodex, "╭───────────────────────────────╮\n│ >_ OpenAI Codex (v0.46.0)     │\n│                               │\n│ model:     gpt-5-codex high   │\n│ directory: ~/dev/agentapi     │",
+			StartupBanner{Version: "0.46.0", Model: "gpt-5-codex", Workspace: "~/dev/agentapi"}},
+		{"gemini", AgentTypeGemini, fixture("gemini"), StartupBanner{Model: "gemini-2.5-pro", Workspace: "~/Documents/work/agentapi"}},
+		{"gemini mcp servers", AgentTypeGemini, "Using: 1 GEMINI.md file | 3 MCP servers (ctrl+t to view)", StartupBanner{MCPServers: 3}},
+		{"no banner", AgentTypeClaude, "● Hello! How can I help?", StartupBanner{}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, ParseStartupBanner(c.agentType, c.screen))
+		})
+	}
+}
+
+func TestStartupBannerMerge(t *testing.T) {
+	trusted, untrusted := true, false
+	banner := StartupBanner{WorkspaceTrusted: &untrusted}.Merge(StartupBanner{Version: "2.0.14", WorkspaceTrusted: &trusted})
+	banner = banner.Merge(StartupBanner{Model: "Sonnet 4.5"})
+	assert.Equal(t, StartupBanner{Version: "2.0.14", Model: "Sonnet 4.5", WorkspaceTrusted: &trusted}, banner)
+	assert.True(t, StartupBanner{}.IsZero())
+	assert.False(t, banner.IsZero())
+}
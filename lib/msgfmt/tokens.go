@@ -0,0 +1,76 @@
+package msgfmt
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer counts the tokens a text takes up in a model's context.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// HeuristicTokenizer estimates token counts without a model's vocabulary,
+// from the shape of the text: BPE tokenizers typically encode a common
+// English word, along with the space before it, as one token, split longer
+// words every few characters, and encode punctuation, CJK characters and
+// emoji as one token or more each. Use the counts the agent reports
+// where exact figures matter.
+type HeuristicTokenizer struct{}
+
+// charsPerWordToken is the average length of the pieces long words are
+// split into.
+const charsPerWordToken = 8
+
+func (HeuristicTokenizer) CountTokens(text string) int {
+	tokens := 0
+	// word is the length of the current run of letters and digits, and
+	// spaces the length of the current run of spaces.
+	word, spaces := 0, 0
+	endWord := func() {
+		tokens += (word + charsPerWordToken - 1) / charsPerWordToken
+		word = 0
+	}
+	endSpaces := func() {
+		// A single space is part of the following token, while runs of
+		// spaces, such as indentation, are tokens of their own.
+		if spaces > 1 {
+			tokens += (spaces + 3) / 4
+		}
+		spaces = 0
+	}
+	for _, r := range text {
+		switch {
+		case r == ' ':
+			endWord()
+			spaces++
+		case unicode.IsSpace(r):
+			// Newlines and tabs.
+			endWord()
+			endSpaces()
+			tokens++
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			endWord()
+			endSpaces()
+			tokens++
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			endSpaces()
+			// Non-ASCII letters are rarer in vocabularies, so words using
+			// them are split into shorter pieces.
+			if r < utf8.RuneSelf {
+				word++
+			} else {
+				word += 2
+			}
+		default:
+			endWord()
+			endSpaces()
+			if !isInvisible(r) {
+				tokens++
+			}
+		}
+	}
+	endWord()
+	endSpaces()
+	return tokens
+}
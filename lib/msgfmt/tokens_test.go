@@ -0,0 +1,26 @@
+package msgfmt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeuristicTokenizer(t *testing.T) {
+	for _, c := range []struct {
+		text     string
+		expected int
+	}{
+		{"", 0},
+		{"Fix the bug", 3},
+		{"Fix the bug.\n", 5},
+		{"internationalization", 3},
+		{"func main() {\n    fmt.Println(\"hi\")\n}", 17},
+		{"こんにちは世界", 7},
+		{"Done 👍🏽", 3},
+	} {
+		t.Run(c.text, func(t *testing.T) {
+			assert.Equal(t, c.expected, HeuristicTokenizer{}.CountTokens(c.text))
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package msgfmt
+
+import (
+	"unicode"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// widthCondition measures characters the way agents' terminal UIs do:
+// East Asian ambiguous-width characters, such as box-drawing characters,
+// take a single column regardless of the locale.
+var widthCondition = &runewidth.Condition{}
+
+// RuneWidth returns the number of terminal columns r takes: 2 for wide
+// characters such as CJK ideographs, 0 for zero-width characters such as
+// combining marks and joiners, and 1 otherwise.
+func RuneWidth(r rune) int {
+	return widthCondition.RuneWidth(r)
+}
+
+// StringWidth returns the number of terminal columns s takes.
+func StringWidth(s string) int {
+	return widthCondition.StringWidth(s)
+}
+
+// isWhitespace reports whether r is whitespace, including non-ASCII
+// whitespace such as the ideographic space (U+3000) used in CJK text.
+func isWhitespace(r rune) bool {
+	return unicode.IsSpace(r)
+}
+
+// isInvisible reports whether r has no width of its own. Terminal
+// emulators and agents handle such characters inconsistently, e.g. by
+// dropping variation selectors, so they are ignored when matching text.
+func isInvisible(r rune) bool {
+	// go-runewidth counts variation selectors as one column.
+	return RuneWidth(r) == 0 || unicode.Is(unicode.Variation_Selector, r)
+}
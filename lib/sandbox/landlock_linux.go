@@ -0,0 +1,163 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// readAccess is the access of read-only paths.
+	readAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+	// fileAccess is the access that applies to files rather than
+	// directories.
+	fileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	// landlockRuleNetPort is LANDLOCK_RULE_NET_PORT, which x/sys doesn't
+	// define.
+	landlockRuleNetPort = 2
+)
+
+// landlockNetPortAttr is struct landlock_net_port_attr.
+type landlockNetPortAttr struct {
+	allowedAccess uint64
+	port          uint64
+}
+
+// ABI returns the version of the Landlock ABI supported by the kernel.
+func ABI() (int, error) {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	switch {
+	case errno == unix.ENOSYS:
+		return 0, xerrors.New("the kernel doesn't support Landlock, it requires Linux 5.13")
+	case errno == unix.EOPNOTSUPP:
+		return 0, xerrors.New("Landlock is disabled, add it to the lsm= kernel parameter to enable it")
+	case errno != 0:
+		return 0, xerrors.Errorf("failed to get the Landlock ABI version: %w", errno)
+	}
+	return int(abi), nil
+}
+
+// handledAccess returns all the filesystem access rights known to a
+// Landlock ABI version. Those not granted by a rule are denied.
+func handledAccess(abi int) uint64 {
+	access := uint64(unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM)
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	return access
+}
+
+// restrict confines the calling thread, and the programs it executes, to
+// the paths of the config. Paths that don't exist are skipped. The caller
+// must lock the goroutine to its thread and exec from it.
+func restrict(cfg Config) error {
+	abi, err := ABI()
+	if err != nil {
+		return err
+	}
+	handled := handledAccess(abi)
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	// Network rules need ABI 4. Binding isn't restricted, so the agent can
+	// still run servers.
+	restrictNetwork := cfg.ProxyPort != 0 && abi >= 4
+	if restrictNetwork {
+		attr.Access_net = unix.LANDLOCK_ACCESS_NET_CONNECT_TCP
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return xerrors.Errorf("failed to create Landlock ruleset: %w", errno)
+	}
+	ruleset := int(fd)
+	defer func() {
+		_ = unix.Close(ruleset)
+	}()
+	for _, path := range cfg.ReadWrite {
+		if err := addPathRule(ruleset, path, handled); err != nil {
+			return err
+		}
+	}
+	for _, path := range cfg.ReadOnly {
+		if err := addPathRule(ruleset, path, readAccess); err != nil {
+			return err
+		}
+	}
+	if restrictNetwork {
+		netAttr := landlockNetPortAttr{allowedAccess: unix.LANDLOCK_ACCESS_NET_CONNECT_TCP, port: uint64(cfg.ProxyPort)}
+		if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), landlockRuleNetPort,
+			uintptr(unsafe.Pointer(&netAttr)), 0, 0, 0); errno != 0 {
+			return xerrors.Errorf("failed to allow connections to port %d: %w", cfg.ProxyPort, errno)
+		}
+	}
+	// Landlock requires that the program can't gain privileges, e.g.
+	// through setuid binaries, which would escape the sandbox.
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return xerrors.Errorf("failed to set no_new_privs: %w", err)
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(ruleset), 0, 0); errno != 0 {
+		return xerrors.Errorf("failed to enforce Landlock ruleset: %w", errno)
+	}
+	return nil
+}
+
+func addPathRule(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ENOTDIR) {
+		return nil
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to open %s: %w", path, err)
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err != nil {
+		return xerrors.Errorf("failed to stat %s: %w", path, err)
+	}
+	// Rules for files can only grant access that applies to files.
+	if stat.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= fileAccess
+	}
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH,
+		uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return xerrors.Errorf("failed to allow %s: %w", path, errno)
+	}
+	return nil
+}
+
+// Exec restricts the process to the config and replaces it with the
+// program.
+func Exec(cfg Config, program string, args []string) error {
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return xerrors.Errorf("failed to find %s: %w", program, err)
+	}
+	// Landlock applies to the calling thread, so the program must be
+	// executed from the same one.
+	runtime.LockOSThread()
+	if err := restrict(cfg); err != nil {
+		return err
+	}
+	if err := unix.Exec(path, append([]string{program}, args...), os.Environ()); err != nil {
+		return xerrors.Errorf("failed to execute %s: %w", program, err)
+	}
+	return nil
+}
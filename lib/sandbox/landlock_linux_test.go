@@ -0,0 +1,43 @@
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sandboxedEnv holds the directory a sandboxed test process may write to.
+const sandboxedEnv = "AGENTAPI_SANDBOX_TEST_DIR"
+
+// TestExec runs a shell in the sandbox in a subprocess, since the sandbox
+// can't be lifted.
+func TestExec(t *testing.T) {
+	if dir := os.Getenv(sandboxedEnv); dir != "" {
+		cfg := Config{ReadWrite: []string{dir}, ReadOnly: systemPaths}
+		err := Exec(cfg, "sh", []string{"-c", `echo inside > "$0/inside" && echo outside > "$0/../outside"`, dir})
+		require.NoError(t, err)
+		return
+	}
+	if _, err := ABI(); err != nil {
+		t.Skip(err)
+	}
+
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "workspace")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	cmd := exec.Command(os.Args[0], "-test.run=^TestExec$")
+	cmd.Env = append(os.Environ(), sandboxedEnv+"="+dir)
+	out, err := cmd.CombinedOutput()
+	require.Error(t, err)
+	assert.Contains(t, string(out), "Permission denied")
+
+	inside, err := os.ReadFile(filepath.Join(dir, "inside"))
+	require.NoError(t, err)
+	assert.Equal(t, "inside", strings.TrimSpace(string(inside)))
+	assert.NoFileExists(t, filepath.Join(parent, "outside"))
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package sandbox
+
+import "golang.org/x/xerrors"
+
+var errUnsupported = xerrors.New("the sandbox uses Landlock, which is only available on Linux")
+
+// ABI returns the version of the Landlock ABI supported by the kernel.
+func ABI() (int, error) {
+	return 0, errUnsupported
+}
+
+// Exec restricts the process to the config and replaces it with the
+// program.
+func Exec(_ Config, _ string, _ []string) error {
+	return errUnsupported
+}
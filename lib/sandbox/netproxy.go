@@ -0,0 +1,208 @@
+package sandbox
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// proxyDialTimeout is how long the proxy waits to connect to an allowed
+// host.
+const proxyDialTimeout = 30 * time.Second
+
+// NetworkProxy is the HTTP proxy the sandboxed agent's network access goes
+// through. It only lets the agent reach the allowed domains, and reports
+// the hosts it blocks so that operators can allow them while the agent
+// runs. Programs find it through the proxy environment variables of Env,
+// and on kernels with Landlock ABI 4 or later, Config.ProxyPort keeps them
+// from connecting anywhere else.
+type NetworkProxy struct {
+	listener  net.Listener
+	server    *http.Server
+	transport *http.Transport
+
+	mu        sync.Mutex
+	allowed   []string
+	onBlocked func(host string)
+}
+
+// NewNetworkProxy starts a proxy on a local port that allows the domains,
+// and their subdomains.
+func NewNetworkProxy(allowed []string) (*NetworkProxy, error) {
+	p := &NetworkProxy{
+		// The server's own proxy settings don't apply to the agent.
+		transport: &http.Transport{Proxy: nil, DialContext: (&net.Dialer{Timeout: proxyDialTimeout}).DialContext},
+	}
+	if err := p.Allow(allowed...); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, xerrors.Errorf("failed to listen: %w", err)
+	}
+	p.listener = listener
+	p.server = &http.Server{Handler: p, ReadHeaderTimeout: proxyDialTimeout}
+	go func() {
+		_ = p.server.Serve(listener)
+	}()
+	return p, nil
+}
+
+// Port is the local port the proxy listens on.
+func (p *NetworkProxy) Port() int {
+	return p.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Env returns the environment variables that point programs to the proxy.
+// Both cases are set since programs disagree on which one they read.
+func (p *NetworkProxy) Env() map[string]string {
+	url := "http://" + p.listener.Addr().String()
+	env := map[string]string{}
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY"} {
+		env[name] = url
+		env[strings.ToLower(name)] = url
+	}
+	// Direct connections, even to the local host, are blocked.
+	env["NO_PROXY"] = ""
+	env["no_proxy"] = ""
+	return env
+}
+
+// Allow adds domains the agent can reach, along with their subdomains.
+func (p *NetworkProxy) Allow(domains ...string) error {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		domain = normalizeHost(domain)
+		if domain == "" || strings.ContainsAny(domain, "/:* ") {
+			return xerrors.Errorf("invalid domain %q, expected a domain name such as github.com", domain)
+		}
+		normalized = append(normalized, domain)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, domain := range normalized {
+		if !slices.Contains(p.allowed, domain) {
+			p.allowed = append(p.allowed, domain)
+		}
+	}
+	return nil
+}
+
+// Allowed returns the domains the agent can reach.
+func (p *NetworkProxy) Allowed() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.allowed)
+}
+
+// SetOnBlocked sets a callback that will be called with the host of every
+// request the proxy blocks.
+func (p *NetworkProxy) SetOnBlocked(fn func(host string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onBlocked = fn
+}
+
+// Close stops the proxy. Open tunnels are left to the agent's exit.
+func (p *NetworkProxy) Close() error {
+	p.transport.CloseIdleConnections()
+	return p.server.Close()
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// allows returns whether the host is one of the allowed domains or a
+// subdomain of one.
+func (p *NetworkProxy) allows(host string) bool {
+	host = normalizeHost(host)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, domain := range p.allowed {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *NetworkProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Hostname()
+	if r.Method == http.MethodConnect {
+		host, _, _ = net.SplitHostPort(r.Host)
+	} else if !r.URL.IsAbs() {
+		http.Error(w, "this is a proxy, requests must have an absolute URL", http.StatusBadRequest)
+		return
+	}
+	if !p.allows(host) {
+		p.mu.Lock()
+		onBlocked := p.onBlocked
+		p.mu.Unlock()
+		if onBlocked != nil {
+			onBlocked(normalizeHost(host))
+		}
+		http.Error(w, "agentapi sandbox: access to "+host+" is not allowed", http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Connection")
+	out.Header.Del("Proxy-Authorization")
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// tunnel connects the client to the host of a CONNECT request, for HTTPS
+// and other protocols over TCP.
+func (p *NetworkProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := net.DialTimeout("tcp", r.Host, proxyDialTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		_ = upstream.Close()
+		http.Error(w, "tunneling is not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		_ = upstream.Close()
+		return
+	}
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		_ = client.Close()
+		_ = upstream.Close()
+		return
+	}
+	go func() {
+		// Bytes the client sent after the request may already be
+		// buffered.
+		_, _ = io.Copy(upstream, buffered)
+		_ = upstream.Close()
+	}()
+	_, _ = io.Copy(client, upstream)
+	_ = client.Close()
+}
@@ -0,0 +1,102 @@
+package sandbox
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProxy(t *testing.T, allowed ...string) (*NetworkProxy, *http.Client, func() []string) {
+	t.Helper()
+	proxy, err := NewNetworkProxy(allowed)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = proxy.Close() })
+
+	var mu sync.Mutex
+	var blocked []string
+	proxy.SetOnBlocked(func(host string) {
+		mu.Lock()
+		defer mu.Unlock()
+		blocked = append(blocked, host)
+	})
+	proxyURL, err := url.Parse(proxy.Env()["HTTP_PROXY"])
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	t.Cleanup(client.CloseIdleConnections)
+	return proxy, client, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), blocked...)
+	}
+}
+
+func TestNetworkProxy(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	}))
+	t.Cleanup(upstream.Close)
+
+	proxy, client, blocked := newTestProxy(t)
+
+	resp, err := client.Get(upstream.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, []string{"127.0.0.1"}, blocked())
+
+	require.NoError(t, proxy.Allow("127.0.0.1"))
+	resp, err = client.Get(upstream.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.Len(t, blocked(), 1)
+}
+
+func TestNetworkProxyTunnel(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "secure hello")
+	}))
+	t.Cleanup(upstream.Close)
+
+	_, client, blocked := newTestProxy(t, "127.0.0.1")
+	client.Transport.(*http.Transport).TLSClientConfig = upstream.Client().Transport.(*http.Transport).TLSClientConfig
+
+	resp, err := client.Get(upstream.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "secure hello", string(body))
+	assert.Empty(t, blocked())
+}
+
+func TestNetworkProxyAllow(t *testing.T) {
+	t.Parallel()
+
+	proxy, err := NewNetworkProxy([]string{"GitHub.com."})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = proxy.Close() })
+
+	require.NoError(t, proxy.Allow("pypi.org", "github.com"))
+	assert.Equal(t, []string{"github.com", "pypi.org"}, proxy.Allowed())
+	assert.Error(t, proxy.Allow("https://example.com"))
+	assert.Error(t, proxy.Allow("*.example.com"))
+
+	assert.True(t, proxy.allows("github.com"))
+	assert.True(t, proxy.allows("api.github.com"))
+	assert.False(t, proxy.allows("notgithub.com"))
+	assert.False(t, proxy.allows("example.com"))
+}
@@ -0,0 +1,147 @@
+// Package sandbox restricts the filesystem access of the agent with
+// Landlock, for Linux hosts where the agent can't run in a container.
+//
+// Landlock only restricts the process that enables it and the processes it
+// starts afterwards, so the server runs the agent through the hidden
+// `agentapi sandbox-exec` command, which restricts itself and then execs the
+// agent.
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+)
+
+// ExecCommand is the agentapi command that runs a program in the sandbox.
+const ExecCommand = "sandbox-exec"
+
+// Config lists the paths the agent can access. Everything else is denied.
+type Config struct {
+	// ReadWrite are the files and directories the agent can read, write,
+	// and execute.
+	ReadWrite []string
+	// ReadOnly are the files and directories the agent can read and
+	// execute.
+	ReadOnly []string
+	// ProxyPort is the port of the NetworkProxy, the only TCP port the
+	// agent can connect to. 0 doesn't restrict network access.
+	ProxyPort int
+}
+
+// systemPaths are the directories programs need to read to run.
+var systemPaths = []string{"/bin", "/sbin", "/usr", "/lib", "/lib32", "/lib64", "/etc", "/opt", "/nix", "/proc", "/sys", "/run"}
+
+// agentConfigPaths are the files and directories, relative to the home
+// directory, where agents keep their settings and sessions.
+var agentConfigPaths = map[mf.AgentType][]string{
+	mf.AgentTypeClaude: {".claude", ".claude.json"},
+	mf.AgentTypeCodex:  {".codex"},
+	mf.AgentTypeGemini: {".gemini"},
+	mf.AgentTypeGoose:  {".config/goose", ".local/share/goose", ".local/state/goose"},
+}
+
+// DefaultConfig lets the agent write to the workspace, the temporary
+// directory, its terminal and its settings, and read the system
+// directories, the directories on PATH and the directory of its
+// executable.
+func DefaultConfig(agentType mf.AgentType, program string, workspace string) Config {
+	cfg := Config{
+		ReadWrite: []string{workspace, os.TempDir(), "/dev"},
+		ReadOnly:  slices.Clone(systemPaths),
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, path := range agentConfigPaths[agentType] {
+			cfg.ReadWrite = append(cfg.ReadWrite, filepath.Join(home, path))
+		}
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if filepath.IsAbs(dir) {
+			cfg.ReadOnly = append(cfg.ReadOnly, dir)
+		}
+	}
+	// Executables are often symlinks into a directory holding the rest of
+	// the agent, e.g. ~/.local/bin/claude.
+	if path, err := exec.LookPath(program); err == nil {
+		if path, err = filepath.EvalSymlinks(path); err == nil {
+			if path, err = filepath.Abs(path); err == nil {
+				cfg.ReadOnly = append(cfg.ReadOnly, filepath.Dir(path))
+			}
+		}
+	}
+	return cfg
+}
+
+// Allow adds paths given as --sandbox-allow values: a path the agent can
+// write to, or a path followed by ":ro" that it can only read.
+func (c *Config) Allow(values []string) error {
+	for _, value := range values {
+		path, readOnly := strings.CutSuffix(value, ":ro")
+		path, err := expandHome(path)
+		if err != nil {
+			return err
+		}
+		if readOnly {
+			c.ReadOnly = append(c.ReadOnly, path)
+		} else {
+			c.ReadWrite = append(c.ReadWrite, path)
+		}
+	}
+	return nil
+}
+
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return filepath.Abs(path)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// Command returns the program and arguments that run the agent in the
+// sandbox through exe, the agentapi executable.
+func (c Config) Command(exe string, program string, args []string) (string, []string) {
+	wrapped := []string{ExecCommand}
+	for _, path := range c.ReadWrite {
+		wrapped = append(wrapped, "--rw", path)
+	}
+	for _, path := range c.ReadOnly {
+		wrapped = append(wrapped, "--ro", path)
+	}
+	if c.ProxyPort != 0 {
+		wrapped = append(wrapped, "--proxy-port", strconv.Itoa(c.ProxyPort))
+	}
+	wrapped = append(wrapped, "--", program)
+	return exe, append(wrapped, args...)
+}
+
+// Limitations describes what the sandbox doesn't restrict with the
+// Landlock ABI version of the kernel, depending on whether network access
+// goes through a NetworkProxy.
+func Limitations(abi int, proxied bool) []string {
+	var limitations []string
+	switch {
+	case !proxied:
+		limitations = append(limitations, "Network access isn't restricted.")
+	case abi < 4:
+		limitations = append(limitations, "Network access goes through the proxy only for programs that use the HTTP_PROXY and HTTPS_PROXY environment variables; the kernel can't block other connections.")
+	default:
+		limitations = append(limitations, "TCP connections other than through the proxy are blocked, but so are connections to local servers. UDP, including DNS, isn't restricted.")
+	}
+	limitations = append(limitations, "System calls other than filesystem and network access aren't restricted.")
+	if abi < 2 {
+		limitations = append(limitations, "Files can't be moved or linked to another directory, even within the allowed paths.")
+	}
+	if abi < 3 {
+		limitations = append(limitations, "Files outside the allowed paths can still be truncated.")
+	}
+	return limitations
+}
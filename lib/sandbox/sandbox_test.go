@@ -0,0 +1,65 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultConfig(t *testing.T) {
+	home := t.TempDir()
+	bin := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+"relative")
+
+	// The agent is a symlink into the directory holding the rest of it.
+	versions := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(versions, "claude"), []byte("#!/bin/sh\n"), 0o755))
+	require.NoError(t, os.Symlink(filepath.Join(versions, "claude"), filepath.Join(bin, "claude")))
+
+	cfg := DefaultConfig(mf.AgentTypeClaude, "claude", "/workspace")
+	assert.Equal(t, []string{"/workspace", os.TempDir(), "/dev", filepath.Join(home, ".claude"), filepath.Join(home, ".claude.json")}, cfg.ReadWrite)
+	assert.Contains(t, cfg.ReadOnly, "/usr")
+	assert.Contains(t, cfg.ReadOnly, bin)
+	assert.NotContains(t, cfg.ReadOnly, "relative")
+	resolved, err := filepath.EvalSymlinks(versions)
+	require.NoError(t, err)
+	assert.Contains(t, cfg.ReadOnly, resolved)
+}
+
+func TestAllow(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	var cfg Config
+	require.NoError(t, cfg.Allow([]string{"~/.npm", "/srv/data:ro", "~/.gitconfig:ro"}))
+	assert.Equal(t, []string{filepath.Join(home, ".npm")}, cfg.ReadWrite)
+	assert.Equal(t, []string{"/srv/data", filepath.Join(home, ".gitconfig")}, cfg.ReadOnly)
+}
+
+func TestCommand(t *testing.T) {
+	t.Parallel()
+
+	cfg := Config{ReadWrite: []string{"/workspace"}, ReadOnly: []string{"/usr", "/etc"}}
+	program, args := cfg.Command("/usr/local/bin/agentapi", "claude", []string{"--model", "opus"})
+	assert.Equal(t, "/usr/local/bin/agentapi", program)
+	assert.Equal(t, []string{"sandbox-exec", "--rw", "/workspace", "--ro", "/usr", "--ro", "/etc", "--", "claude", "--model", "opus"}, args)
+
+	cfg.ProxyPort = 8123
+	_, args = cfg.Command("/usr/local/bin/agentapi", "claude", nil)
+	assert.Equal(t, []string{"sandbox-exec", "--rw", "/workspace", "--ro", "/usr", "--ro", "/etc", "--proxy-port", "8123", "--", "claude"}, args)
+}
+
+func TestLimitations(t *testing.T) {
+	t.Parallel()
+
+	assert.Len(t, Limitations(1, false), 4)
+	assert.Len(t, Limitations(3, false), 2)
+	assert.Contains(t, Limitations(3, false)[0], "isn't restricted")
+	assert.Contains(t, Limitations(3, true)[0], "HTTP_PROXY")
+	assert.Contains(t, Limitations(4, true)[0], "blocked")
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/coder/agentapi/lib/msgfmt"
 	"github.com/coder/agentapi/lib/util"
 	"github.com/danielgtaylor/huma/v2"
 	"golang.org/x/xerrors"
@@ -54,6 +55,8 @@ var (
 	ErrMessageValidationWhitespace = xerrors.New("message must be trimmed of leading and trailing whitespace")
 	ErrMessageValidationEmpty      = xerrors.New("message must not be empty")
 	ErrMessageValidationChanging   = xerrors.New("message can only be sent when the agent is waiting for user input")
+	ErrMessageNotFound             = xerrors.New("message not found")
+	ErrStateRestoreUnsupported     = xerrors.New("the conversation doesn't support restoring state")
 )
 
 type AgentIO interface {
@@ -89,6 +92,61 @@ type Conversation interface {
 	Status() ConversationStatus
 	Text() string
 	SaveState() error
+	// Notes returns the client-managed notes attached to the conversation.
+	Notes() map[string]string
+	// SetNotes replaces the notes. They are persisted with the state file.
+	SetNotes(notes map[string]string)
+	// SetMessagePinned pins or unpins a message, returning
+	// ErrMessageNotFound if there's no message with the ID. Pins are
+	// persisted with the state file.
+	SetMessagePinned(id int, pinned bool) error
+}
+
+// MessageRanger is implemented by conversations that can return part of
+// their messages without copying all of them.
+type MessageRanger interface {
+	// MessageRange returns the messages with IDs from start up to, but not
+	// including, end, limited to the existing messages, along with the
+	// number of messages.
+	MessageRange(start, end int) ([]ConversationMessage, int, error)
+}
+
+// StateRestorer is implemented by conversations that can replace their
+// messages with those of a saved state while running. RestoreState returns
+// ErrStateRestoreUnsupported if a wrapped conversation can't.
+type StateRestorer interface {
+	RestoreState(state AgentState) error
+}
+
+// TerminalInputRecorder is implemented by conversations that can record a
+// user message typed into the agent's terminal directly, e.g. by a human
+// attached to it, rather than sent with Send. StartTerminalInput is called
+// before the first keystroke of the message reaches the agent, and
+// RecordTerminalInput once it is complete. The message was already written
+// to the agent, so it isn't written again.
+type TerminalInputRecorder interface {
+	StartTerminalInput()
+	RecordTerminalInput(message string)
+}
+
+// QueueReporter is implemented by conversations that queue messages before
+// sending them to the agent. QueueDepth counts the messages accepted but
+// not yet sent, including the one being sent.
+type QueueReporter interface {
+	QueueDepth() int
+}
+
+// ReadMessageRange returns a range of messages of the conversation as
+// MessageRanger does, reading all of them from conversations that don't
+// implement it.
+func ReadMessageRange(c Conversation, start, end int) ([]ConversationMessage, int, error) {
+	if ranger, ok := c.(MessageRanger); ok {
+		return ranger.MessageRange(start, end)
+	}
+	messages := c.Messages()
+	start = max(0, min(start, len(messages)))
+	end = max(start, min(end, len(messages)))
+	return messages[start:end], len(messages), nil
 }
 
 // Emitter receives conversation state updates.
@@ -99,15 +157,226 @@ type Emitter interface {
 	EmitError(message string, level ErrorLevel)
 }
 
+// MessageWindowEmitter is implemented by Emitters that can be given the
+// messages that changed rather than all of them.
+type MessageWindowEmitter interface {
+	// EmitMessageWindow publishes the messages with IDs from start on.
+	// Earlier messages are unchanged, later ones are removed.
+	EmitMessageWindow(start int, messages []ConversationMessage)
+}
+
+type ToolCallStatus string
+
+const (
+	ToolCallStatusStarted   ToolCallStatus = "started"
+	ToolCallStatusCompleted ToolCallStatus = "completed"
+	ToolCallStatusFailed    ToolCallStatus = "failed"
+)
+
+var ToolCallStatusValues = []ToolCallStatus{
+	ToolCallStatusStarted,
+	ToolCallStatusCompleted,
+	ToolCallStatusFailed,
+}
+
+func (s ToolCallStatus) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "ToolCallStatus", ToolCallStatusValues)
+}
+
+// StopReason describes why the agent stopped producing a message. The
+// values match the stop reasons of the Agent Client Protocol.
+type StopReason string
+
+const (
+	StopReasonEndTurn         StopReason = "end_turn"
+	StopReasonMaxTokens       StopReason = "max_tokens"
+	StopReasonMaxTurnRequests StopReason = "max_turn_requests"
+	StopReasonRefusal         StopReason = "refusal"
+	StopReasonCancelled       StopReason = "cancelled"
+)
+
+var StopReasonValues = []StopReason{
+	StopReasonEndTurn,
+	StopReasonMaxTokens,
+	StopReasonMaxTurnRequests,
+	StopReasonRefusal,
+	StopReasonCancelled,
+}
+
+func (s StopReason) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "StopReason", StopReasonValues)
+}
+
+// MessageSource tells how a user message reached the agent when it wasn't
+// sent through the API.
+type MessageSource string
+
+const (
+	// MessageSourceTerminal is a message typed into the agent's terminal,
+	// e.g. with agentapi attach.
+	MessageSourceTerminal MessageSource = "terminal"
+)
+
+var MessageSourceValues = []MessageSource{
+	MessageSourceTerminal,
+}
+
+func (s MessageSource) Schema(r huma.Registry) *huma.Schema {
+	return util.OpenAPISchema(r, "MessageSource", MessageSourceValues)
+}
+
+// ToolCall describes a tool invocation reported by a structured transport.
+type ToolCall struct {
+	Id     string
+	Name   string
+	Input  string
+	Status ToolCallStatus
+	// TurnId is the turn of the agent message the tool call was made for,
+	// as in ConversationMessage.
+	TurnId int
+}
+
+// ToolCallEmitter is implemented by Emitters that also publish tool calls.
+// Transports that receive structured tool calls report all of them; PTY
+// conversations only report the coder_report_task calls they remove from
+// agent messages.
+type ToolCallEmitter interface {
+	EmitToolCall(ToolCall)
+}
+
+// AgentCommand is a slash command advertised by the agent.
+type AgentCommand struct {
+	Name        string
+	Description string
+	// InputHint describes the text expected after the command name. It is
+	// empty if the command takes no input.
+	InputHint string
+}
+
+// PlanEntry is one task of the execution plan reported by an agent.
+type PlanEntry struct {
+	Content  string `json:"content"`
+	Priority string `json:"priority"`
+	Status   string `json:"status"`
+}
+
+// ReasoningEmitter is implemented by Emitters that also publish the agent's
+// thoughts and plan while it responds. Only transports that receive them
+// separately from the reply report them.
+type ReasoningEmitter interface {
+	// EmitThought publishes the thought accumulated so far for the agent
+	// message with the given id.
+	EmitThought(messageId int, thought string)
+	// EmitPlan publishes the latest plan for the agent message with the
+	// given id. Each plan replaces the previous one.
+	EmitPlan(messageId int, plan []PlanEntry)
+}
+
+// FileDiff is a file modification reported by an agent's tool call.
+type FileDiff struct {
+	ToolCallId string `json:"tool_call_id"`
+	Path       string `json:"path"`
+	// NewFile is true if the file did not exist before the change.
+	NewFile bool       `json:"new_file,omitempty"`
+	Hunks   []DiffHunk `json:"hunks"`
+}
+
+// DiffHunk is a hunk of a unified diff. Lines are prefixed with ' ', '-'
+// or '+' like in `diff -u`.
+type DiffHunk struct {
+	OldStart int      `json:"old_start"`
+	OldLines int      `json:"old_lines"`
+	NewStart int      `json:"new_start"`
+	NewLines int      `json:"new_lines"`
+	Lines    []string `json:"lines"`
+}
+
+// DiffEmitter is implemented by Emitters that also publish file diffs.
+// Only transports that receive structured diffs report them.
+type DiffEmitter interface {
+	// EmitDiff publishes a diff for the agent message with the given id.
+	EmitDiff(messageId int, diff FileDiff)
+}
+
 type ConversationMessage struct {
 	Id      int              `json:"id"`
 	Message string           `json:"message"`
 	Role    ConversationRole `json:"role"`
 	Time    time.Time        `json:"time"`
+	// TurnId is the id of the message that started the turn the message
+	// belongs to: the user message the agent responds to, or the first
+	// message of the conversation before the user sent any.
+	TurnId int `json:"turn_id"`
+	// Thought and Plan are only set on agent messages from transports that
+	// report reasoning separately from the reply.
+	Thought string      `json:"thought,omitempty"`
+	Plan    []PlanEntry `json:"plan,omitempty"`
+	// Diffs are the file modifications made while producing an agent
+	// message, for transports that report them.
+	Diffs []FileDiff `json:"diffs,omitempty"`
+	// StopReason is why the agent stopped, if known. It is only set on
+	// agent messages.
+	StopReason StopReason `json:"stop_reason,omitempty"`
+	// Filtered is set on agent messages that matched an output filter.
+	Filtered bool `json:"filtered,omitempty"`
+	// Source is set on user messages that weren't sent through the API.
+	Source MessageSource `json:"source,omitempty"`
+	// Pinned is set on messages pinned by a client. Features that shorten
+	// or drop messages, such as summarizing middlewares, must keep pinned
+	// ones verbatim.
+	Pinned bool `json:"pinned,omitempty"`
+	// Markdown is the agent message converted to markdown, if enabled.
+	Markdown string `json:"markdown,omitempty"`
+	// ANSI and HTML are the agent message with the colors it has on the
+	// screen, as SGR escape sequences or HTML, if enabled.
+	ANSI string `json:"ansi,omitempty"`
+	HTML string `json:"html,omitempty"`
+	// Links are the URLs and file references found in an agent message.
+	Links []msgfmt.Link `json:"links,omitempty"`
+	// Blocks are the parts of an agent message, if enabled.
+	Blocks []msgfmt.Block `json:"blocks,omitempty"`
+	// Debug describes how an agent message was parsed from the screen. It
+	// is only set when enabled in the conversation's config.
+	Debug *MessageDebug `json:"debug,omitempty"`
+}
+
+// AssignTurnIds sets the turn of each message, for messages saved before
+// turns were recorded. A user message starts a turn, and every other
+// message belongs to the turn of the message before it.
+func AssignTurnIds(messages []ConversationMessage) {
+	for i := range messages {
+		if i == 0 || messages[i].Role == ConversationRoleUser {
+			messages[i].TurnId = messages[i].Id
+		} else {
+			messages[i].TurnId = messages[i-1].TurnId
+		}
+	}
+}
+
+// MessageDebug describes how an agent message was parsed from the screen,
+// to diagnose messages that contain terminal UI or miss content.
+type MessageDebug struct {
+	// ScreenStartLine and ScreenEndLine are the first and last lines of
+	// the screen, counted from 0, the message was derived from, before
+	// formatting. Both are -1 if nothing on the screen changed.
+	ScreenStartLine int `json:"screen_start_line"`
+	ScreenEndLine   int `json:"screen_end_line"`
+	// EchoRemoved is whether the user message the agent responds to was
+	// found echoed on the screen and removed.
+	EchoRemoved bool `json:"echo_removed"`
+	// Confidence is how likely, from 0 to 1, the message is free of
+	// terminal UI, according to msgfmt.ParseConfidence.
+	Confidence float64 `json:"confidence"`
 }
 
 type StatePersistenceConfig struct {
 	StateFile string
 	LoadState bool
 	SaveState bool
+	// Environment is recorded in the saved state, and compared with the
+	// environment of a loaded one. Optional.
+	Environment *Environment
+	// FilterMessages rewrites the messages written to the state file, e.g.
+	// to redact them. Optional.
+	FilterMessages func([]ConversationMessage) []ConversationMessage
 }
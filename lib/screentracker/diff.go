@@ -8,6 +8,14 @@ import (
 
 // screenDiff compares two screen states and attempts to find latest message of the given agent type.
 func screenDiff(oldScreen, newScreen string, agentType msgfmt.AgentType) string {
+	diff, _, _ := screenDiffLines(oldScreen, newScreen, agentType)
+	return diff
+}
+
+// screenDiffLines is screenDiff that also returns the range of lines of the
+// new screen the message was found in, from start to end inclusive. Both
+// are -1 if the message is empty.
+func screenDiffLines(oldScreen, newScreen string, agentType msgfmt.AgentType) (string, int, int) {
 	oldLines := strings.Split(oldScreen, "\n")
 	newLines := strings.Split(newScreen, "\n")
 	oldLinesMap := make(map[string]bool)
@@ -21,7 +29,7 @@ func screenDiff(oldScreen, newScreen string, agentType msgfmt.AgentType) string
 	//
 	// ┃  # Getting Started with Claude CLI                                   ┃
 	// ┃  /share to create a shareable link                 12.6K/6% ($0.05)  ┃
-	if len(newLines) >= 2 && agentType == msgfmt.AgentTypeOpencode {
+	if len(newLines) > 2 && agentType == msgfmt.AgentTypeOpencode {
 		dynamicHeaderEnd = 2
 	}
 
@@ -35,6 +43,12 @@ func screenDiff(oldScreen, newScreen string, agentType msgfmt.AgentType) string
 			break
 		}
 	}
+	// Don't start in the middle of a grapheme cluster that the terminal
+	// wrapped over two lines, such as an emoji sequence.
+	for firstNonMatchingLine > 0 && firstNonMatchingLine < len(newLines) &&
+		msgfmt.ContinuesGrapheme(newLines[firstNonMatchingLine-1], newLines[firstNonMatchingLine]) {
+		firstNonMatchingLine--
+	}
 	newSectionLines := newLines[firstNonMatchingLine:]
 
 	// remove leading and trailing lines which are empty or have only whitespace
@@ -52,5 +66,9 @@ func screenDiff(oldScreen, newScreen string, agentType msgfmt.AgentType) string
 			break
 		}
 	}
-	return strings.Join(newSectionLines[startLine:endLine+1], "\n")
+	diff := strings.Join(newSectionLines[startLine:endLine+1], "\n")
+	if strings.TrimSpace(diff) == "" {
+		return diff, -1, -1
+	}
+	return diff, firstNonMatchingLine + startLine, firstNonMatchingLine + endLine
 }
@@ -3,6 +3,7 @@ package screentracker
 import (
 	"embed"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/coder/agentapi/lib/msgfmt"
@@ -22,6 +23,16 @@ func TestScreenDiff(t *testing.T) {
 		assert.Equal(t, "42", screenDiff("89", "42", msgfmt.AgentTypeCustom))
 	})
 
+	t.Run("lines", func(t *testing.T) {
+		diff, start, end := screenDiffLines("123", "123\n  \n42\n43\n \n", msgfmt.AgentTypeCustom)
+		assert.Equal(t, "42\n43", diff)
+		assert.Equal(t, 2, start)
+		assert.Equal(t, 3, end)
+		_, start, end = screenDiffLines("123", "123", msgfmt.AgentTypeCustom)
+		assert.Equal(t, -1, start)
+		assert.Equal(t, -1, end)
+	})
+
 	dir := "testdata/diff"
 	cases, err := testdataDir.ReadDir(dir)
 	assert.NoError(t, err)
@@ -37,3 +48,42 @@ func TestScreenDiff(t *testing.T) {
 		})
 	}
 }
+
+func FuzzScreenDiff(f *testing.F) {
+	dir := "testdata/diff"
+	cases, err := testdataDir.ReadDir(dir)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, c := range cases {
+		before, err := testdataDir.ReadFile(path.Join(dir, c.Name(), "before.txt"))
+		if err != nil {
+			f.Fatal(err)
+		}
+		after, err := testdataDir.ReadFile(path.Join(dir, c.Name(), "after.txt"))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(before), string(after), false)
+		f.Add(string(before), string(after), true)
+	}
+	// Opencode screens shorter than the header used to panic.
+	f.Add("header", "header\n", true)
+	f.Fuzz(func(t *testing.T, oldScreen, newScreen string, opencode bool) {
+		agentType := msgfmt.AgentTypeCustom
+		if opencode {
+			agentType = msgfmt.AgentTypeOpencode
+		}
+		diff, start, end := screenDiffLines(oldScreen, newScreen, agentType)
+		if start == -1 {
+			return
+		}
+		lines := strings.Split(newScreen, "\n")
+		if start > end || end >= len(lines) {
+			t.Fatalf("lines [%d, %d] out of range of %d lines", start, end, len(lines))
+		}
+		if diff != strings.Join(lines[start:end+1], "\n") {
+			t.Fatalf("diff %q doesn't match lines [%d, %d]", diff, start, end)
+		}
+	})
+}
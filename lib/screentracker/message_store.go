@@ -0,0 +1,201 @@
+package screentracker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"slices"
+
+	"golang.org/x/xerrors"
+)
+
+// MessageStore holds the messages of a PTYConversation. Only the latest
+// message ever changes, so stores can move older messages out of memory.
+//
+// Stores aren't safe for concurrent use, the conversation serializes
+// access. Slices returned by Messages must not be modified; they stay valid
+// after the store changes.
+type MessageStore interface {
+	// Len returns the number of messages. Message IDs are their index.
+	Len() int
+	// Messages returns the messages with IDs from start up to, but not
+	// including, end.
+	Messages(start, end int) ([]ConversationMessage, error)
+	// Append adds a message after the latest one.
+	Append(msg ConversationMessage) error
+	// ReplaceLast replaces the latest message.
+	ReplaceLast(msg ConversationMessage) error
+	// Reset replaces all messages, e.g. with the ones of a saved state.
+	Reset(messages []ConversationMessage) error
+	// Close releases the resources of the store.
+	Close() error
+}
+
+// memoryMessageStore keeps all messages in memory. Returned slices share
+// the array, so the latest message is copied on write, and slices are
+// clipped so that appending doesn't change them.
+type memoryMessageStore struct {
+	messages []ConversationMessage
+}
+
+// NewMemoryMessageStore returns a store that keeps all messages in memory.
+func NewMemoryMessageStore() MessageStore {
+	return &memoryMessageStore{}
+}
+
+func (s *memoryMessageStore) Len() int {
+	return len(s.messages)
+}
+
+func (s *memoryMessageStore) Messages(start, end int) ([]ConversationMessage, error) {
+	if start < 0 || end > len(s.messages) || start > end {
+		return nil, xerrors.Errorf("message range [%d, %d) out of bounds, there are %d messages", start, end, len(s.messages))
+	}
+	return s.messages[start:end:end], nil
+}
+
+func (s *memoryMessageStore) Append(msg ConversationMessage) error {
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func (s *memoryMessageStore) ReplaceLast(msg ConversationMessage) error {
+	if len(s.messages) == 0 {
+		return xerrors.New("no message to replace")
+	}
+	last := len(s.messages) - 1
+	s.messages = append(s.messages[:last:last], msg)
+	return nil
+}
+
+func (s *memoryMessageStore) Reset(messages []ConversationMessage) error {
+	s.messages = slices.Clip(messages)
+	return nil
+}
+
+func (s *memoryMessageStore) Close() error {
+	return nil
+}
+
+// DiskMessageStore keeps the latest messages in memory and moves older
+// ones to a file, from which they're read when requested.
+type DiskMessageStore struct {
+	file   *os.File
+	window int
+	// offsets holds the position in the file of each message moved there,
+	// and size is the size of the file.
+	offsets []int64
+	size    int64
+	// recent holds the messages from len(offsets) on. They're copied on
+	// write, like those of memoryMessageStore.
+	recent []ConversationMessage
+}
+
+var _ MessageStore = &DiskMessageStore{}
+
+// NewDiskMessageStore creates a store that keeps the latest window messages
+// in memory and the rest in a temporary file in dir, or the default
+// directory for temporary files if dir is empty. The file is removed on
+// Close.
+func NewDiskMessageStore(dir string, window int) (*DiskMessageStore, error) {
+	if window < 1 {
+		return nil, xerrors.Errorf("message window must be at least 1, got %d", window)
+	}
+	file, err := os.CreateTemp(dir, "agentapi-messages-*.jsonl")
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create message file: %w", err)
+	}
+	return &DiskMessageStore{file: file, window: window}, nil
+}
+
+func (s *DiskMessageStore) Len() int {
+	return len(s.offsets) + len(s.recent)
+}
+
+func (s *DiskMessageStore) Messages(start, end int) ([]ConversationMessage, error) {
+	if start < 0 || end > s.Len() || start > end {
+		return nil, xerrors.Errorf("message range [%d, %d) out of bounds, there are %d messages", start, end, s.Len())
+	}
+	spilled := len(s.offsets)
+	if start >= spilled {
+		return s.recent[start-spilled : end-spilled : end-spilled], nil
+	}
+	messages := make([]ConversationMessage, 0, end-start)
+	diskEnd := min(end, spilled)
+	readEnd := s.size
+	if diskEnd < spilled {
+		readEnd = s.offsets[diskEnd]
+	}
+	reader := bufio.NewReader(io.NewSectionReader(s.file, s.offsets[start], readEnd-s.offsets[start]))
+	decoder := json.NewDecoder(reader)
+	for range diskEnd - start {
+		var msg ConversationMessage
+		if err := decoder.Decode(&msg); err != nil {
+			return nil, xerrors.Errorf("failed to read message %d: %w", start+len(messages), err)
+		}
+		messages = append(messages, msg)
+	}
+	if end > spilled {
+		messages = append(messages, s.recent[:end-spilled]...)
+	}
+	return messages, nil
+}
+
+func (s *DiskMessageStore) Append(msg ConversationMessage) error {
+	s.recent = append(s.recent, msg)
+	return s.spill()
+}
+
+func (s *DiskMessageStore) ReplaceLast(msg ConversationMessage) error {
+	if len(s.recent) == 0 {
+		return xerrors.New("no message to replace")
+	}
+	last := len(s.recent) - 1
+	s.recent = append(s.recent[:last:last], msg)
+	return nil
+}
+
+func (s *DiskMessageStore) Reset(messages []ConversationMessage) error {
+	if err := s.file.Truncate(0); err != nil {
+		return xerrors.Errorf("failed to truncate message file: %w", err)
+	}
+	s.offsets = nil
+	s.size = 0
+	s.recent = slices.Clip(messages)
+	return s.spill()
+}
+
+// spill moves the messages beyond the window to the file. Messages that
+// can't be written stay in memory.
+func (s *DiskMessageStore) spill() error {
+	excess := len(s.recent) - s.window
+	if excess <= 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	offsets := make([]int64, 0, excess)
+	encoder := json.NewEncoder(&buf)
+	for _, msg := range s.recent[:excess] {
+		offsets = append(offsets, s.size+int64(buf.Len()))
+		if err := encoder.Encode(msg); err != nil {
+			return xerrors.Errorf("failed to encode message %d: %w", msg.Id, err)
+		}
+	}
+	if _, err := s.file.WriteAt(buf.Bytes(), s.size); err != nil {
+		return xerrors.Errorf("failed to write messages: %w", err)
+	}
+	s.offsets = append(s.offsets, offsets...)
+	s.size += int64(buf.Len())
+	s.recent = s.recent[excess:]
+	return nil
+}
+
+func (s *DiskMessageStore) Close() error {
+	closeErr := s.file.Close()
+	if err := os.Remove(s.file.Name()); err != nil {
+		return xerrors.Errorf("failed to remove message file: %w", err)
+	}
+	return closeErr
+}
@@ -0,0 +1,94 @@
+package screentracker_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+)
+
+func TestMessageStores(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	message := func(id int, text string) st.ConversationMessage {
+		role := st.ConversationRoleAgent
+		if id%2 == 1 {
+			role = st.ConversationRoleUser
+		}
+		return st.ConversationMessage{Id: id, Message: text, Role: role, Time: now}
+	}
+
+	stores := map[string]func(t *testing.T) st.MessageStore{
+		"memory": func(t *testing.T) st.MessageStore {
+			return st.NewMemoryMessageStore()
+		},
+		"disk": func(t *testing.T) st.MessageStore {
+			store, err := st.NewDiskMessageStore(t.TempDir(), 2)
+			require.NoError(t, err)
+			return store
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			t.Cleanup(func() { _ = store.Close() })
+
+			for i, text := range []string{"a", "b", "c", "d"} {
+				require.NoError(t, store.Append(message(i, text)))
+			}
+			before, err := store.Messages(3, 4)
+			require.NoError(t, err)
+			require.NoError(t, store.ReplaceLast(message(3, "d2")))
+			require.NoError(t, store.Append(message(4, "e")))
+			assert.Equal(t, 5, store.Len())
+
+			all, err := store.Messages(0, 5)
+			require.NoError(t, err)
+			assert.Equal(t, []st.ConversationMessage{
+				message(0, "a"), message(1, "b"), message(2, "c"), message(3, "d2"), message(4, "e"),
+			}, all)
+			// Returned messages don't change with the store.
+			assert.Equal(t, []st.ConversationMessage{message(3, "d")}, before)
+
+			middle, err := store.Messages(1, 4)
+			require.NoError(t, err)
+			assert.Equal(t, all[1:4], middle)
+
+			_, err = store.Messages(3, 6)
+			assert.Error(t, err)
+
+			require.NoError(t, store.Reset([]st.ConversationMessage{message(0, "x"), message(1, "y"), message(2, "z")}))
+			all, err = store.Messages(0, store.Len())
+			require.NoError(t, err)
+			assert.Equal(t, []st.ConversationMessage{message(0, "x"), message(1, "y"), message(2, "z")}, all)
+		})
+	}
+
+	t.Run("disk file is removed on close", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := st.NewDiskMessageStore(dir, 1)
+		require.NoError(t, err)
+		require.NoError(t, store.Append(message(0, "a")))
+		require.NoError(t, store.Append(message(1, "b")))
+		files, err := filepath.Glob(filepath.Join(dir, "*"))
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		info, err := os.Stat(files[0])
+		require.NoError(t, err)
+		assert.NotZero(t, info.Size())
+
+		require.NoError(t, store.Close())
+		files, err = filepath.Glob(filepath.Join(dir, "*"))
+		require.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("disk window must be positive", func(t *testing.T) {
+		_, err := st.NewDiskMessageStore(t.TempDir(), 0)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,266 @@
+package screentracker
+
+import (
+	"sync"
+)
+
+// ConversationMiddleware observes and rewrites the messages of a
+// conversation without depending on its transport. Features such as
+// redaction, summarization, budget tracking or webhooks implement it and are
+// composed with NewMiddlewareChain.
+type ConversationMiddleware interface {
+	// OnUserMessage is called with the text of each user message before it
+	// is sent to the agent, and returns the text to send. Returning an
+	// error rejects the message.
+	OnUserMessage(message string) (string, error)
+	// OnAgentMessage is called each time an agent message is created or
+	// updated, and returns the message clients see. The message the agent
+	// wrote is left untouched in the underlying conversation.
+	OnAgentMessage(message ConversationMessage) ConversationMessage
+	// OnStatusChange is called when the conversation status changes.
+	OnStatusChange(status ConversationStatus)
+}
+
+// OutputMiddleware is implemented by middlewares that also rewrite the
+// agent output clients see outside of messages: the terminal screen and
+// streamed thoughts.
+type OutputMiddleware interface {
+	OnAgentOutput(text string) string
+}
+
+// NoopMiddleware implements every ConversationMiddleware hook without
+// doing anything. Embed it to implement only some of the hooks.
+type NoopMiddleware struct{}
+
+func (NoopMiddleware) OnUserMessage(message string) (string, error) { return message, nil }
+func (NoopMiddleware) OnAgentMessage(message ConversationMessage) ConversationMessage {
+	return message
+}
+func (NoopMiddleware) OnStatusChange(ConversationStatus) {}
+
+// MiddlewareChain runs middlewares in the order they were registered.
+type MiddlewareChain struct {
+	middlewares []ConversationMiddleware
+
+	mu sync.Mutex
+	// agentMessages caches the result of OnAgentMessage by message id, so
+	// the hooks only run when a message changes rather than on every read.
+	agentMessages map[int]processedMessage
+	status        ConversationStatus
+}
+
+type processedMessage struct {
+	original ConversationMessage
+	result   ConversationMessage
+}
+
+func NewMiddlewareChain(middlewares ...ConversationMiddleware) *MiddlewareChain {
+	return &MiddlewareChain{
+		middlewares:   middlewares,
+		agentMessages: make(map[int]processedMessage),
+	}
+}
+
+// UserMessage runs OnUserMessage on the visible text parts of a message.
+// Hidden parts, such as terminal escape sequences, are sent as is.
+func (c *MiddlewareChain) UserMessage(parts []MessagePart) ([]MessagePart, error) {
+	result := make([]MessagePart, len(parts))
+	for i, part := range parts {
+		text, ok := part.(MessagePartText)
+		if !ok || text.Hidden || text.Alias != "" {
+			result[i] = part
+			continue
+		}
+		for _, m := range c.middlewares {
+			var err error
+			if text.Content, err = m.OnUserMessage(text.Content); err != nil {
+				return nil, err
+			}
+		}
+		result[i] = text
+	}
+	return result, nil
+}
+
+// processMessages runs OnAgentMessage on agent messages that changed since
+// the last call.
+func (c *MiddlewareChain) processMessages(messages []ConversationMessage) []ConversationMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make([]ConversationMessage, len(messages))
+	for i, msg := range messages {
+		if msg.Role != ConversationRoleAgent {
+			result[i] = msg
+			continue
+		}
+		// Compare the fields agents update in place. Plans and diffs are
+		// only ever added alongside a change to one of them.
+		if cached, ok := c.agentMessages[msg.Id]; ok && cached.original.Message == msg.Message &&
+			cached.original.Thought == msg.Thought && cached.original.StopReason == msg.StopReason && cached.original.Pinned == msg.Pinned &&
+			len(cached.original.Diffs) == len(msg.Diffs) && len(cached.original.Plan) == len(msg.Plan) {
+			result[i] = cached.result
+			continue
+		}
+		processed := msg
+		for _, m := range c.middlewares {
+			processed = m.OnAgentMessage(processed)
+		}
+		c.agentMessages[msg.Id] = processedMessage{original: msg, result: processed}
+		result[i] = processed
+	}
+	return result
+}
+
+// AgentOutput runs OnAgentOutput of the middlewares implementing
+// OutputMiddleware on text.
+func (c *MiddlewareChain) AgentOutput(text string) string {
+	for _, m := range c.middlewares {
+		if m, ok := m.(OutputMiddleware); ok {
+			text = m.OnAgentOutput(text)
+		}
+	}
+	return text
+}
+
+func (c *MiddlewareChain) statusChanged(status ConversationStatus) {
+	c.mu.Lock()
+	changed := status != c.status
+	c.status = status
+	c.mu.Unlock()
+	if !changed {
+		return
+	}
+	for _, m := range c.middlewares {
+		m.OnStatusChange(status)
+	}
+}
+
+// Emitter wraps the emitter a conversation is created with, so that
+// published messages and status changes go through the chain.
+func (c *MiddlewareChain) Emitter(emitter Emitter) Emitter {
+	wrapped := &middlewareEmitter{Emitter: emitter, chain: c}
+	if _, ok := emitter.(MessageWindowEmitter); ok {
+		// Unlike the other optional interfaces, calls can't be dropped if
+		// the emitter doesn't implement it.
+		return &middlewareWindowEmitter{middlewareEmitter: wrapped}
+	}
+	return wrapped
+}
+
+// Conversation wraps a conversation created with Emitter, so that messages
+// sent and read through it go through the chain.
+func (c *MiddlewareChain) Conversation(conversation Conversation) Conversation {
+	return &middlewareConversation{Conversation: conversation, chain: c}
+}
+
+// middlewareEmitter forwards the optional emitter interfaces as well, so
+// transports find the same capabilities as on the wrapped emitter.
+type middlewareEmitter struct {
+	Emitter
+	chain *MiddlewareChain
+}
+
+func (e *middlewareEmitter) EmitMessages(messages []ConversationMessage) {
+	e.Emitter.EmitMessages(e.chain.processMessages(messages))
+}
+
+func (e *middlewareEmitter) EmitStatus(status ConversationStatus) {
+	e.chain.statusChanged(status)
+	e.Emitter.EmitStatus(status)
+}
+
+func (e *middlewareEmitter) EmitScreen(screen string) {
+	e.Emitter.EmitScreen(e.chain.AgentOutput(screen))
+}
+
+func (e *middlewareEmitter) EmitToolCall(toolCall ToolCall) {
+	if emitter, ok := e.Emitter.(ToolCallEmitter); ok {
+		emitter.EmitToolCall(toolCall)
+	}
+}
+
+func (e *middlewareEmitter) EmitThought(messageId int, thought string) {
+	if emitter, ok := e.Emitter.(ReasoningEmitter); ok {
+		emitter.EmitThought(messageId, e.chain.AgentOutput(thought))
+	}
+}
+
+func (e *middlewareEmitter) EmitPlan(messageId int, plan []PlanEntry) {
+	if emitter, ok := e.Emitter.(ReasoningEmitter); ok {
+		emitter.EmitPlan(messageId, plan)
+	}
+}
+
+func (e *middlewareEmitter) EmitDiff(messageId int, diff FileDiff) {
+	if emitter, ok := e.Emitter.(DiffEmitter); ok {
+		emitter.EmitDiff(messageId, diff)
+	}
+}
+
+type middlewareWindowEmitter struct {
+	*middlewareEmitter
+}
+
+func (e *middlewareWindowEmitter) EmitMessageWindow(start int, messages []ConversationMessage) {
+	e.Emitter.(MessageWindowEmitter).EmitMessageWindow(start, e.chain.processMessages(messages))
+}
+
+type middlewareConversation struct {
+	Conversation
+	chain *MiddlewareChain
+}
+
+func (c *middlewareConversation) Send(parts ...MessagePart) error {
+	parts, err := c.chain.UserMessage(parts)
+	if err != nil {
+		return err
+	}
+	return c.Conversation.Send(parts...)
+}
+
+func (c *middlewareConversation) Messages() []ConversationMessage {
+	return c.chain.processMessages(c.Conversation.Messages())
+}
+
+func (c *middlewareConversation) MessageRange(start, end int) ([]ConversationMessage, int, error) {
+	messages, n, err := ReadMessageRange(c.Conversation, start, end)
+	if err != nil {
+		return nil, n, err
+	}
+	return c.chain.processMessages(messages), n, nil
+}
+
+// RestoreState forwards to the wrapped conversation, so that the
+// middlewares don't hide that it's a StateRestorer.
+func (c *middlewareConversation) RestoreState(state AgentState) error {
+	restorer, ok := c.Conversation.(StateRestorer)
+	if !ok {
+		return ErrStateRestoreUnsupported
+	}
+	return restorer.RestoreState(state)
+}
+
+// StartTerminalInput forwards to the wrapped conversation if it records
+// terminal input.
+func (c *middlewareConversation) StartTerminalInput() {
+	if recorder, ok := c.Conversation.(TerminalInputRecorder); ok {
+		recorder.StartTerminalInput()
+	}
+}
+
+// RecordTerminalInput records the message without running it through the
+// middlewares, since the agent already received it.
+func (c *middlewareConversation) RecordTerminalInput(message string) {
+	if recorder, ok := c.Conversation.(TerminalInputRecorder); ok {
+		recorder.RecordTerminalInput(message)
+	}
+}
+
+// QueueDepth forwards to the wrapped conversation, or reports an empty
+// queue if it doesn't queue messages.
+func (c *middlewareConversation) QueueDepth() int {
+	if reporter, ok := c.Conversation.(QueueReporter); ok {
+		return reporter.QueueDepth()
+	}
+	return 0
+}
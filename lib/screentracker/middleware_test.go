@@ -0,0 +1,197 @@
+package screentracker_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// redactMiddleware masks a secret in both directions and counts the hooks
+// it sees.
+type redactMiddleware struct {
+	st.NoopMiddleware
+	agentCalls int
+	statuses   []st.ConversationStatus
+}
+
+func (m *redactMiddleware) OnUserMessage(message string) (string, error) {
+	if strings.Contains(message, "forbidden") {
+		return "", errors.New("message rejected")
+	}
+	return strings.ReplaceAll(message, "hunter2", "***"), nil
+}
+
+func (m *redactMiddleware) OnAgentMessage(message st.ConversationMessage) st.ConversationMessage {
+	m.agentCalls++
+	message.Message = strings.ReplaceAll(message.Message, "hunter2", "***")
+	return message
+}
+
+func (m *redactMiddleware) OnAgentOutput(text string) string {
+	return strings.ReplaceAll(text, "hunter2", "***")
+}
+
+func (m *redactMiddleware) OnStatusChange(status st.ConversationStatus) {
+	m.statuses = append(m.statuses, status)
+}
+
+// suffixMiddleware only implements OnAgentMessage.
+type suffixMiddleware struct {
+	st.NoopMiddleware
+}
+
+func (suffixMiddleware) OnAgentMessage(message st.ConversationMessage) st.ConversationMessage {
+	message.Message += "!"
+	return message
+}
+
+// recordingEmitter records what the wrapped conversation publishes.
+type recordingEmitter struct {
+	testEmitter
+	messages  []st.ConversationMessage
+	toolCalls []st.ToolCall
+	screen    string
+}
+
+func (e *recordingEmitter) EmitScreen(screen string) {
+	e.screen = screen
+}
+
+func (e *recordingEmitter) EmitMessages(messages []st.ConversationMessage) {
+	e.messages = messages
+}
+
+func (e *recordingEmitter) EmitToolCall(toolCall st.ToolCall) {
+	e.toolCalls = append(e.toolCalls, toolCall)
+}
+
+// windowEmitter records the message windows it is given.
+type windowEmitter struct {
+	recordingEmitter
+	start int
+}
+
+func (e *windowEmitter) EmitMessageWindow(start int, messages []st.ConversationMessage) {
+	e.start = start
+	e.messages = messages
+}
+
+// fakeConversation stores sent messages and returns fixed agent messages.
+type fakeConversation struct {
+	st.Conversation
+	sent     []st.MessagePart
+	messages []st.ConversationMessage
+}
+
+func (c *fakeConversation) Send(parts ...st.MessagePart) error {
+	c.sent = parts
+	return nil
+}
+
+func (c *fakeConversation) Messages() []st.ConversationMessage {
+	return c.messages
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	redact := &redactMiddleware{}
+	chain := st.NewMiddlewareChain(redact, suffixMiddleware{})
+
+	t.Run("user messages", func(t *testing.T) {
+		inner := &fakeConversation{}
+		conversation := chain.Conversation(inner)
+		require.NoError(t, conversation.Send(
+			st.MessagePartText{Content: "\x1b[200~", Hidden: true},
+			st.MessagePartText{Content: "the password is hunter2"},
+		))
+		assert.Equal(t, []st.MessagePart{
+			st.MessagePartText{Content: "\x1b[200~", Hidden: true},
+			st.MessagePartText{Content: "the password is ***"},
+		}, inner.sent)
+
+		err := conversation.Send(st.MessagePartText{Content: "something forbidden"})
+		assert.ErrorContains(t, err, "message rejected")
+	})
+
+	t.Run("agent messages", func(t *testing.T) {
+		recorder := &recordingEmitter{}
+		emitter := chain.Emitter(recorder)
+		messages := []st.ConversationMessage{
+			{Id: 0, Role: st.ConversationRoleUser, Message: "hunter2"},
+			{Id: 1, Role: st.ConversationRoleAgent, Message: "Using hunter2"},
+		}
+		emitter.EmitMessages(messages)
+		emitter.EmitMessages(messages)
+		assert.Equal(t, []st.ConversationMessage{
+			{Id: 0, Role: st.ConversationRoleUser, Message: "hunter2"},
+			{Id: 1, Role: st.ConversationRoleAgent, Message: "Using ***!"},
+		}, recorder.messages)
+
+		// Reads through the conversation see the same messages without
+		// running the hooks again.
+		conversation := chain.Conversation(&fakeConversation{messages: messages})
+		assert.Equal(t, recorder.messages, conversation.Messages())
+		assert.Equal(t, 1, redact.agentCalls)
+
+		messages[1].Message = "Using hunter2 again"
+		emitter.EmitMessages(messages)
+		assert.Equal(t, "Using *** again!", recorder.messages[1].Message)
+		assert.Equal(t, 2, redact.agentCalls)
+
+		// Pinning a message runs the hooks again, so they see the flag.
+		messages[1].Pinned = true
+		emitter.EmitMessages(messages)
+		assert.True(t, recorder.messages[1].Pinned)
+		assert.Equal(t, 3, redact.agentCalls)
+
+		toolEmitter, ok := emitter.(st.ToolCallEmitter)
+		require.True(t, ok)
+		toolEmitter.EmitToolCall(st.ToolCall{Name: "Bash"})
+		assert.Equal(t, []st.ToolCall{{Name: "Bash"}}, recorder.toolCalls)
+	})
+
+	t.Run("message windows", func(t *testing.T) {
+		// Emitters that can't take windows aren't given the option.
+		_, ok := chain.Emitter(&recordingEmitter{}).(st.MessageWindowEmitter)
+		assert.False(t, ok)
+
+		recorder := &windowEmitter{}
+		emitter, ok := chain.Emitter(recorder).(st.MessageWindowEmitter)
+		require.True(t, ok)
+		emitter.EmitMessageWindow(5, []st.ConversationMessage{
+			{Id: 5, Role: st.ConversationRoleAgent, Message: "Found hunter2"},
+		})
+		assert.Equal(t, 5, recorder.start)
+		assert.Equal(t, []st.ConversationMessage{
+			{Id: 5, Role: st.ConversationRoleAgent, Message: "Found ***!"},
+		}, recorder.messages)
+
+		conversation := chain.Conversation(&fakeConversation{messages: []st.ConversationMessage{
+			{Id: 0, Role: st.ConversationRoleUser, Message: "hunter2"},
+			{Id: 1, Role: st.ConversationRoleAgent, Message: "Using hunter2"},
+		}})
+		messages, n, err := st.ReadMessageRange(conversation, 1, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, []st.ConversationMessage{
+			{Id: 1, Role: st.ConversationRoleAgent, Message: "Using ***!"},
+		}, messages)
+	})
+
+	t.Run("screen", func(t *testing.T) {
+		recorder := &recordingEmitter{}
+		chain.Emitter(recorder).EmitScreen("$ echo hunter2\nhunter2")
+		assert.Equal(t, "$ echo ***\n***", recorder.screen)
+	})
+
+	t.Run("status changes", func(t *testing.T) {
+		emitter := chain.Emitter(&recordingEmitter{})
+		emitter.EmitStatus(st.ConversationStatusStable)
+		emitter.EmitStatus(st.ConversationStatusStable)
+		emitter.EmitStatus(st.ConversationStatusChanging)
+		assert.Equal(t, []st.ConversationStatus{st.ConversationStatusStable, st.ConversationStatusChanging}, redact.statuses)
+	})
+}
@@ -2,15 +2,17 @@ package screentracker
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"math"
 	"os"
-	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
+	"github.com/coder/agentapi/lib/logctx"
 	"github.com/coder/agentapi/lib/msgfmt"
 	"github.com/coder/agentapi/lib/util"
 	"github.com/coder/quartz"
@@ -54,6 +56,10 @@ type AgentState struct {
 	Messages          []ConversationMessage `json:"messages"`
 	InitialPrompt     string                `json:"initial_prompt"`
 	InitialPromptSent bool                  `json:"initial_prompt_sent"`
+	Notes             map[string]string     `json:"notes,omitempty"`
+	// Environment is how the conversation was produced. States saved
+	// before it was recorded don't have it.
+	Environment *Environment `json:"environment,omitempty"`
 }
 
 // LoadStateStatus represents the state of loading persisted conversation state.
@@ -108,10 +114,18 @@ type PTYConversationConfig struct {
 	ReadyForInitialPrompt func(message string) bool
 	// FormatToolCall removes the coder report_task tool call from the agent message and also returns the array of removed tool calls
 	FormatToolCall func(message string) (string, []string)
+	// DetectStopReason returns the reason the agent stopped if the agent
+	// message shows one, e.g. a context limit banner. Optional.
+	DetectStopReason func(message string) StopReason
 	// InitialPrompt is the initial prompt to send to the agent once ready
 	InitialPrompt          []MessagePart
 	Logger                 *slog.Logger
 	StatePersistenceConfig StatePersistenceConfig
+	// DebugMessages sets the Debug field of agent messages.
+	DebugMessages bool
+	// MessageStore holds the messages. Optional, defaults to keeping all
+	// messages in memory.
+	MessageStore MessageStore
 }
 
 func (cfg PTYConversationConfig) getStableSnapshotsThreshold() int {
@@ -132,7 +146,7 @@ type PTYConversation struct {
 	// How many stable snapshots are required to consider the screen stable
 	stableSnapshotsThreshold    int
 	snapshotBuffer              *RingBuffer[screenSnapshot]
-	messages                    []ConversationMessage
+	store                       MessageStore
 	screenBeforeLastUserMessage string
 	lock                        sync.Mutex
 
@@ -153,6 +167,11 @@ type PTYConversation struct {
 	stableSignal chan struct{}
 	// toolCallMessageSet keeps track of the tool calls that have been detected & logged in the current agent message
 	toolCallMessageSet map[string]bool
+	// pendingToolCalls holds detected tool calls until the snapshot loop
+	// emits them outside the lock.
+	pendingToolCalls []ToolCall
+	// turnId is the turn of the latest message.
+	turnId int
 	// dirty tracks whether the conversation state has changed since the last save
 	dirty bool
 	// userSentMessageAfterLoadState tracks if the user has sent their first message after we load the state
@@ -164,9 +183,34 @@ type PTYConversation struct {
 	initialPromptReady bool
 	// initialPromptSent is set to true when the initial prompt has been enqueued to the outbound queue.
 	initialPromptSent bool
+	// notes are client-managed metadata persisted with the state file.
+	// nil until notes are set or loaded.
+	notes map[string]string
+	// screenBeforeTerminalInput is the screen before the user started
+	// typing a message into the terminal, if they are.
+	screenBeforeTerminalInput *string
+	// inputVersion is incremented whenever the state agent messages are
+	// parsed against changes, i.e. when the user sends a message or the
+	// state is loaded, so the snapshot loop can tell whether a message it
+	// parsed without holding the lock is still valid.
+	inputVersion int
+	// parsedScreen and parsedVersion are the screen and inputVersion the
+	// latest agent message was last parsed from. The snapshot loop doesn't
+	// parse the screen again until one of them changes.
+	parsedScreen  string
+	parsedVersion int
+	// unemittedFrom is the ID of the first message that changed since the
+	// messages were last emitted, or math.MaxInt if none did.
+	unemittedFrom int
+	// pinned holds the IDs of the pinned messages. The store keeps messages
+	// as they were written, so Pinned is set on them as they're read.
+	pinned map[int]bool
 }
 
-var _ Conversation = &PTYConversation{}
+var (
+	_ Conversation  = &PTYConversation{}
+	_ MessageRanger = &PTYConversation{}
+)
 
 type noopEmitter struct{}
 
@@ -182,19 +226,15 @@ func NewPTY(ctx context.Context, cfg PTYConversationConfig, emitter Emitter) *PT
 	if emitter == nil {
 		emitter = noopEmitter{}
 	}
+	if cfg.MessageStore == nil {
+		cfg.MessageStore = NewMemoryMessageStore()
+	}
 	threshold := cfg.getStableSnapshotsThreshold()
 	c := &PTYConversation{
-		cfg:                      cfg,
-		emitter:                  emitter,
-		stableSnapshotsThreshold: threshold,
-		snapshotBuffer:           NewRingBuffer[screenSnapshot](threshold),
-		messages: []ConversationMessage{
-			{
-				Message: "",
-				Role:    ConversationRoleAgent,
-				Time:    cfg.Clock.Now(),
-			},
-		},
+		cfg:                           cfg,
+		emitter:                       emitter,
+		stableSnapshotsThreshold:      threshold,
+		snapshotBuffer:                NewRingBuffer[screenSnapshot](threshold),
 		outboundQueue:                 make(chan outboundMessage, 1),
 		stableSignal:                  make(chan struct{}, 1),
 		toolCallMessageSet:            make(map[string]bool),
@@ -202,7 +242,14 @@ func NewPTY(ctx context.Context, cfg PTYConversationConfig, emitter Emitter) *PT
 		userSentMessageAfterLoadState: false,
 		loadStateStatus:               LoadStatePending,
 		writingMessage:                false,
+		parsedVersion:                 -1,
+		store:                         cfg.MessageStore,
 	}
+	c.appendMessageLocked(ConversationMessage{
+		Message: "",
+		Role:    ConversationRoleAgent,
+		Time:    cfg.Clock.Now(),
+	})
 	if c.cfg.ReadyForInitialPrompt == nil {
 		c.cfg.ReadyForInitialPrompt = func(string) bool { return true }
 	}
@@ -212,11 +259,57 @@ func NewPTY(ctx context.Context, cfg PTYConversationConfig, emitter Emitter) *PT
 func (c *PTYConversation) Start(ctx context.Context) {
 	// Snapshot loop
 	c.cfg.Clock.TickerFunc(ctx, c.cfg.SnapshotInterval, func() error {
-		c.lock.Lock()
+		// The screen is captured and formatted without holding the lock,
+		// so that slow formatters don't block Status() and Send(). The
+		// result is applied under the lock, unless a user message was sent
+		// in the meantime, in which case the screen is parsed again.
 		screen := c.cfg.AgentIO.ReadScreen()
-		c.snapshotLocked(screen)
+		timestamp := c.cfg.Clock.Now()
+		c.lock.Lock()
+		input := c.agentMessageInputLocked()
+		parse := !input.writingMessage && (input.version != c.parsedVersion || screen != c.parsedScreen)
+		c.lock.Unlock()
+		var parsed parsedAgentMessage
+		if parse {
+			parsed = c.parseAgentMessage(screen, input)
+		}
+
+		c.lock.Lock()
+		if input.version == c.inputVersion {
+			c.snapshotBuffer.Add(screenSnapshot{timestamp: timestamp, screen: screen})
+			if parse && !c.writingMessage {
+				c.applyAgentMessageLocked(parsed, timestamp)
+				c.parsedScreen = screen
+				c.parsedVersion = input.version
+			}
+		} else {
+			screen = c.cfg.AgentIO.ReadScreen()
+			c.snapshotLocked(screen)
+		}
 		status := c.statusLocked()
-		messages := c.messagesLocked()
+		// Only the messages that changed are passed to emitters that accept
+		// them, so that those moved out of memory by the store aren't read
+		// again. The store never modifies the returned messages, so they
+		// aren't copied.
+		windowEmitter, windowed := c.emitter.(MessageWindowEmitter)
+		emitFrom := min(c.unemittedFrom, c.store.Len())
+		if !windowed {
+			emitFrom = 0
+		}
+		emitMessages := c.unemittedFrom <= c.store.Len()
+		var messages []ConversationMessage
+		if emitMessages {
+			var err error
+			if messages, err = c.store.Messages(emitFrom, c.store.Len()); err != nil {
+				c.cfg.Logger.Error("Failed to read messages", "error", err)
+				emitMessages = false
+			} else {
+				messages = c.withPinnedLocked(emitFrom, messages)
+				c.unemittedFrom = math.MaxInt
+			}
+		}
+		toolCalls := c.pendingToolCalls
+		c.pendingToolCalls = nil
 
 		// Signal send loop if agent is ready and queue has items.
 		// We check readiness independently of statusLocked() because
@@ -235,6 +328,7 @@ func (c *PTYConversation) Start(ctx context.Context) {
 				}
 			} else {
 				c.loadStateStatus = LoadStateSucceeded
+				c.inputVersion++
 			}
 		}
 
@@ -261,8 +355,17 @@ func (c *PTYConversation) Start(ctx context.Context) {
 			c.emitter.EmitError(loadErr, ErrorLevelWarning)
 		}
 		c.emitter.EmitStatus(status)
-		c.emitter.EmitMessages(messages)
+		if emitMessages && windowed {
+			windowEmitter.EmitMessageWindow(emitFrom, messages)
+		} else if emitMessages {
+			c.emitter.EmitMessages(messages)
+		}
 		c.emitter.EmitScreen(screen)
+		if toolCallEmitter, ok := c.emitter.(ToolCallEmitter); ok {
+			for _, toolCall := range toolCalls {
+				toolCallEmitter.EmitToolCall(toolCall)
+			}
+		}
 		return nil
 	}, "snapshot")
 
@@ -309,59 +412,183 @@ func (c *PTYConversation) Start(ctx context.Context) {
 	}()
 }
 
+// lastMessage returns the latest message with the role. Messages are read
+// one at a time, since it's usually one of the latest, which stores keep in
+// memory.
+// caller MUST hold c.lock
 func (c *PTYConversation) lastMessage(role ConversationRole) ConversationMessage {
-	for i := len(c.messages) - 1; i >= 0; i-- {
-		if c.messages[i].Role == role {
-			return c.messages[i]
+	for i := c.store.Len() - 1; i >= 0; i-- {
+		messages, err := c.store.Messages(i, i+1)
+		if err != nil {
+			c.cfg.Logger.Error("Failed to read messages", "error", err)
+			break
+		}
+		if messages[0].Role == role {
+			return messages[0]
 		}
 	}
 	return ConversationMessage{}
 }
 
+// latestMessageLocked returns the latest message, and false if there are
+// none. The latest message is always kept in memory by stores.
 // caller MUST hold c.lock
-func (c *PTYConversation) updateLastAgentMessageLocked(screen string, timestamp time.Time) {
-	if c.writingMessage {
+func (c *PTYConversation) latestMessageLocked() (ConversationMessage, bool) {
+	n := c.store.Len()
+	if n == 0 {
+		return ConversationMessage{}, false
+	}
+	messages, err := c.store.Messages(n-1, n)
+	if err != nil {
+		c.cfg.Logger.Error("Failed to read the latest message", "error", err)
+		return ConversationMessage{}, false
+	}
+	return messages[0], true
+}
+
+// appendMessageLocked adds a message, setting its ID.
+// caller MUST hold c.lock
+func (c *PTYConversation) appendMessageLocked(msg ConversationMessage) {
+	msg.Id = c.store.Len()
+	if msg.Id == 0 || msg.Role == ConversationRoleUser {
+		c.turnId = msg.Id
+	}
+	msg.TurnId = c.turnId
+	// Stores keep messages they fail to move out of memory, so the error
+	// is only logged.
+	if err := c.store.Append(msg); err != nil {
+		c.cfg.Logger.Error("Failed to store message", "error", err)
+	}
+	c.unemittedFrom = min(c.unemittedFrom, msg.Id)
+}
+
+// replaceLastMessageLocked replaces the latest message, keeping its ID.
+// caller MUST hold c.lock
+func (c *PTYConversation) replaceLastMessageLocked(msg ConversationMessage) {
+	msg.Id = c.store.Len() - 1
+	msg.TurnId = c.turnId
+	if err := c.store.ReplaceLast(msg); err != nil {
+		c.cfg.Logger.Error("Failed to store message", "error", err)
 		return
 	}
-	agentMessage := screenDiff(c.screenBeforeLastUserMessage, screen, c.cfg.AgentType)
-	lastUserMessage := c.lastMessage(ConversationRoleUser)
-	var toolCalls []string
+	c.unemittedFrom = min(c.unemittedFrom, msg.Id)
+}
+
+// agentMessageInput is the state of the conversation the latest agent
+// message is parsed against.
+type agentMessageInput struct {
+	// version is the inputVersion of the conversation at the time.
+	version        int
+	writingMessage bool
+	screenBefore   string
+	userInput      string
+	// restored is the agent message loaded from the state file. It is kept
+	// as is until the user sends a message.
+	restored *ConversationMessage
+}
+
+// parsedAgentMessage is an agent message parsed from the screen.
+type parsedAgentMessage struct {
+	message   ConversationMessage
+	toolCalls []string
+}
+
+// caller MUST hold c.lock
+func (c *PTYConversation) agentMessageInputLocked() agentMessageInput {
+	input := agentMessageInput{
+		version:        c.inputVersion,
+		writingMessage: c.writingMessage,
+		screenBefore:   c.screenBeforeLastUserMessage,
+		userInput:      c.lastMessage(ConversationRoleUser).Message,
+	}
+	if c.loadStateStatus == LoadStateSucceeded && !c.userSentMessageAfterLoadState {
+		if restored, ok := c.latestMessageLocked(); ok && restored.Role == ConversationRoleAgent {
+			input.restored = &restored
+		}
+	}
+	return input
+}
+
+// parseAgentMessage finds the latest agent message on the screen and
+// formats it. It only reads the config, so it's safe to call without
+// holding c.lock.
+func (c *PTYConversation) parseAgentMessage(screen string, input agentMessageInput) parsedAgentMessage {
+	rawMessage, startLine, endLine := screenDiffLines(input.screenBefore, screen, c.cfg.AgentType)
+	agentMessage := rawMessage
 	if c.cfg.FormatMessage != nil {
-		agentMessage = c.cfg.FormatMessage(agentMessage, lastUserMessage.Message)
+		agentMessage = c.cfg.FormatMessage(agentMessage, input.userInput)
 	}
-	if c.loadStateStatus == LoadStateSucceeded && !c.userSentMessageAfterLoadState && len(c.messages) > 0 &&
-		c.messages[len(c.messages)-1].Role == ConversationRoleAgent {
-		agentMessage = c.messages[len(c.messages)-1].Message
+	var debug *MessageDebug
+	if input.restored != nil {
+		agentMessage = input.restored.Message
+		debug = input.restored.Debug
+	} else if c.cfg.DebugMessages {
+		debug = &MessageDebug{
+			ScreenStartLine: startLine,
+			ScreenEndLine:   endLine,
+			EchoRemoved:     msgfmt.UserInputEchoed(rawMessage, input.userInput),
+			Confidence:      msgfmt.ParseConfidence(agentMessage, input.userInput),
+		}
 	}
+	var parsed parsedAgentMessage
 	if c.cfg.FormatToolCall != nil {
-		agentMessage, toolCalls = c.cfg.FormatToolCall(agentMessage)
+		agentMessage, parsed.toolCalls = c.cfg.FormatToolCall(agentMessage)
 	}
-	for _, toolCall := range toolCalls {
+	parsed.message = ConversationMessage{
+		Message: agentMessage,
+		Role:    ConversationRoleAgent,
+		Debug:   debug,
+	}
+	if c.cfg.DetectStopReason != nil {
+		parsed.message.StopReason = c.cfg.DetectStopReason(agentMessage)
+	}
+	return parsed
+}
+
+// caller MUST hold c.lock
+func (c *PTYConversation) updateLastAgentMessageLocked(screen string, timestamp time.Time) {
+	if c.writingMessage {
+		return
+	}
+	c.applyAgentMessageLocked(c.parseAgentMessage(screen, c.agentMessageInputLocked()), timestamp)
+	c.parsedScreen = screen
+	c.parsedVersion = c.inputVersion
+}
+
+// applyAgentMessageLocked adds or updates the latest agent message.
+// caller MUST hold c.lock
+func (c *PTYConversation) applyAgentMessageLocked(parsed parsedAgentMessage, timestamp time.Time) {
+	for _, toolCall := range parsed.toolCalls {
 		if c.toolCallMessageSet[toolCall] == false {
 			c.toolCallMessageSet[toolCall] = true
-			c.cfg.Logger.Info("Tool call detected", "toolCall", toolCall)
+			c.cfg.Logger.Info("Tool call detected", logctx.Content("toolCall", toolCall))
+			// FormatToolCall only removes coder_report_task calls, which
+			// are already finished when they show up on the screen.
+			c.pendingToolCalls = append(c.pendingToolCalls, ToolCall{
+				Name:   msgfmt.ReportTaskToolName,
+				Input:  toolCall,
+				Status: ToolCallStatusCompleted,
+				TurnId: c.turnId,
+			})
 		}
 	}
-	shouldCreateNewMessage := len(c.messages) == 0 || c.messages[len(c.messages)-1].Role == ConversationRoleUser
+	latest, ok := c.latestMessageLocked()
+	shouldCreateNewMessage := !ok || latest.Role == ConversationRoleUser
 	lastAgentMessage := c.lastMessage(ConversationRoleAgent)
-	if lastAgentMessage.Message == agentMessage {
+	if lastAgentMessage.Message == parsed.message.Message {
 		return
 	}
-	conversationMessage := ConversationMessage{
-		Message: agentMessage,
-		Role:    ConversationRoleAgent,
-		Time:    timestamp,
-	}
+	conversationMessage := parsed.message
+	conversationMessage.Time = timestamp
 	if shouldCreateNewMessage {
-		c.messages = append(c.messages, conversationMessage)
+		c.appendMessageLocked(conversationMessage)
 
 		// Cleanup
 		c.toolCallMessageSet = make(map[string]bool)
 
 	} else {
-		c.messages[len(c.messages)-1] = conversationMessage
+		c.replaceLastMessageLocked(conversationMessage)
 	}
-	c.messages[len(c.messages)-1].Id = len(c.messages) - 1
 
 	c.dirty = true
 }
@@ -420,18 +647,55 @@ func (c *PTYConversation) sendMessage(ctx context.Context, messageParts ...Messa
 
 	c.lock.Lock()
 	c.screenBeforeLastUserMessage = screenBeforeMessage
-	c.messages = append(c.messages, ConversationMessage{
-		Id:      len(c.messages),
+	c.appendMessageLocked(ConversationMessage{
 		Message: message,
 		Role:    ConversationRoleUser,
 		Time:    now,
 	})
 	c.userSentMessageAfterLoadState = true
 	c.writingMessage = false
+	c.inputVersion++
 	c.lock.Unlock()
 	return nil
 }
 
+// StartTerminalInput remembers the screen before the user started typing a
+// message into the terminal, so that its echo isn't taken for agent output.
+func (c *PTYConversation) StartTerminalInput() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	screen := c.cfg.AgentIO.ReadScreen()
+	c.updateLastAgentMessageLocked(screen, c.cfg.Clock.Now())
+	c.screenBeforeTerminalInput = &screen
+}
+
+// RecordTerminalInput records a user message typed into the terminal
+// outside of Send.
+func (c *PTYConversation) RecordTerminalInput(message string) {
+	message = msgfmt.TrimWhitespace(message)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	screenBefore := c.screenBeforeTerminalInput
+	c.screenBeforeTerminalInput = nil
+	if message == "" || screenBefore == nil {
+		return
+	}
+	now := c.cfg.Clock.Now()
+	// The echo of the message was taken for agent output while the user
+	// typed it. The reply is what the screen shows after it.
+	c.updateLastAgentMessageLocked(*screenBefore, now)
+	c.screenBeforeLastUserMessage = c.cfg.AgentIO.ReadScreen()
+	c.appendMessageLocked(ConversationMessage{
+		Message: message,
+		Role:    ConversationRoleUser,
+		Time:    now,
+		Source:  MessageSourceTerminal,
+	})
+	c.userSentMessageAfterLoadState = true
+	c.inputVersion++
+	c.dirty = true
+}
+
 // writeStabilize writes messageParts to the PTY and waits for
 // the agent to process them. It operates in two phases:
 //
@@ -558,7 +822,7 @@ func (c *PTYConversation) statusLocked() ConversationStatus {
 	}
 
 	snapshots := c.snapshotBuffer.GetAll()
-	if len(c.messages) > 0 && c.messages[len(c.messages)-1].Role == ConversationRoleUser {
+	if latest, ok := c.latestMessageLocked(); ok && latest.Role == ConversationRoleUser {
 		// if the last message is a user message then the snapshot loop hasn't
 		// been triggered since the last user message, and we should assume
 		// the screen is changing
@@ -594,14 +858,89 @@ func (c *PTYConversation) Messages() []ConversationMessage {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	return c.messagesLocked()
+	messages, err := c.messagesLocked()
+	if err != nil {
+		c.cfg.Logger.Error("Failed to read messages", "error", err)
+	}
+	return messages
 }
 
 // messagesLocked returns a copy of messages. Caller MUST hold c.lock.
-func (c *PTYConversation) messagesLocked() []ConversationMessage {
-	result := make([]ConversationMessage, len(c.messages))
-	copy(result, c.messages)
-	return result
+func (c *PTYConversation) messagesLocked() ([]ConversationMessage, error) {
+	messages, err := c.store.Messages(0, c.store.Len())
+	return slices.Clone(c.withPinnedLocked(0, messages)), err
+}
+
+// withPinnedLocked sets Pinned on messages, the first of which has the ID
+// start. The messages are copied if any of them changes.
+func (c *PTYConversation) withPinnedLocked(start int, messages []ConversationMessage) []ConversationMessage {
+	copied := false
+	for i := range messages {
+		pinned := c.pinned[start+i]
+		if messages[i].Pinned == pinned {
+			continue
+		}
+		if !copied {
+			messages = slices.Clone(messages)
+			copied = true
+		}
+		messages[i].Pinned = pinned
+	}
+	return messages
+}
+
+// MessageRange returns a copy of the messages with IDs from start up to
+// end, reading only those from the store.
+func (c *PTYConversation) MessageRange(start, end int) ([]ConversationMessage, int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	n := c.store.Len()
+	start = max(0, min(start, n))
+	end = max(start, min(end, n))
+	messages, err := c.store.Messages(start, end)
+	if err != nil {
+		return nil, n, err
+	}
+	return slices.Clone(c.withPinnedLocked(start, messages)), n, nil
+}
+
+func (c *PTYConversation) Notes() map[string]string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return maps.Clone(c.notes)
+}
+
+func (c *PTYConversation) SetNotes(notes map[string]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.notes = maps.Clone(notes)
+	if c.notes == nil {
+		c.notes = map[string]string{}
+	}
+	c.dirty = true
+}
+
+func (c *PTYConversation) SetMessagePinned(id int, pinned bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if id < 0 || id >= c.store.Len() {
+		return ErrMessageNotFound
+	}
+	if c.pinned[id] == pinned {
+		return nil
+	}
+	if pinned {
+		if c.pinned == nil {
+			c.pinned = map[int]bool{}
+		}
+		c.pinned[id] = true
+	} else {
+		delete(c.pinned, id)
+	}
+	c.unemittedFrom = min(c.unemittedFrom, id)
+	c.dirty = true
+	return nil
 }
 
 func (c *PTYConversation) Text() string {
@@ -633,7 +972,10 @@ func (c *PTYConversation) SaveState() error {
 		return nil
 	}
 
-	conversation := c.messagesLocked()
+	conversation, err := c.messagesLocked()
+	if err != nil {
+		return xerrors.Errorf("failed to read messages: %w", err)
+	}
 
 	// Serialize initial prompt from message parts
 	var initialPromptStr string
@@ -641,58 +983,20 @@ func (c *PTYConversation) SaveState() error {
 		initialPromptStr = buildStringFromMessageParts(c.cfg.InitialPrompt)
 	}
 
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(stateFile)
-	if err := os.MkdirAll(dir, 0o700); err != nil {
-		return xerrors.Errorf("failed to create state directory: %w", err)
-	}
-
-	// Use atomic write: write to temp file, then rename to target path
-	tempFile := stateFile + ".tmp"
-	f, err := os.OpenFile(tempFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
-	if err != nil {
-		return xerrors.Errorf("failed to create temp state file: %w", err)
+	if filter := c.cfg.StatePersistenceConfig.FilterMessages; filter != nil {
+		conversation = filter(conversation)
 	}
-
-	// Clean up temp file on error (before successful rename)
-	var renamed bool
-	defer func() {
-		if !renamed {
-			if removeErr := os.Remove(tempFile); removeErr != nil && !os.IsNotExist(removeErr) {
-				c.cfg.Logger.Warn("Failed to clean up temp state file", "path", tempFile, "err", removeErr)
-			}
-		}
-	}()
-
-	// Encode directly to file to avoid loading entire JSON into memory
-	encoder := json.NewEncoder(f)
-	if err := encoder.Encode(AgentState{
+	if err := WriteAgentState(stateFile, AgentState{
 		Version:           1,
 		Messages:          conversation,
 		InitialPrompt:     initialPromptStr,
 		InitialPromptSent: c.initialPromptSent,
+		Notes:             c.notes,
+		Environment:       c.cfg.StatePersistenceConfig.Environment,
 	}); err != nil {
-		_ = f.Close()
-		return xerrors.Errorf("failed to encode state: %w", err)
-	}
-
-	// Flush to disk before rename for crash safety
-	if err := f.Sync(); err != nil {
-		_ = f.Close()
-		return xerrors.Errorf("failed to sync state file: %w", err)
-	}
-
-	// Close file before rename
-	if err := f.Close(); err != nil {
-		return xerrors.Errorf("failed to close temp state file: %w", err)
+		return err
 	}
 
-	// Atomic rename
-	if err := os.Rename(tempFile, stateFile); err != nil {
-		return xerrors.Errorf("failed to rename state file: %w", err)
-	}
-	renamed = true
-
 	// Clear dirty flag after successful save
 	c.dirty = false
 
@@ -717,26 +1021,12 @@ func (c *PTYConversation) loadStateLocked() (error, bool) {
 		return xerrors.Errorf("No previous state to load (file does not exist)"), false
 	}
 
-	// Open state file
-	f, err := os.Open(stateFile)
+	agentState, err := ReadAgentState(stateFile)
 	if err != nil {
-		return xerrors.Errorf("failed to open state file: %w", err), true
+		return err, true
 	}
-	defer func() {
-		if closeErr := f.Close(); closeErr != nil {
-			c.cfg.Logger.Warn("Failed to close state file", "path", stateFile, "err", closeErr)
-		}
-	}()
-
-	var agentState AgentState
-	decoder := json.NewDecoder(f)
-	if err := decoder.Decode(&agentState); err != nil {
-		return xerrors.Errorf("failed to unmarshal state (corrupted or invalid JSON): %w", err), true
-	}
-
-	// Validate version
-	if agentState.Version != 1 {
-		return xerrors.Errorf("unsupported state file version %d (expected 1)", agentState.Version), true
+	if differences := c.cfg.StatePersistenceConfig.Environment.Differences(agentState.Environment); len(differences) > 0 {
+		c.cfg.Logger.Warn("The state was saved in a different environment, the agent may behave differently", "differences", differences)
 	}
 
 	// Handle initial prompt restoration:
@@ -758,10 +1048,78 @@ func (c *PTYConversation) loadStateLocked() (error, bool) {
 		}}
 	}
 
-	c.messages = agentState.Messages
+	if err := c.resetMessagesLocked(agentState.Messages); err != nil {
+		return err, true
+	}
+
+	// Notes set before the state was loaded take precedence.
+	if c.notes == nil {
+		c.notes = agentState.Notes
+	}
 
 	c.dirty = false
 
-	c.cfg.Logger.Info("Successfully loaded state", "path", stateFile, "messages", len(c.messages))
+	c.cfg.Logger.Info("Successfully loaded state", "path", stateFile, "messages", c.store.Len())
 	return nil, false
 }
+
+// QueueDepth returns the number of messages waiting to be sent, including
+// the initial prompt, plus the message being written to the agent.
+func (c *PTYConversation) QueueDepth() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	depth := len(c.outboundQueue)
+	if c.writingMessage {
+		depth++
+	}
+	return depth
+}
+
+// RestoreState replaces the messages, pins and notes with those of a saved
+// state while the agent runs. Like a state loaded on startup, the latest
+// agent message is kept as is until the user sends a message. The initial
+// prompt isn't sent again.
+func (c *PTYConversation) RestoreState(state AgentState) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.writingMessage {
+		return xerrors.New("cannot restore the state while a message is being sent")
+	}
+	if err := c.resetMessagesLocked(state.Messages); err != nil {
+		return err
+	}
+	c.notes = state.Notes
+	c.loadStateStatus = LoadStateSucceeded
+	c.userSentMessageAfterLoadState = false
+	c.inputVersion++
+	c.dirty = true
+
+	c.cfg.Logger.Info("Restored state", "messages", c.store.Len())
+	return nil
+}
+
+// resetMessagesLocked replaces the messages and their pins.
+// caller MUST hold c.lock
+func (c *PTYConversation) resetMessagesLocked(messages []ConversationMessage) error {
+	messages = slices.Clone(messages)
+	AssignTurnIds(messages)
+	if err := c.store.Reset(messages); err != nil {
+		return xerrors.Errorf("failed to store messages: %w", err)
+	}
+	c.unemittedFrom = 0
+	c.turnId = 0
+	if len(messages) > 0 {
+		c.turnId = messages[len(messages)-1].TurnId
+	}
+	c.pinned = nil
+	for _, msg := range messages {
+		if msg.Pinned {
+			if c.pinned == nil {
+				c.pinned = map[int]bool{}
+			}
+			c.pinned[msg.Id] = true
+		}
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"os"
 	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -292,6 +293,95 @@ func TestMessages(t *testing.T) {
 		assert.Equal(t, msgs, c.Messages())
 	})
 
+	t.Run("stop reason", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		t.Cleanup(cancel)
+		c, agent, mClock := newConversation(ctx, t, func(cfg *st.PTYConversationConfig) {
+			cfg.DetectStopReason = func(message string) st.StopReason {
+				if strings.Contains(message, "Prompt is too long") {
+					return st.StopReasonMaxTokens
+				}
+				return ""
+			}
+		})
+
+		agent.setScreen("working")
+		advanceFor(ctx, t, mClock, interval)
+		assertMessages(t, c, []st.ConversationMessage{
+			{Id: 0, Message: "working", Role: st.ConversationRoleAgent},
+		})
+
+		agent.setScreen("Prompt is too long")
+		advanceFor(ctx, t, mClock, interval)
+		assertMessages(t, c, []st.ConversationMessage{
+			{Id: 0, Message: "Prompt is too long", Role: st.ConversationRoleAgent, StopReason: st.StopReasonMaxTokens},
+		})
+	})
+
+	t.Run("debug messages", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		t.Cleanup(cancel)
+		c, agent, mClock := newConversation(ctx, t, func(cfg *st.PTYConversationConfig) {
+			cfg.DebugMessages = true
+		})
+
+		agent.setScreen("\nworking\ndone\n")
+		advanceFor(ctx, t, mClock, interval)
+		assertMessages(t, c, []st.ConversationMessage{
+			{Id: 0, Message: "working\ndone", Role: st.ConversationRoleAgent, Debug: &st.MessageDebug{
+				ScreenStartLine: 1,
+				ScreenEndLine:   2,
+				Confidence:      1,
+			}},
+		})
+
+		// Leftover terminal UI lowers the confidence.
+		agent.setScreen("working\n╭────────────╮\n╰────────────╯")
+		advanceFor(ctx, t, mClock, interval)
+		assertMessages(t, c, []st.ConversationMessage{
+			{Id: 0, Message: "working\n╭────────────╮\n╰────────────╯", Role: st.ConversationRoleAgent, Debug: &st.MessageDebug{
+				ScreenStartLine: 0,
+				ScreenEndLine:   2,
+				Confidence:      0.5,
+			}},
+		})
+	})
+
+	t.Run("slow formatter doesn't block status", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		t.Cleanup(cancel)
+		formatting := make(chan struct{})
+		release := make(chan struct{})
+		c, agent, mClock := newConversation(ctx, t, func(cfg *st.PTYConversationConfig) {
+			cfg.FormatMessage = func(message string, userInput string) string {
+				if message == "slow" {
+					formatting <- struct{}{}
+					<-release
+				}
+				return message
+			}
+		})
+
+		agent.setScreen("slow")
+		_, w := mClock.AdvanceNext()
+		select {
+		case <-formatting:
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for the formatter")
+		}
+		// The snapshot loop is formatting the message, but the
+		// conversation can still be queried.
+		assert.Equal(t, st.ConversationStatusInitializing, c.Status())
+		assertMessages(t, c, []st.ConversationMessage{
+			{Id: 0, Message: "", Role: st.ConversationRoleAgent},
+		})
+		close(release)
+		w.MustWait(ctx)
+		assertMessages(t, c, []st.ConversationMessage{
+			{Id: 0, Message: "slow", Role: st.ConversationRoleAgent},
+		})
+	})
+
 	t.Run("tracking messages", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
 		t.Cleanup(cancel)
@@ -323,8 +413,8 @@ func TestMessages(t *testing.T) {
 		advanceFor(ctx, t, mClock, interval*threshold)
 		assertMessages(t, c, []st.ConversationMessage{
 			{Id: 0, Message: "2", Role: st.ConversationRoleAgent},
-			{Id: 1, Message: "3", Role: st.ConversationRoleUser},
-			{Id: 2, Message: "4", Role: st.ConversationRoleAgent},
+			{Id: 1, Message: "3", Role: st.ConversationRoleUser, TurnId: 1},
+			{Id: 2, Message: "4", Role: st.ConversationRoleAgent, TurnId: 1},
 		})
 
 		// Agent message is updated when the screen changes before a user message.
@@ -336,10 +426,10 @@ func TestMessages(t *testing.T) {
 		advanceFor(ctx, t, mClock, interval*threshold)
 		assertMessages(t, c, []st.ConversationMessage{
 			{Id: 0, Message: "2", Role: st.ConversationRoleAgent},
-			{Id: 1, Message: "3", Role: st.ConversationRoleUser},
-			{Id: 2, Message: "5", Role: st.ConversationRoleAgent},
-			{Id: 3, Message: "6", Role: st.ConversationRoleUser},
-			{Id: 4, Message: "7", Role: st.ConversationRoleAgent},
+			{Id: 1, Message: "3", Role: st.ConversationRoleUser, TurnId: 1},
+			{Id: 2, Message: "5", Role: st.ConversationRoleAgent, TurnId: 1},
+			{Id: 3, Message: "6", Role: st.ConversationRoleUser, TurnId: 3},
+			{Id: 4, Message: "7", Role: st.ConversationRoleAgent, TurnId: 3},
 		})
 		assert.Equal(t, st.ConversationStatusStable, c.Status())
 
@@ -352,6 +442,82 @@ func TestMessages(t *testing.T) {
 		assert.Equal(t, st.ConversationStatusStable, c.Status())
 	})
 
+	t.Run("messages moved to disk", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		t.Cleanup(cancel)
+		store, err := st.NewDiskMessageStore(t.TempDir(), 2)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = store.Close() })
+		c, agent, mClock := newConversation(ctx, t, func(cfg *st.PTYConversationConfig) {
+			cfg.MessageStore = store
+		})
+
+		agent.setScreen("1")
+		advanceFor(ctx, t, mClock, interval*threshold)
+		sendAndAdvance(ctx, t, c, mClock, st.MessagePartText{Content: "2"})
+		agent.setScreen("3")
+		advanceFor(ctx, t, mClock, interval*threshold)
+		sendAndAdvance(ctx, t, c, mClock, st.MessagePartText{Content: "4"})
+		agent.setScreen("5")
+		advanceFor(ctx, t, mClock, interval*threshold)
+
+		assertMessages(t, c, []st.ConversationMessage{
+			{Id: 0, Message: "1", Role: st.ConversationRoleAgent},
+			{Id: 1, Message: "2", Role: st.ConversationRoleUser, TurnId: 1},
+			{Id: 2, Message: "3", Role: st.ConversationRoleAgent, TurnId: 1},
+			{Id: 3, Message: "4", Role: st.ConversationRoleUser, TurnId: 3},
+			{Id: 4, Message: "5", Role: st.ConversationRoleAgent, TurnId: 3},
+		})
+		assert.Equal(t, st.ConversationStatusStable, c.Status())
+
+		messages, n, err := c.MessageRange(1, 3)
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+		require.Len(t, messages, 2)
+		assert.Equal(t, "2", messages[0].Message)
+		assert.Equal(t, "3", messages[1].Message)
+
+		messages, n, err = c.MessageRange(4, 10)
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+		require.Len(t, messages, 1)
+		assert.Equal(t, "5", messages[0].Message)
+	})
+
+	t.Run("pinned messages", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		t.Cleanup(cancel)
+		store, err := st.NewDiskMessageStore(t.TempDir(), 1)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = store.Close() })
+		c, agent, mClock := newConversation(ctx, t, func(cfg *st.PTYConversationConfig) {
+			cfg.MessageStore = store
+		})
+
+		agent.setScreen("1")
+		advanceFor(ctx, t, mClock, interval*threshold)
+		sendAndAdvance(ctx, t, c, mClock, st.MessagePartText{Content: "2"})
+		agent.setScreen("3")
+		advanceFor(ctx, t, mClock, interval*threshold)
+
+		// Messages moved to disk can be pinned too.
+		require.NoError(t, c.SetMessagePinned(1, true))
+		assert.ErrorIs(t, c.SetMessagePinned(3, true), st.ErrMessageNotFound)
+		assertMessages(t, c, []st.ConversationMessage{
+			{Id: 0, Message: "1", Role: st.ConversationRoleAgent},
+			{Id: 1, Message: "2", Role: st.ConversationRoleUser, Pinned: true, TurnId: 1},
+			{Id: 2, Message: "3", Role: st.ConversationRoleAgent, TurnId: 1},
+		})
+		messages, _, err := c.MessageRange(1, 2)
+		require.NoError(t, err)
+		assert.True(t, messages[0].Pinned)
+
+		require.NoError(t, c.SetMessagePinned(1, false))
+		messages, _, err = c.MessageRange(1, 2)
+		require.NoError(t, err)
+		assert.False(t, messages[0].Pinned)
+	})
+
 	t.Run("tracking messages overlap", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
 		t.Cleanup(cancel)
@@ -365,8 +531,8 @@ func TestMessages(t *testing.T) {
 		advanceFor(ctx, t, mClock, interval*threshold)
 		assertMessages(t, c, []st.ConversationMessage{
 			{Id: 0, Message: "1", Role: st.ConversationRoleAgent},
-			{Id: 1, Message: "2", Role: st.ConversationRoleUser},
-			{Id: 2, Message: "3", Role: st.ConversationRoleAgent},
+			{Id: 1, Message: "2", Role: st.ConversationRoleUser, TurnId: 1},
+			{Id: 2, Message: "3", Role: st.ConversationRoleAgent, TurnId: 1},
 		})
 
 		agent.setScreen("1\n3x")
@@ -376,10 +542,34 @@ func TestMessages(t *testing.T) {
 		advanceFor(ctx, t, mClock, interval*threshold)
 		assertMessages(t, c, []st.ConversationMessage{
 			{Id: 0, Message: "1", Role: st.ConversationRoleAgent},
-			{Id: 1, Message: "2", Role: st.ConversationRoleUser},
-			{Id: 2, Message: "3x", Role: st.ConversationRoleAgent},
-			{Id: 3, Message: "4", Role: st.ConversationRoleUser},
-			{Id: 4, Message: "5", Role: st.ConversationRoleAgent},
+			{Id: 1, Message: "2", Role: st.ConversationRoleUser, TurnId: 1},
+			{Id: 2, Message: "3x", Role: st.ConversationRoleAgent, TurnId: 1},
+			{Id: 3, Message: "4", Role: st.ConversationRoleUser, TurnId: 3},
+			{Id: 4, Message: "5", Role: st.ConversationRoleAgent, TurnId: 3},
+		})
+	})
+
+	t.Run("terminal input", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		t.Cleanup(cancel)
+		c, agent, mClock := newConversation(ctx, t)
+
+		// The reply to a message typed into the terminal is parsed from the
+		// screen that shows the typed message.
+		agent.setScreen("1")
+		advanceFor(ctx, t, mClock, interval*threshold)
+		c.StartTerminalInput()
+		agent.setScreen("1\n> 2")
+		advanceFor(ctx, t, mClock, interval*threshold)
+		c.RecordTerminalInput("  2 ")
+		// Input that wasn't started isn't recorded.
+		c.RecordTerminalInput("4")
+		agent.setScreen("1\n> 2\n3")
+		advanceFor(ctx, t, mClock, interval*threshold)
+		assertMessages(t, c, []st.ConversationMessage{
+			{Id: 0, Message: "1", Role: st.ConversationRoleAgent},
+			{Id: 1, Message: "2", Role: st.ConversationRoleUser, Source: st.MessageSourceTerminal, TurnId: 1},
+			{Id: 2, Message: "3", Role: st.ConversationRoleAgent, TurnId: 1},
 		})
 	})
 
@@ -402,8 +592,8 @@ func TestMessages(t *testing.T) {
 		advanceFor(ctx, t, mClock, interval*threshold)
 		assertMessages(t, c, []st.ConversationMessage{
 			{Id: 0, Message: "1 ", Role: st.ConversationRoleAgent},
-			{Id: 1, Message: "2", Role: st.ConversationRoleUser},
-			{Id: 2, Message: "x 2", Role: st.ConversationRoleAgent},
+			{Id: 1, Message: "2", Role: st.ConversationRoleUser, TurnId: 1},
+			{Id: 2, Message: "x 2", Role: st.ConversationRoleAgent, TurnId: 1},
 		})
 	})
 
@@ -619,6 +809,158 @@ func TestStatePersistence(t *testing.T) {
 		assert.NotEmpty(t, agentState.Messages)
 	})
 
+	t.Run("notes round-trip through the state file", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		t.Cleanup(cancel)
+
+		stateFile := t.TempDir() + "/state.json"
+		newConversation := func(mClock *quartz.Mock, persistence st.StatePersistenceConfig) *st.PTYConversation {
+			return st.NewPTY(ctx, st.PTYConversationConfig{
+				Clock:                  mClock,
+				SnapshotInterval:       100 * time.Millisecond,
+				ScreenStabilityLength:  200 * time.Millisecond,
+				AgentIO:                &testAgent{screen: "ready"},
+				Logger:                 slog.New(slog.NewTextHandler(io.Discard, nil)),
+				StatePersistenceConfig: persistence,
+			}, &testEmitter{})
+		}
+
+		mClock := quartz.NewMock(t)
+		c := newConversation(mClock, st.StatePersistenceConfig{StateFile: stateFile, SaveState: true})
+		c.Start(ctx)
+		advanceFor(ctx, t, mClock, 300*time.Millisecond)
+		c.SetNotes(map[string]string{"ticket": "ENG-123"})
+		require.NoError(t, c.SaveState())
+
+		mClock = quartz.NewMock(t)
+		c = newConversation(mClock, st.StatePersistenceConfig{StateFile: stateFile, LoadState: true})
+		c.Start(ctx)
+		advanceFor(ctx, t, mClock, 300*time.Millisecond)
+		assert.Equal(t, map[string]string{"ticket": "ENG-123"}, c.Notes())
+	})
+
+	t.Run("pins round-trip through the state file", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		t.Cleanup(cancel)
+
+		stateFile := t.TempDir() + "/state.json"
+		newConversation := func(mClock *quartz.Mock, persistence st.StatePersistenceConfig) *st.PTYConversation {
+			return st.NewPTY(ctx, st.PTYConversationConfig{
+				Clock:                  mClock,
+				SnapshotInterval:       100 * time.Millisecond,
+				ScreenStabilityLength:  200 * time.Millisecond,
+				AgentIO:                &testAgent{screen: "ready"},
+				Logger:                 slog.New(slog.NewTextHandler(io.Discard, nil)),
+				StatePersistenceConfig: persistence,
+			}, &testEmitter{})
+		}
+
+		mClock := quartz.NewMock(t)
+		c := newConversation(mClock, st.StatePersistenceConfig{StateFile: stateFile, SaveState: true})
+		c.Start(ctx)
+		advanceFor(ctx, t, mClock, 300*time.Millisecond)
+		require.NoError(t, c.SetMessagePinned(0, true))
+		require.NoError(t, c.SaveState())
+
+		mClock = quartz.NewMock(t)
+		c = newConversation(mClock, st.StatePersistenceConfig{StateFile: stateFile, LoadState: true})
+		c.Start(ctx)
+		advanceFor(ctx, t, mClock, 300*time.Millisecond)
+		messages := c.Messages()
+		require.Len(t, messages, 1)
+		assert.True(t, messages[0].Pinned)
+	})
+
+	t.Run("environment is saved and compared on load", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		t.Cleanup(cancel)
+
+		stateFile := t.TempDir() + "/state.json"
+		var logs strings.Builder
+		newConversation := func(mClock *quartz.Mock, persistence st.StatePersistenceConfig) *st.PTYConversation {
+			return st.NewPTY(ctx, st.PTYConversationConfig{
+				Clock:                  mClock,
+				SnapshotInterval:       100 * time.Millisecond,
+				ScreenStabilityLength:  200 * time.Millisecond,
+				AgentIO:                &testAgent{screen: "ready"},
+				Logger:                 slog.New(slog.NewTextHandler(&logs, nil)),
+				StatePersistenceConfig: persistence,
+			}, &testEmitter{})
+		}
+		saved := &st.Environment{AgentapiVersion: "0.10.0", AgentType: "claude", AgentVersion: "1.0.0", TerminalWidth: 80, TerminalHeight: 1000}
+
+		mClock := quartz.NewMock(t)
+		c := newConversation(mClock, st.StatePersistenceConfig{StateFile: stateFile, SaveState: true, Environment: saved})
+		c.Start(ctx)
+		advanceFor(ctx, t, mClock, 300*time.Millisecond)
+		require.NoError(t, c.SaveState())
+		state, err := st.ReadAgentState(stateFile)
+		require.NoError(t, err)
+		assert.Equal(t, saved, state.Environment)
+
+		current := *saved
+		current.AgentVersion = "1.1.0"
+		current.TerminalWidth = 120
+		assert.Equal(t, []string{"agent version was 1.0.0, is 1.1.0", "terminal size was 80x1000, is 120x1000"}, current.Differences(saved))
+		// Values that weren't recorded aren't compared.
+		current.AgentVersion = ""
+		current.TerminalWidth = 80
+		assert.Empty(t, current.Differences(saved))
+		assert.Empty(t, current.Differences(nil))
+
+		mClock = quartz.NewMock(t)
+		current.AgentapiVersion = "0.11.0"
+		c = newConversation(mClock, st.StatePersistenceConfig{StateFile: stateFile, LoadState: true, Environment: &current})
+		c.Start(ctx)
+		advanceFor(ctx, t, mClock, 300*time.Millisecond)
+		assert.Contains(t, logs.String(), "agentapi version was 0.10.0, is 0.11.0")
+	})
+
+	t.Run("RestoreState replaces the conversation while running", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		t.Cleanup(cancel)
+
+		stateFile := t.TempDir() + "/state.json"
+		mClock := quartz.NewMock(t)
+		c := st.NewPTY(ctx, st.PTYConversationConfig{
+			Clock:                  mClock,
+			SnapshotInterval:       100 * time.Millisecond,
+			ScreenStabilityLength:  200 * time.Millisecond,
+			AgentIO:                &testAgent{screen: "ready"},
+			Logger:                 slog.New(slog.NewTextHandler(io.Discard, nil)),
+			StatePersistenceConfig: st.StatePersistenceConfig{StateFile: stateFile, SaveState: true},
+		}, &testEmitter{})
+		c.Start(ctx)
+		advanceFor(ctx, t, mClock, 300*time.Millisecond)
+		require.Len(t, c.Messages(), 1)
+
+		require.NoError(t, c.RestoreState(st.AgentState{
+			Version: 1,
+			Messages: []st.ConversationMessage{
+				{Id: 0, Message: "agent message 1", Role: st.ConversationRoleAgent, Pinned: true},
+				{Id: 1, Message: "user message 1", Role: st.ConversationRoleUser},
+				{Id: 2, Message: "agent message 2", Role: st.ConversationRoleAgent},
+			},
+			Notes: map[string]string{"ticket": "ENG-1"},
+		}))
+		advanceFor(ctx, t, mClock, 300*time.Millisecond)
+
+		// The restored agent message isn't replaced with the screen.
+		messages := c.Messages()
+		require.Len(t, messages, 3)
+		assert.True(t, messages[0].Pinned)
+		assert.Equal(t, "agent message 2", messages[2].Message)
+		// States saved without turns get them.
+		assert.Equal(t, 1, messages[2].TurnId)
+		assert.Equal(t, map[string]string{"ticket": "ENG-1"}, c.Notes())
+
+		// The restored conversation is saved.
+		require.NoError(t, c.SaveState())
+		saved, err := st.ReadAgentState(stateFile)
+		require.NoError(t, err)
+		assert.Len(t, saved.Messages, 3)
+	})
+
 	t.Run("SaveState creates valid JSON", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
 		t.Cleanup(cancel)
@@ -1774,3 +2116,106 @@ func TestSendRejectsWhenInitialPromptNotReady(t *testing.T) {
 	err := c.Send(st.MessagePartText{Content: "hello"})
 	assert.ErrorIs(t, err, st.ErrMessageValidationChanging)
 }
+
+// toolCallEmitter records the tool calls a conversation emits.
+type toolCallEmitter struct {
+	testEmitter
+	mu        sync.Mutex
+	toolCalls []st.ToolCall
+}
+
+func (e *toolCallEmitter) EmitToolCall(toolCall st.ToolCall) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.toolCalls = append(e.toolCalls, toolCall)
+}
+
+func (e *toolCallEmitter) getToolCalls() []st.ToolCall {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]st.ToolCall(nil), e.toolCalls...)
+}
+
+func TestToolCallsEmitted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+	mClock := quartz.NewMock(t)
+	agent := &testAgent{}
+	emitter := &toolCallEmitter{}
+	c := st.NewPTY(ctx, st.PTYConversationConfig{
+		Clock:                 mClock,
+		AgentIO:               agent,
+		SnapshotInterval:      100 * time.Millisecond,
+		ScreenStabilityLength: 200 * time.Millisecond,
+		Logger:                slog.New(slog.NewTextHandler(io.Discard, nil)),
+		FormatToolCall: func(message string) (string, []string) {
+			before, call, found := strings.Cut(message, "\nREPORT ")
+			if !found {
+				return message, nil
+			}
+			return before, []string{call}
+		},
+	}, emitter)
+	c.Start(ctx)
+
+	agent.setScreen("working\nREPORT state: \"working\"")
+	advanceFor(ctx, t, mClock, 500*time.Millisecond)
+
+	assertMessages(t, c, []st.ConversationMessage{
+		{Id: 0, Message: "working", Role: st.ConversationRoleAgent},
+	})
+	// The same call is only reported once while it stays on the screen.
+	assert.Equal(t, []st.ToolCall{
+		{Name: "coder_report_task", Input: "state: \"working\"", Status: st.ToolCallStatusCompleted},
+	}, emitter.getToolCalls())
+}
+
+// BenchmarkSnapshotTick measures a snapshot tick of an 80x1000 terminal
+// with a long conversation, while the screen is stable.
+func BenchmarkSnapshotTick(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Cleanup(cancel)
+
+	messages := make([]st.ConversationMessage, 500)
+	for i := range messages {
+		role := st.ConversationRoleUser
+		if i%2 == 1 {
+			role = st.ConversationRoleAgent
+		}
+		messages[i] = st.ConversationMessage{Id: i, Role: role, Message: strings.Repeat("message ", 20)}
+	}
+	stateFile := b.TempDir() + "/state.json"
+	require.NoError(b, st.WriteAgentState(stateFile, st.AgentState{Version: 1, Messages: messages}))
+
+	var screen strings.Builder
+	for i := range 1000 {
+		fmt.Fprintf(&screen, "%-80s\n", fmt.Sprintf("line %d of the agent's output", i))
+	}
+	mClock := quartz.NewMock(b)
+	c := st.NewPTY(ctx, st.PTYConversationConfig{
+		Clock:                 mClock,
+		AgentIO:               &testAgent{screen: screen.String()},
+		SnapshotInterval:      100 * time.Millisecond,
+		ScreenStabilityLength: 200 * time.Millisecond,
+		FormatMessage: func(message string, userInput string) string {
+			return message
+		},
+		Logger:                 slog.New(slog.NewTextHandler(io.Discard, nil)),
+		StatePersistenceConfig: st.StatePersistenceConfig{StateFile: stateFile, LoadState: true},
+	}, &testEmitter{})
+	c.Start(ctx)
+	tick := func() {
+		_, w := mClock.AdvanceNext()
+		w.MustWait(ctx)
+	}
+	// Load the state and fill the snapshot buffer.
+	for range 5 {
+		tick()
+	}
+	require.Len(b, c.Messages(), 500)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		tick()
+	}
+}
@@ -0,0 +1,125 @@
+package screentracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/xerrors"
+)
+
+// WriteAgentState atomically writes state to stateFile, creating its
+// directory if needed.
+func WriteAgentState(stateFile string, state AgentState) error {
+	// Create directory if it doesn't exist
+	dir := filepath.Dir(stateFile)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return xerrors.Errorf("failed to create state directory: %w", err)
+	}
+
+	// Use atomic write: write to temp file, then rename to target path
+	tempFile := stateFile + ".tmp"
+	f, err := os.OpenFile(tempFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return xerrors.Errorf("failed to create temp state file: %w", err)
+	}
+
+	// Clean up temp file on error (before successful rename)
+	var renamed bool
+	defer func() {
+		if !renamed {
+			_ = os.Remove(tempFile)
+		}
+	}()
+
+	// Encode directly to file to avoid loading entire JSON into memory
+	encoder := json.NewEncoder(f)
+	if err := encoder.Encode(state); err != nil {
+		_ = f.Close()
+		return xerrors.Errorf("failed to encode state: %w", err)
+	}
+
+	// Flush to disk before rename for crash safety
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return xerrors.Errorf("failed to sync state file: %w", err)
+	}
+
+	// Close file before rename
+	if err := f.Close(); err != nil {
+		return xerrors.Errorf("failed to close temp state file: %w", err)
+	}
+
+	// Atomic rename
+	if err := os.Rename(tempFile, stateFile); err != nil {
+		return xerrors.Errorf("failed to rename state file: %w", err)
+	}
+	renamed = true
+	return nil
+}
+
+// ReadAgentState reads and validates a state file written by
+// WriteAgentState.
+func ReadAgentState(stateFile string) (AgentState, error) {
+	f, err := os.Open(stateFile)
+	if err != nil {
+		return AgentState{}, xerrors.Errorf("failed to open state file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var agentState AgentState
+	decoder := json.NewDecoder(f)
+	if err := decoder.Decode(&agentState); err != nil {
+		return AgentState{}, xerrors.Errorf("failed to unmarshal state (corrupted or invalid JSON): %w", err)
+	}
+
+	// Validate version
+	if agentState.Version != 1 {
+		return AgentState{}, xerrors.Errorf("unsupported state file version %d (expected 1)", agentState.Version)
+	}
+	return agentState, nil
+}
+
+// Environment records how a conversation was produced, so that a saved
+// state documents it.
+type Environment struct {
+	AgentapiVersion string `json:"agentapi_version"`
+	AgentType       string `json:"agent_type"`
+	// AgentVersion is what the agent printed for --version, if anything.
+	AgentVersion   string `json:"agent_version,omitempty"`
+	TerminalWidth  uint16 `json:"terminal_width,omitempty"`
+	TerminalHeight uint16 `json:"terminal_height,omitempty"`
+	// Flags are the server flags that were set, with secrets redacted.
+	Flags map[string]string `json:"flags,omitempty"`
+	// ProfileHash identifies the terminal profile the agent ran in, its
+	// size and environment variables.
+	ProfileHash string `json:"profile_hash,omitempty"`
+}
+
+// Differences describes how e differs from the environment a state was
+// saved in in ways that may change how the agent behaves. Flags aren't
+// compared since most of them only affect the server. Values that weren't
+// recorded in either environment aren't compared either.
+func (e *Environment) Differences(saved *Environment) []string {
+	if e == nil || saved == nil {
+		return nil
+	}
+	var differences []string
+	compare := func(what, was, is string) {
+		if was != "" && is != "" && was != is {
+			differences = append(differences, fmt.Sprintf("%s was %s, is %s", what, was, is))
+		}
+	}
+	compare("agent type", saved.AgentType, e.AgentType)
+	compare("agent version", saved.AgentVersion, e.AgentVersion)
+	compare("agentapi version", saved.AgentapiVersion, e.AgentapiVersion)
+	if saved.TerminalWidth != 0 && (saved.TerminalWidth != e.TerminalWidth || saved.TerminalHeight != e.TerminalHeight) {
+		differences = append(differences, fmt.Sprintf("terminal size was %dx%d, is %dx%d",
+			saved.TerminalWidth, saved.TerminalHeight, e.TerminalWidth, e.TerminalHeight))
+	}
+	compare("terminal profile", saved.ProfileHash, e.ProfileHash)
+	return differences
+}
@@ -0,0 +1,74 @@
+package termexec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ActiveState/vt10x"
+)
+
+// ReadScreenANSI returns the contents of the terminal window with SGR
+// escape sequences that reproduce the foreground and background colors
+// of each cell. Unlike ReadScreen, it does not wait for the screen to
+// settle, so it reflects the exact state of the emulator at call time.
+func (p *Process) ReadScreenANSI() string {
+	p.screenUpdateLock.RLock()
+	defer p.screenUpdateLock.RUnlock()
+	return renderANSI(p.xp.State)
+}
+
+func renderANSI(state *vt10x.State) string {
+	state.Lock()
+	defer state.Unlock()
+
+	rows, cols := state.Size()
+	var sb strings.Builder
+	for y := range rows {
+		fg, bg := vt10x.DefaultFG, vt10x.DefaultBG
+		for x := range cols {
+			c, cellFG, cellBG := state.Cell(x, y)
+			if c == widePlaceholder {
+				continue
+			}
+			if cellFG != fg || cellBG != bg {
+				sb.WriteString(sgr(cellFG, cellBG))
+				fg, bg = cellFG, cellBG
+			}
+			if c == 0 {
+				c = ' '
+			}
+			sb.WriteRune(c)
+		}
+		if fg != vt10x.DefaultFG || bg != vt10x.DefaultBG {
+			sb.WriteString("\x1b[0m")
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// sgr returns the escape sequence that selects the given colors.
+func sgr(fg, bg vt10x.Color) string {
+	var fgCode, bgCode string
+	switch {
+	case fg == vt10x.DefaultFG:
+		fgCode = "39"
+	case fg < 8:
+		fgCode = fmt.Sprintf("%d", 30+fg)
+	case fg < 16:
+		fgCode = fmt.Sprintf("%d", 90+fg-8)
+	default:
+		fgCode = fmt.Sprintf("38;5;%d", fg)
+	}
+	switch {
+	case bg == vt10x.DefaultBG:
+		bgCode = "49"
+	case bg < 8:
+		bgCode = fmt.Sprintf("%d", 40+bg)
+	case bg < 16:
+		bgCode = fmt.Sprintf("%d", 100+bg-8)
+	default:
+		bgCode = fmt.Sprintf("48;5;%d", bg)
+	}
+	return "\x1b[" + fgCode + ";" + bgCode + "m"
+}
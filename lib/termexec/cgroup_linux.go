@@ -0,0 +1,244 @@
+//go:build linux
+
+package termexec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coder/agentapi/lib/transport"
+	"golang.org/x/xerrors"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupServerLeaf is the cgroup the server moves itself to when its own
+// cgroup holds processes, which cgroup v2 doesn't allow for cgroups that
+// delegate controllers to their children.
+const cgroupServerLeaf = "agentapi-server"
+
+// cpuPeriod is the period cpu.max quotas are given for, in microseconds.
+const cpuPeriod = 100000
+
+// cgroup is a cgroup v2 confining the agent's process tree.
+type cgroup struct {
+	path string
+}
+
+// newCgroup creates a cgroup named name next to the server's own cgroup,
+// self, in the hierarchy mounted at root, and applies the limits to it. A
+// cgroup left behind by a previous server with the same name is reused.
+func newCgroup(root string, self string, name string, limits transport.ResourceLimits) (*cgroup, error) {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil {
+		return nil, xerrors.Errorf("resource limits require cgroup v2 mounted at %s: %w", root, err)
+	}
+	var controllers []string
+	if limits.CPU > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if limits.Memory > 0 {
+		controllers = append(controllers, "memory")
+	}
+	parent := filepath.Join(root, self)
+	if err := enableControllers(parent, controllers); err != nil {
+		return nil, err
+	}
+	cg := &cgroup{path: filepath.Join(parent, name)}
+	if err := os.Mkdir(cg.path, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, xerrors.Errorf("failed to create cgroup: %w", err)
+	}
+	if limits.CPU > 0 {
+		quota := max(int64(limits.CPU*cpuPeriod), 1000)
+		if err := cg.write("cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod)); err != nil {
+			return nil, err
+		}
+	}
+	if limits.Memory > 0 {
+		if err := cg.write("memory.max", strconv.FormatInt(limits.Memory, 10)); err != nil {
+			return nil, err
+		}
+	}
+	return cg, nil
+}
+
+// enableControllers makes the controllers available to the children of
+// the cgroup at path.
+func enableControllers(path string, controllers []string) error {
+	available, err := os.ReadFile(filepath.Join(path, "cgroup.controllers"))
+	if err != nil {
+		return xerrors.Errorf("failed to read controllers: %w", err)
+	}
+	enabled, err := os.ReadFile(filepath.Join(path, "cgroup.subtree_control"))
+	if err != nil {
+		return xerrors.Errorf("failed to read controllers: %w", err)
+	}
+	var missing []string
+	for _, controller := range controllers {
+		if !containsField(available, controller) {
+			return xerrors.Errorf("the %s controller isn't available in cgroup %s, it must be delegated to the user running agentapi", controller, path)
+		}
+		if !containsField(enabled, controller) {
+			missing = append(missing, "+"+controller)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	subtreeControl := filepath.Join(path, "cgroup.subtree_control")
+	err = os.WriteFile(subtreeControl, []byte(strings.Join(missing, " ")), 0o644)
+	if errors.Is(err, syscall.EBUSY) {
+		// Controllers can only be delegated by cgroups without processes,
+		// so the server moves out of the way.
+		leaf := filepath.Join(path, cgroupServerLeaf)
+		if err := os.Mkdir(leaf, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+			return xerrors.Errorf("failed to create cgroup for the server: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
+			return xerrors.Errorf("failed to move the server to its own cgroup: %w", err)
+		}
+		err = os.WriteFile(subtreeControl, []byte(strings.Join(missing, " ")), 0o644)
+	}
+	if err != nil {
+		return xerrors.Errorf("failed to enable %s in cgroup %s: %w", strings.Join(controllers, " and "), path, err)
+	}
+	return nil
+}
+
+func containsField(data []byte, field string) bool {
+	for _, f := range strings.Fields(string(data)) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// currentCgroup returns the cgroup v2 path of a process given the contents
+// of its /proc/<pid>/cgroup.
+func currentCgroup(procCgroup []byte) (string, error) {
+	for _, line := range strings.Split(string(procCgroup), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", xerrors.New("resource limits require cgroup v2, but the server isn't in a cgroup v2 hierarchy")
+}
+
+// newAgentCgroup creates the cgroup for the agent of a session next to the
+// server's cgroup.
+func newAgentCgroup(sessionName string, limits transport.ResourceLimits) (*cgroup, error) {
+	procCgroup, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read the server's cgroup: %w", err)
+	}
+	self, err := currentCgroup(procCgroup)
+	if err != nil {
+		return nil, err
+	}
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("agentapi-%d", os.Getpid())
+	}
+	return newCgroup(cgroupRoot, self, sessionName, limits)
+}
+
+func (c *cgroup) write(file string, value string) error {
+	if err := os.WriteFile(filepath.Join(c.path, file), []byte(value), 0o644); err != nil {
+		return xerrors.Errorf("failed to write %s: %w", file, err)
+	}
+	return nil
+}
+
+// attach makes cmd start in the cgroup, so that everything the agent
+// spawns is confined from the start. The returned function must be called
+// once the command started.
+func (c *cgroup) attach(cmd *exec.Cmd) (func(), error) {
+	dir, err := os.Open(c.path)
+	if err != nil {
+		return nil, xerrors.Errorf("failed to open cgroup: %w", err)
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+	return func() {
+		_ = dir.Close()
+	}, nil
+}
+
+// readKeyValues reads a cgroup file of "key value" lines. Files of
+// controllers that aren't enabled don't exist and read as empty.
+func (c *cgroup) readKeyValues(file string) (map[string]int64, error) {
+	data, err := os.ReadFile(filepath.Join(c.path, file))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("failed to read %s: %w", file, err)
+	}
+	values := map[string]int64{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			values[key] = n
+		}
+	}
+	return values, nil
+}
+
+func (c *cgroup) stats() (transport.ResourceStats, error) {
+	var stats transport.ResourceStats
+	cpu, err := c.readKeyValues("cpu.stat")
+	if err != nil {
+		return stats, err
+	}
+	stats.CPUThrottledPeriods = cpu["nr_throttled"]
+	stats.CPUThrottled = time.Duration(cpu["throttled_usec"]) * time.Microsecond
+	events, err := c.readKeyValues("memory.events")
+	if err != nil {
+		return stats, err
+	}
+	stats.MemoryMaxEvents = events["max"]
+	stats.OOMKills = events["oom_kill"]
+	current, err := os.ReadFile(filepath.Join(c.path, "memory.current"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return stats, xerrors.Errorf("failed to read memory.current: %w", err)
+	}
+	if err == nil {
+		stats.MemoryBytes, _ = strconv.ParseInt(strings.TrimSpace(string(current)), 10, 64)
+	}
+	return stats, nil
+}
+
+// kill kills every process left in the cgroup. cgroup.kill requires Linux
+// 5.14; on older kernels, processes that left the agent's process group
+// may survive.
+func (c *cgroup) kill() error {
+	err := c.write("cgroup.kill", "1")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// remove deletes the cgroup. It fails while processes are still exiting.
+func (c *cgroup) remove() error {
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return xerrors.Errorf("failed to remove cgroup: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package termexec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCgroupFS lays out a cgroup v2 hierarchy in a temporary directory,
+// with the server in /workspace.
+func fakeCgroupFS(t *testing.T, controllers string) string {
+	t.Helper()
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte(controllers+"\n"), 0o644))
+	self := filepath.Join(root, "workspace")
+	require.NoError(t, os.Mkdir(self, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(self, "cgroup.controllers"), []byte(controllers+"\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(self, "cgroup.subtree_control"), []byte("cpu\n"), 0o644))
+	return root
+}
+
+func TestCurrentCgroup(t *testing.T) {
+	t.Parallel()
+
+	path, err := currentCgroup([]byte("12:memory:/legacy\n0::/user.slice/session-1.scope\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "/user.slice/session-1.scope", path)
+
+	_, err = currentCgroup([]byte("4:memory:/legacy\n1:cpu:/\n"))
+	require.ErrorContains(t, err, "cgroup v2")
+}
+
+func TestNewCgroup(t *testing.T) {
+	t.Parallel()
+
+	t.Run("limits", func(t *testing.T) {
+		t.Parallel()
+		root := fakeCgroupFS(t, "cpuset cpu io memory pids")
+		cg, err := newCgroup(root, "/workspace", "agentapi-3284", transport.ResourceLimits{CPU: 1.5, Memory: 512 << 20})
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(root, "workspace", "agentapi-3284"), cg.path)
+
+		// Only the controller that isn't enabled yet is added.
+		subtreeControl, err := os.ReadFile(filepath.Join(root, "workspace", "cgroup.subtree_control"))
+		require.NoError(t, err)
+		assert.Equal(t, "+memory", string(subtreeControl))
+		cpuMax, err := os.ReadFile(filepath.Join(cg.path, "cpu.max"))
+		require.NoError(t, err)
+		assert.Equal(t, "150000 100000", string(cpuMax))
+		memoryMax, err := os.ReadFile(filepath.Join(cg.path, "memory.max"))
+		require.NoError(t, err)
+		assert.Equal(t, "536870912", string(memoryMax))
+
+		// A leftover cgroup is reused.
+		_, err = newCgroup(root, "/workspace", "agentapi-3284", transport.ResourceLimits{CPU: 1})
+		require.NoError(t, err)
+	})
+
+	t.Run("controller-not-delegated", func(t *testing.T) {
+		t.Parallel()
+		root := fakeCgroupFS(t, "cpu pids")
+		_, err := newCgroup(root, "/workspace", "agentapi-3284", transport.ResourceLimits{Memory: 512 << 20})
+		require.ErrorContains(t, err, "the memory controller isn't available")
+	})
+
+	t.Run("cgroup-v1", func(t *testing.T) {
+		t.Parallel()
+		_, err := newCgroup(t.TempDir(), "/", "agentapi-3284", transport.ResourceLimits{CPU: 1})
+		require.ErrorContains(t, err, "require cgroup v2")
+	})
+}
+
+func TestCgroupStats(t *testing.T) {
+	t.Parallel()
+
+	cg := &cgroup{path: t.TempDir()}
+	// Without the memory controller, only CPU stats are reported.
+	require.NoError(t, os.WriteFile(filepath.Join(cg.path, "cpu.stat"), []byte("usage_usec 9000000\nnr_periods 40\nnr_throttled 12\nthrottled_usec 2500000\n"), 0o644))
+	stats, err := cg.stats()
+	require.NoError(t, err)
+	assert.Equal(t, transport.ResourceStats{CPUThrottledPeriods: 12, CPUThrottled: 2500 * time.Millisecond}, stats)
+
+	require.NoError(t, os.WriteFile(filepath.Join(cg.path, "memory.current"), []byte("104857600\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(cg.path, "memory.events"), []byte("low 0\nhigh 0\nmax 7\noom 1\noom_kill 1\n"), 0o644))
+	stats, err = cg.stats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(100<<20), stats.MemoryBytes)
+	assert.Equal(t, int64(7), stats.MemoryMaxEvents)
+	assert.Equal(t, int64(1), stats.OOMKills)
+}
@@ -0,0 +1,33 @@
+//go:build !linux
+
+package termexec
+
+import (
+	"os/exec"
+
+	"github.com/coder/agentapi/lib/transport"
+	"golang.org/x/xerrors"
+)
+
+// cgroup is only supported on Linux.
+type cgroup struct{}
+
+func newAgentCgroup(_ string, _ transport.ResourceLimits) (*cgroup, error) {
+	return nil, xerrors.New("resource limits are only supported on Linux")
+}
+
+func (*cgroup) attach(_ *exec.Cmd) (func(), error) {
+	return func() {}, nil
+}
+
+func (*cgroup) stats() (transport.ResourceStats, error) {
+	return transport.ResourceStats{}, nil
+}
+
+func (*cgroup) kill() error {
+	return nil
+}
+
+func (*cgroup) remove() error {
+	return nil
+}
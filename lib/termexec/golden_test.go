@@ -0,0 +1,345 @@
+package termexec
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ActiveState/vt10x"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/transport"
+	"github.com/coder/quartz"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden transcripts of testdata/golden with the replayed messages")
+
+// replayTimeout bounds how long, in the time of the recording, the replay
+// waits for the conversation, e.g. to become stable before a user message.
+const replayTimeout = 10 * time.Minute
+
+// goldenMessage is a message of a golden transcript. Times differ between
+// replays, so they're left out.
+type goldenMessage struct {
+	Role    st.ConversationRole `json:"role"`
+	Message string              `json:"message"`
+}
+
+// TestGoldenSessions replays the asciinema recordings in
+// testdata/golden/<agent type>/<name>.cast through the PTY transport's
+// message pipeline and compares the messages with <name>.golden.json, so
+// that changes to screentracker or msgfmt that alter the messages of
+// recorded sessions fail CI.
+//
+// Record a session with keyboard input:
+//
+//	asciinema rec --stdin --cols 80 --rows 1000 -c claude session.cast
+//
+// and write its golden transcript, or update the transcripts after an
+// intended change, with:
+//
+//	go test ./lib/termexec -run TestGoldenSessions -update
+func TestGoldenSessions(t *testing.T) {
+	t.Parallel()
+
+	casts, err := filepath.Glob(filepath.Join("testdata", "golden", "*", "*.cast"))
+	require.NoError(t, err)
+	require.NotEmpty(t, casts, "No recordings in testdata/golden")
+	for _, castPath := range casts {
+		agentType := mf.AgentType(filepath.Base(filepath.Dir(castPath)))
+		name := strings.TrimSuffix(filepath.Base(castPath), ".cast")
+		t.Run(string(agentType)+"/"+name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			t.Cleanup(cancel)
+
+			recording, err := readCast(castPath)
+			require.NoError(t, err)
+			messages := replayCast(ctx, t, agentType, recording)
+
+			goldenPath := strings.TrimSuffix(castPath, ".cast") + ".golden.json"
+			if *updateGolden {
+				data, err := json.MarshalIndent(messages, "", "  ")
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(goldenPath, append(data, '\n'), 0o644))
+				return
+			}
+			data, err := os.ReadFile(goldenPath)
+			require.NoError(t, err, "Run the test with -update to create the golden transcript")
+			var golden []goldenMessage
+			require.NoError(t, json.Unmarshal(data, &golden))
+			require.Equal(t, golden, messages, "The messages of the session changed. If that's intended, run the test with -update")
+		})
+	}
+}
+
+// castRecording is an asciicast v2 recording.
+type castRecording struct {
+	width, height int
+	events        []castEvent
+}
+
+// castEvent is an event of a recording: terminal output ("o"), keyboard
+// input ("i"), a resize ("r") or a marker ("m").
+type castEvent struct {
+	time float64
+	code string
+	data string
+}
+
+func readCast(path string) (castRecording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return castRecording{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	if !scanner.Scan() {
+		return castRecording{}, xerrors.Errorf("missing header: %w", scanner.Err())
+	}
+	var header struct {
+		Version int `json:"version"`
+		Width   int `json:"width"`
+		Height  int `json:"height"`
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return castRecording{}, xerrors.Errorf("failed to parse header: %w", err)
+	}
+	if header.Version != 2 {
+		return castRecording{}, xerrors.Errorf("unsupported asciicast version %d", header.Version)
+	}
+	recording := castRecording{width: header.Width, height: header.Height}
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var fields []any
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			return castRecording{}, xerrors.Errorf("failed to parse event %q: %w", scanner.Text(), err)
+		}
+		if len(fields) != 3 {
+			return castRecording{}, xerrors.Errorf("invalid event %q", scanner.Text())
+		}
+		eventTime, _ := fields[0].(float64)
+		code, _ := fields[1].(string)
+		data, _ := fields[2].(string)
+		recording.events = append(recording.events, castEvent{time: eventTime, code: code, data: data})
+	}
+	return recording, scanner.Err()
+}
+
+// typedMessage returns the message typed with the keyboard input, without
+// the final carriage return and bracketed paste markers, and with
+// backspaces applied.
+func typedMessage(input string) string {
+	input = strings.NewReplacer("\x1b[200~", "", "\x1b[201~", "", "\r", "").Replace(input)
+	var message []rune
+	for _, r := range input {
+		if r == '\x7f' || r == '\b' {
+			if len(message) > 0 {
+				message = message[:len(message)-1]
+			}
+			continue
+		}
+		message = append(message, r)
+	}
+	return mf.TrimWhitespace(string(message))
+}
+
+// replayAgent plays a recording as the agent. Output is replayed as it was
+// recorded, except that the echo of a user message is only shown once the
+// conversation writes the message, and the agent's response once it sends
+// the carriage return, the way a live agent would react.
+type replayAgent struct {
+	mu    sync.Mutex
+	vt    *vt10x.VT
+	state *vt10x.State
+	// echo is the output of the message being typed, shown on the next
+	// write, and entered is closed when the carriage return is written.
+	echo    []string
+	entered chan struct{}
+}
+
+func (a *replayAgent) ReadScreen() string {
+	return string(appendScreenText(nil, a.state))
+}
+
+func (a *replayAgent) output(data string) {
+	_, _ = a.vt.Write([]byte(data))
+}
+
+func (a *replayAgent) Write(data []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if strings.Contains(string(data), "\r") {
+		if a.entered != nil {
+			close(a.entered)
+			a.entered = nil
+		}
+		return len(data), nil
+	}
+	for _, out := range a.echo {
+		a.output(out)
+	}
+	a.echo = nil
+	return len(data), nil
+}
+
+// expectMessage prepares the agent for a user message and returns a channel
+// closed once it's entered.
+func (a *replayAgent) expectMessage(echo []string) chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.echo = echo
+	a.entered = make(chan struct{})
+	return a.entered
+}
+
+type discardEmitter struct{}
+
+func (discardEmitter) EmitMessages([]st.ConversationMessage) {}
+func (discardEmitter) EmitStatus(st.ConversationStatus)      {}
+func (discardEmitter) EmitScreen(string)                     {}
+func (discardEmitter) EmitError(string, st.ErrorLevel)       {}
+
+// replayCast replays a recording through the message pipeline of the PTY
+// transport on a mock clock and returns the resulting messages. Keyboard
+// input up to a carriage return is sent as a user message once the
+// conversation is stable.
+func replayCast(ctx context.Context, t *testing.T, agentType mf.AgentType, recording castRecording) []goldenMessage {
+	t.Helper()
+	state := &vt10x.State{}
+	vt, err := vt10x.New(state, strings.NewReader(""), io.Discard)
+	require.NoError(t, err)
+	vt.Resize(recording.width, recording.height)
+	agent := &replayAgent{vt: vt, state: state}
+
+	mClock := quartz.NewMock(t)
+	conversation, err := ptyTransport{}.NewConversation(ctx, transport.ConversationConfig{
+		AgentType: agentType,
+		AgentIO:   agent,
+		Emitter:   discardEmitter{},
+		Clock:     mClock,
+		Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	require.NoError(t, err)
+	conversation.Start(ctx)
+
+	advanceUntil := func(what string, done func() bool) {
+		t.Helper()
+		deadline := mClock.Now().Add(replayTimeout)
+		for !done() {
+			require.True(t, mClock.Now().Before(deadline), "Timed out waiting for %s", what)
+			_, w := mClock.AdvanceNext()
+			w.MustWait(ctx)
+			// The clock and the snapshot loop hand off to each other, which
+			// can keep the send loop from running on a single CPU.
+			runtime.Gosched()
+		}
+	}
+	start := mClock.Now()
+	advanceTo := func(eventTime float64) {
+		target := start.Add(time.Duration(eventTime * float64(time.Second)))
+		advanceUntil("the recording", func() bool { return !mClock.Now().Before(target) })
+	}
+
+	sendErrs := make(chan error, len(recording.events))
+	sends := 0
+	events := recording.events
+	for i := 0; i < len(events); i++ {
+		event := events[i]
+		switch event.code {
+		case "o":
+			advanceTo(event.time)
+			agent.output(event.data)
+		case "r":
+			var cols, rows int
+			_, err := fmt.Sscanf(event.data, "%dx%d", &cols, &rows)
+			require.NoError(t, err, "Invalid resize event %q", event.data)
+			vt.Resize(cols, rows)
+		case "i":
+			// Collect the message up to the carriage return, along with
+			// its echo.
+			var input strings.Builder
+			var echo []string
+			for ; i < len(events); i++ {
+				if events[i].code == "o" {
+					echo = append(echo, events[i].data)
+					continue
+				}
+				if events[i].code != "i" {
+					continue
+				}
+				input.WriteString(events[i].data)
+				if strings.Contains(events[i].data, "\r") {
+					break
+				}
+			}
+			message := typedMessage(input.String())
+			if message == "" {
+				for _, out := range echo {
+					agent.output(out)
+				}
+				continue
+			}
+			advanceUntil("the agent to be stable", func() bool {
+				return conversation.Status() == st.ConversationStatusStable
+			})
+			entered := agent.expectMessage(echo)
+			sends++
+			go func() {
+				sendErrs <- conversation.Send(st.MessagePartText{Content: message})
+			}()
+			advanceUntil("the message to be entered", func() bool {
+				select {
+				case <-entered:
+					return true
+				default:
+					return false
+				}
+			})
+			// The response follows from the time the message was entered
+			// in the recording.
+			if i < len(events) {
+				start = mClock.Now().Add(-time.Duration(events[i].time * float64(time.Second)))
+			}
+		}
+	}
+
+	advanceUntil("the messages to be sent", func() bool {
+		for sends > 0 {
+			select {
+			case err := <-sendErrs:
+				require.NoError(t, err)
+				sends--
+			default:
+				return false
+			}
+		}
+		return true
+	})
+	advanceUntil("the agent to be stable", func() bool {
+		return conversation.Status() == st.ConversationStatusStable
+	})
+
+	var messages []goldenMessage
+	for _, msg := range conversation.Messages() {
+		messages = append(messages, goldenMessage{Role: msg.Role, Message: msg.Message})
+	}
+	return messages
+}
@@ -0,0 +1,5 @@
+//go:build !race
+
+package termexec
+
+const raceEnabled = false
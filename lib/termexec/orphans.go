@@ -0,0 +1,9 @@
+package termexec
+
+const (
+	// sessionEnv and serverPidEnv are set in the environment of agents, and
+	// so inherited by the processes they spawn, to find these processes
+	// again if the server dies without closing the agent.
+	sessionEnv   = "AGENTAPI_SESSION"
+	serverPidEnv = "AGENTAPI_SERVER_PID"
+)
@@ -0,0 +1,74 @@
+//go:build linux
+
+package termexec
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/xerrors"
+)
+
+// SweepOrphans kills the processes left behind by a previous server of
+// the same session that exited without closing its agent, for instance
+// because it was killed with SIGKILL. They are recognized by the
+// environment agents are started with: the session name, and the PID of a
+// server that is no longer running. It returns how many were killed.
+func SweepOrphans(sessionName string) (int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, xerrors.Errorf("failed to list processes: %w", err)
+	}
+	killed := 0
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		// Processes of other users can't be read, and they can't be ours.
+		environ, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "environ"))
+		if err != nil {
+			continue
+		}
+		if !isOrphan(environ, sessionName) {
+			continue
+		}
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return killed, xerrors.Errorf("failed to kill orphaned process %d: %w", pid, err)
+		}
+		killed++
+	}
+	return killed, nil
+}
+
+// isOrphan reports whether a process environment, as in
+// /proc/<pid>/environ, belongs to an agent of the session whose server is
+// gone.
+func isOrphan(environ []byte, sessionName string) bool {
+	var session, serverPid string
+	for _, v := range bytes.Split(environ, []byte{0}) {
+		key, value, ok := bytes.Cut(v, []byte("="))
+		if !ok {
+			continue
+		}
+		switch string(key) {
+		case sessionEnv:
+			session = string(value)
+		case serverPidEnv:
+			serverPid = string(value)
+		}
+	}
+	if session != sessionName {
+		return false
+	}
+	pid, err := strconv.Atoi(serverPid)
+	if err != nil || pid == os.Getpid() {
+		return false
+	}
+	err = syscall.Kill(pid, 0)
+	return errors.Is(err, syscall.ESRCH)
+}
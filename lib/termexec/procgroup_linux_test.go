@@ -0,0 +1,84 @@
+package termexec
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/logctx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// running reports whether a process is running, not counting zombies,
+// which nothing may reap in containers.
+func running(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestCloseKillsProcessGroup(t *testing.T) {
+	logger := slog.New(logctx.DiscardHandler)
+	ctx := logctx.WithLogger(context.Background(), logger)
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	// Background jobs of non-interactive shells ignore SIGINT, and the sleep
+	// also ignores the SIGHUP sent when the shell exits, so it survives the
+	// shell like the children of an agent often do.
+	p, err := StartProcess(ctx, StartProcessConfig{
+		Program:        "sh",
+		Args:           []string{"-c", fmt.Sprintf("(trap '' HUP; exec sleep 60) & echo $! > %s; echo started; wait", pidFile)},
+		TerminalWidth:  80,
+		TerminalHeight: 10,
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return strings.Contains(p.ReadScreen(), "started")
+	}, 5*time.Second, 10*time.Millisecond)
+	data, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	childPid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	require.NoError(t, err)
+	require.True(t, running(childPid))
+
+	require.NoError(t, p.Close(logger, 5*time.Second))
+	assert.Eventually(t, func() bool { return !running(childPid) }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSweepOrphans(t *testing.T) {
+	// The PID of a process that has exited stands in for a dead server.
+	dead := exec.Command("true")
+	require.NoError(t, dead.Run())
+	sessionName := fmt.Sprintf("test-%d", os.Getpid())
+
+	orphan := exec.Command("sleep", "60")
+	orphan.Env = append(os.Environ(), sessionEnv+"="+sessionName, fmt.Sprintf("%s=%d", serverPidEnv, dead.Process.Pid))
+	require.NoError(t, orphan.Start())
+	// Processes of a running server are kept.
+	live := exec.Command("sleep", "60")
+	live.Env = append(os.Environ(), sessionEnv+"="+sessionName, fmt.Sprintf("%s=%d", serverPidEnv, os.Getppid()))
+	require.NoError(t, live.Start())
+	t.Cleanup(func() {
+		_ = live.Process.Kill()
+		_ = live.Wait()
+	})
+
+	killed, err := SweepOrphans(sessionName)
+	require.NoError(t, err)
+	assert.Equal(t, 1, killed)
+	assert.ErrorContains(t, orphan.Wait(), "killed")
+
+	killed, err = SweepOrphans(sessionName)
+	require.NoError(t, err)
+	assert.Equal(t, 0, killed)
+}
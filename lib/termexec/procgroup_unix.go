@@ -0,0 +1,26 @@
+//go:build unix
+
+package termexec
+
+import (
+	"errors"
+	"syscall"
+)
+
+// signalProcessGroup sends sig to the process group led by pid. Agents are
+// started in their own session, so their group holds the processes they
+// spawn, unless these moved to a group of their own.
+func signalProcessGroup(pid int, sig syscall.Signal) error {
+	err := syscall.Kill(-pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// processGroupAlive reports whether any process of the group led by pid is
+// still running.
+func processGroupAlive(pid int) bool {
+	err := syscall.Kill(-pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package termexec
+
+import "syscall"
+
+// signalProcessGroup is a no-op on Windows, which has no process groups.
+// The pseudo console closes the agent's console applications instead.
+func signalProcessGroup(_ int, _ syscall.Signal) error {
+	return nil
+}
+
+func processGroupAlive(_ int) bool {
+	return false
+}
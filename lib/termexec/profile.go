@@ -0,0 +1,62 @@
+package termexec
+
+import (
+	"maps"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+)
+
+// Profile is the terminal an agent runs in.
+type Profile struct {
+	Width  uint16
+	Height uint16
+	// Env is added to the agent's environment, overriding the server's.
+	Env map[string]string
+}
+
+// defaultProfile suits agents that print their output as a log: the tall
+// terminal keeps their whole conversation on the screen.
+var defaultProfile = Profile{
+	Width:  80,
+	Height: 1000,
+	// vt100 is the terminal type that the vt10x library emulates. Setting
+	// this signals to the process that it should only use compatible
+	// escape sequences.
+	Env: map[string]string{"TERM": "vt100"},
+}
+
+// agentProfiles override defaultProfile for agents that don't work well in
+// it.
+var agentProfiles = map[mf.AgentType]Profile{
+	// opencode is a full-screen TUI that stretches its layout over a tall
+	// terminal and needs 256 colors to draw its borders.
+	mf.AgentTypeOpencode: {
+		Width:  120,
+		Height: 50,
+		Env:    map[string]string{"TERM": "xterm-256color"},
+	},
+	// cursor-agent pages long output in a tall terminal.
+	mf.AgentTypeCursor: {Width: 100, Height: 200},
+}
+
+// AgentProfile returns the terminal the agent runs in unless the size or
+// environment are overridden.
+func AgentProfile(agentType mf.AgentType) Profile {
+	profile := Profile{
+		Width:  defaultProfile.Width,
+		Height: defaultProfile.Height,
+		Env:    maps.Clone(defaultProfile.Env),
+	}
+	override, ok := agentProfiles[agentType]
+	if !ok {
+		return profile
+	}
+	if override.Width != 0 {
+		profile.Width = override.Width
+	}
+	if override.Height != 0 {
+		profile.Height = override.Height
+	}
+	maps.Copy(profile.Env, override.Env)
+	return profile
+}
@@ -0,0 +1,45 @@
+package termexec
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/logctx"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentProfile(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, Profile{Width: 80, Height: 1000, Env: map[string]string{"TERM": "vt100"}}, AgentProfile(mf.AgentTypeClaude))
+	assert.Equal(t, Profile{Width: 120, Height: 50, Env: map[string]string{"TERM": "xterm-256color"}}, AgentProfile(mf.AgentTypeOpencode))
+	// Agents override only what they need to.
+	assert.Equal(t, Profile{Width: 100, Height: 200, Env: map[string]string{"TERM": "vt100"}}, AgentProfile(mf.AgentTypeCursor))
+
+	// Profiles can be changed without changing the defaults.
+	profile := AgentProfile(mf.AgentTypeCustom)
+	profile.Env["TERM"] = "dumb"
+	assert.Equal(t, "vt100", AgentProfile(mf.AgentTypeCustom).Env["TERM"])
+}
+
+func TestStartProcessEnv(t *testing.T) {
+	ctx := logctx.WithLogger(context.Background(), slog.New(logctx.DiscardHandler))
+	p, err := StartProcess(ctx, StartProcessConfig{
+		Program:        "sh",
+		Args:           []string{"-c", `echo "$TERM $COLORTERM"; sleep 5`},
+		TerminalWidth:  80,
+		TerminalHeight: 10,
+		Env:            map[string]string{"COLORTERM": "truecolor"},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close(slog.New(logctx.DiscardHandler), time.Second) })
+
+	require.Eventually(t, func() bool {
+		return strings.HasPrefix(p.ReadScreen(), "vt100 truecolor")
+	}, 5*time.Second, 10*time.Millisecond)
+}
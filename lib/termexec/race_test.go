@@ -0,0 +1,7 @@
+//go:build race
+
+package termexec
+
+// raceEnabled is set when tests run with the race detector, under which
+// sync.Pool randomly drops items, so allocations can't be counted.
+const raceEnabled = true
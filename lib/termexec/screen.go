@@ -0,0 +1,59 @@
+package termexec
+
+import (
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ActiveState/vt10x"
+)
+
+// screenBufPool holds the buffers the screen is rendered into. The screen
+// is read every snapshot interval, and an 80x1000 terminal takes 80KB.
+var screenBufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
+
+// appendScreenText appends the contents of the terminal, without the wide
+// character placeholders, to buf. It matches state.String(), which builds
+// the screen as a slice of runes and then copies it into a string.
+func appendScreenText(buf []byte, state *vt10x.State) []byte {
+	state.Lock()
+	defer state.Unlock()
+	rows, cols := state.Size()
+	for y := range rows {
+		for x := range cols {
+			c, _, _ := state.Cell(x, y)
+			if c != widePlaceholder {
+				buf = utf8.AppendRune(buf, c)
+			}
+		}
+		buf = append(buf, '\n')
+	}
+	return buf
+}
+
+// screenText returns the contents of the terminal.
+func (p *Process) screenText() string {
+	return p.renderScreen(p.xp.State)
+}
+
+// renderScreen renders the terminal into a pooled buffer. While the screen
+// doesn't change, the string returned last time is reused, so polling a
+// stable screen doesn't allocate.
+func (p *Process) renderScreen(state *vt10x.State) string {
+	bufp := screenBufPool.Get().(*[]byte)
+	buf := appendScreenText((*bufp)[:0], state)
+	screen := p.lastScreen.Load()
+	// The conversion doesn't allocate when it's only compared.
+	if screen == nil || *screen != string(buf) {
+		text := string(buf)
+		screen = &text
+		p.lastScreen.Store(screen)
+	}
+	*bufp = buf
+	screenBufPool.Put(bufp)
+	return *screen
+}
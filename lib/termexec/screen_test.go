@@ -0,0 +1,62 @@
+package termexec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ActiveState/vt10x"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newScreenState returns an 80x1000 terminal filled with text, including
+// wide characters.
+func newScreenState(t testing.TB) *vt10x.State {
+	t.Helper()
+	var content strings.Builder
+	for i := range 999 {
+		fmt.Fprintf(&content, "line %d: 字%c │ some agent output\r\n", i, widePlaceholder)
+	}
+	state := &vt10x.State{}
+	state.WriteString(content.String(), 80, 1000)
+	rows, cols := state.Size()
+	require.Equal(t, 1000, rows)
+	require.Equal(t, 80, cols)
+	return state
+}
+
+func TestScreenText(t *testing.T) {
+	state := newScreenState(t)
+	expected := strings.ReplaceAll(state.String(), string(widePlaceholder), "")
+	assert.Equal(t, expected, string(appendScreenText(nil, state)))
+
+	p := &Process{}
+	first := p.renderScreen(state)
+	assert.Equal(t, expected, first)
+	if raceEnabled {
+		t.Skip("allocations aren't counted with the race detector")
+	}
+	// A stable screen reuses the string returned last time.
+	allocs := testing.AllocsPerRun(10, func() {
+		_ = p.renderScreen(state)
+	})
+	assert.Zero(t, allocs)
+}
+
+func BenchmarkScreenText(b *testing.B) {
+	state := newScreenState(b)
+	b.Run("String", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			_ = strings.ReplaceAll(state.String(), string(widePlaceholder), "")
+		}
+	})
+	b.Run("Pooled", func(b *testing.B) {
+		p := &Process{}
+		b.ReportAllocs()
+		for b.Loop() {
+			_ = p.renderScreen(state)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package termexec
+
+import (
+	"slices"
+	"strings"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+)
+
+const (
+	// maxOSCLength and maxHyperlinkTextLength bound the memory used by
+	// unterminated sequences.
+	maxOSCLength           = 4096
+	maxHyperlinkTextLength = 1024
+	// maxHyperlinks is the number of recent hyperlinks kept.
+	maxHyperlinks = 256
+)
+
+// outputParser follows the escape sequences in the agent's output to find
+// what the terminal emulator doesn't keep: BEL characters, and OSC 8
+// hyperlinks. BEL also terminates OSC strings, such as the one setting the
+// window title, so the parser has to tell the two apart.
+type outputParser struct {
+	state outputState
+	// isOSC is set while parsing an OSC string, as opposed to the other
+	// string sequences, whose content is ignored.
+	isOSC bool
+	osc   strings.Builder
+	// linkURL is the target of the open hyperlink, if any, and linkText the
+	// text written since it was opened.
+	linkURL  string
+	linkText strings.Builder
+}
+
+type outputState int
+
+const (
+	outputStateGround outputState = iota
+	// outputStateEscape follows an ESC.
+	outputStateEscape
+	// outputStateCSI is inside a control sequence.
+	outputStateCSI
+	// outputStateString is inside an OSC, DCS, SOS, PM or APC string.
+	outputStateString
+	// outputStateStringEscape follows an ESC inside a string, which starts
+	// the ST terminator.
+	outputStateStringEscape
+)
+
+// feed advances the parser by one rune. It reports whether the rune rang
+// the bell, and returns the hyperlink it closed, if any.
+func (p *outputParser) feed(r rune) (bell bool, link *mf.Hyperlink) {
+	switch p.state {
+	case outputStateGround:
+		switch {
+		case r == '\a':
+			return true, nil
+		case r == '\x1b':
+			p.state = outputStateEscape
+		case p.linkURL != "" && r >= ' ' && r != 0x7f && p.linkText.Len() < maxHyperlinkTextLength:
+			p.linkText.WriteRune(r)
+		}
+	case outputStateEscape:
+		switch r {
+		case '[':
+			p.state = outputStateCSI
+		case ']', 'P', 'X', '^', '_':
+			p.state = outputStateString
+			p.isOSC = r == ']'
+			p.osc.Reset()
+		case '\x1b':
+		default:
+			p.state = outputStateGround
+		}
+	case outputStateCSI:
+		if r >= 0x40 && r <= 0x7e {
+			p.state = outputStateGround
+		}
+	case outputStateString:
+		switch {
+		case r == '\a':
+			return false, p.endString()
+		case r == '\x1b':
+			p.state = outputStateStringEscape
+		case p.isOSC && p.osc.Len() < maxOSCLength:
+			p.osc.WriteRune(r)
+		}
+	case outputStateStringEscape:
+		if r == '\\' {
+			return false, p.endString()
+		}
+		p.state = outputStateString
+	}
+	return false, nil
+}
+
+// endString handles a terminated string sequence. OSC 8 sequences open a
+// hyperlink (ESC ] 8 ; params ; URI ST) or close it (ESC ] 8 ; ; ST).
+func (p *outputParser) endString() *mf.Hyperlink {
+	p.state = outputStateGround
+	if !p.isOSC {
+		return nil
+	}
+	payload, ok := strings.CutPrefix(p.osc.String(), "8;")
+	if !ok {
+		return nil
+	}
+	_, uri, ok := strings.Cut(payload, ";")
+	if !ok {
+		return nil
+	}
+
+	var closed *mf.Hyperlink
+	if p.linkURL != "" && p.linkText.Len() > 0 {
+		closed = &mf.Hyperlink{Text: p.linkText.String(), URL: p.linkURL}
+	}
+	p.linkURL = uri
+	p.linkText.Reset()
+	return closed
+}
+
+// printing reports whether the next rune is displayed, rather than being
+// part of an escape sequence.
+func (p *outputParser) printing() bool {
+	return p.state == outputStateGround
+}
+
+// Title returns the window title last set by the process with an OSC 0 or
+// OSC 2 escape sequence.
+func (p *Process) Title() string {
+	p.screenUpdateLock.RLock()
+	defer p.screenUpdateLock.RUnlock()
+	return p.xp.State.Title()
+}
+
+// OnBell sets a function called whenever the process rings the terminal
+// bell. It is called from the goroutine reading the terminal, so it must
+// not block.
+func (p *Process) OnBell(f func()) {
+	p.signalLock.Lock()
+	defer p.signalLock.Unlock()
+	p.bell = f
+}
+
+// Hyperlinks returns the most recent OSC 8 hyperlinks written by the
+// process, oldest first. The terminal emulator only keeps their text.
+func (p *Process) Hyperlinks() []mf.Hyperlink {
+	p.signalLock.Lock()
+	defer p.signalLock.Unlock()
+	return append([]mf.Hyperlink(nil), p.hyperlinks...)
+}
+
+func (p *Process) ringBell() {
+	p.signalLock.Lock()
+	f := p.bell
+	p.signalLock.Unlock()
+	if f != nil {
+		f()
+	}
+}
+
+func (p *Process) addHyperlink(link mf.Hyperlink) {
+	p.signalLock.Lock()
+	defer p.signalLock.Unlock()
+	// Agents redraw the screen, so the same link is often written again.
+	p.hyperlinks = slices.DeleteFunc(p.hyperlinks, func(h mf.Hyperlink) bool { return h == link })
+	p.hyperlinks = append(p.hyperlinks, link)
+	if len(p.hyperlinks) > maxHyperlinks {
+		p.hyperlinks = p.hyperlinks[len(p.hyperlinks)-maxHyperlinks:]
+	}
+}
@@ -0,0 +1,40 @@
+package termexec
+
+import (
+	"testing"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputParser(t *testing.T) {
+	var p outputParser
+	var bells int
+	var links []mf.Hyperlink
+	feed := func(s string) {
+		for _, r := range s {
+			bell, link := p.feed(r)
+			if bell {
+				bells++
+			}
+			if link != nil {
+				links = append(links, *link)
+			}
+		}
+	}
+
+	// Titles terminated with BEL or ST don't ring the bell.
+	feed("\x1b]0;claude\a\x1b]2;✳ Working\x1b\\")
+	assert.Equal(t, 0, bells)
+	feed("Done\a\n")
+	assert.Equal(t, 1, bells)
+
+	// Styling inside a hyperlink is not part of its text.
+	feed("See \x1b]8;id=1;https://example.com/pr/1\x1b\\\x1b[1mPR 1\x1b[0m\x1b]8;;\x1b\\ and ")
+	feed("\x1b]8;;file:///src/main.go\amain.go\x1b]8;;\a.")
+	assert.Equal(t, []mf.Hyperlink{
+		{Text: "PR 1", URL: "https://example.com/pr/1"},
+		{Text: "main.go", URL: "file:///src/main.go"},
+	}, links)
+	assert.Equal(t, 1, bells)
+}
@@ -3,16 +3,22 @@ package termexec
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"os/exec"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/ActiveState/termtest/xpty"
 	"github.com/coder/agentapi/lib/logctx"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	"github.com/coder/agentapi/lib/transport"
 	"github.com/coder/agentapi/lib/util"
 	"github.com/coder/quartz"
 	"golang.org/x/xerrors"
@@ -24,6 +30,13 @@ type Process struct {
 	screenUpdateLock sync.RWMutex
 	lastScreenUpdate time.Time
 	clock            quartz.Clock
+	signalLock       sync.Mutex
+	bell             func()
+	hyperlinks       []mf.Hyperlink
+	// cgroup confines the agent's process tree when it runs with limits.
+	cgroup *cgroup
+	// lastScreen is the screen last returned by ReadScreen.
+	lastScreen atomic.Pointer[string]
 }
 
 type StartProcessConfig struct {
@@ -32,8 +45,20 @@ type StartProcessConfig struct {
 	TerminalWidth  uint16
 	TerminalHeight uint16
 	Clock          quartz.Clock
+	// SessionName marks the agent's processes, so that SweepOrphans finds
+	// them if the server dies without closing the agent.
+	SessionName string
+	// Limits confines the agent's process tree to a cgroup. It requires
+	// Linux with cgroup v2.
+	Limits transport.ResourceLimits
+	// Env is added to the agent's environment. TERM defaults to vt100.
+	Env map[string]string
 }
 
+// processGroupGracePeriod is how long the agent's remaining processes have
+// to exit after SIGTERM before they are killed.
+const processGroupGracePeriod = 2 * time.Second
+
 func StartProcess(ctx context.Context, args StartProcessConfig) (*Process, error) {
 	logger := logctx.From(ctx)
 	clock := args.Clock
@@ -45,15 +70,33 @@ func StartProcess(ctx context.Context, args StartProcessConfig) (*Process, error
 		return nil, err
 	}
 	execCmd := exec.Command(args.Program, args.Args...)
-	// vt100 is the terminal type that the vt10x library emulates.
-	// Setting this signals to the process that it should only use compatible
-	// escape sequences.
-	execCmd.Env = append(os.Environ(), "TERM=vt100")
+	env := maps.Clone(defaultProfile.Env)
+	maps.Copy(env, args.Env)
+	execCmd.Env = os.Environ()
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		execCmd.Env = append(execCmd.Env, key+"="+env[key])
+	}
+	if args.SessionName != "" {
+		execCmd.Env = append(execCmd.Env, sessionEnv+"="+args.SessionName, fmt.Sprintf("%s=%d", serverPidEnv, os.Getpid()))
+	}
+	var cg *cgroup
+	if !args.Limits.IsZero() {
+		if cg, err = newAgentCgroup(args.SessionName, args.Limits); err != nil {
+			_ = xp.Close()
+			return nil, xerrors.Errorf("failed to set up resource limits: %w", err)
+		}
+		done, err := cg.attach(execCmd)
+		if err != nil {
+			_ = xp.Close()
+			return nil, xerrors.Errorf("failed to set up resource limits: %w", err)
+		}
+		defer done()
+	}
 	if err := xp.StartProcessInTerminal(execCmd); err != nil {
 		return nil, err
 	}
 
-	process := &Process{xp: xp, execCmd: execCmd, clock: clock}
+	process := &Process{xp: xp, execCmd: execCmd, clock: clock, cgroup: cg}
 
 	go func() {
 		// HACK: Working around xpty concurrency limitations
@@ -82,6 +125,7 @@ func StartProcess(ctx context.Context, args StartProcessConfig) (*Process, error
 		// Warning: This depends on xpty internals and may break if xpty changes.
 		// A proper fix would require forking xpty or getting upstream changes.
 		pp := util.GetUnexportedField(xp, "pp").(*xpty.PassthroughPipe)
+		var parser outputParser
 		for {
 			r, _, err := pp.ReadRune()
 			if err != nil {
@@ -93,12 +137,35 @@ func StartProcess(ctx context.Context, args StartProcessConfig) (*Process, error
 				// unresponsive.
 				return
 			}
+			wide := parser.printing() && mf.RuneWidth(r) == 2
 			process.screenUpdateLock.Lock()
+			if wide {
+				// Like terminals, wrap wide characters that don't fit on
+				// the line rather than splitting them. The size is read
+				// each time, since the terminal may have been resized.
+				xp.State.Lock()
+				_, cols := xp.State.Size()
+				x, _ := xp.State.Cursor()
+				xp.State.Unlock()
+				if x == cols-1 {
+					xp.Term.WriteRune(widePlaceholder)
+				}
+			}
 			// writing to the terminal updates its state. without it,
 			// xp.State will always return an empty string
 			xp.Term.WriteRune(r)
+			if wide {
+				xp.Term.WriteRune(widePlaceholder)
+			}
 			process.lastScreenUpdate = clock.Now()
 			process.screenUpdateLock.Unlock()
+			bell, link := parser.feed(r)
+			if bell {
+				process.ringBell()
+			}
+			if link != nil {
+				process.addHyperlink(*link)
+			}
 		}
 	}()
 
@@ -122,7 +189,7 @@ func (p *Process) ReadScreen() string {
 	for range 3 {
 		p.screenUpdateLock.RLock()
 		if p.clock.Since(p.lastScreenUpdate) >= 16*time.Millisecond {
-			state := p.xp.State.String()
+			state := p.screenText()
 			p.screenUpdateLock.RUnlock()
 			return state
 		}
@@ -131,7 +198,7 @@ func (p *Process) ReadScreen() string {
 		<-t.C
 		t.Stop()
 	}
-	return p.xp.State.String()
+	return p.screenText()
 }
 
 // Write sends input to the process via the pseudo terminal.
@@ -171,12 +238,74 @@ func (p *Process) Close(logger *slog.Logger, timeout time.Duration) error {
 			exitErr = xerrors.Errorf("process exited with error: %w", err)
 		}
 	}
+	if err := p.killProcessGroup(logger, processGroupGracePeriod); err != nil && exitErr == nil {
+		exitErr = err
+	}
+	if p.cgroup != nil {
+		if err := p.removeCgroup(); err != nil {
+			logger.Warn("Failed to clean up the agent's cgroup", "error", err)
+		}
+	}
 	if err := p.xp.Close(); err != nil {
 		return xerrors.Errorf("failed to close pseudo terminal: %w, exitErr: %w", err, exitErr)
 	}
 	return exitErr
 }
 
+// killProcessGroup terminates the processes spawned by the agent, such as
+// language servers or test runners, which may outlive it. They get SIGTERM
+// first, and SIGKILL if they are still running after the grace period.
+func (p *Process) killProcessGroup(logger *slog.Logger, grace time.Duration) error {
+	pid := p.execCmd.Process.Pid
+	if !processGroupAlive(pid) {
+		return nil
+	}
+	logger.Info("Terminating the agent's remaining processes")
+	if err := signalProcessGroup(pid, syscall.SIGTERM); err != nil {
+		return xerrors.Errorf("failed to terminate the agent's processes: %w", err)
+	}
+	deadline := p.clock.Now().Add(grace)
+	for processGroupAlive(pid) {
+		if !p.clock.Now().Before(deadline) {
+			logger.Warn("Killing the agent's remaining processes")
+			if err := signalProcessGroup(pid, syscall.SIGKILL); err != nil {
+				return xerrors.Errorf("failed to kill the agent's processes: %w", err)
+			}
+			return nil
+		}
+		t := p.clock.NewTimer(50 * time.Millisecond)
+		<-t.C
+	}
+	return nil
+}
+
+// removeCgroup kills whatever is left in the agent's cgroup, including
+// processes that left its process group, and removes the cgroup once they
+// exited.
+func (p *Process) removeCgroup() error {
+	if err := p.cgroup.kill(); err != nil {
+		return err
+	}
+	var err error
+	for range 20 {
+		if err = p.cgroup.remove(); err == nil {
+			return nil
+		}
+		t := p.clock.NewTimer(50 * time.Millisecond)
+		<-t.C
+	}
+	return err
+}
+
+// ResourceStats reads the usage of the agent's cgroup. It is only
+// available when the agent runs with limits.
+func (p *Process) ResourceStats() (transport.ResourceStats, error) {
+	if p.cgroup == nil {
+		return transport.ResourceStats{}, xerrors.New("the agent runs without resource limits")
+	}
+	return p.cgroup.stats()
+}
+
 var ErrNonZeroExitCode = xerrors.New("non-zero exit code")
 
 // Wait waits for the process to exit.
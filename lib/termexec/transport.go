@@ -0,0 +1,114 @@
+package termexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coder/agentapi/lib/logctx"
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/transport"
+	"golang.org/x/xerrors"
+)
+
+// TransportName is the name the PTY transport is registered under.
+const TransportName = "pty"
+
+// That's about 40 frames per second. It's slightly less
+// because the action of taking a snapshot takes time too.
+const snapshotInterval = 25 * time.Millisecond
+
+func init() {
+	transport.Register(TransportName, ptyTransport{})
+}
+
+// ptyTransport runs the agent in a pseudo terminal and reconstructs
+// messages by diffing snapshots of the terminal screen.
+type ptyTransport struct{}
+
+func (ptyTransport) Start(ctx context.Context, cfg transport.StartConfig) (*transport.Agent, error) {
+	logger := logctx.From(ctx)
+
+	if cfg.SessionName != "" {
+		killed, err := SweepOrphans(cfg.SessionName)
+		if err != nil {
+			logger.Warn("Failed to clean up orphaned agent processes", "error", err)
+		}
+		if killed > 0 {
+			logger.Info(fmt.Sprintf("Killed %d processes left behind by a previous server", killed), "session", cfg.SessionName)
+		}
+	}
+
+	logger.Info(fmt.Sprintf("Running: %s %s", cfg.Program, strings.Join(cfg.ProgramArgs, " ")))
+
+	process, err := StartProcess(ctx, StartProcessConfig{
+		Program:        cfg.Program,
+		Args:           cfg.ProgramArgs,
+		TerminalWidth:  cfg.TerminalWidth,
+		TerminalHeight: cfg.TerminalHeight,
+		Clock:          cfg.Clock,
+		SessionName:    cfg.SessionName,
+		Limits:         cfg.Limits,
+		Env:            cfg.Env,
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("failed to start process: %w", err)
+	}
+
+	// Hack for sourcegraph amp to stop the animation.
+	if cfg.AgentType == mf.AgentTypeAmp {
+		if _, err := process.Write([]byte(" \b")); err != nil {
+			return nil, err
+		}
+	}
+
+	agent := &transport.Agent{
+		IO: process,
+		Wait: func() error {
+			err := process.Wait()
+			if errors.Is(err, ErrNonZeroExitCode) {
+				return xerrors.Errorf("========\n%s\n========\n: %w", strings.TrimSpace(process.ReadScreen()), err)
+			}
+			return err
+		},
+		Close: process.Close,
+	}
+	if !cfg.Limits.IsZero() {
+		agent.ResourceStats = process.ResourceStats
+	}
+	return agent, nil
+}
+
+func (ptyTransport) NewConversation(ctx context.Context, cfg transport.ConversationConfig) (st.Conversation, error) {
+	agentType := cfg.AgentType
+	return st.NewPTY(ctx, st.PTYConversationConfig{
+		AgentType:             agentType,
+		AgentIO:               cfg.AgentIO,
+		Clock:                 cfg.Clock,
+		SnapshotInterval:      snapshotInterval,
+		ScreenStabilityLength: 2 * time.Second,
+		FormatMessage: func(message string, userInput string) string {
+			return mf.FormatAgentMessage(agentType, message, userInput)
+		},
+		ReadyForInitialPrompt: func(message string) bool {
+			return mf.IsAgentReadyForInitialPrompt(agentType, message)
+		},
+		FormatToolCall: func(message string) (string, []string) {
+			return mf.FormatToolCall(agentType, message)
+		},
+		DetectStopReason: func(message string) st.StopReason {
+			if mf.IsContextLimitReached(agentType, message) {
+				return st.StopReasonMaxTokens
+			}
+			return ""
+		},
+		InitialPrompt:          cfg.InitialPrompt,
+		Logger:                 cfg.Logger,
+		StatePersistenceConfig: cfg.StatePersistenceConfig,
+		DebugMessages:          cfg.DebugMessages,
+		MessageStore:           cfg.MessageStore,
+	}, cfg.Emitter), nil
+}
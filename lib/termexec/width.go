@@ -0,0 +1,8 @@
+package termexec
+
+// widePlaceholder fills the second cell of double-width characters such
+// as CJK ideographs. The terminal emulator gives every character a single
+// cell, while agents lay out their UI assuming wide characters take two
+// columns, so without it the cursor drifts and lines wrap late. It is a
+// noncharacter, so it never appears in the agent's own output.
+const widePlaceholder = '\uFDD0'
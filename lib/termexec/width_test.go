@@ -0,0 +1,44 @@
+package termexec
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/agentapi/lib/logctx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWideCharacters(t *testing.T) {
+	ctx := logctx.WithLogger(context.Background(), slog.New(logctx.DiscardHandler))
+	// Move to column 10 after two wide characters, then print 45 wide
+	// characters, which a 80 columns wide terminal wraps after 40, and 45
+	// more after a narrow one, which leaves the last column empty. Wide
+	// characters elsewhere on the line aren't moved.
+	script := `printf '你好\033[10G|\n'; printf '字%.0s' $(seq 45); printf '\na'; printf '字%.0s' $(seq 45); ` +
+		`printf '\na字字字字字\033[13G|\ndone\n'; sleep 5`
+	p, err := StartProcess(ctx, StartProcessConfig{
+		Program:        "sh",
+		Args:           []string{"-c", script},
+		TerminalWidth:  80,
+		TerminalHeight: 10,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = p.Close(slog.New(logctx.DiscardHandler), time.Second) })
+
+	var lines []string
+	require.Eventually(t, func() bool {
+		lines = strings.Split(p.ReadScreen(), "\n")
+		return len(lines) > 6 && strings.TrimSpace(lines[6]) == "done"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "你好     |", strings.TrimRight(lines[0], " "))
+	assert.Equal(t, strings.Repeat("字", 40), strings.TrimRight(lines[1], " "))
+	assert.Equal(t, strings.Repeat("字", 5), strings.TrimRight(lines[2], " "))
+	assert.Equal(t, "a"+strings.Repeat("字", 39), strings.TrimRight(lines[3], " "))
+	assert.Equal(t, strings.Repeat("字", 6), strings.TrimRight(lines[4], " "))
+	assert.Equal(t, "a字字字字字 |", strings.TrimRight(lines[5], " "))
+	assert.NotContains(t, p.ReadScreenANSI(), string(widePlaceholder))
+}
@@ -0,0 +1,116 @@
+package transport
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+)
+
+// MockTransportName is the name the mock transport is registered under.
+const MockTransportName = "mock"
+
+func init() {
+	Register(MockTransportName, mockTransport{})
+}
+
+// mockTransport runs an in-process fake agent instead of launching a
+// program. It lets the HTTP API be exercised end to end without a real
+// agent or a pseudo terminal.
+type mockTransport struct{}
+
+func (mockTransport) Start(_ context.Context, _ StartConfig) (*Agent, error) {
+	agentIO := NewMockAgentIO()
+	return &Agent{
+		IO: agentIO,
+		Wait: func() error {
+			<-agentIO.closed
+			return nil
+		},
+		Close: func(_ *slog.Logger, _ time.Duration) error {
+			agentIO.close()
+			return nil
+		},
+	}, nil
+}
+
+func (mockTransport) NewConversation(ctx context.Context, cfg ConversationConfig) (st.Conversation, error) {
+	agentType := cfg.AgentType
+	return st.NewPTY(ctx, st.PTYConversationConfig{
+		AgentType:             agentType,
+		AgentIO:               cfg.AgentIO,
+		Clock:                 cfg.Clock,
+		SnapshotInterval:      25 * time.Millisecond,
+		ScreenStabilityLength: 200 * time.Millisecond,
+		FormatMessage: func(message string, userInput string) string {
+			return mf.FormatAgentMessage(agentType, message, userInput)
+		},
+		// The mock agent accepts input as soon as it starts.
+		ReadyForInitialPrompt: func(string) bool { return true },
+		FormatToolCall: func(message string) (string, []string) {
+			return message, nil
+		},
+		InitialPrompt:          cfg.InitialPrompt,
+		Logger:                 cfg.Logger,
+		StatePersistenceConfig: cfg.StatePersistenceConfig,
+		DebugMessages:          cfg.DebugMessages,
+		MessageStore:           cfg.MessageStore,
+	}, cfg.Emitter), nil
+}
+
+// Matches CSI sequences such as the bracketed paste markers.
+var csiSequenceRe = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// MockAgentIO is a fake terminal agent. It echoes typed input after a
+// "> " prompt and answers every submitted line with "mock: <line>".
+type MockAgentIO struct {
+	mu        sync.Mutex
+	history   []string
+	input     strings.Builder
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewMockAgentIO() *MockAgentIO {
+	return &MockAgentIO{closed: make(chan struct{})}
+}
+
+func (m *MockAgentIO) Write(data []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range csiSequenceRe.ReplaceAllString(string(data), "") {
+		switch r {
+		case '\r':
+			line := m.input.String()
+			m.input.Reset()
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			m.history = append(m.history, "> "+line, "", "mock: "+line, "")
+		case '\b', 0x7f:
+			runes := []rune(m.input.String())
+			if len(runes) > 0 {
+				m.input.Reset()
+				m.input.WriteString(string(runes[:len(runes)-1]))
+			}
+		default:
+			m.input.WriteRune(r)
+		}
+	}
+	return len(data), nil
+}
+
+func (m *MockAgentIO) ReadScreen() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return strings.Join(append(append([]string{}, m.history...), "> "+m.input.String()), "\n")
+}
+
+func (m *MockAgentIO) close() {
+	m.closeOnce.Do(func() { close(m.closed) })
+}
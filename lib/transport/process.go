@@ -0,0 +1,125 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coder/quartz"
+	"golang.org/x/xerrors"
+)
+
+// PipeProcess is an agent process that communicates over stdin and
+// stdout, for transports that speak a structured protocol instead of
+// driving a terminal.
+type PipeProcess struct {
+	Stdin  io.WriteCloser
+	Stdout io.ReadCloser
+
+	cmd     *exec.Cmd
+	clock   quartz.Clock
+	exited  chan struct{}
+	waitErr error
+}
+
+// StartPipeProcess starts program with its stdin and stdout connected to
+// pipes, and env added to its environment. Its stderr is forwarded to ours.
+// When ctx is done, the process is asked to exit with SIGTERM and killed 5
+// seconds later.
+func StartPipeProcess(ctx context.Context, clock quartz.Clock, env map[string]string, program string, args ...string) (*PipeProcess, error) {
+	if clock == nil {
+		clock = quartz.NewReal()
+	}
+	cmd := exec.CommandContext(ctx, program, args...)
+	if len(env) > 0 {
+		cmd.Env = os.Environ()
+		for _, key := range slices.Sorted(maps.Keys(env)) {
+			cmd.Env = append(cmd.Env, key+"="+env[key])
+		}
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, xerrors.Errorf("failed to create stdout pipe: %w", err)
+	}
+	cmd.Stderr = os.Stderr
+	// When ctx is done, ask the agent to exit before it gets killed.
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = 5 * time.Second
+
+	if err := cmd.Start(); err != nil {
+		return nil, xerrors.Errorf("failed to start process: %w", err)
+	}
+
+	p := &PipeProcess{
+		Stdin:  stdin,
+		Stdout: stdout,
+		cmd:    cmd,
+		clock:  clock,
+		exited: make(chan struct{}),
+	}
+	go func() {
+		defer close(p.exited)
+		p.waitErr = cmd.Wait()
+	}()
+	return p, nil
+}
+
+// Wait blocks until the process exits.
+func (p *PipeProcess) Wait() error {
+	<-p.exited
+	return p.waitErr
+}
+
+// Kill forcefully stops the process.
+func (p *PipeProcess) Kill() {
+	_ = p.cmd.Process.Kill()
+}
+
+// Close sends SIGTERM and closes stdin, then kills the process if it
+// doesn't exit within timeout.
+func (p *PipeProcess) Close(logger *slog.Logger, timeout time.Duration) error {
+	logger.Info("Closing process")
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return xerrors.Errorf("failed to send SIGTERM to process: %w", err)
+	}
+	_ = p.Stdin.Close()
+
+	timer := p.clock.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-p.exited:
+		return nil
+	case <-timer.C:
+		if err := p.cmd.Process.Kill(); err != nil {
+			return xerrors.Errorf("failed to forcefully kill the process: %w", err)
+		}
+		return nil
+	}
+}
+
+// helpProbeTimeout bounds how long HelpMentions waits for the help output.
+const helpProbeTimeout = 10 * time.Second
+
+// HelpMentions reports whether the output of "program --help" contains
+// needle, which transports use to tell whether the installed agent
+// supports the mode they run it in. The probe is cancelled with ctx.
+func HelpMentions(ctx context.Context, program, needle string) bool {
+	ctx, cancel := context.WithTimeout(ctx, helpProbeTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, program, "--help").CombinedOutput()
+	return err == nil && strings.Contains(string(out), needle)
+}
@@ -0,0 +1,14 @@
+package transport
+
+import "strings"
+
+// PromptText returns the text of a message written to an agent that takes
+// prompts rather than keystrokes, without the terminal-specific sequences
+// httpapi.FormatMessage adds around it: the "x\b" Claude Code needs and
+// the bracketed paste markers.
+func PromptText(data []byte) string {
+	text := strings.TrimPrefix(string(data), "x\b")
+	text = strings.TrimPrefix(text, "\x1b[200~")
+	text = strings.TrimSuffix(text, "\x1b[201~")
+	return strings.TrimSpace(text)
+}
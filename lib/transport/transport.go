@@ -0,0 +1,167 @@
+// Package transport defines how agentapi talks to an agent process.
+//
+// A Transport knows how to launch an agent and how to wrap the resulting
+// AgentIO into a screentracker.Conversation. Implementations register
+// themselves by name from an init function (see lib/termexec and x/acpio),
+// and cmd/server selects one with the --transport flag.
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mf "github.com/coder/agentapi/lib/msgfmt"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/quartz"
+	"golang.org/x/xerrors"
+)
+
+// StartConfig configures how an agent process is launched.
+type StartConfig struct {
+	Program        string
+	ProgramArgs    []string
+	AgentType      mf.AgentType
+	TerminalWidth  uint16
+	TerminalHeight uint16
+	Clock          quartz.Clock
+	// SessionName identifies the server's agent across restarts, so that
+	// processes left behind by a previous server can be cleaned up.
+	SessionName string
+	// Limits confines the agent and its subprocesses. Only the PTY
+	// transport enforces them.
+	Limits ResourceLimits
+	// Options holds transport-specific settings, passed on the command
+	// line as --transport-opt key=value.
+	Options map[string]string
+	// Env is added to the agent's environment, e.g. the TERM of the
+	// agent's terminal profile or the settings an agent is told to load.
+	Env map[string]string
+}
+
+// ResourceLimits caps the resources used by the agent's process tree.
+// Zero values mean no limit.
+type ResourceLimits struct {
+	// CPU is the number of CPUs the agent can use, e.g. 1.5.
+	CPU float64
+	// Memory is the memory the agent can use, in bytes.
+	Memory int64
+}
+
+func (l ResourceLimits) IsZero() bool {
+	return l.CPU == 0 && l.Memory == 0
+}
+
+// ResourceStats reports how the agent's process tree is doing against its
+// limits.
+type ResourceStats struct {
+	MemoryBytes int64
+	// CPUThrottledPeriods counts the scheduling periods in which the
+	// agent used up its CPU limit, and CPUThrottled is the time it spent
+	// waiting because of it.
+	CPUThrottledPeriods int64
+	CPUThrottled        time.Duration
+	// MemoryMaxEvents counts the times the agent's memory use hit the
+	// limit, and OOMKills the processes killed because memory couldn't be
+	// reclaimed.
+	MemoryMaxEvents int64
+	OOMKills        int64
+}
+
+// MCPServerStatus is an MCP server the agent reports having loaded, and
+// whether it connected, e.g. "connected" or "failed", as the agent puts it.
+type MCPServerStatus struct {
+	Name   string
+	Status string
+}
+
+// ConversationConfig configures the conversation that wraps an AgentIO.
+type ConversationConfig struct {
+	AgentType              mf.AgentType
+	AgentIO                st.AgentIO
+	InitialPrompt          []st.MessagePart
+	Emitter                st.Emitter
+	Clock                  quartz.Clock
+	Logger                 *slog.Logger
+	StatePersistenceConfig st.StatePersistenceConfig
+	// DebugMessages asks transports that parse messages from the screen to
+	// describe how each agent message was parsed.
+	DebugMessages bool
+	// MessageStore holds the messages of transports that parse them from
+	// the screen. Optional, they're kept in memory by default.
+	MessageStore st.MessageStore
+}
+
+// Agent is a running agent started by a Transport.
+type Agent struct {
+	IO st.AgentIO
+	// Transport is set by transports that fell back to another transport
+	// to the name of the transport actually in use.
+	Transport string
+	// Wait blocks until the agent exits and returns its exit error, if any.
+	Wait func() error
+	// Close asks the agent to exit and forcefully stops it if it doesn't
+	// exit within timeout.
+	Close func(logger *slog.Logger, timeout time.Duration) error
+	// ResourceStats reads the usage of the agent's process tree. It is nil
+	// unless the agent runs with limits.
+	ResourceStats func() (ResourceStats, error)
+}
+
+// Transport launches agents and builds conversations on top of them.
+type Transport interface {
+	// Start launches the agent. It returns once the agent is ready to
+	// receive input through the returned AgentIO.
+	Start(ctx context.Context, cfg StartConfig) (*Agent, error)
+	// NewConversation wraps cfg.AgentIO into a Conversation. cfg.AgentIO
+	// is nil when no agent runs (e.g. when printing the OpenAPI schema).
+	NewConversation(ctx context.Context, cfg ConversationConfig) (st.Conversation, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Transport)
+)
+
+// Register makes a transport available under name. It panics if the name
+// is already taken, since that indicates conflicting init functions.
+func Register(name string, t Transport) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("transport %q is already registered", name))
+	}
+	registry[name] = t
+}
+
+// Lookup returns the transport registered under name.
+func Lookup(name string) (Transport, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	t, ok := registry[name]
+	if !ok {
+		return nil, xerrors.Errorf("unknown transport %q (available: %s)", name, strings.Join(namesLocked(), ", "))
+	}
+	return t, nil
+}
+
+// Names returns the sorted names of all registered transports.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return namesLocked()
+}
+
+// Assumes the caller holds registryMu.
+func namesLocked() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,81 @@
+package transport_test
+
+import (
+	"bufio"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/agentapi/lib/transport"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Run("lookup registered", func(t *testing.T) {
+		tr, err := transport.Lookup(transport.MockTransportName)
+		require.NoError(t, err)
+		require.NotNil(t, tr)
+		assert.Contains(t, transport.Names(), transport.MockTransportName)
+	})
+
+	t.Run("lookup unknown", func(t *testing.T) {
+		_, err := transport.Lookup("carrier-pigeon")
+		require.ErrorContains(t, err, `unknown transport "carrier-pigeon"`)
+	})
+
+	t.Run("duplicate registration panics", func(t *testing.T) {
+		tr, err := transport.Lookup(transport.MockTransportName)
+		require.NoError(t, err)
+		assert.Panics(t, func() {
+			transport.Register(transport.MockTransportName, tr)
+		})
+	})
+}
+
+func TestMockAgentIO(t *testing.T) {
+	agentIO := transport.NewMockAgentIO()
+	assert.Equal(t, "> ", agentIO.ReadScreen())
+
+	// Bracketed paste markers are dropped and backspaces edit the input.
+	_, err := agentIO.Write([]byte("x\b\x1b[200~hello\x1b[201~"))
+	require.NoError(t, err)
+	assert.Equal(t, "> hello", agentIO.ReadScreen())
+
+	_, err = agentIO.Write([]byte("\r"))
+	require.NoError(t, err)
+	assert.Equal(t, "> hello\n\nmock: hello\n\n> ", agentIO.ReadScreen())
+}
+
+func TestMockTransport_Close(t *testing.T) {
+	tr, err := transport.Lookup(transport.MockTransportName)
+	require.NoError(t, err)
+	agent, err := tr.Start(context.Background(), transport.StartConfig{})
+	require.NoError(t, err)
+
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- agent.Wait()
+	}()
+	require.NoError(t, agent.Close(nil, 0))
+	require.NoError(t, <-waitErr)
+}
+
+func TestStartPipeProcess_Env(t *testing.T) {
+	// The process waits for its input to close, so that its output isn't
+	// closed before it's read.
+	proc, err := transport.StartPipeProcess(context.Background(), nil, map[string]string{"AGENTAPI_TEST_VALUE": "hello"}, "sh", "-c", "echo $AGENTAPI_TEST_VALUE; cat")
+	require.NoError(t, err)
+	line, err := bufio.NewReader(proc.Stdout).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", line)
+	require.NoError(t, proc.Stdin.Close())
+	require.NoError(t, proc.Wait())
+}
+
+func TestPromptText(t *testing.T) {
+	assert.Equal(t, "hello", transport.PromptText([]byte("x\b\x1b[200~hello\x1b[201~")))
+	assert.Equal(t, "hello", transport.PromptText([]byte("\x1b[200~ hello \x1b[201~")))
+	assert.Equal(t, "hello", transport.PromptText([]byte("hello\n")))
+	assert.Empty(t, transport.PromptText([]byte("x\b")))
+}
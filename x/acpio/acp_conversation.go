@@ -3,11 +3,14 @@ package acpio
 import (
 	"context"
 	"log/slog"
+	"maps"
+	"os"
 	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/coder/agentapi/lib/logctx"
 	st "github.com/coder/agentapi/lib/screentracker"
 	"github.com/coder/quartz"
 	"golang.org/x/xerrors"
@@ -36,10 +39,49 @@ type ACPConversation struct {
 	prompting         bool          // true while agent is processing
 	chunkReceived     chan struct{} // signals that handleChunk has accumulated a chunk
 	streamingResponse strings.Builder
+	streamingThought  strings.Builder
 	logger            *slog.Logger
 	emitter           st.Emitter
 	initialPrompt     []st.MessagePart
 	clock             quartz.Clock
+	statePersistence  st.StatePersistenceConfig
+	initialPromptSent bool
+	notes             map[string]string
+}
+
+// ToolCallReporter is implemented by AgentIOs that receive structured tool
+// calls from the agent. They are forwarded to the emitter if it is a
+// st.ToolCallEmitter.
+type ToolCallReporter interface {
+	SetOnToolCall(fn func(toolCall st.ToolCall))
+}
+
+// ReasoningReporter is implemented by AgentIOs that receive the agent's
+// thoughts and plan separately from its reply. They are stored on the agent
+// message and forwarded to the emitter if it is a st.ReasoningEmitter.
+type ReasoningReporter interface {
+	SetOnThought(fn func(chunk string))
+	SetOnPlan(fn func(plan []st.PlanEntry))
+}
+
+// DiffReporter is implemented by AgentIOs that receive structured file
+// diffs from tool calls. They are stored on the agent message and forwarded
+// to the emitter if it is a st.DiffEmitter.
+type DiffReporter interface {
+	SetOnDiff(fn func(diff st.FileDiff))
+}
+
+// StopReasonReporter is implemented by AgentIOs that know why the agent
+// stopped responding to the last message.
+type StopReasonReporter interface {
+	StopReason() st.StopReason
+}
+
+// HistoryRestorer is implemented by AgentIOs that can replay a restored
+// conversation to the agent, e.g. stateless HTTP agents that receive the
+// full history with every request.
+type HistoryRestorer interface {
+	RestoreHistory(messages []st.ConversationMessage)
 }
 
 // noopEmitter is a no-op implementation of Emitter for when no emitter is provided.
@@ -84,6 +126,16 @@ func (c *ACPConversation) Messages() []st.ConversationMessage {
 	return slices.Clone(c.messages)
 }
 
+// turnId returns the turn of the latest message.
+func (c *ACPConversation) turnId() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.messages) == 0 {
+		return 0
+	}
+	return c.messages[len(c.messages)-1].TurnId
+}
+
 // Send sends a message to the agent synchronously.
 // It blocks until the agent has finished processing and returns any error
 // from the underlying write. Returns a validation error immediately if
@@ -109,11 +161,13 @@ func (c *ACPConversation) Send(messageParts ...st.MessagePart) error {
 		c.mu.Unlock()
 		return st.ErrMessageValidationChanging
 	}
+	turnId := c.nextID
 	c.messages = append(c.messages, st.ConversationMessage{
 		Id:      c.nextID,
 		Role:    st.ConversationRoleUser,
 		Message: message,
 		Time:    c.clock.Now(),
+		TurnId:  turnId,
 	})
 	c.nextID++
 	// Add placeholder for streaming agent response
@@ -122,9 +176,11 @@ func (c *ACPConversation) Send(messageParts ...st.MessagePart) error {
 		Role:    st.ConversationRoleAgent,
 		Message: "",
 		Time:    c.clock.Now(),
+		TurnId:  turnId,
 	})
 	c.nextID++
 	c.streamingResponse.Reset()
+	c.streamingThought.Reset()
 	c.prompting = true
 	status := c.statusLocked()
 	c.mu.Unlock()
@@ -132,7 +188,7 @@ func (c *ACPConversation) Send(messageParts ...st.MessagePart) error {
 	// Emit status change to "running" before starting the prompt
 	c.emitter.EmitStatus(status)
 
-	c.logger.Debug("ACPConversation sending message", "message", message)
+	c.logger.Debug("ACPConversation sending message", logctx.Content("message", message))
 
 	return c.executePrompt(messageParts)
 }
@@ -141,9 +197,35 @@ func (c *ACPConversation) Send(messageParts ...st.MessagePart) error {
 func (c *ACPConversation) Start(ctx context.Context) {
 	// Wire up the chunk callback for streaming
 	c.agentIO.SetOnChunk(c.handleChunk)
+	if reporter, ok := c.agentIO.(ToolCallReporter); ok {
+		if toolCallEmitter, ok := c.emitter.(st.ToolCallEmitter); ok {
+			reporter.SetOnToolCall(func(toolCall st.ToolCall) {
+				toolCall.TurnId = c.turnId()
+				toolCallEmitter.EmitToolCall(toolCall)
+			})
+		}
+	}
+	if reporter, ok := c.agentIO.(ReasoningReporter); ok {
+		reporter.SetOnThought(c.handleThought)
+		reporter.SetOnPlan(c.handlePlan)
+	}
+	if reporter, ok := c.agentIO.(DiffReporter); ok {
+		reporter.SetOnDiff(c.handleDiff)
+	}
+
+	c.mu.Lock()
+	sendInitialPrompt := len(c.initialPrompt) > 0 && !c.initialPromptSent
+	c.initialPromptSent = c.initialPromptSent || sendInitialPrompt
+	restored := slices.Clone(c.messages)
+	c.mu.Unlock()
+
+	// Publish messages restored by EnableStatePersistence.
+	if len(restored) > 0 {
+		c.emitter.EmitMessages(restored)
+	}
 
 	// Send initial prompt if provided
-	if len(c.initialPrompt) > 0 {
+	if sendInitialPrompt {
 		// Run in a goroutine because Send blocks until the prompt completes,
 		// and Start must return immediately per the Conversation interface.
 		go func() {
@@ -178,6 +260,36 @@ func (c *ACPConversation) Stop() {
 	c.cancel()
 }
 
+// Notes returns the client-managed notes attached to the conversation.
+func (c *ACPConversation) Notes() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return maps.Clone(c.notes)
+}
+
+// SetNotes replaces the notes. They are saved by SaveState.
+func (c *ACPConversation) SetNotes(notes map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notes = maps.Clone(notes)
+}
+
+// SetMessagePinned pins or unpins a message. Pins are saved by SaveState.
+func (c *ACPConversation) SetMessagePinned(id int, pinned bool) error {
+	c.mu.Lock()
+	i := slices.IndexFunc(c.messages, func(msg st.ConversationMessage) bool { return msg.Id == id })
+	if i == -1 {
+		c.mu.Unlock()
+		return st.ErrMessageNotFound
+	}
+	c.messages[i].Pinned = pinned
+	messages := slices.Clone(c.messages)
+	c.mu.Unlock()
+
+	c.emitter.EmitMessages(messages)
+	return nil
+}
+
 // Text returns the current streaming response text.
 func (c *ACPConversation) Text() string {
 	c.mu.Lock()
@@ -218,6 +330,82 @@ func (c *ACPConversation) handleChunk(chunk string) {
 	c.emitter.EmitScreen(screen)
 }
 
+// handleThought is called for each chunk of the agent's reasoning. The
+// thought is kept on the agent message instead of being merged into the
+// reply.
+func (c *ACPConversation) handleThought(chunk string) {
+	c.mu.Lock()
+	last := len(c.messages) - 1
+	if !c.prompting || last < 0 || c.messages[last].Role != st.ConversationRoleAgent {
+		c.mu.Unlock()
+		c.logger.Debug("received thought while not prompting (discarded)", "chunkLen", len(chunk))
+		return
+	}
+	c.streamingThought.WriteString(chunk)
+	c.messages[last].Thought = c.streamingThought.String()
+	messageId := c.messages[last].Id
+	thought := c.messages[last].Thought
+	messages := slices.Clone(c.messages)
+	c.mu.Unlock()
+
+	c.emitter.EmitMessages(messages)
+	if reasoningEmitter, ok := c.emitter.(st.ReasoningEmitter); ok {
+		reasoningEmitter.EmitThought(messageId, thought)
+	}
+}
+
+// handlePlan is called each time the agent reports its plan. Every plan
+// replaces the previous one.
+func (c *ACPConversation) handlePlan(plan []st.PlanEntry) {
+	c.mu.Lock()
+	last := len(c.messages) - 1
+	if !c.prompting || last < 0 || c.messages[last].Role != st.ConversationRoleAgent {
+		c.mu.Unlock()
+		c.logger.Debug("received plan while not prompting (discarded)", "entries", len(plan))
+		return
+	}
+	c.messages[last].Plan = plan
+	messageId := c.messages[last].Id
+	messages := slices.Clone(c.messages)
+	c.mu.Unlock()
+
+	c.emitter.EmitMessages(messages)
+	if reasoningEmitter, ok := c.emitter.(st.ReasoningEmitter); ok {
+		reasoningEmitter.EmitPlan(messageId, slices.Clone(plan))
+	}
+}
+
+// handleDiff is called for each file diff in a tool call. Agents may
+// report the same diff again in tool call updates, so a diff replaces an
+// earlier one for the same tool call and path.
+func (c *ACPConversation) handleDiff(diff st.FileDiff) {
+	c.mu.Lock()
+	last := len(c.messages) - 1
+	if !c.prompting || last < 0 || c.messages[last].Role != st.ConversationRoleAgent {
+		c.mu.Unlock()
+		c.logger.Debug("received diff while not prompting (discarded)", "path", diff.Path)
+		return
+	}
+	diffs := slices.Clone(c.messages[last].Diffs)
+	idx := slices.IndexFunc(diffs, func(d st.FileDiff) bool {
+		return d.ToolCallId == diff.ToolCallId && d.Path == diff.Path
+	})
+	if idx >= 0 {
+		diffs[idx] = diff
+	} else {
+		diffs = append(diffs, diff)
+	}
+	c.messages[last].Diffs = diffs
+	messageId := c.messages[last].Id
+	messages := slices.Clone(c.messages)
+	c.mu.Unlock()
+
+	c.emitter.EmitMessages(messages)
+	if diffEmitter, ok := c.emitter.(st.DiffEmitter); ok {
+		diffEmitter.EmitDiff(messageId, diff)
+	}
+}
+
 // executePrompt runs the actual agent request and returns any error.
 func (c *ACPConversation) executePrompt(messageParts []st.MessagePart) error {
 	// Drain any stale signal before sending the prompt.
@@ -273,6 +461,9 @@ func (c *ACPConversation) executePrompt(messageParts []st.MessagePart) error {
 	if len(c.messages) > 0 && c.messages[len(c.messages)-1].Role == st.ConversationRoleAgent {
 		// Intentionally not trimming space here.
 		c.messages[len(c.messages)-1].Message = response
+		if reporter, ok := c.agentIO.(StopReasonReporter); ok {
+			c.messages[len(c.messages)-1].StopReason = reporter.StopReason()
+		}
 	}
 	messages := slices.Clone(c.messages)
 	status := c.statusLocked()
@@ -287,6 +478,77 @@ func (c *ACPConversation) executePrompt(messageParts []st.MessagePart) error {
 	return nil
 }
 
+// EnableStatePersistence makes SaveState write the conversation to
+// cfg.StateFile and, if cfg.LoadState is set, restores the conversation
+// from it. It must be called before Start.
+func (c *ACPConversation) EnableStatePersistence(cfg st.StatePersistenceConfig) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statePersistence = cfg
+	if !cfg.LoadState {
+		return nil
+	}
+	if _, err := os.Stat(cfg.StateFile); os.IsNotExist(err) {
+		c.logger.Info("No previous state to load (file does not exist)", "path", cfg.StateFile)
+		return nil
+	}
+	agentState, err := st.ReadAgentState(cfg.StateFile)
+	if err != nil {
+		return err
+	}
+	if differences := cfg.Environment.Differences(agentState.Environment); len(differences) > 0 {
+		c.logger.Warn("The state was saved in a different environment, the agent may behave differently", "differences", differences)
+	}
+
+	c.messages = agentState.Messages
+	st.AssignTurnIds(c.messages)
+	c.notes = agentState.Notes
+	for _, msg := range c.messages {
+		c.nextID = max(c.nextID, msg.Id+1)
+	}
+	// Don't send the same initial prompt twice.
+	if agentState.InitialPromptSent && buildString(c.initialPrompt) == agentState.InitialPrompt {
+		c.initialPromptSent = true
+	}
+	if restorer, ok := c.agentIO.(HistoryRestorer); ok {
+		restorer.RestoreHistory(slices.Clone(c.messages))
+	}
+	c.logger.Info("Successfully loaded state", "path", cfg.StateFile, "messages", len(c.messages))
+	return nil
+}
+
 func (c *ACPConversation) SaveState() error {
-	return xerrors.Errorf("ACP mode doesn't support state persistence")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.statePersistence.StateFile == "" {
+		return xerrors.Errorf("ACP mode doesn't support state persistence")
+	}
+	if !c.statePersistence.SaveState {
+		c.logger.Info("State persistence is disabled")
+		return nil
+	}
+	messages := slices.Clone(c.messages)
+	if filter := c.statePersistence.FilterMessages; filter != nil {
+		messages = filter(messages)
+	}
+	if err := st.WriteAgentState(c.statePersistence.StateFile, st.AgentState{
+		Version:           1,
+		Messages:          messages,
+		InitialPrompt:     buildString(c.initialPrompt),
+		InitialPromptSent: c.initialPromptSent,
+		Notes:             c.notes,
+		Environment:       c.statePersistence.Environment,
+	}); err != nil {
+		return err
+	}
+	c.logger.Info("State saved successfully", "path", c.statePersistence.StateFile)
+	return nil
+}
+
+func buildString(parts []st.MessagePart) string {
+	var sb strings.Builder
+	for _, part := range parts {
+		sb.WriteString(part.String())
+	}
+	return sb.String()
 }
@@ -2,6 +2,7 @@ package acpio_test
 
 import (
 	"context"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -372,6 +373,123 @@ func Test_Text_ReturnsStreamingContent(t *testing.T) {
 	require.NoError(t, <-errCh)
 }
 
+// reasoningAgentIO is a mockAgentIO that also reports thoughts and plans.
+type reasoningAgentIO struct {
+	*mockAgentIO
+	onThought func(chunk string)
+	onPlan    func(plan []screentracker.PlanEntry)
+}
+
+func (m *reasoningAgentIO) SetOnThought(fn func(chunk string)) { m.onThought = fn }
+
+func (m *reasoningAgentIO) SetOnPlan(fn func(plan []screentracker.PlanEntry)) { m.onPlan = fn }
+
+func Test_ThoughtAndPlan_KeptSeparateFromReply(t *testing.T) {
+	mClock := quartz.NewMock(t)
+	mock := &reasoningAgentIO{mockAgentIO: newMockAgentIO()}
+	started, done := mock.BlockWrite()
+
+	conv := acpio.NewACPConversation(context.Background(), mock, nil, nil, nil, mClock)
+	conv.Start(context.Background())
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- conv.Send(screentracker.MessagePartText{Content: "question"}) }()
+	<-started
+
+	mock.onThought("Let me ")
+	mock.onThought("think.")
+	mock.onPlan([]screentracker.PlanEntry{{Content: "Read the code", Priority: "high", Status: "pending"}})
+	mock.onPlan([]screentracker.PlanEntry{{Content: "Read the code", Priority: "high", Status: "completed"}})
+	mock.SimulateChunks("The answer")
+
+	close(done)
+	require.NoError(t, <-errCh)
+
+	messages := conv.Messages()
+	require.Len(t, messages, 2)
+	assert.Equal(t, "The answer", messages[1].Message)
+	assert.Equal(t, "Let me think.", messages[1].Thought)
+	// Each plan replaces the previous one.
+	assert.Equal(t, []screentracker.PlanEntry{{Content: "Read the code", Priority: "high", Status: "completed"}}, messages[1].Plan)
+	assert.Empty(t, messages[0].Thought)
+}
+
+// stopReasonAgentIO is a mockAgentIO that reports a stop reason.
+type stopReasonAgentIO struct {
+	*mockAgentIO
+	stopReason screentracker.StopReason
+}
+
+func (m *stopReasonAgentIO) StopReason() screentracker.StopReason { return m.stopReason }
+
+func Test_StopReason_RecordedOnAgentMessage(t *testing.T) {
+	mock := &stopReasonAgentIO{mockAgentIO: newMockAgentIO(), stopReason: screentracker.StopReasonRefusal}
+	started, done := mock.BlockWrite()
+
+	conv := acpio.NewACPConversation(context.Background(), mock, nil, nil, nil, quartz.NewMock(t))
+	conv.Start(context.Background())
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- conv.Send(screentracker.MessagePartText{Content: "question"}) }()
+	<-started
+	mock.SimulateChunks("I can't help with that.")
+	close(done)
+	require.NoError(t, <-errCh)
+
+	messages := conv.Messages()
+	require.Len(t, messages, 2)
+	assert.Empty(t, messages[0].StopReason)
+	assert.Equal(t, screentracker.StopReasonRefusal, messages[1].StopReason)
+}
+
+type toolCallAgentIO struct {
+	*mockAgentIO
+	onToolCall func(screentracker.ToolCall)
+}
+
+func (m *toolCallAgentIO) SetOnToolCall(fn func(screentracker.ToolCall)) { m.onToolCall = fn }
+
+type toolCallEmitter struct {
+	*mockEmitter
+	toolCalls []screentracker.ToolCall
+}
+
+func (m *toolCallEmitter) EmitToolCall(toolCall screentracker.ToolCall) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.toolCalls = append(m.toolCalls, toolCall)
+}
+
+func Test_TurnIds(t *testing.T) {
+	mock := &toolCallAgentIO{mockAgentIO: newMockAgentIO()}
+	emitter := &toolCallEmitter{mockEmitter: newMockEmitter()}
+	conv := acpio.NewACPConversation(context.Background(), mock, nil, nil, emitter, quartz.NewMock(t))
+	conv.Start(context.Background())
+
+	for _, content := range []string{"first", "second"} {
+		started, done := mock.BlockWrite()
+		errCh := make(chan error, 1)
+		go func() { errCh <- conv.Send(screentracker.MessagePartText{Content: content}) }()
+		<-started
+		// The tool call arrives before any of the reply.
+		mock.onToolCall(screentracker.ToolCall{Id: content, Name: "read", Status: screentracker.ToolCallStatusStarted})
+		mock.SimulateChunks("reply")
+		close(done)
+		require.NoError(t, <-errCh)
+	}
+
+	var turnIds []int
+	for _, msg := range conv.Messages() {
+		turnIds = append(turnIds, msg.TurnId)
+	}
+	assert.Equal(t, []int{0, 0, 2, 2}, turnIds)
+	emitter.mu.Lock()
+	defer emitter.mu.Unlock()
+	require.Len(t, emitter.toolCalls, 2)
+	assert.Equal(t, 0, emitter.toolCalls[0].TurnId)
+	assert.Equal(t, 2, emitter.toolCalls[1].TurnId)
+}
+
 func Test_Emitter_CalledOnChanges(t *testing.T) {
 	mClock := quartz.NewMock(t)
 	mock := newMockAgentIO()
@@ -589,3 +707,32 @@ func Test_LateChunkAfterError_DoesNotCorruptUserMessage(t *testing.T) {
 	assert.Equal(t, "hello", messages[0].Message)
 	assert.Equal(t, screentracker.ConversationRoleUser, messages[0].Role)
 }
+
+func Test_StatePersistence_RoundTrip(t *testing.T) {
+	mClock := quartz.NewMock(t)
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	persistence := screentracker.StatePersistenceConfig{StateFile: stateFile, LoadState: true, SaveState: true}
+
+	mock := newMockAgentIO()
+	started, done := mock.BlockWrite()
+	conv := acpio.NewACPConversation(context.Background(), mock, nil, nil, nil, mClock)
+	require.NoError(t, conv.EnableStatePersistence(persistence))
+	conv.Start(context.Background())
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- conv.Send(screentracker.MessagePartText{Content: "hello"}) }()
+	<-started
+	mock.SimulateChunks("hi there")
+	close(done)
+	require.NoError(t, <-errCh)
+	require.NoError(t, conv.SaveState())
+
+	restored := acpio.NewACPConversation(context.Background(), newMockAgentIO(), nil, nil, nil, mClock)
+	require.NoError(t, restored.EnableStatePersistence(persistence))
+	assert.Equal(t, conv.Messages(), restored.Messages())
+}
+
+func Test_SaveState_DisabledByDefault(t *testing.T) {
+	conv := acpio.NewACPConversation(context.Background(), newMockAgentIO(), nil, nil, nil, quartz.NewMock(t))
+	require.Error(t, conv.SaveState())
+}
@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"slices"
 	"strings"
 	"sync"
 
 	acp "github.com/coder/acp-go-sdk"
+	"github.com/coder/agentapi/lib/logctx"
 	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/transport"
 )
 
 // Compile-time assertion that ACPAgentIO implements st.AgentIO
@@ -24,6 +27,12 @@ type ACPAgentIO struct {
 	response  strings.Builder
 	logger    *slog.Logger
 	onChunk   func(chunk string) // called on each streaming chunk
+	commands  []st.AgentCommand  // latest available_commands_update
+	onThought func(chunk string)
+	onPlan    func(plan []st.PlanEntry)
+	onDiff    func(diff st.FileDiff)
+	// stopReason is the stop reason of the last completed prompt.
+	stopReason st.StopReason
 }
 
 // acpClient implements acp.Client to handle callbacks from the agent
@@ -41,7 +50,7 @@ func (c *acpClient) SessionUpdate(ctx context.Context, params acp.SessionNotific
 	if params.Update.AgentMessageChunk != nil {
 		if text := params.Update.AgentMessageChunk.Content.Text; text != nil {
 			c.agentIO.logger.Debug("AgentMessageChunk text",
-				"text", text.Text,
+				logctx.Content("text", text.Text),
 				"textLen", len(text.Text))
 			c.agentIO.mu.Lock()
 			c.agentIO.response.WriteString(text.Text)
@@ -64,10 +73,56 @@ func (c *acpClient) SessionUpdate(ctx context.Context, params acp.SessionNotific
 		if onChunk != nil {
 			onChunk(formatted)
 		}
+		c.reportDiffs(string(tc.ToolCallId), tc.Content)
+	}
+
+	// Thoughts and plans are reported separately so they don't end up in
+	// the reply.
+	if params.Update.AgentThoughtChunk != nil {
+		if text := params.Update.AgentThoughtChunk.Content.Text; text != nil {
+			c.agentIO.mu.RLock()
+			onThought := c.agentIO.onThought
+			c.agentIO.mu.RUnlock()
+			if onThought != nil {
+				onThought(text.Text)
+			}
+		}
+	}
+
+	if params.Update.Plan != nil {
+		plan := make([]st.PlanEntry, 0, len(params.Update.Plan.Entries))
+		for _, entry := range params.Update.Plan.Entries {
+			plan = append(plan, st.PlanEntry{
+				Content:  entry.Content,
+				Priority: string(entry.Priority),
+				Status:   string(entry.Status),
+			})
+		}
+		c.agentIO.mu.RLock()
+		onPlan := c.agentIO.onPlan
+		c.agentIO.mu.RUnlock()
+		if onPlan != nil {
+			onPlan(plan)
+		}
+	}
+
+	if update := params.Update.AvailableCommandsUpdate; update != nil {
+		commands := make([]st.AgentCommand, 0, len(update.AvailableCommands))
+		for _, cmd := range update.AvailableCommands {
+			command := st.AgentCommand{Name: cmd.Name, Description: cmd.Description}
+			if cmd.Input != nil && cmd.Input.UnstructuredCommandInput != nil {
+				command.InputHint = cmd.Input.UnstructuredCommandInput.Hint
+			}
+			commands = append(commands, command)
+		}
+		c.agentIO.mu.Lock()
+		c.agentIO.commands = commands
+		c.agentIO.mu.Unlock()
 	}
 
 	if params.Update.ToolCallUpdate != nil {
 		tcu := params.Update.ToolCallUpdate
+		c.reportDiffs(string(tcu.ToolCallId), tcu.Content)
 		var formatted string
 		if tcu.Status != nil {
 			formatted = fmt.Sprintf("[Tool Status: %s]\n", *tcu.Status)
@@ -86,6 +141,21 @@ func (c *acpClient) SessionUpdate(ctx context.Context, params acp.SessionNotific
 	return nil
 }
 
+// reportDiffs forwards the diff content blocks of a tool call.
+func (c *acpClient) reportDiffs(toolCallId string, content []acp.ToolCallContent) {
+	c.agentIO.mu.RLock()
+	onDiff := c.agentIO.onDiff
+	c.agentIO.mu.RUnlock()
+	if onDiff == nil {
+		return
+	}
+	for _, block := range content {
+		if block.Diff != nil {
+			onDiff(newFileDiff(toolCallId, block.Diff.Path, block.Diff.OldText, block.Diff.NewText))
+		}
+	}
+}
+
 func (c *acpClient) RequestPermission(ctx context.Context, params acp.RequestPermissionRequest) (acp.RequestPermissionResponse, error) {
 	// Auto-approve all permissions for Phase 1
 	return acp.RequestPermissionResponse{
@@ -123,6 +193,45 @@ func (c *acpClient) WaitForTerminalExit(ctx context.Context, params acp.WaitForT
 	return acp.WaitForTerminalExitResponse{}, nil
 }
 
+// AvailableCommands returns the slash commands most recently advertised
+// by the agent.
+func (a *ACPAgentIO) AvailableCommands() []st.AgentCommand {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return slices.Clone(a.commands)
+}
+
+// SetOnThought sets a callback that will be called for each chunk of the
+// agent's reasoning.
+func (a *ACPAgentIO) SetOnThought(fn func(chunk string)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onThought = fn
+}
+
+// SetOnPlan sets a callback that will be called each time the agent
+// reports its plan.
+func (a *ACPAgentIO) SetOnPlan(fn func(plan []st.PlanEntry)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onPlan = fn
+}
+
+// SetOnDiff sets a callback that will be called for each file diff in a
+// tool call.
+func (a *ACPAgentIO) SetOnDiff(fn func(diff st.FileDiff)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onDiff = fn
+}
+
+// StopReason returns why the agent stopped responding to the last prompt.
+func (a *ACPAgentIO) StopReason() st.StopReason {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.stopReason
+}
+
 // SetOnChunk sets a callback that will be called for each streaming chunk.
 func (a *ACPAgentIO) SetOnChunk(fn func(chunk string)) {
 	a.mu.Lock()
@@ -176,16 +285,7 @@ func NewWithPipes(ctx context.Context, toAgent io.Writer, fromAgent io.Reader, l
 
 // Write sends a message to the agent via ACP prompt
 func (a *ACPAgentIO) Write(data []byte) (int, error) {
-	text := string(data)
-
-	// Strip bracketed paste escape sequences if present
-	text = strings.TrimPrefix(text, "\x1b[200~")
-	text = strings.TrimSuffix(text, "\x1b[201~")
-
-	// Strip terminal hack sequences (x\b pattern used for Claude Code compatibility)
-	text = strings.TrimPrefix(text, "x\b")
-
-	text = strings.TrimSpace(text)
+	text := transport.PromptText(data)
 
 	// Don't send empty prompts
 	if text == "" {
@@ -196,11 +296,12 @@ func (a *ACPAgentIO) Write(data []byte) (int, error) {
 	// Clear previous response
 	a.mu.Lock()
 	a.response.Reset()
+	a.stopReason = ""
 	a.mu.Unlock()
 
 	a.logger.Debug("Sending prompt",
 		"sessionId", a.sessionID,
-		"text", text,
+		logctx.Content("text", text),
 		"textLen", len(text),
 		"rawDataLen", len(data))
 
@@ -220,6 +321,9 @@ func (a *ACPAgentIO) Write(data []byte) (int, error) {
 	}
 
 	a.logger.Debug("Prompt completed", "stopReason", resp.StopReason)
+	a.mu.Lock()
+	a.stopReason = st.StopReason(resp.StopReason)
+	a.mu.Unlock()
 
 	return len(data), nil
 }
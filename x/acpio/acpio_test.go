@@ -6,11 +6,13 @@ import (
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	acp "github.com/coder/acp-go-sdk"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	st "github.com/coder/agentapi/lib/screentracker"
 	"github.com/coder/agentapi/x/acpio"
 )
 
@@ -249,3 +251,91 @@ func Test_ACPAgentIO_ResetsResponseBetweenWrites(t *testing.T) {
 	// Response should be reset, not accumulated
 	assert.Equal(t, "response 2", agentIO.ReadScreen())
 }
+
+func Test_ACPAgentIO_AvailableCommands(t *testing.T) {
+	agent := &testAgent{
+		onPrompt: func(ctx context.Context, conn *acp.AgentSideConnection, p acp.PromptRequest) (acp.PromptResponse, error) {
+			_ = conn.SessionUpdate(ctx, acp.SessionNotification{
+				SessionId: p.SessionId,
+				Update: acp.SessionUpdate{AvailableCommandsUpdate: &acp.SessionAvailableCommandsUpdate{
+					SessionUpdate: "available_commands_update",
+					AvailableCommands: []acp.AvailableCommand{
+						{Name: "init", Description: "Create an AGENTS.md file"},
+						{
+							Name:        "web",
+							Description: "Search the web",
+							Input: &acp.AvailableCommandInput{
+								UnstructuredCommandInput: &acp.AvailableCommandUnstructuredCommandInput{Hint: "query"},
+							},
+						},
+					},
+				}},
+			})
+			return acp.PromptResponse{StopReason: acp.StopReasonEndTurn}, nil
+		},
+	}
+	agentIO := newTestPair(t, agent)
+	assert.Empty(t, agentIO.AvailableCommands())
+
+	_, err := agentIO.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(agentIO.AvailableCommands()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []st.AgentCommand{
+		{Name: "init", Description: "Create an AGENTS.md file"},
+		{Name: "web", Description: "Search the web", InputHint: "query"},
+	}, agentIO.AvailableCommands())
+}
+
+func Test_ACPAgentIO_ReportsDiffs(t *testing.T) {
+	agent := &testAgent{
+		onPrompt: func(ctx context.Context, conn *acp.AgentSideConnection, p acp.PromptRequest) (acp.PromptResponse, error) {
+			_ = conn.SessionUpdate(ctx, acp.SessionNotification{
+				SessionId: p.SessionId,
+				Update: acp.StartToolCall(
+					"call_1",
+					"Editing main.go",
+					acp.WithStartKind(acp.ToolKindEdit),
+					acp.WithStartContent([]acp.ToolCallContent{acp.ToolDiffContent("main.go", "package main\n\nfunc main() {}\n", "package main\n")}),
+				),
+			})
+			return acp.PromptResponse{StopReason: acp.StopReasonEndTurn}, nil
+		},
+	}
+	agentIO := newTestPair(t, agent)
+
+	diffs := make(chan st.FileDiff, 1)
+	agentIO.SetOnDiff(func(diff st.FileDiff) { diffs <- diff })
+
+	_, err := agentIO.Write([]byte("add a main function"))
+	require.NoError(t, err)
+
+	select {
+	case diff := <-diffs:
+		assert.Equal(t, st.FileDiff{
+			ToolCallId: "call_1",
+			Path:       "main.go",
+			Hunks: []st.DiffHunk{
+				{OldStart: 1, OldLines: 1, NewStart: 1, NewLines: 3, Lines: []string{" package main", "+", "+func main() {}"}},
+			},
+		}, diff)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for diff")
+	}
+}
+
+func Test_ACPAgentIO_StopReason(t *testing.T) {
+	agent := &testAgent{
+		onPrompt: func(ctx context.Context, conn *acp.AgentSideConnection, p acp.PromptRequest) (acp.PromptResponse, error) {
+			return acp.PromptResponse{StopReason: acp.StopReasonMaxTokens}, nil
+		},
+	}
+	agentIO := newTestPair(t, agent)
+	assert.Empty(t, agentIO.StopReason())
+
+	_, err := agentIO.Write([]byte("write a novel"))
+	require.NoError(t, err)
+	assert.Equal(t, st.StopReasonMaxTokens, agentIO.StopReason())
+}
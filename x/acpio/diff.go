@@ -0,0 +1,168 @@
+package acpio
+
+import (
+	"strings"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+)
+
+// diffContextLines is the number of unchanged lines kept around each
+// change, as in `diff -u`.
+const diffContextLines = 3
+
+// maxDiffCells bounds the size of the LCS table. Larger changes are shown
+// as the old lines being replaced by the new ones.
+const maxDiffCells = 4_000_000
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// newFileDiff converts an ACP diff content block, which carries the whole
+// old and new file, into unified diff hunks. oldText is nil for new files.
+func newFileDiff(toolCallId string, path string, oldText *string, newText string) st.FileDiff {
+	var oldLines []string
+	if oldText != nil {
+		oldLines = splitLines(*oldText)
+	}
+	return st.FileDiff{
+		ToolCallId: toolCallId,
+		Path:       path,
+		NewFile:    oldText == nil,
+		Hunks:      diffHunks(diffLines(oldLines, splitLines(newText))),
+	}
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines returns the edit script turning a into b.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, diffMiddle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// diffMiddle diffs the lines between the common prefix and suffix using
+// the longest common subsequence.
+func diffMiddle(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:].
+	width := len(b) + 1
+	lcs := make([]int, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+			} else {
+				lcs[i*width+j] = max(lcs[(i+1)*width+j], lcs[i*width+j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[(i+1)*width+j] >= lcs[i*width+j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
+
+// diffHunks groups an edit script into hunks with diffContextLines lines
+// of context. Changes separated by at most twice that share a hunk.
+func diffHunks(ops []diffOp) []st.DiffHunk {
+	// Line positions in the old and new file before each op.
+	oldPos := make([]int, len(ops)+1)
+	newPos := make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldPos[i+1], newPos[i+1] = oldPos[i], newPos[i]
+		if op.kind != '+' {
+			oldPos[i+1]++
+		}
+		if op.kind != '-' {
+			newPos[i+1]++
+		}
+	}
+
+	var hunks []st.DiffHunk
+	for i := 0; i < len(ops); i++ {
+		if ops[i].kind == ' ' {
+			continue
+		}
+		start := max(0, i-diffContextLines)
+		lastChange := i
+		for j := i + 1; j < len(ops) && j-lastChange <= 2*diffContextLines+1; j++ {
+			if ops[j].kind != ' ' {
+				lastChange = j
+			}
+		}
+		end := min(len(ops), lastChange+1+diffContextLines)
+
+		hunk := st.DiffHunk{
+			OldStart: oldPos[start] + 1,
+			OldLines: oldPos[end] - oldPos[start],
+			NewStart: newPos[start] + 1,
+			NewLines: newPos[end] - newPos[start],
+			Lines:    make([]string, 0, end-start),
+		}
+		// Like diff -u, empty ranges start at the line before.
+		if hunk.OldLines == 0 {
+			hunk.OldStart--
+		}
+		if hunk.NewLines == 0 {
+			hunk.NewStart--
+		}
+		for _, op := range ops[start:end] {
+			hunk.Lines = append(hunk.Lines, string(op.kind)+op.line)
+		}
+		hunks = append(hunks, hunk)
+		i = end - 1
+	}
+	return hunks
+}
@@ -0,0 +1,70 @@
+package acpio
+
+import (
+	"strings"
+	"testing"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/stretchr/testify/assert"
+)
+
+func ptr(s string) *string { return &s }
+
+func numberedLines(n int) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		sb.WriteString(strings.Repeat("x", i))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func TestNewFileDiff(t *testing.T) {
+	t.Run("new file", func(t *testing.T) {
+		diff := newFileDiff("call_1", "/tmp/a.txt", nil, "one\ntwo\n")
+		assert.Equal(t, st.FileDiff{
+			ToolCallId: "call_1",
+			Path:       "/tmp/a.txt",
+			NewFile:    true,
+			Hunks: []st.DiffHunk{
+				{OldStart: 0, OldLines: 0, NewStart: 1, NewLines: 2, Lines: []string{"+one", "+two"}},
+			},
+		}, diff)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		diff := newFileDiff("call_1", "/tmp/a.txt", ptr("same\n"), "same\n")
+		assert.False(t, diff.NewFile)
+		assert.Empty(t, diff.Hunks)
+	})
+
+	t.Run("single change with context", func(t *testing.T) {
+		diff := newFileDiff("call_1", "a.txt", ptr("a\nb\nc\nd\ne\nf\ng\nh\n"), "a\nb\nc\nd\nE\nf\ng\nh\n")
+		assert.Equal(t, []st.DiffHunk{
+			{OldStart: 2, OldLines: 7, NewStart: 2, NewLines: 7, Lines: []string{" b", " c", " d", "-e", "+E", " f", " g", " h"}},
+		}, diff.Hunks)
+	})
+
+	t.Run("distant changes are split into hunks", func(t *testing.T) {
+		old := numberedLines(20)
+		new := strings.Replace(strings.Replace(old, "xx\n", "changed\n", 1), strings.Repeat("x", 19)+"\n", "", 1)
+		diff := newFileDiff("call_1", "a.txt", &old, new)
+		assert.Equal(t, []st.DiffHunk{
+			{OldStart: 1, OldLines: 5, NewStart: 1, NewLines: 5, Lines: []string{" x", "-xx", "+changed", " xxx", " xxxx", " xxxxx"}},
+			{OldStart: 16, OldLines: 5, NewStart: 16, NewLines: 4, Lines: []string{
+				" " + strings.Repeat("x", 16),
+				" " + strings.Repeat("x", 17),
+				" " + strings.Repeat("x", 18),
+				"-" + strings.Repeat("x", 19),
+				" " + strings.Repeat("x", 20),
+			}},
+		}, diff.Hunks)
+	})
+
+	t.Run("nearby changes share a hunk", func(t *testing.T) {
+		diff := newFileDiff("call_1", "a.txt", ptr("1\n2\n3\n4\n5\n6\n7\n"), "0\n1\n2\n3\n4\n5\n6\n")
+		assert.Equal(t, []st.DiffHunk{
+			{OldStart: 1, OldLines: 7, NewStart: 1, NewLines: 7, Lines: []string{"+0", " 1", " 2", " 3", " 4", " 5", " 6", "-7"}},
+		}, diff.Hunks)
+	})
+}
@@ -0,0 +1,60 @@
+package acpio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/coder/agentapi/lib/logctx"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/transport"
+	"golang.org/x/xerrors"
+)
+
+// TransportName is the name the ACP transport is registered under.
+const TransportName = "acp"
+
+func init() {
+	transport.Register(TransportName, acpTransport{})
+}
+
+// acpTransport talks to the agent over the Agent Client Protocol on the
+// agent's stdin and stdout.
+type acpTransport struct{}
+
+func (acpTransport) Start(ctx context.Context, cfg transport.StartConfig) (*transport.Agent, error) {
+	logger := logctx.From(ctx)
+
+	logger.Info(fmt.Sprintf("Running (ACP): %s %s", cfg.Program, strings.Join(cfg.ProgramArgs, " ")))
+
+	proc, err := transport.StartPipeProcess(ctx, cfg.Clock, cfg.Env, cfg.Program, cfg.ProgramArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	agentIO, err := NewWithPipes(ctx, proc.Stdin, proc.Stdout, logger, os.Getwd)
+	if err != nil {
+		proc.Kill()
+		return nil, xerrors.Errorf("failed to initialize ACP connection: %w", err)
+	}
+
+	return &transport.Agent{
+		IO: agentIO,
+		Wait: func() error {
+			if err := proc.Wait(); err != nil {
+				return xerrors.Errorf("ACP process exited: %w", err)
+			}
+			return nil
+		},
+		Close: proc.Close,
+	}, nil
+}
+
+func (acpTransport) NewConversation(ctx context.Context, cfg transport.ConversationConfig) (st.Conversation, error) {
+	agentIO, ok := cfg.AgentIO.(ChunkableAgentIO)
+	if !ok {
+		return nil, xerrors.Errorf("ACP transport requires an AgentIO that supports chunk callbacks, got %T", cfg.AgentIO)
+	}
+	return NewACPConversation(ctx, agentIO, cfg.Logger, cfg.InitialPrompt, cfg.Emitter, cfg.Clock), nil
+}
@@ -0,0 +1,160 @@
+// Package aiderio drives Aider through its scripting interface: every
+// message runs `aider --message` without the TUI, so responses are plain
+// text and failures surface as exit codes.
+package aiderio
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/coder/agentapi/lib/transport"
+	"github.com/coder/agentapi/x/acpio"
+	"golang.org/x/xerrors"
+)
+
+// Compile-time assertion that AiderAgentIO can back an ACPConversation.
+var _ acpio.ChunkableAgentIO = (*AiderAgentIO)(nil)
+
+// scriptingArgs disable everything interactive.
+var scriptingArgs = []string{"--yes-always", "--no-pretty", "--no-fancy-input", "--no-check-update", "--no-show-release-notes"}
+
+// Lines Aider prints before the response, e.g. "Aider v0.86.1".
+var bannerPrefixes = []string{
+	"Aider v",
+	"Main model:",
+	"Model:",
+	"Weak model:",
+	"Editor model:",
+	"Git repo:",
+	"Repo-map:",
+	"Use /help",
+	"Restored previous conversation history.",
+}
+
+type Config struct {
+	Program string
+	Args    []string
+	Logger  *slog.Logger
+}
+
+// AiderAgentIO implements screentracker.AgentIO by running Aider once per
+// message. Aider keeps the conversation in its chat history file, which
+// is restored for every message after the first.
+type AiderAgentIO struct {
+	ctx      context.Context
+	cfg      Config
+	mu       sync.RWMutex
+	response strings.Builder
+	onChunk  func(chunk string)
+	messages int
+}
+
+func New(ctx context.Context, cfg Config) *AiderAgentIO {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &AiderAgentIO{ctx: ctx, cfg: cfg}
+}
+
+// SetOnChunk sets a callback that will be called for each line of output.
+func (a *AiderAgentIO) SetOnChunk(fn func(chunk string)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onChunk = fn
+}
+
+// ReadScreen returns the latest agent response.
+func (a *AiderAgentIO) ReadScreen() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.response.String()
+}
+
+// Write runs Aider with the message and blocks until it exits.
+func (a *AiderAgentIO) Write(data []byte) (int, error) {
+	text := transport.PromptText(data)
+
+	// Don't send empty prompts
+	if text == "" {
+		return len(data), nil
+	}
+
+	a.mu.Lock()
+	a.response.Reset()
+	args := append(append([]string{}, a.cfg.Args...), scriptingArgs...)
+	if a.messages > 0 {
+		args = append(args, "--restore-chat-history")
+	}
+	a.messages++
+	a.mu.Unlock()
+	args = append(args, "--message", text)
+
+	cmd := exec.CommandContext(a.ctx, a.cfg.Program, args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, xerrors.Errorf("failed to create stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, xerrors.Errorf("failed to start aider: %w", err)
+	}
+	a.readOutput(stdout)
+
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return 0, xerrors.Errorf("aider exited with code %d: %s", exitErr.ExitCode(), strings.TrimSpace(a.ReadScreen()))
+		}
+		return 0, xerrors.Errorf("failed to run aider: %w", err)
+	}
+	return len(data), nil
+}
+
+func (a *AiderAgentIO) readOutput(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	inBanner := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inBanner {
+			if isBannerLine(line) {
+				continue
+			}
+			inBanner = false
+		}
+		a.mu.Lock()
+		chunk := line
+		if a.response.Len() > 0 {
+			chunk = "\n" + line
+		}
+		a.response.WriteString(chunk)
+		onChunk := a.onChunk
+		a.mu.Unlock()
+		if onChunk != nil {
+			onChunk(chunk)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		a.cfg.Logger.Warn("Failed to read aider output", "error", err)
+	}
+}
+
+func isBannerLine(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.Trim(line, "─-") == "" {
+		return true
+	}
+	for _, prefix := range bannerPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package aiderio_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/coder/agentapi/x/aiderio"
+)
+
+// fakeAider prints a banner, reports whether the chat history was
+// restored, and echoes the message. The message "fail" exits with code 3.
+const fakeAider = `#!/bin/sh
+echo "Aider v0.0.1"
+echo "Model: fake"
+echo ""
+for arg in "$@"; do
+  [ "$arg" = "--restore-chat-history" ] && echo "(restored)"
+  last="$arg"
+done
+echo "reply: $last"
+[ "$last" = "fail" ] && exit 3
+exit 0
+`
+
+func TestAiderAgentIO(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "aider")
+	require.NoError(t, os.WriteFile(program, []byte(fakeAider), 0o755))
+
+	agentIO := aiderio.New(context.Background(), aiderio.Config{Program: program, Args: []string{"--model", "fake"}})
+	var chunks []string
+	agentIO.SetOnChunk(func(chunk string) { chunks = append(chunks, chunk) })
+
+	_, err := agentIO.Write([]byte("\x1b[200~hello\x1b[201~"))
+	require.NoError(t, err)
+	assert.Equal(t, "reply: hello", agentIO.ReadScreen())
+	assert.Equal(t, []string{"reply: hello"}, chunks)
+
+	// Later messages restore the chat history.
+	_, err = agentIO.Write([]byte("again"))
+	require.NoError(t, err)
+	assert.Equal(t, "(restored)\nreply: again", agentIO.ReadScreen())
+
+	_, err = agentIO.Write([]byte("fail"))
+	require.ErrorContains(t, err, "aider exited with code 3")
+}
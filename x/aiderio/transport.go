@@ -0,0 +1,55 @@
+package aiderio
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/coder/agentapi/lib/logctx"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/transport"
+	"github.com/coder/agentapi/x/acpio"
+	"golang.org/x/xerrors"
+)
+
+// TransportName is the name the Aider scripting transport is registered
+// under.
+const TransportName = "aider-headless"
+
+func init() {
+	transport.Register(TransportName, aiderTransport{})
+}
+
+type aiderTransport struct{}
+
+func (aiderTransport) Start(ctx context.Context, cfg transport.StartConfig) (*transport.Agent, error) {
+	logger := logctx.From(ctx)
+	logger.Info(fmt.Sprintf("Using (scripting mode): %s %s", cfg.Program, strings.Join(cfg.ProgramArgs, " ")))
+
+	// No process runs between messages, so the agent "exits" only when
+	// it's closed.
+	ctx, cancel := context.WithCancel(ctx)
+	return &transport.Agent{
+		IO: New(ctx, Config{Program: cfg.Program, Args: cfg.ProgramArgs, Logger: logger}),
+		Wait: func() error {
+			<-ctx.Done()
+			return nil
+		},
+		Close: func(_ *slog.Logger, _ time.Duration) error {
+			cancel()
+			return nil
+		},
+	}, nil
+}
+
+func (aiderTransport) NewConversation(ctx context.Context, cfg transport.ConversationConfig) (st.Conversation, error) {
+	agentIO, ok := cfg.AgentIO.(acpio.ChunkableAgentIO)
+	if !ok && cfg.AgentIO != nil {
+		return nil, xerrors.Errorf("%s transport requires an AgentIO that supports chunk callbacks, got %T", TransportName, cfg.AgentIO)
+	}
+	// Each message blocks until aider exits, so the conversation works
+	// the same way as with ACP.
+	return acpio.NewACPConversation(ctx, agentIO, cfg.Logger, cfg.InitialPrompt, cfg.Emitter, cfg.Clock), nil
+}
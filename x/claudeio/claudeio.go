@@ -0,0 +1,268 @@
+// Package claudeio drives Claude Code in headless mode
+// (-p --input-format stream-json --output-format stream-json), which
+// reports the conversation as structured JSON events instead of a TUI.
+package claudeio
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/transport"
+	"github.com/coder/agentapi/x/acpio"
+	"golang.org/x/xerrors"
+)
+
+// Compile-time assertions that ClaudeAgentIO can back an ACPConversation.
+var (
+	_ acpio.ChunkableAgentIO = (*ClaudeAgentIO)(nil)
+	_ acpio.ToolCallReporter = (*ClaudeAgentIO)(nil)
+)
+
+// streamEvent is the subset of the stream-json output format agentapi
+// understands. Unknown event types are ignored.
+type streamEvent struct {
+	Type    string `json:"type"`
+	Subtype string `json:"subtype"`
+	Message struct {
+		Content []contentBlock `json:"content"`
+	} `json:"message"`
+	Result  string `json:"result"`
+	IsError bool   `json:"is_error"`
+	// MCPServers are reported by the init event.
+	MCPServers []struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+	} `json:"mcp_servers"`
+}
+
+type contentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	Id        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseId string          `json:"tool_use_id"`
+	IsError   bool            `json:"is_error"`
+}
+
+type userInput struct {
+	Type    string `json:"type"`
+	Message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"message"`
+}
+
+// ClaudeAgentIO implements screentracker.AgentIO on top of Claude Code's
+// stream-json protocol. Like ACPAgentIO, Write blocks until the agent has
+// finished responding.
+type ClaudeAgentIO struct {
+	ctx        context.Context
+	toAgent    io.Writer
+	logger     *slog.Logger
+	mu         sync.RWMutex
+	response   strings.Builder
+	onChunk    func(chunk string)
+	onToolCall func(toolCall st.ToolCall)
+	toolNames  map[string]string // tool_use id -> tool name
+	results    chan streamEvent
+	done       chan struct{}
+	readErr    error
+	// mcpServers are the MCP servers of the init event, nil until it
+	// arrives.
+	mcpServers []transport.MCPServerStatus
+}
+
+// NewWithPipes creates a ClaudeAgentIO that writes user messages to
+// toAgent and reads stream-json events from fromAgent.
+func NewWithPipes(ctx context.Context, toAgent io.Writer, fromAgent io.Reader, logger *slog.Logger) *ClaudeAgentIO {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	a := &ClaudeAgentIO{
+		ctx:       ctx,
+		toAgent:   toAgent,
+		logger:    logger,
+		toolNames: make(map[string]string),
+		results:   make(chan streamEvent, 1),
+		done:      make(chan struct{}),
+	}
+	go a.readLoop(fromAgent)
+	return a
+}
+
+// SetOnChunk sets a callback that will be called for each streaming chunk.
+func (a *ClaudeAgentIO) SetOnChunk(fn func(chunk string)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onChunk = fn
+}
+
+// SetOnToolCall sets a callback that will be called when a tool call
+// starts or finishes.
+func (a *ClaudeAgentIO) SetOnToolCall(fn func(toolCall st.ToolCall)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onToolCall = fn
+}
+
+// MCPServers returns the MCP servers Claude Code reported loading when it
+// initialized, and false until it did.
+func (a *ClaudeAgentIO) MCPServers() ([]transport.MCPServerStatus, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.mcpServers, a.mcpServers != nil
+}
+
+// Write sends a user message to the agent and blocks until it responds.
+func (a *ClaudeAgentIO) Write(data []byte) (int, error) {
+	text := transport.PromptText(data)
+
+	// Don't send empty prompts
+	if text == "" {
+		return len(data), nil
+	}
+
+	a.mu.Lock()
+	a.response.Reset()
+	a.mu.Unlock()
+	// Discard the result of a message whose Write returned before it
+	// arrived, so it isn't taken for the result of this one.
+	select {
+	case <-a.results:
+	default:
+	}
+
+	input := userInput{Type: "user"}
+	input.Message.Role = "user"
+	input.Message.Content = text
+	line, err := json.Marshal(input)
+	if err != nil {
+		return 0, xerrors.Errorf("failed to marshal message: %w", err)
+	}
+	if _, err := a.toAgent.Write(append(line, '\n')); err != nil {
+		return 0, xerrors.Errorf("failed to write message: %w", err)
+	}
+
+	select {
+	case result := <-a.results:
+		if result.IsError {
+			return 0, xerrors.Errorf("agent returned an error (%s): %s", result.Subtype, result.Result)
+		}
+		return len(data), nil
+	case <-a.done:
+		return 0, xerrors.Errorf("agent output closed: %w", a.readErr)
+	case <-a.ctx.Done():
+		return 0, a.ctx.Err()
+	}
+}
+
+// ReadScreen returns the latest agent response.
+func (a *ClaudeAgentIO) ReadScreen() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.response.String()
+}
+
+func (a *ClaudeAgentIO) readLoop(fromAgent io.Reader) {
+	defer close(a.done)
+	scanner := bufio.NewScanner(fromAgent)
+	// Tool results can be large.
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event streamEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			a.logger.Warn("Ignoring malformed stream-json line", "error", err)
+			continue
+		}
+		a.handleEvent(event)
+	}
+	a.readErr = scanner.Err()
+	if a.readErr == nil {
+		a.readErr = io.EOF
+	}
+}
+
+func (a *ClaudeAgentIO) handleEvent(event streamEvent) {
+	switch event.Type {
+	case "system":
+		if event.Subtype != "init" {
+			return
+		}
+		servers := make([]transport.MCPServerStatus, 0, len(event.MCPServers))
+		for _, server := range event.MCPServers {
+			servers = append(servers, transport.MCPServerStatus{Name: server.Name, Status: server.Status})
+		}
+		a.mu.Lock()
+		a.mcpServers = servers
+		a.mu.Unlock()
+	case "assistant":
+		for _, block := range event.Message.Content {
+			switch block.Type {
+			case "text":
+				a.appendResponse(block.Text)
+			case "tool_use":
+				a.mu.Lock()
+				a.toolNames[block.Id] = block.Name
+				a.mu.Unlock()
+				a.appendResponse(fmt.Sprintf("\n[Tool: %s]\n", block.Name))
+				a.reportToolCall(st.ToolCall{Id: block.Id, Name: block.Name, Input: string(block.Input), Status: st.ToolCallStatusStarted})
+			}
+		}
+	case "user":
+		// Tool results are reported back to the model as user messages.
+		for _, block := range event.Message.Content {
+			if block.Type != "tool_result" {
+				continue
+			}
+			a.mu.Lock()
+			name := a.toolNames[block.ToolUseId]
+			delete(a.toolNames, block.ToolUseId)
+			a.mu.Unlock()
+			status := st.ToolCallStatusCompleted
+			if block.IsError {
+				status = st.ToolCallStatusFailed
+			}
+			a.reportToolCall(st.ToolCall{Id: block.ToolUseId, Name: name, Status: status})
+		}
+	case "result":
+		select {
+		case a.results <- event:
+		default:
+			a.logger.Warn("Dropping result event received without a pending message")
+		}
+	}
+}
+
+func (a *ClaudeAgentIO) appendResponse(text string) {
+	if text == "" {
+		return
+	}
+	a.mu.Lock()
+	// Separate consecutive text blocks like the TUI does.
+	if a.response.Len() > 0 && !strings.HasSuffix(a.response.String(), "\n") && !strings.HasPrefix(text, "\n") {
+		text = "\n\n" + text
+	}
+	a.response.WriteString(text)
+	onChunk := a.onChunk
+	a.mu.Unlock()
+	if onChunk != nil {
+		onChunk(text)
+	}
+}
+
+func (a *ClaudeAgentIO) reportToolCall(toolCall st.ToolCall) {
+	a.mu.RLock()
+	onToolCall := a.onToolCall
+	a.mu.RUnlock()
+	if onToolCall != nil {
+		onToolCall(toolCall)
+	}
+}
@@ -0,0 +1,132 @@
+package claudeio
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/transport"
+)
+
+// fakeClaude reads user messages and answers each one with the given
+// stream-json lines. It stops when its input is closed.
+func fakeClaude(t *testing.T, replies ...string) *ClaudeAgentIO {
+	t.Helper()
+	toAgentR, toAgentW := io.Pipe()
+	fromAgentR, fromAgentW := io.Pipe()
+	t.Cleanup(func() {
+		_ = toAgentW.Close()
+		_ = fromAgentW.Close()
+	})
+	go func() {
+		scanner := bufio.NewScanner(toAgentR)
+		for scanner.Scan() {
+			var input userInput
+			if err := json.Unmarshal(scanner.Bytes(), &input); err != nil || input.Type != "user" {
+				_ = fromAgentW.CloseWithError(fmt.Errorf("unexpected input %q", scanner.Text()))
+				return
+			}
+			for _, reply := range replies {
+				if _, err := fmt.Fprintln(fromAgentW, reply); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return NewWithPipes(context.Background(), toAgentW, fromAgentR, nil)
+}
+
+func TestClaudeAgentIO(t *testing.T) {
+	t.Run("text and tool calls", func(t *testing.T) {
+		agentIO := fakeClaude(t,
+			`{"type":"system","subtype":"init","session_id":"abc"}`,
+			`{"type":"assistant","message":{"content":[{"type":"text","text":"Let me look."},{"type":"tool_use","id":"t1","name":"Bash","input":{"command":"ls"}}]}}`,
+			`{"type":"user","message":{"content":[{"type":"tool_result","tool_use_id":"t1","content":"main.go"}]}}`,
+			`{"type":"assistant","message":{"content":[{"type":"text","text":"There is one file."}]}}`,
+			`{"type":"result","subtype":"success","is_error":false,"result":"There is one file."}`,
+		)
+		var mu sync.Mutex
+		var chunks []string
+		var toolCalls []st.ToolCall
+		agentIO.SetOnChunk(func(chunk string) {
+			mu.Lock()
+			defer mu.Unlock()
+			chunks = append(chunks, chunk)
+		})
+		agentIO.SetOnToolCall(func(toolCall st.ToolCall) {
+			mu.Lock()
+			defer mu.Unlock()
+			toolCalls = append(toolCalls, toolCall)
+		})
+
+		_, err := agentIO.Write([]byte("\x1b[200~what's here?\x1b[201~"))
+		require.NoError(t, err)
+		assert.Equal(t, "Let me look.\n[Tool: Bash]\nThere is one file.", agentIO.ReadScreen())
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Len(t, chunks, 3)
+		assert.Equal(t, []st.ToolCall{
+			{Id: "t1", Name: "Bash", Input: `{"command":"ls"}`, Status: st.ToolCallStatusStarted},
+			{Id: "t1", Name: "Bash", Status: st.ToolCallStatusCompleted},
+		}, toolCalls)
+	})
+
+	t.Run("error result", func(t *testing.T) {
+		agentIO := fakeClaude(t, `{"type":"result","subtype":"error_max_turns","is_error":true,"result":"too many turns"}`)
+		_, err := agentIO.Write([]byte("hello"))
+		require.ErrorContains(t, err, "too many turns")
+	})
+
+	t.Run("stale result is discarded", func(t *testing.T) {
+		agentIO := fakeClaude(t, `{"type":"result","subtype":"success","is_error":false,"result":"hello"}`)
+		// The result of a message whose Write was cancelled.
+		agentIO.handleEvent(streamEvent{Type: "result", Subtype: "error_during_execution", IsError: true, Result: "interrupted"})
+		_, err := agentIO.Write([]byte("hello"))
+		require.NoError(t, err)
+	})
+
+	t.Run("mcp servers", func(t *testing.T) {
+		agentIO := fakeClaude(t,
+			`{"type":"system","subtype":"init","session_id":"abc","mcp_servers":[{"name":"docs","status":"connected"},{"name":"search","status":"failed"}]}`,
+			`{"type":"result","subtype":"success","is_error":false,"result":"hi"}`,
+		)
+		_, ok := agentIO.MCPServers()
+		assert.False(t, ok)
+
+		_, err := agentIO.Write([]byte("hello"))
+		require.NoError(t, err)
+		servers, ok := agentIO.MCPServers()
+		require.True(t, ok)
+		assert.Equal(t, []transport.MCPServerStatus{{Name: "docs", Status: "connected"}, {Name: "search", Status: "failed"}}, servers)
+	})
+
+	t.Run("empty input is not sent", func(t *testing.T) {
+		agentIO := fakeClaude(t)
+		n, err := agentIO.Write([]byte("x\b"))
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+	})
+}
+
+func TestSupportsHeadless(t *testing.T) {
+	dir := t.TempDir()
+	script := func(name, help string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\necho '"+help+"'\n"), 0o755))
+		return path
+	}
+	assert.True(t, supportsHeadless(context.Background(), script("new", "--output-format <format> (text, json, stream-json)")))
+	assert.False(t, supportsHeadless(context.Background(), script("old", "--print")))
+	assert.False(t, supportsHeadless(context.Background(), filepath.Join(dir, "missing")))
+}
@@ -0,0 +1,82 @@
+package claudeio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/coder/agentapi/lib/logctx"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/termexec"
+	"github.com/coder/agentapi/lib/transport"
+	"github.com/coder/agentapi/x/acpio"
+	"golang.org/x/xerrors"
+)
+
+// TransportName is the name the Claude Code headless transport is
+// registered under.
+const TransportName = "claude-headless"
+
+// headlessArgs switch Claude Code to headless mode with a JSON event
+// stream on both stdin and stdout.
+var headlessArgs = []string{"-p", "--input-format", "stream-json", "--output-format", "stream-json", "--verbose"}
+
+func init() {
+	transport.Register(TransportName, claudeTransport{})
+}
+
+type claudeTransport struct{}
+
+// supportsHeadless reports whether program's help output mentions the
+// stream-json format, i.e. whether it's a Claude Code version with
+// headless streaming.
+func supportsHeadless(ctx context.Context, program string) bool {
+	return transport.HelpMentions(ctx, program, "stream-json")
+}
+
+func (claudeTransport) Start(ctx context.Context, cfg transport.StartConfig) (*transport.Agent, error) {
+	logger := logctx.From(ctx)
+
+	if !supportsHeadless(ctx, cfg.Program) {
+		logger.Warn(fmt.Sprintf("%s doesn't support headless stream-json mode, falling back to the %s transport", cfg.Program, termexec.TransportName))
+		fallback, err := transport.Lookup(termexec.TransportName)
+		if err != nil {
+			return nil, err
+		}
+		agent, err := fallback.Start(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		agent.Transport = termexec.TransportName
+		return agent, nil
+	}
+
+	args := append(append([]string{}, cfg.ProgramArgs...), headlessArgs...)
+	logger.Info(fmt.Sprintf("Running (headless): %s %s", cfg.Program, strings.Join(args, " ")))
+
+	proc, err := transport.StartPipeProcess(ctx, cfg.Clock, cfg.Env, cfg.Program, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transport.Agent{
+		IO: NewWithPipes(ctx, proc.Stdin, proc.Stdout, logger),
+		Wait: func() error {
+			if err := proc.Wait(); err != nil {
+				return xerrors.Errorf("headless process exited: %w", err)
+			}
+			return nil
+		},
+		Close: proc.Close,
+	}, nil
+}
+
+func (claudeTransport) NewConversation(ctx context.Context, cfg transport.ConversationConfig) (st.Conversation, error) {
+	agentIO, ok := cfg.AgentIO.(acpio.ChunkableAgentIO)
+	if !ok && cfg.AgentIO != nil {
+		return nil, xerrors.Errorf("%s transport requires an AgentIO that supports chunk callbacks, got %T", TransportName, cfg.AgentIO)
+	}
+	// Writes block until the agent's result event, so the conversation
+	// works the same way as with ACP.
+	return acpio.NewACPConversation(ctx, agentIO, cfg.Logger, cfg.InitialPrompt, cfg.Emitter, cfg.Clock), nil
+}
@@ -0,0 +1,106 @@
+package httpio
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheSize is the number of responses kept when only a TTL is
+// configured.
+const defaultCacheSize = 100
+
+// responseCache keeps recent upstream responses so that a request repeated
+// within the TTL, for example by an orchestrator retrying, is answered
+// without calling the upstream again. Entries are evicted least recently
+// used first once the cache is full.
+type responseCache struct {
+	ttl     time.Duration
+	size    int
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first
+}
+
+type cacheEntry struct {
+	key      string
+	response string
+	expires  time.Time
+}
+
+func newResponseCache(ttl time.Duration, size int) *responseCache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &responseCache{
+		ttl:     ttl,
+		size:    size,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// cacheKey hashes the model, system prompt, earlier turns and prompt.
+// Whitespace in prompts is normalized so that prompts differing only in
+// formatting share an entry. A prompt repeated right after it was answered
+// is taken for a retry and keyed on the turns before the first attempt, so
+// it hits the cache even though that attempt was added to the history.
+func cacheKey(model string, systemPrompt string, history []chatMessage, prompt string) string {
+	prompt = normalizePrompt(prompt)
+	if n := len(history); n >= 2 && history[n-2].Role == roleUser && normalizePrompt(history[n-2].Content) == prompt {
+		history = history[:n-2]
+	}
+	data, _ := json.Marshal(struct {
+		Model        string        `json:"model"`
+		SystemPrompt string        `json:"system_prompt"`
+		History      []chatMessage `json:"history,omitempty"`
+		Prompt       string        `json:"prompt"`
+	}{model, systemPrompt, history, prompt})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizePrompt(prompt string) string {
+	return strings.Join(strings.Fields(prompt), " ")
+}
+
+func (c *responseCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return "", false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.response, true
+}
+
+func (c *responseCache) put(key string, response string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.response, entry.expires = response, expires
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, response: response, expires: expires})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,37 @@
+package httpio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCache(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := newResponseCache(time.Minute, 2)
+	cache.now = func() time.Time { return now }
+
+	assert.Equal(t, cacheKey("m", "", nil, "hello  world"), cacheKey("m", "", nil, "hello world\n"))
+	assert.NotEqual(t, cacheKey("m", "", nil, "hello"), cacheKey("other", "", nil, "hello"))
+	// The same prompt later in the conversation may need another answer.
+	history := []chatMessage{{Role: roleUser, Content: "list the files"}, {Role: roleAssistant, Content: "a.go"}}
+	assert.NotEqual(t, cacheKey("m", "", nil, "and now?"), cacheKey("m", "", history, "and now?"))
+	// Unless it repeats the last turn, as retries do.
+	assert.Equal(t, cacheKey("m", "", nil, "list the files"), cacheKey("m", "", history, "list the files"))
+
+	cache.put("a", "A")
+	cache.put("b", "B")
+	_, _ = cache.get("a")
+	// "b" is the least recently used entry.
+	cache.put("c", "C")
+	_, ok := cache.get("b")
+	assert.False(t, ok)
+	response, ok := cache.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "A", response)
+
+	now = now.Add(time.Minute)
+	_, ok = cache.get("c")
+	assert.False(t, ok)
+}
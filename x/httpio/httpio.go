@@ -0,0 +1,275 @@
+// Package httpio adapts agents that already expose an OpenAI-style chat
+// completions endpoint to agentapi's AgentIO.
+package httpio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/transport"
+	"github.com/coder/agentapi/x/acpio"
+	"golang.org/x/xerrors"
+)
+
+// Compile-time assertions that HTTPAgentIO can back an ACPConversation.
+var (
+	_ acpio.ChunkableAgentIO = (*HTTPAgentIO)(nil)
+	_ acpio.HistoryRestorer  = (*HTTPAgentIO)(nil)
+)
+
+const (
+	roleSystem    = "system"
+	roleUser      = "user"
+	roleAssistant = "assistant"
+)
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model    string        `json:"model,omitempty"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+}
+
+// chatResponse covers both streamed chunks (delta) and complete
+// responses (message).
+type chatResponse struct {
+	Choices []struct {
+		Delta   chatMessage `json:"delta"`
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+type Config struct {
+	// Endpoint is the URL of the chat completions endpoint, e.g.
+	// https://api.openai.com/v1/chat/completions.
+	Endpoint string
+	Model    string
+	// APIKey is sent as a bearer token if set.
+	APIKey       string
+	SystemPrompt string
+	// Stream requests a server-sent event stream instead of a single
+	// JSON response.
+	Stream bool
+	// CacheTTL enables caching responses by model, conversation and
+	// prompt for this long, e.g. for orchestrators retrying requests.
+	// CacheSize caps the number of cached responses.
+	CacheTTL  time.Duration
+	CacheSize int
+	// Shadow duplicates requests to a second model for comparison.
+	Shadow     ShadowConfig
+	HTTPClient *http.Client
+	Logger     *slog.Logger
+}
+
+// HTTPAgentIO implements screentracker.AgentIO on top of a chat
+// completions endpoint. Like ACPAgentIO, Write blocks until the agent has
+// responded. The upstream is stateless, so the full history is sent with
+// every request.
+type HTTPAgentIO struct {
+	ctx      context.Context
+	cfg      Config
+	mu       sync.RWMutex
+	history  []chatMessage
+	response strings.Builder
+	onChunk  func(chunk string)
+	cache    *responseCache
+	shadower *shadower
+}
+
+func New(ctx context.Context, cfg Config) *HTTPAgentIO {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	a := &HTTPAgentIO{ctx: ctx, cfg: cfg}
+	if cfg.CacheTTL > 0 {
+		a.cache = newResponseCache(cfg.CacheTTL, cfg.CacheSize)
+	}
+	if cfg.Shadow.Model != "" && cfg.Shadow.Log != nil {
+		a.shadower = newShadower(cfg.Shadow)
+	}
+	return a
+}
+
+// SetOnChunk sets a callback that will be called for each streaming chunk.
+func (a *HTTPAgentIO) SetOnChunk(fn func(chunk string)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onChunk = fn
+}
+
+// RestoreHistory replaces the history sent upstream with messages
+// restored from a state file.
+func (a *HTTPAgentIO) RestoreHistory(messages []st.ConversationMessage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.history = a.history[:0]
+	for _, msg := range messages {
+		role := roleUser
+		if msg.Role == st.ConversationRoleAgent {
+			role = roleAssistant
+		}
+		a.history = append(a.history, chatMessage{Role: role, Content: msg.Message})
+	}
+}
+
+// Write sends a user message to the agent and blocks until it responds.
+func (a *HTTPAgentIO) Write(data []byte) (int, error) {
+	text := transport.PromptText(data)
+
+	// Don't send empty prompts
+	if text == "" {
+		return len(data), nil
+	}
+
+	a.mu.Lock()
+	a.response.Reset()
+	messages := make([]chatMessage, 0, len(a.history)+2)
+	if a.cfg.SystemPrompt != "" {
+		messages = append(messages, chatMessage{Role: roleSystem, Content: a.cfg.SystemPrompt})
+	}
+	messages = append(messages, a.history...)
+	history := messages[len(messages)-len(a.history):]
+	messages = append(messages, chatMessage{Role: roleUser, Content: text})
+	a.mu.Unlock()
+
+	var key string
+	cached := false
+	if a.cache != nil {
+		key = cacheKey(a.cfg.Model, a.cfg.SystemPrompt, history, text)
+		var response string
+		if response, cached = a.cache.get(key); cached {
+			a.cfg.Logger.Debug("Serving chat completion from cache")
+			a.appendResponse(response)
+		}
+	}
+	if !cached {
+		if err := a.complete(messages); err != nil {
+			a.cfg.Logger.Error("Chat completion failed", "error", err)
+			return 0, err
+		}
+		if a.cache != nil {
+			a.cache.put(key, a.ReadScreen())
+		}
+		if a.shadower != nil {
+			a.shadower.shadow(a, messages, text, a.ReadScreen())
+		}
+	}
+
+	a.mu.Lock()
+	a.history = append(a.history,
+		chatMessage{Role: roleUser, Content: text},
+		chatMessage{Role: roleAssistant, Content: a.response.String()},
+	)
+	a.mu.Unlock()
+	return len(data), nil
+}
+
+// ReadScreen returns the latest agent response.
+func (a *HTTPAgentIO) ReadScreen() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.response.String()
+}
+
+func (a *HTTPAgentIO) appendResponse(chunk string) {
+	if chunk == "" {
+		return
+	}
+	a.mu.Lock()
+	a.response.WriteString(chunk)
+	onChunk := a.onChunk
+	a.mu.Unlock()
+	if onChunk != nil {
+		onChunk(chunk)
+	}
+}
+
+func (a *HTTPAgentIO) complete(messages []chatMessage) error {
+	return a.request(a.cfg.Endpoint, a.cfg.APIKey, chatRequest{
+		Model:    a.cfg.Model,
+		Messages: messages,
+		Stream:   a.cfg.Stream,
+	}, a.appendResponse)
+}
+
+// request sends a chat completion request and passes the response to
+// onChunk, in pieces if the upstream streams it.
+func (a *HTTPAgentIO) request(endpoint string, apiKey string, chat chatRequest, onChunk func(chunk string)) error {
+	body, err := json.Marshal(chat)
+	if err != nil {
+		return xerrors.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(a.ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return xerrors.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := a.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return xerrors.Errorf("failed to send request: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return xerrors.Errorf("upstream returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	// Servers may ignore the stream flag, so go by the content type.
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		var parsed chatResponse
+		if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+			return xerrors.Errorf("failed to decode response: %w", err)
+		}
+		if len(parsed.Choices) == 0 {
+			return xerrors.Errorf("response contains no choices")
+		}
+		onChunk(parsed.Choices[0].Message.Content)
+		return nil
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return nil
+		}
+		var chunk chatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return xerrors.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if len(chunk.Choices) > 0 {
+			onChunk(chunk.Choices[0].Delta.Content)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return xerrors.Errorf("failed to read stream: %w", err)
+	}
+	return nil
+}
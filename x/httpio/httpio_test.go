@@ -0,0 +1,217 @@
+package httpio_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/x/httpio"
+)
+
+type chatRequest struct {
+	Model    string `json:"model"`
+	Stream   bool   `json:"stream"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+}
+
+// newUpstream starts a fake chat completions endpoint that replies with
+// "echo: <last message>" and records the requests it receives.
+func newUpstream(t *testing.T) (*httptest.Server, func() []chatRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []chatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+
+		reply := "echo: " + req.Messages[len(req.Messages)-1].Content
+		if !req.Stream {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":%q}}]}`, reply)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, word := range []string{reply[:5], reply[5:]} {
+			_, _ = fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", word)
+		}
+		_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []chatRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]chatRequest(nil), requests...)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestHTTPAgentIO(t *testing.T) {
+	for _, stream := range []bool{true, false} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			upstream, requests := newUpstream(t)
+			agentIO := httpio.New(context.Background(), httpio.Config{
+				Endpoint:     upstream.URL,
+				Model:        "test-model",
+				APIKey:       "secret",
+				SystemPrompt: "be brief",
+				Stream:       stream,
+			})
+			var chunks []string
+			agentIO.SetOnChunk(func(chunk string) { chunks = append(chunks, chunk) })
+
+			// Terminal-specific sequences are stripped.
+			_, err := agentIO.Write([]byte("\x1b[200~hello\x1b[201~"))
+			require.NoError(t, err)
+			assert.Equal(t, "echo: hello", agentIO.ReadScreen())
+			if stream {
+				assert.Equal(t, []string{"echo:", " hello"}, chunks)
+			}
+
+			_, err = agentIO.Write([]byte("again"))
+			require.NoError(t, err)
+			assert.Equal(t, "echo: again", agentIO.ReadScreen())
+
+			reqs := requests()
+			require.Len(t, reqs, 2)
+			assert.Equal(t, "test-model", reqs[1].Model)
+			assert.Equal(t, stream, reqs[1].Stream)
+			// The second request carries the whole history.
+			var roles []string
+			for _, msg := range reqs[1].Messages {
+				roles = append(roles, msg.Role)
+			}
+			assert.Equal(t, []string{"system", "user", "assistant", "user"}, roles)
+		})
+	}
+
+	t.Run("upstream error", func(t *testing.T) {
+		upstream, _ := newUpstream(t)
+		agentIO := httpio.New(context.Background(), httpio.Config{Endpoint: upstream.URL})
+		_, err := agentIO.Write([]byte("hello"))
+		require.ErrorContains(t, err, "401")
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		upstream, requests := newUpstream(t)
+		agentIO := httpio.New(context.Background(), httpio.Config{
+			Endpoint: upstream.URL,
+			APIKey:   "secret",
+			Model:    "test-model",
+			CacheTTL: time.Minute,
+		})
+		_, err := agentIO.Write([]byte("hello"))
+		require.NoError(t, err)
+		// A retry differing only in whitespace is served from the cache.
+		_, err = agentIO.Write([]byte("  hello\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "echo: hello", agentIO.ReadScreen())
+		require.Len(t, requests(), 1)
+
+		_, err = agentIO.Write([]byte("other"))
+		require.NoError(t, err)
+		require.Len(t, requests(), 2)
+
+		// The same prompt later in the conversation isn't a retry.
+		_, err = agentIO.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.Len(t, requests(), 3)
+	})
+
+	t.Run("shadow", func(t *testing.T) {
+		upstream, requests := newUpstream(t)
+		log := &syncBuffer{}
+		agentIO := httpio.New(context.Background(), httpio.Config{
+			Endpoint: upstream.URL,
+			APIKey:   "secret",
+			Model:    "primary",
+			Stream:   true,
+			Shadow:   httpio.ShadowConfig{Model: "candidate", Percent: 100, Log: log},
+		})
+		_, err := agentIO.Write([]byte("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, "echo: hello", agentIO.ReadScreen())
+
+		require.Eventually(t, func() bool {
+			return strings.Count(log.String(), "\n") == 1
+		}, 5*time.Second, 10*time.Millisecond)
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(log.String()), &record))
+		assert.Equal(t, "hello", record["prompt"])
+		assert.Equal(t, "primary", record["model"])
+		assert.Equal(t, "echo: hello", record["response"])
+		assert.Equal(t, "candidate", record["shadow_model"])
+		assert.Equal(t, "echo: hello", record["shadow_response"])
+		assert.NotContains(t, record, "shadow_error")
+
+		reqs := requests()
+		require.Len(t, reqs, 2)
+		assert.Equal(t, "candidate", reqs[1].Model)
+		assert.False(t, reqs[1].Stream)
+
+		// Nothing is shadowed at 0%.
+		agentIO = httpio.New(context.Background(), httpio.Config{
+			Endpoint: upstream.URL,
+			APIKey:   "secret",
+			Shadow:   httpio.ShadowConfig{Model: "candidate", Percent: 0, Log: log},
+		})
+		_, err = agentIO.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.Len(t, requests(), 3)
+	})
+
+	t.Run("restore history", func(t *testing.T) {
+		upstream, requests := newUpstream(t)
+		agentIO := httpio.New(context.Background(), httpio.Config{Endpoint: upstream.URL, APIKey: "secret"})
+		agentIO.RestoreHistory([]st.ConversationMessage{
+			{Role: st.ConversationRoleUser, Message: "first"},
+			{Role: st.ConversationRoleAgent, Message: "echo: first"},
+		})
+		_, err := agentIO.Write([]byte("second"))
+		require.NoError(t, err)
+		reqs := requests()
+		require.Len(t, reqs, 1)
+		require.Len(t, reqs[0].Messages, 3)
+		assert.Equal(t, "assistant", reqs[0].Messages[1].Role)
+	})
+}
@@ -0,0 +1,113 @@
+package httpio
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// ShadowConfig duplicates a share of the requests to a second model, to
+// compare its responses with the primary model's offline. Shadow responses
+// are recorded but never returned to the client.
+type ShadowConfig struct {
+	// Model is the model shadow requests are sent to. Shadowing is disabled
+	// when empty.
+	Model string
+	// Endpoint defaults to the primary endpoint, and APIKey to the primary
+	// API key.
+	Endpoint string
+	APIKey   string
+	// Percent is the share of requests shadowed, from 0 to 100.
+	Percent float64
+	// Log receives a shadowRecord as a JSON line for each shadowed request.
+	Log io.Writer
+}
+
+// shadowRecord pairs the primary and shadow responses to a request.
+type shadowRecord struct {
+	Time           time.Time `json:"time"`
+	Prompt         string    `json:"prompt"`
+	Model          string    `json:"model"`
+	Response       string    `json:"response"`
+	ShadowModel    string    `json:"shadow_model"`
+	ShadowResponse string    `json:"shadow_response,omitempty"`
+	ShadowError    string    `json:"shadow_error,omitempty"`
+	// ShadowLatency is how long the shadow request took, in milliseconds.
+	ShadowLatency int64 `json:"shadow_latency_ms"`
+}
+
+// shadower sends shadow requests in the background.
+type shadower struct {
+	cfg ShadowConfig
+	// sample reports whether the next request is shadowed.
+	sample func() bool
+
+	mu sync.Mutex // serializes writes to cfg.Log
+	wg sync.WaitGroup
+}
+
+func newShadower(cfg ShadowConfig) *shadower {
+	return &shadower{
+		cfg: cfg,
+		sample: func() bool {
+			return rand.Float64()*100 < cfg.Percent
+		},
+	}
+}
+
+// shadow sends messages, which ended with prompt and got response from
+// the primary model, to the shadow model.
+func (s *shadower) shadow(a *HTTPAgentIO, messages []chatMessage, prompt string, response string) {
+	if !s.sample() {
+		return
+	}
+	endpoint, apiKey := s.cfg.Endpoint, s.cfg.APIKey
+	if endpoint == "" {
+		endpoint = a.cfg.Endpoint
+	}
+	if apiKey == "" {
+		apiKey = a.cfg.APIKey
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		record := shadowRecord{
+			Time:        time.Now(),
+			Prompt:      prompt,
+			Model:       a.cfg.Model,
+			Response:    response,
+			ShadowModel: s.cfg.Model,
+		}
+		var shadowResponse strings.Builder
+		start := time.Now()
+		err := a.request(endpoint, apiKey, chatRequest{Model: s.cfg.Model, Messages: messages}, func(chunk string) {
+			shadowResponse.WriteString(chunk)
+		})
+		record.ShadowLatency = time.Since(start).Milliseconds()
+		record.ShadowResponse = shadowResponse.String()
+		if err != nil {
+			record.ShadowError = err.Error()
+		}
+		if err := s.write(record); err != nil {
+			a.cfg.Logger.Warn("Failed to record shadow response", "error", err)
+		}
+	}()
+}
+
+func (s *shadower) write(record shadowRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return xerrors.Errorf("failed to marshal shadow record: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.cfg.Log.Write(append(line, '\n')); err != nil {
+		return xerrors.Errorf("failed to write shadow record: %w", err)
+	}
+	return nil
+}
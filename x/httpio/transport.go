@@ -0,0 +1,163 @@
+package httpio
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/coder/agentapi/lib/logctx"
+	st "github.com/coder/agentapi/lib/screentracker"
+	"github.com/coder/agentapi/lib/transport"
+	"github.com/coder/agentapi/x/acpio"
+	"golang.org/x/xerrors"
+)
+
+// TransportName is the name the HTTP transport is registered under.
+const TransportName = "http"
+
+// Options accepted through transport.StartConfig.Options.
+const (
+	OptionModel        = "model"
+	OptionAPIKeyEnv    = "api-key-env"
+	OptionSystemPrompt = "system-prompt"
+	OptionStream       = "stream"
+	OptionCacheTTL     = "cache-ttl"
+	OptionCacheSize    = "cache-size"
+	// Shadow options, see ShadowConfig.
+	OptionShadowModel     = "shadow-model"
+	OptionShadowEndpoint  = "shadow-endpoint"
+	OptionShadowAPIKeyEnv = "shadow-api-key-env"
+	OptionShadowPercent   = "shadow-percent"
+	OptionShadowLog       = "shadow-log"
+)
+
+func init() {
+	transport.Register(TransportName, httpTransport{})
+}
+
+// httpTransport talks to an upstream chat completions endpoint given as
+// the agent program, e.g.
+//
+//	agentapi server --transport http --transport-opt model=gpt-4o -- https://api.openai.com/v1/chat/completions
+type httpTransport struct{}
+
+func (httpTransport) Start(ctx context.Context, cfg transport.StartConfig) (*transport.Agent, error) {
+	logger := logctx.From(ctx)
+
+	u, err := url.Parse(cfg.Program)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, xerrors.Errorf("the %s transport expects the agent to be an http(s) URL, got %q", TransportName, cfg.Program)
+	}
+	if len(cfg.ProgramArgs) > 0 {
+		return nil, xerrors.Errorf("the %s transport doesn't accept agent arguments, use --transport-opt instead", TransportName)
+	}
+
+	agentCfg := Config{
+		Endpoint:     cfg.Program,
+		Model:        cfg.Options[OptionModel],
+		SystemPrompt: cfg.Options[OptionSystemPrompt],
+		Stream:       true,
+		Logger:       logger,
+		Shadow: ShadowConfig{
+			Model:    cfg.Options[OptionShadowModel],
+			Endpoint: cfg.Options[OptionShadowEndpoint],
+			Percent:  100,
+		},
+	}
+	for key, value := range cfg.Options {
+		switch key {
+		case OptionModel, OptionSystemPrompt, OptionShadowModel, OptionShadowEndpoint, OptionShadowLog:
+		case OptionAPIKeyEnv:
+			agentCfg.APIKey = os.Getenv(value)
+			if agentCfg.APIKey == "" {
+				return nil, xerrors.Errorf("environment variable %s is empty", value)
+			}
+		case OptionStream:
+			stream, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid value for %s: %w", OptionStream, err)
+			}
+			agentCfg.Stream = stream
+		case OptionCacheTTL:
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl < 0 {
+				return nil, xerrors.Errorf("invalid value for %s: %q", OptionCacheTTL, value)
+			}
+			agentCfg.CacheTTL = ttl
+		case OptionCacheSize:
+			size, err := strconv.Atoi(value)
+			if err != nil || size <= 0 {
+				return nil, xerrors.Errorf("invalid value for %s: %q", OptionCacheSize, value)
+			}
+			agentCfg.CacheSize = size
+		case OptionShadowAPIKeyEnv:
+			agentCfg.Shadow.APIKey = os.Getenv(value)
+			if agentCfg.Shadow.APIKey == "" {
+				return nil, xerrors.Errorf("environment variable %s is empty", value)
+			}
+		case OptionShadowPercent:
+			percent, err := strconv.ParseFloat(value, 64)
+			if err != nil || percent < 0 || percent > 100 {
+				return nil, xerrors.Errorf("invalid value for %s: %q, expected a number from 0 to 100", OptionShadowPercent, value)
+			}
+			agentCfg.Shadow.Percent = percent
+		default:
+			return nil, xerrors.Errorf("unknown option %q for the %s transport", key, TransportName)
+		}
+	}
+
+	var shadowLog *os.File
+	if agentCfg.Shadow.Model != "" {
+		path := cfg.Options[OptionShadowLog]
+		if path == "" {
+			return nil, xerrors.Errorf("%s requires %s", OptionShadowModel, OptionShadowLog)
+		}
+		if shadowLog, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+			return nil, xerrors.Errorf("failed to open shadow log: %w", err)
+		}
+		agentCfg.Shadow.Log = shadowLog
+		logger.Info("Shadowing requests", "model", agentCfg.Shadow.Model, "percent", agentCfg.Shadow.Percent, "log", path)
+	}
+
+	logger.Info("Using HTTP agent", "endpoint", cfg.Program, "model", agentCfg.Model)
+
+	ctx, cancel := context.WithCancel(ctx)
+	agentIO := New(ctx, agentCfg)
+	return &transport.Agent{
+		IO: agentIO,
+		Wait: func() error {
+			<-ctx.Done()
+			return nil
+		},
+		Close: func(_ *slog.Logger, _ time.Duration) error {
+			cancel()
+			if shadowLog != nil {
+				agentIO.shadower.wg.Wait()
+				return shadowLog.Close()
+			}
+			return nil
+		},
+	}, nil
+}
+
+func (httpTransport) NewConversation(ctx context.Context, cfg transport.ConversationConfig) (st.Conversation, error) {
+	agentIO, ok := cfg.AgentIO.(acpio.ChunkableAgentIO)
+	if !ok && cfg.AgentIO != nil {
+		return nil, xerrors.Errorf("HTTP transport requires an AgentIO that supports chunk callbacks, got %T", cfg.AgentIO)
+	}
+	// The conversation semantics are the same as ACP: a write blocks until
+	// the response is complete and the response is streamed in chunks.
+	conversation := acpio.NewACPConversation(ctx, agentIO, cfg.Logger, cfg.InitialPrompt, cfg.Emitter, cfg.Clock)
+	if cfg.StatePersistenceConfig.StateFile != "" {
+		// Like the PTY transport, a broken state file isn't fatal.
+		if err := conversation.EnableStatePersistence(cfg.StatePersistenceConfig); err != nil {
+			cfg.Logger.Error("Failed to load state", "error", err)
+			cfg.Emitter.EmitError(fmt.Sprintf("Failed to restore previous session: %v", err), st.ErrorLevelWarning)
+		}
+	}
+	return conversation, nil
+}